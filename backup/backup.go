@@ -4,10 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"os/signal"
 	path "path/filepath"
+	"regexp"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
@@ -23,6 +28,34 @@ import (
 	"github.com/spf13/cobra"
 )
 
+/*
+ * When --max-retries is set, a failed backup restarts gpbackup with the same
+ * arguments and passes the number of the retry through this variable.
+ */
+const RETRY_ATTEMPT_ENV = "GPBACKUP_RETRY_ATTEMPT"
+
+/*
+ * Failures that would repeat on every attempt, such as missing privileges or
+ * a database, role, or filtered object that does not exist.  An object that
+ * is dropped while the backup runs fails with a lowercase "relation ... does
+ * not exist" from the server instead, which a retry can get past.
+ */
+var nonRetryableErrors = []*regexp.Regexp{
+	regexp.MustCompile(`permission denied`),
+	regexp.MustCompile(`must be owner`),
+	regexp.MustCompile(`password authentication failed`),
+	regexp.MustCompile(`(?:database|role) ".*" does not exist`),
+	regexp.MustCompile(`(?:Schema|Table) .* does not exist`),
+	regexp.MustCompile(`Column .* given in --\S+ does not exist`),
+	regexp.MustCompile(`Invalid --\S+`),
+	regexp.MustCompile(`Cannot filter on`),
+	regexp.MustCompile(`does not match`),
+	regexp.MustCompile(`already completed successfully`),
+	regexp.MustCompile(`not in the backup set`),
+	regexp.MustCompile(`--include-table-query`),
+	regexp.MustCompile(`--changed-since`),
+}
+
 // This function handles setup that can be done before parsing flags.
 func DoInit(cmd *cobra.Command) {
 	CleanupGroup = &sync.WaitGroup{}
@@ -32,45 +65,89 @@ func DoInit(cmd *cobra.Command) {
 	_ = cmd.MarkFlagRequired(options.DBNAME)
 	utils.InitializeSignalHandler(DoCleanup, "backup process", &wasTerminated)
 	objectCounts = make(map[string]int)
+	retryAttempt, _ = strconv.Atoi(operating.System.Getenv(RETRY_ATTEMPT_ENV))
 }
 
 func DoFlagValidation(cmd *cobra.Command) {
 	validateFlagCombinations(cmd.Flags())
 	validateFlagValues()
+	flagsValidated = true
 }
 
 // This function handles setup that must be done after parsing flags.
 func DoSetup() {
 	SetLoggerVerbosity()
+	runStats = history.NewRunStats()
 	gplog.Verbose("Backup Command: %s", os.Args)
 	gplog.Info("gpbackup version = %s", GetVersion())
+	if retryAttempt > 0 {
+		gplog.Info("Backup retry %d of %d", retryAttempt, MustGetFlagInt(options.MAX_RETRIES))
+	}
 
+	utils.SetErrorPhase(utils.ERROR_CLASS_CONNECTION)
 	utils.CheckGpexpandRunning(utils.BackupPreventedByGpexpandMessage)
 	timestamp := history.CurrentTimestamp()
+	explicitTimestamp := MustGetFlagString(options.TIMESTAMP) != ""
+	if explicitTimestamp {
+		timestamp = MustGetFlagString(options.TIMESTAMP)
+	}
 	createBackupLockFile(timestamp)
 	initializeConnectionPool(timestamp)
 	gplog.Info("Greenplum Database Version = %s", connectionPool.Version.VersionString)
 
 	gplog.Info("Starting backup of database %s", MustGetFlagString(options.DBNAME))
+	utils.SetErrorPhase(utils.ERROR_CLASS_USAGE)
+	if !resolveIncludeTableQuery() {
+		nothingToBackUp = true
+		return
+	}
 	opts, err := options.NewOptions(cmdFlags)
 	gplog.FatalOnError(err)
+	err = opts.ExpandRelationRegexes(connectionPool, cmdFlags)
+	gplog.FatalOnError(err)
 
+	removeSystemRelationsFromFilters(opts)
+	warnIncludedSystemSchemas()
 	validateFilterLists(opts)
 
 	err = opts.ExpandIncludesForPartitions(connectionPool, cmdFlags)
 	gplog.FatalOnError(err)
 
+	if !MustGetFlagBool(options.NO_AUTO_SEQUENCES) {
+		addedSequences, err := opts.ExpandIncludesForSequences(connectionPool, cmdFlags)
+		gplog.FatalOnError(err)
+		for _, sequence := range addedSequences {
+			gplog.Info("Including sequence %s, which is used by an included table", sequence)
+		}
+	}
+	if !MustGetFlagBool(options.DATA_ONLY) {
+		ValidateTypeDependencies()
+	}
+
+	utils.SetErrorPhase(utils.ERROR_CLASS_CONNECTION)
 	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
 	globalCluster = cluster.NewCluster(segConfig)
 	segPrefix := filepath.GetSegPrefix(connectionPool)
-	globalFPInfo = filepath.NewFilePathInfo(globalCluster, MustGetFlagString(options.BACKUP_DIR), timestamp, segPrefix)
+	fpInfo := filepath.NewFilePathInfo(globalCluster, MustGetFlagString(options.BACKUP_DIR), timestamp, segPrefix)
+	attempt := retryAttempt + 1
+	if explicitTimestamp {
+		// The failed attempt's files are only removed once we know no successful backup would be overwritten
+		attempt = CheckBackupTimestamp(fpInfo) + 1
+	}
+	globalFPInfo = fpInfo
+	if explicitTimestamp {
+		if attempt > 1 {
+			gplog.Info("Removing files of %d earlier attempt(s) of backup with timestamp %s", attempt-1, timestamp)
+		}
+		removeBackupDirectories()
+	}
 	if MustGetFlagBool(options.METADATA_ONLY) {
-		_, err = globalCluster.ExecuteLocalCommand(fmt.Sprintf("mkdir -p %s", globalFPInfo.GetDirForContent(-1)))
-		gplog.FatalOnError(err)
+		output, err := globalCluster.ExecuteLocalCommand(utils.PrepareDirectoryCommand(globalFPInfo.GetDirForContent(-1), MustGetFlagString(options.BACKUP_DIR_MODE)))
+		gplog.FatalOnError(err, fmt.Sprintf("Unable to prepare backup directory %s: %s", globalFPInfo.GetDirForContent(-1), strings.TrimSpace(output)))
 	} else {
 		createBackupDirectoriesOnAllHosts()
 	}
-	globalTOC = &toc.TOC{}
+	globalTOC = &toc.TOC{FormatVersion: toc.FORMAT_VERSION}
 	globalTOC.InitializeMetadataEntryMap()
 	utils.InitializePipeThroughParameters(!MustGetFlagBool(options.NO_COMPRESSION), MustGetFlagInt(options.COMPRESSION_LEVEL))
 	getQuotedRoleNames(connectionPool)
@@ -79,24 +156,41 @@ func DoSetup() {
 
 	if pluginConfigFlag != "" {
 		pluginConfig, err = utils.ReadPluginConfig(pluginConfigFlag)
-		gplog.FatalOnError(err)
+		utils.FatalWithClass(utils.ERROR_CLASS_USAGE, err)
 		configFilename := path.Base(pluginConfig.ConfigPath)
 		configDirname := path.Dir(pluginConfig.ConfigPath)
-		pluginConfig.ConfigPath = path.Join(configDirname, timestamp+"_"+configFilename)
+		pluginConfig.SetConfigPath(path.Join(configDirname, timestamp+"_"+configFilename))
 		_ = cmdFlags.Set(options.PLUGIN_CONFIG, pluginConfig.ConfigPath)
 		gplog.Debug("Plugin config path: %s", pluginConfig.ConfigPath)
 	}
 
 	initializeBackupReport(*opts)
+	backupReport.Attempt = attempt
 
 	if pluginConfigFlag != "" {
 		backupReport.PluginVersion = pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
-		pluginConfig.CopyPluginConfigToAllHosts(globalCluster)
-		pluginConfig.SetupPluginForBackup(globalCluster, globalFPInfo)
+		if pluginConfig.DataPlugin != nil {
+			backupReport.DataPluginVersion = pluginConfig.DataPlugin.CheckPluginExistsOnAllHosts(globalCluster)
+		}
+		for _, plugin := range pluginConfig.AllPlugins() {
+			plugin.CopyPluginConfigToAllHosts(globalCluster)
+			plugin.SetupPluginForBackup(globalCluster, globalFPInfo)
+			if explicitTimestamp && attempt > 1 {
+				err = plugin.DeleteBackup(timestamp)
+				if err != nil {
+					gplog.Warn("%v", err)
+				}
+			}
+		}
 	}
 }
 
 func DoBackup() {
+	if nothingToBackUp {
+		return
+	}
+	utils.SetErrorPhase(utils.ERROR_CLASS_METADATA)
+	utils.SetLowMemoryMode(MustGetFlagBool(options.LOW_MEMORY))
 	gplog.Info("Backup Timestamp = %s", globalFPInfo.Timestamp)
 	gplog.Info("Backup Database = %s", connectionPool.DBName)
 	gplog.Verbose("Backup Parameters: {%s}", strings.ReplaceAll(backupReport.BackupParamsString, "\n", ", "))
@@ -115,6 +209,13 @@ func DoBackup() {
 			pluginConfig.MustRestoreFile(targetBackupFPInfo.GetTOCFilePath())
 			pluginConfig.MustRestoreFile(targetBackupFPInfo.GetPluginConfigPath())
 		}
+	} else if changedSince := MustGetFlagString(options.CHANGED_SINCE); changedSince != "" {
+		backupReport.ChangedSince = GetChangedSinceBackupTimestamp(changedSince)
+		targetBackupFPInfo = filepath.NewFilePathInfo(globalCluster, globalFPInfo.UserSpecifiedBackupDir,
+			backupReport.ChangedSince, globalFPInfo.UserSpecifiedSegPrefix)
+		if pluginConfigFlag != "" {
+			pluginConfig.MustRestoreFile(targetBackupFPInfo.GetTOCFilePath())
+		}
 	}
 
 	gplog.Info("Gathering table state information")
@@ -122,12 +223,15 @@ func DoBackup() {
 	if !(MustGetFlagBool(options.METADATA_ONLY) || MustGetFlagBool(options.DATA_ONLY)) {
 		backupIncrementalMetadata()
 	}
+	dataTables = ExcludeLeafPartitionData(dataTables)
 	CheckTablesContainData(dataTables)
+	dataTables = CheckTablePrivileges(dataTables)
+	dataTables = SetColumnSubstitutions(dataTables)
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	gplog.Info("Metadata will be written to %s", metadataFilename)
 	metadataFile := utils.NewFileWithByteCountFromFile(metadataFilename)
 
-	backupSessionGUC(metadataFile)
+	backupSessionGUC(metadataFile, metadataTables)
 	if !MustGetFlagBool(options.DATA_ONLY) {
 		isFullBackup := len(MustGetFlagStringArray(options.INCLUDE_RELATION)) == 0
 		if isFullBackup && !MustGetFlagBool(options.WITHOUT_GLOBALS) {
@@ -136,7 +240,14 @@ func DoBackup() {
 
 		isFilteredBackup := !isFullBackup
 		backupPredata(metadataFile, metadataTables, isFilteredBackup)
-		backupPostdata(metadataFile)
+		backupPostdata(metadataFile, metadataTables)
+	}
+	if utils.IsLowMemoryMode() {
+		ReleaseMetadataDefinitions(dataTables)
+		if !MustGetFlagBool(options.WITH_STATS) {
+			metadataTables = nil
+		}
+		utils.EndMemoryStage("releasing table definitions")
 	}
 
 	/*
@@ -154,13 +265,17 @@ func DoBackup() {
 			targetBackupTOC := toc.NewTOC(targetBackupFPInfo.GetTOCFilePath())
 			targetBackupRestorePlan = history.ReadConfigFile(targetBackupFPInfo.GetConfigFilePath()).RestorePlan
 			backupSetTables = FilterTablesForIncremental(targetBackupTOC, globalTOC, dataTables)
+		} else if backupReport.HeuristicIncremental {
+			gplog.Info("Backing up data only for tables that probably changed since backup with timestamp = %s", backupReport.ChangedSince)
+			backupSetTables = FilterTablesChangedSince(readChangedSinceTOC(targetBackupFPInfo), globalTOC, dataTables)
+			gplog.Info("%d of %d tables probably changed", len(backupSetTables), len(dataTables))
 		}
 
 		backupReport.RestorePlan = PopulateRestorePlan(backupSetTables, targetBackupRestorePlan, dataTables)
 		backupData(backupSetTables)
 	}
 	if MustGetFlagBool(options.WITH_STATS) {
-		backupStatistics(metadataTables)
+		backupStatistics(GetStatisticsTables(metadataTables, dataTables))
 	}
 
 	globalTOC.WriteToFileAndMakeReadOnly(globalFPInfo.GetTOCFilePath())
@@ -185,6 +300,7 @@ func DoBackup() {
 }
 
 func backupGlobals(metadataFile *utils.FileWithByteCount) {
+	defer runStats.RecordPhase("globals", time.Now())
 	gplog.Info("Writing global database metadata")
 
 	backupResourceQueues(metadataFile)
@@ -203,6 +319,7 @@ func backupPredata(metadataFile *utils.FileWithByteCount, tables []Table, tableO
 	if wasTerminated {
 		return
 	}
+	defer runStats.RecordPhase("predata", time.Now())
 	gplog.Info("Writing pre-data metadata")
 
 	var protocols []ExternalProtocol
@@ -220,7 +337,7 @@ func backupPredata(metadataFile *utils.FileWithByteCount, tables []Table, tableO
 
 	if !tableOnly {
 		protocols = retrieveProtocols(&objects, metadataMap)
-		backupSchemas(metadataFile, createAlteredPartitionSchemaSet(tables))
+		backupSchemas(metadataFile, createIncludedSchemaSet(tables))
 		backupExtensions(metadataFile)
 		backupCollations(metadataFile)
 		retrieveAndBackupTypes(metadataFile, &objects, metadataMap)
@@ -240,16 +357,22 @@ func backupPredata(metadataFile *utils.FileWithByteCount, tables []Table, tableO
 	retrieveViews(&objects)
 	sequences := retrieveAndBackupSequences(metadataFile, relationMetadata)
 	constraints, conMetadata := retrieveConstraints()
+	ValidateForeignKeyTargets(constraints, tables)
 
 	backupDependentObjects(metadataFile, tables, protocols, metadataMap, constraints, objects, sequences, funcInfoMap, tableOnly)
+	utils.EndMemoryStage("dependent object backup")
 
 	backupConversions(metadataFile)
 	backupConstraints(metadataFile, constraints, conMetadata)
 
 	logCompletionMessage("Pre-data metadata metadata backup")
+	utils.EndMemoryStage("pre-data metadata backup")
 }
 
 func backupData(tables []Table) {
+	defer runStats.RecordPhase("data", time.Now())
+	utils.SetErrorPhase(utils.ERROR_CLASS_DATA)
+	defer utils.SetErrorPhase(utils.ERROR_CLASS_METADATA)
 	if len(tables) == 0 {
 		// No incremental data changes to backup
 		gplog.Info("No tables to backup")
@@ -259,7 +382,7 @@ func backupData(tables []Table) {
 
 	if MustGetFlagBool(options.SINGLE_DATA_FILE) {
 		gplog.Verbose("Initializing pipes and gpbackup_helper on segments for single data file backup")
-		utils.VerifyHelperVersionOnSegments(version, globalCluster)
+		utils.VerifyHelperVersionOnSegments(version, MustGetFlagBool(options.ALLOW_HELPER_SKEW), globalCluster)
 		oidList := make([]string, 0, len(tables))
 		for _, table := range tables {
 			if !table.SkipDataBackup() {
@@ -272,27 +395,46 @@ func backupData(tables []Table) {
 		if MustGetFlagBool(options.NO_COMPRESSION) {
 			compressStr = " --compression-level 0"
 		}
+		pluginConfigPath := ""
+		if pluginConfig != nil {
+			pluginConfigPath = pluginConfig.ForData().ConfigPath
+		}
 		// Do not pass through the --on-error-continue flag because it does not apply to gpbackup
 		utils.StartGpbackupHelpers(globalCluster, globalFPInfo, "--backup-agent",
-			MustGetFlagString(options.PLUGIN_CONFIG), compressStr, false, false, &wasTerminated)
+			pluginConfigPath, compressStr, false, false, version,
+			MustGetFlagBool(options.ALLOW_HELPER_SKEW), &wasTerminated)
 	}
 	gplog.Info("Writing data to file")
 	rowsCopiedMaps := backupDataForAllTables(tables)
 	AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+	backupReport.SkippedDataTables = globalTOC.CountSkippedDataEntries()
+	printDataBackupWarnings(backupReport.SkippedDataTables)
+	if MustGetFlagInt(options.MAX_PER_HOST) > 0 {
+		backupReport.HostConcurrency = utils.CollectHostSlotUsage(globalCluster, globalFPInfo)
+	}
+	if MustGetFlagBool(options.CONTENT_ADDRESSED) && !wasTerminated {
+		AddContentHashesToTOC(globalCluster, globalFPInfo, globalTOC.DataEntries)
+		// A gprestore that does not know about content-addressed files could not find the data
+		globalTOC.ContentAddressed = true
+		globalTOC.RequiredSections = append(globalTOC.RequiredSections, "contentaddressed")
+	}
 	if MustGetFlagBool(options.SINGLE_DATA_FILE) && MustGetFlagString(options.PLUGIN_CONFIG) != "" {
-		pluginConfig.BackupSegmentTOCs(globalCluster, globalFPInfo)
+		pluginConfig.ForData().BackupSegmentTOCs(globalCluster, globalFPInfo)
 	}
 
 	logCompletionMessage("Data backup")
+	utils.EndMemoryStage("data backup")
 }
 
-func backupPostdata(metadataFile *utils.FileWithByteCount) {
+func backupPostdata(metadataFile *utils.FileWithByteCount, tables []Table) {
 	if wasTerminated {
 		return
 	}
+	defer runStats.RecordPhase("postdata", time.Now())
 	gplog.Info("Writing post-data metadata")
 
 	backupIndexes(metadataFile)
+	backupReplicaIdentities(metadataFile, tables)
 	backupRules(metadataFile)
 	backupTriggers(metadataFile)
 	if connectionPool.Version.AtLeast("6") {
@@ -301,14 +443,20 @@ func backupPostdata(metadataFile *utils.FileWithByteCount) {
 			backupEventTriggers(metadataFile)
 		}
 	}
+	if len(MustGetFlagStringArray(options.INCLUDE_SCHEMA)) == 0 &&
+		len(MustGetFlagStringArray(options.INCLUDE_RELATION)) == 0 && backUpAddedExtensionMembers() {
+		backupExtensionMembers(metadataFile)
+	}
 
 	logCompletionMessage("Post-data metadata backup")
+	utils.EndMemoryStage("post-data metadata backup")
 }
 
 func backupStatistics(tables []Table) {
 	if wasTerminated {
 		return
 	}
+	defer runStats.RecordPhase("statistics", time.Now())
 	statisticsFilename := globalFPInfo.GetStatisticsFilePath()
 	gplog.Info("Writing query planner statistics to %s", statisticsFilename)
 	statisticsFile := utils.NewFileWithByteCountFromFile(statisticsFilename)
@@ -320,17 +468,30 @@ func backupStatistics(tables []Table) {
 
 func DoTeardown() {
 	backupFailed := false
+	errStr := ""
 	defer func() {
 		DoCleanup(backupFailed)
 
 		errorCode := gplog.GetErrorCode()
+		if errorCode != 0 && isRetryableFailure(errStr) {
+			deletePartialBackup()
+			os.Exit(retryBackup())
+		}
 		if errorCode == 0 {
-			gplog.Info("Backup completed successfully")
+			if nothingToBackUp {
+				gplog.Info("No backup was taken")
+			} else if retryAttempt > 0 {
+				gplog.Info("Backup completed successfully after %d retries", retryAttempt)
+			} else {
+				gplog.Info("Backup completed successfully")
+			}
 		}
-		os.Exit(errorCode)
+		if logCounter != nil && MustGetFlagBool(options.SUMMARY_ONLY) {
+			printBackupSummary(backupFailed)
+		}
+		os.Exit(utils.ErrorClassForOutcome(backupFailed, wasTerminated).ExitCode())
 	}()
 
-	errStr := ""
 	if err := recover(); err != nil {
 		// gplog's Fatal will cause a panic with error code 2
 		if gplog.GetErrorCode() != 2 {
@@ -352,7 +513,7 @@ func DoTeardown() {
 		backupFailed = true
 		return
 	}
-	if errStr != "" {
+	if errStr != "" && (logCounter == nil || !MustGetFlagBool(options.SUMMARY_ONLY)) {
 		fmt.Println(errStr)
 	}
 	errMsg := report.ParseErrorMessage(errStr)
@@ -368,6 +529,7 @@ func DoTeardown() {
 		}
 		historyFilename := globalFPInfo.GetBackupHistoryFilePath()
 		reportFilename := globalFPInfo.GetBackupReportFilePath()
+		jsonReportFilename := globalFPInfo.GetBackupReportJSONFilePath()
 		configFilename := globalFPInfo.GetConfigFilePath()
 
 		time.Sleep(time.Second) // We sleep for 1 second to ensure multiple backups do not start within the same second.
@@ -376,7 +538,9 @@ func DoTeardown() {
 			if !backupFailed {
 				backupReport.BackupConfig.Status = history.BackupStatusSucceed
 			}
+			backupReport.RetryCount = retryAttempt
 			backupReport.ConstructBackupParamsString()
+			backupReport.Stats = getBackupStats()
 			err := history.WriteBackupHistory(historyFilename, &backupReport.BackupConfig)
 			if err != nil {
 				gplog.Error(fmt.Sprintf("%v", err))
@@ -385,8 +549,14 @@ func DoTeardown() {
 			if backupReport.BackupConfig.EndTime == "" {
 				backupReport.BackupConfig.EndTime = history.CurrentTimestamp()
 			}
+			recordBackupInDatabase()
 			endtime, _ := time.ParseInLocation("20060102150405", backupReport.BackupConfig.EndTime, operating.System.Local)
-			backupReport.WriteBackupReportFile(reportFilename, globalFPInfo.Timestamp, endtime, objectCounts, errMsg)
+			backupReport.Tables = getTableStats()
+			backupReport.Warnings = report.GetWarnings(logCounter)
+			if logCounter != nil {
+				backupReport.Errors = logCounter.Errors
+			}
+			backupReport.WriteBackupReportFile(reportFilename, jsonReportFilename, globalFPInfo.Timestamp, endtime, objectCounts, errMsg)
 			report.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gpbackup", !backupFailed)
 			if pluginConfig != nil {
 				err = pluginConfig.BackupFile(configFilename)
@@ -399,11 +569,18 @@ func DoTeardown() {
 					gplog.Error(fmt.Sprintf("%v", err))
 					return
 				}
+				err = pluginConfig.BackupFile(jsonReportFilename)
+				if err != nil {
+					gplog.Error(fmt.Sprintf("%v", err))
+					return
+				}
 			}
 		}
 		if pluginConfig != nil {
-			pluginConfig.CleanupPluginForBackup(globalCluster, globalFPInfo)
-			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
+			for _, plugin := range pluginConfig.AllPlugins() {
+				plugin.CleanupPluginForBackup(globalCluster, globalFPInfo)
+				plugin.DeletePluginConfigWhenEncrypting(globalCluster)
+			}
 		}
 	}
 }
@@ -430,6 +607,10 @@ func DoCleanup(backupFailed bool) {
 			}
 			utils.CleanUpHelperFilesOnAllHosts(globalCluster, globalFPInfo)
 		}
+		if backupFailed && MustGetFlagInt(options.MAX_PER_HOST) > 0 && globalCluster != nil {
+			// Removes the slot directories left by an interrupted data backup
+			utils.CollectHostSlotUsage(globalCluster, globalFPInfo)
+		}
 	}
 	err := backupLockFile.Unlock()
 	if err != nil && backupLockFile != "" {
@@ -441,6 +622,194 @@ func DoCleanup(backupFailed bool) {
 	}
 }
 
+func printBackupSummary(backupFailed bool) {
+	summary := report.Summary{
+		Timestamp: "-",
+		Database:  MustGetFlagString(options.DBNAME),
+		Type:      getBackupType(),
+		Tables:    objectCounts["Tables"],
+		Bytes:     -1,
+		Duration:  "-",
+		Warnings:  logCounter.Warnings,
+		Errors:    logCounter.Errors,
+		Status:    history.BackupStatusSucceed,
+	}
+	if backupFailed {
+		summary.Status = history.BackupStatusFailed
+	}
+	if globalFPInfo.Timestamp != "" {
+		summary.Timestamp = globalFPInfo.Timestamp
+		_, _, summary.Duration = report.GetDurationInfo(globalFPInfo.Timestamp, operating.System.Now())
+		if backupReport != nil && backupReport.Stats != nil {
+			summary.Bytes = backupReport.Stats.Bytes
+		} else if pluginConfig == nil {
+			summary.Bytes = report.GetBackupDirectorySize(globalCluster, globalFPInfo)
+		}
+	}
+	fmt.Println(summary.String())
+}
+
+func getBackupStats() *history.RunStats {
+	runStats.Tables = objectCounts["Tables"]
+	if logCounter != nil {
+		runStats.Errors = len(logCounter.Errors)
+		runStats.Warnings = logCounter.Warnings
+	}
+	if pluginConfig == nil {
+		runStats.Bytes = report.GetBackupDirectorySize(globalCluster, globalFPInfo)
+	}
+	start, _ := time.ParseInLocation("20060102150405", globalFPInfo.Timestamp, operating.System.Local)
+	runStats.Complete(operating.System.Now().Sub(start))
+	return runStats
+}
+
+func recordBackupInDatabase() {
+	historyDB := MustGetFlagString(options.HISTORY_DB)
+	if historyDB == "" {
+		return
+	}
+	record := history.OperationRecord{
+		Operation:       "backup",
+		BackupTimestamp: globalFPInfo.Timestamp,
+		Database:        backupReport.BackupConfig.DatabaseName,
+		Status:          backupReport.BackupConfig.Status,
+		StartTime:       globalFPInfo.Timestamp,
+		EndTime:         backupReport.BackupConfig.EndTime,
+		Options:         options.ChangedFlagValues(cmdFlags),
+		Stats:           backupReport.Stats,
+	}
+	err := history.RecordOperationInDatabase(historyDB, MustGetFlagString(options.HISTORY_SCHEMA), record)
+	if err != nil {
+		gplog.Warn("Unable to record backup in database %s: %v", historyDB, err)
+	}
+}
+
+func getTableStats() []report.TableStats {
+	tableStats := make([]report.TableStats, 0)
+	if globalTOC == nil {
+		return tableStats
+	}
+	for _, entry := range globalTOC.DataEntries {
+		tableStats = append(tableStats, report.TableStats{Table: utils.MakeFQN(entry.Schema, entry.Name), Rows: entry.RowsCopied})
+	}
+	return tableStats
+}
+
+func getBackupType() string {
+	if MustGetFlagBool(options.INCREMENTAL) {
+		return "incremental"
+	} else if MustGetFlagBool(options.DATA_ONLY) {
+		return "data-only"
+	} else if MustGetFlagBool(options.METADATA_ONLY) {
+		return "metadata-only"
+	}
+	return "full"
+}
+
+func isRetryableFailure(errStr string) bool {
+	if !flagsValidated || wasTerminated || errStr == "" || retryAttempt >= MustGetFlagInt(options.MAX_RETRIES) {
+		return false
+	}
+	for _, nonRetryableError := range nonRetryableErrors {
+		if nonRetryableError.MatchString(errStr) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * Removes the files written by a failed attempt, both locally and through the
+ * plugin, so that only the retried backup remains.  The history file keeps its
+ * record of the failed attempt.
+ */
+func deletePartialBackup() {
+	defer func() {
+		if err := recover(); err != nil {
+			gplog.Warn("Encountered error while removing partial backup: %v", err)
+		}
+	}()
+	if globalFPInfo.Timestamp == "" {
+		return
+	}
+	gplog.Info("Removing partial backup with timestamp %s", globalFPInfo.Timestamp)
+	if pluginConfig != nil {
+		for _, plugin := range pluginConfig.AllPlugins() {
+			err := plugin.DeleteBackup(globalFPInfo.Timestamp)
+			if err != nil {
+				gplog.Warn("%v", err)
+			}
+		}
+	}
+	removeBackupDirectories()
+}
+
+func removeBackupDirectories() {
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Removing partial backup directories",
+		cluster.ON_SEGMENTS|cluster.INCLUDE_MASTER,
+		func(contentID int) string {
+			return fmt.Sprintf("rm -rf %s", globalFPInfo.GetDirForContent(contentID))
+		})
+	globalCluster.CheckClusterError(remoteOutput, "Unable to remove partial backup directories", func(contentID int) string {
+		return fmt.Sprintf("Unable to remove partial backup directory %s", globalFPInfo.GetDirForContent(contentID))
+	}, true)
+}
+
+/*
+ * Runs the backup again in a new gpbackup process, so that it starts from a
+ * clean state with a fresh timestamp, and returns that process's exit code.
+ * With --timestamp, the retried backup reuses the timestamp instead.
+ */
+func retryBackup() int {
+	exitCode := utils.ErrorClassForOutcome(true, false).ExitCode()
+	nextAttempt := retryAttempt + 1
+	retryInterval := MustGetFlagInt(options.RETRY_INTERVAL)
+	gplog.Warn("Backup failed; restarting backup in %d seconds (retry %d of %d)",
+		retryInterval, nextAttempt, MustGetFlagInt(options.MAX_RETRIES))
+
+	// Cleanup is already done, so a termination signal while waiting can simply exit
+	signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+	time.Sleep(time.Duration(retryInterval) * time.Second)
+	if MustGetFlagString(options.TIMESTAMP) == "" {
+		waitForNewTimestamp(globalFPInfo.Timestamp)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		gplog.Error("Unable to restart backup: %v", err)
+		return exitCode
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", RETRY_ATTEMPT_ENV, nextAttempt))
+	err = cmd.Start()
+	if err != nil {
+		gplog.Error("Unable to restart backup: %v", err)
+		return exitCode
+	}
+	// The retried backup handles termination signals and its own cleanup
+	signal.Ignore(syscall.SIGINT, syscall.SIGTERM)
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		gplog.Error("Unable to restart backup: %v", err)
+		return exitCode
+	}
+	return 0
+}
+
+/*
+ * Timestamps only have a resolution of one second, so with a short
+ * --retry-interval the retried backup could otherwise reuse the timestamp of
+ * the failed attempt whose directories were just removed.
+ */
+func waitForNewTimestamp(timestamp string) {
+	for history.CurrentTimestamp() == timestamp {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 // Cancel blocked gpbackup queries waiting for locks.
 func cancelBlockedQueries(timestamp string) {
 	conn := dbconn.NewDBConnFromEnvironment(MustGetFlagString(options.DBNAME))
@@ -1,7 +1,20 @@
 package backup
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -17,9 +30,320 @@ var _ = Describe("backup internal tests", func() {
 	Describe("backupData", func() {
 		It("returns successfully immediately if there is no table data to backup", func() {
 			emptyTableSlice := make([]Table, 0)
+			runStats = history.NewRunStats()
 
 			backupData(emptyTableSlice)
 			Expect(string(log.Contents())).To(ContainSubstring("Data backup complete"))
 		})
 	})
+	Describe("ensureColumnOrder", func() {
+		It("leaves columns in attnum order unchanged without warning", func() {
+			columnDefs := []ColumnDefinition{{Num: 1, Name: "a"}, {Num: 2, Name: "b"}, {Num: 2, Name: "b"}, {Num: 4, Name: "d"}}
+			ensureColumnOrder("public.foo", columnDefs)
+			Expect(columnDefs).To(Equal([]ColumnDefinition{{Num: 1, Name: "a"}, {Num: 2, Name: "b"}, {Num: 2, Name: "b"}, {Num: 4, Name: "d"}}))
+			Expect(string(log.Contents())).ToNot(ContainSubstring("SELECT * column order"))
+		})
+		It("reorders columns by attnum and warns if they are out of order", func() {
+			columnDefs := []ColumnDefinition{{Num: 3, Name: "c"}, {Num: 1, Name: "a"}, {Num: 2, Name: "b"}}
+			ensureColumnOrder("public.foo", columnDefs)
+			Expect(columnDefs).To(Equal([]ColumnDefinition{{Num: 1, Name: "a"}, {Num: 2, Name: "b"}, {Num: 3, Name: "c"}}))
+			Expect(string(log.Contents())).To(ContainSubstring("[WARNING]:-Column definitions for table public.foo do not match the SELECT * column order; reordering them by attribute number"))
+		})
+	})
+	Describe("processCorruptPartitionTables", func() {
+		var tables []Table
+		BeforeEach(func() {
+			SetReport(&report.Report{})
+			tables = []Table{
+				{Relation: Relation{Schema: "public", Name: "foo"}},
+				{Relation: Relation{Schema: "public", Name: "sales"}, TableDefinition: TableDefinition{PartitionLevelInfo: PartitionLevelInfo{Level: "p"},
+					PartitionAlteredSchemas: []AlteredPartitionRelation{{OldSchema: "public", NewSchema: "archive", Name: "sales_1_prt_2"}}}},
+				{Relation: Relation{Schema: "public", Name: "sales_1_prt_1"}, TableDefinition: TableDefinition{PartitionLevelInfo: PartitionLevelInfo{Level: "l", RootName: "sales"}}},
+				{Relation: Relation{Schema: "archive", Name: "sales_1_prt_2"}, TableDefinition: TableDefinition{PartitionLevelInfo: PartitionLevelInfo{Level: "l", RootName: "sales"}}},
+				{Relation: Relation{Schema: "public", Name: "orders"}, TableDefinition: TableDefinition{PartitionLevelInfo: PartitionLevelInfo{Level: "p"}, PartDef: "PARTITION BY RANGE(id)"}},
+				{Relation: Relation{Schema: "public", Name: "orders_1_prt_1"}, TableDefinition: TableDefinition{PartitionLevelInfo: PartitionLevelInfo{Level: "l", RootName: "orders"}}},
+			}
+		})
+		It("leaves tables unchanged if every partition table has a partition definition", func() {
+			tables[1].PartDef = "PARTITION BY RANGE(id)"
+			Expect(processCorruptPartitionTables(tables)).To(Equal(tables))
+			Expect(backupReport.ManualAttentionTables).To(BeEmpty())
+		})
+		It("panics naming a partition table without a partition definition", func() {
+			defer func() {
+				Expect(string(log.Contents())).To(ContainSubstring("[ERROR]:-Cannot back up partition table public.sales: its partition definition could not be read from the catalog"))
+				Expect(backupReport.ManualAttentionTables).To(Equal([]string{"public.sales (partition definition could not be read)"}))
+			}()
+			defer testhelper.ShouldPanicWithMessage("Could not read the partition definition of 1 partition table(s). Use --skip-corrupt-partitions to leave them out of the backup.")
+			processCorruptPartitionTables(tables)
+		})
+		It("skips a partition table without a partition definition and its child partitions with --skip-corrupt-partitions", func() {
+			_ = cmdFlags.Set(options.SKIP_CORRUPT_PARTS, "true")
+			resultTables := processCorruptPartitionTables(tables)
+			Expect(resultTables).To(Equal([]Table{tables[0], tables[4], tables[5]}))
+			Expect(backupReport.ManualAttentionTables).To(Equal([]string{"public.sales (partition definition could not be read)"}))
+			Expect(string(log.Contents())).To(ContainSubstring("[WARNING]:-Skipping partition table public.sales and its child partitions: its partition definition could not be read from the catalog"))
+		})
+		It("does not check partition definitions in a data-only backup", func() {
+			_ = cmdFlags.Set(options.DATA_ONLY, "true")
+			Expect(processCorruptPartitionTables(tables)).To(Equal(tables))
+		})
+	})
+	Describe("processInvalidIndexes", func() {
+		var indexes []IndexDefinition
+		BeforeEach(func() {
+			SetReport(&report.Report{})
+			indexes = []IndexDefinition{
+				{Oid: 1, Name: "foo_idx", OwningSchema: "public", OwningTable: "foo"},
+				{Oid: 2, Name: "foo_unfinished_idx", OwningSchema: "public", OwningTable: "foo", IsInvalid: true},
+			}
+		})
+		It("skips invalid indexes with a warning and lists them in the report", func() {
+			Expect(processInvalidIndexes(indexes)).To(Equal(indexes[:1]))
+			Expect(string(log.Contents())).To(ContainSubstring("[WARNING]:-Index public.foo_unfinished_idx on table public.foo is invalid and will not be backed up; use --include-invalid-indexes to back it up"))
+			Expect(backupReport.InvalidObjects).To(Equal([]string{"index public.foo_unfinished_idx on public.foo (not backed up)"}))
+		})
+		It("keeps invalid indexes with --include-invalid-indexes", func() {
+			_ = cmdFlags.Set(options.INCLUDE_INVALID, "true")
+			Expect(processInvalidIndexes(indexes)).To(Equal(indexes))
+			Expect(string(log.Contents())).To(ContainSubstring("[WARNING]:-Index public.foo_unfinished_idx on table public.foo is invalid; it will be backed up and built in full on restore"))
+			Expect(backupReport.InvalidObjects).To(Equal([]string{"index public.foo_unfinished_idx on public.foo (backed up)"}))
+		})
+	})
+	Describe("runQueriesInParallel", func() {
+		It("runs every query", func() {
+			var numRun int32
+			queries := make([]func(conn *dbconn.DBConn), 10)
+			for i := range queries {
+				queries[i] = func(conn *dbconn.DBConn) { atomic.AddInt32(&numRun, 1) }
+			}
+			runQueriesInParallel(connectionPool, queries)
+			Expect(numRun).To(Equal(int32(10)))
+		})
+		It("runs concurrent queries on separate connections that share a snapshot", func() {
+			synchronizedSnapshot = "00000003-00000002-1"
+			defer func() { synchronizedSnapshot = "" }()
+			db0, mock0 := testhelper.CreateMockDB()
+			db1, mock1 := testhelper.CreateMockDB()
+			pool := &dbconn.DBConn{ConnPool: []*sqlx.DB{db0, db1}, Tx: make([]*sqlx.Tx, 2), NumConns: 2, Version: connectionPool.Version}
+			mock0.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("1"))
+			mock1.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("1"))
+			// Neither query finishes until both have started, so each must hold a connection of its own
+			var started sync.WaitGroup
+			started.Add(2)
+			query := func(conn *dbconn.DBConn) {
+				started.Done()
+				started.Wait()
+				Expect(dbconn.MustSelectString(conn, "SELECT 1")).To(Equal("1"))
+			}
+			runQueriesInParallel(pool, []func(conn *dbconn.DBConn){query, query})
+			Expect(mock0.ExpectationsWereMet()).To(Succeed())
+			Expect(mock1.ExpectationsWereMet()).To(Succeed())
+		})
+		It("runs every query on connection 0 when the connections do not share a snapshot", func() {
+			db0, mock0 := testhelper.CreateMockDB()
+			db1, mock1 := testhelper.CreateMockDB()
+			pool := &dbconn.DBConn{ConnPool: []*sqlx.DB{db0, db1}, Tx: make([]*sqlx.Tx, 2), NumConns: 2, Version: connectionPool.Version}
+			mock0.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("1"))
+			mock0.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("1"))
+			query := func(conn *dbconn.DBConn) {
+				Expect(dbconn.MustSelectString(conn, "SELECT 1")).To(Equal("1"))
+			}
+			runQueriesInParallel(pool, []func(conn *dbconn.DBConn){query, query})
+			Expect(mock0.ExpectationsWereMet()).To(Succeed())
+			Expect(mock1.ExpectationsWereMet()).To(Succeed())
+		})
+		It("raises a fatal error in a query on the calling goroutine", func() {
+			defer testhelper.ShouldPanicWithMessage("relation does not exist")
+			runQueriesInParallel(connectionPool, []func(conn *dbconn.DBConn){
+				func(conn *dbconn.DBConn) { gplog.Fatal(errors.New("relation does not exist"), "") },
+			})
+		})
+		It("starts no more queries after a query fails", func() {
+			var numRun int32
+			queries := []func(conn *dbconn.DBConn){
+				func(conn *dbconn.DBConn) { gplog.Fatal(errors.New("relation does not exist"), "") },
+			}
+			for i := 0; i < 5; i++ {
+				queries = append(queries, func(conn *dbconn.DBConn) { atomic.AddInt32(&numRun, 1) })
+			}
+			defer func() {
+				Expect(numRun).To(Equal(int32(0)))
+			}()
+			defer testhelper.ShouldPanicWithMessage("relation does not exist")
+			runQueriesInParallel(connectionPool, queries)
+		})
+	})
+	Describe("synchronizeSnapshot", func() {
+		var mock sqlmock.Sqlmock
+		BeforeEach(func() {
+			connectionPool, mock = testhelper.CreateAndConnectMockDB(2)
+			connectionPool.Version = dbconn.NewVersion("7.0.0")
+		})
+		AfterEach(func() {
+			synchronizedSnapshot = ""
+		})
+		It("exports the snapshot of connection 0 and imports it on the other connections in GPDB 7+", func() {
+			mock.ExpectQuery("SELECT pg_catalog.pg_export_snapshot()").WillReturnRows(sqlmock.NewRows([]string{"pg_export_snapshot"}).AddRow("00000003-00000002-1"))
+			mock.ExpectExec("SET TRANSACTION SNAPSHOT '00000003-00000002-1'").WillReturnResult(sqlmock.NewResult(0, 0))
+			synchronizeSnapshot(0)
+			synchronizeSnapshot(1)
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(synchronizedSnapshot).To(Equal("00000003-00000002-1"))
+		})
+		It("does not synchronize snapshots before GPDB 7", func() {
+			connectionPool.Version = dbconn.NewVersion("6.0.0")
+			synchronizeSnapshot(0)
+			synchronizeSnapshot(1)
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(synchronizedSnapshot).To(BeEmpty())
+		})
+	})
+	Describe("validateFlagCombinations", func() {
+		BeforeEach(func() {
+			_ = cmdFlags.Set(options.METADATA_ONLY, "true")
+		})
+		It("allows --data-format csv with --metadata-only", func() {
+			_ = cmdFlags.Set(options.DATA_FORMAT, "csv")
+			validateFlagCombinations(cmdFlags)
+		})
+		It("panics if --data-format binary is specified with --metadata-only", func() {
+			_ = cmdFlags.Set(options.DATA_FORMAT, "binary")
+			defer testhelper.ShouldPanicWithMessage("--data-format binary cannot be specified with --metadata-only")
+			validateFlagCombinations(cmdFlags)
+		})
+		It("panics if --strict-include is specified without --include-table-query", func() {
+			_ = cmdFlags.Set(options.STRICT_INCLUDE, "true")
+			defer testhelper.ShouldPanicWithMessage("--strict-include must be specified with --include-table-query")
+			validateFlagCombinations(cmdFlags)
+		})
+		It("panics if --exclude-table-data-file is specified without --leaf-partition-data", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_DATA_FILE, "/tmp/old_partitions")
+			defer testhelper.ShouldPanicWithMessage("--leaf-partition-data must be specified with --exclude-table-data or --exclude-table-data-file")
+			validateFlagCombinations(cmdFlags)
+		})
+		It("allows --include-table-regex with --include-table and --exclude-table-regex", func() {
+			_ = cmdFlags.Set(options.INCLUDE_TABLE_REGEX, "public\\.sales_.*")
+			_ = cmdFlags.Set(options.INCLUDE_RELATION, "public.orders")
+			_ = cmdFlags.Set(options.EXCLUDE_TABLE_REGEX, ".*_archive")
+			validateFlagCombinations(cmdFlags)
+		})
+		It("panics if --exclude-table-regex is specified with --include-table", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_TABLE_REGEX, ".*_archive")
+			_ = cmdFlags.Set(options.INCLUDE_RELATION, "public.orders")
+			defer testhelper.ShouldPanicWithMessage("The following flags may not be specified together: exclude-table-regex, exclude-schema, exclude-schema-file, include-table, include-table-file, include-table-query")
+			validateFlagCombinations(cmdFlags)
+		})
+	})
+	Describe("validateFlagValues", func() {
+		It("allows a system schema that may be included", func() {
+			_ = cmdFlags.Set(options.INCLUDE_SYSTEM_SCHEMA, "gp_toolkit")
+			validateFlagValues()
+		})
+		It("panics if --include-system-schema names a schema that may not be included", func() {
+			_ = cmdFlags.Set(options.INCLUDE_SYSTEM_SCHEMA, "pg_toast")
+			defer testhelper.ShouldPanicWithMessage("Invalid --include-system-schema value pg_toast.  Valid values are gp_toolkit, information_schema, pg_catalog.")
+			validateFlagValues()
+		})
+	})
+	Describe("warnIncludedSystemSchemas", func() {
+		It("warns that each included system schema requires superuser to restore", func() {
+			SetReport(&report.Report{})
+			_ = cmdFlags.Set(options.INCLUDE_SYSTEM_SCHEMA, "pg_catalog")
+			warnIncludedSystemSchemas()
+			Expect(string(log.Contents())).To(ContainSubstring("Backing up user objects in system schema pg_catalog; restoring them will require superuser privileges"))
+		})
+	})
+	Describe("resolveIncludeTableQuery", func() {
+		var mock sqlmock.Sqlmock
+		BeforeEach(func() {
+			connectionPool, mock = testhelper.CreateAndConnectMockDB(1)
+			_ = cmdFlags.Set(options.INCLUDE_TABLE_QUERY, "SELECT schemaname, tablename FROM registry.backup_tables")
+		})
+		textRows := func() *sqlmock.Rows {
+			return sqlmock.NewRows([]string{"schemaname", "tablename"})
+		}
+		It("adds the tables returned by the query to --include-table", func() {
+			mock.ExpectQuery("SELECT schemaname, tablename").WillReturnRows(textRows().AddRow("public", "foo").AddRow("public", "bar"))
+			Expect(resolveIncludeTableQuery()).To(BeTrue())
+			Expect(MustGetFlagStringArray(options.INCLUDE_RELATION)).To(Equal([]string{"public.foo", "public.bar"}))
+		})
+		It("warns and takes no backup if the query returns no tables", func() {
+			mock.ExpectQuery("SELECT schemaname, tablename").WillReturnRows(textRows())
+			Expect(resolveIncludeTableQuery()).To(BeFalse())
+			Expect(string(log.Contents())).To(ContainSubstring("[WARNING]:-The --include-table-query query returned no tables, so no backup will be taken"))
+		})
+		It("panics if the query returns no tables with --strict-include", func() {
+			_ = cmdFlags.Set(options.STRICT_INCLUDE, "true")
+			mock.ExpectQuery("SELECT schemaname, tablename").WillReturnRows(textRows())
+			defer testhelper.ShouldPanicWithMessage("The --include-table-query query returned no tables")
+			resolveIncludeTableQuery()
+		})
+	})
+	Describe("waitForNewTimestamp", func() {
+		AfterEach(func() {
+			operating.System.Now = time.Now
+		})
+		It("waits until the current timestamp differs from the failed backup's timestamp", func() {
+			failedTime := time.Date(2017, 1, 1, 1, 1, 1, 0, time.Local)
+			calls := 0
+			operating.System.Now = func() time.Time {
+				calls++
+				if calls < 3 {
+					return failedTime
+				}
+				return failedTime.Add(time.Second)
+			}
+
+			waitForNewTimestamp("20170101010101")
+
+			Expect(calls).To(Equal(3))
+		})
+	})
+	Describe("isRetryableFailure", func() {
+		BeforeEach(func() {
+			_ = cmdFlags.Set(options.MAX_RETRIES, "2")
+			flagsValidated = true
+			retryAttempt = 0
+		})
+		AfterEach(func() {
+			flagsValidated = false
+			retryAttempt = 0
+		})
+		It("retries a failure after flag validation", func() {
+			Expect(isRetryableFailure("ERROR: terminating connection due to administrator command (SQLSTATE 57P01)")).To(BeTrue())
+		})
+		It("does not retry when --max-retries is not set", func() {
+			_ = cmdFlags.Set(options.MAX_RETRIES, "0")
+			Expect(isRetryableFailure("ERROR: terminating connection due to administrator command (SQLSTATE 57P01)")).To(BeFalse())
+		})
+		It("does not retry once all retries are used", func() {
+			retryAttempt = 2
+			Expect(isRetryableFailure("ERROR: terminating connection due to administrator command (SQLSTATE 57P01)")).To(BeFalse())
+		})
+		It("does not retry invalid flags", func() {
+			flagsValidated = false
+			Expect(isRetryableFailure("The following flags may not be specified together: data-only, metadata-only")).To(BeFalse())
+		})
+		It("does not retry a permission error", func() {
+			Expect(isRetryableFailure("ERROR: permission denied for relation foo (SQLSTATE 42501)")).To(BeFalse())
+		})
+		It("does not retry a missing database, role, or filtered table", func() {
+			Expect(isRetryableFailure(`FATAL: database "nodb" does not exist (SQLSTATE 3D000)`)).To(BeFalse())
+			Expect(isRetryableFailure(`FATAL: role "norole" does not exist (SQLSTATE 28000)`)).To(BeFalse())
+			Expect(isRetryableFailure("Table public.foo does not exist")).To(BeFalse())
+		})
+		It("does not retry an invalid flag value", func() {
+			Expect(isRetryableFailure("Invalid --data-format value xml.  Valid values are csv and binary.")).To(BeFalse())
+		})
+		It("retries a table that was dropped during the backup", func() {
+			Expect(isRetryableFailure(`ERROR: relation "public.foo" does not exist (SQLSTATE 42P01)`)).To(BeTrue())
+		})
+		It("does not retry a backup whose --timestamp belongs to a successful backup", func() {
+			Expect(isRetryableFailure("A backup with timestamp 20170101010101 already completed successfully. Specify a different --timestamp.")).To(BeFalse())
+		})
+		It("does not retry an unexpected panic", func() {
+			Expect(isRetryableFailure("")).To(BeFalse())
+		})
+	})
 })
@@ -6,15 +6,21 @@ package backup
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
@@ -22,15 +28,161 @@ var (
 	tableDelim = ","
 )
 
+/*
+ * Stored generated columns are left out, as COPY without a column list does
+ * not write them and a generated column cannot be copied into; their values
+ * are computed again on restore.
+ */
 func ConstructTableAttributesList(columnDefs []ColumnDefinition) string {
-	names := make([]string, 0)
+	var attributes strings.Builder
 	for _, col := range columnDefs {
-		names = append(names, col.Name)
+		if col.GenerationExpr != "" {
+			continue
+		}
+		if attributes.Len() > 0 {
+			attributes.WriteString(",")
+		}
+		attributes.WriteString(col.Name)
+	}
+	if attributes.Len() == 0 {
+		return ""
 	}
-	if len(names) > 0 {
-		return fmt.Sprintf("(%s)", strings.Join(names, ","))
+	return fmt.Sprintf("(%s)", attributes.String())
+}
+
+/*
+ * The query keeps every column in its usual place, so the data file matches
+ * the table's attribute list and restores like any other.
+ */
+func ConstructColumnSubstitutionQuery(table Table) string {
+	columns := make([]string, 0, len(table.ColumnDefs))
+	for _, column := range table.ColumnDefs {
+		if column.GenerationExpr != "" {
+			continue
+		}
+		columnStr := column.Name
+		if substitute, ok := table.ColumnSubstitutions[column.Name]; ok {
+			columnStr = fmt.Sprintf("%s AS %s", substitute, column.Name)
+		}
+		columns = append(columns, columnStr)
+	}
+	return fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), table.FQN())
+}
+
+/*
+ * With --leaf-partition-data each leaf partition's data is backed up on its
+ * own, so the data of leaf partitions given in --exclude-table-data can be
+ * left out while they remain in their parent's CREATE TABLE statement.  Names
+ * that are not in the backup set are only warned about, since lists of old
+ * partitions go stale as partitions are dropped.
+ */
+func ExcludeLeafPartitionData(tables []Table) []Table {
+	entries := MustGetFlagStringArray(options.EXCLUDE_DATA)
+	if len(entries) == 0 {
+		return tables
+	}
+	quotedEntries, err := options.QuoteTableNames(connectionPool, entries)
+	gplog.FatalOnError(err)
+	tableIndexes := make(map[string]int, len(tables))
+	for i, table := range tables {
+		tableIndexes[table.FQN()] = i
+	}
+	numExcluded := 0
+	for _, fqn := range quotedEntries {
+		index, ok := tableIndexes[fqn]
+		if !ok {
+			report.Warn(report.WARN_MISSING_FILTER_OBJECT, fqn, "Table %s given in --%s is not in the backup set", fqn, options.EXCLUDE_DATA)
+			continue
+		}
+		table := &tables[index]
+		if table.PartitionLevelInfo.Level != "l" {
+			gplog.Fatal(errors.Errorf("Table %s given in --%s is not a leaf partition", fqn, options.EXCLUDE_DATA), "")
+		}
+		if !table.DataExcluded {
+			table.DataExcluded = true
+			numExcluded++
+		}
 	}
-	return ""
+	gplog.Info("Excluding the data of %d leaf partition(s) given in --%s", numExcluded, options.EXCLUDE_DATA)
+	return tables
+}
+
+/*
+ * Each --exclude-column-data entry names a column as schema.table.column, whose
+ * data is backed up as NULL, or as schema.table.column=value, whose data is
+ * backed up as that constant.  NOT NULL columns need a constant, as NULL data
+ * could not be restored into them.
+ */
+func SetColumnSubstitutions(tables []Table) []Table {
+	entries := MustGetFlagStringArray(options.EXCLUDE_COLUMN_DATA)
+	if len(entries) == 0 || backupReport.MetadataOnly {
+		return tables
+	}
+	if connectionPool.Version.Before("6") {
+		gplog.Fatal(errors.Errorf("--%s requires GPDB 6 or later", options.EXCLUDE_COLUMN_DATA), "")
+	}
+	tableIndexes := make(map[string]int, len(tables))
+	for i, table := range tables {
+		tableIndexes[table.FQN()] = i
+	}
+	for _, entry := range entries {
+		columnFQN, value, hasValue := entry, "", false
+		if i := strings.Index(entry, "="); i >= 0 {
+			columnFQN, value, hasValue = entry[:i], entry[i+1:], true
+		}
+		dot := strings.LastIndex(columnFQN, ".")
+		if dot < 0 {
+			gplog.Fatal(errors.Errorf("Invalid --%s entry %s.  Entries must be of the form schema.table.column or schema.table.column=value.",
+				options.EXCLUDE_COLUMN_DATA, entry), "")
+		}
+		quotedTables, err := options.QuoteTableNames(connectionPool, []string{columnFQN[:dot]})
+		gplog.FatalOnError(err)
+		index, ok := tableIndexes[quotedTables[0]]
+		if !ok {
+			gplog.Fatal(errors.Errorf("Table %s given in --%s is not in the backup set", quotedTables[0], options.EXCLUDE_COLUMN_DATA), "")
+		}
+		table := &tables[index]
+		columnName := utils.QuoteIdentifier(columnFQN[dot+1:])
+		var column *ColumnDefinition
+		for i := range table.ColumnDefs {
+			if table.ColumnDefs[i].Name == columnName {
+				column = &table.ColumnDefs[i]
+			}
+		}
+		if column == nil {
+			gplog.Fatal(errors.Errorf("Column %s given in --%s does not exist in table %s", columnName, options.EXCLUDE_COLUMN_DATA, table.FQN()), "")
+		}
+		if column.GenerationExpr != "" {
+			gplog.Fatal(errors.Errorf("Column %s of table %s is a generated column, whose data is never backed up", columnName, table.FQN()), "")
+		}
+		if column.NotNull && !hasValue {
+			gplog.Fatal(errors.Errorf("Column %s of table %s is NOT NULL, so --%s must give a value to back up in place of its data, as in %s.%s=value",
+				columnName, table.FQN(), options.EXCLUDE_COLUMN_DATA, table.FQN(), columnName), "")
+		}
+		substitute := "NULL"
+		if hasValue {
+			substitute = fmt.Sprintf("'%s'::%s", utils.EscapeSingleQuotes(value), column.Type)
+		}
+		if table.ColumnSubstitutions == nil {
+			table.ColumnSubstitutions = make(map[string]string)
+		}
+		table.ColumnSubstitutions[columnName] = substitute
+	}
+	for _, table := range tables {
+		if len(table.ColumnSubstitutions) == 0 {
+			continue
+		}
+		columns := make([]string, 0, len(table.ColumnSubstitutions))
+		for _, column := range table.ColumnDefs {
+			if substitute, ok := table.ColumnSubstitutions[column.Name]; ok {
+				report.Warn(report.WARN_PARTIAL_TABLE_DATA, table.FQN(), "Backing up %s in place of the data of column %s of table %s", substitute, column.Name, table.FQN())
+				columns = append(columns, column.Name)
+			}
+		}
+		backupReport.PartialDataTables = append(backupReport.PartialDataTables,
+			fmt.Sprintf("%s (data not backed up for columns %s)", table.FQN(), strings.Join(columns, ", ")))
+	}
+	return tables
 }
 
 func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64) {
@@ -44,9 +196,64 @@ func AddTableDataEntriesToTOC(tables []Table, rowsCopiedMaps []map[uint32]int64)
 				}
 			}
 			attributes := ConstructTableAttributesList(table.ColumnDefs)
-			globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName)
+			globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes, rowsCopied, table.PartitionLevelInfo.RootName, table.DataFormat())
+			globalTOC.DataEntries[len(globalTOC.DataEntries)-1].ColumnSubstitutions = table.ColumnSubstitutions
+		} else {
+			globalTOC.AddSkippedDataEntry(table.Schema, table.Name, table.DataSkipReason())
+		}
+	}
+}
+
+/*
+ * The data is first written to a temporary file so that its checksum can name
+ * it.  If a file with that name already exists, it holds the same data from an
+ * earlier backup and is left untouched, so that rsync sees no change to it.
+ * The statement is embedded in a quoted COPY PROGRAM string, so it must not
+ * contain single quotes.
+ */
+func GetContentAddressedDestination(fpInfo filepath.FilePathInfo, tableOid uint32, extension string) string {
+	tempFile := fpInfo.GetTableBackupFilePathForCopyCommand(tableOid, extension, false) + ".tmp"
+	contentDir := fpInfo.GetContentAddressedDirForCopyCommand()
+	refFile := fpInfo.GetTableContentRefFilePathForCopyCommand(tableOid)
+	return fmt.Sprintf(`%[1]s && HASH=$(sha256sum %[1]s | cut -d" " -f1)%[2]s && mkdir -p %[3]s && `+
+		`(test -e %[3]s/$HASH && rm %[1]s || mv %[1]s %[3]s/$HASH) && echo "%[4]d $HASH" > %[5]s`,
+		tempFile, extension, contentDir, tableOid, refFile)
+}
+
+/*
+ * Each segment's reference files list the oid and data file name of every
+ * table it wrote, which we record in the TOC for each table and content.  A
+ * segment writes no reference files when no table data was written, as when
+ * every table is external or has its data skipped, so no matches is not an
+ * error.
+ */
+func AddContentHashesToTOC(c *cluster.Cluster, fpInfo filepath.FilePathInfo, dataEntries []toc.MasterDataEntry) {
+	if len(dataEntries) == 0 {
+		return
+	}
+	remoteOutput := c.GenerateAndExecuteCommand("Reading content-addressed data file names", cluster.ON_SEGMENTS, func(contentID int) string {
+		return fmt.Sprintf("find %s -maxdepth 1 -name 'gpbackup_%d_%s_*.ref' -exec cat {} +", fpInfo.GetDirForContent(contentID), contentID, fpInfo.Timestamp)
+	})
+	c.CheckClusterError(remoteOutput, "Unable to read content-addressed data file names", func(contentID int) string {
+		return "Unable to read content-addressed data file names"
+	})
+	hashesByOid := make(map[uint32]map[int]string)
+	for _, command := range remoteOutput.Commands {
+		for _, line := range strings.Split(strings.TrimSpace(command.Stdout), "\n") {
+			var oid uint32
+			var filename string
+			if _, err := fmt.Sscanf(line, "%d %s", &oid, &filename); err != nil {
+				continue
+			}
+			if hashesByOid[oid] == nil {
+				hashesByOid[oid] = make(map[int]string)
+			}
+			hashesByOid[oid][command.Content] = filename
 		}
 	}
+	for i := range dataEntries {
+		dataEntries[i].ContentHashes = hashesByOid[dataEntries[i].Oid]
+	}
 }
 
 type BackupProgressCounters struct {
@@ -69,12 +276,20 @@ func CopyTableOut(connectionPool *dbconn.DBConn, table Table, destinationToWrite
 		checkPipeExistsCommand = fmt.Sprintf("(test -p \"%s\" || (echo \"Pipe not found %s\">&2; exit 1)) && ", destinationToWrite, destinationToWrite)
 		customPipeThroughCommand = "cat -"
 	} else if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
-		sendToDestinationCommand = fmt.Sprintf("| %s backup_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+		sendToDestinationCommand = fmt.Sprintf("| %s backup_data %s", pluginConfig.ForData().ExecutablePath, pluginConfig.ForData().ConfigPath)
 	}
 
-	copyCommand := fmt.Sprintf("PROGRAM '%s%s %s %s'", checkPipeExistsCommand, customPipeThroughCommand, sendToDestinationCommand, destinationToWrite)
+	hostSlotCommand := utils.HostSlotCommandPrefix(globalFPInfo.GetHostSlotDir(), MustGetFlagInt(options.MAX_PER_HOST))
+	copyCommand := fmt.Sprintf("PROGRAM '%s%s%s %s %s'", hostSlotCommand, checkPipeExistsCommand, customPipeThroughCommand, sendToDestinationCommand, destinationToWrite)
 
-	query := fmt.Sprintf("COPY %s TO %s WITH CSV DELIMITER '%s' ON SEGMENT IGNORE EXTERNAL PARTITIONS;", table.FQN(), copyCommand, tableDelim)
+	formatClause := fmt.Sprintf("CSV DELIMITER '%s'", tableDelim)
+	if table.DataFormat() == "binary" {
+		formatClause = "BINARY"
+	}
+	query := fmt.Sprintf("COPY %s TO %s WITH %s ON SEGMENT IGNORE EXTERNAL PARTITIONS;", table.FQN(), copyCommand, formatClause)
+	if len(table.ColumnSubstitutions) > 0 {
+		query = fmt.Sprintf("COPY (%s) TO %s WITH %s ON SEGMENT;", ConstructColumnSubstitutionQuery(table), copyCommand, formatClause)
+	}
 	gplog.Verbose("Worker %d: %s", connNum, query)
 	result, err := connectionPool.Exec(query, connNum)
 	if err != nil {
@@ -85,8 +300,8 @@ func CopyTableOut(connectionPool *dbconn.DBConn, table Table, destinationToWrite
 }
 
 func BackupSingleTableData(table Table, rowsCopiedMap map[uint32]int64, counters *BackupProgressCounters, whichConn int) error {
-	if table.SkipDataBackup() {
-		gplog.Verbose("Skipping data backup of table %s because it is either an external or foreign table.", table.FQN())
+	if reason := table.DataSkipReason(); reason != "" {
+		gplog.Verbose("Skipping data backup of table %s: %s", table.FQN(), reason)
 	} else {
 
 		atomic.AddInt64(&counters.NumRegTables, 1)
@@ -101,6 +316,8 @@ func BackupSingleTableData(table Table, rowsCopiedMap map[uint32]int64, counters
 		destinationToWrite := ""
 		if MustGetFlagBool(options.SINGLE_DATA_FILE) {
 			destinationToWrite = fmt.Sprintf("%s_%d", globalFPInfo.GetSegmentPipePathForCopyCommand(), table.Oid)
+		} else if MustGetFlagBool(options.CONTENT_ADDRESSED) {
+			destinationToWrite = GetContentAddressedDestination(globalFPInfo, table.Oid, utils.GetPipeThroughProgram().Extension)
 		} else {
 			destinationToWrite = globalFPInfo.GetTableBackupFilePathForCopyCommand(table.Oid, utils.GetPipeThroughProgram().Extension, false)
 		}
@@ -168,7 +385,7 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 							// the following WARN message is nicely outputted.
 							fmt.Printf("\n")
 						}
-						gplog.Warn("Worker %d could not acquire AccessShareLock for table %s. Terminating worker and deferring table to main worker thread.",
+						report.Warn(report.WARN_TABLE_LOCK_DEFERRED, table.FQN(), "Worker %d could not acquire AccessShareLock for table %s. Terminating worker and deferring table to main worker thread.",
 							whichConn, table.FQN())
 
 						// Defer table to main worker thread
@@ -226,15 +443,22 @@ func backupDataForAllTables(tables []Table) []map[uint32]int64 {
 	}
 
 	counters.ProgressBar.Finish()
-	printDataBackupWarnings(numExtOrForeignTables)
 	return rowsCopiedMaps
 }
 
-func printDataBackupWarnings(numExtTables int64) {
-	if numExtTables > 0 {
-		gplog.Info("Skipped data backup of %d external/foreign table(s).", numExtTables)
-		gplog.Info("See %s for a complete list of skipped tables.", gplog.GetLogFilePath())
+func printDataBackupWarnings(skippedCounts map[string]int) {
+	if len(skippedCounts) == 0 {
+		return
 	}
+	reasons := make([]string, 0, len(skippedCounts))
+	numSkipped := 0
+	for reason, count := range skippedCounts {
+		reasons = append(reasons, fmt.Sprintf("%d %s", count, reason))
+		numSkipped += count
+	}
+	sort.Strings(reasons)
+	gplog.Info("Skipped data backup of %d table(s): %s", numSkipped, strings.Join(reasons, ", "))
+	gplog.Info("See %s for a complete list of skipped tables.", gplog.GetLogFilePath())
 }
 
 func CheckTablesContainData(tables []Table) {
@@ -249,6 +473,58 @@ func CheckTablesContainData(tables []Table) {
 	}
 }
 
+/*
+ * A table the backup role cannot read only fails at COPY time, possibly hours
+ * into the backup, so all of them are found up front.  They either stop the
+ * backup or, with --skip-inaccessible-tables, keep their metadata but have
+ * their data left out of the backup set.
+ */
+func CheckTablePrivileges(tables []Table) []Table {
+	if backupReport.MetadataOnly {
+		return tables
+	}
+	dataTables := make([]Table, 0, len(tables))
+	for _, table := range tables {
+		if !table.SkipDataBackup() {
+			dataTables = append(dataTables, table)
+		}
+	}
+	inaccessibleTables := GetInaccessibleTables(connectionPool, dataTables)
+	if len(inaccessibleTables) == 0 {
+		return tables
+	}
+
+	skip := MustGetFlagBool(options.SKIP_INACCESSIBLE)
+	missingColumns := make(map[uint32]string, len(inaccessibleTables))
+	for _, inaccessible := range inaccessibleTables {
+		missingColumns[inaccessible.Oid] = inaccessible.Columns
+	}
+	tablesToBackUp := make([]Table, 0, len(tables))
+	for _, table := range tables {
+		columns, ok := missingColumns[table.Oid]
+		if !ok {
+			tablesToBackUp = append(tablesToBackUp, table)
+			continue
+		}
+		missingStr := "SELECT"
+		if columns != "" {
+			missingStr = fmt.Sprintf("SELECT on columns %s", columns)
+		}
+		backupReport.InaccessibleTables = append(backupReport.InaccessibleTables, fmt.Sprintf("%s (%s)", table.FQN(), missingStr))
+		if skip {
+			report.Warn(report.WARN_INACCESSIBLE_TABLE, table.FQN(), "Skipping data backup of table %s: missing %s privilege", table.FQN(), missingStr)
+			globalTOC.AddSkippedDataEntry(table.Schema, table.Name, toc.SKIP_REASON_INACCESSIBLE)
+		} else {
+			gplog.Error("Cannot back up data for table %s: missing %s privilege", table.FQN(), missingStr)
+		}
+	}
+	if !skip {
+		gplog.Fatal(errors.Errorf("The backup role lacks SELECT privilege on %d table(s). Use --%s to back up only their metadata.",
+			len(inaccessibleTables), options.SKIP_INACCESSIBLE), "")
+	}
+	return tablesToBackUp
+}
+
 // Acquire AccessShareLock on a table with NOWAIT option. If we are unable to acquire
 // the lock, the call will fail instead of block. Return the failure for handling.
 func LockTableNoWait(dataTable Table, connNum int) error {
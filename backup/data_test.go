@@ -5,7 +5,10 @@ import (
 	"regexp"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/report"
@@ -29,6 +32,11 @@ var _ = Describe("backup/data tests", func() {
 			atts := backup.ConstructTableAttributesList(columnDefs)
 			Expect(atts).To(Equal("(a,b)"))
 		})
+		It("leaves stored generated columns out of the attribute list", func() {
+			columnDefs := []backup.ColumnDefinition{{Name: "a"}, {Name: "b", GenerationExpr: "(a * 2)"}, {Name: "c"}}
+			atts := backup.ConstructTableAttributesList(columnDefs)
+			Expect(atts).To(Equal("(a,c)"))
+		})
 		It("creates an attribute list for a table with no columns", func() {
 			columnDefs := make([]backup.ColumnDefinition, 0)
 			atts := backup.ConstructTableAttributesList(columnDefs)
@@ -54,7 +62,22 @@ var _ = Describe("backup/data tests", func() {
 		It("adds an entry for a regular table to the TOC", func() {
 			tables := []backup.Table{table}
 			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
-			expectedDataEntries := []toc.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)"}}
+			expectedDataEntries := []toc.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", Format: "csv"}}
+			Expect(tocfile.DataEntries).To(Equal(expectedDataEntries))
+		})
+		It("records the binary format for a table when --data-format is binary", func() {
+			_ = cmdFlags.Set(options.DATA_FORMAT, "binary")
+			tables := []backup.Table{table}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			expectedDataEntries := []toc.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", Format: "binary"}}
+			Expect(tocfile.DataEntries).To(Equal(expectedDataEntries))
+		})
+		It("records the csv format for a table without binary I/O when --data-format is binary", func() {
+			_ = cmdFlags.Set(options.DATA_FORMAT, "binary")
+			table.NoBinaryIO = true
+			tables := []backup.Table{table}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			expectedDataEntries := []toc.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", Format: "csv"}}
 			Expect(tocfile.DataEntries).To(Equal(expectedDataEntries))
 		})
 		It("does not add an entry for an external table to the TOC", func() {
@@ -62,6 +85,8 @@ var _ = Describe("backup/data tests", func() {
 			tables := []backup.Table{table}
 			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
 			Expect(tocfile.DataEntries).To(BeNil())
+			expectedSkippedEntries := []toc.SkippedDataEntry{{Schema: "public", Name: "table", Reason: toc.SKIP_REASON_EXTERNAL}}
+			Expect(tocfile.SkippedDataEntries).To(Equal(expectedSkippedEntries))
 		})
 		It("does not add an entry for a foreign table to the TOC", func() {
 			foreignDef := backup.ForeignTableDefinition{Oid: 23, Options: "", Server: "fs"}
@@ -69,6 +94,33 @@ var _ = Describe("backup/data tests", func() {
 			tables := []backup.Table{table}
 			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
 			Expect(tocfile.DataEntries).To(BeNil())
+			expectedSkippedEntries := []toc.SkippedDataEntry{{Schema: "public", Name: "table", Reason: toc.SKIP_REASON_FOREIGN}}
+			Expect(tocfile.SkippedDataEntries).To(Equal(expectedSkippedEntries))
+		})
+		It("adds an entry for a replicated table to the TOC if --no-data-for-replicated-tables is not set", func() {
+			table.DistPolicy = "DISTRIBUTED REPLICATED"
+			tables := []backup.Table{table}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			expectedDataEntries := []toc.MasterDataEntry{{Schema: "public", Name: "table", Oid: 1, AttributeString: "(a)", Format: "csv"}}
+			Expect(tocfile.DataEntries).To(Equal(expectedDataEntries))
+			Expect(tocfile.SkippedDataEntries).To(BeNil())
+		})
+		It("records a skipped data entry for a replicated table if --no-data-for-replicated-tables is set", func() {
+			_ = cmdFlags.Set(options.NO_REPLICATED_DATA, "true")
+			table.DistPolicy = "DISTRIBUTED REPLICATED"
+			tables := []backup.Table{table}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			Expect(tocfile.DataEntries).To(BeNil())
+			expectedSkippedEntries := []toc.SkippedDataEntry{{Schema: "public", Name: "table", Reason: "DISTRIBUTED REPLICATED"}}
+			Expect(tocfile.SkippedDataEntries).To(Equal(expectedSkippedEntries))
+		})
+		It("records a skipped data entry for a leaf partition whose data is excluded", func() {
+			table.DataExcluded = true
+			tables := []backup.Table{table}
+			backup.AddTableDataEntriesToTOC(tables, rowsCopiedMaps)
+			Expect(tocfile.DataEntries).To(BeNil())
+			expectedSkippedEntries := []toc.SkippedDataEntry{{Schema: "public", Name: "table", Reason: "EXCLUDED LEAF PARTITION"}}
+			Expect(tocfile.SkippedDataEntries).To(Equal(expectedSkippedEntries))
 		})
 	})
 	Describe("CopyTableOut", func() {
@@ -119,6 +171,44 @@ var _ = Describe("backup/data tests", func() {
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
+		It("will wait for a host slot before backing up a table with --max-concurrent-per-host", func() {
+			_ = cmdFlags.Set(options.MAX_PER_HOST, "2")
+			backup.SetFPInfo(filepath.FilePathInfo{Timestamp: "20170101010101", PID: 1234})
+			defer backup.SetFPInfo(filepath.FilePathInfo{})
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			slotDir := "/tmp/gpbackup_20170101010101_host_slots_1234"
+			execStr := regexp.QuoteMeta(fmt.Sprintf("COPY public.foo TO PROGRAM 'mkdir -p %[1]s && while true; do for i in $(seq 0 1); do exec 9>%[1]s/slot_$i; if flock -n 9; then touch %[1]s/slot_$i.used; break 2; fi; done; sleep 0.2; done; cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;", slotDir))
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will back up a table in binary format", func() {
+			_ = cmdFlags.Set(options.DATA_FORMAT, "binary")
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH BINARY ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, testTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will back up a table with substituted column data", func() {
+			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
+			partialTable := testTable
+			partialTable.ColumnDefs = []backup.ColumnDefinition{{Name: "a"}, {Name: "b"}}
+			partialTable.ColumnSubstitutions = map[string]string{"b": "NULL"}
+			execStr := regexp.QuoteMeta("COPY (SELECT a, NULL AS b FROM public.foo) TO PROGRAM 'cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+
+			_, err := backup.CopyTableOut(connectionPool, partialTable, filename, defaultConnNum)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
 		It("will back up a table to a single file", func() {
 			_ = cmdFlags.Set(options.SINGLE_DATA_FILE, "true")
 			execStr := regexp.QuoteMeta(`COPY public.foo TO PROGRAM '(test -p "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456" || (echo "Pipe not found <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456">&2; exit 1)) && cat - > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;`)
@@ -130,6 +220,59 @@ var _ = Describe("backup/data tests", func() {
 			Expect(err).ShouldNot(HaveOccurred())
 		})
 	})
+	Describe("GetContentAddressedDestination", func() {
+		It("names the data file by its checksum and records it in the reference file", func() {
+			testCluster := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, DataDir: "/data/gpseg-1"}})
+			destination := backup.GetContentAddressedDestination(filepath.NewFilePathInfo(testCluster, "", "20170101010101", ""), 1234, ".gz")
+			Expect(destination).To(Equal(`<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_1234.gz.tmp && ` +
+				`HASH=$(sha256sum <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_1234.gz.tmp | cut -d" " -f1).gz && ` +
+				`mkdir -p <SEG_DATA_DIR>/backups/content && ` +
+				`(test -e <SEG_DATA_DIR>/backups/content/$HASH && rm <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_1234.gz.tmp || ` +
+				`mv <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_1234.gz.tmp <SEG_DATA_DIR>/backups/content/$HASH) && ` +
+				`echo "1234 $HASH" > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_1234.ref`))
+			Expect(destination).ToNot(ContainSubstring("'"))
+		})
+	})
+	Describe("AddContentHashesToTOC", func() {
+		It("records the data file name of each table on each segment", func() {
+			testExecutor := &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{Commands: []cluster.ShellCommand{
+				{Content: 0, Stdout: "1234 aaaa.gz\n5678 bbbb.gz\n"},
+				{Content: 1, Stdout: "1234 cccc.gz\n"},
+			}}}
+			testCluster := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, DataDir: "/data/gpseg-1"}, {ContentID: 0, DataDir: "/data/gpseg0"}, {ContentID: 1, DataDir: "/data/gpseg1"}})
+			testCluster.Executor = testExecutor
+			dataEntries := []toc.MasterDataEntry{{Oid: 1234}, {Oid: 5678}, {Oid: 9012}}
+
+			backup.AddContentHashesToTOC(testCluster, filepath.NewFilePathInfo(testCluster, "", "20170101010101", ""), dataEntries)
+
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring("find /data/gpseg0/backups/20170101/20170101010101 -maxdepth 1 -name 'gpbackup_0_20170101010101_*.ref' -exec cat {} +"))
+			Expect(dataEntries[0].ContentHashes).To(Equal(map[int]string{0: "aaaa.gz", 1: "cccc.gz"}))
+			Expect(dataEntries[1].ContentHashes).To(Equal(map[int]string{0: "bbbb.gz"}))
+			Expect(dataEntries[2].ContentHashes).To(BeNil())
+		})
+		It("does not read reference files when every table's data was skipped", func() {
+			testExecutor := &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			testCluster := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, DataDir: "/data/gpseg-1"}, {ContentID: 0, DataDir: "/data/gpseg0"}})
+			testCluster.Executor = testExecutor
+
+			backup.AddContentHashesToTOC(testCluster, filepath.NewFilePathInfo(testCluster, "", "20170101010101", ""), []toc.MasterDataEntry{})
+
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+		})
+		It("tolerates a segment that wrote no reference files", func() {
+			testExecutor := &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{Commands: []cluster.ShellCommand{
+				{Content: 0, Stdout: "1234 aaaa.gz\n"},
+				{Content: 1, Stdout: ""},
+			}}}
+			testCluster := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, DataDir: "/data/gpseg-1"}, {ContentID: 0, DataDir: "/data/gpseg0"}, {ContentID: 1, DataDir: "/data/gpseg1"}})
+			testCluster.Executor = testExecutor
+			dataEntries := []toc.MasterDataEntry{{Oid: 1234}}
+
+			backup.AddContentHashesToTOC(testCluster, filepath.NewFilePathInfo(testCluster, "", "20170101010101", ""), dataEntries)
+
+			Expect(dataEntries[0].ContentHashes).To(Equal(map[int]string{0: "aaaa.gz"}))
+		})
+	})
 	Describe("BackupSingleTableData", func() {
 		var (
 			testTable     backup.Table
@@ -173,6 +316,15 @@ var _ = Describe("backup/data tests", func() {
 			Expect(rowsCopiedMap[0]).To(Equal(int64(10)))
 			Expect(counters.NumRegTables).To(Equal(int64(1)))
 		})
+		It("backs up a single regular table to a content-addressed file", func() {
+			_ = cmdFlags.Set(options.CONTENT_ADDRESSED, "true")
+
+			mock.ExpectExec(regexp.QuoteMeta(`> <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_0.tmp && HASH=`)).WillReturnResult(sqlmock.NewResult(0, 10))
+			err := backup.BackupSingleTableData(testTable, rowsCopiedMap, &counters, 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rowsCopiedMap[0]).To(Equal(int64(10)))
+		})
 		It("backs up a single external table", func() {
 			_ = cmdFlags.Set(options.LEAF_PARTITION_DATA, "false")
 			testTable.IsExternal = true
@@ -187,6 +339,15 @@ var _ = Describe("backup/data tests", func() {
 			testTable.ForeignDef = backup.ForeignTableDefinition{Oid: 23, Options: "", Server: "fs"}
 			err := backup.BackupSingleTableData(testTable, rowsCopiedMap, &counters, 0)
 
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rowsCopiedMap).To(BeEmpty())
+			Expect(counters.NumRegTables).To(Equal(int64(0)))
+		})
+		It("skips the data of a replicated table if --no-data-for-replicated-tables is set", func() {
+			_ = cmdFlags.Set(options.NO_REPLICATED_DATA, "true")
+			testTable.DistPolicy = "DISTRIBUTED REPLICATED"
+			err := backup.BackupSingleTableData(testTable, rowsCopiedMap, &counters, 0)
+
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(rowsCopiedMap).To(BeEmpty())
 			Expect(counters.NumRegTables).To(Equal(int64(0)))
@@ -223,4 +384,167 @@ var _ = Describe("backup/data tests", func() {
 			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeFalse())
 		})
 	})
+	Describe("CheckTablePrivileges", func() {
+		var (
+			tocfile     *toc.TOC
+			readable    backup.Table
+			unreadable  backup.Table
+			columnsOnly backup.Table
+		)
+		BeforeEach(func() {
+			tocfile = &toc.TOC{}
+			backup.SetTOC(tocfile)
+			backup.SetReport(&report.Report{})
+			readable = backup.Table{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "readable"}}
+			unreadable = backup.Table{Relation: backup.Relation{Oid: 2, Schema: "public", Name: "unreadable"}}
+			columnsOnly = backup.Table{Relation: backup.Relation{Oid: 3, Schema: "public", Name: "columns_only"}}
+		})
+		It("returns all tables if the backup role can read all of them", func() {
+			mock.ExpectQuery("SELECT c.oid").WillReturnRows(sqlmock.NewRows([]string{"oid", "columns"}))
+			tables := backup.CheckTablePrivileges([]backup.Table{readable, unreadable})
+			Expect(tables).To(Equal([]backup.Table{readable, unreadable}))
+			Expect(backup.GetReport().InaccessibleTables).To(BeEmpty())
+		})
+		It("panics listing every table the backup role cannot read", func() {
+			mock.ExpectQuery("SELECT c.oid").WillReturnRows(sqlmock.NewRows([]string{"oid", "columns"}).
+				AddRow(2, "").AddRow(3, "b, c"))
+			defer func() {
+				Expect(string(logfile.Contents())).To(ContainSubstring("Cannot back up data for table public.unreadable: missing SELECT privilege"))
+				Expect(string(logfile.Contents())).To(ContainSubstring("Cannot back up data for table public.columns_only: missing SELECT on columns b, c privilege"))
+				Expect(backup.GetReport().InaccessibleTables).To(Equal([]string{"public.unreadable (SELECT)", "public.columns_only (SELECT on columns b, c)"}))
+			}()
+			defer testhelper.ShouldPanicWithMessage("The backup role lacks SELECT privilege on 2 table(s). Use --skip-inaccessible-tables to back up only their metadata.")
+			backup.CheckTablePrivileges([]backup.Table{readable, unreadable, columnsOnly})
+		})
+		It("skips the data of tables the backup role cannot read with --skip-inaccessible-tables", func() {
+			_ = cmdFlags.Set(options.SKIP_INACCESSIBLE, "true")
+			mock.ExpectQuery("SELECT c.oid").WillReturnRows(sqlmock.NewRows([]string{"oid", "columns"}).AddRow(2, ""))
+			tables := backup.CheckTablePrivileges([]backup.Table{readable, unreadable})
+			Expect(tables).To(Equal([]backup.Table{readable}))
+			Expect(tocfile.SkippedDataEntries).To(Equal([]toc.SkippedDataEntry{{Schema: "public", Name: "unreadable", Reason: "INSUFFICIENT PRIVILEGES"}}))
+			Expect(backup.GetReport().InaccessibleTables).To(Equal([]string{"public.unreadable (SELECT)"}))
+			Expect(string(logfile.Contents())).To(ContainSubstring("Skipping data backup of table public.unreadable: missing SELECT privilege"))
+		})
+		It("does not check external tables", func() {
+			unreadable.IsExternal = true
+			tables := backup.CheckTablePrivileges([]backup.Table{unreadable})
+			Expect(tables).To(Equal([]backup.Table{unreadable}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+	Describe("ExcludeLeafPartitionData", func() {
+		leafOne := backup.Table{
+			Relation:        backup.Relation{Oid: 1, Schema: "public", Name: "sales_1_prt_2019"},
+			TableDefinition: backup.TableDefinition{PartitionLevelInfo: backup.PartitionLevelInfo{Level: "l", RootName: "sales"}},
+		}
+		leafTwo := backup.Table{
+			Relation:        backup.Relation{Oid: 2, Schema: "public", Name: "sales_1_prt_2020"},
+			TableDefinition: backup.TableDefinition{PartitionLevelInfo: backup.PartitionLevelInfo{Level: "l", RootName: "sales"}},
+		}
+		plainTable := backup.Table{Relation: backup.Relation{Oid: 3, Schema: "public", Name: "orders"}}
+		expectQuotedNames := func(schema string, name string) {
+			mock.ExpectQuery("SELECT quote_ident").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename"}).AddRow(schema, name))
+		}
+		It("does nothing without --exclude-table-data", func() {
+			tables := backup.ExcludeLeafPartitionData([]backup.Table{leafOne, leafTwo})
+			Expect(tables[0].SkipDataBackup()).To(BeFalse())
+			Expect(tables[1].SkipDataBackup()).To(BeFalse())
+		})
+		It("skips the data of the given leaf partitions only", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_DATA, "public.sales_1_prt_2019")
+			expectQuotedNames("public", "sales_1_prt_2019")
+
+			tables := backup.ExcludeLeafPartitionData([]backup.Table{leafOne, leafTwo, plainTable})
+
+			Expect(tables[0].DataSkipReason()).To(Equal(toc.SKIP_REASON_EXCLUDED))
+			Expect(tables[1].SkipDataBackup()).To(BeFalse())
+			Expect(tables[2].SkipDataBackup()).To(BeFalse())
+			Expect(string(logfile.Contents())).To(ContainSubstring("Excluding the data of 1 leaf partition(s) given in --exclude-table-data"))
+		})
+		It("warns about tables that are not in the backup set", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_DATA, "public.sales_1_prt_2018")
+			expectQuotedNames("public", "sales_1_prt_2018")
+
+			tables := backup.ExcludeLeafPartitionData([]backup.Table{leafOne})
+
+			Expect(tables[0].SkipDataBackup()).To(BeFalse())
+			Expect(string(logfile.Contents())).To(ContainSubstring("Table public.sales_1_prt_2018 given in --exclude-table-data is not in the backup set"))
+		})
+		It("panics if a table is not a leaf partition", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_DATA, "public.orders")
+			expectQuotedNames("public", "orders")
+			defer testhelper.ShouldPanicWithMessage("Table public.orders given in --exclude-table-data is not a leaf partition")
+			backup.ExcludeLeafPartitionData([]backup.Table{leafOne, plainTable})
+		})
+	})
+	Describe("SetColumnSubstitutions", func() {
+		var table backup.Table
+		BeforeEach(func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			backup.SetReport(&report.Report{})
+			table = backup.Table{
+				Relation: backup.Relation{Oid: 1, Schema: "public", Name: "docs"},
+				TableDefinition: backup.TableDefinition{ColumnDefs: []backup.ColumnDefinition{
+					{Name: "id", Type: "integer", NotNull: true},
+					{Name: "body", Type: "bytea"},
+					{Name: "kind", Type: "text", NotNull: true},
+				}},
+			}
+		})
+		expectQuotedNames := func(schema string, name string) {
+			mock.ExpectQuery("SELECT quote_ident").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename"}).AddRow(schema, name))
+		}
+		It("does nothing without --exclude-column-data", func() {
+			tables := backup.SetColumnSubstitutions([]backup.Table{table})
+			Expect(tables[0].ColumnSubstitutions).To(BeNil())
+			Expect(backup.GetReport().PartialDataTables).To(BeEmpty())
+		})
+		It("substitutes NULL or the given constant and flags the table in the report", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_COLUMN_DATA, "public.docs.body")
+			_ = cmdFlags.Set(options.EXCLUDE_COLUMN_DATA, "public.docs.kind=it's archived")
+			expectQuotedNames("public", "docs")
+			expectQuotedNames("public", "docs")
+
+			tables := backup.SetColumnSubstitutions([]backup.Table{table})
+
+			Expect(tables[0].ColumnSubstitutions).To(Equal(map[string]string{"body": "NULL", "kind": "'it''s archived'::text"}))
+			Expect(backup.ConstructColumnSubstitutionQuery(tables[0])).To(Equal("SELECT id, NULL AS body, 'it''s archived'::text AS kind FROM public.docs"))
+			Expect(backup.GetReport().PartialDataTables).To(Equal([]string{"public.docs (data not backed up for columns body, kind)"}))
+		})
+		It("leaves stored generated columns out of the query", func() {
+			table.ColumnDefs = append(table.ColumnDefs, backup.ColumnDefinition{Name: "size", Type: "integer", GenerationExpr: "length(body)"})
+			table.ColumnSubstitutions = map[string]string{"body": "NULL"}
+			Expect(backup.ConstructColumnSubstitutionQuery(table)).To(Equal("SELECT id, NULL AS body, kind FROM public.docs"))
+		})
+		It("panics if the column is a generated column", func() {
+			table.ColumnDefs = append(table.ColumnDefs, backup.ColumnDefinition{Name: "size", Type: "integer", GenerationExpr: "length(body)"})
+			_ = cmdFlags.Set(options.EXCLUDE_COLUMN_DATA, "public.docs.size")
+			expectQuotedNames("public", "docs")
+			defer testhelper.ShouldPanicWithMessage("Column size of table public.docs is a generated column, whose data is never backed up")
+			backup.SetColumnSubstitutions([]backup.Table{table})
+		})
+		It("panics if a NOT NULL column is not given a value", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_COLUMN_DATA, "public.docs.kind")
+			expectQuotedNames("public", "docs")
+			defer testhelper.ShouldPanicWithMessage("Column kind of table public.docs is NOT NULL, so --exclude-column-data must give a value to back up in place of its data, as in public.docs.kind=value")
+			backup.SetColumnSubstitutions([]backup.Table{table})
+		})
+		It("panics if the column does not exist", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_COLUMN_DATA, "public.docs.title")
+			expectQuotedNames("public", "docs")
+			defer testhelper.ShouldPanicWithMessage("Column title given in --exclude-column-data does not exist in table public.docs")
+			backup.SetColumnSubstitutions([]backup.Table{table})
+		})
+		It("panics if the table is not in the backup set", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_COLUMN_DATA, "public.other.body")
+			mock.ExpectQuery("SELECT quote_ident").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename"}).AddRow("public", "other"))
+			defer testhelper.ShouldPanicWithMessage("Table public.other given in --exclude-column-data is not in the backup set")
+			backup.SetColumnSubstitutions([]backup.Table{table})
+		})
+		It("panics if an entry does not name a column", func() {
+			_ = cmdFlags.Set(options.EXCLUDE_COLUMN_DATA, "docs")
+			defer testhelper.ShouldPanicWithMessage("Invalid --exclude-column-data entry docs.  Entries must be of the form schema.table.column or schema.table.column=value.")
+			backup.SetColumnSubstitutions([]backup.Table{table})
+		})
+	})
 })
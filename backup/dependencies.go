@@ -249,7 +249,7 @@ func PrintDependentObjectStatements(metadataFile *utils.FileWithByteCount, toc *
 	for _, constraint := range constraints {
 		conMap[constraint.OwningObject] = append(conMap[constraint.OwningObject], constraint)
 	}
-	for _, object := range objects {
+	for i, object := range objects {
 		objMetadata := metadataMap[object.GetUniqueID()]
 		switch obj := object.(type) {
 		case BaseType:
@@ -293,6 +293,8 @@ func PrintDependentObjectStatements(metadataFile *utils.FileWithByteCount, toc *
 		}
 		// Remove ACLs from metadataMap for the current object since they have been processed
 		delete(metadataMap, object.GetUniqueID())
+		// Release the object itself, as its statements have already been written to the file
+		objects[i] = nil
 	}
 	//  Process ACLs for left over objects in the metadata map
 	printExtensionFunctionACLs(metadataFile, toc, metadataMap, funcInfoMap)
@@ -2,10 +2,18 @@ package backup_test
 
 import (
 	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
 	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -227,5 +235,121 @@ CREATE TRUSTED PROTOCOL ext_protocol (readfunc = public.read_from_s3, writefunc
 COMMENT ON PROTOCOL ext_protocol IS 'protocol';
 `)
 		})
+		It("releases each object once its statements are printed", func() {
+			backup.PrintDependentObjectStatements(backupfile, tocfile, objects, metadataMap, []backup.Constraint{}, funcInfoMap)
+			for _, object := range objects {
+				Expect(object).To(BeNil())
+			}
+			Expect(metadataMap).To(BeEmpty())
+		})
 	})
 })
+
+/*
+ * Backs up the metadata of a synthetic catalog of 500,000 objects, half
+ * tables and half functions, each with an owner and privileges, and reports
+ * the peak heap held from the system while the metadata is printed and the
+ * heap still in use when the data backup would begin.
+ */
+func benchmarkMetadataBackupMemory(b *testing.B, lowMemory bool) {
+	conn, _, _, _, _ := testutils.SetupTestEnvironment()
+	backup.SetConnection(conn)
+	backup.SetCmdFlags(pflag.NewFlagSet("gpbackup", pflag.ExitOnError))
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+	defer utils.SetLowMemoryMode(false)
+
+	const numObjects = 500000
+	var peakHeap, dataPhaseHeap uint64
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		debug.FreeOSMemory()
+		utils.SetLowMemoryMode(lowMemory)
+		done := make(chan bool)
+		peak := make(chan uint64)
+		go func() {
+			var stats runtime.MemStats
+			var max uint64
+			ticker := time.NewTicker(5 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				runtime.ReadMemStats(&stats)
+				if heap := stats.HeapSys - stats.HeapReleased; heap > max {
+					max = heap
+				}
+				select {
+				case <-done:
+					peak <- max
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		b.StartTimer()
+
+		metadataTables := make([]backup.Table, 0, numObjects/2)
+		objects := make([]backup.Sortable, 0, numObjects)
+		metadataMap := make(backup.MetadataMap, numObjects)
+		for oid := uint32(1); oid <= numObjects/2; oid++ {
+			table := backup.Table{
+				Relation: backup.Relation{Oid: oid, Schema: "public", Name: fmt.Sprintf("table_%d", oid)},
+				TableDefinition: backup.TableDefinition{
+					DistPolicy: "DISTRIBUTED BY (id)",
+					PartDef:    fmt.Sprintf("PARTITION BY RANGE(id) (START (%d) END (%d) EVERY (1000))", oid, oid+100000),
+					ColumnDefs: []backup.ColumnDefinition{
+						{Num: 1, Name: "id", Type: "integer", StatTarget: -1},
+						{Num: 2, Name: fmt.Sprintf("value_%d", oid), Type: "text", StatTarget: -1},
+					},
+				},
+			}
+			metadataTables = append(metadataTables, table)
+			objects = append(objects, table)
+			metadataMap[table.GetUniqueID()] = backup.ObjectMetadata{Owner: "table_owner", ObjectType: "RELATION",
+				Privileges: []backup.ACL{{Grantee: fmt.Sprintf("reader_%d", oid%100), Select: true}}}
+		}
+		for oid := uint32(numObjects/2 + 1); oid <= numObjects; oid++ {
+			function := backup.Function{Oid: oid, Schema: "public", Name: fmt.Sprintf("function_%d", oid),
+				FunctionBody: fmt.Sprintf("SELECT $1 + %d", oid), Language: "sql",
+				Arguments:  sql.NullString{String: "integer", Valid: true},
+				IdentArgs:  sql.NullString{String: "integer", Valid: true},
+				ResultType: sql.NullString{String: "integer", Valid: true}}
+			objects = append(objects, function)
+			metadataMap[function.GetUniqueID()] = backup.ObjectMetadata{Owner: "function_owner", ObjectType: "FUNCTION",
+				Privileges: []backup.ACL{{Grantee: fmt.Sprintf("caller_%d", oid%100), Execute: true}}}
+		}
+		dataTables := make([]backup.Table, len(metadataTables))
+		copy(dataTables, metadataTables)
+
+		tocfile, metadataFile := testutils.InitializeTestTOC(ioutil.Discard, "predata")
+		sorted := backup.TopologicalSort(objects, backup.DependencyMap{})
+		objects = nil
+		backup.PrintDependentObjectStatements(metadataFile, tocfile, sorted, metadataMap, []backup.Constraint{}, map[uint32]backup.FunctionInfo{})
+		sorted, metadataMap = nil, nil
+		if lowMemory {
+			backup.ReleaseMetadataDefinitions(dataTables)
+			metadataTables = nil
+			utils.EndMemoryStage("releasing table definitions")
+		}
+
+		b.StopTimer()
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		dataPhaseHeap += stats.HeapInuse
+		close(done)
+		peakHeap += <-peak
+		runtime.KeepAlive(metadataTables)
+		runtime.KeepAlive(dataTables)
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(peakHeap)/float64(b.N)/(1<<20), "peak-heap-MB")
+	b.ReportMetric(float64(dataPhaseHeap)/float64(b.N)/(1<<20), "data-phase-heap-MB")
+}
+
+func BenchmarkMetadataBackupMemory(b *testing.B) {
+	benchmarkMetadataBackupMemory(b, false)
+}
+
+func BenchmarkMetadataBackupMemoryLowMemory(b *testing.B) {
+	benchmarkMetadataBackupMemory(b, true)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
@@ -35,6 +36,23 @@ var (
 	backupLockFile       lockfile.Lockfile
 	filterRelationClause string
 	quotedRoleNames      map[string]string
+	retryAttempt         int
+	flagsValidated       bool
+	logCounter           *report.LogCounter
+	runStats             *history.RunStats
+	/*
+	 * Types from excluded schemas that are backed up because tables in the
+	 * backup use them, with --include-type-dependencies.
+	 */
+	includedTypeDependencies []ExcludedSchemaDependency
+	// Set when --include-table-query returns no tables, so that no backup is taken
+	nothingToBackUp bool
+	/*
+	 * The snapshot exported by connection 0 and imported by the others in
+	 * GPDB 7+, so that every connection sees the same catalog.  Empty when
+	 * each connection has a snapshot of its own.
+	 */
+	synchronizedSnapshot string
 	/*
 	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
 	 * and then wait for at least one DoCleanup to finish, either in DoTeardown
@@ -93,10 +111,18 @@ func SetFilterRelationClause(filterClause string) {
 	filterRelationClause = filterClause
 }
 
+func SetIncludedTypeDependencies(dependencies []ExcludedSchemaDependency) {
+	includedTypeDependencies = dependencies
+}
+
 func SetQuotedRoleNames(quotedRoles map[string]string) {
 	quotedRoleNames = quotedRoles
 }
 
+func SetSynchronizedSnapshot(snapshot string) {
+	synchronizedSnapshot = snapshot
+}
+
 // Util functions to enable ease of access to global flag values
 
 func MustGetFlagString(flagName string) string {
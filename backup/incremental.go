@@ -5,6 +5,7 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/toc"
@@ -30,6 +31,68 @@ func FilterTablesForIncremental(lastBackupTOC, currentTOC *toc.TOC, tables []Tab
 	return filteredTables
 }
 
+/*
+ * AO tables are compared by modcount and last DDL timestamp as in an
+ * incremental backup, and other tables by their statistics counters and
+ * relfilenode.  A table missing from either snapshot is treated as changed.
+ */
+func FilterTablesChangedSince(lastBackupTOC, currentTOC *toc.TOC, tables []Table) []Table {
+	filteredTables := make([]Table, 0)
+	for _, table := range tables {
+		if currentAOEntry, isAOTable := currentTOC.IncrementalMetadata.AO[table.FQN()]; isAOTable {
+			previousAOEntry, ok := lastBackupTOC.IncrementalMetadata.AO[table.FQN()]
+			if !ok || previousAOEntry != currentAOEntry {
+				filteredTables = append(filteredTables, table)
+			}
+			continue
+		}
+		currentHeapEntry, hasCurrentEntry := currentTOC.IncrementalMetadata.Heap[table.FQN()]
+		previousHeapEntry, hasPreviousEntry := lastBackupTOC.IncrementalMetadata.Heap[table.FQN()]
+		if !hasCurrentEntry || !hasPreviousEntry || previousHeapEntry != currentHeapEntry {
+			filteredTables = append(filteredTables, table)
+		}
+	}
+	return filteredTables
+}
+
+/*
+ * Returns the backup whose table activity is compared against: the backup
+ * with the given timestamp, or else the latest one taken before it.  Only
+ * backups that include both metadata and data record table activity.
+ */
+func GetChangedSinceBackupConfig(history *history.History, databaseName string, changedSince string) *history.BackupConfig {
+	for _, backupConfig := range history.BackupConfigs {
+		if backupConfig.DatabaseName == databaseName && backupConfig.BackupDir == MustGetFlagString(options.BACKUP_DIR) &&
+			!backupConfig.Failed() && !backupConfig.MetadataOnly && !backupConfig.DataOnly && backupConfig.Timestamp <= changedSince {
+			return &backupConfig
+		}
+	}
+	return nil
+}
+
+func GetChangedSinceBackupTimestamp(changedSince string) string {
+	var changedSinceBackupConfig *history.BackupConfig
+	if iohelper.FileExistsAndIsReadable(globalFPInfo.GetBackupHistoryFilePath()) {
+		contents, err := history.NewHistory(globalFPInfo.GetBackupHistoryFilePath())
+		gplog.FatalOnError(err)
+		changedSinceBackupConfig = GetChangedSinceBackupConfig(contents, backupReport.DatabaseName, changedSince)
+	}
+	if changedSinceBackupConfig == nil {
+		gplog.Fatal(errors.Errorf("No backup of database %s with both metadata and data was taken at or before %s to use with --%s. "+
+			"Please take a full backup.", backupReport.DatabaseName, changedSince, options.CHANGED_SINCE), "")
+	}
+	return changedSinceBackupConfig.Timestamp
+}
+
+func readChangedSinceTOC(fpInfo filepath.FilePathInfo) *toc.TOC {
+	changedSinceTOC := toc.NewTOC(fpInfo.GetTOCFilePath())
+	if changedSinceTOC.IncrementalMetadata.Heap == nil {
+		gplog.Fatal(errors.Errorf("Backup %s does not record table activity, as it was taken by an older version of gpbackup. "+
+			"Please specify a later backup with --%s.", fpInfo.Timestamp, options.CHANGED_SINCE), "")
+	}
+	return changedSinceTOC
+}
+
 func GetTargetBackupTimestamp() string {
 	targetTimestamp := ""
 	if fromTimestamp := MustGetFlagString(options.FROM_TIMESTAMP); fromTimestamp != "" {
@@ -74,7 +137,9 @@ func GetLatestMatchingBackupConfig(history *history.History, currentBackupConfig
 
 func matchesIncrementalFlags(backupConfig *history.BackupConfig, currentBackupConfig *history.BackupConfig) bool {
 	_, pluginBinaryName := path.Split(backupConfig.Plugin)
-	return backupConfig.BackupDir == MustGetFlagString(options.BACKUP_DIR) &&
+	// The restore plan of a heuristic incremental backup only covers the tables it backed up
+	return !backupConfig.HeuristicIncremental &&
+		backupConfig.BackupDir == MustGetFlagString(options.BACKUP_DIR) &&
 		backupConfig.DatabaseName == currentBackupConfig.DatabaseName &&
 		backupConfig.LeafPartitionData == MustGetFlagBool(options.LEAF_PARTITION_DATA) &&
 		pluginBinaryName == currentBackupConfig.Plugin &&
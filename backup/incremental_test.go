@@ -78,6 +78,71 @@ var _ = Describe("backup/incremental tests", func() {
 		})
 	})
 
+	Describe("FilterTablesChangedSince", func() {
+		prevTOC := toc.TOC{
+			IncrementalMetadata: toc.IncrementalEntries{
+				AO: map[string]toc.AOEntry{
+					"public.ao_changed":   {Modcount: 0, LastDDLTimestamp: "00000"},
+					"public.ao_unchanged": {Modcount: 0, LastDDLTimestamp: "00000"},
+				},
+				Heap: map[string]toc.HeapEntry{
+					"public.heap_inserted":  {TuplesInserted: 10, RelFileNode: 16384},
+					"public.heap_truncated": {TuplesInserted: 10, RelFileNode: 16385},
+					"public.heap_unchanged": {TuplesInserted: 10, RelFileNode: 16386},
+				},
+			},
+		}
+		currTOC := toc.TOC{
+			IncrementalMetadata: toc.IncrementalEntries{
+				AO: map[string]toc.AOEntry{
+					"public.ao_changed":   {Modcount: 1, LastDDLTimestamp: "00000"},
+					"public.ao_unchanged": {Modcount: 0, LastDDLTimestamp: "00000"},
+				},
+				Heap: map[string]toc.HeapEntry{
+					"public.heap_inserted":  {TuplesInserted: 11, RelFileNode: 16384},
+					"public.heap_truncated": {TuplesInserted: 10, RelFileNode: 16390},
+					"public.heap_unchanged": {TuplesInserted: 10, RelFileNode: 16386},
+					"public.heap_new":       {RelFileNode: 16391},
+				},
+			},
+		}
+
+		tblAOChanged := backup.Table{Relation: backup.Relation{Schema: "public", Name: "ao_changed"}}
+		tblAOUnchanged := backup.Table{Relation: backup.Relation{Schema: "public", Name: "ao_unchanged"}}
+		tblHeapInserted := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_inserted"}}
+		tblHeapTruncated := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_truncated"}}
+		tblHeapUnchanged := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_unchanged"}}
+		tblHeapNew := backup.Table{Relation: backup.Relation{Schema: "public", Name: "heap_new"}}
+		tables := []backup.Table{tblAOChanged, tblAOUnchanged, tblHeapInserted, tblHeapTruncated, tblHeapUnchanged, tblHeapNew}
+
+		filteredTables := backup.FilterTablesChangedSince(&prevTOC, &currTOC, tables)
+
+		It("includes the tables whose activity or relfilenode changed", func() {
+			Expect(filteredTables).To(Equal([]backup.Table{tblAOChanged, tblHeapInserted, tblHeapTruncated, tblHeapNew}))
+		})
+	})
+
+	Describe("GetChangedSinceBackupConfig", func() {
+		contents := history.History{BackupConfigs: []history.BackupConfig{
+			{DatabaseName: "test1", Timestamp: "20200105000000"},
+			{DatabaseName: "test1", Timestamp: "20200104000000", DataOnly: true},
+			{DatabaseName: "test1", Timestamp: "20200103000000", Status: history.BackupStatusFailed},
+			{DatabaseName: "test2", Timestamp: "20200102000000"},
+			{DatabaseName: "test1", Timestamp: "20200101000000"},
+		}}
+		It("returns the backup with the given timestamp", func() {
+			backupConfig := backup.GetChangedSinceBackupConfig(&contents, "test1", "20200105000000")
+			structmatcher.ExpectStructsToMatch(contents.BackupConfigs[0], backupConfig)
+		})
+		It("returns the latest complete backup taken before the given timestamp", func() {
+			backupConfig := backup.GetChangedSinceBackupConfig(&contents, "test1", "20200104120000")
+			structmatcher.ExpectStructsToMatch(contents.BackupConfigs[4], backupConfig)
+		})
+		It("returns nil if no backup was taken before the given timestamp", func() {
+			Expect(backup.GetChangedSinceBackupConfig(&contents, "test2", "20200101000000")).To(BeNil())
+		})
+	})
+
 	Describe("GetLatestMatchingBackupConfig", func() {
 		contents := history.History{BackupConfigs: []history.BackupConfig{
 			{DatabaseName: "test2", Timestamp: "timestamp4", Status: history.BackupStatusFailed},
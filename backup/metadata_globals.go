@@ -21,6 +21,9 @@ func PrintSessionGUCs(metadataFile *utils.FileWithByteCount, toc *toc.TOC, gucs
 	metadataFile.MustPrintf(`
 SET client_encoding = '%s';
 `, gucs.ClientEncoding)
+	if gucs.DefaultTableAccessMethod != "" {
+		metadataFile.MustPrintf("SET default_table_access_method = %s;\n", gucs.DefaultTableAccessMethod)
+	}
 
 	section, entry := gucs.GetMetadataEntry()
 	toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
@@ -21,6 +21,14 @@ var _ = Describe("backup/metadata_globals tests", func() {
 
 			backup.PrintSessionGUCs(backupfile, tocfile, gucs)
 			testhelper.ExpectRegexp(buffer, `SET client_encoding = 'UTF8';
+`)
+		})
+		It("prints a default table access method", func() {
+			gucs := backup.SessionGUCs{ClientEncoding: "UTF8", DefaultTableAccessMethod: "ao_row"}
+
+			backup.PrintSessionGUCs(backupfile, tocfile, gucs)
+			testhelper.ExpectRegexp(buffer, `SET client_encoding = 'UTF8';
+SET default_table_access_method = ao_row;
 `)
 		})
 	})
@@ -26,22 +26,48 @@ func PrintCreateIndexStatements(metadataFile *utils.FileWithByteCount, toc *toc.
 				metadataFile.MustPrintf("\nALTER INDEX %s SET TABLESPACE %s;", indexFQN, index.Tablespace)
 				toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
 			}
-			tableFQN := utils.MakeFQN(index.OwningSchema, index.OwningTable)
-			if index.IsClustered {
-				start := metadataFile.ByteCount
-				metadataFile.MustPrintf("\nALTER TABLE %s CLUSTER ON %s;", tableFQN, index.Name)
-				toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
-			}
-			if index.IsReplicaIdentity {
-				start := metadataFile.ByteCount
-				metadataFile.MustPrintf("\nALTER TABLE %s REPLICA IDENTITY USING INDEX %s;", tableFQN, index.Name)
-				toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
-			}
 		}
 		PrintObjectMetadata(metadataFile, toc, indexMetadata[index.GetUniqueID()], index, "")
 	}
 }
 
+/*
+ * A table is clustered on at most one index, which may be one created by a
+ * constraint, so the clustering is printed separately from the indexes and
+ * restored once all of them have been created.
+ */
+func PrintClusterStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, indexes []IndexDefinition) {
+	clusteredTables := make(map[string]bool)
+	for _, index := range indexes {
+		tableFQN := utils.MakeFQN(index.OwningSchema, index.OwningTable)
+		if !index.IsClustered || clusteredTables[tableFQN] {
+			continue
+		}
+		clusteredTables[tableFQN] = true
+		start := metadataFile.ByteCount
+		metadataFile.MustPrintf("\n\nALTER TABLE %s CLUSTER ON %s;", tableFQN, index.Name)
+		section, entry := index.GetClusterMetadataEntry()
+		toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+	}
+}
+
+/*
+ * The index a table uses as its replica identity may be one created by a
+ * constraint, so like the clustering, the replica identity is printed once
+ * all of the indexes have been created.
+ */
+func PrintReplicaIdentityStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, tables []Table) {
+	for _, table := range tables {
+		if table.ReplicaIdentity != "i" || table.ReplicaIdentityIndex == "" {
+			continue
+		}
+		start := metadataFile.ByteCount
+		metadataFile.MustPrintf("\n\nALTER TABLE %s REPLICA IDENTITY USING INDEX %s;", table.FQN(), table.ReplicaIdentityIndex)
+		section, entry := table.GetReplicaIdentityMetadataEntry()
+		toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+	}
+}
+
 func PrintCreateRuleStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, rules []RuleDefinition, ruleMetadata MetadataMap) {
 	for _, rule := range rules {
 		start := metadataFile.ByteCount
@@ -7,6 +7,8 @@ import (
 	"github.com/greenplum-db/gpbackup/testutils"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("backup/postdata tests", func() {
@@ -25,13 +27,12 @@ var _ = Describe("backup/postdata tests", func() {
 			testutils.ExpectEntry(tocfile.PostdataEntries, 0, "public", "public.testtable", "testindex", "INDEX")
 			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, `CREATE INDEX testindex ON public.testtable USING btree(i);`)
 		})
-		It("can print an index used for clustering", func() {
+		It("does not print clustering with an index used for clustering", func() {
 			index.IsClustered = true
 			indexes := []backup.IndexDefinition{index}
 			backup.PrintCreateIndexStatements(backupfile, tocfile, indexes, emptyMetadataMap)
 			testutils.ExpectEntry(tocfile.PostdataEntries, 0, "public", "public.testtable", "testindex", "INDEX")
-			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "CREATE INDEX testindex ON public.testtable USING btree(i);",
-				"ALTER TABLE public.testtable CLUSTER ON testindex;")
+			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "CREATE INDEX testindex ON public.testtable USING btree(i);")
 		})
 		It("can print an index with a tablespace", func() {
 			index.Tablespace = "test_tablespace"
@@ -47,14 +48,78 @@ var _ = Describe("backup/postdata tests", func() {
 			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "CREATE INDEX testindex ON public.testtable USING btree(i);",
 				"COMMENT ON INDEX public.testindex IS 'This is an index comment.';")
 		})
-		It("can print an index that is a replica identity", func() {
+		It("does not print the replica identity of an index's table", func() {
 			index.IsReplicaIdentity = true
 			indexes := []backup.IndexDefinition{index}
 			backup.PrintCreateIndexStatements(backupfile, tocfile, indexes, emptyMetadataMap)
 			testutils.ExpectEntry(tocfile.PostdataEntries, 0, "public", "public.testtable", "testindex", "INDEX")
+			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "CREATE INDEX testindex ON public.testtable USING btree(i);")
+		})
+	})
+	Context("PrintReplicaIdentityStatements", func() {
+		var table backup.Table
+		BeforeEach(func() {
+			table = backup.Table{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "testtable"}}
+		})
+		DescribeTable("prints nothing for replica identities set when the table is created",
+			func(identity string) {
+				table.ReplicaIdentity = identity
+				backup.PrintReplicaIdentityStatements(backupfile, tocfile, []backup.Table{table})
+				Expect(tocfile.PostdataEntries).To(BeEmpty())
+				Expect(buffer.Contents()).To(BeEmpty())
+			},
+			Entry("default", "d"),
+			Entry("nothing", "n"),
+			Entry("full", "f"),
+		)
+		It("prints the index used as a replica identity", func() {
+			table.ReplicaIdentity = "i"
+			table.ReplicaIdentityIndex = "testindex"
+			backup.PrintReplicaIdentityStatements(backupfile, tocfile, []backup.Table{table})
+			testutils.ExpectEntry(tocfile.PostdataEntries, 0, "public", "public.testtable", "testtable", "REPLICA IDENTITY")
+			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "ALTER TABLE public.testtable REPLICA IDENTITY USING INDEX testindex;")
+		})
+		It("prints nothing for an index replica identity whose index was not found", func() {
+			table.ReplicaIdentity = "i"
+			backup.PrintReplicaIdentityStatements(backupfile, tocfile, []backup.Table{table})
+			Expect(tocfile.PostdataEntries).To(BeEmpty())
+		})
+	})
+	Context("PrintClusterStatements", func() {
+		var index backup.IndexDefinition
+		BeforeEach(func() {
+			index = backup.IndexDefinition{Oid: 1, Name: "testindex", OwningSchema: "public", OwningTable: "testtable", Def: sql.NullString{String: "CREATE INDEX testindex ON public.testtable USING btree(i)", Valid: true}}
+		})
+		It("prints nothing for indexes not used for clustering", func() {
+			backup.PrintClusterStatements(backupfile, tocfile, []backup.IndexDefinition{index})
+			Expect(tocfile.PostdataEntries).To(BeEmpty())
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+		It("prints the clustering of a table with its own entry", func() {
+			index.IsClustered = true
+			backup.PrintClusterStatements(backupfile, tocfile, []backup.IndexDefinition{index})
+			testutils.ExpectEntry(tocfile.PostdataEntries, 0, "public", "public.testtable", "testtable", "CLUSTER")
+			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "ALTER TABLE public.testtable CLUSTER ON testindex;")
+		})
+		It("prints the clustering of a table on an index supporting a constraint", func() {
+			index.Name = "testtable_pkey"
+			index.IsClustered = true
+			index.SupportsConstraint = true
+			backup.PrintClusterStatements(backupfile, tocfile, []backup.IndexDefinition{index})
+			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "ALTER TABLE public.testtable CLUSTER ON testtable_pkey;")
+		})
+		It("prints one statement per table when a table has several indexes", func() {
+			index.IsClustered = true
+			index2 := backup.IndexDefinition{Oid: 2, Name: "testindex2", OwningSchema: "public", OwningTable: "testtable", IsClustered: true}
+			index3 := backup.IndexDefinition{Oid: 3, Name: "testindex3", OwningSchema: "public", OwningTable: "testtable"}
+			index4 := backup.IndexDefinition{Oid: 4, Name: "testindex4", OwningSchema: "public", OwningTable: "testtable2", IsClustered: true}
+			backup.PrintClusterStatements(backupfile, tocfile, []backup.IndexDefinition{index, index2, index3, index4})
+			Expect(tocfile.PostdataEntries).To(HaveLen(2))
+			testutils.ExpectEntry(tocfile.PostdataEntries, 0, "public", "public.testtable", "testtable", "CLUSTER")
+			testutils.ExpectEntry(tocfile.PostdataEntries, 1, "public", "public.testtable2", "testtable2", "CLUSTER")
 			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer,
-				"CREATE INDEX testindex ON public.testtable USING btree(i);",
-				"ALTER TABLE public.testtable REPLICA IDENTITY USING INDEX testindex;",
+				"ALTER TABLE public.testtable CLUSTER ON testindex;",
+				"ALTER TABLE public.testtable2 CLUSTER ON testindex4;",
 			)
 		})
 	})
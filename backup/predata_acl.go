@@ -7,12 +7,17 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 )
 
 var ACLRegex = regexp.MustCompile(`^(.*)=([a-zA-Z\*]*)/(.*)$`)
 
+// The maximum size of a batch of privileges statements for a single object
+const STATEMENT_BATCH_SIZE = 1024 * 1024
+
 /*
  * Structs and functions relating to generic metadata handling.
  */
@@ -28,6 +33,7 @@ type ObjectMetadata struct {
 
 type ACL struct {
 	Grantee             string
+	Grantor             string
 	Select              bool
 	SelectWithGrant     bool
 	Insert              bool
@@ -56,18 +62,59 @@ type ACL struct {
 
 type MetadataMap map[UniqueID]ObjectMetadata
 
-func PrintStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC,
+func PrintStatements(metadataFile *utils.FileWithByteCount, tocfile *toc.TOC,
 	obj toc.TOCObject, statements []string) {
 	for _, statement := range statements {
 		start := metadataFile.ByteCount
 		metadataFile.MustPrintf("\n\n%s\n", statement)
 		section, entry := obj.GetMetadataEntry()
-		toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+		if len(statement) > toc.MAX_STATEMENT_SIZE {
+			report.Warn(report.WARN_STATEMENT_TOO_LARGE, entry.FQN(), "Statement for %s %s is %d bytes, which exceeds the maximum statement size of %d bytes; it cannot be restored", entry.ObjectType, entry.FQN(), len(statement), toc.MAX_STATEMENT_SIZE)
+		}
+		tocfile.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
 	}
 }
 
+/*
+ * An object with privileges granted to tens of thousands of roles gets one
+ * GRANT statement per role, so we split such lists into batches of at most
+ * maxSize bytes, each with its own TOC entry, rather than restoring them all as
+ * a single enormous statement.  Statements are only split where a line ends
+ * with a semicolon, as a quoted identifier may itself contain a newline.
+ */
+func BatchStatements(statements string, maxSize int) []string {
+	if len(statements) <= maxSize {
+		return []string{statements}
+	}
+	batches := make([]string, 0)
+	var batch, statement strings.Builder
+	for _, line := range strings.SplitAfter(statements, "\n") {
+		statement.WriteString(line)
+		if !strings.HasSuffix(strings.TrimSpace(line), ";") {
+			continue
+		}
+		if batch.Len() > 0 && batch.Len()+statement.Len() > maxSize {
+			batches = append(batches, strings.TrimSpace(batch.String()))
+			batch.Reset()
+		}
+		batch.WriteString(statement.String())
+		statement.Reset()
+	}
+	batch.WriteString(statement.String())
+	if strings.TrimSpace(batch.String()) != "" {
+		batches = append(batches, strings.TrimSpace(batch.String()))
+	}
+	return batches
+}
+
 func PrintObjectMetadata(metadataFile *utils.FileWithByteCount, toc *toc.TOC,
 	metadata ObjectMetadata, obj toc.TOCObjectWithMetadata, owningTable string) {
+	printObjectMetadata(metadataFile, toc, metadata, obj, owningTable, true)
+}
+
+// Objects whose owner is set in their CREATE statement pass includeOwner as false
+func printObjectMetadata(metadataFile *utils.FileWithByteCount, toc *toc.TOC,
+	metadata ObjectMetadata, obj toc.TOCObjectWithMetadata, owningTable string, includeOwner bool) {
 	_, entry := obj.GetMetadataEntry()
 	if entry.ObjectType == "DATABASE METADATA" {
 		entry.ObjectType = "DATABASE"
@@ -76,14 +123,14 @@ func PrintObjectMetadata(metadataFile *utils.FileWithByteCount, toc *toc.TOC,
 	if comment := metadata.GetCommentStatement(obj.FQN(), entry.ObjectType, owningTable); comment != "" {
 		statements = append(statements, strings.TrimSpace(comment))
 	}
-	if owner := metadata.GetOwnerStatement(obj.FQN(), entry.ObjectType); owner != "" {
+	if owner := metadata.GetOwnerStatement(obj.FQN(), entry.ObjectType); owner != "" && includeOwner {
 		if !(connectionPool.Version.Before("5") && entry.ObjectType == "LANGUAGE") {
 			// Languages have implicit owners in 4.3, but do not support ALTER OWNER
 			statements = append(statements, strings.TrimSpace(owner))
 		}
 	}
 	if privileges := metadata.GetPrivilegesStatements(obj.FQN(), entry.ObjectType); privileges != "" {
-		statements = append(statements, strings.TrimSpace(privileges))
+		statements = append(statements, BatchStatements(strings.TrimSpace(privileges), STATEMENT_BATCH_SIZE)...)
 	}
 	if securityLabel := metadata.GetSecurityLabelStatement(obj.FQN(), entry.ObjectType); securityLabel != "" {
 		statements = append(statements, strings.TrimSpace(securityLabel))
@@ -151,6 +198,10 @@ func ConstructMetadataMap(results []MetadataQueryStruct) MetadataMap {
 
 		privileges := ParseACL(privilegesStr)
 		if privileges != nil {
+			// Privileges granted by the owner are the default, so only other grantors are kept
+			if privileges.Grantor == metadata.Owner {
+				privileges.Grantor = ""
+			}
 			metadata.Privileges = append(metadata.Privileges, *privileges)
 		}
 	}
@@ -258,6 +309,11 @@ func ParseACL(aclStr string) *ACL {
 		} else {
 			acl.Grantee = grantee
 		}
+		if quotedRoleName, ok := quotedRoleNames[matches[3]]; ok {
+			acl.Grantor = quotedRoleName
+		} else {
+			acl.Grantor = matches[3]
+		}
 
 		return &acl
 	}
@@ -292,10 +348,10 @@ func (obj ObjectMetadata) GetPrivilegesStatements(objectName string, objectType
 			}
 			privStr, privWithGrantStr := createPrivilegeStrings(acl, objectType)
 			if privStr != "" {
-				statements = append(statements, fmt.Sprintf("GRANT %s %sON %s%s TO %s;", privStr, columnStr, typeStr, objectName, grantee))
+				statements = append(statements, withGrantor(fmt.Sprintf("GRANT %s %sON %s%s TO %s;", privStr, columnStr, typeStr, objectName, grantee), acl.Grantor, obj.Owner))
 			}
 			if privWithGrantStr != "" {
-				statements = append(statements, fmt.Sprintf("GRANT %s %sON %s%s TO %s WITH GRANT OPTION;", privWithGrantStr, columnStr, typeStr, objectName, grantee))
+				statements = append(statements, withGrantor(fmt.Sprintf("GRANT %s %sON %s%s TO %s WITH GRANT OPTION;", privWithGrantStr, columnStr, typeStr, objectName, grantee), acl.Grantor, obj.Owner))
 			}
 		}
 	}
@@ -305,6 +361,21 @@ func (obj ObjectMetadata) GetPrivilegesStatements(objectName string, objectType
 	return ""
 }
 
+/*
+ * With --preserve-grantor, a grant made by a role other than the object's
+ * owner is run under that role's session authorization so the catalog
+ * records the original grantor, which later REVOKE ... CASCADE statements on
+ * a WITH GRANT OPTION chain depend on.  The statement stays on one line so
+ * that gprestore can fall back to the plain GRANT if the grantor does not
+ * exist in the restore database.
+ */
+func withGrantor(grant string, grantor string, owner string) string {
+	if !MustGetFlagBool(options.PRESERVE_GRANTOR) || grantor == "" || grantor == owner {
+		return grant
+	}
+	return fmt.Sprintf("SET SESSION AUTHORIZATION %s; %s RESET SESSION AUTHORIZATION;", grantor, grant)
+}
+
 func createPrivilegeStrings(acl ACL, objectType string) (string, string) {
 	/*
 	 * Determine whether to print "GRANT ALL" instead of granting individual
@@ -332,7 +403,7 @@ func createPrivilegeStrings(acl ACL, objectType string) (string, string) {
 		hasAllPrivileges = acl.Select && acl.Insert && acl.Update && acl.Delete && acl.References && acl.Trigger
 		hasAllPrivilegesWithGrant = acl.SelectWithGrant && acl.InsertWithGrant && acl.UpdateWithGrant && acl.DeleteWithGrant &&
 			acl.ReferencesWithGrant && acl.TriggerWithGrant
-	case "FUNCTION":
+	case "FUNCTION", "AGGREGATE":
 		hasAllPrivileges = acl.Execute
 		hasAllPrivilegesWithGrant = acl.ExecuteWithGrant
 	case "LANGUAGE":
@@ -354,7 +425,7 @@ func createPrivilegeStrings(acl ACL, objectType string) (string, string) {
 	case "TABLESPACE":
 		hasAllPrivileges = acl.Create
 		hasAllPrivilegesWithGrant = acl.CreateWithGrant
-	case "TYPE":
+	case "TYPE", "DOMAIN":
 		hasAllPrivileges = acl.Usage
 		hasAllPrivilegesWithGrant = acl.UsageWithGrant
 	}
@@ -503,6 +574,9 @@ func PrintDefaultPrivilegesStatements(metadataFile *utils.FileWithByteCount, toc
 			objectType = "FUNCTION"
 		case "T":
 			objectType = "TYPE"
+		case "n":
+			// Default privileges on schemas can be set from GPDB 7, and only for the whole database
+			objectType = "SCHEMA"
 		}
 		alterPrefix := fmt.Sprintf("ALTER DEFAULT PRIVILEGES%s%s", roleStr, schemaStr)
 		statements = append(statements, fmt.Sprintf("%s REVOKE ALL ON %sS FROM PUBLIC;", alterPrefix, objectType))
@@ -560,6 +634,9 @@ func ConstructDefaultPrivileges(results []DefaultPrivilegesQueryStruct) []Defaul
 
 		privileges := ParseACL(privilegesStr)
 		if privileges != nil {
+			if privileges.Grantor == priv.Owner {
+				privileges.Grantor = ""
+			}
 			priv.Privileges = append(priv.Privileges, *privileges)
 		}
 	}
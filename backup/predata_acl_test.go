@@ -3,11 +3,13 @@ package backup_test
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/greenplum-db/gp-common-go-libs/structmatcher"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/testutils"
 
 	. "github.com/onsi/ginkgo"
@@ -44,6 +46,11 @@ COMMENT ON TABLE public.tablename IS 'This is a table comment.';`)
 
 COMMENT ON TABLE public.tablename IS 'This is a ta''ble 1+=;,./\>,<@\\n^comment.';`)
 		})
+		It("prints a table comment containing a quote, a newline, and a trailing backslash", func() {
+			tableMetadata := backup.ObjectMetadata{Comment: "driver's license #\nissued by state\\"}
+			backup.PrintObjectMetadata(backupfile, tocfile, tableMetadata, table, "")
+			testhelper.ExpectRegexp(buffer, "COMMENT ON TABLE public.tablename IS 'driver''s license #\nissued by state\\';")
+		})
 		It("prints an ALTER TABLE ... OWNER TO statement to set the table owner", func() {
 			tableMetadata := backup.ObjectMetadata{Owner: "testrole"}
 			backup.PrintObjectMetadata(backupfile, tocfile, tableMetadata, table, "")
@@ -103,6 +110,31 @@ REVOKE ALL ON TABLE public.tablename FROM testrole;
 GRANT ALL ON TABLE public.tablename TO anothertestrole;
 GRANT SELECT,INSERT,UPDATE,DELETE,TRUNCATE,REFERENCES ON TABLE public.tablename TO testrole;
 GRANT TRIGGER ON TABLE public.tablename TO PUBLIC;`)
+		})
+		It("prints GRANT statements as the original grantor when --preserve-grantor is set", func() {
+			_ = cmdFlags.Set(options.PRESERVE_GRANTOR, "true")
+			grantedByOther := backup.ACL{Grantee: "anothertestrole", Grantor: "grantorrole", Select: true}
+			grantedByOwner := backup.ACL{Grantee: "thirdrole", Grantor: "testrole", SelectWithGrant: true}
+			tableMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{grantedByOther, grantedByOwner}, Owner: "testrole"}
+			backup.PrintObjectMetadata(backupfile, tocfile, tableMetadata, table, "")
+			testhelper.ExpectRegexp(buffer, `
+
+ALTER TABLE public.tablename OWNER TO testrole;
+
+
+REVOKE ALL ON TABLE public.tablename FROM PUBLIC;
+REVOKE ALL ON TABLE public.tablename FROM testrole;
+SET SESSION AUTHORIZATION grantorrole; GRANT SELECT ON TABLE public.tablename TO anothertestrole; RESET SESSION AUTHORIZATION;
+GRANT SELECT ON TABLE public.tablename TO thirdrole WITH GRANT OPTION;`)
+		})
+		It("ignores the grantor when --preserve-grantor is not set", func() {
+			grantedByOther := backup.ACL{Grantee: "anothertestrole", Grantor: "grantorrole", Select: true}
+			tableMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{grantedByOther}}
+			backup.PrintObjectMetadata(backupfile, tocfile, tableMetadata, table, "")
+			testhelper.ExpectRegexp(buffer, `
+
+REVOKE ALL ON TABLE public.tablename FROM PUBLIC;
+GRANT SELECT ON TABLE public.tablename TO anothertestrole;`)
 		})
 		It("prints both a block of REVOKE and GRANT statements and a table comment", func() {
 			tableMetadata := backup.ObjectMetadata{Privileges: privileges, Comment: "This is a table comment."}
@@ -159,7 +191,62 @@ ALTER AGGREGATE public.testagg(*) OWNER TO testrole;
 
 
 REVOKE ALL ON FUNCTION public.testagg(*) FROM PUBLIC;
-REVOKE ALL ON FUNCTION public.testagg(*) FROM testrole;`)
+REVOKE ALL ON FUNCTION public.testagg(*) FROM testrole;
+GRANT ALL ON FUNCTION public.testagg(*) TO testrole;`)
+		})
+		Context("Privileges parsed from ACL strings", func() {
+			BeforeEach(func() {
+				backup.SetQuotedRoleNames(map[string]string{"testrole": "testrole", "gpadmin": "gpadmin"})
+			})
+			It("prints ON SEQUENCE privileges for a sequence ACL", func() {
+				sequence := backup.Sequence{Relation: backup.Relation{Schema: "public", Name: "seq"}}
+				sequenceMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{*backup.ParseACL("testrole=rwU/gpadmin"), *backup.ParseACL("=U/gpadmin"), *backup.ParseACL("gpadmin=r*w/gpadmin")}}
+				backup.PrintObjectMetadata(backupfile, tocfile, sequenceMetadata, sequence, "")
+				testhelper.ExpectRegexp(buffer, `
+
+REVOKE ALL ON SEQUENCE public.seq FROM PUBLIC;
+GRANT ALL ON SEQUENCE public.seq TO testrole;
+GRANT USAGE ON SEQUENCE public.seq TO PUBLIC;
+GRANT UPDATE ON SEQUENCE public.seq TO gpadmin;
+GRANT SELECT ON SEQUENCE public.seq TO gpadmin WITH GRANT OPTION;`)
+			})
+			It("prints ON FUNCTION privileges for a function ACL", func() {
+				function := backup.Function{Schema: "public", Name: "func", IdentArgs: sql.NullString{String: "integer", Valid: true}}
+				functionMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{*backup.ParseACL("testrole=X/gpadmin"), *backup.ParseACL("gpadmin=X*/gpadmin")}}
+				backup.PrintObjectMetadata(backupfile, tocfile, functionMetadata, function, "")
+				testhelper.ExpectRegexp(buffer, `
+
+REVOKE ALL ON FUNCTION public.func(integer) FROM PUBLIC;
+GRANT ALL ON FUNCTION public.func(integer) TO testrole;
+GRANT ALL ON FUNCTION public.func(integer) TO gpadmin WITH GRANT OPTION;`)
+			})
+			It("prints ON FUNCTION privileges for an aggregate ACL", func() {
+				aggregate := backup.Aggregate{Schema: "public", Name: "agg", IdentArgs: sql.NullString{String: "integer", Valid: true}}
+				aggregateMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{*backup.ParseACL("testrole=X/gpadmin")}}
+				backup.PrintObjectMetadata(backupfile, tocfile, aggregateMetadata, aggregate, "")
+				testhelper.ExpectRegexp(buffer, `
+
+REVOKE ALL ON FUNCTION public.agg(integer) FROM PUBLIC;
+GRANT ALL ON FUNCTION public.agg(integer) TO testrole;`)
+			})
+			It("prints ON TYPE privileges for a type ACL", func() {
+				baseType := backup.BaseType{Schema: "public", Name: "base_type"}
+				typeMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{*backup.ParseACL("testrole=U/gpadmin")}}
+				backup.PrintObjectMetadata(backupfile, tocfile, typeMetadata, baseType, "")
+				testhelper.ExpectRegexp(buffer, `
+
+REVOKE ALL ON TYPE public.base_type FROM PUBLIC;
+GRANT ALL ON TYPE public.base_type TO testrole;`)
+			})
+			It("prints ON DOMAIN privileges for a domain ACL", func() {
+				domain := backup.Domain{Schema: "public", Name: "domain_type"}
+				domainMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{*backup.ParseACL("testrole=U*/gpadmin")}}
+				backup.PrintObjectMetadata(backupfile, tocfile, domainMetadata, domain, "")
+				testhelper.ExpectRegexp(buffer, `
+
+REVOKE ALL ON DOMAIN public.domain_type FROM PUBLIC;
+GRANT ALL ON DOMAIN public.domain_type TO testrole WITH GRANT OPTION;`)
+			})
 		})
 		Context("Views and sequences have owners", func() {
 			view := backup.View{Schema: "public", Name: "viewname"}
@@ -190,6 +277,61 @@ ALTER %s public.viewname OWNER TO testrole;`, expectedKeyword))
 			})
 		})
 	})
+	Describe("BatchStatements", func() {
+		It("returns the statements unchanged when they fit in a single batch", func() {
+			statements := "GRANT ALL ON TABLE public.foo TO role1;\nGRANT ALL ON TABLE public.foo TO role2;"
+			Expect(backup.BatchStatements(statements, 1024)).To(Equal([]string{statements}))
+		})
+		It("splits many thousands of statements into batches no larger than the maximum size", func() {
+			grants := make([]string, 60000)
+			for i := range grants {
+				grants[i] = fmt.Sprintf("GRANT ALL ON SCHEMA schema1 TO role%d;", i)
+			}
+			statements := strings.Join(grants, "\n")
+
+			batches := backup.BatchStatements(statements, 64*1024)
+
+			Expect(len(batches)).To(BeNumerically(">", 1))
+			for _, batch := range batches {
+				Expect(len(batch)).To(BeNumerically("<=", 64*1024))
+				Expect(batch).To(HavePrefix("GRANT ALL ON SCHEMA schema1 TO role"))
+				Expect(batch).To(HaveSuffix(";"))
+			}
+			Expect(strings.Join(batches, "\n")).To(Equal(statements))
+		})
+		It("does not split a statement containing a newline", func() {
+			statements := "GRANT ALL ON TABLE public.foo TO role1;\nGRANT ALL ON TABLE public.foo TO \"role\n2\";\nGRANT ALL ON TABLE public.foo TO role3;"
+
+			batches := backup.BatchStatements(statements, 50)
+
+			Expect(batches).To(Equal([]string{"GRANT ALL ON TABLE public.foo TO role1;", "GRANT ALL ON TABLE public.foo TO \"role\n2\";", "GRANT ALL ON TABLE public.foo TO role3;"}))
+		})
+	})
+	Describe("PrintObjectMetadata with many privileges", func() {
+		It("prints privileges for many thousands of roles in bounded batches with their own TOC entries", func() {
+			schema := backup.Schema{Oid: 1, Name: "schema1"}
+			privileges := make([]backup.ACL, 50000)
+			for i := range privileges {
+				privileges[i] = backup.ACL{Grantee: fmt.Sprintf("role%d", i), Usage: true}
+			}
+			schemaMetadata := backup.ObjectMetadata{Privileges: privileges, Owner: "testrole"}
+
+			backup.PrintObjectMetadata(backupfile, tocfile, schemaMetadata, schema, "")
+
+			Expect(len(tocfile.PredataEntries)).To(BeNumerically(">", 2))
+			hunks, remaining := testutils.SliceBufferByEntries(tocfile.PredataEntries, buffer)
+			Expect(remaining).To(Equal(""))
+			Expect(strings.TrimSpace(hunks[0])).To(Equal("ALTER SCHEMA schema1 OWNER TO testrole;"))
+			numGrants := 0
+			for i, hunk := range hunks[1:] {
+				testutils.ExpectEntry(tocfile.PredataEntries, i+1, "schema1", "", "schema1", "SCHEMA")
+				Expect(len(strings.TrimSpace(hunk))).To(BeNumerically("<=", backup.STATEMENT_BATCH_SIZE))
+				Expect(strings.TrimSpace(hunk)).To(HaveSuffix(";"))
+				numGrants += strings.Count(hunk, "GRANT USAGE ON SCHEMA schema1 TO role")
+			}
+			Expect(numGrants).To(Equal(50000))
+		})
+	})
 	Describe("PrintDefaultPrivilegeStatements", func() {
 		privs := []backup.ACL{{Grantee: "", Usage: true}}
 		It("prints ALTER DEFAULT PRIVILEGES statement for relation", func() {
@@ -226,6 +368,16 @@ ALTER DEFAULT PRIVILEGES FOR ROLE testrole GRANT USAGE ON FUNCTIONS TO PUBLIC;
 ALTER DEFAULT PRIVILEGES FOR ROLE testrole REVOKE ALL ON TYPES FROM PUBLIC;
 ALTER DEFAULT PRIVILEGES FOR ROLE testrole REVOKE ALL ON TYPES FROM testrole;
 ALTER DEFAULT PRIVILEGES FOR ROLE testrole GRANT ALL ON TYPES TO PUBLIC;
+`)
+		})
+		It("prints ALTER DEFAULT PRIVILEGES statement for schema", func() {
+			localPrivs := []backup.ACL{{Grantee: "somerole", Usage: true}}
+			defaultPrivileges := []backup.DefaultPrivileges{{Owner: "testrole", Schema: "", Privileges: localPrivs, ObjectType: "n"}}
+			backup.PrintDefaultPrivilegesStatements(backupfile, tocfile, defaultPrivileges)
+			testhelper.ExpectRegexp(buffer, `
+ALTER DEFAULT PRIVILEGES FOR ROLE testrole REVOKE ALL ON SCHEMAS FROM PUBLIC;
+ALTER DEFAULT PRIVILEGES FOR ROLE testrole REVOKE ALL ON SCHEMAS FROM testrole;
+ALTER DEFAULT PRIVILEGES FOR ROLE testrole GRANT USAGE ON SCHEMAS TO somerole;
 `)
 		})
 		It("prints ALTER DEFAULT PRIVILEGES statement for role", func() {
@@ -299,14 +451,14 @@ ALTER DEFAULT PRIVILEGES FOR ROLE testrole GRANT USAGE ON TABLES TO somerole WIT
 		It("One object with two ACL entries", func() {
 			metadataList = []backup.MetadataQueryStruct{object1A, object1B}
 			metadataMap := backup.ConstructMetadataMap(metadataList)
-			expectedObjectMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{{Grantee: "gpadmin", Select: true}, {Grantee: "testrole", Select: true}}, Owner: "testrole"}
+			expectedObjectMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{{Grantee: "gpadmin", Grantor: "gpadmin", Select: true}, {Grantee: "testrole", Select: true}}, Owner: "testrole"}
 			Expect(metadataMap).To(HaveLen(1))
 			Expect(metadataMap[backup.UniqueID{Oid: 1}]).To(Equal(expectedObjectMetadata))
 		})
 		It("Multiple objects", func() {
 			metadataList = []backup.MetadataQueryStruct{object1A, object1B, object2}
 			metadataMap := backup.ConstructMetadataMap(metadataList)
-			expectedObjectMetadataOne := backup.ObjectMetadata{Privileges: []backup.ACL{{Grantee: "gpadmin", Select: true}, {Grantee: "testrole", Select: true}}, Owner: "testrole"}
+			expectedObjectMetadataOne := backup.ObjectMetadata{Privileges: []backup.ACL{{Grantee: "gpadmin", Grantor: "gpadmin", Select: true}, {Grantee: "testrole", Select: true}}, Owner: "testrole"}
 			expectedObjectMetadataTwo := backup.ObjectMetadata{Privileges: []backup.ACL{{Grantee: "testrole", Select: true}}, Owner: "testrole", Comment: "this is a comment", SecurityLabelProvider: "some_provider", SecurityLabel: "some_label"}
 			Expect(metadataMap).To(HaveLen(2))
 			Expect(metadataMap[backup.UniqueID{Oid: 1}]).To(Equal(expectedObjectMetadataOne))
@@ -322,7 +474,7 @@ ALTER DEFAULT PRIVILEGES FOR ROLE testrole GRANT USAGE ON TABLES TO somerole WIT
 		It("'Empty' Kind", func() {
 			metadataList = []backup.MetadataQueryStruct{objectEmptyKind}
 			metadataMap := backup.ConstructMetadataMap(metadataList)
-			expectedObjectMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{{Grantee: "GRANTEE"}}, Owner: "testrole"}
+			expectedObjectMetadata := backup.ObjectMetadata{Privileges: []backup.ACL{{Grantee: "GRANTEE", Grantor: "GRANTOR"}}, Owner: "testrole"}
 			Expect(metadataMap).To(HaveLen(1))
 			Expect(metadataMap[backup.UniqueID{Oid: 4}]).To(Equal(expectedObjectMetadata))
 		})
@@ -343,45 +495,46 @@ ALTER DEFAULT PRIVILEGES FOR ROLE testrole GRANT USAGE ON TABLES TO somerole WIT
 		})
 		It("parses an ACL string representing no privileges", func() {
 			aclStr := "GRANTEE=/GRANTOR"
-			expected := backup.ACL{Grantee: "GRANTEE"}
+			expected := backup.ACL{Grantee: "GRANTEE", Grantor: "GRANTOR"}
 			result := backup.ParseACL(aclStr)
 			structmatcher.ExpectStructsToMatch(&expected, result)
 		})
 		It("parses an ACL string containing a role with multiple privileges", func() {
 			aclStr := "testrole=arwdDxt/gpadmin"
 			expected := testutils.DefaultACLForType("testrole", "TABLE")
+			expected.Grantor = "gpadmin"
 			result := backup.ParseACL(aclStr)
 			structmatcher.ExpectStructsToMatch(&expected, result)
 		})
 		It("parses an ACL string containing a role with one privilege", func() {
 			aclStr := "testrole=a/gpadmin"
-			expected := backup.ACL{Grantee: "testrole", Insert: true}
+			expected := backup.ACL{Grantee: "testrole", Grantor: "gpadmin", Insert: true}
 			result := backup.ParseACL(aclStr)
 			structmatcher.ExpectStructsToMatch(&expected, result)
 		})
 		It("parses an ACL string containing a role name with special characters", func() {
 			aclStr := `Test|role=a/gpadmin`
-			expected := backup.ACL{Grantee: `"Test|role"`, Insert: true}
+			expected := backup.ACL{Grantee: `"Test|role"`, Grantor: "gpadmin", Insert: true}
 			result := backup.ParseACL(aclStr)
 			structmatcher.ExpectStructsToMatch(&expected, result)
 		})
 		It("parses an ACL string containing a role with some privileges with GRANT and some without including GRANT", func() {
 			aclStr := "testrole=ar*w*d*tXUCTc/gpadmin"
-			expected := backup.ACL{Grantee: "testrole", Insert: true, SelectWithGrant: true, UpdateWithGrant: true,
+			expected := backup.ACL{Grantee: "testrole", Grantor: "gpadmin", Insert: true, SelectWithGrant: true, UpdateWithGrant: true,
 				DeleteWithGrant: true, Trigger: true, Execute: true, Usage: true, Create: true, Temporary: true, Connect: true}
 			result := backup.ParseACL(aclStr)
 			structmatcher.ExpectStructsToMatch(&expected, result)
 		})
 		It("parses an ACL string containing a role with all privileges including GRANT", func() {
 			aclStr := "testrole=a*D*x*t*X*U*C*T*c*/gpadmin"
-			expected := backup.ACL{Grantee: "testrole", InsertWithGrant: true, TruncateWithGrant: true, ReferencesWithGrant: true,
+			expected := backup.ACL{Grantee: "testrole", Grantor: "gpadmin", InsertWithGrant: true, TruncateWithGrant: true, ReferencesWithGrant: true,
 				TriggerWithGrant: true, ExecuteWithGrant: true, UsageWithGrant: true, CreateWithGrant: true, TemporaryWithGrant: true, ConnectWithGrant: true}
 			result := backup.ParseACL(aclStr)
 			structmatcher.ExpectStructsToMatch(&expected, result)
 		})
 		It("parses an ACL string granting privileges to PUBLIC", func() {
 			aclStr := "=a/gpadmin"
-			expected := backup.ACL{Grantee: "", Insert: true}
+			expected := backup.ACL{Grantee: "", Grantor: "gpadmin", Insert: true}
 			result := backup.ParseACL(aclStr)
 			structmatcher.ExpectStructsToMatch(&expected, result)
 		})
@@ -415,14 +568,14 @@ ALTER DEFAULT PRIVILEGES FOR ROLE testrole GRANT USAGE ON TABLES TO somerole WIT
 		It("constructs multiple default privileges on a single relation in a specific schema", func() {
 			privilegesQuerylist = []backup.DefaultPrivilegesQueryStruct{object1A, object1B}
 			privilegesList := backup.ConstructDefaultPrivileges(privilegesQuerylist)
-			expectedObjectMetadata := backup.DefaultPrivileges{Privileges: []backup.ACL{{Grantee: "gpadmin", Select: true}, {Grantee: "testrole", Select: true}}, Owner: "testrole", Schema: "myschema", ObjectType: "r"}
+			expectedObjectMetadata := backup.DefaultPrivileges{Privileges: []backup.ACL{{Grantee: "gpadmin", Grantor: "gpadmin", Select: true}, {Grantee: "testrole", Select: true}}, Owner: "testrole", Schema: "myschema", ObjectType: "r"}
 			Expect(privilegesList).To(HaveLen(1))
 			Expect(privilegesList[0]).To(Equal(expectedObjectMetadata))
 		})
 		It("constructs multiple default privileges on multiple objects in a specific schema", func() {
 			privilegesQuerylist = []backup.DefaultPrivilegesQueryStruct{object1A, object1B, object2}
 			privilegesList := backup.ConstructDefaultPrivileges(privilegesQuerylist)
-			expectedObjectMetadataOne := backup.DefaultPrivileges{Privileges: []backup.ACL{{Grantee: "gpadmin", Select: true}, {Grantee: "testrole", Select: true}}, Owner: "testrole", Schema: "myschema", ObjectType: "r"}
+			expectedObjectMetadataOne := backup.DefaultPrivileges{Privileges: []backup.ACL{{Grantee: "gpadmin", Grantor: "gpadmin", Select: true}, {Grantee: "testrole", Select: true}}, Owner: "testrole", Schema: "myschema", ObjectType: "r"}
 			expectedObjectMetadataTwo := backup.DefaultPrivileges{Privileges: []backup.ACL{{Grantee: "testrole", Select: true}}, Owner: "testrole", Schema: "myschema", ObjectType: "S"}
 			Expect(privilegesList).To(HaveLen(2))
 			Expect(privilegesList[0]).To(Equal(expectedObjectMetadataOne))
@@ -438,7 +591,7 @@ ALTER DEFAULT PRIVILEGES FOR ROLE testrole GRANT USAGE ON TABLES TO somerole WIT
 		It("constructs a default privilege for a function with an 'Empty' kind", func() {
 			privilegesQuerylist = []backup.DefaultPrivilegesQueryStruct{objectEmptyKind}
 			privilegesList := backup.ConstructDefaultPrivileges(privilegesQuerylist)
-			expectedObjectMetadata := backup.DefaultPrivileges{Privileges: []backup.ACL{{Grantee: "GRANTEE"}}, Owner: "testrole", Schema: "", ObjectType: "f"}
+			expectedObjectMetadata := backup.DefaultPrivileges{Privileges: []backup.ACL{{Grantee: "GRANTEE", Grantor: "GRANTOR"}}, Owner: "testrole", Schema: "", ObjectType: "f"}
 			Expect(privilegesList).To(HaveLen(1))
 			Expect(privilegesList[0]).To(Equal(expectedObjectMetadata))
 		})
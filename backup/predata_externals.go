@@ -59,10 +59,10 @@ func PrintExternalTableCreateStatement(metadataFile *utils.FileWithByteCount, to
 	extTableDef := table.ExtTableDef
 	extTableDef.Type, extTableDef.Protocol = DetermineExternalTableCharacteristics(extTableDef)
 	metadataFile.MustPrintf("\n\nCREATE %s TABLE %s (\n", tableTypeStrMap[extTableDef.Type], table.FQN())
-	printColumnDefinitions(metadataFile, table.ColumnDefs, "")
+	printColumnDefinitions(metadataFile, table.Schema, table.ColumnDefs, "")
 	metadataFile.MustPrintf(") ")
 	PrintExternalTableStatements(metadataFile, table.FQN(), extTableDef)
-	if extTableDef.Writable {
+	if extTableDef.Writable && table.DistPolicy != "" {
 		metadataFile.MustPrintf("\n%s", table.DistPolicy)
 	}
 	metadataFile.MustPrintf(";")
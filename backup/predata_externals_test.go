@@ -116,6 +116,48 @@ ENCODING 'UTF-8';`)
 FORMAT 'TEXT'
 ENCODING 'UTF-8'
 DISTRIBUTED RANDOMLY;`)
+		})
+		It("prints a CREATE block for a WRITABLE EXTERNAL table DISTRIBUTED BY a column", func() {
+			extTableDef.Location = "file://host:port/path/file"
+			extTableDef.URIs = []string{"file://host:port/path/file"}
+			extTableDef.Writable = true
+			testTable.ExtTableDef = extTableDef
+			testTable.DistPolicy = "DISTRIBUTED BY (i)"
+			backup.PrintExternalTableCreateStatement(backupfile, tocfile, testTable)
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE WRITABLE EXTERNAL TABLE public.tablename (
+) LOCATION (
+	'file://host:port/path/file'
+)
+FORMAT 'TEXT'
+ENCODING 'UTF-8'
+DISTRIBUTED BY (i);`)
+		})
+		It("does not print a distribution policy for a READABLE EXTERNAL table", func() {
+			extTableDef.Location = "file://host:port/path/file"
+			extTableDef.URIs = []string{"file://host:port/path/file"}
+			testTable.ExtTableDef = extTableDef
+			testTable.DistPolicy = "DISTRIBUTED BY (i)"
+			backup.PrintExternalTableCreateStatement(backupfile, tocfile, testTable)
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE READABLE EXTERNAL TABLE public.tablename (
+) LOCATION (
+	'file://host:port/path/file'
+)
+FORMAT 'TEXT'
+ENCODING 'UTF-8';`)
+		})
+		It("prints a CREATE block for a WRITABLE EXTERNAL table without a distribution policy", func() {
+			extTableDef.Location = "file://host:port/path/file"
+			extTableDef.URIs = []string{"file://host:port/path/file"}
+			extTableDef.Writable = true
+			testTable.ExtTableDef = extTableDef
+			testTable.DistPolicy = ""
+			backup.PrintExternalTableCreateStatement(backupfile, tocfile, testTable)
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE WRITABLE EXTERNAL TABLE public.tablename (
+) LOCATION (
+	'file://host:port/path/file'
+)
+FORMAT 'TEXT'
+ENCODING 'UTF-8';`)
 		})
 		It("prints a CREATE block for a READABLE EXTERNAL WEB table with a LOCATION", func() {
 			extTableDef.Location = "http://webhost:port/path/file"
@@ -207,6 +207,16 @@ func PrintCreateExtensionStatements(metadataFile *utils.FileWithByteCount, toc *
 	}
 }
 
+func PrintAlterExtensionAddStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, extensionMembers []ExtensionMember) {
+	for _, member := range extensionMembers {
+		start := metadataFile.ByteCount
+		metadataFile.MustPrintf("\n\nALTER EXTENSION %s ADD %s %s;", member.ExtensionName, member.ObjectType, member.Identity)
+
+		section, entry := member.GetMetadataEntry()
+		toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+	}
+}
+
 /*
  * This function separates out functions related to procedural languages from
  * any other functions, so that language-related functions can be backed up before
@@ -151,6 +151,14 @@ $_$`)
 				backup.PrintFunctionModifiers(backupfile, funcDef)
 				testhelper.ExpectRegexp(buffer, "SECURITY DEFINER")
 			})
+			It("prints each SET clause for a SECURITY DEFINER function with a fixed search_path", func() {
+				funcDef.IsSecurityDefiner = true
+				funcDef.Config = "SET search_path TO 'pg_catalog', 'pg_temp'\nSET work_mem TO '64MB'"
+				backup.PrintFunctionModifiers(backupfile, funcDef)
+				testhelper.ExpectRegexp(buffer, `SECURITY DEFINER
+SET search_path TO 'pg_catalog', 'pg_temp'
+SET work_mem TO '64MB'`)
+			})
 			It("print 'WINDOW' if IsWindow is set", func() {
 				funcDef.IsWindow = true
 				backup.PrintFunctionModifiers(backupfile, funcDef)
@@ -592,6 +600,19 @@ CREATE EXTENSION IF NOT EXISTS extension1 WITH SCHEMA schema1;
 SET search_path=pg_catalog;`, "COMMENT ON EXTENSION extension1 IS 'This is an extension comment.';")
 		})
 	})
+	Describe("PrintAlterExtensionAddStatements", func() {
+		It("prints an ALTER EXTENSION ... ADD statement for each added member", func() {
+			members := []backup.ExtensionMember{
+				{ExtensionName: "extension1", Schema: "public", ObjectType: "FUNCTION", Identity: "public.add_one(integer)"},
+				{ExtensionName: "extension1", Schema: "public", ObjectType: "TABLE", Identity: "public.lookup"},
+			}
+			backup.PrintAlterExtensionAddStatements(backupfile, tocfile, members)
+			testutils.ExpectEntry(tocfile.PostdataEntries, 0, "public", "public.add_one(integer)", "extension1", "EXTENSION MEMBER")
+			testutils.AssertBufferContents(tocfile.PostdataEntries, buffer,
+				"ALTER EXTENSION extension1 ADD FUNCTION public.add_one(integer);",
+				"ALTER EXTENSION extension1 ADD TABLE public.lookup;")
+		})
+	})
 	Describe("ExtractLanguageFunctions", func() {
 		customLang1 := backup.ProceduralLanguage{Oid: 1, Name: "custom_language", Owner: "testrole", IsPl: true, PlTrusted: true, Handler: 3, Inline: 4, Validator: 5}
 		customLang2 := backup.ProceduralLanguage{Oid: 2, Name: "custom_language2", Owner: "testrole", IsPl: true, PlTrusted: true, Handler: 5, Inline: 6, Validator: 7}
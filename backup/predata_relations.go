@@ -8,10 +8,12 @@ package backup
 import (
 	"fmt"
 	"math"
+	"sort"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 )
@@ -59,6 +61,39 @@ func SplitTablesByPartitionType(tables []Table, includeList []string) ([]Table,
 	return metadataTables, dataTables
 }
 
+/*
+ * Adds the orphaned child partitions missing from the table list, which are
+ * left out along with the child partitions they resemble, or removes every
+ * orphaned child partition if they are excluded.
+ */
+func MergeOrphanedPartitions(tableRelations []Relation, orphans []Relation, exclude bool) []Relation {
+	orphanOids := make(map[uint32]bool, len(orphans))
+	for _, orphan := range orphans {
+		orphanOids[orphan.Oid] = true
+	}
+	merged := make([]Relation, 0, len(tableRelations)+len(orphans))
+	for _, relation := range tableRelations {
+		if !orphanOids[relation.Oid] {
+			merged = append(merged, relation)
+		} else if !exclude {
+			merged = append(merged, relation)
+			delete(orphanOids, relation.Oid)
+		}
+	}
+	if exclude {
+		return merged
+	}
+	for _, orphan := range orphans {
+		if orphanOids[orphan.Oid] {
+			merged = append(merged, orphan)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Oid < merged[j].Oid
+	})
+	return merged
+}
+
 func AppendExtPartSuffix(name string) string {
 	const SUFFIX = "_ext_part_"
 	const MAX_LEN = 63                 // MAX_DATA_LEN - 1 is the maximum length of a relation name
@@ -92,6 +127,17 @@ func PrintCreateTableStatement(metadataFile *utils.FileWithByteCount, toc *toc.T
 	}
 	section, entry := table.GetMetadataEntry()
 	toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
+	for _, column := range table.ColumnDefs {
+		if column.HasDefault && column.DefaultVal != "" && column.GenerationExpr == "" {
+			toc.AddColumnDefaultEntry(table.Schema, table.Name, column.Name, column.Type, column.DefaultVal)
+		}
+	}
+	if table.HasOids {
+		toc.AddTableWithOids(table.Schema, table.Name)
+	}
+	if usesLeafPartitionDDL(table) {
+		printPartitionChildStatements(metadataFile, toc, table, tableMetadata)
+	}
 	PrintPostCreateTableStatements(metadataFile, toc, table, tableMetadata)
 }
 
@@ -112,31 +158,46 @@ func PrintRegularTableCreateStatement(metadataFile *utils.FileWithByteCount, toc
 
 	metadataFile.MustPrintf("\n\nCREATE %sTABLE %s %s(\n", tableModifier, table.FQN(), typeStr)
 
-	printColumnDefinitions(metadataFile, table.ColumnDefs, table.TableType)
+	printColumnDefinitions(metadataFile, table.Schema, table.ColumnDefs, table.TableType)
 	metadataFile.MustPrintf(") ")
 	if len(table.Inherits) != 0 {
 		dependencyList := strings.Join(table.Inherits, ", ")
 		metadataFile.MustPrintf("INHERITS (%s) ", dependencyList)
 	}
+	if table.AccessMethod != "" {
+		metadataFile.MustPrintf("USING %s ", table.AccessMethod)
+	}
 	if table.ForeignDef != (ForeignTableDefinition{}) {
 		metadataFile.MustPrintf("SERVER %s ", table.ForeignDef.Server)
 		if table.ForeignDef.Options != "" {
 			metadataFile.MustPrintf("OPTIONS (%s) ", table.ForeignDef.Options)
 		}
 	}
-	if table.StorageOpts != "" {
-		metadataFile.MustPrintf("WITH (%s) ", table.StorageOpts)
+	storageOpts := table.StorageOpts
+	if table.HasOids {
+		if storageOpts != "" {
+			storageOpts += ", "
+		}
+		storageOpts += "oids=true"
+	}
+	if storageOpts != "" {
+		metadataFile.MustPrintf("WITH (%s) ", storageOpts)
 	}
 	if table.TablespaceName != "" {
 		metadataFile.MustPrintf("TABLESPACE %s ", table.TablespaceName)
 	}
 	metadataFile.MustPrintf("%s", table.DistPolicy)
-	if table.PartDef != "" {
-		metadataFile.MustPrintf(" %s", strings.TrimSpace(table.PartDef))
-	}
-	metadataFile.MustPrintln(";")
-	if table.PartTemplateDef != "" {
-		metadataFile.MustPrintf("%s;\n", strings.TrimSpace(table.PartTemplateDef))
+	if usesLeafPartitionDDL(table) {
+		metadataFile.MustPrintf(" %s", partitionByClause(table.PartitionKeys[0]))
+		metadataFile.MustPrintln(";")
+	} else {
+		if table.PartDef != "" {
+			metadataFile.MustPrintf(" %s", strings.TrimSpace(table.PartDef))
+		}
+		metadataFile.MustPrintln(";")
+		if table.PartTemplateDef != "" {
+			metadataFile.MustPrintf("%s;\n", strings.TrimSpace(table.PartTemplateDef))
+		}
 	}
 	printAlterColumnStatements(metadataFile, table, table.ColumnDefs)
 	if toc != nil {
@@ -145,33 +206,171 @@ func PrintRegularTableCreateStatement(metadataFile *utils.FileWithByteCount, toc
 	}
 }
 
-func printColumnDefinitions(metadataFile *utils.FileWithByteCount, columnDefs []ColumnDefinition, tableType string) {
-	lines := make([]string, 0)
-	for _, column := range columnDefs {
-		line := fmt.Sprintf("\t%s %s", column.Name, column.Type)
+/*
+ * With --leaf-partition-ddl, a partition root is printed with only a PARTITION
+ * BY clause and each child partition gets its own CREATE TABLE followed by an
+ * ATTACH PARTITION, which can be replayed on GPDB 7 where the
+ * pg_get_partition_def syntax is not supported.  Hash partitioning has no
+ * portable equivalent, so such tables keep the compact form.  So do tables with
+ * external leaf partitions, as PrintExchangeExternalPartitionStatements swaps
+ * those in for the placeholder leaves created by the compact form.
+ */
+func usesLeafPartitionDDL(table Table) bool {
+	if !MustGetFlagBool(options.LEAF_PARTITION_DDL) || table.PartitionLevelInfo.Level != "p" || len(table.PartitionKeys) == 0 {
+		return false
+	}
+	for _, key := range table.PartitionKeys {
+		if key.Kind != "r" && key.Kind != "l" {
+			return false
+		}
+	}
+	for _, child := range table.PartitionChildren {
+		if child.IsExternal {
+			return false
+		}
+	}
+	return true
+}
+
+func partitionByClause(key PartitionKey) string {
+	strategy := "RANGE"
+	if key.Kind == "l" {
+		strategy = "LIST"
+	}
+	return fmt.Sprintf("PARTITION BY %s (%s)", strategy, strings.Join(key.Columns, ", "))
+}
+
+func partitionBoundClause(root Table, child PartitionChild) string {
+	if child.IsDefault {
+		return "DEFAULT"
+	}
+	if child.ListValues != "" {
+		return fmt.Sprintf("FOR VALUES IN (%s)", child.ListValues)
+	}
+	if (child.RangeStart != "" && !child.RangeStartInclusive) || (child.RangeEnd != "" && child.RangeEndInclusive) {
+		report.Warn(report.WARN_INEXACT_PARTITION_BOUND, child.FQN(), "Partition %s of table %s has an exclusive START or inclusive END bound, which cannot be represented exactly by --leaf-partition-ddl", child.FQN(), root.FQN())
+	}
+	start, end := "MINVALUE", "MAXVALUE"
+	if child.RangeStart != "" {
+		start = child.RangeStart
+	}
+	if child.RangeEnd != "" {
+		end = child.RangeEnd
+	}
+	return fmt.Sprintf("FOR VALUES FROM (%s) TO (%s)", start, end)
+}
+
+/*
+ * Each child is printed with its own storage options, tablespace, and column
+ * encodings, as these may differ from those of the root.  The TOC entries
+ * reference the root so that the children are restored along with it.
+ */
+func printPartitionChildStatements(metadataFile *utils.FileWithByteCount, tocfile *toc.TOC, table Table, tableMetadata ObjectMetadata) {
+	leafOwners := make(map[string]bool)
+	for _, leaf := range table.PartitionLeafOwners {
+		leafOwners[utils.MakeFQN(leaf.Schema, leaf.Name)] = true
+	}
+	for _, child := range table.PartitionChildren {
+		start := metadataFile.ByteCount
+		columnDefs := make([]ColumnDefinition, len(table.ColumnDefs))
+		for i, column := range table.ColumnDefs {
+			column.Encoding = child.ColumnEncodings[column.Name]
+			columnDefs[i] = column
+		}
+		metadataFile.MustPrintf("\n\nCREATE TABLE %s (\n", child.FQN())
+		printColumnDefinitions(metadataFile, child.Schema, columnDefs, "")
+		metadataFile.MustPrintf(") ")
+		if child.StorageOpts != "" {
+			metadataFile.MustPrintf("WITH (%s) ", child.StorageOpts)
+		}
+		if child.TablespaceName != "" {
+			metadataFile.MustPrintf("TABLESPACE %s ", child.TablespaceName)
+		}
+		metadataFile.MustPrintf("%s", table.DistPolicy)
+		if child.Level+1 < len(table.PartitionKeys) {
+			metadataFile.MustPrintf(" %s", partitionByClause(table.PartitionKeys[child.Level+1]))
+		}
+		metadataFile.MustPrintln(";")
+		metadataFile.MustPrintf("ALTER TABLE ONLY %s ATTACH PARTITION %s %s;\n",
+			utils.MakeFQN(child.ParentSchema, child.ParentName), child.FQN(), partitionBoundClause(table, child))
+		if tableMetadata.Owner != "" && !leafOwners[child.FQN()] {
+			metadataFile.MustPrintf("ALTER TABLE %s OWNER TO %s;\n", child.FQN(), tableMetadata.Owner)
+		}
+		entry := toc.MetadataEntry{
+			Schema:          child.Schema,
+			Name:            child.Name,
+			ObjectType:      "TABLE",
+			ReferenceObject: table.FQN(),
+		}
+		tocfile.AddMetadataEntry("predata", entry, start, metadataFile.ByteCount)
+	}
+}
+
+/*
+ * Each column is written to the file as it is formatted, rather than joining
+ * every column into one string, so that tables with thousands of columns do
+ * not need the whole column list in memory at once.
+ */
+func printColumnDefinitions(metadataFile *utils.FileWithByteCount, schema string, columnDefs []ColumnDefinition, tableType string) {
+	var line strings.Builder
+	for i, column := range columnDefs {
+		line.Reset()
+		if i > 0 {
+			line.WriteString(",\n")
+		}
 		if tableType != "" {
-			line = fmt.Sprintf("\t%s WITH OPTIONS", column.Name)
+			fmt.Fprintf(&line, "\t%s WITH OPTIONS", column.Name)
+		} else {
+			fmt.Fprintf(&line, "\t%s %s", column.Name, column.Type)
+			if column.CompressionType != "" {
+				fmt.Fprintf(&line, " COMPRESSION %s", column.CompressionType)
+			}
 		}
 		if column.FdwOptions != "" {
-			line += fmt.Sprintf(" OPTIONS (%s)", column.FdwOptions)
+			fmt.Fprintf(&line, " OPTIONS (%s)", column.FdwOptions)
 		}
 		if column.Collation != "" {
-			line += fmt.Sprintf(" COLLATE %s", column.Collation)
+			fmt.Fprintf(&line, " COLLATE %s", column.Collation)
 		}
-		if column.HasDefault {
-			line += fmt.Sprintf(" DEFAULT %s", column.DefaultVal)
+		if column.GenerationExpr != "" {
+			fmt.Fprintf(&line, " GENERATED ALWAYS AS (%s) STORED", column.GenerationExpr)
+		} else if column.HasDefault {
+			fmt.Fprintf(&line, " DEFAULT %s", column.DefaultVal)
+		}
+		if column.Identity != "" {
+			line.WriteString(identityClause(schema, column))
 		}
 		if column.NotNull {
-			line += " NOT NULL"
+			line.WriteString(" NOT NULL")
 		}
 		if column.Encoding != "" {
-			line += fmt.Sprintf(" ENCODING (%s)", column.Encoding)
+			fmt.Fprintf(&line, " ENCODING (%s)", column.Encoding)
 		}
-		lines = append(lines, line)
+		metadataFile.MustPrint(line.String())
+	}
+	if len(columnDefs) > 0 {
+		metadataFile.MustPrintln()
+	}
+}
+
+/*
+ * The sequence backing an identity column is created with the column, so its
+ * name and options are given in the column's identity clause.  A sequence in
+ * the table's own schema is named without the schema, so that it follows the
+ * table when the table is restored with --redirect-schema.
+ */
+func identityClause(schema string, column ColumnDefinition) string {
+	generated := "ALWAYS"
+	if column.Identity == "d" {
+		generated = "BY DEFAULT"
 	}
-	if len(lines) > 0 {
-		metadataFile.MustPrintln(strings.Join(lines, ",\n"))
+	cycleStr := ""
+	if column.IdentityCycle {
+		cycleStr = " CYCLE"
 	}
+	sequenceName := strings.TrimPrefix(column.IdentitySequence, schema+".")
+	return fmt.Sprintf(" GENERATED %s AS IDENTITY (SEQUENCE NAME %s START WITH %d INCREMENT BY %d MINVALUE %d MAXVALUE %d CACHE %d%s)",
+		generated, sequenceName, column.IdentityStart, column.IdentityIncrement, column.IdentityMin, column.IdentityMax, column.IdentityCache, cycleStr)
 }
 
 func printAlterColumnStatements(metadataFile *utils.FileWithByteCount, table Table, columnDefs []ColumnDefinition) {
@@ -200,8 +399,8 @@ func PrintPostCreateTableStatements(metadataFile *utils.FileWithByteCount, toc *
 			escapedComment := utils.EscapeSingleQuotes(att.Comment)
 			statements = append(statements, fmt.Sprintf("COMMENT ON COLUMN %s.%s IS '%s';", table.FQN(), att.Name, escapedComment))
 		}
-		if att.Privileges.Valid {
-			columnMetadata := ObjectMetadata{Privileges: getColumnACL(att.Privileges, att.Kind), Owner: tableMetadata.Owner}
+		if len(att.ACL) > 0 {
+			columnMetadata := ObjectMetadata{Privileges: att.ACL, Owner: tableMetadata.Owner}
 			columnPrivileges := columnMetadata.GetPrivilegesStatements(table.FQN(), "COLUMN", att.Name)
 			statements = append(statements, strings.TrimSpace(columnPrivileges))
 		}
@@ -209,13 +408,18 @@ func PrintPostCreateTableStatements(metadataFile *utils.FileWithByteCount, toc *
 			escapedLabel := utils.EscapeSingleQuotes(att.SecurityLabel)
 			statements = append(statements, fmt.Sprintf("SECURITY LABEL FOR %s ON COLUMN %s.%s IS '%s';", att.SecurityLabelProvider, table.FQN(), att.Name, escapedLabel))
 		}
+		// The identity sequence has no last value until a value is first drawn from it
+		if att.Identity != "" && att.IdentityLastVal.Valid {
+			statements = append(statements, fmt.Sprintf("SELECT pg_catalog.setval('%s', %d, true);",
+				utils.EscapeSingleQuotes(att.IdentitySequence), att.IdentityLastVal.Int64))
+		}
 	}
 
 	// It seems that replica identity on foreign tables default to "n" and cannot be altered in postgres 9.4
 	if (table.ReplicaIdentity != "") && (table.ForeignDef == ForeignTableDefinition{}) {
 		switch table.ReplicaIdentity {
 		case "d", "i":
-			// default values do not need to be written ; index values are handled when the index is created
+			// default values do not need to be written ; index values are printed in postdata once the index exists
 			break
 		case "n":
 			statements = append(statements, fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY NOTHING;", table.FQN()))
@@ -231,20 +435,43 @@ func PrintPostCreateTableStatements(metadataFile *utils.FileWithByteCount, toc *
 	}
 
 	PrintStatements(metadataFile, toc, table, statements)
+	printPartitionLeafOwnerStatements(metadataFile, toc, table)
+}
+
+/*
+ * Child partitions owned by a different role than the root get their own
+ * ALTER TABLE ... OWNER TO statement.  The TOC entries reference the root so
+ * that they are restored whenever the root table is restored.
+ */
+func printPartitionLeafOwnerStatements(metadataFile *utils.FileWithByteCount, tocfile *toc.TOC, table Table) {
+	for _, leaf := range table.PartitionLeafOwners {
+		start := metadataFile.ByteCount
+		metadataFile.MustPrintf("\n\nALTER TABLE %s OWNER TO %s;\n", utils.MakeFQN(leaf.Schema, leaf.Name), leaf.Owner)
+		entry := toc.MetadataEntry{
+			Schema:          leaf.Schema,
+			Name:            leaf.Name,
+			ObjectType:      "TABLE",
+			ReferenceObject: table.FQN(),
+		}
+		tocfile.AddMetadataEntry("predata", entry, start, metadataFile.ByteCount)
+	}
 }
 
 /*
  * This function is largely derived from the dumpSequence() function in pg_dump.c.  The values of
- * minVal and maxVal come from SEQ_MINVALUE and SEQ_MAXVALUE, defined in include/commands/sequence.h.
+ * minVal and maxVal come from SEQ_MINVALUE and SEQ_MAXVALUE, defined in include/commands/sequence.h,
+ * or from the bounds of the sequence's data type in GPDB 7+.
  */
 func PrintCreateSequenceStatements(metadataFile *utils.FileWithByteCount,
 	toc *toc.TOC, sequences []Sequence, sequenceMetadata MetadataMap) {
-	maxVal := int64(math.MaxInt64)
-	minVal := int64(math.MinInt64)
 	for _, sequence := range sequences {
 		start := metadataFile.ByteCount
 		definition := sequence.Definition
+		minVal, maxVal := getSequenceTypeBounds(definition.Type)
 		metadataFile.MustPrintln("\n\nCREATE SEQUENCE", sequence.FQN())
+		if definition.Type != "" && definition.Type != "bigint" {
+			metadataFile.MustPrintln("\tAS", definition.Type)
+		}
 		if connectionPool.Version.AtLeast("6") {
 			metadataFile.MustPrintln("\tSTART WITH", definition.StartVal)
 		} else if !definition.IsCalled {
@@ -279,6 +506,18 @@ func PrintCreateSequenceStatements(metadataFile *utils.FileWithByteCount,
 	}
 }
 
+// Sequences before GPDB 7 are always bigint; an empty type is treated the same way.
+func getSequenceTypeBounds(seqType string) (int64, int64) {
+	switch seqType {
+	case "smallint":
+		return math.MinInt16, math.MaxInt16
+	case "integer":
+		return math.MinInt32, math.MaxInt32
+	default:
+		return math.MinInt64, math.MaxInt64
+	}
+}
+
 func PrintAlterSequenceStatements(metadataFile *utils.FileWithByteCount,
 	tocfile *toc.TOC, sequences []Sequence) {
 	gplog.Verbose("Writing ALTER SEQUENCE statements to metadata file")
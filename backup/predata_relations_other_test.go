@@ -135,6 +135,50 @@ SELECT pg_catalog.setval('public.seq_name', 7, true);`, getSeqDefReplace()))
 	CACHE 5;
 
 SELECT pg_catalog.setval('public.seq_name', 7, false);`)
+		})
+		It("can print a smallint sequence with default bounds for its type", func() {
+			seqSmallint := backup.Sequence{Relation: baseSequence, Definition: backup.SequenceDefinition{LastVal: 7, Increment: -1, MaxVal: -1, MinVal: math.MinInt16, CacheVal: 1, IsCycled: false, IsCalled: true, Type: "smallint"}}
+			sequences := []backup.Sequence{seqSmallint}
+			backup.PrintCreateSequenceStatements(backupfile, tocfile, sequences, emptySequenceMetadataMap)
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, fmt.Sprintf(`CREATE SEQUENCE public.seq_name
+	AS smallint%s
+	INCREMENT BY -1
+	NO MAXVALUE
+	NO MINVALUE
+	CACHE 1;
+
+SELECT pg_catalog.setval('public.seq_name', 7, true);`, getSeqDefReplace()))
+		})
+		It("can print an integer sequence with non-default values for every option", func() {
+			seqInteger := backup.Sequence{Relation: baseSequence, Definition: backup.SequenceDefinition{LastVal: 40, StartVal: 20, Increment: 5, MaxVal: 1000, MinVal: 10, CacheVal: 30, IsCycled: true, IsCalled: false, Type: "integer"}}
+			startWith := "40"
+			if connectionPool.Version.AtLeast("6") {
+				startWith = "20"
+			}
+			sequences := []backup.Sequence{seqInteger}
+			backup.PrintCreateSequenceStatements(backupfile, tocfile, sequences, emptySequenceMetadataMap)
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, fmt.Sprintf(`CREATE SEQUENCE public.seq_name
+	AS integer
+	START WITH %s
+	INCREMENT BY 5
+	MAXVALUE 1000
+	MINVALUE 10
+	CACHE 30
+	CYCLE;
+
+SELECT pg_catalog.setval('public.seq_name', 40, false);`, startWith))
+		})
+		It("prints the type maximum of a smaller type as an explicit bigint maximum", func() {
+			seqIntMax := backup.Sequence{Relation: baseSequence, Definition: backup.SequenceDefinition{LastVal: 7, Increment: 1, MaxVal: math.MaxInt32, MinVal: 1, CacheVal: 5, IsCycled: false, IsCalled: true}}
+			sequences := []backup.Sequence{seqIntMax}
+			backup.PrintCreateSequenceStatements(backupfile, tocfile, sequences, emptySequenceMetadataMap)
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, fmt.Sprintf(`CREATE SEQUENCE public.seq_name%s
+	INCREMENT BY 1
+	MAXVALUE 2147483647
+	NO MINVALUE
+	CACHE 5;
+
+SELECT pg_catalog.setval('public.seq_name', 7, true);`, getSeqDefReplace()))
 		})
 		It("escapes a sequence containing single quotes", func() {
 			baseSequenceWithQuote := backup.Relation{SchemaOid: 0, Oid: 1, Schema: "public", Name: "seq_'name"}
@@ -436,6 +480,27 @@ GRANT ALL ON shamwow.shazam TO testrole;`}
 			})
 		})
 	})
+	Describe("MergeOrphanedPartitions", func() {
+		regular := backup.Relation{Oid: 1, Schema: "public", Name: "regular"}
+		strandedRule := backup.Relation{Oid: 2, Schema: "public", Name: "sales_1_prt_1"}
+		inherited := backup.Relation{Oid: 3, Schema: "public", Name: "sales_1_prt_2"}
+		other := backup.Relation{Oid: 4, Schema: "public", Name: "other"}
+		tableRelations := []backup.Relation{regular, inherited, other}
+		orphans := []backup.Relation{strandedRule, inherited}
+
+		It("adds the orphaned child partitions missing from the table list", func() {
+			merged := backup.MergeOrphanedPartitions(tableRelations, orphans, false)
+			Expect(merged).To(Equal([]backup.Relation{regular, strandedRule, inherited, other}))
+		})
+		It("removes every orphaned child partition if they are excluded", func() {
+			merged := backup.MergeOrphanedPartitions(tableRelations, orphans, true)
+			Expect(merged).To(Equal([]backup.Relation{regular, other}))
+		})
+		It("leaves the table list unchanged without orphaned child partitions", func() {
+			merged := backup.MergeOrphanedPartitions(tableRelations, []backup.Relation{}, false)
+			Expect(merged).To(Equal(tableRelations))
+		})
+	})
 	Describe("AppendExtPartSuffix", func() {
 		It("adds a suffix to an unquoted external partition table", func() {
 			tablename := "name"
@@ -2,12 +2,21 @@ package backup_test
 
 import (
 	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
 
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/spf13/pflag"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("backup/predata_relations tests", func() {
@@ -43,6 +52,101 @@ var _ = Describe("backup/predata_relations tests", func() {
 FORMAT 'TEXT'
 ENCODING 'UTF-8';`)
 		})
+		It("records column defaults with their types in the TOC", func() {
+			testTable.IsExternal = false
+			testTable.ColumnDefs = []backup.ColumnDefinition{
+				{Oid: 0, Num: 1, Name: "i", Type: "integer"},
+				{Oid: 0, Num: 2, Name: "j", HasDefault: true, Type: "jsonb", DefaultVal: "'{}'::jsonb"},
+				{Oid: 0, Num: 3, Name: "k", HasDefault: true, Type: "text[]", DefaultVal: "ARRAY['a'::text]"},
+			}
+			backup.PrintCreateTableStatement(backupfile, tocfile, testTable, noMetadata)
+			Expect(tocfile.ColumnDefaults).To(Equal([]toc.ColumnDefaultEntry{
+				{Schema: "public", Table: "tablename", Column: "j", Type: "jsonb", Default: "'{}'::jsonb"},
+				{Schema: "public", Table: "tablename", Column: "k", Type: "text[]", Default: "ARRAY['a'::text]"},
+			}))
+		})
+		It("records tables with OIDS in the TOC", func() {
+			testTable.IsExternal = false
+			testTable.HasOids = true
+			backup.PrintCreateTableStatement(backupfile, tocfile, testTable, noMetadata)
+			Expect(tocfile.TablesWithOids).To(Equal([]string{"public.tablename"}))
+		})
+		Context("leaf partition DDL", func() {
+			BeforeEach(func() {
+				_ = cmdFlags.Set(options.LEAF_PARTITION_DDL, "true")
+				testTable.ColumnDefs = []backup.ColumnDefinition{rowOne, rowTwo}
+				testTable.PartDef = "PARTITION BY RANGE(i) (START (1) END (10) EVERY (5))"
+				testTable.PartitionLevelInfo = backup.PartitionLevelInfo{Level: "p"}
+				testTable.PartitionKeys = []backup.PartitionKey{{Level: 0, Kind: "r", Columns: []string{"i"}}}
+				testTable.PartitionChildren = []backup.PartitionChild{
+					{Schema: "public", Name: "tablename_1_prt_1", ParentSchema: "public", ParentName: "tablename", RangeStart: "1", RangeStartInclusive: true, RangeEnd: "5"},
+					{Schema: "public", Name: "tablename_1_prt_2", ParentSchema: "public", ParentName: "tablename", RangeStart: "5", RangeStartInclusive: true, RangeEnd: "10",
+						StorageOpts: "appendonly=true, orientation=column", TablespaceName: "test_tablespace", ColumnEncodings: map[string]string{"i": "compresstype=zlib", "j": "compresstype=none"}},
+					{Schema: "public", Name: "tablename_1_prt_other", ParentSchema: "public", ParentName: "tablename", IsDefault: true},
+				}
+			})
+			It("prints a separate CREATE TABLE and ATTACH PARTITION for each child partition", func() {
+				backup.PrintCreateTableStatement(backupfile, tocfile, testTable, noMetadata)
+				testutils.ExpectEntry(tocfile.PredataEntries, 0, "public", "", "tablename", "TABLE")
+				testutils.ExpectEntry(tocfile.PredataEntries, 1, "public", "public.tablename", "tablename_1_prt_1", "TABLE")
+				testutils.ExpectEntry(tocfile.PredataEntries, 3, "public", "public.tablename", "tablename_1_prt_other", "TABLE")
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer,
+	j character varying(20)
+) DISTRIBUTED RANDOMLY PARTITION BY RANGE (i);`, `CREATE TABLE public.tablename_1_prt_1 (
+	i integer,
+	j character varying(20)
+) DISTRIBUTED RANDOMLY;
+ALTER TABLE ONLY public.tablename ATTACH PARTITION public.tablename_1_prt_1 FOR VALUES FROM (1) TO (5);`, `CREATE TABLE public.tablename_1_prt_2 (
+	i integer ENCODING (compresstype=zlib),
+	j character varying(20) ENCODING (compresstype=none)
+) WITH (appendonly=true, orientation=column) TABLESPACE test_tablespace DISTRIBUTED RANDOMLY;
+ALTER TABLE ONLY public.tablename ATTACH PARTITION public.tablename_1_prt_2 FOR VALUES FROM (5) TO (10);`, `CREATE TABLE public.tablename_1_prt_other (
+	i integer,
+	j character varying(20)
+) DISTRIBUTED RANDOMLY;
+ALTER TABLE ONLY public.tablename ATTACH PARTITION public.tablename_1_prt_other DEFAULT;`)
+			})
+			It("prints a PARTITION BY clause for intermediate partitions and owners for children", func() {
+				testTable.PartitionKeys = append(testTable.PartitionKeys, backup.PartitionKey{Level: 1, Kind: "l", Columns: []string{"j"}})
+				testTable.PartitionChildren = []backup.PartitionChild{
+					{Schema: "public", Name: "tablename_1_prt_1", ParentSchema: "public", ParentName: "tablename", Level: 0, RangeStart: "1", RangeStartInclusive: true},
+					{Schema: "public", Name: "tablename_1_prt_1_2_prt_a", ParentSchema: "public", ParentName: "tablename_1_prt_1", Level: 1, ListValues: "'a'::character varying"},
+				}
+				backup.PrintCreateTableStatement(backupfile, tocfile, testTable, backup.ObjectMetadata{Owner: "testrole"})
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer,
+	j character varying(20)
+) DISTRIBUTED RANDOMLY PARTITION BY RANGE (i);`, `CREATE TABLE public.tablename_1_prt_1 (
+	i integer,
+	j character varying(20)
+) DISTRIBUTED RANDOMLY PARTITION BY LIST (j);
+ALTER TABLE ONLY public.tablename ATTACH PARTITION public.tablename_1_prt_1 FOR VALUES FROM (1) TO (MAXVALUE);
+ALTER TABLE public.tablename_1_prt_1 OWNER TO testrole;`, `CREATE TABLE public.tablename_1_prt_1_2_prt_a (
+	i integer,
+	j character varying(20)
+) DISTRIBUTED RANDOMLY;
+ALTER TABLE ONLY public.tablename_1_prt_1 ATTACH PARTITION public.tablename_1_prt_1_2_prt_a FOR VALUES IN ('a'::character varying);
+ALTER TABLE public.tablename_1_prt_1_2_prt_a OWNER TO testrole;`, "ALTER TABLE public.tablename OWNER TO testrole;")
+			})
+			It("prints the compact partition definition when a leaf partition is external", func() {
+				testTable.PartitionChildren[1].IsExternal = true
+				backup.PrintCreateTableStatement(backupfile, tocfile, testTable, noMetadata)
+				Expect(tocfile.PredataEntries).To(HaveLen(1))
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer,
+	j character varying(20)
+) DISTRIBUTED RANDOMLY PARTITION BY RANGE(i) (START (1) END (10) EVERY (5));`)
+			})
+			It("prints the compact partition definition when the flag is not set", func() {
+				_ = cmdFlags.Set(options.LEAF_PARTITION_DDL, "false")
+				backup.PrintCreateTableStatement(backupfile, tocfile, testTable, noMetadata)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer,
+	j character varying(20)
+) DISTRIBUTED RANDOMLY PARTITION BY RANGE(i) (START (1) END (10) EVERY (5));`)
+			})
+		})
 	})
 	Describe("PrintRegularTableCreateStatement", func() {
 		rowOneEncoding := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", Encoding: "compresstype=none,blocksize=32768,compresslevel=0", StatTarget: -1}
@@ -58,6 +162,11 @@ ENCODING 'UTF-8';`)
 		colOptions := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", Options: "n_distinct=1", StatTarget: -1}
 		colStorageType := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, StorageType: "PLAIN"}
 		colWithCollation := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "c", Type: "character (8)", StatTarget: -1, Collation: "public.some_coll"}
+		colIdentityAlways := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", NotNull: true, Type: "integer", StatTarget: -1, Identity: "a", IdentitySequence: "public.tablename_i_seq",
+			IdentityStart: 1, IdentityIncrement: 1, IdentityMin: 1, IdentityMax: 2147483647, IdentityCache: 1}
+		colIdentityByDefault := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", NotNull: true, Type: "bigint", StatTarget: -1, Identity: "d", IdentitySequence: "public.tablename_j_seq",
+			IdentityStart: 100, IdentityIncrement: -5, IdentityMin: -1000, IdentityMax: 100, IdentityCache: 20, IdentityCycle: true}
+		colGenerated := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", HasDefault: true, Type: "integer", StatTarget: -1, DefaultVal: "(i * 2)", GenerationExpr: "(i * 2)"}
 
 		Context("No special table attributes", func() {
 			It("prints a CREATE TABLE OF type block with one attribute", func() {
@@ -94,6 +203,23 @@ ENCODING 'UTF-8';`)
 ) DISTRIBUTED RANDOMLY;`)
 			})
 		})
+		Context("Very wide tables", func() {
+			It("prints a CREATE TABLE block with 1600 attributes", func() {
+				columnDefs := make([]backup.ColumnDefinition, 1600)
+				columnLines := make([]string, 1600)
+				for i := range columnDefs {
+					columnDefs[i] = backup.ColumnDefinition{Num: i + 1, Name: fmt.Sprintf("c%04d", i+1), Type: "integer", HasDefault: i%2 == 0, DefaultVal: "0", StatTarget: -1}
+					columnLines[i] = fmt.Sprintf("\tc%04d integer", i+1)
+					if i%2 == 0 {
+						columnLines[i] += " DEFAULT 0"
+					}
+				}
+				testTable.ColumnDefs = columnDefs
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, fmt.Sprintf("CREATE TABLE public.tablename (\n%s\n) DISTRIBUTED RANDOMLY;", strings.Join(columnLines, ",\n")))
+				Expect(tocfile.PredataEntries[0].EndByte).To(Equal(uint64(len(buffer.Contents()))))
+			})
+		})
 		Context("One special table attribute", func() {
 			It("prints a CREATE TABLE block where one line has the given ENCODING and the other has the default ENCODING", func() {
 				col := []backup.ColumnDefinition{rowOneEncoding, rowTwoEncoding}
@@ -148,6 +274,24 @@ ENCODING 'UTF-8';`)
 				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
 	i integer,
 	c character (8) COLLATE public.some_coll
+) DISTRIBUTED RANDOMLY;`)
+			})
+			It("prints a CREATE TABLE block where one line contains COMPRESSION", func() {
+				colWithCompression := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "t", Type: "text", StatTarget: -1, CompressionType: "lz4"}
+				testTable.ColumnDefs = []backup.ColumnDefinition{rowOne, colWithCompression}
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer,
+	t text COMPRESSION lz4
+) DISTRIBUTED RANDOMLY;`)
+			})
+			It("prints COMPRESSION before COLLATE", func() {
+				col := colWithCollation
+				col.CompressionType = "pglz"
+				testTable.ColumnDefs = []backup.ColumnDefinition{col}
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	c character (8) COMPRESSION pglz COLLATE public.some_coll
 ) DISTRIBUTED RANDOMLY;`)
 			})
 			It("prints a CREATE TABLE block followed by an ALTER COLUMN ... SET STATISTICS statement", func() {
@@ -207,6 +351,33 @@ ALTER TABLE ONLY public.tablename ALTER COLUMN i SET (n_distinct=1);`)
 				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
 	i integer ENCODING (compresstype=none,blocksize=32768,compresslevel=0),
 	j character varying(20) DEFAULT 'bar'::text ENCODING (compresstype=zlib,blocksize=65536,compresslevel=1)
+) DISTRIBUTED RANDOMLY;`)
+			})
+			It("prints a CREATE TABLE block with GENERATED ALWAYS and GENERATED BY DEFAULT identity columns", func() {
+				col := []backup.ColumnDefinition{colIdentityAlways, colIdentityByDefault}
+				testTable.ColumnDefs = col
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer GENERATED ALWAYS AS IDENTITY (SEQUENCE NAME tablename_i_seq START WITH 1 INCREMENT BY 1 MINVALUE 1 MAXVALUE 2147483647 CACHE 1) NOT NULL,
+	j bigint GENERATED BY DEFAULT AS IDENTITY (SEQUENCE NAME tablename_j_seq START WITH 100 INCREMENT BY -5 MINVALUE -1000 MAXVALUE 100 CACHE 20 CYCLE) NOT NULL
+) DISTRIBUTED RANDOMLY;`)
+			})
+			It("schema-qualifies an identity sequence in a different schema than its table", func() {
+				colOtherSchema := colIdentityAlways
+				colOtherSchema.IdentitySequence = "other.tablename_i_seq"
+				testTable.ColumnDefs = []backup.ColumnDefinition{colOtherSchema}
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer GENERATED ALWAYS AS IDENTITY (SEQUENCE NAME other.tablename_i_seq START WITH 1 INCREMENT BY 1 MINVALUE 1 MAXVALUE 2147483647 CACHE 1) NOT NULL
+) DISTRIBUTED RANDOMLY;`)
+			})
+			It("prints a CREATE TABLE block with a stored generated column", func() {
+				col := []backup.ColumnDefinition{rowOne, colGenerated}
+				testTable.ColumnDefs = col
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer,
+	j integer GENERATED ALWAYS AS ((i * 2)) STORED
 ) DISTRIBUTED RANDOMLY;`)
 			})
 			It("prints a CREATE TABLE block where one line contains all three of DEFAULT, NOT NULL, and ENCODING", func() {
@@ -307,6 +478,23 @@ ALTER TABLE ONLY public.tablename ALTER COLUMN i SET (n_distinct=1);`)
 	i integer,
 	j character varying(20)
 ) WITH (fillfactor=42) DISTRIBUTED RANDOMLY;`)
+			})
+			It("is a heap table with OIDS", func() {
+				testTable.HasOids = true
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer,
+	j character varying(20)
+) WITH (oids=true) DISTRIBUTED RANDOMLY;`)
+			})
+			It("is a heap table with a fill factor and OIDS", func() {
+				testTable.StorageOpts = heapFillOpts
+				testTable.HasOids = true
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+	i integer,
+	j character varying(20)
+) WITH (fillfactor=42, oids=true) DISTRIBUTED RANDOMLY;`)
 			})
 			It("is a heap table with a fill factor and a single-column distribution key", func() {
 				testTable.DistPolicy = distSingle
@@ -422,6 +610,15 @@ SET SUBPARTITION TEMPLATE
           );`)
 			})
 		})
+		Context("Access methods", func() {
+			It("prints a USING clause for a table with a non-default access method", func() {
+				testTable.AccessMethod = "ao_column"
+				testTable.StorageOpts = "compresstype=zstd"
+				backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+				testutils.AssertBufferContents(tocfile.PredataEntries, buffer, `CREATE TABLE public.tablename (
+) USING ao_column WITH (compresstype=zstd) DISTRIBUTED RANDOMLY;`)
+			})
+		})
 		Context("Tablespaces", func() {
 			It("prints a CREATE TABLE block with a TABLESPACE clause", func() {
 				testTable.TablespaceName = "test_tablespace"
@@ -529,6 +726,17 @@ SET SUBPARTITION TEMPLATE
 
 COMMENT ON TABLE public.tablename IS 'This is a table comment.';`)
 		})
+		It("sets the last value of an identity sequence that has been used", func() {
+			usedIdentity := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, Identity: "a", IdentitySequence: "public.tablename_i_seq",
+				IdentityLastVal: sql.NullInt64{Int64: 42, Valid: true}}
+			unusedIdentity := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", Type: "integer", StatTarget: -1, Identity: "d", IdentitySequence: "public.tablename_j_seq"}
+			testTable.ColumnDefs = []backup.ColumnDefinition{usedIdentity, unusedIdentity}
+			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, noMetadata)
+			testhelper.ExpectRegexp(buffer, `
+
+SELECT pg_catalog.setval('public.tablename_i_seq', 42, true);`)
+			testhelper.NotExpectRegexp(buffer, `tablename_j_seq`)
+		})
 		It("prints a block with a single column comment", func() {
 			col := []backup.ColumnDefinition{rowCommentOne}
 			testTable.ColumnDefs = col
@@ -547,6 +755,12 @@ COMMENT ON COLUMN public.tablename.i IS 'This is a column comment.';`)
 
 COMMENT ON COLUMN public.tablename.i IS 'This is a ta''ble 1+=;,./\>,<@\\n^comment.';`)
 		})
+		It("prints a column comment containing a quote, a newline, and a trailing backslash", func() {
+			rowCommentSpecialCharacters := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, Comment: "driver's license #\nissued by state\\"}
+			testTable.ColumnDefs = []backup.ColumnDefinition{rowCommentSpecialCharacters}
+			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, noMetadata)
+			testhelper.ExpectRegexp(buffer, "COMMENT ON COLUMN public.tablename.i IS 'driver''s license #\nissued by state\\';")
+		})
 		It("prints a block with multiple column comments", func() {
 			col := []backup.ColumnDefinition{rowCommentOne, rowCommentTwo}
 			testTable.ColumnDefs = col
@@ -576,6 +790,11 @@ ALTER TABLE public.tablename OWNER TO testrole;`)
 
 SECURITY LABEL FOR dummy ON TABLE public.tablename IS 'unclassified';`)
 		})
+		It("escapes single quotes in a table SECURITY LABEL", func() {
+			tableMetadata := backup.ObjectMetadata{SecurityLabelProvider: "dummy", SecurityLabel: "it's classified"}
+			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, tableMetadata)
+			testhelper.ExpectRegexp(buffer, `SECURITY LABEL FOR dummy ON TABLE public.tablename IS 'it''s classified';`)
+		})
 		It("does not print an ALTER TABLE... REPLICA IDENTITY for foreign tables", func() {
 			testTable.ForeignDef = backup.ForeignTableDefinition{Options: "", Server: "fs"}
 			testTable.ReplicaIdentity = "n"
@@ -623,8 +842,8 @@ COMMENT ON COLUMN public.tablename.i IS 'This is a column comment.';
 COMMENT ON COLUMN public.tablename.j IS 'This is another column comment.';`)
 		})
 		It("prints a GRANT statement on a table column", func() {
-			privilegesColumnOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, Privileges: sql.NullString{String: "testrole=r/testrole", Valid: true}}
-			privilegesColumnTwo := backup.ColumnDefinition{Oid: 1, Num: 2, Name: "j", Type: "character varying(20)", StatTarget: -1, Privileges: sql.NullString{String: "testrole2=arwx/testrole2", Valid: true}}
+			privilegesColumnOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, ACL: []backup.ACL{{Grantee: "testrole", Grantor: "testrole", Select: true}}}
+			privilegesColumnTwo := backup.ColumnDefinition{Oid: 1, Num: 2, Name: "j", Type: "character varying(20)", StatTarget: -1, ACL: []backup.ACL{{Grantee: "testrole2", Grantor: "testrole2", Select: true, Insert: true, Update: true, References: true}}}
 			col := []backup.ColumnDefinition{privilegesColumnOne, privilegesColumnTwo}
 			testTable.ColumnDefs = col
 			tableMetadata := backup.ObjectMetadata{Owner: "testrole"}
@@ -642,6 +861,64 @@ GRANT SELECT (i) ON TABLE public.tablename TO testrole;
 REVOKE ALL (j) ON TABLE public.tablename FROM PUBLIC;
 REVOKE ALL (j) ON TABLE public.tablename FROM testrole;
 GRANT ALL (j) ON TABLE public.tablename TO testrole2;`)
+		})
+		It("prints GRANT statements for every grantee of a table column", func() {
+			privilegesColumnOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: `"Social Security"`, Type: "text", StatTarget: -1, ACL: []backup.ACL{
+				{Grantee: "auditor", Grantor: "testrole", Select: true},
+				{Grantee: "hr", Grantor: "testrole", Select: true, UpdateWithGrant: true},
+			}}
+			privilegesColumnTwo := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", Type: "integer", StatTarget: -1, ACL: []backup.ACL{
+				{Grantee: "", Grantor: "testrole", Select: true},
+			}}
+			testTable.ColumnDefs = []backup.ColumnDefinition{privilegesColumnOne, privilegesColumnTwo}
+			tableMetadata := backup.ObjectMetadata{Owner: "testrole"}
+			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, tableMetadata)
+			testhelper.ExpectRegexp(buffer, `
+
+ALTER TABLE public.tablename OWNER TO testrole;
+
+
+REVOKE ALL ("Social Security") ON TABLE public.tablename FROM PUBLIC;
+REVOKE ALL ("Social Security") ON TABLE public.tablename FROM testrole;
+GRANT SELECT ("Social Security") ON TABLE public.tablename TO auditor;
+GRANT SELECT ("Social Security") ON TABLE public.tablename TO hr;
+GRANT UPDATE ("Social Security") ON TABLE public.tablename TO hr WITH GRANT OPTION;
+
+
+REVOKE ALL (j) ON TABLE public.tablename FROM PUBLIC;
+REVOKE ALL (j) ON TABLE public.tablename FROM testrole;
+GRANT SELECT (j) ON TABLE public.tablename TO PUBLIC;`)
+		})
+		It("prints a column grant on a table that grants nothing", func() {
+			ssnColumn := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "ssn", Type: "text", StatTarget: -1, ACL: []backup.ACL{{Grantee: "reader", Select: true}}}
+			testTable.ColumnDefs = []backup.ColumnDefinition{ssnColumn}
+			tableMetadata := backup.ObjectMetadata{Owner: "testrole", Privileges: []backup.ACL{{Grantee: "GRANTEE"}}}
+			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, tableMetadata)
+			testhelper.ExpectRegexp(buffer, `
+
+ALTER TABLE public.tablename OWNER TO testrole;
+
+
+REVOKE ALL ON TABLE public.tablename FROM PUBLIC;
+REVOKE ALL ON TABLE public.tablename FROM testrole;
+
+
+REVOKE ALL (ssn) ON TABLE public.tablename FROM PUBLIC;
+REVOKE ALL (ssn) ON TABLE public.tablename FROM testrole;
+GRANT SELECT (ssn) ON TABLE public.tablename TO reader;`)
+		})
+		It("prints only REVOKE statements for a table column with an empty ACL", func() {
+			privilegesColumnOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, ACL: []backup.ACL{{Grantee: "GRANTEE", Grantor: "GRANTOR"}}}
+			testTable.ColumnDefs = []backup.ColumnDefinition{privilegesColumnOne}
+			tableMetadata := backup.ObjectMetadata{Owner: "testrole"}
+			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, tableMetadata)
+			testhelper.ExpectRegexp(buffer, `
+
+ALTER TABLE public.tablename OWNER TO testrole;
+
+
+REVOKE ALL (i) ON TABLE public.tablename FROM PUBLIC;
+REVOKE ALL (i) ON TABLE public.tablename FROM testrole;`)
 		})
 		It("prints a security group statement on a table column", func() {
 			privilegesColumnOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, SecurityLabelProvider: "dummy", SecurityLabel: "unclassified"}
@@ -656,6 +933,12 @@ SECURITY LABEL FOR dummy ON COLUMN public.tablename.i IS 'unclassified';
 
 SECURITY LABEL FOR dummy ON COLUMN public.tablename.j IS 'unclassified';`)
 		})
+		It("escapes single quotes in a column SECURITY LABEL", func() {
+			labeledColumn := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, SecurityLabelProvider: "dummy", SecurityLabel: "it's classified"}
+			testTable.ColumnDefs = []backup.ColumnDefinition{labeledColumn}
+			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, backup.ObjectMetadata{})
+			testhelper.ExpectRegexp(buffer, `SECURITY LABEL FOR dummy ON COLUMN public.tablename.i IS 'it''s classified';`)
+		})
 		It("prints altered schemas of child partitions different from the root partition", func() {
 			testTable.PartitionAlteredSchemas = []backup.AlteredPartitionRelation{
 				{OldSchema: "schema1", NewSchema: "schema2", Name: "table1"},
@@ -669,5 +952,43 @@ ALTER TABLE schema1.table1 SET SCHEMA schema2;
 
 ALTER TABLE schema2.table2 SET SCHEMA schema1;`)
 		})
+		It("prints owners of child partitions that differ from the root partition owner", func() {
+			testTable.PartitionLeafOwners = []backup.PartitionLeafOwner{
+				{Schema: "public", Name: "tablename_1_prt_1", Owner: "testrole2"},
+				{Schema: "schema2", Name: "tablename_1_prt_2", Owner: "testrole3"},
+			}
+			tableMetadata := backup.ObjectMetadata{Owner: "testrole"}
+			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, tableMetadata)
+			testhelper.ExpectRegexp(buffer, `
+
+ALTER TABLE public.tablename OWNER TO testrole;
+
+
+ALTER TABLE public.tablename_1_prt_1 OWNER TO testrole2;
+
+
+ALTER TABLE schema2.tablename_1_prt_2 OWNER TO testrole3;`)
+			testutils.ExpectEntry(tocfile.PredataEntries, 1, "public", "public.tablename", "tablename_1_prt_1", "TABLE")
+			testutils.ExpectEntry(tocfile.PredataEntries, 2, "schema2", "public.tablename", "tablename_1_prt_2", "TABLE")
+		})
 	})
 })
+
+func BenchmarkPrintRegularTableCreateStatementWideTable(b *testing.B) {
+	backup.SetCmdFlags(pflag.NewFlagSet("gpbackup", pflag.ExitOnError))
+	columnDefs := make([]backup.ColumnDefinition, 1600)
+	for i := range columnDefs {
+		columnDefs[i] = backup.ColumnDefinition{Num: i + 1, Name: fmt.Sprintf("c%04d", i+1), Type: "character varying(255)",
+			HasDefault: true, DefaultVal: "'default'::character varying", Encoding: "compresstype=zlib,blocksize=32768,compresslevel=1", StatTarget: -1}
+	}
+	table := backup.Table{
+		Relation:        backup.Relation{Schema: "public", Name: "widetable"},
+		TableDefinition: backup.TableDefinition{DistPolicy: "DISTRIBUTED RANDOMLY", ColumnDefs: columnDefs},
+	}
+	metadataFile := utils.NewFileWithByteCount(ioutil.Discard)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		backup.PrintRegularTableCreateStatement(metadataFile, nil, table)
+	}
+}
@@ -7,6 +7,10 @@ package backup
  */
 
 import (
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 )
@@ -17,20 +21,27 @@ import (
  */
 func PrintConstraintStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, constraints []Constraint, conMetadata MetadataMap) {
 	allConstraints := make([]Constraint, 0)
+	allUniqueConstraints := make([]Constraint, 0)
 	allFkConstraints := make([]Constraint, 0)
 	/*
 	 * Because FOREIGN KEY constraints must be backed up after PRIMARY KEY
 	 * constraints, we separate the two types then concatenate the lists,
-	 * so FOREIGN KEY are guaranteed to be printed last.
+	 * so FOREIGN KEY are guaranteed to be printed last.  EXCLUDE constraints
+	 * build an index just as UNIQUE constraints do, so the two are grouped
+	 * together ahead of the FOREIGN KEY constraints.
 	 */
 	for _, constraint := range constraints {
-		if constraint.ConType == "f" {
+		switch constraint.ConType {
+		case "f":
 			allFkConstraints = append(allFkConstraints, constraint)
-		} else {
+		case "u", "x":
+			allUniqueConstraints = append(allUniqueConstraints, constraint)
+		default:
 			allConstraints = append(allConstraints, constraint)
 		}
 	}
-	constraints = append(allConstraints, allFkConstraints...)
+	constraints = append(allConstraints, allUniqueConstraints...)
+	constraints = append(constraints, sortForeignKeyConstraints(allFkConstraints)...)
 
 	alterStr := "\n\nALTER %s %s ADD CONSTRAINT %s %s;\n"
 	for _, constraint := range constraints {
@@ -39,11 +50,20 @@ func PrintConstraintStatements(metadataFile *utils.FileWithByteCount, toc *toc.T
 			continue
 		}
 		// ConIsLocal should always return true from GetConstraints because we filter out constraints that are inherited using the INHERITS clause, or inherited from a parent partition table. This field only accurately reflects constraints in GPDB6+ because check constraints on parent tables must propogate to children. For GPDB versions 5 or lower, this field will default to false.
+		// A CHECK constraint on a plain inheritance parent is printed without ONLY so that its children inherit it again, unless it is NO INHERIT or some child does not have it.
 		objStr := "TABLE ONLY"
-		if constraint.IsPartitionParent || (constraint.ConType == "c" && constraint.ConIsLocal) {
+		if constraint.IsPartitionParent {
 			objStr = "TABLE"
+		} else if constraint.ConType == "c" && !constraint.ConNoInherit {
+			if constraint.IsInheritanceParent {
+				if !constraint.IsParentOnly {
+					objStr = "TABLE"
+				}
+			} else if constraint.ConIsLocal {
+				objStr = "TABLE"
+			}
 		}
-		metadataFile.MustPrintf(alterStr, objStr, constraint.OwningObject, constraint.Name, constraint.ConDef.String)
+		metadataFile.MustPrintf(alterStr, objStr, constraint.OwningObject, constraint.Name, constraintDefinition(constraint))
 
 		section, entry := constraint.GetMetadataEntry()
 		toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
@@ -51,15 +71,100 @@ func PrintConstraintStatements(metadataFile *utils.FileWithByteCount, toc *toc.T
 	}
 }
 
+/*
+ * Orders FOREIGN KEY constraints so that the foreign keys of a referenced
+ * table are printed before those of the tables referencing it, keeping the
+ * given order otherwise.  A foreign key referencing its own table does not
+ * constrain the order.  If the foreign keys form a cycle, those in or
+ * depending on the cycle are printed last in the given order.
+ */
+func sortForeignKeyConstraints(fkConstraints []Constraint) []Constraint {
+	pending := make(map[string]int)
+	for _, constraint := range fkConstraints {
+		pending[constraint.OwningObject]++
+	}
+	sorted := make([]Constraint, 0, len(fkConstraints))
+	remaining := fkConstraints
+	for len(remaining) > 0 {
+		ready := make([]Constraint, 0)
+		deferred := make([]Constraint, 0)
+		for _, constraint := range remaining {
+			if constraint.ReferencedObject == constraint.OwningObject || pending[constraint.ReferencedObject] == 0 {
+				ready = append(ready, constraint)
+			} else {
+				deferred = append(deferred, constraint)
+			}
+		}
+		if len(ready) == 0 {
+			tableNames := make([]string, 0)
+			for _, constraint := range deferred {
+				if !utils.Exists(tableNames, constraint.OwningObject) {
+					tableNames = append(tableNames, constraint.OwningObject)
+				}
+			}
+			report.Warn(report.WARN_FOREIGN_KEY_CYCLE, "", "The foreign keys of the following tables form a cycle, so they are printed without ordering by dependency: %s",
+				strings.Join(tableNames, ", "))
+			sorted = append(sorted, deferred...)
+			break
+		}
+		for _, constraint := range ready {
+			pending[constraint.OwningObject]--
+		}
+		sorted = append(sorted, ready...)
+		remaining = deferred
+	}
+	return sorted
+}
+
+/*
+ * Some versions of pg_get_constraintdef give the deferrability of a constraint
+ * and some do not, so any that it gives are removed and the attributes given
+ * by condeferrable and condeferred are added in their place, ahead of NOT
+ * VALID.  A deferrable constraint is given INITIALLY IMMEDIATE explicitly
+ * when it is not initially deferred.  Only PRIMARY KEY, UNIQUE, FOREIGN KEY, and EXCLUDE constraints can
+ * be deferred, and only CHECK and FOREIGN KEY constraints can be left
+ * unvalidated.
+ */
+func constraintDefinition(constraint Constraint) string {
+	conDef := constraint.ConDef.String
+	notValid := strings.HasSuffix(conDef, " NOT VALID") ||
+		(!constraint.IsValid && (constraint.ConType == "c" || constraint.ConType == "f"))
+	conDef = strings.TrimSuffix(conDef, " NOT VALID")
+	switch constraint.ConType {
+	case "p", "u", "f", "x":
+		conDef = strings.TrimSuffix(conDef, " INITIALLY DEFERRED")
+		conDef = strings.TrimSuffix(conDef, " INITIALLY IMMEDIATE")
+		conDef = strings.TrimSuffix(conDef, " DEFERRABLE")
+		if constraint.ConDeferrable {
+			conDef += " DEFERRABLE"
+			if !constraint.ConDeferred {
+				conDef += " INITIALLY IMMEDIATE"
+			}
+		}
+		if constraint.ConDeferred {
+			conDef += " INITIALLY DEFERRED"
+		}
+	}
+	if notValid {
+		conDef += " NOT VALID"
+	}
+	return conDef
+}
+
 func PrintCreateSchemaStatements(metadataFile *utils.FileWithByteCount, toc *toc.TOC, schemas []Schema, schemaMetadata MetadataMap) {
 	for _, schema := range schemas {
 		start := metadataFile.ByteCount
 		metadataFile.MustPrintln()
-		if schema.Name != "public" {
+		metadata := schemaMetadata[schema.GetUniqueID()]
+		// The public schema already exists, so its owner can only be set with ALTER SCHEMA
+		useAuthorization := MustGetFlagBool(options.SCHEMA_AUTHORIZATION) && schema.Name != "public" && metadata.Owner != ""
+		if useAuthorization {
+			metadataFile.MustPrintf("\nCREATE SCHEMA %s AUTHORIZATION %s;", schema.Name, metadata.Owner)
+		} else if schema.Name != "public" {
 			metadataFile.MustPrintf("\nCREATE SCHEMA %s;", schema.Name)
 		}
 		section, entry := schema.GetMetadataEntry()
 		toc.AddMetadataEntry(section, entry, start, metadataFile.ByteCount)
-		PrintObjectMetadata(metadataFile, toc, schemaMetadata[schema.GetUniqueID()], schema, "")
+		printObjectMetadata(metadataFile, toc, metadata, schema, "", !useAuthorization)
 	}
 }
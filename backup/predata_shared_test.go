@@ -5,9 +5,11 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/testutils"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 )
 
 var _ = Describe("backup/predata_shared tests", func() {
@@ -22,15 +24,17 @@ var _ = Describe("backup/predata_shared tests", func() {
 			primaryComposite backup.Constraint
 			foreignOne       backup.Constraint
 			foreignTwo       backup.Constraint
+			exclusion        backup.Constraint
 			emptyMetadataMap backup.MetadataMap
 		)
 		BeforeEach(func() {
-			uniqueOne = backup.Constraint{Oid: 1, Name: "tablename_i_key", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (i)", Valid: true}, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
-			uniqueTwo = backup.Constraint{Oid: 0, Name: "tablename_j_key", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (j)", Valid: true}, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
-			primarySingle = backup.Constraint{Oid: 0, Name: "tablename_pkey", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (i)", Valid: true}, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
-			primaryComposite = backup.Constraint{Oid: 0, Name: "tablename_pkey", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (i, j)", Valid: true}, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
-			foreignOne = backup.Constraint{Oid: 0, Name: "tablename_i_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (i) REFERENCES other_tablename(a)", Valid: true}, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
-			foreignTwo = backup.Constraint{Oid: 0, Name: "tablename_j_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (j) REFERENCES other_tablename(b)", Valid: true}, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
+			uniqueOne = backup.Constraint{Oid: 1, Name: "tablename_i_key", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (i)", Valid: true}, IsValid: true, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
+			uniqueTwo = backup.Constraint{Oid: 0, Name: "tablename_j_key", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (j)", Valid: true}, IsValid: true, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
+			primarySingle = backup.Constraint{Oid: 0, Name: "tablename_pkey", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (i)", Valid: true}, IsValid: true, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
+			primaryComposite = backup.Constraint{Oid: 0, Name: "tablename_pkey", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (i, j)", Valid: true}, IsValid: true, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
+			foreignOne = backup.Constraint{Oid: 0, Name: "tablename_i_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (i) REFERENCES other_tablename(a)", Valid: true}, IsValid: true, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
+			foreignTwo = backup.Constraint{Oid: 0, Name: "tablename_j_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (j) REFERENCES other_tablename(b)", Valid: true}, IsValid: true, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
+			exclusion = backup.Constraint{Oid: 0, Name: "tablename_i_excl", ConType: "x", ConDef: sql.NullString{String: "EXCLUDE USING btree (i WITH =)", Valid: true}, IsValid: true, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false}
 			emptyMetadataMap = backup.MetadataMap{}
 		})
 
@@ -49,6 +53,12 @@ var _ = Describe("backup/predata_shared tests", func() {
 				testutils.ExpectEntry(tocfile.PostdataEntries, 0, "", "public.tablename", "tablename_i_key", "CONSTRAINT")
 				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_i_key UNIQUE (i);", "COMMENT ON CONSTRAINT tablename_i_key ON public.tablename IS 'This is a constraint comment.';")
 			})
+			It("prints a constraint comment containing a quote, a newline, and a trailing backslash", func() {
+				constraints := []backup.Constraint{uniqueOne}
+				constraintMetadataMap := backup.MetadataMap{uniqueOne.GetUniqueID(): {Comment: "driver's license #\nissued by state\\"}}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, constraintMetadataMap)
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, "ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_i_key UNIQUE (i);", "COMMENT ON CONSTRAINT tablename_i_key ON public.tablename IS 'driver''s license #\nissued by state\\';")
+			})
 			It("prints an ADD CONSTRAINT statement for one UNIQUE constraint", func() {
 				constraints := []backup.Constraint{uniqueOne}
 				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
@@ -104,6 +114,21 @@ var _ = Describe("backup/predata_shared tests", func() {
 					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_pkey PRIMARY KEY (i, j);`,
 					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_j_fkey FOREIGN KEY (j) REFERENCES other_tablename(b);`)
 			})
+			It("prints an ADD CONSTRAINT statement for one EXCLUDE constraint", func() {
+				constraints := []backup.Constraint{exclusion}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
+				testutils.ExpectEntry(tocfile.PostdataEntries, 0, "", "public.tablename", "tablename_i_excl", "CONSTRAINT")
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, `ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_i_excl EXCLUDE USING btree (i WITH =);`)
+			})
+			It("prints EXCLUDE constraints with UNIQUE constraints, after PRIMARY KEY and before FOREIGN KEY constraints", func() {
+				constraints := []backup.Constraint{foreignTwo, exclusion, primarySingle, uniqueOne}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_pkey PRIMARY KEY (i);`,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_i_excl EXCLUDE USING btree (i WITH =);`,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_i_key UNIQUE (i);`,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_j_fkey FOREIGN KEY (j) REFERENCES other_tablename(b);`)
+			})
 		})
 		Context("Constraints involving the same column", func() {
 			It("prints ADD CONSTRAINT statements for one UNIQUE constraint and one FOREIGN KEY constraint", func() {
@@ -128,7 +153,7 @@ var _ = Describe("backup/predata_shared tests", func() {
 					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT tablename_i_fkey FOREIGN KEY (i) REFERENCES other_tablename(a);`)
 			})
 			It("doesn't print an ADD CONSTRAINT statement for domain check constraint", func() {
-				domainCheckConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (VALUE <> 42::numeric)", Valid: true}, OwningObject: "public.domain1", IsDomainConstraint: true, IsPartitionParent: false}
+				domainCheckConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (VALUE <> 42::numeric)", Valid: true}, IsValid: true, OwningObject: "public.domain1", IsDomainConstraint: true, IsPartitionParent: false}
 				constraints := []backup.Constraint{domainCheckConstraint}
 				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
 				testhelper.NotExpectRegexp(buffer, `ALTER DOMAIN`)
@@ -140,11 +165,115 @@ var _ = Describe("backup/predata_shared tests", func() {
 				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, `ALTER TABLE public.tablename ADD CONSTRAINT tablename_i_key UNIQUE (i);`)
 			})
 			It("prints an ADD CONSTRAINT [name] CHECK statement without keyword ONLY for a table with descendants (another table inherits it)", func() {
-				checkConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (VALUE <> 42::numeric)", Valid: true}, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false, ConIsLocal: true}
+				checkConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (VALUE <> 42::numeric)", Valid: true}, IsValid: true, OwningObject: "public.tablename", IsDomainConstraint: false, IsPartitionParent: false, ConIsLocal: true}
 				constraints := []backup.Constraint{checkConstraint}
 				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
 				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, `ALTER TABLE public.tablename ADD CONSTRAINT check1 CHECK (VALUE <> 42::numeric);`)
 			})
+			It("prints an inheritable CHECK constraint on an inheritance parent without keyword ONLY", func() {
+				checkConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (i <> 42)", Valid: true}, IsValid: true, OwningObject: "public.parent", IsInheritanceParent: true}
+				constraints := []backup.Constraint{checkConstraint}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, `ALTER TABLE public.parent ADD CONSTRAINT check1 CHECK (i <> 42);`)
+			})
+			It("prints a NO INHERIT CHECK constraint on an inheritance parent with keyword ONLY", func() {
+				checkConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (i <> 42) NO INHERIT", Valid: true}, IsValid: true, OwningObject: "public.parent", ConIsLocal: true, ConNoInherit: true, IsInheritanceParent: true}
+				constraints := []backup.Constraint{checkConstraint}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, `ALTER TABLE ONLY public.parent ADD CONSTRAINT check1 CHECK (i <> 42) NO INHERIT;`)
+			})
+			It("prints a CHECK constraint that exists only on an inheritance parent with keyword ONLY", func() {
+				checkConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (i <> 42)", Valid: true}, IsValid: true, OwningObject: "public.parent", ConIsLocal: true, IsInheritanceParent: true, IsParentOnly: true}
+				constraints := []backup.Constraint{checkConstraint}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, `ALTER TABLE ONLY public.parent ADD CONSTRAINT check1 CHECK (i <> 42);`)
+			})
+		})
+		Context("Deferrable constraints", func() {
+			DescribeTable("prints the deferrability of a constraint after its definition",
+				func(conType string, conDef string, deferrable bool, deferred bool, expected string) {
+					constraint := backup.Constraint{Oid: 0, Name: "con1", ConType: conType, ConDef: sql.NullString{String: conDef, Valid: true}, IsValid: true, OwningObject: "public.tablename",
+						ConDeferrable: deferrable, ConDeferred: deferred}
+					backup.PrintConstraintStatements(backupfile, tocfile, []backup.Constraint{constraint}, emptyMetadataMap)
+					testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, expected)
+				},
+				Entry("a UNIQUE constraint that is not deferrable", "u", "UNIQUE (i)", false, false,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 UNIQUE (i);`),
+				Entry("a UNIQUE constraint that is DEFERRABLE INITIALLY IMMEDIATE", "u", "UNIQUE (i)", true, false,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 UNIQUE (i) DEFERRABLE INITIALLY IMMEDIATE;`),
+				Entry("a UNIQUE constraint that is DEFERRABLE INITIALLY DEFERRED", "u", "UNIQUE (i)", true, true,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 UNIQUE (i) DEFERRABLE INITIALLY DEFERRED;`),
+				Entry("a UNIQUE constraint that is only INITIALLY DEFERRED", "u", "UNIQUE (i)", false, true,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 UNIQUE (i) INITIALLY DEFERRED;`),
+				Entry("a PRIMARY KEY constraint", "p", "PRIMARY KEY (i)", true, true,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 PRIMARY KEY (i) DEFERRABLE INITIALLY DEFERRED;`),
+				Entry("a FOREIGN KEY constraint whose definition already gives its deferrability", "f", "FOREIGN KEY (i) REFERENCES other_tablename(a) DEFERRABLE INITIALLY DEFERRED", true, true,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 FOREIGN KEY (i) REFERENCES other_tablename(a) DEFERRABLE INITIALLY DEFERRED;`),
+				Entry("a UNIQUE constraint whose definition already gives DEFERRABLE", "u", "UNIQUE (i) DEFERRABLE", true, false,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 UNIQUE (i) DEFERRABLE INITIALLY IMMEDIATE;`),
+				Entry("a FOREIGN KEY constraint that is NOT VALID", "f", "FOREIGN KEY (i) REFERENCES other_tablename(a) NOT VALID", true, false,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 FOREIGN KEY (i) REFERENCES other_tablename(a) DEFERRABLE INITIALLY IMMEDIATE NOT VALID;`),
+				Entry("an EXCLUDE constraint", "x", "EXCLUDE USING gist (c WITH &&)", true, true,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 EXCLUDE USING gist (c WITH &&) DEFERRABLE INITIALLY DEFERRED;`),
+				Entry("an EXCLUDE constraint with an operator class and a predicate", "x", "EXCLUDE USING btree (t text_pattern_ops WITH =) WHERE ((i > 0))", false, false,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 EXCLUDE USING btree (t text_pattern_ops WITH =) WHERE ((i > 0));`),
+				Entry("an EXCLUDE constraint with a schema-qualified operator", "x", "EXCLUDE USING gist (c WITH OPERATOR(public.&&))", false, false,
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 EXCLUDE USING gist (c WITH OPERATOR(public.&&));`),
+			)
+		})
+		Context("Foreign keys referencing other tables with foreign keys", func() {
+			var (
+				aToB backup.Constraint
+				bToC backup.Constraint
+				cToD backup.Constraint
+			)
+			BeforeEach(func() {
+				aToB = backup.Constraint{Oid: 0, Name: "a_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (i) REFERENCES public.b(i)", Valid: true}, IsValid: true, OwningObject: "public.a", ReferencedObject: "public.b"}
+				bToC = backup.Constraint{Oid: 0, Name: "b_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (i) REFERENCES public.c(i)", Valid: true}, IsValid: true, OwningObject: "public.b", ReferencedObject: "public.c"}
+				cToD = backup.Constraint{Oid: 0, Name: "c_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (i) REFERENCES public.d(i)", Valid: true}, IsValid: true, OwningObject: "public.c", ReferencedObject: "public.d"}
+			})
+			It("prints the foreign keys of a three-table chain in dependency order", func() {
+				constraints := []backup.Constraint{aToB, bToC, cToD}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer,
+					`ALTER TABLE ONLY public.c ADD CONSTRAINT c_fkey FOREIGN KEY (i) REFERENCES public.d(i);`,
+					`ALTER TABLE ONLY public.b ADD CONSTRAINT b_fkey FOREIGN KEY (i) REFERENCES public.c(i);`,
+					`ALTER TABLE ONLY public.a ADD CONSTRAINT a_fkey FOREIGN KEY (i) REFERENCES public.b(i);`)
+			})
+			It("does not order a foreign key after one referencing its own table", func() {
+				selfReference := backup.Constraint{Oid: 0, Name: "b_parent_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (j) REFERENCES public.b(i)", Valid: true}, IsValid: true, OwningObject: "public.b", ReferencedObject: "public.b"}
+				constraints := []backup.Constraint{aToB, selfReference}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer,
+					`ALTER TABLE ONLY public.b ADD CONSTRAINT b_parent_fkey FOREIGN KEY (j) REFERENCES public.b(i);`,
+					`ALTER TABLE ONLY public.a ADD CONSTRAINT a_fkey FOREIGN KEY (i) REFERENCES public.b(i);`)
+			})
+			It("prints the foreign keys of a two-table cycle in the given order with a warning", func() {
+				bToA := backup.Constraint{Oid: 0, Name: "b_fkey", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (i) REFERENCES public.a(i)", Valid: true}, IsValid: true, OwningObject: "public.b", ReferencedObject: "public.a"}
+				constraints := []backup.Constraint{aToB, bToA}
+				backup.PrintConstraintStatements(backupfile, tocfile, constraints, emptyMetadataMap)
+				testutils.AssertBufferContents(tocfile.PostdataEntries, buffer,
+					`ALTER TABLE ONLY public.a ADD CONSTRAINT a_fkey FOREIGN KEY (i) REFERENCES public.b(i);`,
+					`ALTER TABLE ONLY public.b ADD CONSTRAINT b_fkey FOREIGN KEY (i) REFERENCES public.a(i);`)
+				testhelper.ExpectRegexp(logfile, "The foreign keys of the following tables form a cycle, so they are printed without ordering by dependency: public.a, public.b")
+			})
+		})
+		Context("Constraints that are not validated", func() {
+			DescribeTable("prints NOT VALID after the definition of a constraint that is not validated",
+				func(conType string, conDef string, expected string) {
+					constraint := backup.Constraint{Oid: 0, Name: "con1", ConType: conType, ConDef: sql.NullString{String: conDef, Valid: true}, IsValid: false, OwningObject: "public.tablename"}
+					backup.PrintConstraintStatements(backupfile, tocfile, []backup.Constraint{constraint}, emptyMetadataMap)
+					testutils.AssertBufferContents(tocfile.PostdataEntries, buffer, expected)
+				},
+				Entry("a CHECK constraint", "c", "CHECK (i <> 42)",
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 CHECK (i <> 42) NOT VALID;`),
+				Entry("a FOREIGN KEY constraint", "f", "FOREIGN KEY (i) REFERENCES other_tablename(a)",
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 FOREIGN KEY (i) REFERENCES other_tablename(a) NOT VALID;`),
+				Entry("a CHECK constraint whose definition already gives NOT VALID", "c", "CHECK (i <> 42) NOT VALID",
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 CHECK (i <> 42) NOT VALID;`),
+				Entry("a UNIQUE constraint, which is always validated", "u", "UNIQUE (i)",
+					`ALTER TABLE ONLY public.tablename ADD CONSTRAINT con1 UNIQUE (i);`),
+			)
 		})
 	})
 	Describe("PrintCreateSchemaStatements", func() {
@@ -170,5 +299,34 @@ GRANT ALL ON SCHEMA schemaname TO testrole;`,
 				"SECURITY LABEL FOR dummy ON SCHEMA schemaname IS 'unclassified';"}
 			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, expectedStatements...)
 		})
+		It("can print a schema with its owner in the CREATE SCHEMA statement", func() {
+			_ = cmdFlags.Set(options.SCHEMA_AUTHORIZATION, "true")
+			schemas := []backup.Schema{{Oid: 1, Name: "schemaname"}}
+			schemaMetadataMap := testutils.DefaultMetadataMap("SCHEMA", true, true, true, true)
+
+			backup.PrintCreateSchemaStatements(backupfile, tocfile, schemas, schemaMetadataMap)
+			expectedStatements := []string{"CREATE SCHEMA schemaname AUTHORIZATION testrole;",
+				"COMMENT ON SCHEMA schemaname IS 'This is a schema comment.';",
+				`REVOKE ALL ON SCHEMA schemaname FROM PUBLIC;
+REVOKE ALL ON SCHEMA schemaname FROM testrole;
+GRANT ALL ON SCHEMA schemaname TO testrole;`,
+				"SECURITY LABEL FOR dummy ON SCHEMA schemaname IS 'unclassified';"}
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, expectedStatements...)
+		})
+		It("prints a separate owner statement for the public schema", func() {
+			_ = cmdFlags.Set(options.SCHEMA_AUTHORIZATION, "true")
+			schemas := []backup.Schema{{Oid: 1, Name: "public"}}
+			schemaMetadataMap := backup.MetadataMap{schemas[0].GetUniqueID(): {Owner: "testrole"}}
+
+			backup.PrintCreateSchemaStatements(backupfile, tocfile, schemas, schemaMetadataMap)
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, "", "ALTER SCHEMA public OWNER TO testrole;")
+		})
+		It("prints a CREATE SCHEMA statement without AUTHORIZATION when there is no owner", func() {
+			_ = cmdFlags.Set(options.SCHEMA_AUTHORIZATION, "true")
+			schemas := []backup.Schema{{Oid: 0, Name: "schemaname"}}
+
+			backup.PrintCreateSchemaStatements(backupfile, tocfile, schemas, backup.MetadataMap{})
+			testutils.AssertBufferContents(tocfile.PredataEntries, buffer, "CREATE SCHEMA schemaname;")
+		})
 	})
 })
@@ -12,6 +12,7 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/toc"
 )
 
@@ -158,7 +159,7 @@ func GetMetadataForObjectType(connectionPool *dbconn.DBConn, params MetadataQuer
 	secCols := ""
 	if connectionPool.Version.AtLeast("6") {
 		secCols = `coalesce(sec.label,'') AS securitylabel,
-		coalesce(sec.provider, '') AS securitylabelprovider,`
+		coalesce(quote_ident(sec.provider), '') AS securitylabelprovider,`
 		secTable := "pg_seclabel"
 		secSubidFilter := " AND sec.objsubid = 0"
 		if params.Shared {
@@ -288,8 +289,16 @@ func (dp DefaultPrivileges) GetMetadataEntry() (string, toc.MetadataEntry) {
 		}
 }
 
+/*
+ * Default privileges set for a schema follow the schema filters, while those
+ * set for the whole database are left out of a backup of specific schemas.
+ */
 func GetDefaultPrivileges(connectionPool *dbconn.DBConn) []DefaultPrivileges {
-	query := `
+	databaseWideStr := "a.defaclnamespace = 0 OR "
+	if len(MustGetFlagStringArray(options.INCLUDE_SCHEMA)) > 0 {
+		databaseWideStr = ""
+	}
+	query := fmt.Sprintf(`
 	SELECT a.oid,
 		quote_ident(r.rolname) AS owner,
 		coalesce(quote_ident(n.nspname),'') AS schema,
@@ -307,7 +316,8 @@ func GetDefaultPrivileges(connectionPool *dbconn.DBConn) []DefaultPrivileges {
 	FROM pg_default_acl a
 		JOIN pg_roles r ON r.oid = a.defaclrole
 		LEFT JOIN pg_namespace n ON n.oid = a.defaclnamespace
-	ORDER BY n.nspname, a.defaclobjtype, r.rolname`
+	WHERE %s(%s)
+	ORDER BY n.nspname, a.defaclobjtype, r.rolname`, databaseWideStr, SchemaFilterClause("n"))
 	results := make([]DefaultPrivilegesQueryStruct, 0)
 	err := connectionPool.Select(&results, query)
 	gplog.FatalOnError(err)
@@ -24,7 +24,7 @@ var _ = Describe("backup/queries_acl tests", func() {
 			if connectionPool.Version.AtLeast("6") {
 				securityLabelSelectReplace = `
 		coalesce(sec.label,'') AS securitylabel,
-		coalesce(sec.provider, '') AS securitylabelprovider,`
+		coalesce(quote_ident(sec.provider), '') AS securitylabelprovider,`
 				securityLabelJoinReplace = `
 		LEFT JOIN pg_seclabel sec ON (sec.objoid = o.oid AND sec.classoid = 'table'::regclass AND sec.objsubid = 0)`
 				sharedSecurityLabelJoinReplace = `
@@ -138,8 +138,8 @@ var _ = Describe("backup/queries_acl tests", func() {
 			resultMetadataMap := backup.GetMetadataForObjectType(connectionPool, params)
 
 			expectedOne := backup.ObjectMetadata{Privileges: []backup.ACL{
-				{Grantee: "gpadmin", Insert: true},
-				{Grantee: "testrole", Insert: true},
+				{Grantee: "gpadmin", Grantor: "gpadmin", Insert: true},
+				{Grantee: "testrole", Grantor: "gpadmin", Insert: true},
 			}, Owner: "testrole"}
 			expectedTwo := backup.ObjectMetadata{Privileges: []backup.ACL{}, Owner: "testrole", Comment: "This is a metadata comment."}
 			resultOne := resultMetadataMap[backup.UniqueID{Oid: 1}]
@@ -13,6 +13,7 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 )
@@ -124,11 +125,11 @@ func GetFunctions(connectionPool *dbconn.DBConn) []Function {
 		prosecdef,
 		%s
 		coalesce(array_to_string(ARRAY(SELECT 'SET ' || option_name || ' TO ' || option_value
-			FROM pg_options_to_table(proconfig)), ' '), '') AS proconfig,
+			FROM pg_options_to_table(proconfig)), E'\n'), '') AS proconfig,
 		procost,
 		prorows,
 		prodataaccess,
-		l.lanname AS language
+		quote_ident(l.lanname) AS language
 	FROM pg_proc p
 		JOIN pg_catalog.pg_language l ON p.prolang = l.oid
 		LEFT JOIN pg_namespace n ON p.pronamespace = n.oid
@@ -156,31 +157,41 @@ func GetFunctions(connectionPool *dbconn.DBConn) []Function {
 		if result.Arguments.Valid && result.IdentArgs.Valid && result.ResultType.Valid {
 			verifiedResults = append(verifiedResults, result)
 		} else {
-			gplog.Warn("Function '%s.%s' not backed up, most likely dropped after gpbackup had begun.", result.Schema, result.Name)
+			report.Warn(report.WARN_OBJECT_DROPPED, utils.MakeFQN(result.Schema, result.Name), "Function '%s.%s' not backed up, most likely dropped after gpbackup had begun.", result.Schema, result.Name)
 		}
 	}
 
 	return verifiedResults
 }
 
+/*
+ * A function's proconfig holds one "SET name TO value" entry per GUC, such as
+ * the fixed search_path of a SECURITY DEFINER function; GetFunctions returns
+ * them one per line.  Each entry is requoted for SQL and emitted as its own
+ * SET clause.
+ */
 func PostProcessFunctionConfigs(allFunctions []Function) error {
-	setToNameValuePattern := regexp.MustCompile(`^SET (.*) TO (.*)$`)
+	setToNameValuePattern := regexp.MustCompile(`^SET (\S+) TO (.*)$`)
 
 	for i, function := range allFunctions {
 		if function.Config == "" {
 			continue
 		}
 
-		captures := setToNameValuePattern.FindStringSubmatch(function.Config)
-		if len(captures) != 3 {
-			return fmt.Errorf("Function config does not match syntax expectations. Function was: %v", function)
+		configLines := strings.Split(function.Config, "\n")
+		for j, configLine := range configLines {
+			captures := setToNameValuePattern.FindStringSubmatch(configLine)
+			if len(captures) != 3 {
+				return fmt.Errorf("Function config does not match syntax expectations. Function was: %v", function)
+			}
+			gucName := strings.ToLower(captures[1])
+			gucValue := captures[2]
+			quotedValue := QuoteGUCValue(gucName, gucValue)
+			configLines[j] = fmt.Sprintf(`SET %s TO %s`, gucName, quotedValue)
 		}
-		gucName := strings.ToLower(captures[1])
-		gucValue := captures[2]
-		quotedValue := QuoteGUCValue(gucName, gucValue)
 
 		// write to struct by referencing the slice rather than the readonly 'function' copy
-		allFunctions[i].Config = fmt.Sprintf(`SET %s TO %s`, gucName, quotedValue)
+		allFunctions[i].Config = strings.Join(configLines, "\n")
 	}
 	return nil
 }
@@ -238,7 +249,7 @@ func GetFunctions4(connectionPool *dbconn.DBConn) []Function {
 		proisstrict,
 		prosecdef,
 		'a' AS proexeclocation,
-		(SELECT lanname FROM pg_catalog.pg_language WHERE oid = prolang) AS language
+		(SELECT quote_ident(lanname) FROM pg_catalog.pg_language WHERE oid = prolang) AS language
 	FROM pg_proc p
 		LEFT JOIN pg_namespace n ON p.pronamespace = n.oid
 	WHERE %s
@@ -400,7 +411,7 @@ func GetAggregates(connectionPool *dbconn.DBConn) []Aggregate {
 	version4query := fmt.Sprintf(`
 	SELECT p.oid,
 		quote_ident(n.nspname) AS schema,
-		p.proname AS name,
+		quote_ident(p.proname) AS name,
 		'' AS arguments,
 		'' AS identargs,
 		a.aggtransfn::regproc::oid,
@@ -423,7 +434,7 @@ func GetAggregates(connectionPool *dbconn.DBConn) []Aggregate {
 	version5query := fmt.Sprintf(`
 	SELECT p.oid,
 		quote_ident(n.nspname) AS schema,
-		p.proname AS name,
+		quote_ident(p.proname) AS name,
 		pg_catalog.pg_get_function_arguments(p.oid) AS arguments,
 		pg_catalog.pg_get_function_identity_arguments(p.oid) AS identargs,
 		a.aggtransfn::regproc::oid,
@@ -448,7 +459,7 @@ func GetAggregates(connectionPool *dbconn.DBConn) []Aggregate {
 	masterQuery := fmt.Sprintf(`
 	SELECT p.oid,
 		quote_ident(n.nspname) AS schema,
-		p.proname AS name,
+		quote_ident(p.proname) AS name,
 		pg_catalog.pg_get_function_arguments(p.oid) AS arguments,
 		pg_catalog.pg_get_function_identity_arguments(p.oid) AS identargs,
 		a.aggtransfn::regproc::oid,
@@ -518,7 +529,7 @@ func GetAggregates(connectionPool *dbconn.DBConn) []Aggregate {
 			if aggregate.Arguments.Valid && aggregate.IdentArgs.Valid {
 				verifiedAggregates = append(verifiedAggregates, aggregate)
 			} else {
-				gplog.Warn("Aggregate '%s.%s' not backed up, most likely dropped after gpbackup had begun.", aggregate.Schema, aggregate.Name)
+				report.Warn(report.WARN_OBJECT_DROPPED, utils.MakeFQN(aggregate.Schema, aggregate.Name), "Aggregate '%s.%s' not backed up, most likely dropped after gpbackup had begun.", aggregate.Schema, aggregate.Name)
 			}
 		}
 
@@ -587,7 +598,7 @@ func GetFunctionOidToInfoMap(connectionPool *dbconn.DBConn) map[uint32]FunctionI
 	gplog.FatalOnError(err)
 	for _, funcInfo := range results {
 		if !funcInfo.Arguments.Valid || !funcInfo.IdentArgs.Valid {
-			gplog.Warn("Function '%s.%s' not backed up, most likely dropped after gpbackup had begun.", funcInfo.Schema, funcInfo.Name)
+			report.Warn(report.WARN_OBJECT_DROPPED, utils.MakeFQN(funcInfo.Schema, funcInfo.Name), "Function '%s.%s' not backed up, most likely dropped after gpbackup had begun.", funcInfo.Schema, funcInfo.Name)
 			continue
 		}
 
@@ -731,6 +742,49 @@ func GetExtensions(connectionPool *dbconn.DBConn) []Extension {
 	return results
 }
 
+type ExtensionMember struct {
+	ExtensionName string
+	Schema        string
+	ObjectType    string
+	Identity      string
+}
+
+func (em ExtensionMember) GetMetadataEntry() (string, toc.MetadataEntry) {
+	return "postdata",
+		toc.MetadataEntry{
+			Schema:          em.Schema,
+			Name:            em.ExtensionName,
+			ObjectType:      "EXTENSION MEMBER",
+			ReferenceObject: em.Identity,
+			StartByte:       0,
+			EndByte:         0,
+		}
+}
+
+/*
+ * Returns the user objects that were added to an extension after it was
+ * created; see addedExtensionMembersQuery.  pg_identify_object gives a fully
+ * qualified identity that ALTER EXTENSION ... ADD accepts for each type.
+ */
+func GetAddedExtensionMembers(connectionPool *dbconn.DBConn) []ExtensionMember {
+	results := make([]ExtensionMember, 0)
+
+	query := fmt.Sprintf(`
+	SELECT quote_ident(e.extname) AS extensionname,
+		coalesce(quote_ident(i.schema), '') AS schema,
+		upper(i.type) AS objecttype,
+		i.identity
+	FROM (%s) a
+		JOIN pg_extension e ON a.refobjid = e.oid
+		CROSS JOIN pg_identify_object(a.classid, a.objid, 0) i
+		LEFT JOIN pg_namespace n ON n.nspname = i.schema
+	WHERE i.schema IS NULL OR (%s)
+	ORDER BY e.extname, i.type, i.identity`, addedExtensionMembersQuery, SchemaFilterClause("n"))
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	return results
+}
+
 type ProceduralLanguage struct {
 	Oid       uint32
 	Name      string
@@ -29,19 +29,23 @@ var _ = Describe("backup/queries_acl tests", func() {
 			err := backup.PostProcessFunctionConfigs(allFunctions)
 			Expect(err).To(HaveOccurred())
 		})
-		// known bug https://www.pivotaltracker.com/story/show/164575992
-		PIt("returns correct value for multiple GUCs in one function", func() {
+		It("returns correct value for multiple GUCs in one function", func() {
 			allFunctions := []backup.Function{
-				// not clear how the native pg_proc.proconfig field will translate into our Config attribute: assuming we get 2 separate strings
 				{Config: `SET search_path TO bar, blah
 SET BAZ TO abc`},
 			}
 			err := backup.PostProcessFunctionConfigs(allFunctions)
 			Expect(err).ToNot(HaveOccurred())
-			// expecting separate lines stored in the Config attribute;
-			// this may not be the perfect solution, TBD: may want to have it become a slice of strings
-			Expect(allFunctions[0].Config).To(Equal(`SET search_path TO 'bar', blah
-SET baz to abc`))
+			Expect(allFunctions[0].Config).To(Equal(`SET search_path TO 'bar', 'blah'
+SET baz TO 'abc'`))
+		})
+		It("returns error when any one function config does not parse", func() {
+			allFunctions := []backup.Function{
+				{Config: `SET search_path TO bar
+SET foo blah blah blah`},
+			}
+			err := backup.PostProcessFunctionConfigs(allFunctions)
+			Expect(err).To(HaveOccurred())
 		})
 	})
 	Describe("QuoteGUCValue", func() {
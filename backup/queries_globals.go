@@ -15,7 +15,8 @@ import (
 )
 
 type SessionGUCs struct {
-	ClientEncoding string `db:"client_encoding"`
+	ClientEncoding           string `db:"client_encoding"`
+	DefaultTableAccessMethod string
 }
 
 func (sg SessionGUCs) GetMetadataEntry() (string, toc.MetadataEntry) {
@@ -38,6 +39,17 @@ func GetSessionGUCs(connectionPool *dbconn.DBConn) SessionGUCs {
 	return result
 }
 
+/*
+ * Table data is written in the client encoding of the backup session, which
+ * is recorded in the backup config along with the server encoding so that
+ * gprestore can tell whether the data must be converted.
+ */
+func GetDataEncodings(connectionPool *dbconn.DBConn) (string, string) {
+	clientEncoding := dbconn.MustSelectString(connectionPool, "SELECT current_setting('client_encoding') AS string")
+	serverEncoding := dbconn.MustSelectString(connectionPool, "SELECT current_setting('server_encoding') AS string")
+	return clientEncoding, serverEncoding
+}
+
 type Database struct {
 	Oid        uint32
 	Name       string
@@ -24,6 +24,39 @@ func GetAOIncrementalMetadata(connectionPool *dbconn.DBConn) map[string]toc.AOEn
 	return aoTableEntries
 }
 
+func GetHeapIncrementalMetadata(connectionPool *dbconn.DBConn) map[string]toc.HeapEntry {
+	gplog.Verbose("Querying table activity counters")
+	query := fmt.Sprintf(`
+	SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS tablefqn,
+		c.relfilenode,
+		coalesce(s.n_tup_ins, 0) AS tuplesinserted,
+		coalesce(s.n_tup_upd, 0) AS tuplesupdated,
+		coalesce(s.n_tup_del, 0) AS tuplesdeleted
+	FROM pg_class c
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+		JOIN pg_stat_user_tables s ON c.oid = s.relid
+	WHERE %s`, relationAndSchemaFilterClause())
+	results := make([]struct {
+		TableFQN       string
+		RelFileNode    uint32
+		TuplesInserted int64
+		TuplesUpdated  int64
+		TuplesDeleted  int64
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	heapTableEntries := make(map[string]toc.HeapEntry, len(results))
+	for _, result := range results {
+		heapTableEntries[result.TableFQN] = toc.HeapEntry{
+			TuplesInserted: result.TuplesInserted,
+			TuplesUpdated:  result.TuplesUpdated,
+			TuplesDeleted:  result.TuplesDeleted,
+			RelFileNode:    result.RelFileNode,
+		}
+	}
+	return heapTableEntries
+}
+
 func getAllModCounts(connectionPool *dbconn.DBConn) map[string]int64 {
 	var segTableFQNs = getAOSegTableFQNs(connectionPool)
 	modCounts := make(map[string]int64)
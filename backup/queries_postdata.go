@@ -11,6 +11,7 @@ import (
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 )
@@ -48,6 +49,7 @@ type IndexDefinition struct {
 	IsClustered        bool
 	SupportsConstraint bool
 	IsReplicaIdentity  bool
+	IsInvalid          bool
 }
 
 func (i IndexDefinition) GetMetadataEntry() (string, toc.MetadataEntry) {
@@ -63,6 +65,19 @@ func (i IndexDefinition) GetMetadataEntry() (string, toc.MetadataEntry) {
 		}
 }
 
+func (i IndexDefinition) GetClusterMetadataEntry() (string, toc.MetadataEntry) {
+	tableFQN := utils.MakeFQN(i.OwningSchema, i.OwningTable)
+	return "postdata",
+		toc.MetadataEntry{
+			Schema:          i.OwningSchema,
+			Name:            i.OwningTable,
+			ObjectType:      "CLUSTER",
+			ReferenceObject: tableFQN,
+			StartByte:       0,
+			EndByte:         0,
+		}
+}
+
 func (i IndexDefinition) GetUniqueID() UniqueID {
 	return UniqueID{ClassID: PG_INDEX_OID, Oid: i.Oid}
 }
@@ -75,16 +90,22 @@ func (i IndexDefinition) FQN() string {
  * GetIndexes queries for all user and implicitly created indexes, since
  * implicitly created indexes could still have metadata to be backed up.
  * e.g. comments on implicitly created indexes
+ *
+ * In GPDB 6 and later, primary key indexes are only included if their table
+ * is clustered on them, so that the clustering can be backed up.
+ *
+ * Invalid indexes, such as those left by a failed CREATE INDEX CONCURRENTLY,
+ * are included and marked, so that they can be reported before being skipped.
  */
 func GetIndexes(connectionPool *dbconn.DBConn) []IndexDefinition {
-	resultIndexes := make([]IndexDefinition, 0)
+	query := ""
 	if connectionPool.Version.Before("6") {
 		indexOidList := ConstructImplicitIndexOidList(connectionPool)
 		implicitIndexStr := ""
 		if indexOidList != "" {
 			implicitIndexStr = fmt.Sprintf("OR i.indexrelid IN (%s)", indexOidList)
 		}
-		query := fmt.Sprintf(`
+		query = fmt.Sprintf(`
 	SELECT DISTINCT i.indexrelid AS oid,
 		quote_ident(ic.relname) AS name,
 		quote_ident(n.nspname) AS owningschema,
@@ -92,6 +113,7 @@ func GetIndexes(connectionPool *dbconn.DBConn) []IndexDefinition {
 		coalesce(quote_ident(s.spcname), '') AS tablespace,
 		pg_get_indexdef(i.indexrelid) AS def,
 		i.indisclustered AS isclustered,
+		NOT i.indisvalid AS isinvalid,
 		CASE
 			WHEN i.indisprimary = 't' %s THEN 't'
 			ELSE 'f'
@@ -102,16 +124,12 @@ func GetIndexes(connectionPool *dbconn.DBConn) []IndexDefinition {
 		JOIN pg_class c ON (c.oid = i.indrelid)
 		LEFT JOIN pg_tablespace s ON (ic.reltablespace = s.oid)
 	WHERE %s
-		AND i.indisvalid
-		AND NOT EXISTS (SELECT 1 FROM pg_partition_rule r WHERE r.parchildrelid = c.oid)
+		AND c.oid NOT IN (%s)
 		AND %s
 	ORDER BY name`,
-	implicitIndexStr, relationAndSchemaFilterClause(), ExtensionFilterClause("c"))
-
-		err := connectionPool.Select(&resultIndexes, query)
-		gplog.FatalOnError(err)
+	implicitIndexStr, relationAndSchemaFilterClause(), reachablePartitionChildren, ExtensionFilterClause("c"))
 	} else {
-		query := fmt.Sprintf(`
+		query = fmt.Sprintf(`
 	SELECT DISTINCT i.indexrelid AS oid,
 		quote_ident(ic.relname) AS name,
 		quote_ident(n.nspname) AS owningschema,
@@ -120,6 +138,7 @@ func GetIndexes(connectionPool *dbconn.DBConn) []IndexDefinition {
 		pg_get_indexdef(i.indexrelid) AS def,
 		i.indisclustered AS isclustered,
 		i.indisreplident AS isreplicaidentity,
+		NOT (i.indisvalid AND i.indisready) AS isinvalid,
 		CASE
 			WHEN conindid > 0 THEN 't'
 			ELSE 'f'
@@ -131,29 +150,33 @@ func GetIndexes(connectionPool *dbconn.DBConn) []IndexDefinition {
 		LEFT JOIN pg_tablespace s ON ic.reltablespace = s.oid
 		LEFT JOIN pg_constraint con ON i.indexrelid = con.conindid
 	WHERE %s
-		AND i.indisvalid
-		AND i.indisready
-		AND i.indisprimary = 'f'
-		AND NOT EXISTS (SELECT 1 FROM pg_partition_rule r WHERE r.parchildrelid = c.oid)
+		AND (i.indisprimary = 'f' OR i.indisclustered)
+		AND c.oid NOT IN (%s)
 		AND %s
 	ORDER BY name`,
-	relationAndSchemaFilterClause(), ExtensionFilterClause("c")) // The index itself does not have a dependency on the extension, but the index's table does
-		err := connectionPool.Select(&resultIndexes, query)
-		gplog.FatalOnError(err)
+	relationAndSchemaFilterClause(), reachablePartitionChildren, ExtensionFilterClause("c")) // The index itself does not have a dependency on the extension, but the index's table does
 	}
 
+	rows, err := connectionPool.Query(query)
+	gplog.FatalOnError(err)
+	defer rows.Close()
+
 	// Remove all indexes that have NULL definitions. This can happen
 	// if a concurrent index drop happens before the associated table
 	// lock is acquired earlier during gpbackup execution.
 	verifiedResultIndexes := make([]IndexDefinition, 0)
-	for _, resultIndex := range resultIndexes {
+	for rows.Next() {
+		var resultIndex IndexDefinition
+		err = rows.StructScan(&resultIndex)
+		gplog.FatalOnError(err)
 		if resultIndex.Def.Valid {
 			verifiedResultIndexes = append(verifiedResultIndexes, resultIndex)
 		} else {
-			gplog.Warn("Index '%s' on table '%s.%s' not backed up, most likely dropped after gpbackup had begun.",
+			report.Warn(report.WARN_OBJECT_DROPPED, utils.MakeFQN(resultIndex.OwningSchema, resultIndex.Name), "Index '%s' on table '%s.%s' not backed up, most likely dropped after gpbackup had begun.",
 				resultIndex.Name, resultIndex.OwningSchema, resultIndex.OwningTable)
 		}
 	}
+	gplog.FatalOnError(rows.Err())
 
 	return verifiedResultIndexes
 }
@@ -221,7 +244,7 @@ func GetRules(connectionPool *dbconn.DBConn) []RuleDefinition {
 		if result.Def.Valid {
 			verifiedResults = append(verifiedResults, result)
 		} else {
-			gplog.Warn("Rule '%s' on table '%s.%s' not backed up, most likely dropped after gpbackup had begun.",
+			report.Warn(report.WARN_OBJECT_DROPPED, result.Name, "Rule '%s' on table '%s.%s' not backed up, most likely dropped after gpbackup had begun.",
 				result.Name, result.OwningSchema, result.OwningTable)
 		}
 	}
@@ -285,7 +308,7 @@ func GetTriggers(connectionPool *dbconn.DBConn) []TriggerDefinition {
 		if result.Def.Valid {
 			verifiedResults = append(verifiedResults, result)
 		} else {
-			gplog.Warn("Trigger '%s' on table '%s.%s' not backed up, most likely dropped after gpbackup had begun.",
+			report.Warn(report.WARN_OBJECT_DROPPED, result.Name, "Trigger '%s' on table '%s.%s' not backed up, most likely dropped after gpbackup had begun.",
 				result.Name, result.OwningSchema, result.OwningTable)
 		}
 	}
@@ -35,6 +35,19 @@ var _ = Describe("backup/queries_postdata tests", func() {
 			Expect(result).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatch(&expectedResult[0], &result[0])
 		})
+		It("GetIndexes marks invalid indexes instead of filtering them out", func() {
+			if connectionPool.Version.Before("6") {
+				mock.ExpectQuery(`SELECT (.*)`).WillReturnRows(sqlmock.NewRows([]string{"string"}))
+			}
+
+			header := []string{"oid", "name", "owningschema", "owningtable", "tablespace", "def", "isclustered", "supportsconstraint", "isinvalid"}
+			rowOne := []driver.Value{"1", "mock_index", "mock_schema", "mock_table", "", "mock_def", false, false, true}
+			mock.ExpectQuery(`NOT (.*)indisvalid(.*) AS isinvalid`).WillReturnRows(sqlmock.NewRows(header).AddRow(rowOne...))
+			result := backup.GetIndexes(connectionPool)
+
+			Expect(result).To(HaveLen(1))
+			Expect(result[0].IsInvalid).To(BeTrue())
+		})
 	})
 	Describe("GetRules", func() {
 		It("GetRules properly handles NULL rule definitions", func() {
@@ -36,6 +36,19 @@ var _ = Describe("backup internal tests", func() {
 			Expect(len(lockQueries)).To(Equal(3))
 		})
 	})
+	Describe("GenerateOidBatches", func() {
+		It("fills every batch when the oids divide evenly", func() {
+			batches := backup.GenerateOidBatches([]string{"1", "2", "3", "4"}, 2)
+			Expect(batches).To(Equal([]string{"1,2", "3,4"}))
+		})
+		It("puts the oid past a batch boundary in a batch of its own", func() {
+			batches := backup.GenerateOidBatches([]string{"1", "2", "3", "4", "5"}, 2)
+			Expect(batches).To(Equal([]string{"1,2", "3,4", "5"}))
+		})
+		It("makes no batches from no oids", func() {
+			Expect(backup.GenerateOidBatches([]string{}, 2)).To(BeEmpty())
+		})
+	})
 	Describe("GetAllViews", func() {
 		It("GetAllViews properly handles NULL view definitions", func() {
 			header := []string{"oid", "schema", "name", "options", "definition", "tablespace", "ismaterialized"}
@@ -52,4 +65,76 @@ var _ = Describe("backup internal tests", func() {
 			structmatcher.ExpectStructsToMatch(&expectedResult[0], &result[0])
 		})
 	})
+	Describe("GetTablesFromIncludeQuery", func() {
+		query := "SELECT schemaname, tablename FROM registry.backup_tables"
+		It("returns each table once in the form given to --include-table", func() {
+			rows := sqlmock.NewRows([]string{"schemaname", "tablename"}).AddRow("public", "foo").AddRow("sales", "bar").AddRow("public", "foo")
+			mock.ExpectQuery(query).WillReturnRows(rows)
+
+			tables, err := backup.GetTablesFromIncludeQuery(connectionPool, query)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tables).To(Equal([]string{"public.foo", "sales.bar"}))
+		})
+		It("returns an error if the query does not return two columns", func() {
+			rows := sqlmock.NewRows([]string{"schemaname"}).AddRow("public")
+			mock.ExpectQuery(query).WillReturnRows(rows)
+
+			_, err := backup.GetTablesFromIncludeQuery(connectionPool, query)
+
+			Expect(err).To(MatchError("The --include-table-query query must return exactly two columns, a schema name and a table name, but returned 1"))
+		})
+		It("returns an error if a name is null", func() {
+			rows := sqlmock.NewRows([]string{"schemaname", "tablename"}).AddRow("public", nil)
+			mock.ExpectQuery(query).WillReturnRows(rows)
+
+			_, err := backup.GetTablesFromIncludeQuery(connectionPool, query)
+
+			Expect(err).To(MatchError("The --include-table-query query returned a null schema or table name"))
+		})
+	})
+	Describe("ExtractDefaultTableAccessMethod", func() {
+		table := func(accessMethod string) backup.Table {
+			return backup.Table{TableDefinition: backup.TableDefinition{AccessMethod: accessMethod}}
+		}
+		It("returns the most common access method and clears it from those tables", func() {
+			tables := []backup.Table{table("ao_row"), table("heap"), table("ao_row")}
+
+			result := backup.ExtractDefaultTableAccessMethod(tables)
+
+			Expect(result).To(Equal("ao_row"))
+			Expect(tables[0].AccessMethod).To(Equal(""))
+			Expect(tables[1].AccessMethod).To(Equal("heap"))
+			Expect(tables[2].AccessMethod).To(Equal(""))
+		})
+		It("breaks ties by access method name", func() {
+			tables := []backup.Table{table("heap"), table("ao_column")}
+
+			Expect(backup.ExtractDefaultTableAccessMethod(tables)).To(Equal("ao_column"))
+		})
+		It("returns an empty string if no tables have an access method", func() {
+			tables := []backup.Table{table(""), table("")}
+
+			Expect(backup.ExtractDefaultTableAccessMethod(tables)).To(Equal(""))
+		})
+	})
+	Describe("OmitHeapTableAccessMethod", func() {
+		table := func(accessMethod string) backup.Table {
+			return backup.Table{TableDefinition: backup.TableDefinition{AccessMethod: accessMethod}}
+		}
+		It("clears the access method of heap tables and returns heap", func() {
+			tables := []backup.Table{table("ao_row"), table("heap"), table("heap")}
+
+			Expect(backup.OmitHeapTableAccessMethod(tables)).To(Equal("heap"))
+			Expect(tables[0].AccessMethod).To(Equal("ao_row"))
+			Expect(tables[1].AccessMethod).To(Equal(""))
+			Expect(tables[2].AccessMethod).To(Equal(""))
+		})
+		It("returns an empty string if there are no heap tables", func() {
+			tables := []backup.Table{table("ao_column"), table("")}
+
+			Expect(backup.OmitHeapTableAccessMethod(tables)).To(Equal(""))
+			Expect(tables[0].AccessMethod).To(Equal("ao_column"))
+		})
+	})
 })
@@ -8,11 +8,13 @@ package backup
 import (
 	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
@@ -26,7 +28,14 @@ func relationAndSchemaFilterClause() string {
 	if len(MustGetFlagStringArray(options.EXCLUDE_RELATION)) > 0 {
 		excludeOids := getOidsFromRelationList(connectionPool, MustGetFlagStringArray(options.EXCLUDE_RELATION))
 		if len(excludeOids) > 0 {
-			filterRelationClause += fmt.Sprintf("\nAND c.oid NOT IN (%s)", strings.Join(excludeOids, ", "))
+			oidStr := strings.Join(excludeOids, ", ")
+			filterRelationClause += fmt.Sprintf("\nAND c.oid NOT IN (%s)", oidStr)
+			// Child partitions of an excluded table are excluded with it, along with their triggers, rules, and indexes
+			if connectionPool.Version.Before("7") {
+				filterRelationClause += fmt.Sprintf("\nAND c.oid NOT IN (SELECT r.parchildrelid FROM pg_partition_rule r JOIN pg_partition p ON r.paroid = p.oid WHERE p.parrelid IN (%s))", oidStr)
+			} else {
+				filterRelationClause += fmt.Sprintf("\nAND c.oid NOT IN (SELECT t.relid FROM unnest(ARRAY[%s]::oid[]) e(oid), pg_partition_tree(e.oid) t)", oidStr)
+			}
 		}
 	}
 	if len(MustGetFlagStringArray(options.INCLUDE_RELATION)) > 0 {
@@ -49,6 +58,54 @@ func getOidsFromRelationList(connectionPool *dbconn.DBConn, quotedIncludeRelatio
 	return dbconn.MustSelectStringSlice(connectionPool, query)
 }
 
+/*
+ * Runs the query given with --include-table-query, which must return the
+ * schema and name of each table as two text columns, and returns the tables
+ * in the form given to --include-table.
+ */
+func GetTablesFromIncludeQuery(connectionPool *dbconn.DBConn, query string) ([]string, error) {
+	rows, err := connectionPool.Query(query)
+	if err != nil {
+		return nil, errors.Errorf("Could not run --%s query: %v", options.INCLUDE_TABLE_QUERY, err)
+	}
+	defer rows.Close()
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	if len(columnTypes) != 2 {
+		return nil, errors.Errorf("The --%s query must return exactly two columns, a schema name and a table name, but returned %d",
+			options.INCLUDE_TABLE_QUERY, len(columnTypes))
+	}
+	// A driver that does not report column types gives an empty type name
+	for _, columnType := range columnTypes {
+		switch strings.ToUpper(columnType.DatabaseTypeName()) {
+		case "TEXT", "VARCHAR", "BPCHAR", "NAME", "":
+		default:
+			return nil, errors.Errorf("The --%s query must return text columns, but column %s is of type %s",
+				options.INCLUDE_TABLE_QUERY, columnType.Name(), columnType.DatabaseTypeName())
+		}
+	}
+	tables := make([]string, 0)
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var schema, table sql.NullString
+		err = rows.Scan(&schema, &table)
+		if err != nil {
+			return nil, err
+		}
+		if !schema.Valid || !table.Valid {
+			return nil, errors.Errorf("The --%s query returned a null schema or table name", options.INCLUDE_TABLE_QUERY)
+		}
+		fqn := utils.MakeFQN(schema.String, table.String)
+		if !seen[fqn] {
+			seen[fqn] = true
+			tables = append(tables, fqn)
+		}
+	}
+	return tables, rows.Err()
+}
+
 func GetIncludedUserTableRelations(connectionPool *dbconn.DBConn, includedRelationsQuoted []string) []Relation {
 	if len(MustGetFlagStringArray(options.INCLUDE_RELATION)) > 0 {
 		return getUserTableRelationsWithIncludeFiltering(connectionPool, includedRelationsQuoted)
@@ -79,12 +136,12 @@ func getUserTableRelations(connectionPool *dbconn.DBConn) []Relation {
 	childPartitionFilter := ""
 	if !MustGetFlagBool(options.LEAF_PARTITION_DATA) {
 		//Filter out non-external child partitions
-		childPartitionFilter = `
+		childPartitionFilter = fmt.Sprintf(`
 	AND c.oid NOT IN (
-		SELECT p.parchildrelid
-		FROM pg_partition_rule p
-			LEFT JOIN pg_exttable e ON p.parchildrelid = e.reloid
-		WHERE e.reloid IS NULL)`
+		SELECT children.parchildrelid
+		FROM (%s) children
+			LEFT JOIN pg_exttable e ON children.parchildrelid = e.reloid
+		WHERE e.reloid IS NULL)`, reachablePartitionChildren)
 	}
 
 	query := fmt.Sprintf(`
@@ -108,10 +165,59 @@ func getUserTableRelations(connectionPool *dbconn.DBConn) []Relation {
 	return results
 }
 
+/*
+ * The child partitions whose partition rule still leads to a root partition
+ * table, and so are backed up as part of that root.
+ */
+const reachablePartitionChildren = `SELECT r.parchildrelid
+		FROM pg_partition_rule r
+			JOIN pg_partition p ON r.paroid = p.oid
+			JOIN pg_class root ON p.parrelid = root.oid`
+
+/*
+ * Finds tables that were once child partitions but can no longer be reached
+ * from a root partition table: children whose partition rule remains after
+ * its partition or root was dropped, and tables named like child partitions
+ * that still inherit from a partition table without a partition rule of their
+ * own.  Neither is part of a partition hierarchy any more, so they are backed
+ * up as standalone tables.
+ */
+func GetOrphanedPartitionTables(connectionPool *dbconn.DBConn) []Relation {
+	if connectionPool.Version.AtLeast("7") {
+		return []Relation{}
+	}
+	query := fmt.Sprintf(`
+	SELECT n.oid AS schemaoid,
+		c.oid AS oid,
+		quote_ident(n.nspname) AS schema,
+		quote_ident(c.relname) AS name
+	FROM pg_class c
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+	WHERE %s
+		AND relkind = 'r'
+		AND %s
+		AND ((c.oid IN (SELECT parchildrelid FROM pg_partition_rule)
+				AND c.oid NOT IN (%s))
+			OR (position('_prt_' in c.relname) > 0
+				AND c.oid NOT IN (SELECT parchildrelid FROM pg_partition_rule)
+				AND c.oid IN (SELECT i.inhrelid
+					FROM pg_inherits i
+					WHERE i.inhparent IN (SELECT parrelid FROM pg_partition)
+						OR i.inhparent IN (SELECT parchildrelid FROM pg_partition_rule))))
+	ORDER BY c.oid`,
+		relationAndSchemaFilterClause(), ExtensionFilterClause("c"), reachablePartitionChildren)
+
+	results := make([]Relation, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	return results
+}
+
 func getUserTableRelationsWithIncludeFiltering(connectionPool *dbconn.DBConn, includedRelationsQuoted []string) []Relation {
 	includeOids := getOidsFromRelationList(connectionPool, includedRelationsQuoted)
-	oidStr := strings.Join(includeOids, ", ")
-	query := fmt.Sprintf(`
+	results := make([]Relation, 0)
+	for _, oidStr := range GenerateOidBatches(includeOids, oidBatchSize) {
+		query := fmt.Sprintf(`
 	SELECT n.oid AS schemaoid,
 		c.oid AS oid,
 		quote_ident(n.nspname) AS schema,
@@ -122,9 +228,13 @@ func getUserTableRelationsWithIncludeFiltering(connectionPool *dbconn.DBConn, in
 		AND (relkind = 'r')
 	ORDER BY c.oid`, oidStr)
 
-	results := make([]Relation, 0)
-	err := connectionPool.Select(&results, query)
-	gplog.FatalOnError(err)
+		batch := make([]Relation, 0)
+		err := connectionPool.Select(&batch, query)
+		gplog.FatalOnError(err)
+		results = append(results, batch...)
+	}
+	// Each batch is in oid order, but the oids are not batched in order
+	sort.Slice(results, func(i, j int) bool { return results[i].Oid < results[j].Oid })
 	return results
 }
 
@@ -179,9 +289,18 @@ type SequenceDefinition struct {
 	IsCycled    bool
 	IsCalled    bool
 	OwningTable string
+	Type        string
 }
 
 func GetAllSequences(connectionPool *dbconn.DBConn) []Sequence {
+	// Sequences backing identity columns are created along with their column
+	identityFilter := ""
+	if connectionPool.Version.AtLeast("7") {
+		identityFilter = `
+		AND NOT EXISTS (SELECT 1 FROM pg_depend i
+			WHERE i.classid = 'pg_class'::regclass AND i.objid = c.oid AND i.deptype = 'i'
+				AND i.refclassid = 'pg_class'::regclass AND i.refobjsubid > 0)`
+	}
 	query := fmt.Sprintf(`
 	SELECT n.oid AS schemaoid,
 		c.oid AS oid,
@@ -199,9 +318,9 @@ func GetAllSequences(connectionPool *dbconn.DBConn) []Sequence {
 		LEFT JOIN pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
 	WHERE c.relkind = 'S'
 		AND %s
-		AND %s
+		AND %s%s
 	ORDER BY n.nspname, c.relname`,
-		relationAndSchemaFilterClause(), ExtensionFilterClause("c"))
+		relationAndSchemaFilterClause(), ExtensionFilterClause("c"), identityFilter)
 
 	results := make([]Sequence, 0)
 	err := connectionPool.Select(&results, query)
@@ -234,11 +353,27 @@ func GetAllSequences(connectionPool *dbconn.DBConn) []Sequence {
 }
 
 func GetSequenceDefinition(connectionPool *dbconn.DBConn, seqName string) SequenceDefinition {
-	startValQuery := ""
-	if connectionPool.Version.AtLeast("6") {
-		startValQuery = "start_value AS startval,"
-	}
-	query := fmt.Sprintf(`
+	var query string
+	if connectionPool.Version.AtLeast("7") {
+		// In GPDB 7+, sequence parameters are stored in pg_sequence rather than in the sequence relation
+		query = fmt.Sprintf(`
+	SELECT s.last_value AS lastval,
+		p.seqstart AS startval,
+		p.seqincrement AS increment,
+		p.seqmax AS maxval,
+		p.seqmin AS minval,
+		p.seqcache AS cacheval,
+		p.seqcycle AS iscycled,
+		s.is_called AS iscalled,
+		format_type(p.seqtypid, NULL) AS type
+	FROM %s s
+		JOIN pg_sequence p ON p.seqrelid = '%s'::regclass`, seqName, utils.EscapeSingleQuotes(seqName))
+	} else {
+		startValQuery := ""
+		if connectionPool.Version.AtLeast("6") {
+			startValQuery = "start_value AS startval,"
+		}
+		query = fmt.Sprintf(`
 	SELECT last_value AS lastval,
 		%s
 		increment_by AS increment,
@@ -248,6 +383,7 @@ func GetSequenceDefinition(connectionPool *dbconn.DBConn, seqName string) Sequen
 		is_cycled AS iscycled,
 		is_called AS iscalled
 	FROM %s`, startValQuery, seqName)
+	}
 	result := SequenceDefinition{}
 	err := connectionPool.Get(&result, query)
 	gplog.FatalOnError(err)
@@ -350,13 +486,57 @@ func GetAllViews(connectionPool *dbconn.DBConn) []View {
 				verifiedResults = append(verifiedResults, result)
 			}
 		} else {
-			gplog.Warn("View '%s.%s' not backed up, most likely dropped after gpbackup had begun.", result.Schema, result.Name)
+			report.Warn(report.WARN_OBJECT_DROPPED, utils.MakeFQN(result.Schema, result.Name), "View '%s.%s' not backed up, most likely dropped after gpbackup had begun.", result.Schema, result.Name)
 		}
 	}
 
 	return verifiedResults
 }
 
+type InaccessibleTable struct {
+	Oid     uint32
+	Columns string
+}
+
+/*
+ * Returns the tables that the backup role cannot COPY out in full, along with
+ * the columns it cannot read when it has column-level SELECT on some of them.
+ * Column privileges were introduced in GPDB 6, so before that only the table
+ * privilege is checked.
+ */
+func GetInaccessibleTables(connectionPool *dbconn.DBConn, tables []Table) []InaccessibleTable {
+	results := make([]InaccessibleTable, 0)
+	if len(tables) == 0 {
+		return results
+	}
+	oidList := make([]string, len(tables))
+	for i, table := range tables {
+		oidList[i] = fmt.Sprintf("%d", table.Oid)
+	}
+	columnsSelect := "'' AS columns"
+	columnsFilter := ""
+	if connectionPool.Version.AtLeast("6") {
+		columnsSelect = `coalesce((SELECT string_agg(quote_ident(a.attname), ', ' ORDER BY a.attnum)
+			FROM pg_attribute a
+			WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+				AND NOT has_column_privilege(c.oid, a.attnum, 'SELECT')), '') AS columns`
+		columnsFilter = `
+		AND EXISTS (SELECT 1 FROM pg_attribute a
+			WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+				AND NOT has_column_privilege(c.oid, a.attnum, 'SELECT'))`
+	}
+	query := fmt.Sprintf(`
+	SELECT c.oid, %s
+	FROM pg_class c
+	WHERE c.oid IN (%s)
+		AND NOT has_table_privilege(c.oid, 'SELECT')%s
+	ORDER BY c.oid`, columnsSelect, strings.Join(oidList, ", "), columnsFilter)
+
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	return results
+}
+
 // This function is responsible for getting the necessary access share
 // locks for the target relations. This is mainly to protect the metadata
 // dumping part but it also makes the main worker thread (worker 0) the
@@ -420,3 +600,25 @@ func GenerateTableBatches(tables []Relation, batchSize int) []string {
 
 	return batches
 }
+
+/*
+ * Queries that filter on a list of oids given inline, such as those of the
+ * tables in an --include-table backup, are run once for each batch of
+ * oidBatchSize oids, so that a very long list does not make a query text of
+ * several MB for the planner to parse.
+ */
+const oidBatchSize = 10000
+
+// GenerateOidBatches returns an array of batches where a batch of oids is a
+// single string with comma separated oids
+func GenerateOidBatches(oids []string, batchSize int) []string {
+	batches := make([]string, 0)
+	for i := 0; i < len(oids); i += batchSize {
+		end := i + batchSize
+		if end > len(oids) {
+			end = len(oids)
+		}
+		batches = append(batches, strings.Join(oids[i:end], ","))
+	}
+	return batches
+}
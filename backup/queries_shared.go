@@ -13,6 +13,7 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 )
@@ -73,15 +74,22 @@ func GetAllUserSchemas(connectionPool *dbconn.DBConn, partitionAlteredSchemas ma
 }
 
 type Constraint struct {
-	Oid                uint32
-	Schema             string
-	Name               string
-	ConType            string
-	ConDef             sql.NullString
-	ConIsLocal         bool
-	OwningObject       string
-	IsDomainConstraint bool
-	IsPartitionParent  bool
+	Oid                 uint32
+	Schema              string
+	Name                string
+	ConType             string
+	ConDef              sql.NullString
+	ConIsLocal          bool
+	ConNoInherit        bool
+	ConDeferrable       bool
+	ConDeferred         bool
+	IsValid             bool
+	OwningObject        string
+	ReferencedObject    string
+	IsDomainConstraint  bool
+	IsPartitionParent   bool
+	IsInheritanceParent bool
+	IsParentOnly        bool
 }
 
 func (c Constraint) GetMetadataEntry() (string, toc.MetadataEntry) {
@@ -112,9 +120,15 @@ func GetConstraints(connectionPool *dbconn.DBConn, includeTables ...Relation) []
 	// ConIsLocal should always return true from GetConstraints because we filter out constraints that are inherited using the INHERITS clause, or inherited from a parent partition table. This field only accurately reflects constraints in GPDB6+ because check constraints on parent tables must propogate to children. For GPDB versions 5 or lower, this field will default to false.
 	var selectConIsLocal string
 	var groupByConIsLocal string
+	// Constraints cannot be added NOT VALID before GPDB 6, so they are always validated
+	selectIsValid := `'t' AS isvalid,`
+	groupByIsValid := ""
 	if connectionPool.Version.AtLeast("6") {
-		selectConIsLocal = `conislocal,`
-		groupByConIsLocal = `con.conislocal,`
+		selectConIsLocal = `conislocal,
+		connoinherit,`
+		groupByConIsLocal = `con.conislocal, con.connoinherit,`
+		selectIsValid = `convalidated AS isvalid,`
+		groupByIsValid = `con.convalidated,`
 	}
 	// This query is adapted from the queries underlying \d in psql.
 	tableQuery := fmt.Sprintf(`
@@ -123,23 +137,38 @@ func GetConstraints(connectionPool *dbconn.DBConn, includeTables ...Relation) []
 		quote_ident(conname) AS name,
 		contype,
 		%s
+		%s
+		condeferrable,
+		condeferred,
 		pg_get_constraintdef(con.oid, TRUE) AS condef,
 		quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS owningobject,
+		coalesce(quote_ident(rn.nspname) || '.' || quote_ident(rc.relname), '') AS referencedobject,
 		'f' AS isdomainconstraint,
 		CASE
 			WHEN pt.parrelid IS NULL THEN 'f'
 			ELSE 't'
-		END AS ispartitionparent
+		END AS ispartitionparent,
+		CASE
+			WHEN EXISTS (SELECT 1 FROM pg_inherits i WHERE i.inhparent = con.conrelid) THEN 't'
+			ELSE 'f'
+		END AS isinheritanceparent,
+		CASE
+			WHEN EXISTS (SELECT 1 FROM pg_inherits i WHERE i.inhparent = con.conrelid
+				AND NOT EXISTS (SELECT 1 FROM pg_constraint cc WHERE cc.conrelid = i.inhrelid AND cc.conname = con.conname)) THEN 't'
+			ELSE 'f'
+		END AS isparentonly
 	FROM pg_constraint con
 		LEFT JOIN pg_class c ON con.conrelid = c.oid
 		LEFT JOIN pg_partition pt ON con.conrelid = pt.parrelid
+		LEFT JOIN pg_class rc ON con.confrelid = rc.oid
+		LEFT JOIN pg_namespace rn ON rc.relnamespace = rn.oid
 		JOIN pg_namespace n ON n.oid = con.connamespace
 	WHERE %s
 		AND %s
 		AND c.relname IS NOT NULL
-		AND conrelid NOT IN (SELECT parchildrelid FROM pg_partition_rule)
+		AND conrelid NOT IN (`+reachablePartitionChildren+`)
 		AND (conrelid, conname) NOT IN (SELECT i.inhrelid, con.conname FROM pg_inherits i JOIN pg_constraint con ON i.inhrelid = con.conrelid JOIN pg_constraint p ON i.inhparent = p.conrelid WHERE con.conname = p.conname)
-	GROUP BY con.oid, conname, contype, c.relname, n.nspname, %s pt.parrelid`, selectConIsLocal, "%s", ExtensionFilterClause("c"), groupByConIsLocal)
+	GROUP BY con.oid, con.conrelid, conname, contype, con.condeferrable, con.condeferred, c.relname, n.nspname, rc.relname, rn.nspname, %s %s pt.parrelid`, selectConIsLocal, selectIsValid, "%s", ExtensionFilterClause("c"), groupByConIsLocal, groupByIsValid)
 
 	nonTableQuery := fmt.Sprintf(`
 	SELECT con.oid,
@@ -147,54 +176,86 @@ func GetConstraints(connectionPool *dbconn.DBConn, includeTables ...Relation) []
 		quote_ident(conname) AS name,
 		contype,
 		%s
+		%s
+		condeferrable,
+		condeferred,
 		pg_get_constraintdef(con.oid, TRUE) AS condef,
 		quote_ident(n.nspname) || '.' || quote_ident(t.typname) AS owningobject,
+		'' AS referencedobject,
 		't' AS isdomainconstraint,
-		'f' AS ispartitionparent
+		'f' AS ispartitionparent,
+		'f' AS isinheritanceparent,
+		'f' AS isparentonly
 	FROM pg_constraint con
 		LEFT JOIN pg_type t ON con.contypid = t.oid
 		JOIN pg_namespace n ON n.oid = con.connamespace
 	WHERE %s
 		AND %s
 		AND t.typname IS NOT NULL
-	GROUP BY con.oid, conname, contype, n.nspname, %s t.typname
-	ORDER BY name`, selectConIsLocal, SchemaFilterClause("n"), ExtensionFilterClause("con"), groupByConIsLocal)
+	GROUP BY con.oid, conname, contype, con.condeferrable, con.condeferred, n.nspname, %s %s t.typname
+	ORDER BY name`, selectConIsLocal, selectIsValid, SchemaFilterClause("n"), ExtensionFilterClause("con"), groupByConIsLocal, groupByIsValid)
 
-	query := ""
+	queries := make([]string, 0)
 	if len(includeTables) > 0 {
 		oidList := make([]string, 0)
 		for _, table := range includeTables {
 			oidList = append(oidList, fmt.Sprintf("%d", table.Oid))
 		}
-		filterClause := fmt.Sprintf("%s\nAND c.oid IN (%s)", SchemaFilterClause("n"), strings.Join(oidList, ","))
-		query = fmt.Sprintf(tableQuery, filterClause)
+		for _, oidStr := range GenerateOidBatches(oidList, oidBatchSize) {
+			filterClause := fmt.Sprintf("%s\nAND c.oid IN (%s)", SchemaFilterClause("n"), oidStr)
+			queries = append(queries, fmt.Sprintf(tableQuery, filterClause))
+		}
 	} else {
 		tableQuery = fmt.Sprintf(tableQuery, relationAndSchemaFilterClause())
-		query = fmt.Sprintf("%s\nUNION\n%s", tableQuery, nonTableQuery)
+		queries = append(queries, fmt.Sprintf("%s\nUNION\n%s", tableQuery, nonTableQuery))
 	}
 	results := make([]Constraint, 0)
-	err := connectionPool.Select(&results, query)
+	for _, query := range queries {
+		results = scanConstraints(connectionPool, query, results)
+	}
+	return results
+}
+
+func scanConstraints(connectionPool *dbconn.DBConn, query string, results []Constraint) []Constraint {
+	rows, err := connectionPool.Query(query)
 	gplog.FatalOnError(err)
+	defer rows.Close()
 
-	if connectionPool.Version.Before("6") {
-		// Remove all constraints that have NULL definitions. This can happen
-		// if the query above is run and a concurrent constraint drop happens
-		// just before the pg_get_constraintdef function executes. Note that
-		// GPDB 6+ pg_get_constraintdef uses an MVCC snapshot instead of
-		// syscache so we only need to verify for GPDB 4.3 and 5 (technically
-		// only GPDB 5 since 4.3 will get a cache error on the query).
-		verifiedResults := make([]Constraint, 0)
-		for _, result := range results {
-			if result.ConDef.Valid {
-				verifiedResults = append(verifiedResults, result)
-			} else {
-				gplog.Warn("Constraint '%s.%s' not backed up, most likely dropped after gpbackup had begun.", result.Schema, result.Name)
-			}
+	// Remove all constraints that have NULL definitions. This can happen
+	// if the query above is run and a concurrent constraint drop happens
+	// just before the pg_get_constraintdef function executes. Note that
+	// GPDB 6+ pg_get_constraintdef uses an MVCC snapshot instead of
+	// syscache so we only need to verify for GPDB 4.3 and 5 (technically
+	// only GPDB 5 since 4.3 will get a cache error on the query).
+	verifyDefinitions := connectionPool.Version.Before("6")
+	for rows.Next() {
+		var result Constraint
+		err = rows.StructScan(&result)
+		gplog.FatalOnError(err)
+		if verifyDefinitions && !result.ConDef.Valid {
+			report.Warn(report.WARN_OBJECT_DROPPED, result.Name, "Constraint '%s.%s' not backed up, most likely dropped after gpbackup had begun.", result.Schema, result.Name)
+			continue
+		}
+		results = append(results, result)
+	}
+	gplog.FatalOnError(rows.Err())
+	return results
+}
+
+/*
+ * The system schemas, other than those named with --include-system-schema,
+ * formatted for use in a WHERE clause.
+ */
+func systemSchemaFilterClause(namespace string, includedSystemSchemas []string) string {
+	included := utils.NewSet(includedSystemSchemas)
+	excluded := make([]string, 0)
+	for _, schema := range []string{"gp_toolkit", "information_schema", "pg_aoseg", "pg_bitmapindex", "pg_catalog"} {
+		if !included.MatchesFilter(schema) {
+			excluded = append(excluded, fmt.Sprintf("'%s'", schema))
 		}
-		return verifiedResults
-	} else {
-		return results
 	}
+	return fmt.Sprintf(`%s.nspname NOT LIKE 'pg_temp_%%' AND %s.nspname NOT LIKE 'pg_toast%%' AND %s.nspname NOT IN (%s)`,
+		namespace, namespace, namespace, strings.Join(excluded, ", "))
 }
 
 // A list of schemas we don't want to back up, formatted for use in a WHERE clause
@@ -206,7 +267,7 @@ func SchemaFilterClause(namespace string) string {
 	if len(MustGetFlagStringArray(options.EXCLUDE_SCHEMA)) > 0 {
 		schemaFilterClauseStr = fmt.Sprintf("\nAND %s.nspname NOT IN (%s)", namespace, utils.SliceToQuotedString(MustGetFlagStringArray(options.EXCLUDE_SCHEMA)))
 	}
-	return fmt.Sprintf(`%s.nspname NOT LIKE 'pg_temp_%%' AND %s.nspname NOT LIKE 'pg_toast%%' AND %s.nspname NOT IN ('gp_toolkit', 'information_schema', 'pg_aoseg', 'pg_bitmapindex', 'pg_catalog') %s`, namespace, namespace, namespace, schemaFilterClauseStr)
+	return fmt.Sprintf(`%s %s`, systemSchemaFilterClause(namespace, MustGetFlagStringArray(options.INCLUDE_SYSTEM_SCHEMA)), schemaFilterClauseStr)
 }
 
 /*
@@ -245,14 +306,58 @@ func SchemaFilterClauseWithAlteredPartitionSchemas(namespace string, partitionAl
 			schemaFilterClauseStr = fmt.Sprintf("\nAND %s.nspname NOT IN (%s)", namespace, utils.SliceToQuotedString(excludeSchemaArray))
 		}
 	}
-	return fmt.Sprintf(`%s.nspname NOT LIKE 'pg_temp_%%' AND %s.nspname NOT LIKE 'pg_toast%%' AND %s.nspname NOT IN ('gp_toolkit', 'information_schema', 'pg_aoseg', 'pg_bitmapindex', 'pg_catalog') %s`, namespace, namespace, namespace, schemaFilterClauseStr)
+	// System schemas are never created on restore, so they are left out even if their objects are included
+	return fmt.Sprintf(`%s %s`, systemSchemaFilterClause(namespace, nil), schemaFilterClauseStr)
 }
 
+/*
+ * The objects that a system schema named with --include-system-schema was
+ * created with have oids below FIRST_NORMAL_OBJECT_ID, unlike any user
+ * object, so they are left out along with extension members.  Schemas are
+ * queried without an alias and are not filtered this way, as the public
+ * schema was created with the database too.
+ */
 func ExtensionFilterClause(namespace string) string {
 	oidStr := "oid"
 	if namespace != "" {
 		oidStr = fmt.Sprintf("%s.oid", namespace)
 	}
+	systemObjectStr := ""
+	if namespace != "" && len(MustGetFlagStringArray(options.INCLUDE_SYSTEM_SCHEMA)) > 0 {
+		systemObjectStr = fmt.Sprintf(" AND %s >= %d", oidStr, FIRST_NORMAL_OBJECT_ID)
+	}
+
+	if backUpAddedExtensionMembers() {
+		return fmt.Sprintf("%s NOT IN (select objid from pg_depend where deptype = 'e' EXCEPT select objid from (%s) added)%s", oidStr, addedExtensionMembersQuery, systemObjectStr)
+	}
+	return fmt.Sprintf("%s NOT IN (select objid from pg_depend where deptype = 'e')%s", oidStr, systemObjectStr)
+}
+
+/*
+ * Objects added to an extension with ALTER EXTENSION ... ADD are not created by
+ * the extension's script, so CREATE EXTENSION does not recreate them on
+ * restore.  The catalog does not record how a member was added, so we treat a
+ * member as added if it predates the extension, or if its pg_depend entry was
+ * written in a different transaction than that of the extension's first
+ * member, which CREATE EXTENSION always creates.  Members created by ALTER
+ * EXTENSION ... UPDATE also match the second test; --no-extension-members
+ * disables this for databases where that is a problem.
+ */
+var addedExtensionMembersQuery = fmt.Sprintf(`
+	SELECT d.classid, d.objid, d.refobjid
+	FROM pg_depend d
+		JOIN pg_extension e ON d.refobjid = e.oid
+	WHERE d.deptype = 'e'
+		AND d.refclassid = 'pg_extension'::regclass
+		AND e.oid >= %d
+		AND (d.objid < e.oid OR d.xmin::text <> (
+			SELECT f.xmin::text FROM pg_depend f
+			WHERE f.deptype = 'e'
+				AND f.refclassid = 'pg_extension'::regclass
+				AND f.refobjid = e.oid
+				AND f.objid > e.oid
+			ORDER BY f.objid LIMIT 1))`, FIRST_NORMAL_OBJECT_ID)
 
-	return fmt.Sprintf("%s NOT IN (select objid from pg_depend where deptype = 'e')", oidStr)
+func backUpAddedExtensionMembers() bool {
+	return !MustGetFlagBool(options.NO_EXTENSION_MEMBERS) && connectionPool.Version.AtLeast("6")
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/greenplum-db/gp-common-go-libs/structmatcher"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/options"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -33,5 +34,49 @@ var _ = Describe("backup/queries_shared tests", func() {
 			Expect(result).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatch(&expectedResult[0], &result[0])
 		})
+		It("queries the constraints of the given tables in batches", func() {
+			header := []string{"oid", "schema", "name", "contype", "condef", "owningobject"}
+			tables := make([]backup.Relation, 10001)
+			for i := range tables {
+				tables[i] = backup.Relation{Oid: uint32(i + 1)}
+			}
+			mock.ExpectQuery(`AND c.oid IN \(1,2,.*,10000\)`).
+				WillReturnRows(sqlmock.NewRows(header).AddRow(100, "public", "pk", "p", "PRIMARY KEY (id)", "public.first"))
+			mock.ExpectQuery(`AND c.oid IN \(10001\)`).
+				WillReturnRows(sqlmock.NewRows(header).AddRow(200, "public", "pk2", "p", "PRIMARY KEY (id)", "public.last"))
+
+			result := backup.GetConstraints(connectionPool, tables...)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(result).To(HaveLen(2))
+			Expect(result[0].OwningObject).To(Equal("public.first"))
+			Expect(result[1].OwningObject).To(Equal("public.last"))
+		})
+	})
+	Describe("SchemaFilterClause", func() {
+		It("excludes every system schema by default", func() {
+			Expect(backup.SchemaFilterClause("n")).To(Equal(`n.nspname NOT LIKE 'pg_temp_%' AND n.nspname NOT LIKE 'pg_toast%' AND n.nspname NOT IN ('gp_toolkit', 'information_schema', 'pg_aoseg', 'pg_bitmapindex', 'pg_catalog') `))
+		})
+		It("does not exclude a system schema named with --include-system-schema", func() {
+			_ = cmdFlags.Set(options.INCLUDE_SYSTEM_SCHEMA, "information_schema")
+			Expect(backup.SchemaFilterClause("n")).To(Equal(`n.nspname NOT LIKE 'pg_temp_%' AND n.nspname NOT LIKE 'pg_toast%' AND n.nspname NOT IN ('gp_toolkit', 'pg_aoseg', 'pg_bitmapindex', 'pg_catalog') `))
+		})
+		It("still excludes system schemas from the schemas to create", func() {
+			_ = cmdFlags.Set(options.INCLUDE_SYSTEM_SCHEMA, "information_schema")
+			Expect(backup.SchemaFilterClauseWithAlteredPartitionSchemas("n", nil)).To(ContainSubstring("'information_schema'"))
+		})
+	})
+	Describe("ExtensionFilterClause", func() {
+		It("does not filter objects by oid by default", func() {
+			Expect(backup.ExtensionFilterClause("c")).ToNot(ContainSubstring("c.oid >= 16384"))
+		})
+		It("leaves out built-in objects of a system schema named with --include-system-schema", func() {
+			_ = cmdFlags.Set(options.INCLUDE_SYSTEM_SCHEMA, "pg_catalog")
+			Expect(backup.ExtensionFilterClause("c")).To(HaveSuffix(" AND c.oid >= 16384"))
+		})
+		It("does not filter schemas by oid", func() {
+			_ = cmdFlags.Set(options.INCLUDE_SYSTEM_SCHEMA, "pg_catalog")
+			Expect(backup.ExtensionFilterClause("")).ToNot(ContainSubstring("AND oid >= 16384"))
+		})
 	})
 })
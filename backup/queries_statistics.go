@@ -48,6 +48,11 @@ type AttributeStatistic struct {
 	Values5      pq.StringArray `db:"stavalues5"`
 }
 
+/*
+ * Leaf partitions backed up with --leaf-partition-data may have been moved to
+ * a schema outside the schema filters, so the schemas of moved partitions are
+ * allowed here as they are for the partition metadata.
+ */
 func GetAttributeStatistics(connectionPool *dbconn.DBConn, tables []Table) map[uint32][]AttributeStatistic {
 	inheritClause := ""
 	statSlotClause := ""
@@ -99,7 +104,7 @@ func GetAttributeStatistics(connectionPool *dbconn.DBConn, tables []Table) map[u
 	WHERE %s
 		AND quote_ident(n.nspname) || '.' || quote_ident(c.relname) IN (%s)
 	ORDER BY n.nspname, c.relname, a.attnum`,
-	inheritClause, statSlotClause, SchemaFilterClause("n"), utils.SliceToQuotedString(tablenames))
+	inheritClause, statSlotClause, SchemaFilterClauseWithAlteredPartitionSchemas("n", createAlteredPartitionSchemaSet(tables)), utils.SliceToQuotedString(tablenames))
 
 	results := make([]AttributeStatistic, 0)
 	err := connectionPool.Select(&results, query)
@@ -135,7 +140,7 @@ func GetTupleStatistics(connectionPool *dbconn.DBConn, tables []Table) map[uint3
 	WHERE %s
 		AND quote_ident(n.nspname) || '.' || quote_ident(c.relname) IN (%s)
 	ORDER BY n.nspname, c.relname`,
-	SchemaFilterClause("n"), utils.SliceToQuotedString(tablenames))
+	SchemaFilterClauseWithAlteredPartitionSchemas("n", createAlteredPartitionSchemaSet(tables)), utils.SliceToQuotedString(tablenames))
 
 	results := make([]TupleStatistic, 0)
 	err := connectionPool.Select(&results, query)
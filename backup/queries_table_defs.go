@@ -8,12 +8,16 @@ package backup
 import (
 	"database/sql"
 	"fmt"
-	"strings"
+	"sort"
+	"sync"
+	"sync/atomic"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
 )
 
 type Table struct {
@@ -22,8 +26,70 @@ type Table struct {
 }
 
 func (t Table) SkipDataBackup() bool {
-	def := t.TableDefinition
-	return def.IsExternal || (def.ForeignDef != ForeignTableDefinition{})
+	return t.DataSkipReason() != ""
+}
+
+/*
+ * Returns the reason recorded in the TOC for leaving the table's data out of
+ * the backup, or an empty string if its data is backed up.
+ */
+func (t Table) DataSkipReason() string {
+	switch {
+	case t.IsExternal:
+		return toc.SKIP_REASON_EXTERNAL
+	case t.ForeignDef != ForeignTableDefinition{}:
+		return toc.SKIP_REASON_FOREIGN
+	case t.SkipReplicatedData():
+		return toc.SKIP_REASON_REPLICATED
+	case t.DataExcluded:
+		return toc.SKIP_REASON_EXCLUDED
+	}
+	return ""
+}
+
+/*
+ * Replicated tables hold a full copy of their data on every segment, and some
+ * users regenerate such reference data rather than restoring it, so
+ * --no-data-for-replicated-tables backs up only their metadata.
+ */
+func (t Table) SkipReplicatedData() bool {
+	return MustGetFlagBool(options.NO_REPLICATED_DATA) && t.DistPolicy == "DISTRIBUTED REPLICATED"
+}
+
+/*
+ * COPY ... BINARY needs binary send and receive functions for every column
+ * type, so tables with other column types keep using CSV.
+ */
+func (t Table) DataFormat() string {
+	if MustGetFlagString(options.DATA_FORMAT) == "binary" && !t.NoBinaryIO {
+		return "binary"
+	}
+	return "csv"
+}
+
+/*
+ * Once the metadata file is written, tables are only needed to back up their
+ * data, so in low-memory mode the parts of their definitions used only to
+ * print CREATE TABLE statements are released. The fields that DataSkipReason,
+ * DataFormat, and the COPY statements read are kept.
+ */
+func ReleaseMetadataDefinitions(tables []Table) {
+	for i := range tables {
+		definition := &tables[i].TableDefinition
+		definition.PartDef = ""
+		definition.PartTemplateDef = ""
+		definition.StorageOpts = ""
+		definition.TablespaceName = ""
+		definition.ExtTableDef = ExternalTableDefinition{}
+		definition.Inherits = nil
+		definition.ReplicaIdentity = ""
+		definition.ReplicaIdentityIndex = ""
+		definition.AccessMethod = ""
+		definition.PartitionAlteredSchemas = nil
+		definition.PartitionLeafOwners = nil
+		definition.PartitionKeys = nil
+		definition.PartitionChildren = nil
+	}
 }
 
 func (t Table) GetMetadataEntry() (string, toc.MetadataEntry) {
@@ -42,6 +108,18 @@ func (t Table) GetMetadataEntry() (string, toc.MetadataEntry) {
 		}
 }
 
+func (t Table) GetReplicaIdentityMetadataEntry() (string, toc.MetadataEntry) {
+	return "postdata",
+		toc.MetadataEntry{
+			Schema:          t.Schema,
+			Name:            t.Name,
+			ObjectType:      "REPLICA IDENTITY",
+			ReferenceObject: t.FQN(),
+			StartByte:       0,
+			EndByte:         0,
+		}
+}
+
 /*
  * Extract all the unique schemas out from a Table array.
  */
@@ -56,6 +134,18 @@ func createAlteredPartitionSchemaSet(tables []Table) map[string]bool {
 	return partitionAlteredSchemas
 }
 
+/*
+ * Schemas outside the schema filters that must still be created, for
+ * partitions moved to another schema and types included for tables.
+ */
+func createIncludedSchemaSet(tables []Table) map[string]bool {
+	includedSchemas := createAlteredPartitionSchemaSet(tables)
+	for _, dependency := range includedTypeDependencies {
+		includedSchemas[dependency.Schema] = true
+	}
+	return includedSchemas
+}
+
 type TableDefinition struct {
 	DistPolicy         string
 	PartDef            string
@@ -68,37 +158,77 @@ type TableDefinition struct {
 	PartitionLevelInfo PartitionLevelInfo
 	TableType          string
 	IsUnlogged         bool
+	HasOids            bool
 	ForeignDef         ForeignTableDefinition
 	Inherits           []string
 	ReplicaIdentity    string
+	ReplicaIdentityIndex string
+	AccessMethod       string
 	PartitionAlteredSchemas []AlteredPartitionRelation
+	PartitionLeafOwners     []PartitionLeafOwner
+	PartitionKeys           []PartitionKey
+	PartitionChildren       []PartitionChild
+	NoBinaryIO              bool
+	ColumnSubstitutions     map[string]string
+	DataExcluded            bool // set for leaf partitions given in --exclude-table-data
 }
 
 /*
  * This function calls all the functions needed to gather the metadata for a
  * single table and assembles the metadata into ColumnDef and TableDef structs
  * for more convenient handling in the PrintCreateTableStatement() function.
+ * The queries are independent of one another, so in GPDB 7+ they are spread
+ * across the connections opened for --jobs and their results joined by oid
+ * afterward.
  */
 func ConstructDefinitionsForTables(connectionPool *dbconn.DBConn, tableRelations []Relation) []Table {
 	tables := make([]Table, 0)
 
 	gplog.Info("Gathering additional table metadata")
-	columnDefs := GetColumnDefinitions(connectionPool)
-	distributionPolicies := GetDistributionPolicies(connectionPool)
-	partitionDefs, partTemplateDefs := GetPartitionDetails(connectionPool)
-	tablespaceNames, storageOptions := GetTableStorage(connectionPool)
-	extTableDefs := GetExternalTableDefinitions(connectionPool)
-	partTableMap := GetPartitionTableMap(connectionPool)
-	tableTypeMap := GetTableType(connectionPool)
-	unloggedTableMap := GetUnloggedTables(connectionPool)
-	foreignTableDefs := GetForeignTableDefinitions(connectionPool)
-	inheritanceMap := GetTableInheritance(connectionPool, tableRelations)
-	replicaIdentityMap := GetTableReplicaIdentity(connectionPool)
-	partitionAlteredSchemaMap := GetPartitionAlteredSchema(connectionPool)
+	var columnDefs map[uint32][]ColumnDefinition
+	var distributionPolicies, partitionDefs, partTemplateDefs, tablespaceNames, storageOptions map[uint32]string
+	var tableTypeMap, replicaIdentityMap, replicaIdentityIndexMap, accessMethodMap map[uint32]string
+	var extTableDefs map[uint32]ExternalTableDefinition
+	var partTableMap map[uint32]PartitionLevelInfo
+	var unloggedTableMap, oidsTableMap map[uint32]bool
+	var foreignTableDefs map[uint32]ForeignTableDefinition
+	var inheritanceMap map[uint32][]string
+	var partitionAlteredSchemaMap map[uint32][]AlteredPartitionRelation
+	var partitionLeafOwnerMap map[uint32][]PartitionLeafOwner
+	noBinaryIOMap := make(map[uint32]bool)
+	partitionKeyMap := make(map[uint32][]PartitionKey)
+	partitionChildMap := make(map[uint32][]PartitionChild)
+	queries := []func(conn *dbconn.DBConn){
+		func(conn *dbconn.DBConn) { columnDefs = GetColumnDefinitions(conn) },
+		func(conn *dbconn.DBConn) { distributionPolicies = GetDistributionPolicies(conn) },
+		func(conn *dbconn.DBConn) { partitionDefs, partTemplateDefs = GetPartitionDetails(conn) },
+		func(conn *dbconn.DBConn) { tablespaceNames, storageOptions = GetTableStorage(conn) },
+		func(conn *dbconn.DBConn) { extTableDefs = GetExternalTableDefinitions(conn) },
+		func(conn *dbconn.DBConn) { partTableMap = GetPartitionTableMap(conn) },
+		func(conn *dbconn.DBConn) { tableTypeMap = GetTableType(conn) },
+		func(conn *dbconn.DBConn) { unloggedTableMap = GetUnloggedTables(conn) },
+		func(conn *dbconn.DBConn) { oidsTableMap = GetTablesWithOids(conn) },
+		func(conn *dbconn.DBConn) { foreignTableDefs = GetForeignTableDefinitions(conn) },
+		func(conn *dbconn.DBConn) { inheritanceMap = GetTableInheritance(conn, tableRelations) },
+		func(conn *dbconn.DBConn) { replicaIdentityMap, replicaIdentityIndexMap = GetTableReplicaIdentity(conn) },
+		func(conn *dbconn.DBConn) { accessMethodMap = GetTableAccessMethods(conn) },
+		func(conn *dbconn.DBConn) { partitionAlteredSchemaMap = GetPartitionAlteredSchema(conn) },
+		func(conn *dbconn.DBConn) { partitionLeafOwnerMap = GetPartitionLeafOwners(conn) },
+	}
+	if MustGetFlagString(options.DATA_FORMAT) == "binary" {
+		queries = append(queries, func(conn *dbconn.DBConn) { noBinaryIOMap = GetTablesWithoutBinaryIO(conn) })
+	}
+	if MustGetFlagBool(options.LEAF_PARTITION_DDL) {
+		queries = append(queries,
+			func(conn *dbconn.DBConn) { partitionKeyMap = GetPartitionKeys(conn) },
+			func(conn *dbconn.DBConn) { partitionChildMap = GetPartitionChildren(conn) })
+	}
+	runQueriesInParallel(connectionPool, queries)
 
 	gplog.Verbose("Constructing table definition map")
 	for _, tableRel := range tableRelations {
 		oid := tableRel.Oid
+		ensureColumnOrder(tableRel.FQN(), columnDefs[oid])
 		tableDef := TableDefinition{
 			DistPolicy:         distributionPolicies[oid],
 			PartDef:            partitionDefs[oid],
@@ -111,19 +241,92 @@ func ConstructDefinitionsForTables(connectionPool *dbconn.DBConn, tableRelations
 			PartitionLevelInfo: partTableMap[oid],
 			TableType:          tableTypeMap[oid],
 			IsUnlogged:         unloggedTableMap[oid],
+			HasOids:            oidsTableMap[oid],
 			ForeignDef:         foreignTableDefs[oid],
 			Inherits:           inheritanceMap[oid],
 			ReplicaIdentity:    replicaIdentityMap[oid],
+			ReplicaIdentityIndex: replicaIdentityIndexMap[oid],
+			AccessMethod:       accessMethodMap[oid],
 			PartitionAlteredSchemas: partitionAlteredSchemaMap[oid],
+			PartitionLeafOwners:     partitionLeafOwnerMap[oid],
+			PartitionKeys:           partitionKeyMap[oid],
+			PartitionChildren:       partitionChildMap[oid],
+			NoBinaryIO:              noBinaryIOMap[oid],
 		}
 		if tableDef.Inherits == nil {
 			tableDef.Inherits = []string{}
 		}
+		if tableDef.NoBinaryIO && !tableDef.IsExternal {
+			report.Warn(report.WARN_CSV_DATA_FORMAT, tableRel.FQN(), "Table %s has a column type without binary send and receive functions, so its data will be backed up in CSV format", tableRel.FQN())
+		}
 		tables = append(tables, Table{tableRel, tableDef})
 	}
 	return tables
 }
 
+/*
+ * Runs each query function with a connection of its own from the pool, when
+ * the connections share the snapshot exported by connection 0.  Without a
+ * shared snapshot, as before GPDB 7, each connection's snapshot is taken by
+ * its own first query, so a table altered between those queries could be
+ * seen differently by two of them; the queries are then all run on
+ * connection 0 instead.  A query that fails fatally panics on its worker's
+ * goroutine, so the first panic is raised again here, where the usual cleanup
+ * can recover from it.  The other workers finish the query they are running
+ * but start no more, so the backup fails without waiting on the rest of the
+ * catalog.
+ */
+func runQueriesInParallel(connectionPool *dbconn.DBConn, queries []func(conn *dbconn.DBConn)) {
+	queue := make(chan func(conn *dbconn.DBConn), len(queries))
+	for _, query := range queries {
+		queue <- query
+	}
+	close(queue)
+
+	var workerPool sync.WaitGroup
+	var panicOnce sync.Once
+	var firstPanic interface{}
+	var failed int32
+	numWorkers := connectionPool.NumConns
+	if synchronizedSnapshot == "" {
+		numWorkers = 1
+	}
+	for connNum := 0; connNum < numWorkers && connNum < len(queries); connNum++ {
+		workerPool.Add(1)
+		go func(conn *dbconn.DBConn) {
+			defer workerPool.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					atomic.StoreInt32(&failed, 1)
+					panicOnce.Do(func() { firstPanic = r })
+				}
+			}()
+			for query := range queue {
+				if atomic.LoadInt32(&failed) == 1 {
+					return
+				}
+				query(conn)
+			}
+		}(singleConnection(connectionPool, connNum))
+	}
+	workerPool.Wait()
+	if firstPanic != nil {
+		panic(firstPanic)
+	}
+}
+
+/*
+ * Returns a copy of the pool that runs every query on connection connNum, for
+ * passing to the query functions, which run their queries on connection 0.
+ */
+func singleConnection(connectionPool *dbconn.DBConn, connNum int) *dbconn.DBConn {
+	conn := *connectionPool
+	conn.ConnPool = connectionPool.ConnPool[connNum : connNum+1]
+	conn.Tx = connectionPool.Tx[connNum : connNum+1]
+	conn.NumConns = 1
+	return &conn
+}
+
 /*
  * This returns a map of all parent partition tables and leaf partition tables;
  * "p" indicates a parent table, "l" indicates a leaf table, and "i" indicates
@@ -180,11 +383,23 @@ type ColumnDefinition struct {
 	Comment               string
 	Privileges            sql.NullString
 	Kind                  string
+	ACL                   []ACL `db:"-"` // every entry of the column's ACL, gathered from its rows
 	Options               string
 	FdwOptions            string
 	Collation             string
 	SecurityLabelProvider string
 	SecurityLabel         string
+	Identity              string
+	IdentitySequence      string
+	IdentityStart         int64
+	IdentityIncrement     int64
+	IdentityMin           int64
+	IdentityMax           int64
+	IdentityCache         int64
+	IdentityCycle         bool
+	IdentityLastVal       sql.NullInt64
+	GenerationExpr        string
+	CompressionType       string
 }
 
 var storageTypeCodes = map[string]string{
@@ -194,12 +409,16 @@ var storageTypeCodes = map[string]string{
 	"x": "EXTENDED",
 }
 
+var compressionTypeCodes = map[string]string{
+	"l": "lz4",
+	"p": "pglz",
+}
+
 func GetColumnDefinitions(connectionPool *dbconn.DBConn) map[uint32][]ColumnDefinition {
 	// This query is adapted from the getTableAttrs() function in pg_dump.c.
 	// Optimize Get column definitions to avoid child partitions
 	// Include child partitions that are also external tables
 	gplog.Verbose("Getting column definitions")
-	results := make([]ColumnDefinition, 0)
 	selectClause := `
     SELECT a.attrelid,
 		a.attnum,
@@ -223,7 +442,7 @@ func GetColumnDefinitions(connectionPool *dbconn.DBConn) map[uint32][]ColumnDefi
 	whereClause := `
 	WHERE ` + relationAndSchemaFilterClause() + `
 		AND NOT EXISTS (SELECT 1 FROM 
-			(SELECT parchildrelid FROM pg_partition_rule EXCEPT SELECT reloid FROM pg_exttable)
+			(` + reachablePartitionChildren + ` EXCEPT SELECT reloid FROM pg_exttable)
 			par WHERE par.parchildrelid = c.oid)
 		AND c.reltype <> 0
 		AND a.attnum > 0::pg_catalog.int2
@@ -245,7 +464,7 @@ func GetColumnDefinitions(connectionPool *dbconn.DBConn) map[uint32][]ColumnDefi
 		coalesce(pg_catalog.array_to_string(a.attoptions, ','), '') AS options,
 		coalesce(array_to_string(ARRAY(SELECT option_name || ' ' || quote_literal(option_value) FROM pg_options_to_table(attfdwoptions) ORDER BY option_name), ', '), '') AS fdwoptions,
 		CASE WHEN a.attcollation <> t.typcollation THEN quote_ident(cn.nspname) || '.' || quote_ident(coll.collname) ELSE '' END AS collation,
-		coalesce(sec.provider,'') AS securitylabelprovider,
+		coalesce(quote_ident(sec.provider),'') AS securitylabelprovider,
 		coalesce(sec.label,'') AS securitylabel`
 		fromClause += `
 		LEFT JOIN pg_collation coll ON a.attcollation = coll.oid
@@ -254,17 +473,86 @@ func GetColumnDefinitions(connectionPool *dbconn.DBConn) map[uint32][]ColumnDefi
 			sec.classoid = 'pg_class'::regclass AND sec.objsubid = a.attnum`
 	}
 
+	/*
+	 * Identity columns, added in GPDB 7, are backed by a sequence that depends
+	 * internally on the column.  Stored generated columns, also added in GPDB 7,
+	 * keep their generation expression in pg_attrdef as a default would.
+	 */
+	if connectionPool.Version.AtLeast("7") {
+		selectClause += `,
+		a.attidentity AS identity,
+		coalesce(quote_ident(sn.nspname) || '.' || quote_ident(s.relname), '') AS identitysequence,
+		coalesce(sp.seqstart, 0) AS identitystart,
+		coalesce(sp.seqincrement, 0) AS identityincrement,
+		coalesce(sp.seqmin, 0) AS identitymin,
+		coalesce(sp.seqmax, 0) AS identitymax,
+		coalesce(sp.seqcache, 0) AS identitycache,
+		coalesce(sp.seqcycle, false) AS identitycycle,
+		pg_catalog.pg_sequence_last_value(s.oid) AS identitylastval,
+		CASE WHEN a.attgenerated = 's' THEN pg_catalog.pg_get_expr(ad.adbin, ad.adrelid) ELSE '' END AS generationexpr`
+		fromClause += `
+		LEFT JOIN pg_depend sd ON a.attidentity <> '' AND sd.classid = 'pg_class'::regclass AND sd.deptype = 'i'
+			AND sd.refclassid = 'pg_class'::regclass AND sd.refobjid = a.attrelid AND sd.refobjsubid = a.attnum
+		LEFT JOIN pg_class s ON s.oid = sd.objid
+		LEFT JOIN pg_namespace sn ON s.relnamespace = sn.oid
+		LEFT JOIN pg_sequence sp ON sp.seqrelid = s.oid`
+	}
+
+	/*
+	 * The compression method of a column's TOAST data is only in the catalog
+	 * from Postgres 14, which GPDB 7 predates.  A column that uses the default
+	 * method leaves attcompression empty.
+	 */
+	if connectionPool.Version.AtLeast("8") {
+		selectClause += `,
+		a.attcompression AS compressiontype`
+	}
+
+	/*
+	 * A database can have millions of columns, so each row is bucketed by table
+	 * as it is scanned rather than all of them being held in a slice first.
+	 */
 	query := fmt.Sprintf(`%s %s %s;`, selectClause, fromClause, whereClause)
-	err := connectionPool.Select(&results, query)
+	rows, err := connectionPool.Query(query)
 	gplog.FatalOnError(err)
+	defer rows.Close()
 	resultMap := make(map[uint32][]ColumnDefinition)
-	for _, result := range results {
+	for rows.Next() {
+		var result ColumnDefinition
+		err = rows.StructScan(&result)
+		gplog.FatalOnError(err)
 		result.StorageType = storageTypeCodes[result.StorageType]
-		resultMap[result.Oid] = append(resultMap[result.Oid], result)
+		result.CompressionType = compressionTypeCodes[result.CompressionType]
+		if result.Privileges.Valid || result.Kind == "Empty" {
+			result.ACL = getColumnACL(result.Privileges, result.Kind)
+		}
+		// A column with privileges has one row per ACL entry, which are collected into its first row
+		columns := resultMap[result.Oid]
+		if last := len(columns) - 1; last >= 0 && columns[last].Num == result.Num {
+			columns[last].ACL = sortACLs(append(columns[last].ACL, result.ACL...))
+			continue
+		}
+		resultMap[result.Oid] = append(columns, result)
 	}
+	gplog.FatalOnError(rows.Err())
 	return resultMap
 }
 
+/*
+ * SELECT * returns a table's columns in attnum order, skipping dropped columns,
+ * and GPDB has no separate logical column order, so the columns must be
+ * emitted in attnum order for INSERT ... SELECT * and COPY without a column
+ * list to match the source table after restore.
+ */
+func ensureColumnOrder(tableFQN string, columnDefs []ColumnDefinition) {
+	byAttnum := func(i int, j int) bool { return columnDefs[i].Num < columnDefs[j].Num }
+	if sort.SliceIsSorted(columnDefs, byAttnum) {
+		return
+	}
+	gplog.Warn("Column definitions for table %s do not match the SELECT * column order; reordering them by attribute number", tableFQN)
+	sort.SliceStable(columnDefs, byAttnum)
+}
+
 func GetDistributionPolicies(connectionPool *dbconn.DBConn) map[uint32]string {
 	gplog.Verbose("Getting distribution policies")
 	var query string
@@ -306,17 +594,39 @@ func GetTableType(connectionPool *dbconn.DBConn) map[uint32]string {
 	return selectAsOidToStringMap(connectionPool, query)
 }
 
-func GetTableReplicaIdentity(connectionPool *dbconn.DBConn) map[uint32]string {
+/*
+ * Returns the replica identity of each table, and for tables whose replica
+ * identity is an index, the name of that index.
+ */
+func GetTableReplicaIdentity(connectionPool *dbconn.DBConn) (map[uint32]string, map[uint32]string) {
 	if connectionPool.Version.Before("6") {
-		return map[uint32]string{}
+		return map[uint32]string{}, map[uint32]string{}
 	}
 	query := fmt.Sprintf(`
-	SELECT oid,
-		relreplident AS value
-	FROM pg_class
-	WHERE relkind IN ('r', 'm')
-		AND oid >= %d`, FIRST_NORMAL_OBJECT_ID)
-	return selectAsOidToStringMap(connectionPool, query)
+	SELECT c.oid,
+		c.relreplident AS identity,
+		quote_ident(ic.relname) AS indexname
+	FROM pg_class c
+		LEFT JOIN pg_index i ON i.indrelid = c.oid AND i.indisreplident AND c.relreplident = 'i'
+		LEFT JOIN pg_class ic ON ic.oid = i.indexrelid
+	WHERE c.relkind IN ('r', 'm')
+		AND c.oid >= %d`, FIRST_NORMAL_OBJECT_ID)
+	var results []struct {
+		Oid       uint32
+		Identity  string
+		IndexName sql.NullString
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	replicaIdentities := make(map[uint32]string)
+	replicaIdentityIndexes := make(map[uint32]string)
+	for _, result := range results {
+		replicaIdentities[result.Oid] = result.Identity
+		if result.IndexName.Valid {
+			replicaIdentityIndexes[result.Oid] = result.IndexName.String
+		}
+	}
+	return replicaIdentities, replicaIdentityIndexes
 }
 
 func GetPartitionDetails(connectionPool *dbconn.DBConn) (map[uint32]string, map[uint32]string) {
@@ -332,7 +642,7 @@ func GetPartitionDetails(connectionPool *dbconn.DBConn) (map[uint32]string, map[
 	WHERE %s`, relationAndSchemaFilterClause())
 	var results []struct {
 		Oid        uint32
-		Definition string
+		Definition sql.NullString
 		Template   sql.NullString
 	}
 	err := connectionPool.Select(&results, query)
@@ -340,7 +650,10 @@ func GetPartitionDetails(connectionPool *dbconn.DBConn) (map[uint32]string, map[
 	partitionDef := make(map[uint32]string)
 	partitionTemp := make(map[uint32]string)
 	for _, result := range results {
-		partitionDef[result.Oid] = result.Definition
+		// pg_get_partition_def returns NULL for a hierarchy that is inconsistent in the catalog
+		if result.Definition.Valid {
+			partitionDef[result.Oid] = result.Definition.String
+		}
 		if result.Template.Valid {
 			partitionTemp[result.Oid] = result.Template.String
 		}
@@ -388,6 +701,49 @@ func GetPartitionAlteredSchema(connectionPool *dbconn.DBConn) map[uint32][]Alter
 	return partitionAlteredSchemaMap
 }
 
+type PartitionLeafOwner struct {
+	Schema string
+	Name   string
+	Owner  string
+}
+
+/*
+ * Only the root partition table receives an ALTER TABLE ... OWNER TO
+ * statement, and child partitions inherit that owner when the hierarchy is
+ * recreated.  This returns the child partitions of each root whose owner
+ * differs from the owner of the root, so their ownership can be restored
+ * explicitly.
+ */
+func GetPartitionLeafOwners(connectionPool *dbconn.DBConn) map[uint32][]PartitionLeafOwner {
+	gplog.Verbose("Getting child partitions with owners differing from their root partition")
+	query := fmt.Sprintf(`
+	SELECT p.parrelid AS oid,
+		quote_ident(ln.nspname) AS schema,
+		quote_ident(lc.relname) AS name,
+		quote_ident(pg_get_userbyid(lc.relowner)) AS owner
+	FROM pg_partition p
+		JOIN pg_partition_rule r ON p.oid = r.paroid
+		JOIN pg_class c ON p.parrelid = c.oid
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+		JOIN pg_class lc ON r.parchildrelid = lc.oid
+		JOIN pg_namespace ln ON lc.relnamespace = ln.oid
+	WHERE p.paristemplate = false
+		AND lc.relowner != c.relowner
+		AND %s
+	ORDER BY p.parrelid, lc.oid`, relationAndSchemaFilterClause())
+	var results []struct {
+		Oid uint32
+		PartitionLeafOwner
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	partitionLeafOwnerMap := make(map[uint32][]PartitionLeafOwner)
+	for _, result := range results {
+		partitionLeafOwnerMap[result.Oid] = append(partitionLeafOwnerMap[result.Oid], result.PartitionLeafOwner)
+	}
+	return partitionLeafOwnerMap
+}
+
 func GetTableStorage(connectionPool *dbconn.DBConn) (map[uint32]string, map[uint32]string) {
 	gplog.Info("Getting storage information")
 	query := fmt.Sprintf(`
@@ -437,6 +793,44 @@ func GetUnloggedTables(connectionPool *dbconn.DBConn) map[uint32]bool {
 	return resultMap
 }
 
+// OIDS were removed from tables in GPDB 7, along with relhasoids
+func GetTablesWithOids(connectionPool *dbconn.DBConn) map[uint32]bool {
+	if connectionPool.Version.AtLeast("7") {
+		return map[uint32]bool{}
+	}
+	query := `SELECT oid FROM pg_class WHERE relhasoids AND relkind = 'r'`
+	var results []struct {
+		Oid uint32
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	resultMap := make(map[uint32]bool)
+	for _, result := range results {
+		resultMap[result.Oid] = true
+	}
+	return resultMap
+}
+
+func GetTablesWithoutBinaryIO(connectionPool *dbconn.DBConn) map[uint32]bool {
+	query := `
+	SELECT DISTINCT a.attrelid AS oid
+	FROM pg_attribute a
+		JOIN pg_type t ON a.atttypid = t.oid
+	WHERE a.attnum > 0
+		AND NOT a.attisdropped
+		AND (t.typsend = 0 OR t.typreceive = 0)`
+	var results []struct {
+		Oid uint32
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	resultMap := make(map[uint32]bool)
+	for _, result := range results {
+		resultMap[result.Oid] = true
+	}
+	return resultMap
+}
+
 type ForeignTableDefinition struct {
 	Oid     uint32 `db:"ftrelid"`
 	Options string `db:"ftoptions"`
@@ -448,7 +842,7 @@ func GetForeignTableDefinitions(connectionPool *dbconn.DBConn) map[uint32]Foreig
 		return map[uint32]ForeignTableDefinition{}
 	}
 	query := fmt.Sprintf(`
-	SELECT ftrelid, fs.srvname AS ftserver,
+	SELECT ftrelid, quote_ident(fs.srvname) AS ftserver,
 		pg_catalog.array_to_string(array(
 			SELECT pg_catalog.quote_ident(option_name) || ' ' || pg_catalog.quote_literal(option_value)
 			FROM pg_catalog.pg_options_to_table(ftoptions) ORDER BY option_name
@@ -472,7 +866,7 @@ type Dependency struct {
 }
 
 func GetTableInheritance(connectionPool *dbconn.DBConn, tables []Relation) map[uint32][]string {
-	tableFilterStr := ""
+	tableFilterStrs := []string{""}
 	if len(MustGetFlagStringArray(options.INCLUDE_RELATION)) > 0 {
 		tableOidList := make([]string, len(tables))
 		for i, table := range tables {
@@ -480,11 +874,17 @@ func GetTableInheritance(connectionPool *dbconn.DBConn, tables []Relation) map[u
 		}
 		// If we are filtering on tables, we only want to record dependencies on other tables in the list
 		if len(tableOidList) > 0 {
-			tableFilterStr = fmt.Sprintf("\nAND i.inhrelid IN (%s)", strings.Join(tableOidList, ","))
+			tableFilterStrs = make([]string, 0)
+			for _, oidStr := range GenerateOidBatches(tableOidList, oidBatchSize) {
+				tableFilterStrs = append(tableFilterStrs, fmt.Sprintf("\nAND i.inhrelid IN (%s)", oidStr))
+			}
 		}
 	}
 
-	query := fmt.Sprintf(`
+	// Each table is in exactly one batch, so its parents stay in inhseqno order
+	resultMap := make(map[uint32][]string)
+	for _, tableFilterStr := range tableFilterStrs {
+		query := fmt.Sprintf(`
 	SELECT i.inhrelid AS oid,
 		quote_ident(n.nspname) || '.' || quote_ident(p.relname) AS referencedobject
 	FROM pg_inherits i
@@ -492,18 +892,85 @@ func GetTableInheritance(connectionPool *dbconn.DBConn, tables []Relation) map[u
 		JOIN pg_namespace n ON p.relnamespace = n.oid
 	WHERE %s%s
 	ORDER BY i.inhrelid, i.inhseqno`,
-	ExtensionFilterClause("p"), tableFilterStr)
+		ExtensionFilterClause("p"), tableFilterStr)
 
-	results := make([]Dependency, 0)
-	resultMap := make(map[uint32][]string)
-	err := connectionPool.Select(&results, query)
-	gplog.FatalOnError(err)
-	for _, result := range results {
-		resultMap[result.Oid] = append(resultMap[result.Oid], result.ReferencedObject)
+		results := make([]Dependency, 0)
+		err := connectionPool.Select(&results, query)
+		gplog.FatalOnError(err)
+		for _, result := range results {
+			resultMap[result.Oid] = append(resultMap[result.Oid], result.ReferencedObject)
+		}
 	}
 	return resultMap
 }
 
+/*
+ * Table access methods were introduced in GPDB 7, where append-optimized
+ * storage is chosen with USING ao_row or ao_column rather than with reloptions.
+ */
+func GetTableAccessMethods(connectionPool *dbconn.DBConn) map[uint32]string {
+	if connectionPool.Version.Before("7") {
+		return make(map[uint32]string)
+	}
+	gplog.Verbose("Getting table access methods")
+	query := fmt.Sprintf(`
+	SELECT c.oid,
+		quote_ident(a.amname) AS value
+	FROM pg_class c
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+		JOIN pg_am a ON c.relam = a.oid
+	WHERE c.relkind = 'r'
+		AND %s`, relationAndSchemaFilterClause())
+	return selectAsOidToStringMap(connectionPool, query)
+}
+
+/*
+ * This returns the access method used by the most tables, breaking ties by
+ * name so that the result is deterministic, and clears it from those tables so
+ * that only the exceptions are printed with a USING clause.
+ */
+func ExtractDefaultTableAccessMethod(tables []Table) string {
+	counts := make(map[string]int)
+	for _, table := range tables {
+		if table.AccessMethod != "" {
+			counts[table.AccessMethod]++
+		}
+	}
+	defaultAccessMethod := ""
+	for accessMethod, count := range counts {
+		if count > counts[defaultAccessMethod] || (count == counts[defaultAccessMethod] && accessMethod < defaultAccessMethod) {
+			defaultAccessMethod = accessMethod
+		}
+	}
+	if defaultAccessMethod == "" {
+		return ""
+	}
+	for i := range tables {
+		if tables[i].AccessMethod == defaultAccessMethod {
+			tables[i].AccessMethod = ""
+		}
+	}
+	return defaultAccessMethod
+}
+
+/*
+ * Heap tables are printed without a USING clause unless
+ * --set-default-access-method is used, so that the output for them does not
+ * change, and this returns heap as the default access method to set if any
+ * are, so that they are still created as heap tables whatever the default
+ * of the restore database.
+ */
+func OmitHeapTableAccessMethod(tables []Table) string {
+	defaultAccessMethod := ""
+	for i := range tables {
+		if tables[i].AccessMethod == "heap" {
+			tables[i].AccessMethod = ""
+			defaultAccessMethod = "heap"
+		}
+	}
+	return defaultAccessMethod
+}
+
 func selectAsOidToStringMap(connectionPool *dbconn.DBConn, query string) map[uint32]string {
 	var results []struct {
 		Oid   uint32
@@ -517,3 +984,146 @@ func selectAsOidToStringMap(connectionPool *dbconn.DBConn, query string) map[uin
 	}
 	return resultMap
 }
+
+type PartitionKey struct {
+	Level   int
+	Kind    string
+	Columns []string
+}
+
+/*
+ * This returns the partitioning strategy and key columns of each level of a
+ * partition hierarchy, ordered by level, for use with --leaf-partition-ddl.
+ */
+func GetPartitionKeys(connectionPool *dbconn.DBConn) map[uint32][]PartitionKey {
+	gplog.Verbose("Getting partition keys")
+	query := fmt.Sprintf(`
+	SELECT p.parrelid AS oid,
+		p.parlevel AS level,
+		p.parkind AS kind,
+		quote_ident(a.attname) AS columnname
+	FROM pg_partition p
+		JOIN (SELECT oid, generate_series(0, parnatts - 1) AS position FROM pg_partition) k ON k.oid = p.oid
+		JOIN pg_attribute a ON a.attrelid = p.parrelid AND a.attnum = p.paratts[k.position]
+		JOIN pg_class c ON p.parrelid = c.oid
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+	WHERE p.paristemplate = false
+		AND %s
+	ORDER BY p.parrelid, p.parlevel, k.position`, relationAndSchemaFilterClause())
+	var results []struct {
+		Oid        uint32
+		Level      int
+		Kind       string
+		ColumnName string
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	partitionKeyMap := make(map[uint32][]PartitionKey)
+	for _, result := range results {
+		keys := partitionKeyMap[result.Oid]
+		if len(keys) == 0 || keys[len(keys)-1].Level != result.Level {
+			keys = append(keys, PartitionKey{Level: result.Level, Kind: result.Kind})
+		}
+		keys[len(keys)-1].Columns = append(keys[len(keys)-1].Columns, result.ColumnName)
+		partitionKeyMap[result.Oid] = keys
+	}
+	return partitionKeyMap
+}
+
+type PartitionChild struct {
+	Oid                 uint32
+	Schema              string
+	Name                string
+	ParentSchema        string
+	ParentName          string
+	Level               int
+	IsDefault           bool
+	IsExternal          bool
+	RangeStart          string
+	RangeStartInclusive bool
+	RangeEnd            string
+	RangeEndInclusive   bool
+	ListValues          string
+	TablespaceName      string
+	StorageOpts         string
+	ColumnEncodings     map[string]string
+}
+
+func (pc PartitionChild) FQN() string {
+	return utils.MakeFQN(pc.Schema, pc.Name)
+}
+
+/*
+ * This returns every child of each partition root along with its bounds and
+ * any storage settings that may deviate from its root, ordered so that each
+ * intermediate table precedes its own children.
+ */
+func GetPartitionChildren(connectionPool *dbconn.DBConn) map[uint32][]PartitionChild {
+	gplog.Verbose("Getting child partition definitions")
+	query := fmt.Sprintf(`
+	SELECT p.parrelid AS rootoid,
+		r.parchildrelid AS oid,
+		quote_ident(cn.nspname) AS schema,
+		quote_ident(cc.relname) AS name,
+		quote_ident(pn.nspname) AS parentschema,
+		quote_ident(pc.relname) AS parentname,
+		p.parlevel AS level,
+		r.parisdefault AS isdefault,
+		e.reloid IS NOT NULL AS isexternal,
+		coalesce(pg_get_expr(r.parrangestart, r.parchildrelid), '') AS rangestart,
+		r.parrangestartincl AS rangestartinclusive,
+		coalesce(pg_get_expr(r.parrangeend, r.parchildrelid), '') AS rangeend,
+		r.parrangeendincl AS rangeendinclusive,
+		coalesce(pg_get_expr(r.parlistvalues, r.parchildrelid), '') AS listvalues,
+		coalesce(quote_ident(t.spcname), '') AS tablespacename,
+		coalesce(array_to_string(cc.reloptions, ', '), '') AS storageopts
+	FROM pg_partition p
+		JOIN pg_partition_rule r ON p.oid = r.paroid
+		JOIN pg_class c ON p.parrelid = c.oid
+		JOIN pg_namespace n ON c.relnamespace = n.oid
+		JOIN pg_class cc ON r.parchildrelid = cc.oid
+		JOIN pg_namespace cn ON cc.relnamespace = cn.oid
+		LEFT JOIN pg_partition_rule pr ON r.parparentrule = pr.oid
+		JOIN pg_class pc ON pc.oid = coalesce(pr.parchildrelid, p.parrelid)
+		JOIN pg_namespace pn ON pc.relnamespace = pn.oid
+		LEFT JOIN pg_tablespace t ON t.oid = cc.reltablespace
+		LEFT JOIN pg_exttable e ON e.reloid = cc.oid
+	WHERE p.paristemplate = false
+		AND %s
+	ORDER BY p.parrelid, p.parlevel, r.parparentrule, r.parruleord`, relationAndSchemaFilterClause())
+	var results []struct {
+		RootOid uint32
+		PartitionChild
+	}
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+
+	encodingQuery := `
+	SELECT e.attrelid AS oid,
+		quote_ident(a.attname) AS name,
+		array_to_string(e.attoptions, ',') AS encoding
+	FROM pg_attribute_encoding e
+		JOIN pg_attribute a ON e.attrelid = a.attrelid AND e.attnum = a.attnum
+		JOIN pg_partition_rule r ON r.parchildrelid = e.attrelid`
+	var encodings []struct {
+		Oid      uint32
+		Name     string
+		Encoding string
+	}
+	err = connectionPool.Select(&encodings, encodingQuery)
+	gplog.FatalOnError(err)
+	encodingMap := make(map[uint32]map[string]string)
+	for _, encoding := range encodings {
+		if encodingMap[encoding.Oid] == nil {
+			encodingMap[encoding.Oid] = make(map[string]string)
+		}
+		encodingMap[encoding.Oid][encoding.Name] = encoding.Encoding
+	}
+
+	partitionChildMap := make(map[uint32][]PartitionChild)
+	for _, result := range results {
+		result.ColumnEncodings = encodingMap[result.Oid]
+		partitionChildMap[result.RootOid] = append(partitionChildMap[result.RootOid], result.PartitionChild)
+	}
+	return partitionChildMap
+}
@@ -0,0 +1,150 @@
+package backup_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/pflag"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/*
+ * Each catalog query takes queryLatency to run and returns a row for each of
+ * 20,000 tables, so the benchmark measures how much of the query time the
+ * connections opened for --jobs overlap.  The queries scan into different
+ * structs, so the rows have no columns that any of them would reject.
+ */
+func benchmarkConstructDefinitionsForTables(b *testing.B, numConns int) {
+	defer backup.SetSynchronizedSnapshot("")
+	const numTables = 20000
+	const numQueries = 17
+	const queryLatency = 50 * time.Millisecond
+	_, _, _ = testhelper.SetupTestLogger()
+	backup.SetCmdFlags(pflag.NewFlagSet("gpbackup", pflag.ExitOnError))
+	tableRelations := make([]backup.Relation, numTables)
+	for i := range tableRelations {
+		tableRelations[i] = backup.Relation{Oid: uint32(i + 1), Schema: "public", Name: fmt.Sprintf("table_%d", i+1)}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		pool := &dbconn.DBConn{ConnPool: make([]*sqlx.DB, numConns), Tx: make([]*sqlx.Tx, numConns),
+			NumConns: numConns, Version: dbconn.NewVersion("6.0.0")}
+		for connNum := range pool.ConnPool {
+			db, mock := testhelper.CreateMockDB()
+			// Any connection may run any of the queries
+			for query := 0; query < numQueries; query++ {
+				rows := sqlmock.NewRows([]string{})
+				for table := 0; table < numTables; table++ {
+					rows.AddRow()
+				}
+				mock.ExpectQuery("SELECT").WillDelayFor(queryLatency).WillReturnRows(rows)
+			}
+			pool.ConnPool[connNum] = db
+		}
+		backup.SetConnection(pool)
+		// The connections share a snapshot, as they do in GPDB 7+, so that the queries run in parallel
+		backup.SetSynchronizedSnapshot("00000003-00000002-1")
+		b.StartTimer()
+
+		backup.ConstructDefinitionsForTables(pool, tableRelations)
+	}
+}
+
+func BenchmarkConstructDefinitionsForTables1Job(b *testing.B) {
+	benchmarkConstructDefinitionsForTables(b, 1)
+}
+
+func BenchmarkConstructDefinitionsForTables4Jobs(b *testing.B) {
+	benchmarkConstructDefinitionsForTables(b, 4)
+}
+
+/*
+ * Each column is scanned into the map of columns by table as its row is read,
+ * so the bytes allocated per run grow with the columns returned but not with
+ * an intermediate slice of every row.
+ */
+func BenchmarkGetColumnDefinitions(b *testing.B) {
+	const numTables = 1000
+	const columnsPerTable = 100
+	_, _, _ = testhelper.SetupTestLogger()
+	backup.SetCmdFlags(pflag.NewFlagSet("gpbackup", pflag.ExitOnError))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, mock := testhelper.CreateMockDB()
+		pool := &dbconn.DBConn{ConnPool: []*sqlx.DB{db}, Tx: make([]*sqlx.Tx, 1), NumConns: 1, Version: dbconn.NewVersion("6.0.0")}
+		backup.SetConnection(pool)
+		rows := sqlmock.NewRows([]string{"attrelid", "attnum", "name", "type", "privileges", "kind"})
+		for table := 1; table <= numTables; table++ {
+			for column := 1; column <= columnsPerTable; column++ {
+				rows.AddRow(table, column, fmt.Sprintf("column_%d", column), "integer", nil, "")
+			}
+		}
+		mock.ExpectQuery("SELECT").WillReturnRows(rows)
+		b.StartTimer()
+
+		backup.GetColumnDefinitions(pool)
+	}
+}
+
+var _ = Describe("backup/queries_table_defs tests", func() {
+	Describe("GetTableInheritance", func() {
+		header := []string{"oid", "referencedobject"}
+		It("runs one query without an oid list when tables are not filtered", func() {
+			mock.ExpectQuery(`ORDER BY i.inhrelid, i.inhseqno$`).WillReturnRows(sqlmock.NewRows(header).AddRow(2, "public.parent"))
+			inheritance := backup.GetTableInheritance(connectionPool, []backup.Relation{{Oid: 2}})
+			Expect(inheritance).To(Equal(map[uint32][]string{2: {"public.parent"}}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("queries the included tables in batches and merges the results", func() {
+			_ = cmdFlags.Set(options.INCLUDE_RELATION, "public.child")
+			tables := make([]backup.Relation, 10001)
+			for i := range tables {
+				tables[i] = backup.Relation{Oid: uint32(i + 1)}
+			}
+			mock.ExpectQuery(`AND i.inhrelid IN \(1,2,.*,10000\)`).
+				WillReturnRows(sqlmock.NewRows(header).AddRow(1, "public.parent").AddRow(10000, "public.parent"))
+			mock.ExpectQuery(`AND i.inhrelid IN \(10001\)`).
+				WillReturnRows(sqlmock.NewRows(header).AddRow(10001, "public.parent").AddRow(10001, "public.other_parent"))
+
+			inheritance := backup.GetTableInheritance(connectionPool, tables)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(inheritance).To(Equal(map[uint32][]string{
+				1:     {"public.parent"},
+				10000: {"public.parent"},
+				10001: {"public.parent", "public.other_parent"},
+			}))
+		})
+	})
+	Describe("GetColumnDefinitions", func() {
+		It("collects the ACL entries of a column from its rows", func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			header := []string{"attrelid", "attnum", "name", "type", "privileges", "kind"}
+			rows := sqlmock.NewRows(header).
+				AddRow(1, 1, "id", "integer", nil, "").
+				AddRow(1, 2, "ssn", "text", "reader=r/testrole", "").
+				AddRow(1, 2, "ssn", "text", "auditor=r*/testrole", "")
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(rows)
+
+			columns := backup.GetColumnDefinitions(connectionPool)[1]
+
+			Expect(columns).To(HaveLen(2))
+			Expect(columns[0].ACL).To(BeEmpty())
+			Expect(columns[1].ACL).To(Equal([]backup.ACL{
+				{Grantee: "auditor", Grantor: "testrole", SelectWithGrant: true},
+				{Grantee: "reader", Grantor: "testrole", Select: true},
+			}))
+		})
+	})
+})
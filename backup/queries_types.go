@@ -7,6 +7,7 @@ package backup
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
@@ -14,6 +15,21 @@ import (
 	"github.com/greenplum-db/gpbackup/utils"
 )
 
+/*
+ * Types from excluded schemas that tables in the backup use are backed up
+ * along with the tables when --include-type-dependencies is set.
+ */
+func typeSchemaFilterClause(namespace string, typeAlias string) string {
+	if len(includedTypeDependencies) == 0 {
+		return SchemaFilterClause(namespace)
+	}
+	oidStrs := make([]string, len(includedTypeDependencies))
+	for i, dependency := range includedTypeDependencies {
+		oidStrs[i] = fmt.Sprintf("%d", dependency.Oid)
+	}
+	return fmt.Sprintf("((%s) OR %s.oid IN (%s))", SchemaFilterClause(namespace), typeAlias, strings.Join(oidStrs, ", "))
+}
+
 func GetTypeMetadataEntry(schema string, name string) (string, toc.MetadataEntry) {
 	return "predata",
 		toc.MetadataEntry{
@@ -67,7 +83,7 @@ func GetBaseTypes(connectionPool *dbconn.DBConn) []BaseType {
 	version4query := fmt.Sprintf(`
 	SELECT t.oid,
 		quote_ident(n.nspname) AS schema,
-		quote_ident(t.typname) AS name,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
 		t.typinput AS input,
 		t.typoutput AS output,
 		t.typreceive AS receive,
@@ -102,7 +118,7 @@ func GetBaseTypes(connectionPool *dbconn.DBConn) []BaseType {
 	version5query := fmt.Sprintf(`
 	SELECT t.oid,
 		quote_ident(n.nspname) AS schema,
-		quote_ident(t.typname) AS name,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
 		t.typinput AS input,
 		t.typoutput AS output,
 		CASE WHEN t.typreceive = '-'::regproc THEN '' ELSE t.typreceive::regproc::text END AS receive,
@@ -134,7 +150,7 @@ func GetBaseTypes(connectionPool *dbconn.DBConn) []BaseType {
 	masterQuery := fmt.Sprintf(`
 	SELECT t.oid,
 		quote_ident(n.nspname) AS schema,
-		quote_ident(t.typname) AS name,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
 		t.typinput AS input,
 		t.typoutput AS output,
 		CASE WHEN t.typreceive = '-'::regproc THEN '' ELSE t.typreceive::regproc::text END AS receive,
@@ -228,7 +244,7 @@ func GetCompositeTypes(connectionPool *dbconn.DBConn) []CompositeType {
 	WHERE %s
 		AND t.typtype = 'c'
 		AND c.relkind = 'c'
-		AND %s`, SchemaFilterClause("n"), ExtensionFilterClause("t"))
+		AND %s`, typeSchemaFilterClause("n", "t"), ExtensionFilterClause("t"))
 
 	compTypes := make([]CompositeType, 0)
 	err := connectionPool.Select(&compTypes, query)
@@ -265,7 +281,7 @@ func getCompositeTypeAttributes(connectionPool *dbconn.DBConn) map[uint32][]Attr
 		LEFT JOIN pg_description d ON (d.objoid = a.attrelid AND d.classoid = 'pg_class'::regclass AND d.objsubid = a.attnum)
 	WHERE t.typtype = 'c'
 		AND c.relkind = 'c'
-	ORDER BY t.oid, a.attnum`, SchemaFilterClause("n"))
+	ORDER BY t.oid, a.attnum`, typeSchemaFilterClause("n", "t"))
 
 	if connectionPool.Version.AtLeast("6") {
 		compositeAttributeQuery = fmt.Sprintf(`
@@ -287,7 +303,7 @@ func getCompositeTypeAttributes(connectionPool *dbconn.DBConn) map[uint32][]Attr
 			LEFT JOIN pg_namespace cn ON coll.collnamespace = cn.oid
 		WHERE t.typtype = 'c'
 			AND c.relkind = 'c'
-		ORDER BY t.oid, a.attnum`, SchemaFilterClause("n"))
+		ORDER BY t.oid, a.attnum`, typeSchemaFilterClause("n", "t"))
 	}
 
 	results := make([]Attribute, 0)
@@ -338,7 +354,7 @@ func GetDomainTypes(connectionPool *dbconn.DBConn) []Domain {
 	before6query := fmt.Sprintf(`
 	SELECT t.oid,
 		quote_ident(n.nspname) AS schema,
-		quote_ident(t.typname) AS name,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
 		coalesce(t.typdefault, '') AS defaultval,
 		format_type(t.typbasetype, t.typtypmod) AS basetype,
 		t.typnotnull AS notnull
@@ -347,12 +363,12 @@ func GetDomainTypes(connectionPool *dbconn.DBConn) []Domain {
 	WHERE %s
 		AND t.typtype = 'd'
 		AND %s
-	ORDER BY n.nspname, t.typname`, SchemaFilterClause("n"), ExtensionFilterClause("t"))
+	ORDER BY n.nspname, t.typname`, typeSchemaFilterClause("n", "t"), ExtensionFilterClause("t"))
 
 	masterQuery := fmt.Sprintf(`
 	SELECT t.oid,
 		quote_ident(n.nspname) AS schema,
-		quote_ident(t.typname) AS name,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
 		coalesce(t.typdefault, '') AS defaultval,
 		CASE
 			WHEN t.typcollation <> u.typcollation
@@ -369,7 +385,7 @@ func GetDomainTypes(connectionPool *dbconn.DBConn) []Domain {
 	WHERE %s
 		AND t.typtype = 'd'
 		AND %s
-	ORDER BY n.nspname, t.typname`, SchemaFilterClause("n"), ExtensionFilterClause("t"))
+	ORDER BY n.nspname, t.typname`, typeSchemaFilterClause("n", "t"), ExtensionFilterClause("t"))
 	var err error
 
 	if connectionPool.Version.Before("6") {
@@ -401,6 +417,12 @@ func (t EnumType) FQN() string {
 	return utils.MakeFQN(t.Schema, t.Name)
 }
 
+/*
+ * Labels added with ALTER TYPE ... ADD VALUE BEFORE/AFTER get non-contiguous
+ * enumsortorder values, so we aggregate labels in enumsortorder rather than
+ * creation order to ensure the restored enum sorts identically.  GPDB 5 has
+ * no enumsortorder and does not support adding values, so oid order suffices.
+ */
 func GetEnumTypes(connectionPool *dbconn.DBConn) []EnumType {
 	enumSortClause := "ORDER BY e.enumsortorder"
 	if connectionPool.Version.Is("5") {
@@ -409,7 +431,7 @@ func GetEnumTypes(connectionPool *dbconn.DBConn) []EnumType {
 	query := fmt.Sprintf(`
 	SELECT t.oid,
 		quote_ident(n.nspname) AS schema,
-		quote_ident(t.typname) AS name,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
 		enumlabels
 	FROM pg_type t
 		LEFT JOIN pg_namespace n ON t.typnamespace = n.oid
@@ -418,7 +440,7 @@ func GetEnumTypes(connectionPool *dbconn.DBConn) []EnumType {
 	WHERE %s
 		AND t.typtype = 'e'
 		AND %s
-	ORDER BY n.nspname, t.typname`, enumSortClause, SchemaFilterClause("n"), ExtensionFilterClause("t"))
+	ORDER BY n.nspname, t.typname`, enumSortClause, typeSchemaFilterClause("n", "t"), ExtensionFilterClause("t"))
 
 	results := make([]EnumType, 0)
 	err := connectionPool.Select(&results, query)
@@ -454,7 +476,7 @@ func GetRangeTypes(connectionPool *dbconn.DBConn) []RangeType {
 	query := fmt.Sprintf(`
 	SELECT t.oid,
 		quote_ident(n.nspname) AS schema,
-		quote_ident(t.typname) AS name,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
 		format_type(st.oid, st.typtypmod) AS subtype,
 		CASE
 			WHEN c.collname IS NULL THEN ''
@@ -571,3 +593,129 @@ func GetCollations(connectionPool *dbconn.DBConn) []Collation {
 	gplog.FatalOnError(err)
 	return results
 }
+
+/*
+ * A type or operator class in a schema that is not backed up, used by Dependent,
+ * which is a table or a type backed up with --include-type-dependencies.  Name
+ * is schema-qualified, while Schema is unquoted so that it can be added to the
+ * schema filter.
+ */
+type ExcludedSchemaDependency struct {
+	Dependent  string
+	ObjectType string
+	Oid        uint32
+	Schema     string
+	Name       string
+	TypType    string
+}
+
+/*
+ * Only composite, domain, and enum types can be backed up apart from their
+ * schema, as base types, range types, and operator classes need functions
+ * from the schema as well.
+ */
+func (d ExcludedSchemaDependency) CanBeIncluded() bool {
+	return d.ObjectType == "TYPE" && (d.TypType == "c" || d.TypType == "d" || d.TypType == "e")
+}
+
+/*
+ * Matches user schemas that are not in the backup.  The element type is used
+ * for array types, which always share the namespace of their element type.
+ */
+func excludedSchemaTypeJoins(typeOid string) string {
+	return fmt.Sprintf(`JOIN pg_type at ON at.oid = %s
+		JOIN pg_type t ON t.oid = CASE WHEN at.typelem <> 0 AND at.typlen = -1 THEN at.typelem ELSE at.oid END
+		JOIN pg_namespace tn ON tn.oid = t.typnamespace`, typeOid)
+}
+
+func excludedSchemaClause(namespace string) string {
+	return fmt.Sprintf(`%s.nspname NOT LIKE 'pg_temp_%%' AND %s.nspname NOT LIKE 'pg_toast%%' AND %s.nspname NOT IN ('gp_toolkit', 'information_schema', 'pg_aoseg', 'pg_bitmapindex', 'pg_catalog')
+		AND NOT (%s)`, namespace, namespace, namespace, SchemaFilterClause(namespace))
+}
+
+/*
+ * Finds the types used by the columns of tables in the backup, and the
+ * operator classes used by their indexes, that are defined in schemas that
+ * are not in the backup.
+ */
+func GetTableExcludedSchemaDependencies(connectionPool *dbconn.DBConn) []ExcludedSchemaDependency {
+	gplog.Verbose("Getting types from excluded schemas used by tables")
+	query := fmt.Sprintf(`
+	SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS dependent,
+		'TYPE' AS objecttype,
+		t.oid,
+		tn.nspname AS schema,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
+		t.typtype
+	FROM pg_depend d
+		JOIN pg_class c ON c.oid = d.objid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		%s
+	WHERE d.classid = 'pg_class'::regclass
+		AND d.refclassid = 'pg_type'::regclass
+		AND d.deptype = 'n'
+		AND c.relkind IN ('r', 'p')
+		AND %s
+		AND %s
+		AND %s
+	UNION
+	SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS dependent,
+		'OPERATOR CLASS' AS objecttype,
+		oc.oid,
+		ocn.nspname AS schema,
+		quote_ident(ocn.nspname) || '.' || quote_ident(oc.opcname) AS name,
+		'' AS typtype
+	FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_depend d ON d.objid = i.indexrelid
+			AND d.classid = 'pg_class'::regclass
+			AND d.refclassid = 'pg_opclass'::regclass
+		JOIN pg_opclass oc ON oc.oid = d.refobjid
+		JOIN pg_namespace ocn ON ocn.oid = oc.opcnamespace
+	WHERE %s
+		AND %s
+	ORDER BY dependent, schema, name`, excludedSchemaTypeJoins("d.refobjid"), relationAndSchemaFilterClause(), excludedSchemaClause("tn"),
+		ExtensionFilterClause("t"), relationAndSchemaFilterClause(), excludedSchemaClause("ocn"))
+
+	results := make([]ExcludedSchemaDependency, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	return results
+}
+
+/*
+ * Finds the types from schemas that are not in the backup that the given
+ * composite and domain types use, so that types included for a table bring
+ * along the types they are built on.
+ */
+func GetTypeExcludedSchemaDependencies(connectionPool *dbconn.DBConn, typeOids []uint32) []ExcludedSchemaDependency {
+	oidStrs := make([]string, len(typeOids))
+	for i, oid := range typeOids {
+		oidStrs[i] = fmt.Sprintf("%d", oid)
+	}
+	query := fmt.Sprintf(`
+	SELECT DISTINCT quote_ident(sn.nspname) || '.' || quote_ident(s.typname) AS dependent,
+		'TYPE' AS objecttype,
+		t.oid,
+		tn.nspname AS schema,
+		quote_ident(tn.nspname) || '.' || quote_ident(t.typname) AS name,
+		t.typtype
+	FROM pg_type s
+		JOIN pg_namespace sn ON sn.oid = s.typnamespace
+		JOIN pg_depend d ON (d.classid = 'pg_type'::regclass AND d.objid = s.oid)
+			OR (d.classid = 'pg_class'::regclass AND d.objid = s.typrelid)
+		%s
+	WHERE s.oid IN (%s)
+		AND d.refclassid = 'pg_type'::regclass
+		AND d.deptype = 'n'
+		AND %s
+		AND %s
+	ORDER BY dependent, schema, name`, excludedSchemaTypeJoins("d.refobjid"), strings.Join(oidStrs, ", "),
+		excludedSchemaClause("tn"), ExtensionFilterClause("t"))
+
+	results := make([]ExcludedSchemaDependency, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	return results
+}
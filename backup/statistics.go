@@ -9,11 +9,33 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/lib/pq"
 )
 
+/*
+ * The statistics of the tables whose metadata is backed up, and with
+ * --leaf-partition-data also those of the leaf partitions, since the leaves
+ * are then restored individually and the planner would otherwise have no
+ * statistics for them until they are analyzed.  External leaf partitions
+ * have no statistics of their own.
+ */
+func GetStatisticsTables(metadataTables []Table, dataTables []Table) []Table {
+	if !MustGetFlagBool(options.LEAF_PARTITION_DATA) {
+		return metadataTables
+	}
+	tables := make([]Table, 0, len(metadataTables)+len(dataTables))
+	tables = append(tables, metadataTables...)
+	for _, table := range dataTables {
+		if table.PartitionLevelInfo.Level == "l" && !table.IsExternal {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
 func PrintStatisticsStatements(statisticsFile *utils.FileWithByteCount, tocfile *toc.TOC, tables []Table, attStats map[uint32][]AttributeStatistic, tupleStats map[uint32]TupleStatistic) {
 	for _, table := range tables {
 		tupleQuery := GenerateTupleStatisticsQuery(table, tupleStats[table.Oid])
@@ -3,7 +3,9 @@ package backup_test
 import (
 	"fmt"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/lib/pq"
 
@@ -133,6 +135,43 @@ fmt.Sprintf(`INSERT INTO pg_statistic VALUES (
 			testutils.AssertBufferContents(tocfile.StatisticsEntries, buffer, expected...)
 		})
 	})
+	Describe("GetStatisticsTables", func() {
+		root := backup.Table{Relation: backup.Relation{Oid: 1, Schema: "public", Name: "sales"},
+			TableDefinition: backup.TableDefinition{PartitionLevelInfo: backup.PartitionLevelInfo{Level: "p"},
+				PartitionAlteredSchemas: []backup.AlteredPartitionRelation{{OldSchema: "public", NewSchema: "archive", Name: "sales_1_prt_2"}}}}
+		leaf := backup.Table{Relation: backup.Relation{Oid: 2, Schema: "public", Name: "sales_1_prt_1"},
+			TableDefinition: backup.TableDefinition{PartitionLevelInfo: backup.PartitionLevelInfo{Level: "l", RootName: "sales"}}}
+		movedLeaf := backup.Table{Relation: backup.Relation{Oid: 3, Schema: "archive", Name: "sales_1_prt_2"},
+			TableDefinition: backup.TableDefinition{PartitionLevelInfo: backup.PartitionLevelInfo{Level: "l", RootName: "sales"}}}
+		externalLeaf := backup.Table{Relation: backup.Relation{Oid: 4, Schema: "public", Name: "sales_1_prt_3"},
+			TableDefinition: backup.TableDefinition{PartitionLevelInfo: backup.PartitionLevelInfo{Level: "l", RootName: "sales"}, IsExternal: true}}
+		plain := backup.Table{Relation: backup.Relation{Oid: 5, Schema: "public", Name: "plain"}}
+		metadataTables := []backup.Table{root, plain}
+		dataTables := []backup.Table{leaf, movedLeaf, externalLeaf, plain}
+
+		It("returns the tables whose metadata is backed up", func() {
+			Expect(backup.GetStatisticsTables(metadataTables, dataTables)).To(Equal(metadataTables))
+		})
+		It("adds the leaf partitions with --leaf-partition-data", func() {
+			_ = cmdFlags.Set(options.LEAF_PARTITION_DATA, "true")
+			Expect(backup.GetStatisticsTables(metadataTables, dataTables)).To(Equal([]backup.Table{root, plain, leaf, movedLeaf}))
+		})
+		It("keys the statistics of each leaf partition by the leaf", func() {
+			_ = cmdFlags.Set(options.LEAF_PARTITION_DATA, "true")
+			tocfile, backupfile = testutils.InitializeTestTOC(buffer, "statistics")
+			tables := backup.GetStatisticsTables(metadataTables, dataTables)
+			backup.PrintStatisticsStatements(backupfile, tocfile, tables, map[uint32][]backup.AttributeStatistic{}, map[uint32]backup.TupleStatistic{})
+			testutils.ExpectEntry(tocfile.StatisticsEntries, 2, "public", "", "sales_1_prt_1", "STATISTICS")
+			testutils.ExpectEntry(tocfile.StatisticsEntries, 3, "archive", "", "sales_1_prt_2", "STATISTICS")
+			Expect(string(buffer.Contents())).To(ContainSubstring("WHERE oid = 'archive.sales_1_prt_2'::regclass::oid;"))
+		})
+		It("gathers statistics for leaf partitions moved out of the included schemas", func() {
+			_ = cmdFlags.Set(options.INCLUDE_SCHEMA, "public")
+			mock.ExpectQuery(`n.nspname IN \(('public','archive'|'archive','public')\)`).WillReturnRows(sqlmock.NewRows([]string{"oid"}))
+			backup.GetTupleStatistics(connectionPool, []backup.Table{root, movedLeaf})
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
 	Describe("GenerateTupleStatisticsQuery", func() {
 		It("generates tuple statistics query with double quotes and a single quote in the table name and schema name", func() {
 			tableTestTable := backup.Table{Relation: backup.Relation{Schema: `"""test'schema"""`, Name: `"""test'table"""`}}
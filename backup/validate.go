@@ -2,12 +2,16 @@ package backup
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
@@ -17,6 +21,58 @@ import (
  * This file contains functions related to validating user input.
  */
 
+/*
+ * Toast tables, append-optimized auxiliary tables, and catalog tables are
+ * backed up with the tables they belong to, if at all, so filtering on one
+ * would produce a partial backup.  They are ignored, with a single warning.
+ */
+func removeSystemRelationsFromFilters(opts *options.Options) {
+	removed, err := opts.RemoveSystemRelations(cmdFlags)
+	gplog.FatalOnError(err)
+	if len(removed) > 0 {
+		report.Warn(report.WARN_SYSTEM_RELATION_FILTER, "", "Ignoring the following system or auxiliary relation(s) in the filter lists: %s", strings.Join(removed, ", "))
+	}
+}
+
+/*
+ * Restoring objects into a system schema requires superuser privileges, which
+ * the restore would otherwise only discover partway through.
+ */
+func warnIncludedSystemSchemas() {
+	for _, schema := range MustGetFlagStringArray(options.INCLUDE_SYSTEM_SCHEMA) {
+		report.Warn(report.WARN_SYSTEM_SCHEMA_INCLUDED, schema, "Backing up user objects in system schema %s; restoring them will require superuser privileges", schema)
+	}
+}
+
+/*
+ * The tables returned by --include-table-query are added to --include-table,
+ * so that they are filtered on as if they had been listed there.  Returns
+ * false if the query returned no tables, in which case there is nothing to
+ * back up.
+ */
+func resolveIncludeTableQuery() bool {
+	query := MustGetFlagString(options.INCLUDE_TABLE_QUERY)
+	if query == "" {
+		return true
+	}
+	tables, err := GetTablesFromIncludeQuery(connectionPool, query)
+	gplog.FatalOnError(err)
+	if len(tables) == 0 {
+		if MustGetFlagBool(options.STRICT_INCLUDE) {
+			gplog.Fatal(errors.Errorf("The --%s query returned no tables", options.INCLUDE_TABLE_QUERY), "")
+		}
+		report.Warn(report.WARN_EMPTY_INCLUDE_QUERY, "", "The --%s query returned no tables, so no backup will be taken", options.INCLUDE_TABLE_QUERY)
+		return false
+	}
+	gplog.Info("Including %d table(s) returned by the --%s query", len(tables), options.INCLUDE_TABLE_QUERY)
+	gplog.Verbose("Tables returned by the --%s query: %s", options.INCLUDE_TABLE_QUERY, strings.Join(tables, ", "))
+	for _, table := range tables {
+		err = cmdFlags.Set(options.INCLUDE_RELATION, table)
+		gplog.FatalOnError(err)
+	}
+	return true
+}
+
 func validateFilterLists(opts *options.Options) {
 	gplog.Verbose("Validating Tables and Schemas exist in Database")
 	ValidateTablesExist(connectionPool, opts.GetIncludedTables(), false)
@@ -38,7 +94,7 @@ func ValidateSchemasExist(connectionPool *dbconn.DBConn, schemaList []string, ex
 		for _, schema := range schemaList {
 			if !schemaSet.MatchesFilter(schema) {
 				if excludeSet {
-					gplog.Warn(`Excluded schema %s does not exist`, schema)
+					report.Warn(report.WARN_MISSING_FILTER_OBJECT, schema, `Excluded schema %s does not exist`, schema)
 				} else {
 					gplog.Fatal(nil, "Schema %s does not exist", schema)
 				}
@@ -60,19 +116,28 @@ func ValidateTablesExist(conn *dbconn.DBConn, tableList []string, excludeSet boo
 	query := fmt.Sprintf(`
 	SELECT
 		c.oid,
-		n.nspname || '.' || c.relname AS name
+		n.nspname || '.' || c.relname AS name,
+		c.relkind
 	FROM pg_namespace n
 	JOIN pg_class c ON n.oid = c.relnamespace
 	WHERE quote_ident(n.nspname) || '.' || quote_ident(c.relname) IN (%s)`, quotedTablesStr)
 	resultTables := make([]struct {
-		Oid  uint32
-		Name string
+		Oid     uint32
+		Name    string
+		Relkind string
 	}, 0)
 	err = conn.Select(&resultTables, query)
 	gplog.FatalOnError(err, fmt.Sprintf("Query was: %s", query))
 	tableMap := make(map[string]uint32)
+	auxiliaryTables := make([]string, 0)
 	for _, table := range resultTables {
 		tableMap[table.Name] = table.Oid
+		if isAuxiliaryRelkind(table.Relkind) {
+			auxiliaryTables = append(auxiliaryTables, table.Name)
+		}
+	}
+	if len(auxiliaryTables) > 0 {
+		gplog.Fatal(nil, "Cannot filter on toast or append-optimized auxiliary tables, which are backed up with the tables they belong to: %s", strings.Join(auxiliaryTables, ", "))
 	}
 
 	partTableMap := GetPartitionTableMap(conn)
@@ -80,7 +145,7 @@ func ValidateTablesExist(conn *dbconn.DBConn, tableList []string, excludeSet boo
 		tableOid := tableMap[table]
 		if tableOid == 0 {
 			if excludeSet {
-				gplog.Warn("Excluded table %s does not exist", table)
+				report.Warn(report.WARN_MISSING_FILTER_OBJECT, table, "Excluded table %s does not exist", table)
 			} else {
 				gplog.Fatal(nil, "Table %s does not exist", table)
 			}
@@ -91,22 +156,164 @@ func ValidateTablesExist(conn *dbconn.DBConn, tableList []string, excludeSet boo
 	}
 }
 
+// Toast tables and the segment, block directory, and visibility map tables of append-optimized tables
+func isAuxiliaryRelkind(relkind string) bool {
+	switch relkind {
+	case "t", "o", "b", "M":
+		return true
+	}
+	return false
+}
+
+/*
+ * pg_get_constraintdef qualifies the referenced table with its schema whenever
+ * the schema is not in the search_path, which during backup is only pg_catalog.
+ */
+var foreignKeyTargetRegex = regexp.MustCompile(`REFERENCES ((?:"(?:[^"]|"")+"|[^\s."(]+)\.(?:"(?:[^"]|"")+"|[^\s."(]+))`)
+
+func GetDanglingForeignKeys(constraints []Constraint, tables []Table) []string {
+	tableSet := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		tableSet[table.FQN()] = true
+	}
+	dangling := make([]string, 0)
+	for _, constraint := range constraints {
+		if constraint.ConType != "f" {
+			continue
+		}
+		match := foreignKeyTargetRegex.FindStringSubmatch(constraint.ConDef.String)
+		if match == nil || tableSet[match[1]] {
+			continue
+		}
+		dangling = append(dangling, fmt.Sprintf("%s on table %s references table %s", constraint.Name, constraint.OwningObject, match[1]))
+	}
+	return dangling
+}
+
+func ValidateForeignKeyTargets(constraints []Constraint, tables []Table) {
+	dangling := GetDanglingForeignKeys(constraints, tables)
+	if len(dangling) == 0 {
+		return
+	}
+	for _, foreignKey := range dangling {
+		report.Warn(report.WARN_DANGLING_FOREIGN_KEY, "", "Foreign key %s, which is not in the backup set", foreignKey)
+	}
+	if MustGetFlagBool(options.STRICT) {
+		gplog.Fatal(errors.Errorf("%d foreign key(s) reference tables that are not in the backup set; "+
+			"include the referenced tables or run without --%s", len(dangling), options.STRICT), "")
+	}
+	gplog.Warn("Restoring this backup will fail to create the foreign key(s) above unless the referenced tables already exist")
+}
+
+/*
+ * A table whose columns or indexes use a type or operator class from a schema
+ * that is not in the backup cannot be restored.  With --include-type-dependencies
+ * the composite, domain, and enum types are backed up along with the types they
+ * are built on, and anything else still fails the backup.
+ */
+func ValidateTypeDependencies() {
+	if len(MustGetFlagStringArray(options.INCLUDE_SCHEMA)) == 0 && len(MustGetFlagStringArray(options.EXCLUDE_SCHEMA)) == 0 {
+		return
+	}
+	includeTypes := MustGetFlagBool(options.INCLUDE_TYPE_DEPS)
+	includedOids := make(map[uint32]bool)
+	missing := make([]string, 0)
+	dependencies := GetTableExcludedSchemaDependencies(connectionPool)
+	for len(dependencies) > 0 {
+		newOids := make([]uint32, 0)
+		for _, dependency := range dependencies {
+			if includeTypes && dependency.CanBeIncluded() {
+				if !includedOids[dependency.Oid] {
+					gplog.Info("Including type %s, which is used by %s", dependency.Name, dependency.Dependent)
+					includedOids[dependency.Oid] = true
+					includedTypeDependencies = append(includedTypeDependencies, dependency)
+					newOids = append(newOids, dependency.Oid)
+				}
+				continue
+			}
+			missing = append(missing, fmt.Sprintf("%s uses %s %s", dependency.Dependent, strings.ToLower(dependency.ObjectType), dependency.Name))
+		}
+		dependencies = nil
+		if len(newOids) > 0 {
+			dependencies = GetTypeExcludedSchemaDependencies(connectionPool, newOids)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	hint := fmt.Sprintf(", or use --%s to back up the composite, domain, and enum types", options.INCLUDE_TYPE_DEPS)
+	if includeTypes {
+		hint = ""
+	}
+	gplog.Fatal(errors.Errorf("Tables in the backup use objects from schemas that are not in the backup set and could not be restored:\n\t%s\nInclude the schemas of these objects%s.",
+		strings.Join(missing, "\n\t"), hint), "")
+}
+
+/*
+ * A backup given a --timestamp may run again after an attempt with that
+ * timestamp failed, but must never overwrite a backup that succeeded.  We
+ * check both the history and the backup's own config file, in case the
+ * history file has been replaced.  Returns the number of earlier attempts.
+ */
+func CheckBackupTimestamp(fpInfo filepath.FilePathInfo) int {
+	priorAttempts := 0
+	historyFilename := fpInfo.GetBackupHistoryFilePath()
+	if iohelper.FileExistsAndIsReadable(historyFilename) {
+		backupHistory, err := history.NewHistory(historyFilename)
+		gplog.FatalOnError(err)
+		if backupHistory.FindBackupConfig(fpInfo.Timestamp) != nil {
+			gplog.Fatal(errors.Errorf("A backup with timestamp %s already completed successfully. Specify a different --%s.",
+				fpInfo.Timestamp, options.TIMESTAMP), "")
+		}
+		priorAttempts = backupHistory.CountBackupAttempts(fpInfo.Timestamp)
+	}
+	configFilename := fpInfo.GetConfigFilePath()
+	if iohelper.FileExistsAndIsReadable(configFilename) && !history.ReadConfigFile(configFilename).Failed() {
+		gplog.Fatal(errors.Errorf("A backup with timestamp %s already completed successfully. Specify a different --%s.",
+			fpInfo.Timestamp, options.TIMESTAMP), "")
+	}
+	return priorAttempts
+}
+
 func validateFlagCombinations(flags *pflag.FlagSet) {
-	options.CheckExclusiveFlags(flags, options.DEBUG, options.QUIET, options.VERBOSE)
-	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.METADATA_ONLY, options.INCREMENTAL)
+	options.CheckExclusiveFlags(flags, options.DEBUG, options.QUIET, options.VERBOSE, options.SUMMARY_ONLY)
+	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.METADATA_ONLY, options.INCREMENTAL, options.CHANGED_SINCE)
 	options.CheckExclusiveFlags(flags, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE, options.INCLUDE_RELATION, options.INCLUDE_RELATION_FILE)
 	options.CheckExclusiveFlags(flags, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE)
 	options.CheckExclusiveFlags(flags, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.EXCLUDE_RELATION, options.INCLUDE_RELATION, options.EXCLUDE_RELATION_FILE, options.INCLUDE_RELATION_FILE)
 	options.CheckExclusiveFlags(flags, options.JOBS, options.METADATA_ONLY, options.SINGLE_DATA_FILE)
 	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.LEAF_PARTITION_DATA)
+	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.LEAF_PARTITION_DDL)
 	options.CheckExclusiveFlags(flags, options.NO_COMPRESSION, options.COMPRESSION_LEVEL)
 	options.CheckExclusiveFlags(flags, options.PLUGIN_CONFIG, options.BACKUP_DIR)
+	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.SKIP_INACCESSIBLE)
+	options.CheckExclusiveFlags(flags, options.CONTENT_ADDRESSED, options.METADATA_ONLY, options.SINGLE_DATA_FILE, options.PLUGIN_CONFIG)
+	options.CheckExclusiveFlags(flags, options.EXCLUDE_COLUMN_DATA, options.METADATA_ONLY)
+	options.CheckExclusiveFlags(flags, options.INCLUDE_TABLE_QUERY, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE, options.INCLUDE_RELATION, options.INCLUDE_RELATION_FILE)
+	options.CheckExclusiveFlags(flags, options.INCLUDE_TABLE_QUERY, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.EXCLUDE_RELATION, options.EXCLUDE_RELATION_FILE)
+	options.CheckExclusiveFlags(flags, options.INCLUDE_TABLE_REGEX, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.EXCLUDE_RELATION, options.EXCLUDE_RELATION_FILE, options.INCLUDE_TABLE_QUERY)
+	if !flags.Changed(options.INCLUDE_TABLE_REGEX) {
+		// Without an include pattern to filter, an exclude pattern excludes tables as --exclude-table does
+		options.CheckExclusiveFlags(flags, options.EXCLUDE_TABLE_REGEX, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.INCLUDE_RELATION, options.INCLUDE_RELATION_FILE, options.INCLUDE_TABLE_QUERY)
+	}
+	if MustGetFlagBool(options.METADATA_ONLY) && MustGetFlagString(options.DATA_FORMAT) == "binary" {
+		gplog.Fatal(errors.Errorf("--%s binary cannot be specified with --%s", options.DATA_FORMAT, options.METADATA_ONLY), "")
+	}
+	if MustGetFlagBool(options.NO_DATA_LOCKS) && !MustGetFlagBool(options.METADATA_ONLY) {
+		gplog.Fatal(errors.Errorf("--%s must be specified with --%s", options.NO_DATA_LOCKS, options.METADATA_ONLY), "")
+	}
+	if MustGetFlagBool(options.STRICT_INCLUDE) && MustGetFlagString(options.INCLUDE_TABLE_QUERY) == "" {
+		gplog.Fatal(errors.Errorf("--%s must be specified with --%s", options.STRICT_INCLUDE, options.INCLUDE_TABLE_QUERY), "")
+	}
 	if MustGetFlagString(options.FROM_TIMESTAMP) != "" && !MustGetFlagBool(options.INCREMENTAL) {
 		gplog.Fatal(errors.Errorf("--from-timestamp must be specified with --incremental"), "")
 	}
 	if MustGetFlagBool(options.INCREMENTAL) && !MustGetFlagBool(options.LEAF_PARTITION_DATA) {
 		gplog.Fatal(errors.Errorf("--leaf-partition-data must be specified with --incremental"), "")
 	}
+	if (flags.Changed(options.EXCLUDE_DATA) || flags.Changed(options.EXCLUDE_DATA_FILE)) && !MustGetFlagBool(options.LEAF_PARTITION_DATA) {
+		gplog.Fatal(errors.Errorf("--leaf-partition-data must be specified with --%s or --%s", options.EXCLUDE_DATA, options.EXCLUDE_DATA_FILE), "")
+	}
 }
 
 func validateFlagValues() {
@@ -114,12 +321,41 @@ func validateFlagValues() {
 	gplog.FatalOnError(err)
 	err = utils.ValidateFullPath(MustGetFlagString(options.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
+	err = utils.ValidateDirectoryMode(MustGetFlagString(options.BACKUP_DIR_MODE))
+	gplog.FatalOnError(err)
 	err = utils.ValidateCompressionLevel(MustGetFlagInt(options.COMPRESSION_LEVEL))
 	gplog.FatalOnError(err)
+	err = utils.ValidateKeepaliveSettings(MustGetFlagInt(options.KEEPALIVES_IDLE),
+		MustGetFlagInt(options.KEEPALIVES_INTERVAL), MustGetFlagInt(options.KEEPALIVES_COUNT))
+	gplog.FatalOnError(err)
+	includableSchemas := utils.NewSet(options.IncludableSystemSchemas)
+	for _, schema := range MustGetFlagStringArray(options.INCLUDE_SYSTEM_SCHEMA) {
+		if !includableSchemas.MatchesFilter(schema) {
+			gplog.Fatal(errors.Errorf("Invalid --%s value %s.  Valid values are %s.", options.INCLUDE_SYSTEM_SCHEMA, schema,
+				strings.Join(options.IncludableSystemSchemas, ", ")), "")
+		}
+	}
+	if format := MustGetFlagString(options.DATA_FORMAT); format != "csv" && format != "binary" {
+		gplog.Fatal(errors.Errorf("Invalid --%s value %s.  Valid values are csv and binary.", options.DATA_FORMAT, format), "")
+	}
+	if MustGetFlagInt(options.MAX_PER_HOST) < 0 {
+		gplog.Fatal(errors.Errorf("--%s must not be negative", options.MAX_PER_HOST), "")
+	}
+	if MustGetFlagInt(options.MAX_RETRIES) < 0 || MustGetFlagInt(options.RETRY_INTERVAL) < 0 {
+		gplog.Fatal(errors.Errorf("--%s and --%s must not be negative", options.MAX_RETRIES, options.RETRY_INTERVAL), "")
+	}
 	if MustGetFlagString(options.FROM_TIMESTAMP) != "" && !filepath.IsValidTimestamp(MustGetFlagString(options.FROM_TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
 			MustGetFlagString(options.FROM_TIMESTAMP)), "")
 	}
+	if MustGetFlagString(options.CHANGED_SINCE) != "" && !filepath.IsValidTimestamp(MustGetFlagString(options.CHANGED_SINCE)) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
+			MustGetFlagString(options.CHANGED_SINCE)), "")
+	}
+	if MustGetFlagString(options.TIMESTAMP) != "" && !filepath.IsValidTimestamp(MustGetFlagString(options.TIMESTAMP)) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.",
+			MustGetFlagString(options.TIMESTAMP)), "")
+	}
 }
 
 func validateFromTimestamp(fromTimestamp string) {
@@ -1,12 +1,22 @@
 package backup_test
 
 import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/spf13/pflag"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("backup/validate tests", func() {
@@ -148,6 +158,17 @@ var _ = Describe("backup/validate tests", func() {
 				filterList = []string{"public.table1"}
 				backup.ValidateTablesExist(connectionPool, filterList, false)
 			})
+			It("panics if given a toast or append-optimized auxiliary table", func() {
+				// Added to handle call to `quote_ident`
+				schemaAndTable.AddRow("public", "table1")
+				mock.ExpectQuery("SELECT (.*)").WillReturnRows(schemaAndTable)
+				//
+				auxiliaryRows := sqlmock.NewRows([]string{"oid", "name", "relkind"}).AddRow("1", "public.table1", "o")
+				mock.ExpectQuery("SELECT (.*)").WillReturnRows(auxiliaryRows)
+				filterList = []string{"public.table1"}
+				defer testhelper.ShouldPanicWithMessage("Cannot filter on toast or append-optimized auxiliary tables, which are backed up with the tables they belong to: public.table1")
+				backup.ValidateTablesExist(connectionPool, filterList, false)
+			})
 			It("panics if given an intermediate partition table and --leaf-partition-data is set", func() {
 				// Added to handle call to `quote_ident`
 				schemaAndTable.AddRow("public", "table1")
@@ -177,4 +198,125 @@ var _ = Describe("backup/validate tests", func() {
 			})
 		})
 	})
+	Describe("ValidateForeignKeyTargets", func() {
+		tables := []backup.Table{
+			{Relation: backup.Relation{Schema: "public", Name: "orders"}},
+			{Relation: backup.Relation{Schema: `"Mixed Case"`, Name: "customers"}},
+		}
+		inSetFK := backup.Constraint{Name: "orders_cust_fkey", ConType: "f", OwningObject: "public.orders",
+			ConDef: sql.NullString{String: `FOREIGN KEY (cust) REFERENCES "Mixed Case".customers(id)`, Valid: true}}
+		danglingFK := backup.Constraint{Name: "orders_item_fkey", ConType: "f", OwningObject: "public.orders",
+			ConDef: sql.NullString{String: `FOREIGN KEY (item) REFERENCES public.items(id) ON DELETE CASCADE`, Valid: true}}
+		quotedDanglingFK := backup.Constraint{Name: "orders_region_fkey", ConType: "f", OwningObject: "public.orders",
+			ConDef: sql.NullString{String: `FOREIGN KEY (region) REFERENCES sales."Region.List"(id)`, Valid: true}}
+		checkConstraint := backup.Constraint{Name: "orders_check", ConType: "c", OwningObject: "public.orders",
+			ConDef: sql.NullString{String: `CHECK (note <> 'REFERENCES public.items(id)')`, Valid: true}}
+
+		It("returns no foreign keys when every referenced table is in the backup set", func() {
+			Expect(backup.GetDanglingForeignKeys([]backup.Constraint{inSetFK, checkConstraint}, tables)).To(BeEmpty())
+		})
+		It("lists each foreign key that references a table outside the backup set", func() {
+			dangling := backup.GetDanglingForeignKeys([]backup.Constraint{inSetFK, danglingFK, quotedDanglingFK}, tables)
+			Expect(dangling).To(Equal([]string{
+				"orders_item_fkey on table public.orders references table public.items",
+				`orders_region_fkey on table public.orders references table sales."Region.List"`,
+			}))
+		})
+		It("warns about dangling foreign keys", func() {
+			_, _, logfile := testhelper.SetupTestLogger()
+			backup.ValidateForeignKeyTargets([]backup.Constraint{danglingFK}, tables)
+			testhelper.ExpectRegexp(logfile, "[WARNING]:-Foreign key orders_item_fkey on table public.orders references table public.items, which is not in the backup set")
+		})
+		It("panics on dangling foreign keys when --strict is set", func() {
+			_ = cmdFlags.Set(options.STRICT, "true")
+			defer testhelper.ShouldPanicWithMessage("1 foreign key(s) reference tables that are not in the backup set; include the referenced tables or run without --strict")
+			backup.ValidateForeignKeyTargets([]backup.Constraint{danglingFK}, tables)
+		})
+	})
+	Describe("ValidateTypeDependencies", func() {
+		header := []string{"dependent", "objecttype", "oid", "schema", "name", "typtype"}
+		BeforeEach(func() {
+			_ = cmdFlags.Set(options.INCLUDE_SCHEMA, "public")
+			backup.SetFilterRelationClause("")
+		})
+		AfterEach(func() {
+			backup.SetFilterRelationClause("")
+			backup.SetIncludedTypeDependencies(nil)
+		})
+		It("does nothing when no schemas are filtered", func() {
+			cmdFlags = pflag.NewFlagSet("gpbackup", pflag.ExitOnError)
+			backup.SetCmdFlags(cmdFlags)
+			backup.ValidateTypeDependencies()
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("passes when included tables only use types from included schemas", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(header))
+			backup.ValidateTypeDependencies()
+		})
+		It("panics listing each table and the type or operator class it uses", func() {
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(header).
+				AddRow("public.orders", "TYPE", 1, "other", "other.address", "c").
+				AddRow("public.orders", "OPERATOR CLASS", 2, "other", "other.custom_ops", ""))
+			defer testhelper.ShouldPanicWithMessage("Tables in the backup use objects from schemas that are not in the backup set and could not be restored:\n\tpublic.orders uses type other.address\n\tpublic.orders uses operator class other.custom_ops\nInclude the schemas of these objects, or use --include-type-dependencies to back up the composite, domain, and enum types.")
+			backup.ValidateTypeDependencies()
+		})
+		It("includes composite types and the types they use with --include-type-dependencies", func() {
+			_ = cmdFlags.Set(options.INCLUDE_TYPE_DEPS, "true")
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(header).
+				AddRow("public.orders", "TYPE", 1, "other", "other.address", "c").
+				AddRow("public.customers", "TYPE", 1, "other", "other.address", "c"))
+			mock.ExpectQuery(`WHERE s.oid IN \(1\)`).WillReturnRows(sqlmock.NewRows(header).
+				AddRow("other.address", "TYPE", 3, "other", "other.zipcode", "d"))
+			mock.ExpectQuery(`WHERE s.oid IN \(3\)`).WillReturnRows(sqlmock.NewRows(header))
+			backup.ValidateTypeDependencies()
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("panics on base types even with --include-type-dependencies", func() {
+			_ = cmdFlags.Set(options.INCLUDE_TYPE_DEPS, "true")
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(sqlmock.NewRows(header).
+				AddRow("public.orders", "TYPE", 1, "other", "other.money2", "b"))
+			defer testhelper.ShouldPanicWithMessage("Tables in the backup use objects from schemas that are not in the backup set and could not be restored:\n\tpublic.orders uses type other.money2\nInclude the schemas of these objects.")
+			backup.ValidateTypeDependencies()
+		})
+	})
+	Describe("CheckBackupTimestamp", func() {
+		var (
+			masterDataDir string
+			fpInfo        filepath.FilePathInfo
+		)
+		BeforeEach(func() {
+			var err error
+			masterDataDir, err = ioutil.TempDir("", "gpbackup_timestamp")
+			Expect(err).ToNot(HaveOccurred())
+			testCluster := cluster.NewCluster([]cluster.SegConfig{{ContentID: -1, DataDir: masterDataDir}})
+			fpInfo = filepath.NewFilePathInfo(testCluster, "", "20170101010101", "")
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(masterDataDir)
+		})
+		It("returns 0 for a timestamp that has never been used", func() {
+			Expect(backup.CheckBackupTimestamp(fpInfo)).To(Equal(0))
+		})
+		It("counts the earlier failed attempts with the timestamp", func() {
+			failedConfig := history.BackupConfig{Timestamp: "20170101010101", Status: history.BackupStatusFailed}
+			Expect(history.WriteBackupHistory(fpInfo.GetBackupHistoryFilePath(), &failedConfig)).To(Succeed())
+			Expect(history.WriteBackupHistory(fpInfo.GetBackupHistoryFilePath(), &failedConfig)).To(Succeed())
+			otherConfig := history.BackupConfig{Timestamp: "20170101010102", Status: history.BackupStatusFailed}
+			Expect(history.WriteBackupHistory(fpInfo.GetBackupHistoryFilePath(), &otherConfig)).To(Succeed())
+
+			Expect(backup.CheckBackupTimestamp(fpInfo)).To(Equal(2))
+		})
+		It("panics if a backup with the timestamp succeeded", func() {
+			succeededConfig := history.BackupConfig{Timestamp: "20170101010101", Status: history.BackupStatusSucceed}
+			Expect(history.WriteBackupHistory(fpInfo.GetBackupHistoryFilePath(), &succeededConfig)).To(Succeed())
+			defer testhelper.ShouldPanicWithMessage("A backup with timestamp 20170101010101 already completed successfully. Specify a different --timestamp.")
+			backup.CheckBackupTimestamp(fpInfo)
+		})
+		It("panics if the config file of a successful backup exists without a history entry", func() {
+			Expect(os.MkdirAll(path.Dir(fpInfo.GetConfigFilePath()), 0755)).To(Succeed())
+			history.WriteConfigFile(&history.BackupConfig{Timestamp: "20170101010101", Status: history.BackupStatusSucceed}, fpInfo.GetConfigFilePath())
+			defer testhelper.ShouldPanicWithMessage("A backup with timestamp 20170101010101 already completed successfully. Specify a different --timestamp.")
+			backup.CheckBackupTimestamp(fpInfo)
+		})
+	})
 })
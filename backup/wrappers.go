@@ -30,11 +30,17 @@ import (
 func SetLoggerVerbosity() {
 	if MustGetFlagBool(options.QUIET) {
 		gplog.SetVerbosity(gplog.LOGERROR)
+	} else if MustGetFlagBool(options.SUMMARY_ONLY) {
+		logCounter = report.EnableSummaryOnlyLogging("gpbackup")
 	} else if MustGetFlagBool(options.DEBUG) {
 		gplog.SetVerbosity(gplog.LOGDEBUG)
 	} else if MustGetFlagBool(options.VERBOSE) {
 		gplog.SetVerbosity(gplog.LOGVERBOSE)
 	}
+	if logCounter == nil {
+		// Warnings and errors are counted for the backup history
+		logCounter = report.EnableLogCounting("gpbackup")
+	}
 }
 
 func initializeConnectionPool(timestamp string) {
@@ -46,10 +52,28 @@ func initializeConnectionPool(timestamp string) {
 		connectionPool.MustExec(fmt.Sprintf("SET application_name TO 'gpbackup_%s'", timestamp), connNum)
 		// BEGIN TRANSACTION
 		connectionPool.MustBegin(connNum)
+		synchronizeSnapshot(connNum)
 		SetSessionGUCs(connNum)
 	}
 }
 
+/*
+ * Each connection's serializable snapshot is otherwise taken by the first
+ * query it runs, so the connections may see different catalogs.  GPDB 7 can
+ * export the snapshot of connection 0 for the other connections to import,
+ * which must be done before they run any other query.
+ */
+func synchronizeSnapshot(connNum int) {
+	if !connectionPool.Version.AtLeast("7") {
+		return
+	}
+	if connNum == 0 {
+		synchronizedSnapshot = dbconn.MustSelectString(connectionPool, "SELECT pg_catalog.pg_export_snapshot()", connNum)
+		return
+	}
+	connectionPool.MustExec(fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", synchronizedSnapshot), connNum)
+}
+
 func SetSessionGUCs(connNum int) {
 	// These GUCs ensure the dumps portability accross systems
 	connectionPool.MustExec("SET search_path TO pg_catalog", connNum)
@@ -57,6 +81,8 @@ func SetSessionGUCs(connNum int) {
 	connectionPool.MustExec("SET DATESTYLE = ISO", connNum)
 	connectionPool.MustExec("SET standard_conforming_strings = 1", connNum) // Needed for 4.3, default on in 5+
 	connectionPool.MustExec("SET enable_mergejoin TO off", connNum)
+	connectionPool.MustExec(utils.GetKeepaliveQuery(connectionPool, MustGetFlagInt(options.KEEPALIVES_IDLE),
+		MustGetFlagInt(options.KEEPALIVES_INTERVAL), MustGetFlagInt(options.KEEPALIVES_COUNT)), connNum)
 
 	// The fix to raise the max of extra_float_digits GUC is going out with
 	// GPDB 4.3.33.1. This means if we set the GUC using 'SET
@@ -82,6 +108,7 @@ func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plug
 		Compressed:            !MustGetFlagBool(options.NO_COMPRESSION),
 		DatabaseName:          dbName,
 		DatabaseVersion:       dbVersion,
+		DataFormat:            MustGetFlagString(options.DATA_FORMAT),
 		DataOnly:              MustGetFlagBool(options.DATA_ONLY),
 		ExcludeRelations:      MustGetFlagStringArray(options.EXCLUDE_RELATION),
 		ExcludeSchemaFiltered: len(MustGetFlagStringArray(options.EXCLUDE_SCHEMA)) > 0,
@@ -91,15 +118,25 @@ func NewBackupConfig(dbName string, dbVersion string, backupVersion string, plug
 		IncludeSchemaFiltered: len(MustGetFlagStringArray(options.INCLUDE_SCHEMA)) > 0,
 		IncludeSchemas:        MustGetFlagStringArray(options.INCLUDE_SCHEMA),
 		IncludeTableFiltered:  len(opts.GetOriginalIncludedTables()) > 0,
+		IncludeTableQuery:     MustGetFlagString(options.INCLUDE_TABLE_QUERY),
 		Incremental:           MustGetFlagBool(options.INCREMENTAL),
 		LeafPartitionData:     MustGetFlagBool(options.LEAF_PARTITION_DATA),
+		LeafPartitionDDL:      MustGetFlagBool(options.LEAF_PARTITION_DDL),
 		MetadataOnly:          MustGetFlagBool(options.METADATA_ONLY),
+		NoDataLocks:           MustGetFlagBool(options.NO_DATA_LOCKS),
+		MaxConcurrentPerHost:  MustGetFlagInt(options.MAX_PER_HOST),
 		Plugin:                plugin,
 		SingleDataFile:        MustGetFlagBool(options.SINGLE_DATA_FILE),
 		Timestamp:             timestamp,
 		WithoutGlobals:        MustGetFlagBool(options.WITHOUT_GLOBALS),
 		WithStatistics:        MustGetFlagBool(options.WITH_STATS),
 		Status:                history.BackupStatusFailed,
+		FormatVersion:         history.CONFIG_FORMAT_VERSION,
+		HeuristicIncremental:  MustGetFlagString(options.CHANGED_SINCE) != "",
+	}
+	if backupConfig.HeuristicIncremental {
+		// A gprestore that does not know about heuristic incremental backups would restore one as a complete backup
+		backupConfig.RequiredSections = []string{"heuristicincremental"}
 	}
 
 	return &backupConfig
@@ -113,6 +150,11 @@ func initializeBackupReport(opts options.Options) {
 	}
 	config := NewBackupConfig(escapedDBName, connectionPool.Version.VersionString, version,
 		plugin, globalFPInfo.Timestamp, opts)
+	if pluginConfig != nil && pluginConfig.DataPlugin != nil {
+		_, config.DataPlugin = path.Split(pluginConfig.DataPlugin.ExecutablePath)
+	}
+	config.ClientEncoding, config.ServerEncoding = GetDataEncodings(connectionPool)
+	config.SessionGUCs = utils.GetRestoreSessionGUCs(connectionPool)
 
 	isFilteredBackup := config.IncludeTableFiltered || config.IncludeSchemaFiltered ||
 		config.ExcludeTableFiltered || config.ExcludeSchemaFiltered
@@ -138,19 +180,16 @@ func createBackupLockFile(timestamp string) {
 	err = backupLockFile.TryLock()
 	if err != nil {
 		gplog.Error(err.Error())
+		if MustGetFlagString(options.TIMESTAMP) != "" {
+			gplog.Fatal(errors.Errorf("A backup with timestamp %s is already in progress.", timestamp), "")
+		}
 		gplog.Fatal(errors.Errorf("A backup with timestamp %s is already in progress. Wait 1 second and try the backup again.", timestamp), "")
 	}
 }
 
 func createBackupDirectoriesOnAllHosts() {
-	remoteOutput := globalCluster.GenerateAndExecuteCommand("Creating backup directories",
-		cluster.ON_SEGMENTS|cluster.INCLUDE_MASTER,
-		func(contentID int) string {
-			return fmt.Sprintf("mkdir -p %s", globalFPInfo.GetDirForContent(contentID))
-		})
-	globalCluster.CheckClusterError(remoteOutput, "Unable to create backup directories", func(contentID int) string {
-		return fmt.Sprintf("Unable to create backup directory %s", globalFPInfo.GetDirForContent(contentID))
-	})
+	utils.PrepareDirectoriesOnAllHosts(globalCluster, globalFPInfo, cluster.ON_SEGMENTS|cluster.INCLUDE_MASTER,
+		MustGetFlagString(options.BACKUP_DIR_MODE))
 }
 
 /*
@@ -162,20 +201,121 @@ func RetrieveAndProcessTables() ([]Table, []Table) {
 	gplog.FatalOnError(err)
 
 	tableRelations := GetIncludedUserTableRelations(connectionPool, quotedIncludeRelations)
-	LockTables(connectionPool, tableRelations)
+	tableRelations = processOrphanedPartitions(tableRelations)
+	if MustGetFlagBool(options.NO_DATA_LOCKS) {
+		report.Warn(report.WARN_NO_TABLE_LOCKS, "", "Tables will not be locked; DDL run concurrently with the backup may produce inconsistent metadata")
+	} else {
+		LockTables(connectionPool, tableRelations)
+	}
 
 	if connectionPool.Version.AtLeast("6") {
 		tableRelations = append(tableRelations, GetForeignTableRelations(connectionPool)...)
 	}
 
 	tables := ConstructDefinitionsForTables(connectionPool, tableRelations)
+	tables = processCorruptPartitionTables(tables)
 
 	metadataTables, dataTables := SplitTablesByPartitionType(tables, quotedIncludeRelations)
 	objectCounts["Tables"] = len(metadataTables)
+	reportMixedOwnershipPartitions(metadataTables)
+	reportTablesWithOids(metadataTables)
 
 	return metadataTables, dataTables
 }
 
+func processOrphanedPartitions(tableRelations []Relation) []Relation {
+	orphans := GetOrphanedPartitionTables(connectionPool)
+	if len(orphans) == 0 {
+		return tableRelations
+	}
+	exclude := MustGetFlagBool(options.EXCLUDE_ORPHANED)
+	for _, orphan := range orphans {
+		backupReport.OrphanedPartitions = append(backupReport.OrphanedPartitions, orphan.FQN())
+		if !exclude {
+			report.Warn(report.WARN_ORPHANED_PARTITION, orphan.FQN(), "Table %s was a child partition but can no longer be reached from a root partition table; it will be backed up as a standalone table",
+				orphan.FQN())
+		}
+	}
+	if exclude {
+		gplog.Info("Excluding %d orphaned child partition(s) from the backup", len(orphans))
+	}
+	return MergeOrphanedPartitions(tableRelations, orphans, exclude)
+}
+
+/*
+ * A partition table without a partition definition would be printed without
+ * its PARTITION BY clause and silently restored as a table that is not
+ * partitioned, so it fails the backup unless --skip-corrupt-partitions is
+ * given, in which case it is left out along with its child partitions.
+ * Either way it is listed in the report as needing manual attention.
+ */
+func processCorruptPartitionTables(tables []Table) []Table {
+	if MustGetFlagBool(options.DATA_ONLY) {
+		return tables
+	}
+	corruptRoots := make(map[string]bool)
+	// Child partitions know their root by name only, and may be in other schemas than the root
+	corruptChildRoots := make(map[string]bool)
+	for _, table := range tables {
+		if table.PartitionLevelInfo.Level != "p" || table.PartDef != "" {
+			continue
+		}
+		corruptRoots[table.FQN()] = true
+		corruptChildRoots[table.FQN()] = true
+		for _, child := range table.PartitionAlteredSchemas {
+			corruptChildRoots[utils.MakeFQN(child.NewSchema, table.Name)] = true
+		}
+		backupReport.ManualAttentionTables = append(backupReport.ManualAttentionTables,
+			fmt.Sprintf("%s (partition definition could not be read)", table.FQN()))
+	}
+	if len(corruptRoots) == 0 {
+		return tables
+	}
+
+	skip := MustGetFlagBool(options.SKIP_CORRUPT_PARTS)
+	tablesToBackUp := make([]Table, 0, len(tables))
+	for _, table := range tables {
+		level := table.PartitionLevelInfo.Level
+		if (level == "l" || level == "i") && corruptChildRoots[utils.MakeFQN(table.Schema, table.PartitionLevelInfo.RootName)] {
+			continue
+		}
+		if level != "p" || !corruptRoots[table.FQN()] {
+			tablesToBackUp = append(tablesToBackUp, table)
+			continue
+		}
+		if skip {
+			report.Warn(report.WARN_CORRUPT_PARTITION, table.FQN(), "Skipping partition table %s and its child partitions: its partition definition could not be read from the catalog", table.FQN())
+		} else {
+			gplog.Error("Cannot back up partition table %s: its partition definition could not be read from the catalog", table.FQN())
+		}
+	}
+	if !skip {
+		gplog.Fatal(errors.Errorf("Could not read the partition definition of %d partition table(s). Use --%s to leave them out of the backup.",
+			len(corruptRoots), options.SKIP_CORRUPT_PARTS), "")
+	}
+	return tablesToBackUp
+}
+
+func reportMixedOwnershipPartitions(tables []Table) {
+	for _, table := range tables {
+		if len(table.PartitionLeafOwners) > 0 {
+			report.Warn(report.WARN_MIXED_OWNERSHIP, table.FQN(), "Partition table %s has %d child partition(s) owned by a role other than the owner of the root partition",
+				table.FQN(), len(table.PartitionLeafOwners))
+			backupReport.MixedOwnershipPartitions = append(backupReport.MixedOwnershipPartitions, table.FQN())
+		}
+	}
+}
+
+func reportTablesWithOids(tables []Table) {
+	for _, table := range tables {
+		if table.HasOids {
+			report.Warn(report.WARN_TABLE_WITH_OIDS, table.FQN(), "Table %s was created WITH OIDS; the values of its oid column will not be backed up, and GPDB 7 and later will restore it without OIDS",
+				table.FQN())
+			backupReport.TablesWithOids = append(backupReport.TablesWithOids, table.FQN())
+		}
+	}
+}
+
 func retrieveFunctions(sortables *[]Sortable, metadataMap MetadataMap) ([]Function, map[uint32]FunctionInfo) {
 	gplog.Verbose("Retrieving function information")
 	functionMetadata := GetMetadataForObjectType(connectionPool, TYPE_FUNCTION)
@@ -387,9 +527,14 @@ func retrieveUserMappings(sortables *[]Sortable) {
 	*sortables = append(*sortables, convertToSortableSlice(mappings)...)
 }
 
-func backupSessionGUC(metadataFile *utils.FileWithByteCount) {
+func backupSessionGUC(metadataFile *utils.FileWithByteCount, tables []Table) {
 	gplog.Verbose("Writing Session Configuration Parameters to metadata file")
 	gucs := GetSessionGUCs(connectionPool)
+	if MustGetFlagBool(options.SET_DEFAULT_AM) {
+		gucs.DefaultTableAccessMethod = ExtractDefaultTableAccessMethod(tables)
+	} else {
+		gucs.DefaultTableAccessMethod = OmitHeapTableAccessMethod(tables)
+	}
 	PrintSessionGUCs(metadataFile, globalTOC, gucs)
 }
 
@@ -598,6 +743,11 @@ func backupExtensions(metadataFile *utils.FileWithByteCount) {
 func backupConstraints(metadataFile *utils.FileWithByteCount, constraints []Constraint, conMetadata MetadataMap) {
 	gplog.Verbose("Writing ADD CONSTRAINT statements to metadata file")
 	objectCounts["Constraints"] = len(constraints)
+	for _, constraint := range constraints {
+		if !constraint.IsValid {
+			backupReport.InvalidObjects = append(backupReport.InvalidObjects, fmt.Sprintf("constraint %s on %s (backed up NOT VALID)", constraint.Name, constraint.OwningObject))
+		}
+	}
 	PrintConstraintStatements(metadataFile, globalTOC, constraints, conMetadata)
 }
 
@@ -607,10 +757,43 @@ func backupConstraints(metadataFile *utils.FileWithByteCount, constraints []Cons
 
 func backupIndexes(metadataFile *utils.FileWithByteCount) {
 	gplog.Verbose("Writing CREATE INDEX statements to metadata file")
-	indexes := GetIndexes(connectionPool)
+	indexes := processInvalidIndexes(GetIndexes(connectionPool))
 	objectCounts["Indexes"] = len(indexes)
 	indexMetadata := GetCommentsForObjectType(connectionPool, TYPE_INDEX)
 	PrintCreateIndexStatements(metadataFile, globalTOC, indexes, indexMetadata)
+	PrintClusterStatements(metadataFile, globalTOC, indexes)
+}
+
+/*
+ * An invalid index was never finished on the source, so restoring it would
+ * spend time building an index the source does not use.  Invalid indexes are
+ * skipped unless --include-invalid-indexes is given, and listed in the report
+ * either way.
+ */
+func processInvalidIndexes(indexes []IndexDefinition) []IndexDefinition {
+	include := MustGetFlagBool(options.INCLUDE_INVALID)
+	validIndexes := make([]IndexDefinition, 0, len(indexes))
+	for _, index := range indexes {
+		if !index.IsInvalid {
+			validIndexes = append(validIndexes, index)
+			continue
+		}
+		tableFQN := utils.MakeFQN(index.OwningSchema, index.OwningTable)
+		if include {
+			report.Warn(report.WARN_INVALID_INDEX, index.FQN(), "Index %s on table %s is invalid; it will be backed up and built in full on restore", index.FQN(), tableFQN)
+			backupReport.InvalidObjects = append(backupReport.InvalidObjects, fmt.Sprintf("index %s on %s (backed up)", index.FQN(), tableFQN))
+			validIndexes = append(validIndexes, index)
+		} else {
+			report.Warn(report.WARN_INVALID_INDEX, index.FQN(), "Index %s on table %s is invalid and will not be backed up; use --%s to back it up", index.FQN(), tableFQN, options.INCLUDE_INVALID)
+			backupReport.InvalidObjects = append(backupReport.InvalidObjects, fmt.Sprintf("index %s on %s (not backed up)", index.FQN(), tableFQN))
+		}
+	}
+	return validIndexes
+}
+
+func backupReplicaIdentities(metadataFile *utils.FileWithByteCount, tables []Table) {
+	gplog.Verbose("Writing REPLICA IDENTITY statements to metadata file")
+	PrintReplicaIdentityStatements(metadataFile, globalTOC, tables)
 }
 
 func backupRules(metadataFile *utils.FileWithByteCount) {
@@ -637,6 +820,13 @@ func backupEventTriggers(metadataFile *utils.FileWithByteCount) {
 	PrintCreateEventTriggerStatements(metadataFile, globalTOC, eventTriggers, eventTriggerMetadata)
 }
 
+func backupExtensionMembers(metadataFile *utils.FileWithByteCount) {
+	gplog.Verbose("Writing ALTER EXTENSION ... ADD statements to metadata file")
+	extensionMembers := GetAddedExtensionMembers(connectionPool)
+	objectCounts["Extension Members"] = len(extensionMembers)
+	PrintAlterExtensionAddStatements(metadataFile, globalTOC, extensionMembers)
+}
+
 func backupDefaultPrivileges(metadataFile *utils.FileWithByteCount) {
 	gplog.Verbose("Writing ALTER DEFAULT PRIVILEGES statements to metadata file")
 	defaultPrivileges := GetDefaultPrivileges(connectionPool)
@@ -652,11 +842,12 @@ func backupTableStatistics(statisticsFile *utils.FileWithByteCount, tables []Tab
 	attStats := GetAttributeStatistics(connectionPool, tables)
 	tupleStats := GetTupleStatistics(connectionPool, tables)
 
-	backupSessionGUC(statisticsFile)
+	backupSessionGUC(statisticsFile, nil)
 	PrintStatisticsStatements(statisticsFile, globalTOC, tables, attStats, tupleStats)
 }
 
 func backupIncrementalMetadata() {
 	aoTableEntries := GetAOIncrementalMetadata(connectionPool)
 	globalTOC.IncrementalMetadata.AO = aoTableEntries
+	globalTOC.IncrementalMetadata.Heap = GetHeapIncrementalMetadata(connectionPool)
 }
@@ -493,6 +493,32 @@ var _ = Describe("backup and restore end to end tests", func() {
 				"--with-globals",
 				"--create-db")
 		})
+		It("runs gprestore with --redirect-db and --create-db into a database with a different name", func() {
+			if backupConn.Version.Before("6") {
+				Skip("Test only applicable to GPDB 6 and above")
+			}
+			testhelper.AssertQueryRuns(backupConn, "CREATE DATABASE prod;")
+			defer testhelper.AssertQueryRuns(backupConn, "DROP DATABASE prod;")
+			testhelper.AssertQueryRuns(backupConn, "CREATE ROLE reporting;")
+			defer testhelper.AssertQueryRuns(backupConn, "DROP ROLE reporting;")
+			testhelper.AssertQueryRuns(backupConn, "COMMENT ON DATABASE prod IS 'production database';")
+			testhelper.AssertQueryRuns(backupConn, "ALTER DATABASE prod SET statement_timeout TO '5min';")
+			testhelper.AssertQueryRuns(backupConn, "GRANT CONNECT ON DATABASE prod TO reporting;")
+
+			timestamp := gpbackup(gpbackupPath, backupHelperPath, "--dbname", "prod", "--backup-dir", backupDir, "--metadata-only")
+			defer testhelper.AssertQueryRuns(backupConn, "DROP DATABASE IF EXISTS prod_copy;")
+			gprestore(gprestorePath, restoreHelperPath, timestamp,
+				"--redirect-db", "prod_copy",
+				"--create-db",
+				"--backup-dir", backupDir)
+
+			comment := dbconn.MustSelectString(backupConn, "SELECT shobj_description(oid, 'pg_database') AS string FROM pg_database WHERE datname = 'prod_copy'")
+			Expect(comment).To(Equal("production database"))
+			settings := dbconn.MustSelectString(backupConn, "SELECT array_to_string(setconfig, ',') AS string FROM pg_db_role_setting s JOIN pg_database d ON s.setdatabase = d.oid WHERE d.datname = 'prod_copy' AND s.setrole = 0")
+			Expect(settings).To(Equal("statement_timeout=5min"))
+			hasConnect := dbconn.MustSelectString(backupConn, "SELECT has_database_privilege('reporting', 'prod_copy', 'CONNECT')::text AS string")
+			Expect(hasConnect).To(Equal("true"))
+		})
 		It("runs gpbackup with --without-globals", func() {
 			skipIfOldBackupVersionBefore("1.18.0")
 			createGlobalObjects(backupConn)
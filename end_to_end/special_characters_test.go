@@ -3,7 +3,9 @@ package end_to_end_test
 import (
 	"fmt"
 	"os/exec"
+	"strings"
 
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -77,6 +79,33 @@ PARTITION BY LIST (gender)
 		assertDataRestored(restoreConn, localSchemaTupleCounts)
 		assertArtifactsCleaned(restoreConn, timestamp)
 	})
+	It("runs gpbackup and gprestore with comments containing a quote, a newline, and a trailing backslash", func() {
+		comment := "driver's license #\nissued by state\\"
+		testhelper.AssertQueryRuns(backupConn, "CREATE TABLE public.comment_table (i int CONSTRAINT comment_table_i_key UNIQUE)")
+		defer testhelper.AssertQueryRuns(backupConn, "DROP TABLE public.comment_table")
+		for _, statement := range []string{
+			"COMMENT ON TABLE public.comment_table IS '%s'",
+			"COMMENT ON COLUMN public.comment_table.i IS '%s'",
+			"COMMENT ON CONSTRAINT comment_table_i_key ON public.comment_table IS '%s'",
+		} {
+			testhelper.AssertQueryRuns(backupConn, fmt.Sprintf(statement, strings.Replace(comment, "'", "''", -1)))
+		}
+		timestamp := gpbackup(gpbackupPath, backupHelperPath,
+			"--backup-dir", backupDir,
+			"--include-table", "public.comment_table")
+		gprestore(gprestorePath, restoreHelperPath, timestamp,
+			"--redirect-db", "restoredb",
+			"--backup-dir", backupDir)
+
+		for _, query := range []string{
+			"SELECT obj_description('public.comment_table'::regclass, 'pg_class') AS string",
+			"SELECT col_description('public.comment_table'::regclass, 1) AS string",
+			"SELECT obj_description(oid, 'pg_constraint') AS string FROM pg_constraint WHERE conname = 'comment_table_i_key'",
+		} {
+			Expect(dbconn.MustSelectString(restoreConn, query)).To(Equal(comment))
+		}
+		assertArtifactsCleaned(restoreConn, timestamp)
+	})
 	It(`gpbackup runs with table name including special chars ~#$%^&*()_-+[]{}><|;:/?!\tC`, func() {
 		allChars := []string{" ", "`", "~", "#", "$", "%", "^", "&", "*", "(", ")", "-", "+", "[", "]", "{", "}", ">", "<", "\\", "|", ";", ":", "/", "?", ",", "!", "C", "\t", "'", "1", "\\n", "\\t", "\""}
 		var includeTableArgs []string
@@ -89,11 +89,34 @@ func (backupFPInfo *FilePathInfo) GetTableBackupFilePathForCopyCommand(tableOid
 	}
 
 	backupFilePath += extension
-	baseDir := "<SEG_DATA_DIR>"
+	return path.Join(backupFPInfo.getBaseDirForCopyCommand(), "backups", backupFPInfo.Timestamp[0:8], backupFPInfo.Timestamp, backupFilePath)
+}
+
+func (backupFPInfo *FilePathInfo) getBaseDirForCopyCommand() string {
 	if backupFPInfo.IsUserSpecifiedBackupDir() {
-		baseDir = path.Join(backupFPInfo.UserSpecifiedBackupDir, fmt.Sprintf("%s<SEGID>", backupFPInfo.UserSpecifiedSegPrefix))
+		return path.Join(backupFPInfo.UserSpecifiedBackupDir, fmt.Sprintf("%s<SEGID>", backupFPInfo.UserSpecifiedSegPrefix))
 	}
-	return path.Join(baseDir, "backups", backupFPInfo.Timestamp[0:8], backupFPInfo.Timestamp, backupFilePath)
+	return "<SEG_DATA_DIR>"
+}
+
+/*
+ * With --content-addressed-data, table data files are named by the checksum
+ * of their contents and kept in a directory shared by all backups, so that an
+ * unchanged table produces the same file at the same path in every backup.
+ * A small reference file in the timestamped directory records which data file
+ * belongs to each table.
+ */
+func (backupFPInfo *FilePathInfo) GetContentAddressedDirForCopyCommand() string {
+	return path.Join(backupFPInfo.getBaseDirForCopyCommand(), "backups", "content")
+}
+
+func (backupFPInfo *FilePathInfo) GetContentAddressedDir(contentID int) string {
+	return backupFPInfo.replaceCopyFormatStringsInPath(backupFPInfo.GetContentAddressedDirForCopyCommand(), contentID)
+}
+
+func (backupFPInfo *FilePathInfo) GetTableContentRefFilePathForCopyCommand(tableOid uint32) string {
+	return path.Join(backupFPInfo.getBaseDirForCopyCommand(), "backups", backupFPInfo.Timestamp[0:8], backupFPInfo.Timestamp,
+		fmt.Sprintf("gpbackup_<SEGID>_%s_%d.ref", backupFPInfo.Timestamp, tableOid))
 }
 
 var metadataFilenameMap = map[string]string{
@@ -102,9 +125,11 @@ var metadataFilenameMap = map[string]string{
 	"statistics":            "statistics.sql",
 	"table of contents":     "toc.yaml",
 	"report":                "report",
+	"report_json":           "report.json",
 	"plugin_config":         "plugin_config.yaml",
 	"error_tables_metadata": "error_tables_metadata",
 	"error_tables_data":     "error_tables_data",
+	"index_journal":         "index_journal.yaml",
 }
 
 func (backupFPInfo *FilePathInfo) GetBackupFilePath(filetype string) string {
@@ -132,6 +157,10 @@ func (backupFPInfo *FilePathInfo) GetBackupReportFilePath() string {
 	return backupFPInfo.GetBackupFilePath("report")
 }
 
+func (backupFPInfo *FilePathInfo) GetBackupReportJSONFilePath() string {
+	return backupFPInfo.GetBackupFilePath("report_json")
+}
+
 func (backupFPInfo *FilePathInfo) GetRestoreFilePath(restoreTimestamp string, filetype string) string {
 	return path.Join(backupFPInfo.GetDirForContent(-1), fmt.Sprintf("gprestore_%s_%s_%s", backupFPInfo.Timestamp, restoreTimestamp, metadataFilenameMap[filetype]))
 }
@@ -140,6 +169,10 @@ func (backupFPInfo *FilePathInfo) GetRestoreReportFilePath(restoreTimestamp stri
 	return backupFPInfo.GetRestoreFilePath(restoreTimestamp, "report")
 }
 
+func (backupFPInfo *FilePathInfo) GetRestoreReportJSONFilePath(restoreTimestamp string) string {
+	return backupFPInfo.GetRestoreFilePath(restoreTimestamp, "report_json")
+}
+
 func (backupFPInfo *FilePathInfo) GetErrorTablesMetadataFilePath(restoreTimestamp string) string {
 	return backupFPInfo.GetRestoreFilePath(restoreTimestamp, "error_tables_metadata")
 }
@@ -148,6 +181,14 @@ func (backupFPInfo *FilePathInfo) GetErrorTablesDataFilePath(restoreTimestamp st
 	return backupFPInfo.GetRestoreFilePath(restoreTimestamp, "error_tables_data")
 }
 
+/*
+ * Unlike other restore files, the index journal is not named by the restore
+ * timestamp, so that a later restore of the same backup can find it.
+ */
+func (backupFPInfo *FilePathInfo) GetIndexJournalFilePath() string {
+	return path.Join(backupFPInfo.GetDirForContent(-1), fmt.Sprintf("gprestore_%s_%s", backupFPInfo.Timestamp, metadataFilenameMap["index_journal"]))
+}
+
 func (backupFPInfo *FilePathInfo) GetConfigFilePath() string {
 	return backupFPInfo.GetBackupFilePath("config")
 }
@@ -164,6 +205,27 @@ func (backupFPInfo *FilePathInfo) GetSegmentHelperFilePath(contentID int, suffix
 	return path.Join(backupFPInfo.SegDirMap[contentID], fmt.Sprintf("gpbackup_%d_%s_%s_%d", contentID, backupFPInfo.Timestamp, suffix, backupFPInfo.PID))
 }
 
+/*
+ * The slot directory is shared by all segments on a host, so it is not in
+ * any segment data directory.
+ */
+func (backupFPInfo *FilePathInfo) GetHostSlotDir() string {
+	return fmt.Sprintf("/tmp/gpbackup_%s_host_slots_%d", backupFPInfo.Timestamp, backupFPInfo.PID)
+}
+
+/*
+ * As with the slot directory, the files counting rows that failed encoding
+ * conversion are written by all segments on a host to one directory.
+ */
+func (backupFPInfo *FilePathInfo) GetConversionCountDir() string {
+	return fmt.Sprintf("/tmp/gpbackup_%s_conversion_%d", backupFPInfo.Timestamp, backupFPInfo.PID)
+}
+
+// The files counting the bytes read for each table's data load are kept the same way
+func (backupFPInfo *FilePathInfo) GetLoadByteCountDir() string {
+	return fmt.Sprintf("/tmp/gpbackup_%s_load_bytes_%d", backupFPInfo.Timestamp, backupFPInfo.PID)
+}
+
 func (backupFPInfo *FilePathInfo) GetHelperLogPath() string {
 	currentUser, _ := operating.System.CurrentUser()
 	homeDir := currentUser.HomeDir
@@ -97,6 +97,20 @@ var _ = Describe("filepath tests", func() {
 			Expect(fpInfo.GetTableBackupFilePathForCopyCommand(1234, ".gzip", true)).To(Equal("/foo/bar/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101.gzip"))
 		})
 	})
+	Describe("content-addressed data paths", func() {
+		It("returns the shared content directory and the table reference file for copy command", func() {
+			fpInfo := NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			Expect(fpInfo.GetContentAddressedDirForCopyCommand()).To(Equal("<SEG_DATA_DIR>/backups/content"))
+			Expect(fpInfo.GetContentAddressedDir(-1)).To(Equal("/data/gpseg-1/backups/content"))
+			Expect(fpInfo.GetTableContentRefFilePathForCopyCommand(1234)).To(Equal("<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_1234.ref"))
+		})
+		It("returns the shared content directory and the table reference file based on user specified path", func() {
+			fpInfo := NewFilePathInfo(c, "/foo/bar", "20170101010101", "gpseg")
+			Expect(fpInfo.GetContentAddressedDirForCopyCommand()).To(Equal("/foo/bar/gpseg<SEGID>/backups/content"))
+			Expect(fpInfo.GetContentAddressedDir(1)).To(Equal("/foo/bar/gpseg1/backups/content"))
+			Expect(fpInfo.GetTableContentRefFilePathForCopyCommand(1234)).To(Equal("/foo/bar/gpseg<SEGID>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_1234.ref"))
+		})
+	})
 	Describe("GetReportFilePath", func() {
 		It("returns report file path", func() {
 			fpInfo := NewFilePathInfo(c, "", "20170101010101", "gpseg")
@@ -106,6 +120,11 @@ var _ = Describe("filepath tests", func() {
 			fpInfo := NewFilePathInfo(c, "/foo/bar", "20170101010101", "gpseg")
 			Expect(fpInfo.GetBackupReportFilePath()).To(Equal("/foo/bar/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_report"))
 		})
+		It("returns the JSON report file paths for backups and restores", func() {
+			fpInfo := NewFilePathInfo(c, "", "20170101010101", "gpseg")
+			Expect(fpInfo.GetBackupReportJSONFilePath()).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_report.json"))
+			Expect(fpInfo.GetRestoreReportJSONFilePath("20170101020202")).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gprestore_20170101010101_20170101020202_report.json"))
+		})
 	})
 	Describe("GetTableBackupFilePath", func() {
 		It("returns table file path", func() {
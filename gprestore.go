@@ -7,6 +7,7 @@ import (
 
 	"github.com/greenplum-db/gpbackup/options"
 	. "github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -25,6 +26,6 @@ func main() {
 	rootCmd.SetArgs(options.HandleSingleDashes(os.Args[1:]))
 	DoInit(rootCmd)
 	if err := rootCmd.Execute(); err != nil {
-		os.Exit(2)
+		os.Exit(utils.EXIT_USAGE)
 	}
 }
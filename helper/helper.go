@@ -41,18 +41,26 @@ var (
  * Command-line flags
  */
 var (
-	backupAgent      *bool
-	compressionLevel *int
-	content          *int
-	dataFile         *string
-	oidFile          *string
-	onErrorContinue  *bool
-	pipeFile         *string
-	pluginConfigFile *string
-	printVersion     *bool
-	restoreAgent     *bool
-	tocFile          *string
-	isFiltered       *bool
+	allowVersionSkew   *bool
+	backupAgent        *bool
+	compressionLevel   *int
+	content            *int
+	coordinatorVersion *string
+	countFile          *string
+	dataFile           *string
+	dataFormatVersion  *int
+	fromEncoding       *string
+	oidFile            *string
+	onConversionError  *string
+	onErrorContinue    *bool
+	pipeFile           *string
+	pluginConfigFile   *string
+	printVersion       *bool
+	restoreAgent       *bool
+	toEncoding         *string
+	tocFile            *string
+	transcodeAgent     *bool
+	isFiltered         *bool
 )
 
 func DoHelper() {
@@ -80,15 +88,20 @@ func DoHelper() {
 		}
 	}()
 
-	if *backupAgent {
+	err = checkCoordinatorVersion()
+	if err == nil && *backupAgent {
 		err = doBackupAgent()
-	} else if *restoreAgent {
+	} else if err == nil && *restoreAgent {
 		err = doRestoreAgent()
+	} else if err == nil && *transcodeAgent {
+		err = doTranscodeAgent()
 	}
 	if err != nil {
 		gplog.Error(fmt.Sprintf("%v: %s", err, debug.Stack()))
-		handle, _ := utils.OpenFileForWrite(fmt.Sprintf("%s_error", *pipeFile))
-		_ = handle.Close()
+		if *pipeFile != "" {
+			handle, _ := utils.OpenFileForWrite(fmt.Sprintf("%s_error", *pipeFile))
+			_ = handle.Close()
+		}
 	}
 }
 
@@ -97,17 +110,25 @@ func InitializeGlobals() {
 	CleanupGroup.Add(1)
 	gplog.InitializeLogging("gpbackup_helper", "")
 
+	allowVersionSkew = flag.Bool("allow-version-skew", false, "Allow the coordinator version to differ by minor or patch version")
 	backupAgent = flag.Bool("backup-agent", false, "Use gpbackup_helper as an agent for backup")
 	content = flag.Int("content", -2, "Content ID of the corresponding segment")
 	compressionLevel = flag.Int("compression-level", 0, "The level of compression to use with gzip. O indicates no compression.")
+	coordinatorVersion = flag.String("coordinator-version", "", "The version of gpbackup or gprestore that started the helper")
+	countFile = flag.String("count-file", "", "Absolute path to the file to which the number of rows that failed encoding conversion is written")
 	dataFile = flag.String("data-file", "", "Absolute path to the data file")
+	dataFormatVersion = flag.Int("data-format-version", utils.HELPER_DATA_FORMAT_VERSION, "The data format version expected by gpbackup or gprestore")
+	fromEncoding = flag.String("from-encoding", "", "The encoding of the data read by the transcode agent")
 	oidFile = flag.String("oid-file", "", "Absolute path to the file containing a list of oids to restore")
+	onConversionError = flag.String("on-conversion-error", utils.CONVERSION_ERROR_FAIL, "What the transcode agent does with a row that cannot be converted: fail, skip, or replace")
 	onErrorContinue = flag.Bool("on-error-continue", false, "Continue restore even when encountering an error")
 	pipeFile = flag.String("pipe-file", "", "Absolute path to the pipe file")
 	pluginConfigFile = flag.String("plugin-config", "", "The configuration file to use for a plugin")
 	printVersion = flag.Bool("version", false, "Print version number and exit")
 	restoreAgent = flag.Bool("restore-agent", false, "Use gpbackup_helper as an agent for restore")
+	toEncoding = flag.String("to-encoding", "", "The encoding of the data written by the transcode agent")
 	tocFile = flag.String("toc-file", "", "Absolute path to the table of contents file")
+	transcodeAgent = flag.Bool("transcode-agent", false, "Use gpbackup_helper to convert CSV data on stdin between encodings for restore")
 	isFiltered = flag.Bool("with-filters", false, "Used with table/schema filters")
 
 	if *onErrorContinue && !*restoreAgent {
@@ -117,7 +138,7 @@ func InitializeGlobals() {
 
 	flag.Parse()
 	if *printVersion {
-		fmt.Printf("gpbackup_helper version %s\n", version)
+		fmt.Printf("gpbackup_helper version %s data format version %d\n", version, utils.HELPER_DATA_FORMAT_VERSION)
 		os.Exit(0)
 	}
 	operating.InitializeSystemFunctions()
@@ -127,6 +148,23 @@ func InitializeGlobals() {
  * Shared functions
  */
 
+/*
+ * gpbackup and gprestore check the helper version on every host before
+ * starting helpers, but the helper checks again in case its binary changed
+ * in between, so that it never frames data differently than expected.
+ */
+func checkCoordinatorVersion() error {
+	if *coordinatorVersion == "" {
+		return nil
+	}
+	err := utils.CheckHelperVersionCompatibility(*coordinatorVersion, version, *dataFormatVersion, utils.HELPER_DATA_FORMAT_VERSION, *allowVersionSkew)
+	if err != nil {
+		return err
+	}
+	log("Verified coordinator version %s and data format version %d", *coordinatorVersion, *dataFormatVersion)
+	return nil
+}
+
 func createPipe(pipe string) error {
 	err := unix.Mkfifo(pipe, 0777)
 	return err
@@ -0,0 +1,45 @@
+package helper
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+/*
+ * Transcode specific functions
+ */
+
+/*
+ * The transcode agent runs in the COPY PROGRAM pipeline of a single table, so
+ * it reads the table's data from stdin and writes it to stdout rather than
+ * using pipes.  The count file is only written when rows failed conversion.
+ */
+func doTranscodeAgent() error {
+	transcoder, err := utils.NewTranscoder(*fromEncoding, *toEncoding, *onConversionError)
+	if err != nil {
+		return err
+	}
+	stdoutWriter := bufio.NewWriter(os.Stdout)
+	failedRows, err := transcoder.TranscodeCSV(os.Stdin, stdoutWriter)
+	if err != nil {
+		return err
+	}
+	err = stdoutWriter.Flush()
+	if err != nil {
+		return err
+	}
+	if failedRows == 0 || *countFile == "" {
+		return nil
+	}
+	log(fmt.Sprintf("%d row(s) could not be converted from %s to %s", failedRows, *fromEncoding, *toEncoding))
+	err = os.MkdirAll(filepath.Dir(*countFile), 0777)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*countFile, []byte(fmt.Sprintf("%d\n", failedRows)), 0644)
+}
@@ -0,0 +1,203 @@
+package history
+
+/*
+ * This file contains functions for recording backups and restores in a table
+ * in a database, for sites that query their history with SQL rather than
+ * reading the history file.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+const HISTORY_TABLE = "gpbackup_history"
+
+/*
+ * Columns may be added to the end of this list, and are added to existing
+ * tables when the next operation is recorded, but are never renamed,
+ * removed, or retyped, so that a table can be shared by every version.
+ */
+var HistoryTableColumns = []struct {
+	Name string
+	Type string
+}{
+	{"operation", "text"},
+	{"backup_timestamp", "text"},
+	{"restore_timestamp", "text"},
+	{"database_name", "text"},
+	{"status", "text"},
+	{"start_time", "timestamp"},
+	{"end_time", "timestamp"},
+	{"duration_seconds", "double precision"},
+	{"bytes", "bigint"},
+	{"tables", "integer"},
+	{"errors", "integer"},
+	{"warnings", "integer"},
+	{"phase_seconds", "text"},
+	{"options", "text"},
+	{"source_database_name", "text"},
+}
+
+/*
+ * StartTime and EndTime are in the timestamp format, YYYYMMDDHHMMSS.
+ * Options holds the flags the operation was run with, by flag name.
+ * SourceDatabase is only set for restores, to the database that was backed
+ * up, which differs from Database for restores with --redirect-db.
+ */
+type OperationRecord struct {
+	Operation        string
+	BackupTimestamp  string
+	RestoreTimestamp string
+	Database         string
+	SourceDatabase   string
+	Status           string
+	StartTime        string
+	EndTime          string
+	Options          map[string]string
+	Stats            *RunStats
+}
+
+func quoteLiteral(value string) string {
+	return fmt.Sprintf("'%s'", utils.EscapeSingleQuotes(value))
+}
+
+func textOrNull(value string) string {
+	if value == "" {
+		return "NULL"
+	}
+	return quoteLiteral(value)
+}
+
+func timestampOrNull(timestamp string) string {
+	parsed, err := time.Parse("20060102150405", timestamp)
+	if err != nil {
+		return "NULL"
+	}
+	return quoteLiteral(parsed.Format("2006-01-02 15:04:05"))
+}
+
+func jsonOrNull(value interface{}) (string, error) {
+	contents, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	if string(contents) == "null" {
+		return "NULL", nil
+	}
+	return quoteLiteral(string(contents)), nil
+}
+
+/*
+ * Creates the schema and table if they do not exist, and adds any columns
+ * missing from a table created by an older version.  Returns the name of the
+ * table.
+ */
+func EnsureHistoryTable(connectionPool *dbconn.DBConn, schema string) (string, error) {
+	schemaCount, err := dbconn.SelectString(connectionPool,
+		fmt.Sprintf("SELECT count(*) AS string FROM pg_namespace WHERE nspname = %s", quoteLiteral(schema)))
+	if err != nil {
+		return "", err
+	}
+	quotedSchema := utils.QuoteIdentifier(schema)
+	if schemaCount == "0" {
+		_, err = connectionPool.Exec(fmt.Sprintf("CREATE SCHEMA %s", quotedSchema))
+		if err != nil {
+			return "", err
+		}
+	}
+	existingColumns := make([]string, 0)
+	err = connectionPool.Select(&existingColumns, fmt.Sprintf(`
+SELECT a.attname
+FROM pg_attribute a
+	JOIN pg_class c ON a.attrelid = c.oid
+	JOIN pg_namespace n ON c.relnamespace = n.oid
+WHERE n.nspname = %s
+	AND c.relname = '%s'
+	AND a.attnum > 0
+	AND NOT a.attisdropped`, quoteLiteral(schema), HISTORY_TABLE))
+	if err != nil {
+		return "", err
+	}
+	tableFQN := fmt.Sprintf("%s.%s", quotedSchema, HISTORY_TABLE)
+	if len(existingColumns) == 0 {
+		columnDefs := make([]string, len(HistoryTableColumns))
+		for i, column := range HistoryTableColumns {
+			columnDefs[i] = fmt.Sprintf("\t%s %s", column.Name, column.Type)
+		}
+		_, err = connectionPool.Exec(fmt.Sprintf("CREATE TABLE %s (\n%s\n) DISTRIBUTED RANDOMLY", tableFQN, strings.Join(columnDefs, ",\n")))
+		return tableFQN, err
+	}
+	for _, column := range HistoryTableColumns {
+		if utils.Exists(existingColumns, column.Name) {
+			continue
+		}
+		_, err = connectionPool.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableFQN, column.Name, column.Type))
+		if err != nil {
+			return "", err
+		}
+	}
+	return tableFQN, nil
+}
+
+func RecordOperation(connectionPool *dbconn.DBConn, schema string, record OperationRecord) error {
+	tableFQN, err := EnsureHistoryTable(connectionPool, schema)
+	if err != nil {
+		return err
+	}
+	optionsStr, err := jsonOrNull(record.Options)
+	if err != nil {
+		return err
+	}
+	values := []string{quoteLiteral(record.Operation), textOrNull(record.BackupTimestamp), textOrNull(record.RestoreTimestamp),
+		textOrNull(record.Database), textOrNull(record.Status), timestampOrNull(record.StartTime), timestampOrNull(record.EndTime)}
+	if record.Stats != nil {
+		bytesStr := "NULL"
+		if record.Stats.Bytes >= 0 {
+			bytesStr = strconv.FormatInt(record.Stats.Bytes, 10)
+		}
+		phaseStr, err := jsonOrNull(record.Stats.PhaseDurations)
+		if err != nil {
+			return err
+		}
+		values = append(values, strconv.FormatFloat(record.Stats.Duration, 'f', -1, 64), bytesStr, strconv.Itoa(record.Stats.Tables),
+			strconv.Itoa(record.Stats.Errors), strconv.Itoa(record.Stats.Warnings), phaseStr)
+	} else {
+		values = append(values, "NULL", "NULL", "NULL", "NULL", "NULL", "NULL")
+	}
+	values = append(values, optionsStr, textOrNull(record.SourceDatabase))
+
+	columnNames := make([]string, len(HistoryTableColumns))
+	for i, column := range HistoryTableColumns {
+		columnNames[i] = column.Name
+	}
+	_, err = connectionPool.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableFQN, strings.Join(columnNames, ", "), strings.Join(values, ", ")))
+	return err
+}
+
+/*
+ * A new connection is made for each operation recorded, and any error,
+ * including a failure to connect, is returned for the caller to log as a
+ * warning, so that the database never affects the outcome of the operation.
+ */
+func RecordOperationInDatabase(dbname string, schema string, record OperationRecord) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("%v", r)
+		}
+	}()
+	connectionPool := dbconn.NewDBConnFromEnvironment(dbname)
+	err = connectionPool.Connect(1)
+	if err != nil {
+		return err
+	}
+	defer connectionPool.Close()
+	return RecordOperation(connectionPool, schema, record)
+}
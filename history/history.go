@@ -25,10 +25,19 @@ const (
 	BackupStatusFailed  = "Failure"
 )
 
+/*
+ * Bump CONFIG_FORMAT_VERSION whenever the config changes in a way that an older
+ * gprestore cannot safely ignore.  Config files written before versioning have
+ * a FormatVersion of 0.  As with the TOC, BackupConfig fields may be added
+ * within a format version but are never renamed, removed, or retyped.
+ */
+const CONFIG_FORMAT_VERSION = 1
+
 type BackupConfig struct {
 	BackupDir             string
 	BackupVersion         string
 	Compressed            bool
+	DataFormat            string
 	DatabaseName          string
 	DatabaseVersion       string
 	DataOnly              bool
@@ -41,8 +50,10 @@ type BackupConfig struct {
 	IncludeSchemaFiltered bool
 	IncludeSchemas        []string
 	IncludeTableFiltered  bool
+	IncludeTableQuery     string `yaml:",omitempty"` // the query that IncludeRelations was resolved from
 	Incremental           bool
 	LeafPartitionData     bool
+	LeafPartitionDDL      bool
 	MetadataOnly          bool
 	Plugin                string
 	PluginVersion         string
@@ -53,21 +64,62 @@ type BackupConfig struct {
 	WithoutGlobals        bool
 	WithStatistics        bool
 	Status                string
+	FormatVersion         int
+	RequiredSections      []string          `yaml:",omitempty"`
+	Attempt               int               `yaml:",omitempty"`
+	NoDataLocks           bool              `yaml:",omitempty"` // tables were not locked, so concurrent DDL may be reflected inconsistently
+	MaxConcurrentPerHost  int               `yaml:",omitempty"`
+	ClientEncoding        string            `yaml:",omitempty"` // the encoding of the table data files
+	ServerEncoding        string            `yaml:",omitempty"`
+	SessionGUCs           map[string]string `yaml:",omitempty"` // the settings of utils.RestoreSessionGUCs in the backup sessions
+	Stats                 *RunStats         `yaml:",omitempty"`
+	Restores              []RunStats        `yaml:",omitempty"`
+	HeuristicIncremental  bool              `yaml:",omitempty"` // only the data of tables that probably changed since ChangedSince was backed up
+	ChangedSince          string            `yaml:",omitempty"`
+	DataPlugin            string            `yaml:",omitempty"` // the plugin that data files were sent to, when it has a destination of its own
+	DataPluginVersion     string            `yaml:",omitempty"`
 }
 
 func (backup *BackupConfig) Failed() bool {
 	return backup.Status == BackupStatusFailed
 }
 
-func ReadConfigFile(filename string) *BackupConfig {
+/*
+ * LoadBackupConfig reads a backup's config file, returning an error instead
+ * of exiting so that it can be used by programs other than gpbackup and
+ * gprestore.
+ */
+func LoadBackupConfig(filename string) (*BackupConfig, error) {
 	config := &BackupConfig{}
 	contents, err := ioutil.ReadFile(filename)
-	gplog.FatalOnError(err)
+	if err != nil {
+		return nil, err
+	}
 	err = yaml.Unmarshal(contents, config)
+	if err != nil {
+		// A newer format may have changed the type of a field we know about
+		versionInfo := struct {
+			BackupVersion string
+			FormatVersion int
+		}{}
+		if yaml.Unmarshal(contents, &versionInfo) == nil && versionInfo.FormatVersion > CONFIG_FORMAT_VERSION {
+			err = utils.NewFormatVersionError(versionInfo.BackupVersion, "config", versionInfo.FormatVersion, CONFIG_FORMAT_VERSION)
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+func ReadConfigFile(filename string) *BackupConfig {
+	config, err := LoadBackupConfig(filename)
 	gplog.FatalOnError(err)
 	return config
 }
 
+func (backup *BackupConfig) UnknownRequiredSections() []string {
+	return utils.UnknownYAMLKeys(backup, backup.RequiredSections)
+}
+
 func WriteConfigFile(config *BackupConfig, configFilename string) {
 	configContents, err := yaml.Marshal(config)
 	gplog.FatalOnError(err)
@@ -211,3 +263,17 @@ func (history *History) FindBackupConfig(timestamp string) *BackupConfig {
 	}
 	return nil
 }
+
+/*
+ * A backup run again under the timestamp of a failed attempt adds another
+ * entry with that timestamp, so this counts every attempt made so far.
+ */
+func (history *History) CountBackupAttempts(timestamp string) int {
+	attempts := 0
+	for _, backupConfig := range history.BackupConfigs {
+		if backupConfig.Timestamp == timestamp {
+			attempts++
+		}
+	}
+	return attempts
+}
@@ -4,9 +4,12 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"regexp"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gp-common-go-libs/structmatcher"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
@@ -298,4 +301,204 @@ var _ = Describe("backup/history tests", func() {
 			Expect(foundConfig).To(BeNil())
 		})
 	})
+	Describe("CountBackupAttempts", func() {
+		It("counts every entry with the given timestamp", func() {
+			backupHistory := history.History{BackupConfigs: []history.BackupConfig{
+				{Timestamp: "20170101010102", Status: history.BackupStatusSucceed, Attempt: 2},
+				{Timestamp: "20170101010102", Status: history.BackupStatusFailed, Attempt: 1},
+				{Timestamp: "20170101010101", Status: history.BackupStatusSucceed},
+			}}
+			Expect(backupHistory.CountBackupAttempts("20170101010102")).To(Equal(2))
+			Expect(backupHistory.CountBackupAttempts("20170101010101")).To(Equal(1))
+			Expect(backupHistory.CountBackupAttempts("20170101010103")).To(Equal(0))
+		})
+	})
+	Describe("ReadConfigFile", func() {
+		configFilePath := "/tmp/gpbackup_config.yaml"
+		AfterEach(func() {
+			_ = os.Remove(configFilePath)
+		})
+		It("ignores fields it does not know about", func() {
+			err := ioutil.WriteFile(configFilePath, []byte("backupversion: 9.9.9\nformatversion: 1\nnewoptionalfield: true\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			config := history.ReadConfigFile(configFilePath)
+			Expect(config.BackupVersion).To(Equal("9.9.9"))
+			Expect(config.UnknownRequiredSections()).To(BeEmpty())
+		})
+		It("reports required sections it does not know about", func() {
+			err := ioutil.WriteFile(configFilePath, []byte("backupversion: 9.9.9\nformatversion: 1\nrequiredsections: [timestamp, encryption]\nencryption: {}\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			config := history.ReadConfigFile(configFilePath)
+			Expect(config.UnknownRequiredSections()).To(Equal([]string{"encryption"}))
+		})
+		It("panics with the format version when a newer format cannot be parsed", func() {
+			err := ioutil.WriteFile(configFilePath, []byte("backupversion: 9.9.9\nformatversion: 2\ncompressed: {type: zstd}\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			defer testhelper.ShouldPanicWithMessage("backup was created by gpbackup 9.9.9 using config format 2; this gprestore supports up to format 1")
+			history.ReadConfigFile(configFilePath)
+		})
+	})
+	Describe("LoadBackupConfig", func() {
+		configFilePath := "/tmp/gpbackup_config.yaml"
+		AfterEach(func() {
+			_ = os.Remove(configFilePath)
+		})
+		It("reads a config file", func() {
+			err := ioutil.WriteFile(configFilePath, []byte("backupversion: 9.9.9\nformatversion: 1\ntimestamp: \"20170101010101\"\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			config, err := history.LoadBackupConfig(configFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Timestamp).To(Equal("20170101010101"))
+		})
+		It("reads the recorded session settings", func() {
+			err := ioutil.WriteFile(configFilePath, []byte("backupversion: 9.9.9\nformatversion: 1\nsessiongucs:\n  DateStyle: ISO, MDY\n  bytea_output: hex\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			config, err := history.LoadBackupConfig(configFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.SessionGUCs).To(Equal(map[string]string{"DateStyle": "ISO, MDY", "bytea_output": "hex"}))
+		})
+		It("returns an error when the file does not exist", func() {
+			_, err := history.LoadBackupConfig("/tmp/gpbackup_missing_config.yaml")
+			Expect(err).To(HaveOccurred())
+		})
+		It("returns an error with the format version when a newer format cannot be parsed", func() {
+			err := ioutil.WriteFile(configFilePath, []byte("backupversion: 9.9.9\nformatversion: 2\ncompressed: {type: zstd}\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = history.LoadBackupConfig(configFilePath)
+			Expect(err).To(MatchError("backup was created by gpbackup 9.9.9 using config format 2; this gprestore supports up to format 1"))
+		})
+	})
+	Describe("RunStats", func() {
+		It("computes throughput when the size is known", func() {
+			stats := history.NewRunStats()
+			stats.Bytes = 3000
+			stats.Complete(1500 * time.Millisecond)
+			Expect(stats.Duration).To(Equal(1.5))
+			Expect(stats.BytesPerSecond).To(Equal(float64(2000)))
+		})
+		It("leaves throughput unset when the size is unknown", func() {
+			stats := history.NewRunStats()
+			stats.Complete(2 * time.Second)
+			Expect(stats.Duration).To(Equal(float64(2)))
+			Expect(stats.BytesPerSecond).To(Equal(float64(0)))
+		})
+	})
+	Describe("NewHistory with statistics", func() {
+		It("parses entries written before statistics were recorded", func() {
+			err := ioutil.WriteFile(historyFilePath, []byte("backupconfigs:\n- timestamp: \"20170101010101\"\n  status: Success\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			resultHistory, err := history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs).To(HaveLen(1))
+			Expect(resultHistory.BackupConfigs[0].Stats).To(BeNil())
+			Expect(resultHistory.BackupConfigs[0].Restores).To(BeEmpty())
+		})
+	})
+	Describe("AddRestoreStats", func() {
+		It("records the restore under the successful entry for the backup", func() {
+			backupHistory := history.History{BackupConfigs: []history.BackupConfig{testConfigSucceed, testConfigFailed}}
+			err := backupHistory.WriteToFileAndMakeReadOnly(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+
+			stats := history.RunStats{RestoreTimestamp: "20170101010101", Database: "restoredb", SourceDatabase: "testdb", Status: history.BackupStatusSucceed, Tables: 2}
+			err = history.AddRestoreStats(historyFilePath, "timestampSucceed", stats)
+			Expect(err).ToNot(HaveOccurred())
+
+			resultHistory, err := history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs[0].Restores).To(Equal([]history.RunStats{stats}))
+			Expect(resultHistory.BackupConfigs[1].Restores).To(BeEmpty())
+		})
+		It("does not change the file when the backup has no entry", func() {
+			backupHistory := history.History{BackupConfigs: []history.BackupConfig{testConfigFailed}}
+			err := backupHistory.WriteToFileAndMakeReadOnly(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = history.AddRestoreStats(historyFilePath, "timestampFailed", history.RunStats{})
+			Expect(err).ToNot(HaveOccurred())
+
+			resultHistory, err := history.NewHistory(historyFilePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resultHistory.BackupConfigs[0].Restores).To(BeEmpty())
+		})
+	})
+	Describe("RecordOperation", func() {
+		var (
+			connectionPool *dbconn.DBConn
+			mock           sqlmock.Sqlmock
+			record         history.OperationRecord
+		)
+		BeforeEach(func() {
+			connectionPool, mock = testhelper.CreateAndConnectMockDB(1)
+			stats := history.NewRunStats()
+			stats.Duration = 12.5
+			stats.Tables = 3
+			stats.PhaseDurations["data"] = 10
+			record = history.OperationRecord{Operation: "backup", BackupTimestamp: "20200101010101", Database: "testdb", Status: history.BackupStatusSucceed,
+				StartTime: "20200101010101", EndTime: "20200101010114", Options: map[string]string{"jobs": "4"}, Stats: stats}
+		})
+		It("creates the schema and table and inserts the operation", func() {
+			mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("0"))
+			mock.ExpectExec("CREATE SCHEMA gpbackup").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT a.attname").WillReturnRows(sqlmock.NewRows([]string{"attname"}))
+			mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE gpbackup.gpbackup_history (\n\toperation text,")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO gpbackup.gpbackup_history (operation, backup_timestamp, restore_timestamp, database_name, status, start_time, end_time, duration_seconds, bytes, tables, errors, warnings, phase_seconds, options, source_database_name) VALUES ('backup', '20200101010101', NULL, 'testdb', 'Success', '2020-01-01 01:01:01', '2020-01-01 01:01:14', 12.5, NULL, 3, 0, 0, '{"data":10}', '{"jobs":"4"}', NULL)`)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			Expect(history.RecordOperation(connectionPool, "gpbackup", record)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("adds the columns missing from an existing table", func() {
+			mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("1"))
+			columns := sqlmock.NewRows([]string{"attname"})
+			for _, column := range history.HistoryTableColumns[:len(history.HistoryTableColumns)-1] {
+				columns.AddRow(column.Name)
+			}
+			mock.ExpectQuery("SELECT a.attname").WillReturnRows(columns)
+			mock.ExpectExec("ALTER TABLE gpbackup.gpbackup_history ADD COLUMN source_database_name text").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("INSERT INTO gpbackup.gpbackup_history").WillReturnResult(sqlmock.NewResult(0, 1))
+			Expect(history.RecordOperation(connectionPool, "gpbackup", record)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("records both the source and target database of a restore", func() {
+			record.Operation = "restore"
+			record.RestoreTimestamp = "20200102010101"
+			record.Database = "prod_copy"
+			record.SourceDatabase = "prod"
+			mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("1"))
+			columns := sqlmock.NewRows([]string{"attname"})
+			for _, column := range history.HistoryTableColumns {
+				columns.AddRow(column.Name)
+			}
+			mock.ExpectQuery("SELECT a.attname").WillReturnRows(columns)
+			mock.ExpectExec(regexp.QuoteMeta(`VALUES ('restore', '20200101010101', '20200102010101', 'prod_copy', 'Success', `) + ".*" +
+				regexp.QuoteMeta(`'{"jobs":"4"}', 'prod')`)).WillReturnResult(sqlmock.NewResult(0, 1))
+			Expect(history.RecordOperation(connectionPool, "gpbackup", record)).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("returns an error if the operation cannot be inserted", func() {
+			mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("1"))
+			mock.ExpectQuery("SELECT a.attname").WillReturnRows(sqlmock.NewRows([]string{"attname"}).AddRow("operation"))
+			for _, column := range history.HistoryTableColumns[1:] {
+				mock.ExpectExec("ALTER TABLE gpbackup.gpbackup_history ADD COLUMN " + column.Name).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectExec("INSERT INTO gpbackup.gpbackup_history").WillReturnError(errors.New("permission denied for relation gpbackup_history"))
+			Expect(history.RecordOperation(connectionPool, "gpbackup", record)).To(MatchError("permission denied for relation gpbackup_history"))
+		})
+	})
+	Describe("WriteStatsCSV", func() {
+		It("writes a row for each backup and restore, leaving unrecorded values empty", func() {
+			testConfigSucceed.Stats = &history.RunStats{Duration: 10, PhaseDurations: map[string]float64{"predata": 1.5, "data": 8}, Bytes: 5000, Tables: 3, Warnings: 1, BytesPerSecond: 500}
+			testConfigSucceed.Restores = []history.RunStats{{RestoreTimestamp: "20170101010101", Database: "restoredb", Status: history.BackupStatusFailed, Duration: 4, Bytes: -1, Tables: 1, Errors: 2}}
+			buffer := NewBuffer()
+
+			err := history.WriteStatsCSV(buffer, []history.BackupConfig{testConfigSucceed, testConfig1})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(string(buffer.Contents())).To(Equal(`type,backup_timestamp,restore_timestamp,database,status,duration_seconds,bytes,tables,errors,warnings,bytes_per_second,globals_seconds,predata_seconds,data_seconds,postdata_seconds,statistics_seconds
+backup,timestampSucceed,,testdb3,Success,10,5000,3,0,1,500,,1.5,8,,
+restore,timestampSucceed,20170101010101,restoredb,Failure,4,,1,2,0,,,,,,
+backup,timestamp1,,testdb1,,,,,,,,,,,,
+`))
+		})
+	})
 })
@@ -0,0 +1,146 @@
+package history
+
+/*
+ * This file contains the statistics recorded for each backup and restore in
+ * the history file, so that duration and size can be charted over time
+ * without reading report files.
+ */
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The phases timed for both backups and restores, in the order they run
+var StatsPhases = []string{"globals", "predata", "data", "postdata", "statistics"}
+
+/*
+ * Durations are in seconds.  Bytes is the size of the backup set, written by
+ * a backup or read by a restore, and is -1 if it is unknown, as for backups
+ * written through a plugin.  RestoreTimestamp, Database, SourceDatabase, and
+ * Status are only set for restores, as a backup's own entry already records
+ * them.  Database is the database restored into, and SourceDatabase the one
+ * backed up.
+ */
+type RunStats struct {
+	RestoreTimestamp string `yaml:",omitempty"`
+	Database         string `yaml:",omitempty"`
+	SourceDatabase   string `yaml:",omitempty"`
+	Status           string `yaml:",omitempty"`
+	Duration         float64
+	PhaseDurations   map[string]float64 `yaml:",omitempty"`
+	Bytes            int64
+	Tables           int
+	Errors           int
+	Warnings         int
+	BytesPerSecond   float64
+}
+
+func NewRunStats() *RunStats {
+	return &RunStats{PhaseDurations: make(map[string]float64), Bytes: -1}
+}
+
+func roundSeconds(duration time.Duration) float64 {
+	return math.Round(duration.Seconds()*1000) / 1000
+}
+
+func (stats *RunStats) RecordPhase(phase string, start time.Time) {
+	stats.PhaseDurations[phase] += roundSeconds(time.Since(start))
+}
+
+func (stats *RunStats) Complete(duration time.Duration) {
+	stats.Duration = roundSeconds(duration)
+	if stats.Bytes >= 0 && stats.Duration > 0 {
+		stats.BytesPerSecond = math.Round(float64(stats.Bytes) / stats.Duration)
+	}
+}
+
+/*
+ * Restores are recorded under the entry of the backup they restored.  A
+ * backup with no successful entry in the history file is not recorded.
+ */
+func AddRestoreStats(historyFilePath string, backupTimestamp string, stats RunStats) error {
+	lock := lockHistoryFile()
+	defer func() {
+		_ = lock.Unlock()
+	}()
+
+	history, err := NewHistory(historyFilePath)
+	if err != nil {
+		return err
+	}
+	found := false
+	for i := range history.BackupConfigs {
+		if history.BackupConfigs[i].Timestamp == backupTimestamp && !history.BackupConfigs[i].Failed() {
+			history.BackupConfigs[i].Restores = append(history.BackupConfigs[i].Restores, stats)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	return history.WriteToFileAndMakeReadOnly(historyFilePath)
+}
+
+func formatStatsRow(rowType string, backupTimestamp string, database string, status string, stats *RunStats) []string {
+	row := []string{rowType, backupTimestamp, "", database, status}
+	if stats == nil {
+		// Entries written before statistics were recorded
+		return append(row, make([]string, 6+len(StatsPhases))...)
+	}
+	row[2] = stats.RestoreTimestamp
+	bytesStr := ""
+	if stats.Bytes >= 0 {
+		bytesStr = strconv.FormatInt(stats.Bytes, 10)
+	}
+	throughputStr := ""
+	if stats.BytesPerSecond > 0 {
+		throughputStr = strconv.FormatFloat(stats.BytesPerSecond, 'f', -1, 64)
+	}
+	row = append(row, strconv.FormatFloat(stats.Duration, 'f', -1, 64), bytesStr, strconv.Itoa(stats.Tables),
+		strconv.Itoa(stats.Errors), strconv.Itoa(stats.Warnings), throughputStr)
+	for _, phase := range StatsPhases {
+		phaseStr := ""
+		if duration, ok := stats.PhaseDurations[phase]; ok {
+			phaseStr = strconv.FormatFloat(duration, 'f', -1, 64)
+		}
+		row = append(row, phaseStr)
+	}
+	return row
+}
+
+/*
+ * Writes one row for each backup and for each restore recorded under it.
+ * Values that were not recorded are left empty.
+ */
+func WriteStatsCSV(writer io.Writer, backupConfigs []BackupConfig) error {
+	csvWriter := csv.NewWriter(writer)
+	header := []string{"type", "backup_timestamp", "restore_timestamp", "database", "status", "duration_seconds",
+		"bytes", "tables", "errors", "warnings", "bytes_per_second"}
+	for _, phase := range StatsPhases {
+		header = append(header, phase+"_seconds")
+	}
+	err := csvWriter.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, config := range backupConfigs {
+		err = csvWriter.Write(formatStatsRow("backup", config.Timestamp, config.DatabaseName, config.Status, config.Stats))
+		if err != nil {
+			return err
+		}
+		for i := range config.Restores {
+			restore := config.Restores[i]
+			err = csvWriter.Write(formatStatsRow("restore", config.Timestamp, restore.Database, restore.Status, &restore))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
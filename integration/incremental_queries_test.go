@@ -263,4 +263,24 @@ var _ = Describe("backup integration tests", func() {
 			})
 		})
 	})
+	Describe("GetHeapIncrementalMetadata", func() {
+		var heapTableFQN = "public.heap_foo"
+		BeforeEach(func() {
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf("CREATE TABLE %s (i int)", heapTableFQN))
+		})
+		AfterEach(func() {
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf(dropTableSQL, heapTableFQN))
+		})
+		It("retrieves the relfilenode of each table", func() {
+			heapIncrementalMetadata := backup.GetHeapIncrementalMetadata(connectionPool)
+			Expect(heapIncrementalMetadata).To(HaveKey(heapTableFQN))
+			Expect(heapIncrementalMetadata[heapTableFQN].RelFileNode).ToNot(Equal(uint32(0)))
+		})
+		It("retrieves a different relfilenode after a table is truncated", func() {
+			initialHeapIncrementalMetadata := backup.GetHeapIncrementalMetadata(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, fmt.Sprintf("TRUNCATE %s", heapTableFQN))
+			heapIncrementalMetadata := backup.GetHeapIncrementalMetadata(connectionPool)
+			Expect(heapIncrementalMetadata[heapTableFQN].RelFileNode).ToNot(Equal(initialHeapIncrementalMetadata[heapTableFQN].RelFileNode))
+		})
+	})
 })
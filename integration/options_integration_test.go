@@ -1,11 +1,14 @@
 package integration
 
 import (
+	"fmt"
 	"sort"
 
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -44,6 +47,16 @@ var _ = Describe("Options Integration", func() {
 			Expect(expected).To(Equal(resultFQNs))
 		})
 	})
+	Describe("QuoteIdentifier", func() {
+		It("quotes identifiers the way quote_ident does", func() {
+			identifiers := []string{"bar", "BAR", "Order", "select", "user", "integer", "left", "distributed", "action", "2", "_bar",
+				"'bar", `"bar`, `~#$%^&*()_-+[]{}><\|;:/?!,.`, "\tbar", "\nbar", "tablé", ""}
+			for _, ident := range identifiers {
+				expected := dbconn.MustSelectString(connectionPool, fmt.Sprintf("SELECT quote_ident('%s') AS string", utils.EscapeSingleQuotes(ident)))
+				Expect(utils.QuoteIdentifier(ident)).To(Equal(expected))
+			}
+		})
+	})
 	Describe("ValidateFilterTables", func() {
 		It("validates special chars", func() {
 			createSpecialCharacterTables := `
@@ -279,4 +292,24 @@ FORMAT 'csv';`)
 			Expect(tables).To(Equal(expectedTableNames))
 		})
 	})
+	Describe("ExpandIncludesForSequences", func() {
+		It("adds sequences owned by or used in defaults of included tables", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE SEQUENCE public.shared_seq")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP SEQUENCE public.shared_seq")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.orders (id serial, ref int DEFAULT nextval('public.shared_seq'))")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.orders")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.items (ref int DEFAULT nextval('public.shared_seq'))")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.items")
+
+			err := backupCmdFlags.Set(options.INCLUDE_RELATION, "public.orders")
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(backupCmdFlags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			added, err := subject.ExpandIncludesForSequences(connectionPool, backupCmdFlags)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(added).To(Equal([]string{"public.orders_id_seq", "public.shared_seq"}))
+			Expect(subject.GetIncludedTables()).To(Equal([]string{"public.orders", "public.orders_id_seq", "public.shared_seq"}))
+		})
+	})
 })
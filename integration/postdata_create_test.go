@@ -3,6 +3,7 @@ package integration
 import (
 	"database/sql"
 
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/structmatcher"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
@@ -48,6 +49,7 @@ var _ = Describe("backup integration create statement tests", func() {
 		It("creates an index used for clustering", func() {
 			indexes := []backup.IndexDefinition{{Oid: 0, Name: "index1", OwningSchema: "public", OwningTable: "testtable", Def: sql.NullString{String: "CREATE INDEX index1 ON public.testtable USING btree (i)", Valid: true}, IsClustered: true}}
 			backup.PrintCreateIndexStatements(backupfile, tocfile, indexes, indexMetadataMap)
+			backup.PrintClusterStatements(backupfile, tocfile, indexes)
 
 			//Create table whose columns we can index
 			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.testtable(i int)")
@@ -59,6 +61,41 @@ var _ = Describe("backup integration create statement tests", func() {
 			Expect(resultIndexes).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatchExcluding(&resultIndexes[0], &indexes[0], "Oid")
 		})
+		It("clusters a table on the same index after a round trip when it has several indexes", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.testtable(i int, j int)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.testtable")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE INDEX index1 ON public.testtable(i)")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE INDEX index2 ON public.testtable(j)")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.testtable CLUSTER ON index2")
+
+			indexes := backup.GetIndexes(connectionPool)
+			backup.PrintCreateIndexStatements(backupfile, tocfile, indexes, indexMetadataMap)
+			backup.PrintClusterStatements(backupfile, tocfile, indexes)
+			testhelper.AssertQueryRuns(connectionPool, "DROP INDEX public.index1")
+			testhelper.AssertQueryRuns(connectionPool, "DROP INDEX public.index2")
+
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+
+			resultIndexes := backup.GetIndexes(connectionPool)
+			Expect(resultIndexes).To(HaveLen(2))
+			for _, index := range resultIndexes {
+				Expect(index.IsClustered).To(Equal(index.Name == "index2"))
+			}
+		})
+		It("clusters a table on its primary key after a round trip", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.testtable(i int PRIMARY KEY)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.testtable")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.testtable CLUSTER ON testtable_pkey")
+
+			indexes := backup.GetIndexes(connectionPool)
+			backup.PrintClusterStatements(backupfile, tocfile, indexes)
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.testtable SET WITHOUT CLUSTER")
+
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+
+			isClustered := dbconn.MustSelectString(connectionPool, "SELECT indisclustered::text AS string FROM pg_index WHERE indexrelid = 'public.testtable_pkey'::regclass")
+			Expect(isClustered).To(Equal("true"))
+		})
 		It("creates an index with a comment", func() {
 			indexes := []backup.IndexDefinition{{Oid: 1, Name: "index1", OwningSchema: "public", OwningTable: "testtable", Def: sql.NullString{String: "CREATE INDEX index1 ON public.testtable USING btree (i)", Valid: true}}}
 			indexMetadataMap = testutils.DefaultMetadataMap("INDEX", false, false, true, false)
@@ -104,6 +141,9 @@ var _ = Describe("backup integration create statement tests", func() {
 			index.IsReplicaIdentity = true
 			indexes := []backup.IndexDefinition{index}
 			backup.PrintCreateIndexStatements(backupfile, tocfile, indexes, indexMetadataMap)
+			table := backup.Table{Relation: backup.Relation{Schema: "public", Name: "testtable"},
+				TableDefinition: backup.TableDefinition{ReplicaIdentity: "i", ReplicaIdentityIndex: "index1"}}
+			backup.PrintReplicaIdentityStatements(backupfile, tocfile, []backup.Table{table})
 
 			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.testtable(i int NOT NULL)")
 			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.testtable")
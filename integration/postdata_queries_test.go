@@ -185,6 +185,17 @@ PARTITION BY RANGE (date)
 
 			structmatcher.ExpectStructsToMatchExcluding(&index1, &results[0], "Oid")
 		})
+		It("returns no indexes on an excluded table", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.simple_table(i int, j int, k int)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.simple_table")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE INDEX simple_table_idx1 ON public.simple_table(i)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP INDEX public.simple_table_idx1")
+			_ = backupCmdFlags.Set(options.EXCLUDE_RELATION, "public.simple_table")
+
+			results := backup.GetIndexes(connectionPool)
+
+			Expect(results).To(BeEmpty())
+		})
 		It("returns a slice for an index used for clustering", func() {
 			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.simple_table(i int, j int, k int)")
 			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.simple_table")
@@ -277,6 +288,17 @@ PARTITION BY RANGE (date)
 			Expect(results).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatchExcluding(&rule1, &results[0], "Oid")
 		})
+		It("returns no rules on an excluded table", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.rule_table1(i int)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.rule_table1")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE RULE double_insert AS ON INSERT TO public.rule_table1 DO INSERT INTO public.rule_table1 (i) VALUES (1)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP RULE double_insert ON public.rule_table1")
+			_ = backupCmdFlags.Set(options.EXCLUDE_RELATION, "public.rule_table1")
+
+			results := backup.GetRules(connectionPool)
+
+			Expect(results).To(BeEmpty())
+		})
 	})
 	Describe("GetTriggers", func() {
 		It("returns no slice when no trigger exists", func() {
@@ -354,6 +376,29 @@ PARTITION BY RANGE (date)
 			Expect(results).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatchExcluding(&trigger1, &results[0], "Oid")
 		})
+		It("returns no triggers on an excluded table", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.trigger_table1(i int)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.trigger_table1")
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TRIGGER sync_trigger_table1 AFTER INSERT OR DELETE OR UPDATE ON public.trigger_table1 FOR EACH STATEMENT EXECUTE PROCEDURE "RI_FKey_check_ins"()`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TRIGGER sync_trigger_table1 ON public.trigger_table1")
+			_ = backupCmdFlags.Set(options.EXCLUDE_RELATION, "public.trigger_table1")
+
+			results := backup.GetTriggers(connectionPool)
+
+			Expect(results).To(BeEmpty())
+		})
+		It("returns no triggers on a child partition of an excluded table", func() {
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.part (id int, year int) DISTRIBUTED BY (id)
+PARTITION BY RANGE (year) (START (2007) END (2009) EVERY (1))`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.part")
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TRIGGER part_trigger AFTER INSERT OR DELETE OR UPDATE ON public.part_1_prt_1 FOR EACH STATEMENT EXECUTE PROCEDURE "RI_FKey_check_ins"()`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TRIGGER part_trigger ON public.part_1_prt_1")
+			_ = backupCmdFlags.Set(options.EXCLUDE_RELATION, "public.part")
+
+			results := backup.GetTriggers(connectionPool)
+
+			Expect(results).To(BeEmpty())
+		})
 	})
 	Describe("GetEventTriggers", func() {
 		BeforeEach(func() {
@@ -846,6 +846,29 @@ LANGUAGE SQL`)
 			Expect(resultDefaultPrivileges).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatchExcluding(&expectedDefaultPrivileges, &resultDefaultPrivileges[0], "Oid")
 		})
+		It("does not return default privileges in an excluded schema", func() {
+			testhelper.AssertQueryRuns(connectionPool, "ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE ON SEQUENCES TO testrole;")
+			defer testhelper.AssertQueryRuns(connectionPool, "ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE USAGE ON SEQUENCES FROM testrole;")
+			_ = backupCmdFlags.Set(options.EXCLUDE_SCHEMA, "public")
+
+			resultDefaultPrivileges := backup.GetDefaultPrivileges(connectionPool)
+
+			Expect(resultDefaultPrivileges).To(BeEmpty())
+		})
+		It("returns only default privileges in an included schema", func() {
+			testhelper.AssertQueryRuns(connectionPool, "ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE ON SEQUENCES TO testrole;")
+			defer testhelper.AssertQueryRuns(connectionPool, "ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE USAGE ON SEQUENCES FROM testrole;")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER DEFAULT PRIVILEGES GRANT SELECT ON TABLES TO PUBLIC;")
+			defer testhelper.AssertQueryRuns(connectionPool, "ALTER DEFAULT PRIVILEGES REVOKE SELECT ON TABLES FROM PUBLIC;")
+			_ = backupCmdFlags.Set(options.INCLUDE_SCHEMA, "public")
+
+			resultDefaultPrivileges := backup.GetDefaultPrivileges(connectionPool)
+
+			privs := []backup.ACL{{Grantee: "testrole", Usage: true}}
+			expectedDefaultPrivileges := backup.DefaultPrivileges{Schema: "public", Privileges: privs, ObjectType: "S", Owner: "testrole"}
+			Expect(resultDefaultPrivileges).To(HaveLen(1))
+			structmatcher.ExpectStructsToMatchExcluding(&expectedDefaultPrivileges, &resultDefaultPrivileges[0], "Oid")
+		})
 
 	})
 	Describe("GetCommentsForObjectType", func() {
@@ -59,6 +59,22 @@ MODIFIES SQL DATA
 			structmatcher.ExpectStructsToMatchExcluding(&results[0], &addFunction, "Oid")
 			structmatcher.ExpectStructsToMatchExcluding(&results[1], &appendFunction, "Oid")
 		})
+		It("returns every SET clause of a SECURITY DEFINER function with a fixed search_path", func() {
+			testhelper.AssertQueryRuns(connectionPool, `CREATE FUNCTION public.secdef_add(integer, integer) RETURNS integer
+AS 'SELECT $1 + $2'
+LANGUAGE SQL
+SECURITY DEFINER
+SET search_path = pg_catalog, pg_temp
+SET work_mem = '64MB'`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP FUNCTION public.secdef_add(integer, integer)")
+
+			results := backup.GetFunctions(connectionPool)
+
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].IsSecurityDefiner).To(BeTrue())
+			Expect(results[0].Config).To(Equal(`SET search_path TO 'pg_catalog', 'pg_temp'
+SET work_mem TO '64MB'`))
+		})
 		It("returns a slice of functions in a specific schema", func() {
 			testhelper.AssertQueryRuns(connectionPool, `CREATE FUNCTION public.add(integer, integer) RETURNS integer
 AS 'SELECT $1 + $2'
@@ -406,6 +422,22 @@ CREATE AGGREGATE public.agg_prefunc(numeric, numeric) (
 			Expect(result).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatchExcluding(&result[0], &aggregateDef, "Oid")
 		})
+		It("returns an aggregate with a mixed-case name", func() {
+			testhelper.AssertQueryRuns(connectionPool, `
+CREATE AGGREGATE public."Agg_Prefunc"(numeric, numeric) (
+	SFUNC = public.mysfunc_accum,
+	STYPE = numeric,
+	PREFUNC = public.mypre_accum,
+	INITCOND = 0 );
+`)
+			defer testhelper.AssertQueryRuns(connectionPool, `DROP AGGREGATE public."Agg_Prefunc"(numeric, numeric)`)
+
+			result := backup.GetAggregates(connectionPool)
+
+			Expect(result).To(HaveLen(1))
+			Expect(result[0].Name).To(Equal(`"Agg_Prefunc"`))
+			Expect(result[0].FQN()).To(Equal(`public."Agg_Prefunc"(numeric, numeric)`))
+		})
 		It("returns a slice of aggregates in a specific schema", func() {
 			testhelper.AssertQueryRuns(connectionPool, `
 CREATE AGGREGATE public.agg_prefunc(numeric, numeric) (
@@ -678,6 +710,26 @@ LANGUAGE SQL`)
 			structmatcher.ExpectStructsToMatchExcluding(&plperlDef, &results[0], "Oid")
 		})
 	})
+	Describe("GetAddedExtensionMembers", func() {
+		It("returns functions added to an extension but not the extension's own members", func() {
+			testutils.SkipIfBefore6(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, "CREATE FUNCTION public.before_ext() RETURNS integer AS 'SELECT 1' LANGUAGE SQL")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE EXTENSION plperl")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP EXTENSION plperl")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE FUNCTION public.after_ext(integer) RETURNS integer AS 'SELECT $1' LANGUAGE SQL")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER EXTENSION plperl ADD FUNCTION public.before_ext()")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER EXTENSION plperl ADD FUNCTION public.after_ext(integer)")
+
+			results := backup.GetAddedExtensionMembers(connectionPool)
+
+			Expect(results).To(Equal([]backup.ExtensionMember{
+				{ExtensionName: "plperl", Schema: "public", ObjectType: "FUNCTION", Identity: "public.after_ext(integer)"},
+				{ExtensionName: "plperl", Schema: "public", ObjectType: "FUNCTION", Identity: "public.before_ext()"},
+			}))
+			functions := backup.GetFunctions(connectionPool)
+			Expect(functions).To(HaveLen(2))
+		})
+	})
 	Describe("GetProceduralLanguages", func() {
 		It("returns a slice of procedural languages", func() {
 			testhelper.AssertQueryRuns(connectionPool, "CREATE LANGUAGE plpythonu")
@@ -77,6 +77,34 @@ var _ = Describe("backup integration create statement tests", func() {
 			resultTable := backup.ConstructDefinitionsForTables(connectionPool, []backup.Relation{testTable.Relation})[0]
 			structmatcher.ExpectStructsToMatchExcluding(testTable.TableDefinition, resultTable.TableDefinition, "ColumnDefs.Oid", "ExtTableDef")
 		})
+		It("creates a table with GENERATED ALWAYS and GENERATED BY DEFAULT identity columns", func() {
+			testutils.SkipIfBefore7(connectionPool)
+			rowOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", NotNull: true, Type: "integer", StatTarget: -1, Identity: "a", IdentitySequence: "public.testtable_i_seq",
+				IdentityStart: 10, IdentityIncrement: 5, IdentityMin: 1, IdentityMax: 1000, IdentityCache: 1}
+			rowTwo := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", NotNull: true, Type: "bigint", StatTarget: -1, Identity: "d", IdentitySequence: "public.testtable_j_seq",
+				IdentityStart: -1, IdentityIncrement: -1, IdentityMin: -9223372036854775808, IdentityMax: -1, IdentityCache: 20, IdentityCycle: true}
+			testTable.ColumnDefs = []backup.ColumnDefinition{rowOne, rowTwo}
+
+			backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+			testTable.Oid = testutils.OidFromObjectName(connectionPool, "public", "testtable", backup.TYPE_RELATION)
+			resultTable := backup.ConstructDefinitionsForTables(connectionPool, []backup.Relation{testTable.Relation})[0]
+			structmatcher.ExpectStructsToMatchExcluding(testTable.TableDefinition, resultTable.TableDefinition, "ColumnDefs.Oid", "ExtTableDef")
+		})
+		It("creates a table with a stored generated column", func() {
+			testutils.SkipIfBefore7(connectionPool)
+			rowOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1}
+			rowTwo := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", HasDefault: true, Type: "integer", StatTarget: -1, DefaultVal: "(i * 2)", GenerationExpr: "(i * 2)"}
+			testTable.ColumnDefs = []backup.ColumnDefinition{rowOne, rowTwo}
+
+			backup.PrintRegularTableCreateStatement(backupfile, tocfile, testTable)
+
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+			testTable.Oid = testutils.OidFromObjectName(connectionPool, "public", "testtable", backup.TYPE_RELATION)
+			resultTable := backup.ConstructDefinitionsForTables(connectionPool, []backup.Relation{testTable.Relation})[0]
+			structmatcher.ExpectStructsToMatchExcluding(testTable.TableDefinition, resultTable.TableDefinition, "ColumnDefs.Oid", "ExtTableDef")
+		})
 		It("creates a complex heap table", func() {
 			rowOneDefault := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", NotNull: false, HasDefault: true, Type: "integer", Encoding: "", StatTarget: -1, StorageType: "", DefaultVal: "42", Comment: ""}
 			rowNotNullDefault := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", NotNull: true, HasDefault: true, Type: "character varying(20)", Encoding: "", StatTarget: -1, StorageType: "", DefaultVal: "'bar'::text", Comment: ""}
@@ -370,7 +398,8 @@ SET SUBPARTITION TEMPLATE ` + `
 		})
 		It("prints column level privileges", func() {
 			testutils.SkipIfBefore6(connectionPool)
-			privilegesColumnOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, Privileges: sql.NullString{String: "testrole=r/testrole", Valid: true}}
+			privilegesColumnOne := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1, Privileges: sql.NullString{String: "testrole=r/testrole", Valid: true},
+				ACL: []backup.ACL{{Grantee: "testrole", Grantor: "testrole", Select: true}}}
 			tableMetadata.Owner = "testrole"
 			testTable.ColumnDefs = []backup.ColumnDefinition{privilegesColumnOne}
 			backup.PrintPostCreateTableStatements(backupfile, tocfile, testTable, tableMetadata)
@@ -161,6 +161,34 @@ PARTITION BY LIST (gender)
 			structmatcher.ExpectStructsToMatchIncluding(&tableFoo, &tables[0], "Name", "Schema")
 		})
 	})
+	Describe("GetOrphanedPartitionTables", func() {
+		BeforeEach(func() {
+			testutils.SkipIfBefore6(connectionPool)
+		})
+		It("returns a child partition whose partition rule was removed", func() {
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.part_table (id int, year int)
+DISTRIBUTED BY (id)
+PARTITION BY RANGE (year)
+( START (2015) END (2017) EVERY (1) )`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.part_table")
+			testhelper.AssertQueryRuns(connectionPool, "DELETE FROM pg_partition_rule WHERE parchildrelid = 'public.part_table_1_prt_2'::regclass")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.part_table_1_prt_2")
+			orphanOid := testutils.OidFromObjectName(connectionPool, "public", "part_table_1_prt_2", backup.TYPE_RELATION)
+
+			orphans := backup.GetOrphanedPartitionTables(connectionPool)
+
+			Expect(orphans).To(Equal([]backup.Relation{{SchemaOid: 2200, Oid: orphanOid, Schema: "public", Name: "part_table_1_prt_2"}}))
+		})
+		It("returns nothing for a partition table whose children are all reachable", func() {
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.part_table (id int, year int)
+DISTRIBUTED BY (id)
+PARTITION BY RANGE (year)
+( START (2015) END (2017) EVERY (1) )`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.part_table")
+
+			Expect(backup.GetOrphanedPartitionTables(connectionPool)).To(BeEmpty())
+		})
+	})
 	Describe("GetAllSequenceRelations", func() {
 		It("returns a slice of all sequences", func() {
 			testhelper.AssertQueryRuns(connectionPool, "CREATE SEQUENCE public.my_sequence START 10")
@@ -292,6 +320,17 @@ PARTITION BY LIST (gender)
 
 			structmatcher.ExpectStructsToMatch(&expectedSequence, &resultSequenceDef)
 		})
+		It("returns the data type and options of a non-bigint sequence", func() {
+			testutils.SkipIfBefore7(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool,
+				"CREATE SEQUENCE public.my_sequence AS smallint INCREMENT BY -2 MINVALUE -500 MAXVALUE 50 START 10 CACHE 3 CYCLE")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP SEQUENCE public.my_sequence")
+
+			resultSequenceDef := backup.GetSequenceDefinition(connectionPool, "public.my_sequence")
+
+			expectedSequence := backup.SequenceDefinition{LastVal: 10, StartVal: 10, Increment: -2, MaxVal: 50, MinVal: -500, CacheVal: 3, IsCycled: true, IsCalled: false, Type: "smallint"}
+			structmatcher.ExpectStructsToMatch(&expectedSequence, &resultSequenceDef)
+		})
 	})
 	Describe("Get sequence owner information", func() {
 		It("returns sequence information for sequences owned by columns", func() {
@@ -379,6 +418,15 @@ PARTITION BY LIST (gender)
 			structmatcher.ExpectStructsToMatchExcluding(&seqTwoRelation, &results[1].Relation, "SchemaOid", "Oid")
 			structmatcher.ExpectStructsToMatchExcluding(&seqTwoDef, &results[1].Definition)
 		})
+		It("does not return sequences backing identity columns", func() {
+			testutils.SkipIfBefore7(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.identitytable (i integer GENERATED ALWAYS AS IDENTITY)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.identitytable")
+
+			results := backup.GetAllSequences(connectionPool)
+
+			Expect(results).To(BeEmpty())
+		})
 	})
 	Describe("GetAllViews", func() {
 		var viewDef sql.NullString
@@ -64,11 +64,11 @@ var _ = Describe("backup integration create statement tests", func() {
 			conMetadataMap           backup.MetadataMap
 		)
 		BeforeEach(func() {
-			uniqueConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "uniq2", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (a, b)", Valid: true}, OwningObject: "public.testtable", IsDomainConstraint: false, IsPartitionParent: false}
-			pkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "constraints_other_table_pkey", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (b)", Valid: true}, OwningObject: "public.constraints_other_table", IsDomainConstraint: false, IsPartitionParent: false}
-			fkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "fk1", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (b) REFERENCES public.constraints_other_table(b)", Valid: true}, OwningObject: "public.testtable", IsDomainConstraint: false, IsPartitionParent: false}
-			checkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (a <> 42)", Valid: true}, OwningObject: "public.testtable", IsDomainConstraint: false, IsPartitionParent: false}
-			partitionCheckConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (id <> 0)", Valid: true}, OwningObject: "public.part", IsDomainConstraint: false, IsPartitionParent: true}
+			uniqueConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "uniq2", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (a, b)", Valid: true}, IsValid: true, OwningObject: "public.testtable", IsDomainConstraint: false, IsPartitionParent: false}
+			pkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "constraints_other_table_pkey", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (b)", Valid: true}, IsValid: true, OwningObject: "public.constraints_other_table", IsDomainConstraint: false, IsPartitionParent: false}
+			fkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "fk1", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (b) REFERENCES public.constraints_other_table(b)", Valid: true}, IsValid: true, OwningObject: "public.testtable", ReferencedObject: "public.constraints_other_table", IsDomainConstraint: false, IsPartitionParent: false}
+			checkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (a <> 42)", Valid: true}, IsValid: true, OwningObject: "public.testtable", IsDomainConstraint: false, IsPartitionParent: false}
+			partitionCheckConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (id <> 0)", Valid: true}, IsValid: true, OwningObject: "public.part", IsDomainConstraint: false, IsPartitionParent: true}
 			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.testtable(a int, b text) DISTRIBUTED BY (b)")
 			conMetadataMap = backup.MetadataMap{}
 
@@ -94,6 +94,22 @@ var _ = Describe("backup integration create statement tests", func() {
 			Expect(resultConstraints).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatchExcluding(&uniqueConstraint, &resultConstraints[0], "Oid")
 		})
+		It("creates an exclusion constraint with its access method and operator class", func() {
+			testutils.SkipIfBefore6(connectionPool)
+			exclusionConstraint := backup.Constraint{Oid: 0, Schema: "public", Name: "excl1", ConType: "x", ConDef: sql.NullString{String: "EXCLUDE USING btree (b text_pattern_ops WITH =) WHERE ((a > 0))", Valid: true}, IsValid: true, ConIsLocal: true, OwningObject: "public.testtable", IsDomainConstraint: false, IsPartitionParent: false}
+			constraints := []backup.Constraint{exclusionConstraint}
+			backup.PrintConstraintStatements(backupfile, tocfile, constraints, conMetadataMap)
+
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+
+			resultConstraints := backup.GetConstraints(connectionPool)
+
+			Expect(resultConstraints).To(HaveLen(1))
+			structmatcher.ExpectStructsToMatchExcluding(&exclusionConstraint, &resultConstraints[0], "Oid")
+			resultIndexes := backup.GetIndexes(connectionPool)
+			Expect(resultIndexes).To(HaveLen(1))
+			Expect(resultIndexes[0].SupportsConstraint).To(BeTrue())
+		})
 		It("creates a primary key constraint", func() {
 			constraints := []backup.Constraint{pkConstraint}
 			backup.PrintConstraintStatements(backupfile, tocfile, constraints, conMetadataMap)
@@ -121,6 +137,43 @@ var _ = Describe("backup integration create statement tests", func() {
 			structmatcher.ExpectStructsToMatchExcluding(&pkConstraint, &resultConstraints[0], "Oid")
 			structmatcher.ExpectStructsToMatchExcluding(&fkConstraint, &resultConstraints[1], "Oid")
 		})
+		It("creates a deferrable foreign key constraint", func() {
+			testutils.SkipIfBefore6(connectionPool)
+			fkConstraint.ConDef.String = "FOREIGN KEY (b) REFERENCES public.constraints_other_table(b) DEFERRABLE INITIALLY DEFERRED"
+			fkConstraint.ConDeferrable = true
+			fkConstraint.ConDeferred = true
+			constraints := []backup.Constraint{fkConstraint}
+			backup.PrintConstraintStatements(backupfile, tocfile, constraints, conMetadataMap)
+			Expect(buffer.String()).To(ContainSubstring("ADD CONSTRAINT fk1 FOREIGN KEY (b) REFERENCES public.constraints_other_table(b) DEFERRABLE INITIALLY DEFERRED;"))
+
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.constraints_other_table(b text PRIMARY KEY)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.constraints_other_table CASCADE")
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+
+			resultConstraints := backup.GetConstraints(connectionPool)
+
+			Expect(resultConstraints).To(HaveLen(2))
+			structmatcher.ExpectStructsToMatchExcluding(&pkConstraint, &resultConstraints[0], "Oid")
+			structmatcher.ExpectStructsToMatchExcluding(&fkConstraint, &resultConstraints[1], "Oid")
+		})
+		It("creates a deferrable foreign key constraint that is initially immediate", func() {
+			testutils.SkipIfBefore6(connectionPool)
+			fkConstraint.ConDef.String = "FOREIGN KEY (b) REFERENCES public.constraints_other_table(b) DEFERRABLE"
+			fkConstraint.ConDeferrable = true
+			constraints := []backup.Constraint{fkConstraint}
+			backup.PrintConstraintStatements(backupfile, tocfile, constraints, conMetadataMap)
+			Expect(buffer.String()).To(ContainSubstring("ADD CONSTRAINT fk1 FOREIGN KEY (b) REFERENCES public.constraints_other_table(b) DEFERRABLE INITIALLY IMMEDIATE;"))
+
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.constraints_other_table(b text PRIMARY KEY)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.constraints_other_table CASCADE")
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+
+			resultConstraints := backup.GetConstraints(connectionPool)
+
+			Expect(resultConstraints).To(HaveLen(2))
+			structmatcher.ExpectStructsToMatchExcluding(&pkConstraint, &resultConstraints[0], "Oid")
+			structmatcher.ExpectStructsToMatchExcluding(&fkConstraint, &resultConstraints[1], "Oid")
+		})
 		It("creates a check constraint", func() {
 			constraints := []backup.Constraint{checkConstraint}
 			backup.PrintConstraintStatements(backupfile, tocfile, constraints, conMetadataMap)
@@ -132,6 +185,21 @@ var _ = Describe("backup integration create statement tests", func() {
 			Expect(resultConstraints).To(HaveLen(1))
 			structmatcher.ExpectStructsToMatchExcluding(&checkConstraint, &resultConstraints[0], "Oid")
 		})
+		It("creates a check constraint that is not validated", func() {
+			testutils.SkipIfBefore6(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, "INSERT INTO public.testtable VALUES (42, 'a')")
+			checkConstraint.IsValid = false
+			constraints := []backup.Constraint{checkConstraint}
+			backup.PrintConstraintStatements(backupfile, tocfile, constraints, conMetadataMap)
+
+			testhelper.AssertQueryRuns(connectionPool, buffer.String())
+
+			resultConstraints := backup.GetConstraints(connectionPool)
+
+			checkConstraint.ConDef.String = "CHECK (a <> 42) NOT VALID"
+			Expect(resultConstraints).To(HaveLen(1))
+			structmatcher.ExpectStructsToMatchExcluding(&checkConstraint, &resultConstraints[0], "Oid")
+		})
 		It("creates multiple constraints on one table", func() {
 			constraints := []backup.Constraint{checkConstraint, uniqueConstraint, fkConstraint}
 			backup.PrintConstraintStatements(backupfile, tocfile, constraints, conMetadataMap)
@@ -151,7 +219,7 @@ var _ = Describe("backup integration create statement tests", func() {
 		It("doesn't create a check constraint on a domain", func() {
 			testhelper.AssertQueryRuns(connectionPool, "CREATE DOMAIN public.domain1 AS numeric")
 			defer testhelper.AssertQueryRuns(connectionPool, "DROP DOMAIN public.domain1")
-			domainCheckConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (VALUE <> 42::numeric)", Valid: true}, OwningObject: "public.domain1", IsDomainConstraint: true, IsPartitionParent: false}
+			domainCheckConstraint := backup.Constraint{Oid: 0, Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (VALUE <> 42::numeric)", Valid: true}, IsValid: true, OwningObject: "public.domain1", IsDomainConstraint: true, IsPartitionParent: false}
 			constraints := []backup.Constraint{domainCheckConstraint}
 			backup.PrintConstraintStatements(backupfile, tocfile, constraints, conMetadataMap)
 
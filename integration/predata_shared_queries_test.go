@@ -90,13 +90,13 @@ var _ = Describe("backup integration tests", func() {
 		)
 
 		BeforeEach(func() {
-			uniqueConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "uniq2", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (a, b)", Valid: true}, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
-			fkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "fk1", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (b) REFERENCES public.constraints_table(b)", Valid: true}, OwningObject: "public.constraints_other_table", IsDomainConstraint: false, IsPartitionParent: false}
-			pkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "pk1", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (b)", Valid: true}, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
-			checkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (a <> 42)", Valid: true}, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
-			partitionCheckConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (id <> 0)", Valid: true}, OwningObject: "public.part", IsDomainConstraint: false, IsPartitionParent: true}
-			domainConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (VALUE <> 42)", Valid: true}, OwningObject: "public.constraint_domain", IsDomainConstraint: true, IsPartitionParent: false}
-			constraintInSchema = backup.Constraint{Oid: 0, Schema: "testschema", Name: "uniq2", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (a, b)", Valid: true}, OwningObject: "testschema.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
+			uniqueConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "uniq2", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (a, b)", Valid: true}, IsValid: true, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
+			fkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "fk1", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (b) REFERENCES public.constraints_table(b)", Valid: true}, IsValid: true, OwningObject: "public.constraints_other_table", ReferencedObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
+			pkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "pk1", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (b)", Valid: true}, IsValid: true, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
+			checkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (a <> 42)", Valid: true}, IsValid: true, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
+			partitionCheckConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (id <> 0)", Valid: true}, IsValid: true, OwningObject: "public.part", IsDomainConstraint: false, IsPartitionParent: true}
+			domainConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "check1", ConType: "c", ConDef: sql.NullString{String: "CHECK (VALUE <> 42)", Valid: true}, IsValid: true, OwningObject: "public.constraint_domain", IsDomainConstraint: true, IsPartitionParent: false}
+			constraintInSchema = backup.Constraint{Oid: 0, Schema: "testschema", Name: "uniq2", ConType: "u", ConDef: sql.NullString{String: "UNIQUE (a, b)", Valid: true}, IsValid: true, OwningObject: "testschema.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
 
 			if connectionPool.Version.AtLeast("6") {
 				uniqueConstraint.ConIsLocal = true
@@ -165,6 +165,19 @@ var _ = Describe("backup integration tests", func() {
 				structmatcher.ExpectStructsToMatchExcluding(&constraints[0], &fkConstraint, "Oid")
 				structmatcher.ExpectStructsToMatchExcluding(&constraints[1], &pkConstraint, "Oid")
 			})
+			It("returns a constraint array for a table with one EXCLUDE constraint", func() {
+				testutils.SkipIfBefore6(connectionPool)
+				testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.constraints_table(a int, b text, c float) DISTRIBUTED BY (a)")
+				defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.constraints_table")
+				testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE ONLY public.constraints_table ADD CONSTRAINT excl1 EXCLUDE USING btree (a WITH =)")
+
+				exclusionConstraint := backup.Constraint{Oid: 0, Schema: "public", Name: "excl1", ConType: "x", ConDef: sql.NullString{String: "EXCLUDE USING btree (a WITH =)", Valid: true}, IsValid: true, ConIsLocal: true, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
+
+				constraints := backup.GetConstraints(connectionPool)
+
+				Expect(constraints).To(HaveLen(1))
+				structmatcher.ExpectStructsToMatchExcluding(&constraints[0], &exclusionConstraint, "Oid")
+			})
 			It("returns a constraint array for a table with one CHECK constraint", func() {
 				testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.constraints_table(a int, b text, c float)")
 				defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.constraints_table")
@@ -210,9 +223,28 @@ PARTITION BY RANGE (date)
 
 				constraints := backup.GetConstraints(connectionPool)
 
+				checkConstraint.IsInheritanceParent = true
 				Expect(constraints).To(HaveLen(1))
 				structmatcher.ExpectStructsToMatchExcluding(&constraints[0], &checkConstraint, "Oid")
 			})
+			It("returns a NO INHERIT constraint on an inheritance parent along with its inherited constraint", func() {
+				testutils.SkipIfBefore6(connectionPool)
+				testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.constraints_table(a int, b text, c float)")
+				defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.constraints_table")
+				testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.constraints_table ADD CONSTRAINT check1 CHECK (a <> 42)")
+				testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.constraints_table ADD CONSTRAINT check2 CHECK (a <> 0) NO INHERIT")
+				testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.constraints_child_table(a int, b text, c float) INHERITS (public.constraints_table)")
+				defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.constraints_child_table")
+
+				constraints := backup.GetConstraints(connectionPool)
+
+				checkConstraint.IsInheritanceParent = true
+				noInheritConstraint := backup.Constraint{Oid: 0, Schema: "public", Name: "check2", ConType: "c", ConDef: sql.NullString{String: "CHECK (a <> 0) NO INHERIT", Valid: true}, IsValid: true, OwningObject: "public.constraints_table",
+					ConIsLocal: true, ConNoInherit: true, IsInheritanceParent: true, IsParentOnly: true}
+				Expect(constraints).To(HaveLen(2))
+				structmatcher.ExpectStructsToMatchExcluding(&constraints[0], &checkConstraint, "Oid")
+				structmatcher.ExpectStructsToMatchExcluding(&constraints[1], &noInheritConstraint, "Oid")
+			})
 			It("returns a constraint array for a table that inherits from another table and has an additional constraint", func() {
 				testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.parent_table(a int, b text, c float)")
 				defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.parent_table")
@@ -324,13 +356,13 @@ PARTITION BY RANGE (date)
 				Expect(constraints).To(HaveLen(4))
 				structmatcher.ExpectStructsToMatchExcluding(&constraints[0], &checkConstraint, "Oid")
 
-				fkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "fk1", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (a, b) REFERENCES public.constraints_table(a, b)", Valid: true}, OwningObject: "public.constraints_other_table", IsDomainConstraint: false, IsPartitionParent: false}
+				fkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "fk1", ConType: "f", ConDef: sql.NullString{String: "FOREIGN KEY (a, b) REFERENCES public.constraints_table(a, b)", Valid: true}, IsValid: true, OwningObject: "public.constraints_other_table", ReferencedObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
 				if connectionPool.Version.AtLeast("6") {
 					fkConstraint.ConIsLocal = true
 				}
 				structmatcher.ExpectStructsToMatchExcluding(&constraints[1], &fkConstraint, "Oid")
 
-				pkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "pk1", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (a, b)", Valid: true}, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
+				pkConstraint = backup.Constraint{Oid: 0, Schema: "public", Name: "pk1", ConType: "p", ConDef: sql.NullString{String: "PRIMARY KEY (a, b)", Valid: true}, IsValid: true, OwningObject: "public.constraints_table", IsDomainConstraint: false, IsPartitionParent: false}
 				if connectionPool.Version.AtLeast("6") {
 					pkConstraint.ConIsLocal = true
 				}
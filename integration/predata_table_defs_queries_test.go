@@ -70,15 +70,17 @@ PARTITION BY RANGE (year)
 			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.atttable ALTER COLUMN e SET STORAGE PLAIN")
 			oid := testutils.OidFromObjectName(connectionPool, "public", "atttable", backup.TYPE_RELATION)
 			privileges := sql.NullString{String: "", Valid: false}
+			var acl []backup.ACL
 			if connectionPool.Version.AtLeast("6") {
 				testhelper.AssertQueryRuns(connectionPool, "GRANT SELECT (c, d) ON TABLE public.atttable TO testrole")
 				privileges = sql.NullString{String: "testrole=r/testrole", Valid: true}
+				acl = []backup.ACL{{Grantee: "testrole", Grantor: "testrole", Select: true}}
 			}
 			tableAtts := backup.GetColumnDefinitions(connectionPool)[oid]
 
 			columnA := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "a", NotNull: false, HasDefault: false, Type: "double precision", Encoding: "", StatTarget: -1, StorageType: "", DefaultVal: "", Comment: "att comment"}
-			columnC := backup.ColumnDefinition{Oid: 0, Num: 3, Name: "c", NotNull: true, HasDefault: false, Type: "text", Encoding: "", StatTarget: -1, StorageType: "", DefaultVal: "", Comment: "", Privileges: privileges}
-			columnD := backup.ColumnDefinition{Oid: 0, Num: 4, Name: "d", NotNull: false, HasDefault: true, Type: "integer", Encoding: "", StatTarget: -1, StorageType: "", DefaultVal: "5", Comment: "", Privileges: privileges}
+			columnC := backup.ColumnDefinition{Oid: 0, Num: 3, Name: "c", NotNull: true, HasDefault: false, Type: "text", Encoding: "", StatTarget: -1, StorageType: "", DefaultVal: "", Comment: "", Privileges: privileges, ACL: acl}
+			columnD := backup.ColumnDefinition{Oid: 0, Num: 4, Name: "d", NotNull: false, HasDefault: true, Type: "integer", Encoding: "", StatTarget: -1, StorageType: "", DefaultVal: "5", Comment: "", Privileges: privileges, ACL: acl}
 			columnE := backup.ColumnDefinition{Oid: 0, Num: 5, Name: "e", NotNull: false, HasDefault: false, Type: "text", Encoding: "", StatTarget: -1, StorageType: "PLAIN", DefaultVal: "", Comment: ""}
 
 			Expect(tableAtts).To(HaveLen(4))
@@ -88,6 +90,34 @@ PARTITION BY RANGE (year)
 			structmatcher.ExpectStructsToMatchExcluding(&columnD, &tableAtts[2], "Oid")
 			structmatcher.ExpectStructsToMatchExcluding(&columnE, &tableAtts[3], "Oid")
 		})
+		It("returns the compression method of a column", func() {
+			if connectionPool.Version.Before("8") {
+				Skip("Test only applicable to GPDB8 and above")
+			}
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.compression_table(i int, t text COMPRESSION pglz)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.compression_table")
+			oid := testutils.OidFromObjectName(connectionPool, "public", "compression_table", backup.TYPE_RELATION)
+			tableAtts := backup.GetColumnDefinitions(connectionPool)[oid]
+
+			Expect(tableAtts).To(HaveLen(2))
+			Expect(tableAtts[0].CompressionType).To(Equal(""))
+			Expect(tableAtts[1].CompressionType).To(Equal("pglz"))
+		})
+		It("returns a column with privileges granted to several roles once", func() {
+			testutils.SkipIfBefore6(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.acl_atttable(i int, "Social Security" text)`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.acl_atttable")
+			testhelper.AssertQueryRuns(connectionPool, `GRANT SELECT ("Social Security") ON TABLE public.acl_atttable TO testrole`)
+			testhelper.AssertQueryRuns(connectionPool, `GRANT UPDATE ("Social Security") ON TABLE public.acl_atttable TO anothertestrole WITH GRANT OPTION`)
+			oid := testutils.OidFromObjectName(connectionPool, "public", "acl_atttable", backup.TYPE_RELATION)
+			tableAtts := backup.GetColumnDefinitions(connectionPool)[oid]
+
+			Expect(tableAtts).To(HaveLen(2))
+			Expect(tableAtts[1].Name).To(Equal(`"Social Security"`))
+			Expect(tableAtts[1].ACL).To(HaveLen(2))
+			structmatcher.ExpectStructsToMatchExcluding(&backup.ACL{Grantee: "anothertestrole", UpdateWithGrant: true}, &tableAtts[1].ACL[0], "Grantor")
+			structmatcher.ExpectStructsToMatchExcluding(&backup.ACL{Grantee: "testrole", Select: true}, &tableAtts[1].ACL[1], "Grantor")
+		})
 		It("returns table attributes including encoding for a column oriented table", func() {
 			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.co_atttable(a float, b text ENCODING(blocksize=65536)) WITH (appendonly=true, orientation=column)")
 			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.co_atttable")
@@ -102,6 +132,28 @@ PARTITION BY RANGE (year)
 			structmatcher.ExpectStructsToMatchExcluding(&columnA, &tableAtts[0], "Oid")
 			structmatcher.ExpectStructsToMatchExcluding(&columnB, &tableAtts[1], "Oid")
 		})
+		It("returns columns in SELECT * order after a column type change rewrites the table", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.reordered_atttable(a int, b int, c text)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.reordered_atttable")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.reordered_atttable ALTER COLUMN b TYPE bigint")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.reordered_atttable DROP COLUMN a")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.reordered_atttable ADD COLUMN a numeric")
+			oid := testutils.OidFromObjectName(connectionPool, "public", "reordered_atttable", backup.TYPE_RELATION)
+
+			tableAtts := backup.GetColumnDefinitions(connectionPool)[oid]
+
+			rows, err := connectionPool.Query("SELECT * FROM public.reordered_atttable LIMIT 0")
+			Expect(err).ToNot(HaveOccurred())
+			selectStarColumns, err := rows.Columns()
+			Expect(err).ToNot(HaveOccurred())
+			_ = rows.Close()
+			columnNames := make([]string, 0)
+			for _, att := range tableAtts {
+				columnNames = append(columnNames, att.Name)
+			}
+			Expect(columnNames).To(Equal([]string{"b", "c", "a"}))
+			Expect(columnNames).To(Equal(selectStarColumns))
+		})
 		It("returns an empty attribute array for a table with no columns", func() {
 			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.nocol_atttable()")
 			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.nocol_atttable")
@@ -128,6 +180,43 @@ PARTITION BY RANGE (year)
 
 			structmatcher.ExpectStructsToMatchExcluding(&columnA, &tableAtts[0], "Oid")
 		})
+		It("returns table attributes for identity columns", func() {
+			testutils.SkipIfBefore7(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.identitytable (
+	i integer GENERATED ALWAYS AS IDENTITY (START WITH 10 INCREMENT BY 5),
+	j bigint GENERATED BY DEFAULT AS IDENTITY (SEQUENCE NAME public.j_sequence MAXVALUE 1000 CYCLE),
+	k integer
+)`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.identitytable")
+			testhelper.AssertQueryRuns(connectionPool, "INSERT INTO public.identitytable (k) VALUES (1), (2)")
+			oid := testutils.OidFromObjectName(connectionPool, "public", "identitytable", backup.TYPE_RELATION)
+
+			tableAtts := backup.GetColumnDefinitions(connectionPool)[oid]
+
+			Expect(tableAtts).To(HaveLen(3))
+			columnI := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", NotNull: true, Type: "integer", StatTarget: -1, Identity: "a", IdentitySequence: "public.identitytable_i_seq",
+				IdentityStart: 10, IdentityIncrement: 5, IdentityMin: 1, IdentityMax: 2147483647, IdentityCache: 20, IdentityLastVal: sql.NullInt64{Int64: 15, Valid: true}}
+			columnJ := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", NotNull: true, Type: "bigint", StatTarget: -1, Identity: "d", IdentitySequence: "public.j_sequence",
+				IdentityStart: 1, IdentityIncrement: 1, IdentityMin: 1, IdentityMax: 1000, IdentityCache: 20, IdentityCycle: true, IdentityLastVal: sql.NullInt64{Int64: 2, Valid: true}}
+			columnK := backup.ColumnDefinition{Oid: 0, Num: 3, Name: "k", Type: "integer", StatTarget: -1}
+			structmatcher.ExpectStructsToMatchExcluding(&columnI, &tableAtts[0], "Oid")
+			structmatcher.ExpectStructsToMatchExcluding(&columnJ, &tableAtts[1], "Oid")
+			structmatcher.ExpectStructsToMatchExcluding(&columnK, &tableAtts[2], "Oid")
+		})
+		It("returns table attributes for stored generated columns", func() {
+			testutils.SkipIfBefore7(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.generatedtable (i integer, j integer GENERATED ALWAYS AS (i * 2) STORED)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.generatedtable")
+			oid := testutils.OidFromObjectName(connectionPool, "public", "generatedtable", backup.TYPE_RELATION)
+
+			tableAtts := backup.GetColumnDefinitions(connectionPool)[oid]
+
+			Expect(tableAtts).To(HaveLen(2))
+			columnI := backup.ColumnDefinition{Oid: 0, Num: 1, Name: "i", Type: "integer", StatTarget: -1}
+			columnJ := backup.ColumnDefinition{Oid: 0, Num: 2, Name: "j", HasDefault: true, Type: "integer", StatTarget: -1, DefaultVal: "(i * 2)", GenerationExpr: "(i * 2)"}
+			structmatcher.ExpectStructsToMatchExcluding(&columnI, &tableAtts[0], "Oid")
+			structmatcher.ExpectStructsToMatchExcluding(&columnJ, &tableAtts[1], "Oid")
+		})
 		It("returns table attributes with foreign data options", func() {
 			testutils.SkipIfBefore6(connectionPool)
 			testhelper.AssertQueryRuns(connectionPool, "CREATE FOREIGN DATA WRAPPER dummy;")
@@ -293,6 +382,24 @@ CREATE TABLE public.test_tsvector (
 
 			Expect(distPolicies).To(Equal(`DISTRIBUTED REPLICATED`))
 		})
+		It("returns distribution policy info for a WRITABLE EXTERNAL table DISTRIBUTED BY one column", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE WRITABLE EXTERNAL TABLE public.ext_dist_one(a int, b text) LOCATION ('gpfdist://localhost:8080/out.txt') FORMAT 'TEXT' DISTRIBUTED BY (a)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP EXTERNAL TABLE public.ext_dist_one")
+			oid := testutils.OidFromObjectName(connectionPool, "public", "ext_dist_one", backup.TYPE_RELATION)
+
+			distPolicies := backup.GetDistributionPolicies(connectionPool)[oid]
+
+			Expect(distPolicies).To(Equal("DISTRIBUTED BY (a)"))
+		})
+		It("returns distribution policy info for a WRITABLE EXTERNAL table DISTRIBUTED RANDOMLY", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE WRITABLE EXTERNAL TABLE public.ext_dist_random(a int, b text) LOCATION ('gpfdist://localhost:8080/out.txt') FORMAT 'TEXT' DISTRIBUTED RANDOMLY")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP EXTERNAL TABLE public.ext_dist_random")
+			oid := testutils.OidFromObjectName(connectionPool, "public", "ext_dist_random", backup.TYPE_RELATION)
+
+			distPolicies := backup.GetDistributionPolicies(connectionPool)[oid]
+
+			Expect(distPolicies).To(Equal("DISTRIBUTED RANDOMLY"))
+		})
 	})
 	Describe("GetPartitionDefinitions", func() {
 		var partitionPartFalseExpectation = "false "
@@ -638,6 +745,24 @@ SET SUBPARTITION TEMPLATE
 		})
 	})
 
+	Describe("GetTablesWithOids", func() {
+		It("Returns a map when a table WITH OIDS exists", func() {
+			if connectionPool.Version.AtLeast("7") {
+				Skip("Test only applicable to GPDB6 and below")
+			}
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.some_table(i int) WITH (oids=true)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.some_table")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.other_table(i int)")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.other_table")
+			oid := testutils.OidFromObjectName(connectionPool, "public", "some_table", backup.TYPE_RELATION)
+
+			result := backup.GetTablesWithOids(connectionPool)
+			Expect(result).To(HaveLen(1))
+
+			Expect(result[oid]).To(BeTrue())
+		})
+	})
+
 	Describe("GetForeignTableDefinitions", func() {
 		It("Returns a map when a FOREIGN table exists", func() {
 			testutils.SkipIfBefore6(connectionPool)
@@ -827,6 +952,17 @@ SET SUBPARTITION TEMPLATE
 			Expect(inheritanceMap).To(Not(HaveKey(partition.Oid)))
 		})
 	})
+	Describe("GetTableAccessMethods", func() {
+		It("Returns a map of oid to table access method", func() {
+			testutils.SkipIfBefore7(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.test_table(i int) USING ao_column`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.test_table")
+
+			oid := testutils.OidFromObjectName(connectionPool, "public", "test_table", backup.TYPE_RELATION)
+			result := backup.GetTableAccessMethods(connectionPool)
+			Expect(result[oid]).To(Equal("ao_column"))
+		})
+	})
 	Describe("GetTableReplicaIdentity", func() {
 		It("Returns a map of oid to replica identity with default", func() {
 			testutils.SkipIfBefore6(connectionPool)
@@ -834,7 +970,7 @@ SET SUBPARTITION TEMPLATE
 			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.test_table")
 
 			oid := testutils.OidFromObjectName(connectionPool, "public", "test_table", backup.TYPE_RELATION)
-			result := backup.GetTableReplicaIdentity(connectionPool)
+			result, _ := backup.GetTableReplicaIdentity(connectionPool)
 			Expect(result[oid]).To(Equal("d"))
 		})
 		It("Returns a map of oid to replica identity with full", func() {
@@ -844,7 +980,7 @@ SET SUBPARTITION TEMPLATE
 
 			testhelper.AssertQueryRuns(connectionPool, `ALTER TABLE public.test_table REPLICA IDENTITY FULL;`)
 			oid := testutils.OidFromObjectName(connectionPool, "public", "test_table", backup.TYPE_RELATION)
-			result := backup.GetTableReplicaIdentity(connectionPool)
+			result, _ := backup.GetTableReplicaIdentity(connectionPool)
 			Expect(result[oid]).To(Equal("f"))
 		})
 		It("Returns a map of oid to replica identity with nothing", func() {
@@ -854,9 +990,21 @@ SET SUBPARTITION TEMPLATE
 
 			testhelper.AssertQueryRuns(connectionPool, `ALTER TABLE public.test_table REPLICA IDENTITY NOTHING;`)
 			oid := testutils.OidFromObjectName(connectionPool, "public", "test_table", backup.TYPE_RELATION)
-			result := backup.GetTableReplicaIdentity(connectionPool)
+			result, _ := backup.GetTableReplicaIdentity(connectionPool)
 			Expect(result[oid]).To(Equal("n"))
 		})
+		It("Returns a map of oid to replica identity index with using index", func() {
+			testutils.SkipIfBefore6(connectionPool)
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.test_table(i int NOT NULL)`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.test_table")
+			testhelper.AssertQueryRuns(connectionPool, `CREATE UNIQUE INDEX test_index ON public.test_table(i)`)
+
+			testhelper.AssertQueryRuns(connectionPool, `ALTER TABLE public.test_table REPLICA IDENTITY USING INDEX test_index;`)
+			oid := testutils.OidFromObjectName(connectionPool, "public", "test_table", backup.TYPE_RELATION)
+			result, indexes := backup.GetTableReplicaIdentity(connectionPool)
+			Expect(result[oid]).To(Equal("i"))
+			Expect(indexes[oid]).To(Equal("test_index"))
+		})
 	})
 	Describe("GetPartitionAlteredSchema", func() {
 		It("Returns a map of table oid to array of child partitions with different schemas", func() {
@@ -878,4 +1026,73 @@ SET SUBPARTITION TEMPLATE
 			Expect(result[oid]).To(ConsistOf(expectedAlteredPartitions))
 		})
 	})
+	Describe("GetPartitionLeafOwners", func() {
+		It("Returns a map of table oid to array of child partitions with owners different from the root", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE ROLE testrole_leafowner")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP ROLE testrole_leafowner")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.foopart(a int, b int) PARTITION BY RANGE(a) (START(1) END (4) EVERY(1))")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.foopart")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.foopart_1_prt_2 OWNER TO testrole_leafowner")
+
+			oid := testutils.OidFromObjectName(connectionPool, "public", "foopart", backup.TYPE_RELATION)
+			result := backup.GetPartitionLeafOwners(connectionPool)
+
+			expectedLeafOwners := []backup.PartitionLeafOwner{
+				{Schema: "public", Name: "foopart_1_prt_2", Owner: "testrole_leafowner"},
+			}
+
+			Expect(result[oid]).To(ConsistOf(expectedLeafOwners))
+		})
+	})
+	Describe("GetPartitionKeys", func() {
+		It("Returns a map of table oid to the partition key of each level", func() {
+			testhelper.AssertQueryRuns(connectionPool, `CREATE TABLE public.foopart(a int, b text) PARTITION BY RANGE(a) SUBPARTITION BY LIST(b)
+SUBPARTITION TEMPLATE (SUBPARTITION x VALUES ('x'), DEFAULT SUBPARTITION other) (START(1) END (3) EVERY(1))`)
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.foopart")
+
+			oid := testutils.OidFromObjectName(connectionPool, "public", "foopart", backup.TYPE_RELATION)
+			result := backup.GetPartitionKeys(connectionPool)
+
+			expectedKeys := []backup.PartitionKey{
+				{Level: 0, Kind: "r", Columns: []string{"a"}},
+				{Level: 1, Kind: "l", Columns: []string{"b"}},
+			}
+
+			Expect(result[oid]).To(Equal(expectedKeys))
+		})
+	})
+	Describe("GetPartitionChildren", func() {
+		It("Returns a map of table oid to array of child partitions with their bounds", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.foopart(a int, b int) PARTITION BY RANGE(a) (START(1) END (3) EVERY(1))")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.foopart")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.foopart_1_prt_1 SET (fillfactor=50)")
+
+			oid := testutils.OidFromObjectName(connectionPool, "public", "foopart", backup.TYPE_RELATION)
+			result := backup.GetPartitionChildren(connectionPool)
+
+			expectedChildren := []backup.PartitionChild{
+				{Schema: "public", Name: "foopart_1_prt_1", ParentSchema: "public", ParentName: "foopart", RangeStart: "1", RangeStartInclusive: true, RangeEnd: "2", StorageOpts: "fillfactor=50"},
+				{Schema: "public", Name: "foopart_1_prt_2", ParentSchema: "public", ParentName: "foopart", RangeStart: "2", RangeStartInclusive: true, RangeEnd: "3"},
+			}
+
+			Expect(result[oid]).To(HaveLen(len(expectedChildren)))
+			for i := range expectedChildren {
+				structmatcher.ExpectStructsToMatchExcluding(&expectedChildren[i], &result[oid][i], "Oid")
+			}
+		})
+		It("Marks external leaf partitions", func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.foopart(a int, b int) PARTITION BY RANGE(a) (START(1) END (3) EVERY(1))")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.foopart")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE READABLE EXTERNAL TABLE public.fooext (LIKE public.foopart) LOCATION ('file://tmp/myfile.txt') FORMAT 'TEXT'")
+			defer testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.fooext")
+			testhelper.AssertQueryRuns(connectionPool, "ALTER TABLE public.foopart EXCHANGE PARTITION FOR (RANK(2)) WITH TABLE public.fooext WITHOUT VALIDATION")
+
+			oid := testutils.OidFromObjectName(connectionPool, "public", "foopart", backup.TYPE_RELATION)
+			result := backup.GetPartitionChildren(connectionPool)
+
+			Expect(result[oid]).To(HaveLen(2))
+			Expect(result[oid][0].IsExternal).To(BeFalse())
+			Expect(result[oid][1].IsExternal).To(BeTrue())
+		})
+	})
 })
@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/structmatcher"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/backup"
@@ -145,6 +146,23 @@ var _ = Describe("backup integration create statement tests", func() {
 				Expect(resultTypes).To(HaveLen(1))
 				structmatcher.ExpectStructsToMatchExcluding(&resultTypes[0], &enumType, "Oid")
 			})
+			It("creates enum types whose labels sort identically to an enum with labels added out of declaration order", func() {
+				testutils.SkipIfBefore6(connectionPool)
+				testhelper.AssertQueryRuns(connectionPool, "CREATE TYPE public.enum_type AS ENUM ('low', 'high')")
+				testhelper.AssertQueryRuns(connectionPool, "ALTER TYPE public.enum_type ADD VALUE 'medium' BEFORE 'high'")
+				testhelper.AssertQueryRuns(connectionPool, "ALTER TYPE public.enum_type ADD VALUE 'lowest' BEFORE 'low'")
+				testhelper.AssertQueryRuns(connectionPool, "ALTER TYPE public.enum_type ADD VALUE 'medium_high' AFTER 'medium'")
+				enums := backup.GetEnumTypes(connectionPool)
+				testhelper.AssertQueryRuns(connectionPool, "DROP TYPE public.enum_type")
+
+				backup.PrintCreateEnumTypeStatements(backupfile, tocfile, enums, emptyMetadataMap)
+
+				testhelper.AssertQueryRuns(connectionPool, buffer.String())
+				defer testhelper.AssertQueryRuns(connectionPool, "DROP TYPE public.enum_type")
+
+				labels := dbconn.MustSelectStringSlice(connectionPool, "SELECT enumlabel AS string FROM pg_enum WHERE enumtypid = 'public.enum_type'::regtype ORDER BY enumsortorder")
+				Expect(labels).To(Equal([]string{"lowest", "low", "medium", "medium_high", "high"}))
+			})
 		})
 		Describe("PrintCreateDomainStatement", func() {
 			domainType := backup.Domain{
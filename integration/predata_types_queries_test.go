@@ -348,4 +348,43 @@ var _ = Describe("backup integration tests", func() {
 
 		})
 	})
+	Describe("GetTableExcludedSchemaDependencies", func() {
+		BeforeEach(func() {
+			testhelper.AssertQueryRuns(connectionPool, "CREATE SCHEMA testschema")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE DOMAIN testschema.zipcode AS text")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TYPE testschema.address AS (street text, zip testschema.zipcode)")
+			testhelper.AssertQueryRuns(connectionPool, "CREATE TABLE public.customers (id int, addresses testschema.address[]) DISTRIBUTED BY (id)")
+			_ = backupCmdFlags.Set(options.INCLUDE_SCHEMA, "public")
+			backup.SetFilterRelationClause("")
+		})
+		AfterEach(func() {
+			testhelper.AssertQueryRuns(connectionPool, "DROP TABLE public.customers")
+			testhelper.AssertQueryRuns(connectionPool, "DROP SCHEMA testschema CASCADE")
+			backup.SetFilterRelationClause("")
+		})
+		It("returns the element type of array columns using types from excluded schemas", func() {
+			results := backup.GetTableExcludedSchemaDependencies(connectionPool)
+
+			expected := backup.ExcludedSchemaDependency{Dependent: "public.customers", ObjectType: "TYPE", Schema: "testschema", Name: "testschema.address", TypType: "c"}
+			Expect(results).To(HaveLen(1))
+			structmatcher.ExpectStructsToMatchExcluding(&expected, &results[0], "Oid")
+		})
+		It("returns nothing when the type's schema is in the backup", func() {
+			_ = backupCmdFlags.Set(options.INCLUDE_SCHEMA, "testschema")
+
+			results := backup.GetTableExcludedSchemaDependencies(connectionPool)
+
+			Expect(results).To(BeEmpty())
+		})
+		It("returns the types that a composite type uses from excluded schemas", func() {
+			dependencies := backup.GetTableExcludedSchemaDependencies(connectionPool)
+			Expect(dependencies).To(HaveLen(1))
+
+			results := backup.GetTypeExcludedSchemaDependencies(connectionPool, []uint32{dependencies[0].Oid})
+
+			expected := backup.ExcludedSchemaDependency{Dependent: "testschema.address", ObjectType: "TYPE", Schema: "testschema", Name: "testschema.zipcode", TypType: "d"}
+			Expect(results).To(HaveLen(1))
+			structmatcher.ExpectStructsToMatchExcluding(&expected, &results[0], "Oid")
+		})
+	})
 })
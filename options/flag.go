@@ -14,98 +14,231 @@ import (
 )
 
 const (
+	ALLOW_HELPER_SKEW     = "allow-helper-version-skew"
 	BACKUP_DIR            = "backup-dir"
+	BACKUP_DIR_MODE       = "backup-dir-mode"
+	CHANGED_SINCE         = "changed-since"
 	COMPRESSION_LEVEL     = "compression-level"
+	CONSTRAINT_INDEXES    = "include-constraint-indexes"
+	CONTENT_ADDRESSED     = "content-addressed-data"
+	DATA_FORMAT           = "data-format"
 	DATA_ONLY             = "data-only"
+	DATA_PLAN             = "data-plan"
 	DBNAME                = "dbname"
 	DEBUG                 = "debug"
+	DEFAULTS_REWRITE_FILE = "defaults-rewrite-file"
+	EXCLUDE_COLUMN_DATA   = "exclude-column-data"
+	EXCLUDE_DATA          = "exclude-table-data"
+	EXCLUDE_DATA_FILE     = "exclude-table-data-file"
+	EXCLUDE_ORPHANED      = "exclude-orphaned-partitions"
 	EXCLUDE_RELATION      = "exclude-table"
 	EXCLUDE_RELATION_FILE = "exclude-table-file"
+	EXCLUDE_TABLE_REGEX   = "exclude-table-regex"
 	EXCLUDE_SCHEMA        = "exclude-schema"
 	EXCLUDE_SCHEMA_FILE   = "exclude-schema-file"
+	FK_CYCLE_REPLICA      = "replica-role-on-fk-cycle"
+	FORCE                 = "force"
 	FROM_TIMESTAMP        = "from-timestamp"
+	HISTORY_DB            = "history-database"
+	HISTORY_SCHEMA        = "history-schema"
 	INCLUDE_RELATION      = "include-table"
 	INCLUDE_RELATION_FILE = "include-table-file"
 	INCLUDE_SCHEMA        = "include-schema"
 	INCLUDE_SCHEMA_FILE   = "include-schema-file"
+	INCLUDE_SYSTEM_SCHEMA = "include-system-schema"
+	INCLUDE_TABLE_QUERY   = "include-table-query"
+	INCLUDE_TABLE_REGEX   = "include-table-regex"
+	INCLUDE_TYPE_DEPS     = "include-type-dependencies"
+	INCLUDE_INVALID       = "include-invalid-indexes"
 	INCREMENTAL           = "incremental"
 	JOBS                  = "jobs"
+	KEEPALIVES_COUNT      = "keepalives-count"
+	KEEPALIVES_IDLE       = "keepalives-idle"
+	KEEPALIVES_INTERVAL   = "keepalives-interval"
 	LEAF_PARTITION_DATA   = "leaf-partition-data"
+	LEAF_PARTITION_DDL    = "leaf-partition-ddl"
+	LOW_MEMORY            = "low-memory"
+	MAX_PER_HOST          = "max-concurrent-per-host"
+	MAX_RECONNECTS        = "max-reconnects"
+	MAX_STATEMENT_RETRIES = "max-statement-retries"
+	MAX_RETRIES           = "max-retries"
 	METADATA_ONLY         = "metadata-only"
+	NO_AUTO_SEQUENCES     = "no-auto-include-sequences"
 	NO_COMPRESSION        = "no-compression"
+	NO_DATA_LOCKS         = "no-data-locks"
+	NO_DESTRUCTIVE        = "no-destructive"
+	NO_EXTENSION_MEMBERS  = "no-extension-members"
+	NO_REPLICATED_DATA    = "no-data-for-replicated-tables"
 	PLUGIN_CONFIG         = "plugin-config"
+	POST_RESTORE_SCRIPT   = "post-restore-script"
+	PRE_RESTORE_SCRIPT    = "pre-restore-script"
+	PRESERVE_GRANTOR      = "preserve-grantor"
 	QUIET                 = "quiet"
+	REBUILD_INDEXES       = "rebuild-indexes"
+	RETRY_INTERVAL        = "retry-interval"
+	SCHEMA_AUTHORIZATION  = "schema-authorization"
+	SERIAL_CONSTRAINTS    = "serial-constraints"
+	SET_DEFAULT_AM        = "set-default-access-method"
 	SINGLE_DATA_FILE      = "single-data-file"
+	SKIP_CORRUPT_PARTS    = "skip-corrupt-partitions"
+	SLOW_CONSTRAINT_SECS  = "slow-constraint-seconds"
+	SKIP_INACCESSIBLE     = "skip-inaccessible-tables"
+	STRICT                = "strict"
+	STRICT_INCLUDE        = "strict-include"
+	STRICT_ROLES          = "strict-roles"
+	STORAGE_OVERRIDE      = "storage-option-override"
+	STORAGE_OVERRIDE_FILE = "storage-option-override-file"
+	STORAGE_OVERRIDE_MODE = "storage-option-override-mode"
+	SUMMARY_ONLY          = "summary-only"
 	VERBOSE               = "verbose"
 	WITH_STATS            = "with-stats"
 	CREATE_DB             = "create-db"
+	ON_CONVERSION_ERROR   = "on-conversion-error"
 	ON_ERROR_CONTINUE     = "on-error-continue"
 	REDIRECT_DB           = "redirect-db"
+	RESTORE_TO_TIMESTAMP  = "restore-to-timestamp"
+	ROLE_MAP              = "role-map"
+	TABLESPACE_MAP        = "tablespace-location-map"
+	TABLESPACE_MAP_FILE   = "tablespace-location-map-file"
 	RUN_ANALYZE           = "run-analyze"
 	TIMESTAMP             = "timestamp"
 	WITH_GLOBALS          = "with-globals"
 	REDIRECT_SCHEMA       = "redirect-schema"
 	TRUNCATE_TABLE        = "truncate-table"
+	VALIDATE_DEFAULTS     = "validate-defaults"
+	VERIFY_BACKUP_FILES   = "verify-backup-files"
+	VERIFY_CHECKSUMS      = "verify-checksums"
 	WITHOUT_GLOBALS       = "without-globals"
 )
 
 func SetBackupFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.Bool(ALLOW_HELPER_SKEW, false, "Allow gpbackup_helper on segment hosts to differ from gpbackup by minor or patch version, as long as it uses the same data format")
 	flagSet.String(BACKUP_DIR, "", "The absolute path of the directory to which all backup files will be written")
+	flagSet.String(BACKUP_DIR_MODE, "", "The octal permission mode to set on each timestamped backup directory, such as 0700. By default the mode is determined by the umask.")
+	flagSet.String(CHANGED_SINCE, "", "Back up all metadata, but only the data of tables that have probably changed since the backup with the given timestamp, or the latest backup taken before it, judged from table statistics counters and relfilenodes. Changes these do not reflect are missed.")
 	flagSet.Int(COMPRESSION_LEVEL, 1, "Level of compression to use during data backup. Valid values are between 1 and 9.")
+	flagSet.Bool(CONTENT_ADDRESSED, false, "Write each table's data to a file named by the checksum of its contents in a directory shared by all backups, so that unchanged tables produce identical files")
+	flagSet.String(DATA_FORMAT, "csv", "The COPY format used for table data, csv or binary. Binary data can only be restored to the same major version of GPDB on the same architecture.")
 	flagSet.Bool(DATA_ONLY, false, "Only back up data, do not back up metadata")
 	flagSet.String(DBNAME, "", "The database to be backed up")
 	flagSet.Bool(DEBUG, false, "Print verbose and debug log messages")
+	flagSet.StringArray(EXCLUDE_COLUMN_DATA, []string{}, "Back up NULL in place of the data of the specified column, given as schema.table.column, or a constant, given as schema.table.column=value. --exclude-column-data can be specified multiple times.")
+	flagSet.StringArray(EXCLUDE_DATA, []string{}, "With --leaf-partition-data, back up the specified leaf partition(s) as part of their parent table's definition, but not their data. --exclude-table-data can be specified multiple times.")
+	flagSet.String(EXCLUDE_DATA_FILE, "", "A file containing a list of fully-qualified leaf partitions whose data will not be backed up")
 	flagSet.StringArray(EXCLUDE_SCHEMA, []string{}, "Back up all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
 	flagSet.String(EXCLUDE_SCHEMA_FILE, "", "A file containing a list of schemas to be excluded from the backup")
 	flagSet.StringArray(EXCLUDE_RELATION, []string{}, "Back up all metadata except the specified table(s). --exclude-table can be specified multiple times.")
 	flagSet.String(EXCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified tables to be excluded from the backup")
+	flagSet.StringArray(EXCLUDE_TABLE_REGEX, []string{}, "Back up all metadata except the tables whose whole schema.table name matches the specified regular expression, in addition to any given with --exclude-table. --exclude-table-regex can be specified multiple times.")
+	flagSet.Bool(EXCLUDE_ORPHANED, false, "Do not back up tables that were child partitions but can no longer be reached from a root partition table")
 	flagSet.String(FROM_TIMESTAMP, "", "A timestamp to use to base the current incremental backup off")
 	flagSet.Bool("help", false, "Help for gpbackup")
+	flagSet.String(HISTORY_DB, "", "A database in which to record the backup in a history table, in addition to the history file. Failing to record it only causes a warning.")
+	flagSet.String(HISTORY_SCHEMA, "gpbackup", "The schema of the history table in the --history-database database, created if it does not exist")
 	flagSet.StringArray(INCLUDE_SCHEMA, []string{}, "Back up only the specified schema(s). --include-schema can be specified multiple times.")
 	flagSet.String(INCLUDE_SCHEMA_FILE, "", "A file containing a list of schema(s) to be included in the backup")
+	flagSet.StringArray(INCLUDE_SYSTEM_SCHEMA, []string{}, "Back up the metadata of user objects in the specified system schema (gp_toolkit, information_schema, or pg_catalog), which is otherwise excluded. Restoring them requires superuser privileges. --include-system-schema can be specified multiple times.")
 	flagSet.StringArray(INCLUDE_RELATION, []string{}, "Back up only the specified table(s). --include-table can be specified multiple times.")
+	flagSet.StringArray(INCLUDE_TABLE_REGEX, []string{}, "Back up only the tables whose whole schema.table name matches the specified regular expression, in addition to any given with --include-table. Tables matching --exclude-table-regex are not included. --include-table-regex can be specified multiple times.")
+	flagSet.String(INCLUDE_TABLE_QUERY, "", "A query returning the schema and name of each table to back up, as two text columns, which is run against the database at startup")
 	flagSet.String(INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified tables to be included in the backup")
+	flagSet.Bool(INCLUDE_INVALID, false, "Back up indexes left invalid by a failed CREATE INDEX CONCURRENTLY, which are otherwise skipped. Restoring them builds them in full.")
+	flagSet.Bool(INCLUDE_TYPE_DEPS, false, "Back up the composite, domain, and enum types that included tables use from schemas that are not in the backup, instead of failing the backup")
 	flagSet.Bool(INCREMENTAL, false, "Only back up data for AO tables that have been modified since the last backup")
 	flagSet.Int(JOBS, 1, "The number of parallel connections to use when backing up data")
+	flagSet.Int(KEEPALIVES_COUNT, 3, "Number of unanswered TCP keepalives after which an idle database connection is considered dead. 0 uses the system default.")
+	flagSet.Int(KEEPALIVES_IDLE, 300, "Seconds of inactivity after which TCP keepalives are sent on database connections. 0 uses the system default.")
+	flagSet.Int(KEEPALIVES_INTERVAL, 30, "Seconds between unanswered TCP keepalives on database connections. 0 uses the system default.")
 	flagSet.Bool(LEAF_PARTITION_DATA, false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
+	flagSet.Bool(LEAF_PARTITION_DDL, false, "For partition tables, print a separate CREATE TABLE and ATTACH PARTITION statement for each child partition instead of a single partition definition")
+	flagSet.Bool(LOW_MEMORY, false, "Reduce the peak memory use of gpbackup on catalogs with very many objects, by releasing each stage's metadata as soon as it is written and collecting garbage more often, at the cost of some speed")
+	flagSet.Int(MAX_PER_HOST, 0, "The maximum number of segments on each host that copy and compress table data at the same time. 0 means no limit.")
+	flagSet.Int(MAX_RETRIES, 0, "Number of times to restart the whole backup with a new timestamp after a retryable failure")
 	flagSet.Bool(METADATA_ONLY, false, "Only back up metadata, do not back up data")
+	flagSet.Bool(NO_AUTO_SEQUENCES, false, "Do not automatically back up sequences owned by or used in column defaults of tables included with --include-table")
 	flagSet.Bool(NO_COMPRESSION, false, "Disable compression of data files")
+	flagSet.Bool(NO_DATA_LOCKS, false, "With --metadata-only, do not lock the tables being backed up. Metadata is read from a single snapshot, but DDL run concurrently with the backup may produce slightly inconsistent metadata.")
+	flagSet.Bool(NO_EXTENSION_MEMBERS, false, "Do not back up user objects that were added to an extension with ALTER EXTENSION ... ADD, or their extension membership")
+	flagSet.Bool(NO_REPLICATED_DATA, false, "Back up only metadata for DISTRIBUTED REPLICATED tables, do not back up their data")
 	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
 	flagSet.Bool("version", false, "Print version number and exit")
+	flagSet.Bool(PRESERVE_GRANTOR, false, "Run privileges granted by a role other than the object owner as that role, so that the original grantor is recorded on restore")
 	flagSet.Bool(QUIET, false, "Suppress non-warning, non-error log messages")
+	flagSet.Int(RETRY_INTERVAL, 60, "Seconds to wait before restarting a failed backup when --max-retries is set")
+	flagSet.Bool(SCHEMA_AUTHORIZATION, false, "Set schema owners with CREATE SCHEMA ... AUTHORIZATION instead of a separate ALTER SCHEMA ... OWNER TO statement")
+	flagSet.Bool(SET_DEFAULT_AM, false, "On GPDB 7 and later, set default_table_access_method to the most common table access method and only print USING clauses for tables that use a different one")
 	flagSet.Bool(SINGLE_DATA_FILE, false, "Back up all data to a single file instead of one per table")
+	flagSet.Bool(SKIP_CORRUPT_PARTS, false, "Leave out partition tables whose partition definition cannot be read from the catalog, listing them in the report, instead of failing the backup")
+	flagSet.Bool(SKIP_INACCESSIBLE, false, "Back up only metadata for tables the backup role cannot SELECT from, instead of failing before the data backup starts")
+	flagSet.Bool(STRICT, false, "Fail the backup instead of warning when a foreign key references a table that is not in the backup set")
+	flagSet.Bool(STRICT_INCLUDE, false, "Fail the backup instead of warning when --include-table-query returns no tables")
+	flagSet.Bool(SUMMARY_ONLY, false, "Suppress log messages and print a one-line summary when the backup finishes")
+	flagSet.String(TIMESTAMP, "", "The timestamp to give the backup, in the format YYYYMMDDHHMMSS. Running a failed backup again with its timestamp first removes the files of the failed attempt.")
 	flagSet.Bool(VERBOSE, false, "Print verbose log messages")
 	flagSet.Bool(WITH_STATS, false, "Back up query plan statistics")
 	flagSet.Bool(WITHOUT_GLOBALS, false, "Disable backup of global metadata")
 }
 
 func SetRestoreFlagDefaults(flagSet *pflag.FlagSet) {
+	flagSet.Bool(ALLOW_HELPER_SKEW, false, "Allow gpbackup_helper on segment hosts to differ from gprestore by minor or patch version, as long as it uses the same data format")
 	flagSet.String(BACKUP_DIR, "", "The absolute path of the directory in which the backup files to be restored are located")
 	flagSet.Bool(CREATE_DB, false, "Create the database before metadata restore")
 	flagSet.Bool(DATA_ONLY, false, "Only restore data, do not restore metadata")
+	flagSet.String(DATA_PLAN, "", "Print the table data the restore would load, text or json, listing for each table the data file read on each segment, its size, the rows expected and the table restored to, and exit without restoring anything. Fails if any data file is missing.")
 	flagSet.Bool(DEBUG, false, "Print verbose and debug log messages")
+	flagSet.String(DEFAULTS_REWRITE_FILE, "", "A YAML file of regular expression rewrites to apply to column default expressions before tables are created")
 	flagSet.StringArray(EXCLUDE_SCHEMA, []string{}, "Restore all metadata except objects in the specified schema(s). --exclude-schema can be specified multiple times.")
 	flagSet.String(EXCLUDE_SCHEMA_FILE, "", "A file containing a list of schemas that will not be restored")
 	flagSet.StringArray(EXCLUDE_RELATION, []string{}, "Restore all metadata except the specified relation(s). --exclude-table can be specified multiple times.")
 	flagSet.String(EXCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will not be restored")
+	flagSet.Bool(FK_CYCLE_REPLICA, false, "Restore the data of tables whose foreign keys in the restore database form a cycle with session_replication_role set to replica, so that their foreign keys are not checked. Requires superuser privileges.")
+	flagSet.Bool(FORCE, false, "Proceed with destructive restore operations, such as --truncate-table, without asking for confirmation, and restore a backup taken with --changed-since as if it were a complete backup, although it only contains the data of the tables that probably changed")
 	flagSet.Bool("help", false, "Help for gprestore")
+	flagSet.String(HISTORY_DB, "", "A database in which to record the restore in a history table. Failing to record it only causes a warning.")
+	flagSet.String(HISTORY_SCHEMA, "gpbackup", "The schema of the history table in the --history-database database, created if it does not exist")
+	flagSet.Bool(CONSTRAINT_INDEXES, false, "With --rebuild-indexes, also drop and re-create the indexes that back primary key and unique constraints")
 	flagSet.StringArray(INCLUDE_SCHEMA, []string{}, "Restore only the specified schema(s). --include-schema can be specified multiple times.")
 	flagSet.String(INCLUDE_SCHEMA_FILE, "", "A file containing a list of schemas that will be restored")
 	flagSet.StringArray(INCLUDE_RELATION, []string{}, "Restore only the specified relation(s). --include-table can be specified multiple times.")
 	flagSet.String(INCLUDE_RELATION_FILE, "", "A file containing a list of fully-qualified relation(s) that will be restored")
 	flagSet.Bool(INCREMENTAL, false, "BETA FEATURE: Only restore data for all heap tables and only AO tables that have been modified since the last backup")
 	flagSet.Bool(METADATA_ONLY, false, "Only restore metadata, do not restore data")
+	flagSet.Bool(NO_DESTRUCTIVE, false, "Fail instead of proceeding if the restore would truncate table data or drop indexes in the restore database")
 	flagSet.Int(JOBS, 1, "Number of parallel connections to use when restoring table data and post-data")
+	flagSet.Int(KEEPALIVES_COUNT, 3, "Number of unanswered TCP keepalives after which an idle database connection is considered dead. 0 uses the system default.")
+	flagSet.Int(KEEPALIVES_IDLE, 300, "Seconds of inactivity after which TCP keepalives are sent on database connections. 0 uses the system default.")
+	flagSet.Int(KEEPALIVES_INTERVAL, 30, "Seconds between unanswered TCP keepalives on database connections. 0 uses the system default.")
+	flagSet.Int(MAX_PER_HOST, 0, "The maximum number of segments on each host that load table data at the same time. 0 means no limit.")
+	flagSet.Int(MAX_RECONNECTS, 3, "Maximum number of times each connection will reconnect after losing its connection to the database. Only statements that are safe to run twice, such as GRANT and COMMENT, are retried after reconnecting. 0 disables reconnecting.")
+	flagSet.Int(MAX_STATEMENT_RETRIES, 3, "Maximum number of times a metadata statement that fails with a deadlock or serialization failure will be retried. 0 disables retrying.")
+	flagSet.String(ON_CONVERSION_ERROR, "fail", "When table data must be converted to the encoding of the restore database, what to do with a row that cannot be converted: fail, skip the row, or replace the characters that cannot be converted")
 	flagSet.Bool(ON_ERROR_CONTINUE, false, "Log errors and continue restore, instead of exiting on first error")
 	flagSet.String(PLUGIN_CONFIG, "", "The configuration file to use for a plugin")
+	flagSet.String(POST_RESTORE_SCRIPT, "", "A file of SQL statements to run against the restore database after the restore completes. Errors are logged as warnings.")
+	flagSet.String(PRE_RESTORE_SCRIPT, "", "A file of SQL statements to run against the restore database before any metadata or data is restored. Errors abort the restore.")
 	flagSet.Bool("version", false, "Print version number and exit")
 	flagSet.Bool(QUIET, false, "Suppress non-warning, non-error log messages")
+	flagSet.Bool(REBUILD_INDEXES, false, "When restoring data only, drop the indexes on each restored table before loading its data and re-create them in parallel afterwards. Index definitions are saved to a journal in the backup directory until they are re-created.")
 	flagSet.String(REDIRECT_DB, "", "Restore to the specified database instead of the database that was backed up")
-	flagSet.String(REDIRECT_SCHEMA, "", "Restore to the specified schema instead of the schema that was backed up")
+	flagSet.String(REDIRECT_SCHEMA, "", "Restore to the specified schema instead of the schema that was backed up. The name is matched exactly, including its case, or may be given in double quotes as in SQL.")
+	flagSet.String(RESTORE_TO_TIMESTAMP, "", "Restore the state as of an earlier backup in the incremental backup chain of --timestamp, ignoring later incremental backups")
+	flagSet.StringArray(ROLE_MAP, []string{}, "Restore objects owned by or granted to a role under another role name, given as old:new. Role names are matched exactly, including their case, or may be given in double quotes as in SQL, such as \"Sales:West\":sales_west. --role-map can be specified multiple times.")
+	flagSet.StringArray(TABLESPACE_MAP, []string{}, "Restore a tablespace at a new location, given as name:/new/path, when restoring with --with-globals. Tablespace names are matched exactly, including their case, or may be given in double quotes as in SQL. Every tablespace in the backup must be mapped. --tablespace-location-map can be specified multiple times.")
+	flagSet.String(TABLESPACE_MAP_FILE, "", "A file of tablespace location mappings, one name:/new/path entry per line, used in addition to --tablespace-location-map")
+	flagSet.Bool(SERIAL_CONSTRAINTS, false, "Add constraints one at a time on a single connection, instead of adding the constraints of different tables in parallel across the --jobs connections")
+	flagSet.Int(SLOW_CONSTRAINT_SECS, 60, "Log each constraint that takes at least this many seconds to add, and each one still being added after that long. 0 logs every constraint.")
+	flagSet.String(STORAGE_OVERRIDE, "", "Storage options, such as appendonly=true,compresstype=zstd,compresslevel=3, to set in the WITH clause of every table created. External and foreign tables are not changed.")
+	flagSet.String(STORAGE_OVERRIDE_FILE, "", "A YAML file of storage options to set for specific tables in place of --storage-option-override")
+	flagSet.String(STORAGE_OVERRIDE_MODE, "merge", "Whether storage option overrides are merged with the storage options each table was backed up with, or replace them: merge or replace")
+	flagSet.Bool(STRICT_ROLES, false, "Check that every role owning or granted privileges on a restored object exists in the restore database, after applying --role-map, before restoring any metadata")
 	flagSet.Bool(WITH_GLOBALS, false, "Restore global metadata")
 	flagSet.String(TIMESTAMP, "", "The timestamp to be restored, in the format YYYYMMDDHHMMSS")
+	flagSet.Bool(SUMMARY_ONLY, false, "Suppress log messages and print a one-line summary when the restore finishes")
 	flagSet.Bool(TRUNCATE_TABLE, false, "Removes data of the tables getting restored")
+	flagSet.Bool(VALIDATE_DEFAULTS, false, "Check that all column default expressions are valid in the restore database before creating any tables")
 	flagSet.Bool(VERBOSE, false, "Print verbose log messages")
+	flagSet.Bool(VERIFY_BACKUP_FILES, false, "Check that no backup file on the segments is empty, in addition to checking the number of backup files. File contents are not verified.")
+	flagSet.Bool(VERIFY_CHECKSUMS, false, "Read every backup file on the segments and log its md5sum, in the same pass as --verify-backup-files, failing if any file cannot be read. Implies --verify-backup-files.")
 	flagSet.Bool(WITH_STATS, false, "Restore query plan statistics")
 	flagSet.Bool(LEAF_PARTITION_DATA, false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
 	flagSet.Bool(RUN_ANALYZE, false, "Run ANALYZE on restored tables")
@@ -176,3 +309,12 @@ func MustGetFlagStringArray(cmdFlags *pflag.FlagSet, flagName string) []string {
 	gplog.FatalOnError(err)
 	return value
 }
+
+// Returns the value of each flag set on the command line, by flag name
+func ChangedFlagValues(flags *pflag.FlagSet) map[string]string {
+	values := make(map[string]string)
+	flags.Visit(func(flag *pflag.Flag) {
+		values[flag.Name] = flag.Value.String()
+	})
+	return values
+}
@@ -57,5 +57,11 @@ var _ = Describe("utils/flag tests", func() {
 				Expect(result).To(Equal([]string{"-s", "some_argument"}))
 			})
 		})
+		Context("ChangedFlagValues", func() {
+			It("returns only the flags set on the command line", func() {
+				Expect(flagSet.Parse([]string{"--stringFlag", "foo", "--boolFlag"})).To(Succeed())
+				Expect(options.ChangedFlagValues(flagSet)).To(Equal(map[string]string{"stringFlag": "foo", "boolFlag": "true"}))
+			})
+		})
 	})
 })
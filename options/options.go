@@ -2,6 +2,7 @@ package options
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
@@ -21,11 +22,14 @@ type Options struct {
 	ExcludedSchemas           []string
 	IncludedSchemas           []string
 	originalIncludedRelations []string
+	excludedDataRelations     []string
 	RedirectSchema            string
+	includedRelationRegexes   []*regexp.Regexp
+	excludedRelationRegexes   []*regexp.Regexp
 }
 
 func NewOptions(initialFlags *pflag.FlagSet) (*Options, error) {
-	includedRelations, err := setFiltersFromFile(initialFlags, INCLUDE_RELATION, INCLUDE_RELATION_FILE)
+	includedRelations, err := setFiltersFromFile(initialFlags, INCLUDE_RELATION, INCLUDE_RELATION_FILE, utils.ValidateFQNs)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +38,7 @@ func NewOptions(initialFlags *pflag.FlagSet) (*Options, error) {
 		return nil, err
 	}
 
-	excludedRelations, err := setFiltersFromFile(initialFlags, EXCLUDE_RELATION, EXCLUDE_RELATION_FILE)
+	excludedRelations, err := setFiltersFromFile(initialFlags, EXCLUDE_RELATION, EXCLUDE_RELATION_FILE, utils.ValidateFQNs)
 	if err != nil {
 		return nil, err
 	}
@@ -43,12 +47,24 @@ func NewOptions(initialFlags *pflag.FlagSet) (*Options, error) {
 		return nil, err
 	}
 
-	includedSchemas, err := setFiltersFromFile(initialFlags, INCLUDE_SCHEMA, INCLUDE_SCHEMA_FILE)
+	excludedDataRelations := make([]string, 0)
+	if initialFlags.Lookup(EXCLUDE_DATA) != nil {
+		excludedDataRelations, err = setFiltersFromFile(initialFlags, EXCLUDE_DATA, EXCLUDE_DATA_FILE, utils.ValidateFQNs)
+		if err != nil {
+			return nil, err
+		}
+		err = utils.ValidateFQNs(excludedDataRelations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	includedSchemas, err := setFiltersFromFile(initialFlags, INCLUDE_SCHEMA, INCLUDE_SCHEMA_FILE, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	excludedSchemas, err := setFiltersFromFile(initialFlags, EXCLUDE_SCHEMA, EXCLUDE_SCHEMA_FILE)
+	excludedSchemas, err := setFiltersFromFile(initialFlags, EXCLUDE_SCHEMA, EXCLUDE_SCHEMA_FILE, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +82,15 @@ func NewOptions(initialFlags *pflag.FlagSet) (*Options, error) {
 		}
 	}
 
+	includedRelationRegexes, err := compileRelationRegexes(initialFlags, INCLUDE_TABLE_REGEX)
+	if err != nil {
+		return nil, err
+	}
+	excludedRelationRegexes, err := compileRelationRegexes(initialFlags, EXCLUDE_TABLE_REGEX)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Options{
 		IncludedRelations:         includedRelations,
 		ExcludedRelations:         excludedRelations,
@@ -73,11 +98,42 @@ func NewOptions(initialFlags *pflag.FlagSet) (*Options, error) {
 		ExcludedSchemas:           excludedSchemas,
 		isLeafPartitionData:       leafPartitionData,
 		originalIncludedRelations: includedRelations,
+		excludedDataRelations:     excludedDataRelations,
 		RedirectSchema:            redirectSchema,
+		includedRelationRegexes:   includedRelationRegexes,
+		excludedRelationRegexes:   excludedRelationRegexes,
 	}, nil
 }
 
-func setFiltersFromFile(initialFlags *pflag.FlagSet, filterFlag string, filterFileFlag string) ([]string, error) {
+/*
+ * Each pattern must match the whole unquoted schema.table name, so it is
+ * anchored at both ends; a pattern that should match part of a name must
+ * say so with .* explicitly.
+ */
+func compileRelationRegexes(initialFlags *pflag.FlagSet, regexFlag string) ([]*regexp.Regexp, error) {
+	if initialFlags.Lookup(regexFlag) == nil {
+		return nil, nil
+	}
+	patterns, err := initialFlags.GetStringArray(regexFlag)
+	if err != nil {
+		return nil, err
+	}
+	regexes := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		regex, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+		if err != nil {
+			return nil, errors.Errorf("Invalid --%s pattern %s: %v", regexFlag, pattern, err)
+		}
+		regexes = append(regexes, regex)
+	}
+	return regexes, nil
+}
+
+/*
+ * If validate is given, each line read from the file is checked with it as it
+ * is read, so that an invalid entry is reported with the line it came from.
+ */
+func setFiltersFromFile(initialFlags *pflag.FlagSet, filterFlag string, filterFileFlag string, validate func([]string) error) ([]string, error) {
 	filters, err := initialFlags.GetStringArray(filterFlag)
 	if err != nil {
 		return nil, err
@@ -94,8 +150,13 @@ func setFiltersFromFile(initialFlags *pflag.FlagSet, filterFlag string, filterFi
 			return nil, err
 		}
 		// copy any values for flag filterFileFlag into global flag for filterFlag
-		for _, fqn := range filterLines {
+		for lineNum, fqn := range filterLines {
 			if fqn != "" {
+				if validate != nil {
+					if err := validate([]string{fqn}); err != nil {
+						return nil, errors.Errorf("Invalid entry on line %d of %s: %v", lineNum+1, filename, err)
+					}
+				}
 				filters = append(filters, fqn)          //This appends filter to options
 				err = initialFlags.Set(filterFlag, fqn) //This appends to the slice underlying the flag.
 				if err != nil {
@@ -122,6 +183,10 @@ func (o Options) GetExcludedTables() []string {
 	return o.ExcludedRelations
 }
 
+func (o Options) GetExcludedDataTables() []string {
+	return o.excludedDataRelations
+}
+
 func (o Options) IsLeafPartitionData() bool {
 	return o.isLeafPartitionData
 }
@@ -138,6 +203,81 @@ func (o *Options) AddIncludedRelation(relation string) {
 	o.IncludedRelations = append(o.IncludedRelations, relation)
 }
 
+/*
+ * Relations in these schemas are catalog tables or the toast and
+ * append-optimized auxiliary tables of user tables, none of which are backed
+ * up on their own.  These are the schemas excluded by schemaFilterClause.
+ */
+func IsSystemSchema(schema string) bool {
+	if strings.HasPrefix(schema, "pg_temp_") || strings.HasPrefix(schema, "pg_toast") {
+		return true
+	}
+	switch schema {
+	case "gp_toolkit", "information_schema", "pg_aoseg", "pg_bitmapindex", "pg_catalog":
+		return true
+	}
+	return false
+}
+
+/*
+ * The system schemas whose user objects can be backed up with
+ * --include-system-schema.  The other system schemas only hold the toast and
+ * auxiliary tables of user tables.
+ */
+var IncludableSystemSchemas = []string{"gp_toolkit", "information_schema", "pg_catalog"}
+
+func splitSystemRelations(relations []string) ([]string, []string) {
+	userRelations := make([]string, 0, len(relations))
+	systemRelations := make([]string, 0)
+	for _, fqn := range relations {
+		if IsSystemSchema(strings.SplitN(fqn, ".", 2)[0]) {
+			systemRelations = append(systemRelations, fqn)
+		} else {
+			userRelations = append(userRelations, fqn)
+		}
+	}
+	return userRelations, systemRelations
+}
+
+/*
+ * Removes relations in system schemas from the include and exclude lists, and
+ * from the flags they were read from, as gpbackup reads the flags again when
+ * querying relations.  Returns the relations removed.  If every included
+ * relation is removed, this returns an error rather than leaving no include
+ * filter, which would select every relation instead of none.
+ */
+func (o *Options) RemoveSystemRelations(flags *pflag.FlagSet) ([]string, error) {
+	includedRelations, removedIncludes := splitSystemRelations(o.IncludedRelations)
+	if len(removedIncludes) > 0 && len(includedRelations) == 0 {
+		return nil, errors.Errorf("Cannot filter on system or auxiliary relations, and no other relations were included: %s", strings.Join(removedIncludes, ", "))
+	}
+	excludedRelations, removedExcludes := splitSystemRelations(o.ExcludedRelations)
+	if len(removedIncludes) > 0 {
+		o.IncludedRelations = includedRelations
+		o.originalIncludedRelations, _ = splitSystemRelations(o.originalIncludedRelations)
+		err := replaceFlagValues(flags, INCLUDE_RELATION, includedRelations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(removedExcludes) > 0 {
+		o.ExcludedRelations = excludedRelations
+		err := replaceFlagValues(flags, EXCLUDE_RELATION, excludedRelations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(removedIncludes, removedExcludes...), nil
+}
+
+func replaceFlagValues(flags *pflag.FlagSet, flagName string, values []string) error {
+	flag := flags.Lookup(flagName)
+	if flag == nil {
+		return nil
+	}
+	return flag.Value.(pflag.SliceValue).Replace(values)
+}
+
 type FqnStruct struct {
 	SchemaName string
 	TableName  string
@@ -249,6 +389,168 @@ func (o *Options) ExpandIncludesForPartitions(conn *dbconn.DBConn, flags *pflag.
 	return nil
 }
 
+/*
+ * Adds sequences owned by, or used in the column defaults of, the included
+ * tables to the include list, since those tables cannot be restored without
+ * them.  Returns the sequences that were added.
+ */
+func (o *Options) ExpandIncludesForSequences(conn *dbconn.DBConn, flags *pflag.FlagSet) ([]string, error) {
+	added := make([]string, 0)
+	if len(o.GetIncludedTables()) == 0 {
+		return added, nil
+	}
+
+	quotedIncludeRelations, err := QuoteTableNames(conn, o.GetIncludedTables())
+	if err != nil {
+		return nil, err
+	}
+	includeOids, err := getOidsFromRelationList(conn, quotedIncludeRelations)
+	if err != nil {
+		return nil, err
+	}
+	if len(includeOids) == 0 {
+		return added, nil
+	}
+
+	oidStr := strings.Join(includeOids, ", ")
+	query := fmt.Sprintf(`
+SELECT
+	n.nspname AS schemaname,
+	c.relname AS tablename
+FROM pg_class c
+JOIN pg_namespace n
+	ON c.relnamespace = n.oid
+WHERE c.relkind = 'S'
+AND (
+	-- Get sequences owned by tables in the include list
+	c.oid IN (
+		SELECT
+			d.objid
+		FROM pg_depend d
+		WHERE d.classid = 'pg_class'::regclass
+		AND d.refclassid = 'pg_class'::regclass
+		AND d.deptype = 'a'
+		AND d.refobjid IN (%s)
+	)
+	-- Get sequences used in column defaults of tables in the include list
+	OR c.oid IN (
+		SELECT
+			d.refobjid
+		FROM pg_depend d
+		JOIN pg_attrdef ad ON ad.oid = d.objid
+		WHERE d.classid = 'pg_attrdef'::regclass
+		AND d.refclassid = 'pg_class'::regclass
+		AND ad.adrelid IN (%s)
+	)
+)
+AND %s
+ORDER BY n.nspname, c.relname;`, oidStr, oidStr, ExtensionFilterClause("c"))
+
+	sequences := make([]FqnStruct, 0)
+	err = conn.Select(&sequences, query)
+	if err != nil {
+		return nil, err
+	}
+
+	includeSet := map[string]bool{}
+	for _, include := range o.GetIncludedTables() {
+		includeSet[include] = true
+	}
+	for _, sequence := range sequences {
+		fqn := fmt.Sprintf("%s.%s", sequence.SchemaName, sequence.TableName)
+		if includeSet[fqn] {
+			continue
+		}
+		includeSet[fqn] = true
+		err = flags.Set(INCLUDE_RELATION, fqn)
+		if err != nil {
+			return nil, err
+		}
+		o.AddIncludedRelation(fqn)
+		added = append(added, fqn)
+	}
+
+	return added, nil
+}
+
+func matchesAnyRegex(regexes []*regexp.Regexp, fqn string) bool {
+	for _, regex := range regexes {
+		if regex.MatchString(fqn) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Adds the user tables matching --include-table-regex to the include list,
+ * and those matching --exclude-table-regex to the exclude list, as if they
+ * had been listed with --include-table and --exclude-table.  When both are
+ * given, a table matching both is neither included nor excluded, so the
+ * exclude pattern wins.  Returns an error if --include-table-regex leaves
+ * nothing included, which would otherwise back up every table.
+ */
+func (o *Options) ExpandRelationRegexes(conn *dbconn.DBConn, flags *pflag.FlagSet) error {
+	if len(o.includedRelationRegexes) == 0 && len(o.excludedRelationRegexes) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`
+SELECT
+	n.nspname AS schemaname,
+	c.relname AS tablename
+FROM pg_class c
+JOIN pg_namespace n
+	ON c.relnamespace = n.oid
+WHERE %s
+AND c.relkind IN ('r', 'p', 'f')
+AND %s
+ORDER BY n.nspname, c.relname;`, o.schemaFilterClause("n"), ExtensionFilterClause("c"))
+
+	tables := make([]FqnStruct, 0)
+	err := conn.Select(&tables, query)
+	if err != nil {
+		return err
+	}
+
+	listed := map[string]bool{}
+	for _, fqn := range append(o.GetIncludedTables(), o.GetExcludedTables()...) {
+		listed[fqn] = true
+	}
+	included := make([]string, 0)
+	for _, table := range tables {
+		fqn := fmt.Sprintf("%s.%s", table.SchemaName, table.TableName)
+		if listed[fqn] {
+			continue
+		}
+		excluded := matchesAnyRegex(o.excludedRelationRegexes, fqn)
+		if len(o.includedRelationRegexes) > 0 {
+			if !excluded && matchesAnyRegex(o.includedRelationRegexes, fqn) {
+				included = append(included, fqn)
+			}
+		} else if excluded {
+			err = flags.Set(EXCLUDE_RELATION, fqn)
+			if err != nil {
+				return err
+			}
+			o.ExcludedRelations = append(o.ExcludedRelations, fqn)
+		}
+	}
+	for _, fqn := range included {
+		err = flags.Set(INCLUDE_RELATION, fqn)
+		if err != nil {
+			return err
+		}
+		o.AddIncludedRelation(fqn)
+	}
+	o.originalIncludedRelations = append(append([]string{}, o.originalIncludedRelations...), included...)
+
+	if len(o.includedRelationRegexes) > 0 && len(o.GetIncludedTables()) == 0 {
+		return errors.Errorf("No tables matched --%s", INCLUDE_TABLE_REGEX)
+	}
+	return nil
+}
+
 func (o *Options) QuoteIncludeRelations(conn *dbconn.DBConn) error {
 	var err error
 	o.IncludedRelations, err = QuoteTableNames(conn, o.GetIncludedTables())
@@ -259,6 +561,16 @@ func (o *Options) QuoteIncludeRelations(conn *dbconn.DBConn) error {
 	return nil
 }
 
+/*
+ * Like the names in the mapping flags, the schema is matched exactly as given,
+ * or may be given in double quotes as in SQL.
+ */
+func (o *Options) QuoteRedirectSchema() {
+	if o.RedirectSchema != "" {
+		o.RedirectSchema = utils.QuoteIdentifier(utils.UnquoteIdent(o.RedirectSchema))
+	}
+}
+
 func (o Options) getUserTableRelationsWithIncludeFiltering(connectionPool *dbconn.DBConn, includedRelationsQuoted []string) ([]FqnStruct, error) {
 	includeOids, err := getOidsFromRelationList(connectionPool, includedRelationsQuoted)
 	if err != nil {
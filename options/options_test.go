@@ -1,6 +1,7 @@
 package options_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/spf13/pflag"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -92,6 +94,22 @@ var _ = Describe("options", func() {
 			Expect(includedTables[0]).To(Equal("myschema.mytable"))
 			Expect(includedTables[1]).To(Equal("myschema.mytable2"))
 		})
+		It("reports the line of an invalid entry in a table file", func() {
+			file, err := ioutil.TempFile("/tmp", "gpbackup_test_options*.txt")
+			Expect(err).To(Not(HaveOccurred()))
+			defer func() {
+				_ = os.Remove(file.Name())
+			}()
+			_, err = file.WriteString("myschema.mytable\n\nmytable2\n")
+			Expect(err).To(Not(HaveOccurred()))
+			err = file.Close()
+			Expect(err).To(Not(HaveOccurred()))
+
+			err = myflags.Set(options.INCLUDE_RELATION_FILE, file.Name())
+			Expect(err).ToNot(HaveOccurred())
+			_, err = options.NewOptions(myflags)
+			Expect(err).To(MatchError(fmt.Sprintf(`Invalid entry on line 3 of %s: Table "mytable2" is not correctly fully-qualified.  Please ensure table is in the format "schema.table" and both the schema and table does not contain a dot (.).`, file.Name())))
+		})
 		It("sets the INCLUDE_RELATIONS flag from file", func() {
 			file, err := ioutil.TempFile("/tmp", "gpbackup_test_options*.txt")
 			Expect(err).To(Not(HaveOccurred()))
@@ -196,6 +214,28 @@ var _ = Describe("options", func() {
 			Expect(subject.GetIncludedSchemas()[0]).To(Equal("my include schema"))
 			Expect(subject.GetExcludedSchemas()[0]).To(Equal("my exclude schema"))
 		})
+		It("returns the leaf partitions whose data is excluded from the flag and file", func() {
+			file, err := ioutil.TempFile("/tmp", "gpbackup_test_options*.txt")
+			Expect(err).To(Not(HaveOccurred()))
+			defer func() {
+				_ = os.Remove(file.Name())
+			}()
+			_, err = file.WriteString("public.sales_1_prt_2019\n\npublic.sales_1_prt_2020\n")
+			Expect(err).To(Not(HaveOccurred()))
+			err = file.Close()
+			Expect(err).To(Not(HaveOccurred()))
+			err = myflags.Set(options.EXCLUDE_DATA, "public.sales_1_prt_2018")
+			Expect(err).ToNot(HaveOccurred())
+			err = myflags.Set(options.EXCLUDE_DATA_FILE, file.Name())
+			Expect(err).ToNot(HaveOccurred())
+
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			expected := []string{"public.sales_1_prt_2018", "public.sales_1_prt_2019", "public.sales_1_prt_2020"}
+			Expect(subject.GetExcludedDataTables()).To(Equal(expected))
+			Expect(myflags.GetStringArray(options.EXCLUDE_DATA)).To(Equal(expected))
+		})
 		It("returns an error upon invalid inclusions", func() {
 			err := myflags.Set(options.INCLUDE_RELATION, "foo")
 			Expect(err).ToNot(HaveOccurred())
@@ -212,6 +252,71 @@ var _ = Describe("options", func() {
 			})
 		})
 	})
+	Describe("RemoveSystemRelations", func() {
+		It("removes toast and append-optimized auxiliary tables read from an include file", func() {
+			file, err := ioutil.TempFile("/tmp", "gpbackup_test_options*.txt")
+			Expect(err).To(Not(HaveOccurred()))
+			defer func() {
+				_ = os.Remove(file.Name())
+			}()
+			_, err = file.WriteString("public.foo\npg_toast.pg_toast_16384\npg_aoseg.pg_aoseg_16390\npg_bitmapindex.pg_bm_16400\n")
+			Expect(err).To(Not(HaveOccurred()))
+			err = file.Close()
+			Expect(err).To(Not(HaveOccurred()))
+			err = myflags.Set(options.INCLUDE_RELATION_FILE, file.Name())
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			removed, err := subject.RemoveSystemRelations(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			Expect(removed).To(Equal([]string{"pg_toast.pg_toast_16384", "pg_aoseg.pg_aoseg_16390", "pg_bitmapindex.pg_bm_16400"}))
+			Expect(subject.GetIncludedTables()).To(Equal([]string{"public.foo"}))
+			Expect(subject.GetOriginalIncludedTables()).To(Equal([]string{"public.foo"}))
+			includeFlag, err := myflags.GetStringArray(options.INCLUDE_RELATION)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(includeFlag).To(Equal([]string{"public.foo"}))
+		})
+		It("removes system relations from the exclude list", func() {
+			err := myflags.Set(options.EXCLUDE_RELATION, "public.foo")
+			Expect(err).ToNot(HaveOccurred())
+			err = myflags.Set(options.EXCLUDE_RELATION, "pg_catalog.pg_class")
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			removed, err := subject.RemoveSystemRelations(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			Expect(removed).To(Equal([]string{"pg_catalog.pg_class"}))
+			Expect(subject.GetExcludedTables()).To(Equal([]string{"public.foo"}))
+			excludeFlag, err := myflags.GetStringArray(options.EXCLUDE_RELATION)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(excludeFlag).To(Equal([]string{"public.foo"}))
+		})
+		It("returns an error if only system relations are included", func() {
+			err := myflags.Set(options.INCLUDE_RELATION, "pg_toast.pg_toast_16384")
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			_, err = subject.RemoveSystemRelations(myflags)
+			Expect(err).To(MatchError("Cannot filter on system or auxiliary relations, and no other relations were included: pg_toast.pg_toast_16384"))
+		})
+		It("leaves user relations alone", func() {
+			err := myflags.Set(options.INCLUDE_RELATION, "public.pg_toast_16384")
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			removed, err := subject.RemoveSystemRelations(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			Expect(removed).To(BeEmpty())
+			Expect(subject.GetIncludedTables()).To(Equal([]string{"public.pg_toast_16384"}))
+		})
+	})
 	Describe("SeparateSchemaAndTable", func() {
 		It("properly splits the strings", func() {
 			tableList := []string{"foo.Bar", "FOO.Bar", "FO!@#.BAR"}
@@ -250,6 +355,19 @@ var _ = Describe("options", func() {
 			Expect(err.Error()).To(ContainSubstring("foobar.baz.bam"))
 		})
 	})
+	Describe("QuoteRedirectSchema", func() {
+		DescribeTable("matches the schema exactly as given or in double quotes",
+			func(given string, expected string) {
+				subject := options.Options{RedirectSchema: given}
+				subject.QuoteRedirectSchema()
+				Expect(subject.RedirectSchema).To(Equal(expected))
+			},
+			Entry("a lowercase name", "sales", "sales"),
+			Entry("a mixed-case name", "Sales", `"Sales"`),
+			Entry("a quoted mixed-case name", `"Sales"`, `"Sales"`),
+			Entry("a quoted lowercase name", `"sales"`, "sales"),
+		)
+	})
 	Describe("QuoteTableNames", func() {
 		var (
 			conn   *dbconn.DBConn
@@ -310,4 +428,133 @@ var _ = Describe("options", func() {
 		//	})
 		//
 	})
+	Describe("ExpandIncludesForSequences", func() {
+		var (
+			conn   *dbconn.DBConn
+			mockdb sqlmock.Sqlmock
+		)
+		BeforeEach(func() {
+			conn, mockdb, _, _, _ = testhelper.SetupTestEnvironment()
+		})
+
+		It("does nothing when no tables are included", func() {
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			added, err := subject.ExpandIncludesForSequences(conn, myflags)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(added).To(BeEmpty())
+			Expect(subject.GetIncludedTables()).To(BeEmpty())
+		})
+		It("adds each sequence used by the included tables once, skipping sequences already included", func() {
+			err := myflags.Set(options.INCLUDE_RELATION, "public.orders")
+			Expect(err).ToNot(HaveOccurred())
+			err = myflags.Set(options.INCLUDE_RELATION, "public.shared_seq")
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			mockdb.ExpectQuery("SELECT quote_ident").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename"}).AddRow("public", "orders"))
+			mockdb.ExpectQuery("SELECT quote_ident").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename"}).AddRow("public", "shared_seq"))
+			mockdb.ExpectQuery("SELECT c.oid AS string").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("1").AddRow("2"))
+			mockdb.ExpectQuery("WHERE c.relkind = 'S'").WillReturnRows(sqlmock.NewRows([]string{"schemaname", "tablename"}).
+				AddRow("public", "orders_id_seq").
+				AddRow("public", "shared_seq"))
+
+			added, err := subject.ExpandIncludesForSequences(conn, myflags)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(added).To(Equal([]string{"public.orders_id_seq"}))
+			Expect(subject.GetIncludedTables()).To(Equal([]string{"public.orders", "public.shared_seq", "public.orders_id_seq"}))
+			Expect(myflags.GetStringArray(options.INCLUDE_RELATION)).To(Equal([]string{"public.orders", "public.shared_seq", "public.orders_id_seq"}))
+			Expect(subject.GetOriginalIncludedTables()).To(Equal([]string{"public.orders", "public.shared_seq"}))
+		})
+	})
+	Describe("ExpandRelationRegexes", func() {
+		var (
+			conn   *dbconn.DBConn
+			mockdb sqlmock.Sqlmock
+		)
+		tableRows := func() *sqlmock.Rows {
+			return sqlmock.NewRows([]string{"schemaname", "tablename"}).
+				AddRow("public", "sales_2019").
+				AddRow("public", "sales_2020").
+				AddRow("public", "sales_2020_archive").
+				AddRow("public", "old_sales_2020").
+				AddRow("public", "orders")
+		}
+		BeforeEach(func() {
+			conn, mockdb, _, _, _ = testhelper.SetupTestEnvironment()
+		})
+
+		It("does not query the database when no patterns are given", func() {
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			err = subject.ExpandRelationRegexes(conn, myflags)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(mockdb.ExpectationsWereMet()).To(Succeed())
+		})
+		It("includes only tables whose whole name matches a pattern", func() {
+			err := myflags.Set(options.INCLUDE_TABLE_REGEX, `public\.sales_\d{4}`)
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			mockdb.ExpectQuery("AND c.relkind IN").WillReturnRows(tableRows())
+			err = subject.ExpandRelationRegexes(conn, myflags)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(subject.GetIncludedTables()).To(Equal([]string{"public.sales_2019", "public.sales_2020"}))
+			Expect(subject.GetOriginalIncludedTables()).To(Equal([]string{"public.sales_2019", "public.sales_2020"}))
+			Expect(myflags.GetStringArray(options.INCLUDE_RELATION)).To(Equal([]string{"public.sales_2019", "public.sales_2020"}))
+		})
+		It("includes the union of exact names and matches, leaving out tables matching an exclude pattern", func() {
+			err := myflags.Set(options.INCLUDE_RELATION, "public.orders")
+			Expect(err).ToNot(HaveOccurred())
+			err = myflags.Set(options.INCLUDE_TABLE_REGEX, `public\..*sales.*`)
+			Expect(err).ToNot(HaveOccurred())
+			err = myflags.Set(options.EXCLUDE_TABLE_REGEX, `.*_archive`)
+			Expect(err).ToNot(HaveOccurred())
+			err = myflags.Set(options.EXCLUDE_TABLE_REGEX, `public\.old_.*`)
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			mockdb.ExpectQuery("AND c.relkind IN").WillReturnRows(tableRows())
+			err = subject.ExpandRelationRegexes(conn, myflags)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(subject.GetIncludedTables()).To(Equal([]string{"public.orders", "public.sales_2019", "public.sales_2020"}))
+			Expect(subject.GetExcludedTables()).To(BeEmpty())
+		})
+		It("adds tables matching an exclude pattern to the exact exclude list", func() {
+			err := myflags.Set(options.EXCLUDE_RELATION, "public.orders")
+			Expect(err).ToNot(HaveOccurred())
+			err = myflags.Set(options.EXCLUDE_TABLE_REGEX, `public\.sales_2020.*`)
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			mockdb.ExpectQuery("AND c.relkind IN").WillReturnRows(tableRows())
+			err = subject.ExpandRelationRegexes(conn, myflags)
+			Expect(err).To(Not(HaveOccurred()))
+			Expect(subject.GetIncludedTables()).To(BeEmpty())
+			Expect(subject.GetExcludedTables()).To(Equal([]string{"public.orders", "public.sales_2020", "public.sales_2020_archive"}))
+			Expect(myflags.GetStringArray(options.EXCLUDE_RELATION)).To(Equal([]string{"public.orders", "public.sales_2020", "public.sales_2020_archive"}))
+		})
+		It("returns an error if no tables match an include pattern", func() {
+			err := myflags.Set(options.INCLUDE_TABLE_REGEX, "sales_2020")
+			Expect(err).ToNot(HaveOccurred())
+			subject, err := options.NewOptions(myflags)
+			Expect(err).To(Not(HaveOccurred()))
+
+			mockdb.ExpectQuery("AND c.relkind IN").WillReturnRows(tableRows())
+			err = subject.ExpandRelationRegexes(conn, myflags)
+			Expect(err).To(MatchError("No tables matched --include-table-regex"))
+		})
+		It("returns an error for an invalid pattern", func() {
+			err := myflags.Set(options.EXCLUDE_TABLE_REGEX, "public.sales_(2020")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = options.NewOptions(myflags)
+			Expect(err).To(MatchError("Invalid --exclude-table-regex pattern public.sales_(2020: error parsing regexp: missing closing ): `^(?:public.sales_(2020)$`"))
+		})
+	})
 })
@@ -3,7 +3,6 @@ package report
 import (
 	"fmt"
 	"io"
-	"os"
 	"regexp"
 	"sort"
 	"strings"
@@ -27,11 +26,48 @@ import (
  * file that we will want to read in for a restore.
  */
 type Report struct {
-	BackupParamsString string
-	DatabaseSize       string
+	BackupParamsString       string
+	DatabaseSize             string
+	MixedOwnershipPartitions []string
+	OrphanedPartitions       []string
+	TablesWithOids           []string
+	InaccessibleTables       []string
+	ManualAttentionTables    []string
+	PartialDataTables        []string
+	InvalidObjects           []string
+	SkippedDataTables        map[string]int
+	RetryCount               int
+	HostConcurrency          map[string]int
+	Tables                   []TableStats
+	Warnings                 []Warning
+	Errors                   []string
 	history.BackupConfig
 }
 
+/*
+ * The timings of an index dropped by gprestore --rebuild-indexes before a
+ * data load and re-created after it.  Durations are in nanoseconds in the
+ * JSON report.
+ */
+type IndexRebuild struct {
+	Index          string        `json:"index"`
+	Table          string        `json:"table"`
+	DropDuration   time.Duration `json:"dropDurationNanoseconds"`
+	CreateDuration time.Duration `json:"createDurationNanoseconds"`
+	Recreated      bool          `json:"recreated"`
+	Error          string        `json:"error,omitempty"`
+}
+
+/*
+ * The time taken to add a constraint in the post-data of a restore.  The
+ * duration is in nanoseconds in the JSON report.
+ */
+type ConstraintTiming struct {
+	Constraint string        `json:"constraint"`
+	Table      string        `json:"table"`
+	Duration   time.Duration `json:"durationNanoseconds"`
+}
+
 type LineInfo struct {
 	Key   string
 	Value string
@@ -72,6 +108,9 @@ func (report *Report) ConstructBackupParamsString() {
 	pluginStr := "None"
 	if report.Plugin != "" {
 		pluginStr = report.Plugin
+		if report.DataPlugin != "" {
+			pluginStr += fmt.Sprintf(" (data files: %s)", report.DataPlugin)
+		}
 	}
 	sectionStr := "All Sections"
 	if report.DataOnly {
@@ -79,6 +118,9 @@ func (report *Report) ConstructBackupParamsString() {
 	}
 	if report.MetadataOnly {
 		sectionStr = "Metadata Only"
+		if report.NoDataLocks {
+			sectionStr = "Metadata Only (tables not locked)"
+		}
 	}
 	filesStr := "Multiple Data Files Per Segment"
 	if report.MetadataOnly {
@@ -102,6 +144,10 @@ data file format: %s
 }
 
 func (report *Report) constructIncrementalSection() string {
+	if report.HeuristicIncremental {
+		return fmt.Sprintf(`incremental: Heuristic
+changed since: %s`, report.ChangedSince)
+	}
 	if !report.Incremental {
 		return "incremental: False"
 	}
@@ -114,111 +160,8 @@ incremental backup set:
 %s`, strings.Join(backupTimestamps, "\n"))
 }
 
-func (report *Report) WriteBackupReportFile(reportFilename string, timestamp string, endtime time.Time, objectCounts map[string]int, errMsg string) {
-	reportFile, err := iohelper.OpenFileForWriting(reportFilename)
-	if err != nil {
-		gplog.Error("Unable to open backup report file %s", reportFilename)
-		return
-	}
-
-	gpbackupCommandLine := strings.Join(os.Args, " ")
-	start, end, duration := GetDurationInfo(timestamp, endtime)
-
-	reportInfo := make([]LineInfo, 0)
-	reportInfo = append(reportInfo,
-		LineInfo{Key: "timestamp key:", Value: timestamp},
-		LineInfo{Key: "gpdb version:", Value: report.DatabaseVersion},
-		LineInfo{Key: "gpbackup version:", Value: fmt.Sprintf("%s\n", report.BackupVersion)},
-		LineInfo{Key: "database name:", Value: report.DatabaseName},
-		LineInfo{Key: "command line:", Value: gpbackupCommandLine},
-	)
-
-	AppendBackupParams(&reportInfo, report.BackupParamsString)
-
-	reportInfo = append(reportInfo,
-		LineInfo{},
-		LineInfo{Key: "start time:", Value: start},
-		LineInfo{Key: "end time:", Value: end},
-		LineInfo{Key: "duration:", Value: duration})
-
-	if errMsg != "" {
-		reportInfo = append(reportInfo,
-			LineInfo{},
-			LineInfo{Key: "backup status:", Value: history.BackupStatusFailed},
-			LineInfo{Key: "backup error:", Value: errMsg})
-	} else {
-		reportInfo = append(reportInfo,
-			LineInfo{},
-			LineInfo{Key: "backup status:", Value: history.BackupStatusSucceed})
-	}
-	if report.DatabaseSize != "" {
-		reportInfo = append(reportInfo,
-			LineInfo{},
-			LineInfo{Key: "database size:", Value: strings.ToUpper(report.DatabaseSize)})
-	}
-
-	_, err = fmt.Fprint(reportFile, "Greenplum Database Backup Report\n\n")
-	if err != nil {
-		gplog.Error("Unable to write backup report file %s", reportFilename)
-		return
-	}
-
-	logOutputReport(reportFile, reportInfo)
-
-	PrintObjectCounts(reportFile, objectCounts)
-
-	err = reportFile.Close()
-	gplog.FatalOnError(err)
-	_ = operating.System.Chmod(reportFilename, 0444)
-}
-
-func WriteRestoreReportFile(reportFilename string, backupTimestamp string, startTimestamp string, connectionPool *dbconn.DBConn, restoreVersion string, errMsg string) {
-	reportFile, err := iohelper.OpenFileForWriting(reportFilename)
-	if err != nil {
-		gplog.Error("Unable to open restore report file %s", reportFilename)
-		return
-	}
-
-	gprestoreCommandLine := strings.Join(os.Args, " ")
-	start, end, duration := GetDurationInfo(startTimestamp, operating.System.Now())
-
-	utils.MustPrintf(reportFile, "Greenplum Database Restore Report\n\n")
-
-	reportInfo := make([]LineInfo, 0)
-	reportInfo = append(reportInfo,
-		LineInfo{Key: "timestamp key:", Value: backupTimestamp},
-		LineInfo{Key: "gpdb version:", Value: connectionPool.Version.VersionString},
-		LineInfo{Key: "gprestore version:", Value: fmt.Sprintf("%s\n", restoreVersion)},
-		LineInfo{Key: "database name:", Value: connectionPool.DBName},
-		LineInfo{Key: "command line:", Value: fmt.Sprintf("%s\n", gprestoreCommandLine)},
-		LineInfo{Key: "start time:", Value: start},
-		LineInfo{Key: "end time:", Value: end},
-		LineInfo{Key: "duration:", Value: duration},
-	)
-
-	var restoreStatus string
-	errorCode := gplog.GetErrorCode()
-	if errorCode == 1 {
-		restoreStatus = fmt.Sprintf("Success but non-fatal errors occurred. See log file %s for details.", gplog.GetLogFilePath())
-		reportInfo = append(reportInfo,
-			LineInfo{},
-			LineInfo{Key: "restore status:", Value: restoreStatus})
-	} else if errMsg != "" {
-		reportInfo = append(reportInfo,
-			LineInfo{},
-			LineInfo{Key: "restore status:", Value: "Failure"},
-			LineInfo{Key: "restore error:", Value: errMsg})
-	} else {
-		reportInfo = append(reportInfo,
-			LineInfo{},
-			LineInfo{Key: "restore status:", Value: "Success"})
-	}
-
-	logOutputReport(reportFile, reportInfo)
-
-	err = reportFile.Close()
-	gplog.FatalOnError(err)
-	_ = operating.System.Chmod(reportFilename, 0444)
+func (report *Report) WriteBackupReportFile(reportFilename string, jsonFilename string, timestamp string, endtime time.Time, objectCounts map[string]int, errMsg string) {
+	report.NewStructuredReport(timestamp, endtime, objectCounts, errMsg).WriteReportFiles(reportFilename, jsonFilename)
 }
 
 func logOutputReport(reportFile io.WriteCloser, reportInfo []LineInfo) {
@@ -278,6 +221,142 @@ func PrintObjectCounts(reportFile io.WriteCloser, objectCounts map[string]int) {
 	utils.MustPrintf(reportFile, objectStr)
 }
 
+/*
+ * Lists such as the tables created WITH OIDS or the invalid indexes and
+ * constraints are printed under a header, and left out of the report when
+ * they are empty.
+ */
+func PrintList(reportFile io.WriteCloser, header string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	listStr := fmt.Sprintf("\n%s:\n", header)
+	for _, item := range items {
+		listStr += fmt.Sprintf("%s\n", item)
+	}
+	utils.MustPrintf(reportFile, listStr)
+}
+
+/*
+ * Tables such as external and foreign tables have only their metadata backed
+ * up, which we count by the reason recorded in the TOC so that their data is
+ * not mistaken for missing.
+ */
+func PrintSkippedDataTables(reportFile io.WriteCloser, skippedCounts map[string]int) {
+	if len(skippedCounts) == 0 {
+		return
+	}
+	reasons := make([]string, 0, len(skippedCounts))
+	for reason := range skippedCounts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	skippedStr := "\ntables backed up without data:\n"
+	for _, reason := range reasons {
+		skippedStr += fmt.Sprintf("%s: %d\n", strings.ToLower(reason), skippedCounts[reason])
+	}
+	utils.MustPrintf(reportFile, skippedStr)
+}
+
+/*
+ * Restore workers that lose their connection reconnect and retry, so a
+ * restore can succeed despite an unstable network.  We list how often each
+ * connection had to reconnect so that such instability is not hidden.
+ */
+func PrintReconnectEvents(reportFile io.WriteCloser, reconnectCounts map[int]int) {
+	if len(reconnectCounts) == 0 {
+		return
+	}
+	connNums := make([]int, 0, len(reconnectCounts))
+	for connNum := range reconnectCounts {
+		connNums = append(connNums, connNum)
+	}
+	sort.Ints(connNums)
+	reconnectStr := "\nreconnect events:\n"
+	for _, connNum := range connNums {
+		reconnectStr += fmt.Sprintf("connection %d: %d\n", connNum, reconnectCounts[connNum])
+	}
+	utils.MustPrintf(reportFile, reconnectStr)
+}
+
+func PrintIndexRebuilds(reportFile io.WriteCloser, indexRebuilds []IndexRebuild) {
+	if len(indexRebuilds) == 0 {
+		return
+	}
+	rebuildStr := "\nrebuilt indexes:\n"
+	for _, rebuild := range indexRebuilds {
+		status := fmt.Sprintf("re-created in %s", rebuild.CreateDuration.Round(time.Millisecond))
+		if rebuild.Error != "" {
+			status = fmt.Sprintf("not re-created: %s", rebuild.Error)
+		} else if !rebuild.Recreated {
+			status = "not re-created"
+		}
+		rebuildStr += fmt.Sprintf("%s on %s: dropped in %s, %s\n", rebuild.Index, rebuild.Table, rebuild.DropDuration.Round(time.Millisecond), status)
+	}
+	utils.MustPrintf(reportFile, rebuildStr)
+}
+
+func PrintSlowestConstraints(reportFile io.WriteCloser, timings []ConstraintTiming) {
+	if len(timings) == 0 {
+		return
+	}
+	constraintStr := "\nslowest constraints:\n"
+	for _, timing := range timings {
+		constraintStr += fmt.Sprintf("%s on %s: %s\n", timing.Constraint, timing.Table, timing.Duration.Round(time.Millisecond))
+	}
+	utils.MustPrintf(reportFile, constraintStr)
+}
+
+/*
+ * Conversion by gpbackup_helper applies --on-conversion-error, so we list the
+ * rows of each table that it skipped or replaced characters in.
+ */
+func PrintEncodingConversion(reportFile io.WriteCloser, conversion *EncodingConversion) {
+	if conversion == nil {
+		return
+	}
+	conversionStr := fmt.Sprintf("\nencoding conversion: %s to %s by the server\n", conversion.SourceEncoding, conversion.TargetEncoding)
+	if conversion.Method == ENCODING_CONVERSION_HELPER {
+		conversionStr = fmt.Sprintf("\nencoding conversion: %s to %s by gpbackup_helper (on error: %s)\n", conversion.SourceEncoding, conversion.TargetEncoding, conversion.OnError)
+	}
+	if len(conversion.FailedRows) > 0 {
+		conversionStr += "rows that failed conversion:\n"
+		for _, table := range conversion.FailedRows {
+			conversionStr += fmt.Sprintf("%s: %d\n", table.Table, table.Rows)
+		}
+	}
+	utils.MustPrintf(reportFile, conversionStr)
+}
+
+/*
+ * With --max-concurrent-per-host, we list the most segments on each host that
+ * copied data at the same time, so that a limit that is never reached, or a
+ * host that never reaches it, is visible.
+ */
+func PrintTableLoadRates(reportFile io.WriteCloser, rates *TableLoadRates) {
+	if rates == nil {
+		return
+	}
+	utils.MustPrintf(reportFile, "\ntable load rates:\np50: %.0f rows/sec, %.2f MB/sec\np95: %.0f rows/sec, %.2f MB/sec\n",
+		rates.RowsPerSecondP50, rates.MBPerSecondP50, rates.RowsPerSecondP95, rates.MBPerSecondP95)
+}
+
+func PrintHostConcurrency(reportFile io.WriteCloser, maxPerHost int, hostConcurrency map[string]int) {
+	if maxPerHost <= 0 || len(hostConcurrency) == 0 {
+		return
+	}
+	hosts := make([]string, 0, len(hostConcurrency))
+	for host := range hostConcurrency {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	concurrencyStr := fmt.Sprintf("\nper-host data concurrency (limit %d):\n", maxPerHost)
+	for _, host := range hosts {
+		concurrencyStr += fmt.Sprintf("%s: %d\n", host, hostConcurrency[host])
+	}
+	utils.MustPrintf(reportFile, concurrencyStr)
+}
+
 /*
  * This function will not error out if the user has gprestore X.Y.Z
  * and gpbackup X.Y.Z+dev, when technically the uncommitted code changes
@@ -299,6 +378,21 @@ func EnsureBackupVersionCompatibility(backupVersion string, restoreVersion strin
 	}
 }
 
+/*
+ * Backups that record a format version are checked against the formats this
+ * gprestore supports rather than against the gpbackup version, so that a
+ * newer gpbackup that did not change the format can still be restored.
+ */
+func EnsureFormatCompatibility(backupVersion string, fileType string, formatVersion int, supportedFormatVersion int, unknownRequiredSections []string) {
+	if formatVersion > supportedFormatVersion {
+		gplog.Fatal(utils.NewFormatVersionError(backupVersion, fileType, formatVersion, supportedFormatVersion), "")
+	}
+	if len(unknownRequiredSections) > 0 {
+		gplog.Fatal(errors.Errorf("backup was created by gpbackup %s using %s format %d, which requires sections this gprestore does not support: %s",
+			backupVersion, fileType, formatVersion, strings.Join(unknownRequiredSections, ", ")), "")
+	}
+}
+
 func EnsureDatabaseVersionCompatibility(backupGPDBVersion string, restoreGPDBVersion dbconn.GPDBVersion) {
 	pattern := regexp.MustCompile(`\d+\.\d+\.\d+`)
 	threeDigitVersion := pattern.FindStringSubmatch(backupGPDBVersion)[0]
@@ -309,6 +403,25 @@ func EnsureDatabaseVersionCompatibility(backupGPDBVersion string, restoreGPDBVer
 	}
 }
 
+/*
+ * The binary COPY format depends on the server's internal representation of
+ * each type, so binary data is only restored into the major version it was
+ * backed up from.
+ */
+func EnsureBinaryDataCompatibility(backupGPDBVersion string, restoreGPDBVersion dbconn.GPDBVersion) {
+	pattern := regexp.MustCompile(`\d+\.\d+\.\d+`)
+	threeDigitVersion := pattern.FindStringSubmatch(backupGPDBVersion)[0]
+	backupGPDBSemVer, err := semver.Make(threeDigitVersion)
+	gplog.FatalOnError(err)
+	if backupGPDBSemVer.Major != restoreGPDBVersion.SemVer.Major {
+		gplog.Fatal(errors.Errorf("Cannot restore binary format data from GPDB version %s to %s.", backupGPDBVersion, restoreGPDBVersion.VersionString), "")
+	}
+	if !backupGPDBSemVer.Equals(restoreGPDBVersion.SemVer) {
+		gplog.Warn("This backup contains binary format data from GPDB version %s, which is being restored to %s.", backupGPDBVersion, restoreGPDBVersion.VersionString)
+		gplog.Warn("Binary format data must also be restored to a cluster with the same architecture as the one it was backed up from.")
+	}
+}
+
 type ContactFile struct {
 	Contacts map[string][]EmailContact
 }
@@ -1,6 +1,7 @@
 package report_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -59,6 +60,18 @@ var _ = Describe("utils/report tests", func() {
 			Expect(errMsg).To(Equal(""))
 		})
 	})
+	Describe("ConstructBackupParamsString", func() {
+		It("notes a metadata-only backup that did not lock tables", func() {
+			backupReport := &Report{BackupConfig: history.BackupConfig{MetadataOnly: true, NoDataLocks: true}}
+			backupReport.ConstructBackupParamsString()
+			Expect(backupReport.BackupParamsString).To(ContainSubstring("backup section: Metadata Only (tables not locked)\n"))
+		})
+		It("labels a heuristic incremental backup with the backup it was compared against", func() {
+			backupReport := &Report{BackupConfig: history.BackupConfig{HeuristicIncremental: true, ChangedSince: "20170101010101"}}
+			backupReport.ConstructBackupParamsString()
+			Expect(backupReport.BackupParamsString).To(HaveSuffix("incremental: Heuristic\nchanged since: 20170101010101"))
+		})
+	})
 	Describe("WriteBackupReportFile", func() {
 		timestamp := "20170101010101"
 		endtime := time.Date(2017, 1, 1, 5, 4, 3, 2, time.Local)
@@ -68,6 +81,7 @@ var _ = Describe("utils/report tests", func() {
 			DatabaseVersion: "5.0.0 build test",
 		}
 		backupReport := &Report{}
+		var jsonBuffer *Buffer
 		objectCounts := map[string]int{"tables": 42, "sequences": 1, "types": 1000}
 		BeforeEach(func() {
 			backupReport = &Report{
@@ -79,7 +93,11 @@ data file format: Single Data File Per Segment`,
 				DatabaseSize: "42 MB",
 				BackupConfig: config,
 			}
+			jsonBuffer = NewBuffer()
 			operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+				if strings.HasSuffix(name, ".json") {
+					return jsonBuffer, nil
+				}
 				return buffer, nil
 			}
 			operating.System.Now = func() time.Time {
@@ -92,7 +110,7 @@ data file format: Single Data File Per Segment`,
 		})
 
 		It("writes a report for a successful backup", func() {
-			backupReport.WriteBackupReportFile("filename", timestamp, endtime, objectCounts, "")
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
 			Expect(buffer).To(Say(`Greenplum Database Backup Report
 
 timestamp key:         20170101010101
@@ -121,7 +139,7 @@ tables      42
 types       1000`))
 		})
 		It("writes a report for a failed backup", func() {
-			backupReport.WriteBackupReportFile("filename", timestamp, endtime, objectCounts, "Cannot access /tmp/backups: Permission denied")
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "Cannot access /tmp/backups: Permission denied")
 			Expect(buffer).To(Say(`Greenplum Database Backup Report
 
 timestamp key:         20170101010101
@@ -149,10 +167,18 @@ count of database objects in backup:
 sequences   1
 tables      42
 types       1000`))
+		})
+		It("writes a report for a backup that succeeded after retrying", func() {
+			backupReport.RetryCount = 2
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`backup status:         Success
+retry attempts:        2
+
+database size:         42 MB`))
 		})
 		It("writes a report without database size information", func() {
 			backupReport.DatabaseSize = ""
-			backupReport.WriteBackupReportFile("filename", timestamp, endtime, objectCounts, "")
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
 			Expect(buffer).To(Say(`Greenplum Database Backup Report
 
 timestamp key:         20170101010101
@@ -178,6 +204,130 @@ sequences   1
 tables      42
 types       1000`))
 		})
+		It("writes a report listing partition tables with mixed ownership", func() {
+			backupReport.MixedOwnershipPartitions = []string{"public.sales", "public.events"}
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`count of database objects in backup:
+sequences   1
+tables      42
+types       1000
+
+partition tables with mixed ownership:
+public.sales
+public.events`))
+		})
+		It("writes a report listing orphaned child partitions", func() {
+			backupReport.OrphanedPartitions = []string{"public.sales_1_prt_2"}
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`types       1000
+
+orphaned child partitions:
+public.sales_1_prt_2`))
+		})
+		It("writes a report listing tables with OIDS", func() {
+			backupReport.TablesWithOids = []string{"public.legacy"}
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`types       1000
+
+tables with OIDS:
+public.legacy`))
+		})
+		It("writes a report listing tables without SELECT privilege", func() {
+			backupReport.InaccessibleTables = []string{"public.secrets (SELECT)", "public.payroll (SELECT on columns salary)"}
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`types       1000
+
+tables without SELECT privilege:
+public.secrets \(SELECT\)
+public.payroll \(SELECT on columns salary\)`))
+		})
+		It("writes a report listing tables requiring manual attention", func() {
+			backupReport.ManualAttentionTables = []string{"public.sales (partition definition could not be read)"}
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`types       1000
+
+tables requiring manual attention:
+public.sales \(partition definition could not be read\)`))
+		})
+		It("writes a report listing tables with partially backed up data", func() {
+			backupReport.PartialDataTables = []string{"public.docs (data not backed up for columns body)"}
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`types       1000
+
+tables with partially backed up data:
+public.docs \(data not backed up for columns body\)`))
+		})
+		It("writes a report listing warnings with their codes", func() {
+			backupReport.Warnings = []Warning{
+				{Code: WARN_INACCESSIBLE_TABLE.Code, Name: WARN_INACCESSIBLE_TABLE.Name, Object: "public.secrets", Message: "Skipping data backup of table public.secrets: missing SELECT privilege"},
+				{Code: WARN_UNCATEGORIZED.Code, Name: WARN_UNCATEGORIZED.Name, Message: "Something else happened"},
+			}
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+			Expect(buffer).To(Say(`types       1000
+
+warnings:
+W001 INACCESSIBLE_TABLE: Skipping data backup of table public.secrets: missing SELECT privilege
+W000 UNCATEGORIZED: Something else happened`))
+		})
+		It("writes a JSON report with the same contents as the text report", func() {
+			backupReport.RetryCount = 1
+			backupReport.Tables = []TableStats{{Table: "public.foo", Rows: 10}}
+			backupReport.Warnings = []Warning{{Code: WARN_NO_TABLE_LOCKS.Code, Name: WARN_NO_TABLE_LOCKS.Name, Message: "Tables will not be locked"}}
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "")
+
+			structured := StructuredReport{}
+			err := json.Unmarshal(jsonBuffer.Contents(), &structured)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(structured.Type).To(Equal(REPORT_TYPE_BACKUP))
+			Expect(structured.Status).To(Equal(history.BackupStatusSucceed))
+			Expect(structured.Parameters.Timestamp).To(Equal(timestamp))
+			Expect(structured.Parameters.DatabaseName).To(Equal("testdb"))
+			Expect(structured.Parameters.Version).To(Equal("0.1.0"))
+			Expect(structured.Parameters.Options).To(Equal([]ReportOption{
+				{Name: "compression", Value: "gzip"},
+				{Name: "backup section", Value: "All Sections"},
+				{Name: "object filtering", Value: "None"},
+				{Name: "includes statistics", Value: "No"},
+				{Name: "data file format", Value: "Single Data File Per Segment"},
+			}))
+			Expect(structured.Timings.DurationSeconds).To(Equal(float64(4*3600 + 3*60 + 2)))
+			Expect(structured.ObjectCounts).To(Equal(objectCounts))
+			Expect(structured.Tables).To(Equal([]TableStats{{Table: "public.foo", Rows: 10}}))
+			Expect(structured.Backup.RetryCount).To(Equal(1))
+			Expect(structured.Backup.DatabaseSize).To(Equal("42 MB"))
+			Expect(structured.Warnings).To(Equal(backupReport.Warnings))
+			Expect(structured.Errors).To(BeEmpty())
+		})
+		It("writes a JSON report listing the incremental backup set and error of a failed backup", func() {
+			backupReport.BackupParamsString = `compression: gzip
+incremental: True
+incremental backup set:
+20170101010100
+20170101010101`
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "Cannot access /tmp/backups: Permission denied")
+
+			structured := StructuredReport{}
+			err := json.Unmarshal(jsonBuffer.Contents(), &structured)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(structured.Status).To(Equal(history.BackupStatusFailed))
+			Expect(structured.Error).To(Equal("Cannot access /tmp/backups: Permission denied"))
+			Expect(structured.Errors).To(Equal([]string{"Cannot access /tmp/backups: Permission denied"}))
+			Expect(structured.Parameters.Options).To(Equal([]ReportOption{{Name: "compression", Value: "gzip"}, {Name: "incremental", Value: "True"}}))
+			Expect(structured.Parameters.IncrementalBackupSet).To(Equal([]string{"20170101010100", "20170101010101"}))
+			Expect(structured.Tables).To(BeEmpty())
+			Expect(structured.Warnings).To(BeEmpty())
+		})
+		It("writes the error class and exit code of a failed backup to the JSON report", func() {
+			defer utils.ResetErrorClass()
+			utils.SetErrorPhase(utils.ERROR_CLASS_DATA)
+			backupReport.WriteBackupReportFile("filename", "filename.json", timestamp, endtime, objectCounts, "Error writing data for table public.foo")
+
+			structured := StructuredReport{}
+			err := json.Unmarshal(jsonBuffer.Contents(), &structured)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(structured.ErrorClass).To(Equal(utils.ERROR_CLASS_DATA))
+			Expect(structured.ExitCode).To(Equal(utils.EXIT_DATA))
+		})
 	})
 	Describe("AppendBackupParams", func() {
 		It("correctly parses the string and appends to the LineInfo array", func() {
@@ -211,10 +361,11 @@ incremental backup set:
 
 		})
 	})
-	Describe("WriteRestoreReportFile", func() {
+	Describe("NewRestoreStructuredReport", func() {
 		timestamp := "20170101010101"
 		restoreStartTime := "20170101010102"
 		restoreVersion := "0.1.0"
+		var jsonBuffer *Buffer
 		connectionPool := &dbconn.DBConn{
 			DBName: "testdb",
 			Version: dbconn.GPDBVersion{
@@ -222,7 +373,11 @@ incremental backup set:
 			},
 		}
 		BeforeEach(func() {
+			jsonBuffer = NewBuffer()
 			operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+				if strings.HasSuffix(name, ".json") {
+					return jsonBuffer, nil
+				}
 				return buffer, nil
 			}
 			operating.System.Now = func() time.Time {
@@ -239,7 +394,7 @@ incremental backup set:
 
 		It("writes a report for a failed restore", func() {
 			gplog.SetErrorCode(2)
-			WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, "Cannot access /tmp/backups: Permission denied")
+			NewRestoreStructuredReport(timestamp, timestamp, restoreStartTime, connectionPool, restoreVersion, "Cannot access /tmp/backups: Permission denied").WriteReportFiles("filename", "filename.json")
 			Expect(buffer).To(Say(`Greenplum Database Restore Report
 
 timestamp key:       20170101010101
@@ -258,7 +413,7 @@ restore error:       Cannot access /tmp/backups: Permission denied`))
 		})
 		It("writes a report for a successful restore", func() {
 			gplog.SetErrorCode(0)
-			WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, "")
+			NewRestoreStructuredReport(timestamp, timestamp, restoreStartTime, connectionPool, restoreVersion, "").WriteReportFiles("filename", "filename.json")
 			Expect(buffer).To(Say(`Greenplum Database Restore Report
 
 timestamp key:       20170101010101
@@ -276,7 +431,7 @@ restore status:      Success`))
 		})
 		It("writes a report for a successful restore with errors", func() {
 			gplog.SetErrorCode(1)
-			WriteRestoreReportFile("filename", timestamp, restoreStartTime, connectionPool, restoreVersion, "")
+			NewRestoreStructuredReport(timestamp, timestamp, restoreStartTime, connectionPool, restoreVersion, "").WriteReportFiles("filename", "filename.json")
 			Expect(buffer).To(Say(`Greenplum Database Restore Report
 
 timestamp key:       20170101010101
@@ -292,6 +447,196 @@ duration:            4:03:01
 
 restore status:      Success but non-fatal errors occurred. See log file .+ for details.`))
 		})
+		It("writes a report listing reconnect events in connection order", func() {
+			gplog.SetErrorCode(0)
+			restoreReport := NewRestoreStructuredReport(timestamp, timestamp, restoreStartTime, connectionPool, restoreVersion, "")
+			restoreReport.Restore = &RestoreDetails{ReconnectEvents: map[int]int{2: 1, 0: 3}}
+			restoreReport.WriteReportFiles("filename", "filename.json")
+			Expect(buffer).To(Say(`restore status:      Success
+
+reconnect events:
+connection 0: 3
+connection 2: 1`))
+		})
+		It("writes a report noting the restore point when restoring an earlier backup in the chain", func() {
+			gplog.SetErrorCode(0)
+			NewRestoreStructuredReport(timestamp, "20161231010101", restoreStartTime, connectionPool, restoreVersion, "").WriteReportFiles("filename", "filename.json")
+			Expect(buffer).To(Say(`timestamp key:       20170101010101
+restored as of:      20161231010101
+gpdb version:`))
+		})
+		It("writes a report listing the number of retried statements", func() {
+			gplog.SetErrorCode(0)
+			restoreReport := NewRestoreStructuredReport(timestamp, timestamp, restoreStartTime, connectionPool, restoreVersion, "")
+			restoreReport.Restore = &RestoreDetails{RetriedStatements: 2}
+			restoreReport.WriteReportFiles("filename", "filename.json")
+			Expect(buffer).To(Say(`restore status:      Success
+
+retried statements: 2`))
+		})
+		It("writes a report listing each rebuilt index with its timings", func() {
+			gplog.SetErrorCode(0)
+			indexRebuilds := []IndexRebuild{
+				{Index: "public.foo_idx", Table: "public.foo", DropDuration: 12 * time.Millisecond, CreateDuration: 3200 * time.Millisecond, Recreated: true},
+				{Index: "public.bar_idx", Table: "public.bar", DropDuration: 5 * time.Millisecond, CreateDuration: time.Second, Error: "out of memory"},
+				{Index: "public.baz_idx", Table: "public.baz", DropDuration: 7 * time.Millisecond},
+			}
+			restoreReport := NewRestoreStructuredReport(timestamp, timestamp, restoreStartTime, connectionPool, restoreVersion, "")
+			restoreReport.Restore = &RestoreDetails{IndexRebuilds: indexRebuilds}
+			restoreReport.WriteReportFiles("filename", "filename.json")
+			Expect(buffer).To(Say(`restore status:      Success
+
+rebuilt indexes:
+public.foo_idx on public.foo: dropped in 12ms, re-created in 3.2s
+public.bar_idx on public.bar: dropped in 5ms, not re-created: out of memory
+public.baz_idx on public.baz: dropped in 7ms, not re-created`))
+		})
+		It("writes a report listing the tables restored without OIDS", func() {
+			gplog.SetErrorCode(0)
+			restoreReport := NewRestoreStructuredReport(timestamp, timestamp, restoreStartTime, connectionPool, restoreVersion, "")
+			restoreReport.Restore = &RestoreDetails{OidsRemovedTables: []string{"public.legacy"}}
+			restoreReport.WriteReportFiles("filename", "filename.json")
+			Expect(buffer).To(Say(`restore status:      Success
+
+tables restored without OIDS:
+public.legacy`))
+		})
+		It("writes a JSON report with the restore details", func() {
+			gplog.SetErrorCode(0)
+			restoreReport := NewRestoreStructuredReport(timestamp, "20161231010101", restoreStartTime, connectionPool, restoreVersion, "")
+			restoreReport.Restore = &RestoreDetails{ReconnectEvents: map[int]int{1: 2}, RetriedStatements: 3}
+			restoreReport.Tables = []TableStats{{Table: "public.foo", Rows: 10}}
+			restoreReport.WriteReportFiles("filename", "filename.json")
+
+			structured := StructuredReport{}
+			err := json.Unmarshal(jsonBuffer.Contents(), &structured)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(structured.Type).To(Equal(REPORT_TYPE_RESTORE))
+			Expect(structured.Status).To(Equal(history.BackupStatusSucceed))
+			Expect(structured.Parameters.Timestamp).To(Equal(timestamp))
+			Expect(structured.Parameters.RestorePoint).To(Equal("20161231010101"))
+			Expect(structured.Parameters.DatabaseVersion).To(Equal("5.0.0 build test"))
+			Expect(structured.Timings.DurationSeconds).To(Equal(float64(4*3600 + 3*60 + 1)))
+			Expect(structured.Tables).To(Equal([]TableStats{{Table: "public.foo", Rows: 10}}))
+			Expect(structured.Restore.ReconnectEvents).To(Equal(map[int]int{1: 2}))
+			Expect(structured.Restore.RetriedStatements).To(Equal(3))
+			Expect(structured.Backup).To(BeNil())
+			Expect(structured.ErrorClass).To(Equal(utils.ERROR_CLASS_NONE))
+			Expect(structured.ExitCode).To(Equal(utils.EXIT_SUCCESS))
+		})
+		It("writes the partial class to the JSON report of a restore with errors", func() {
+			gplog.SetErrorCode(1)
+			NewRestoreStructuredReport(timestamp, timestamp, restoreStartTime, connectionPool, restoreVersion, "").WriteReportFiles("filename", "filename.json")
+
+			structured := StructuredReport{}
+			err := json.Unmarshal(jsonBuffer.Contents(), &structured)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(structured.ErrorClass).To(Equal(utils.ERROR_CLASS_PARTIAL))
+			Expect(structured.ExitCode).To(Equal(utils.EXIT_PARTIAL))
+		})
+	})
+	Describe("PrintHostConcurrency", func() {
+		It("lists the peak data concurrency on each host", func() {
+			PrintHostConcurrency(buffer, 2, map[string]int{"sdw2": 1, "sdw1": 2})
+			Expect(buffer).To(Say(`per-host data concurrency \(limit 2\):
+sdw1: 2
+sdw2: 1`))
+		})
+		It("prints nothing when there is no limit", func() {
+			PrintHostConcurrency(buffer, 0, map[string]int{"sdw1": 8})
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+	})
+	Describe("NewTableLoadRates", func() {
+		It("returns the percentiles of the load rates of the timed tables", func() {
+			tables := make([]TableStats, 0)
+			for i := 1; i <= 20; i++ {
+				tables = append(tables, TableStats{Table: fmt.Sprintf("public.t%d", i), Rows: int64(i * 100), Bytes: int64(i) << 20, Seconds: 1})
+			}
+			tables = append(tables, TableStats{Table: "public.untimed", Rows: 1000000})
+			Expect(NewTableLoadRates(tables)).To(Equal(&TableLoadRates{RowsPerSecondP50: 1000, RowsPerSecondP95: 1900, MBPerSecondP50: 10, MBPerSecondP95: 19}))
+		})
+		It("returns nil when no table was timed", func() {
+			Expect(NewTableLoadRates([]TableStats{{Table: "public.foo", Rows: 10}})).To(BeNil())
+		})
+	})
+	Describe("PrintTableLoadRates", func() {
+		It("prints the median and 95th percentile load rates", func() {
+			PrintTableLoadRates(buffer, &TableLoadRates{RowsPerSecondP50: 1000, RowsPerSecondP95: 1900, MBPerSecondP50: 10, MBPerSecondP95: 19.5})
+			Expect(buffer).To(Say(`table load rates:
+p50: 1000 rows/sec, 10.00 MB/sec
+p95: 1900 rows/sec, 19.50 MB/sec`))
+		})
+		It("prints nothing when no table was timed", func() {
+			PrintTableLoadRates(buffer, nil)
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+	})
+	Describe("NewSlowestConstraints", func() {
+		timings := []ConstraintTiming{
+			{Constraint: "fast_fk", Table: "public.foo", Duration: time.Second},
+			{Constraint: "slow_fk", Table: "public.bar", Duration: time.Minute},
+			{Constraint: "foo_pkey", Table: "public.foo", Duration: 10 * time.Second},
+		}
+		It("returns the slowest constraints, slowest first", func() {
+			Expect(NewSlowestConstraints(timings, 2)).To(Equal([]ConstraintTiming{timings[1], timings[2]}))
+			Expect(timings[0].Constraint).To(Equal("fast_fk"))
+		})
+		It("returns every constraint when there are fewer than requested", func() {
+			Expect(NewSlowestConstraints(timings, 10)).To(Equal([]ConstraintTiming{timings[1], timings[2], timings[0]}))
+		})
+	})
+	Describe("PrintSlowestConstraints", func() {
+		It("prints the time taken to add each constraint", func() {
+			PrintSlowestConstraints(buffer, []ConstraintTiming{{Constraint: "slow_fk", Table: "public.bar", Duration: 61500 * time.Millisecond}})
+			Expect(buffer).To(Say(`slowest constraints:
+slow_fk on public.bar: 1m1.5s`))
+		})
+		It("prints nothing when no constraint was added", func() {
+			PrintSlowestConstraints(buffer, nil)
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+	})
+	Describe("PrintList", func() {
+		It("lists each item under the header", func() {
+			PrintList(buffer, "invalid indexes and constraints", []string{"index public.foo_idx on public.foo (not backed up)", "constraint bar_check on public.bar (backed up NOT VALID)"})
+			Expect(buffer).To(Say(`invalid indexes and constraints:
+index public.foo_idx on public.foo \(not backed up\)
+constraint bar_check on public.bar \(backed up NOT VALID\)`))
+		})
+		It("prints nothing when the list is empty", func() {
+			PrintList(buffer, "invalid indexes and constraints", []string{})
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+	})
+	Describe("PrintSkippedDataTables", func() {
+		It("counts the tables backed up without data by reason", func() {
+			PrintSkippedDataTables(buffer, map[string]int{"FOREIGN TABLE": 1, "EXTERNAL TABLE": 2})
+			Expect(buffer).To(Say(`tables backed up without data:
+external table: 2
+foreign table: 1`))
+		})
+		It("prints nothing when no table data was skipped", func() {
+			PrintSkippedDataTables(buffer, map[string]int{})
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+	})
+	Describe("PrintEncodingConversion", func() {
+		It("prints a conversion by the server", func() {
+			PrintEncodingConversion(buffer, &EncodingConversion{SourceEncoding: "LATIN1", TargetEncoding: "UTF8", Method: ENCODING_CONVERSION_SERVER, OnError: "fail"})
+			Expect(buffer).To(Say(`encoding conversion: LATIN1 to UTF8 by the server`))
+		})
+		It("lists the rows that gpbackup_helper could not convert", func() {
+			PrintEncodingConversion(buffer, &EncodingConversion{SourceEncoding: "UTF8", TargetEncoding: "LATIN1", Method: ENCODING_CONVERSION_HELPER, OnError: "skip",
+				FailedRows: []TableStats{{Table: "public.foo", Rows: 3}}})
+			Expect(buffer).To(Say(`encoding conversion: UTF8 to LATIN1 by gpbackup_helper \(on error: skip\)
+rows that failed conversion:
+public.foo: 3`))
+		})
+		It("prints nothing when the data is not converted", func() {
+			PrintEncodingConversion(buffer, nil)
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
 	})
 	Describe("SetBackupParamFromFlags", func() {
 		AfterEach(func() {
@@ -315,6 +660,7 @@ restore status:      Success but non-fatal errors occurred. See log file .+ for
 			structmatcher.ExpectStructsToMatch(history.BackupConfig{
 				BackupVersion:        "0.1.0",
 				Compressed:           true,
+				DataFormat:           "csv",
 				DatabaseName:         "testdb",
 				DatabaseVersion:      "5.0.0 build test",
 				IncludeSchemas:       []string{},
@@ -325,6 +671,7 @@ restore status:      Success but non-fatal errors occurred. See log file .+ for
 				Timestamp:            "timestamp1",
 				IncludeTableFiltered: true,
 				Status:               history.BackupStatusFailed,
+				FormatVersion:        history.CONFIG_FORMAT_VERSION,
 			}, backupConfig)
 		})
 	})
@@ -392,6 +739,19 @@ restore status:      Success but non-fatal errors occurred. See log file .+ for
 			EnsureBackupVersionCompatibility("0.1.0", "0.1.0")
 		})
 	})
+	Describe("EnsureFormatCompatibility", func() {
+		It("panics if the backup format is newer than the supported format", func() {
+			defer testhelper.ShouldPanicWithMessage("backup was created by gpbackup 2.1.0 using TOC format 3; this gprestore supports up to format 2")
+			EnsureFormatCompatibility("2.1.0", "TOC", 3, 2, []string{})
+		})
+		It("panics if the backup requires sections that are not supported", func() {
+			defer testhelper.ShouldPanicWithMessage("backup was created by gpbackup 2.1.0 using config format 2, which requires sections this gprestore does not support: encryption, checksums")
+			EnsureFormatCompatibility("2.1.0", "config", 2, 2, []string{"encryption", "checksums"})
+		})
+		It("does not panic if the backup format is supported, regardless of gpbackup version", func() {
+			EnsureFormatCompatibility("9.9.9", "config", 1, 2, []string{})
+		})
+	})
 	Describe("EnsureDatabaseVersionCompatibility", func() {
 		var restoreVersion dbconn.GPDBVersion
 		BeforeEach(func() {
@@ -412,6 +772,28 @@ restore status:      Success but non-fatal errors occurred. See log file .+ for
 			EnsureDatabaseVersionCompatibility("5.0.6-beta.9+dev.129.g4bd4e41 build dev", restoreVersion)
 		})
 	})
+	Describe("EnsureBinaryDataCompatibility", func() {
+		var restoreVersion dbconn.GPDBVersion
+		BeforeEach(func() {
+			semver, _ := semver.Make("5.0.0")
+			restoreVersion = dbconn.GPDBVersion{
+				VersionString: "5.0.0 build dev",
+				SemVer:        semver,
+			}
+		})
+		It("panics if the backup database major version differs from the restore major version", func() {
+			defer testhelper.ShouldPanicWithMessage("Cannot restore binary format data from GPDB version 4.3.16 build dev to 5.0.0 build dev.")
+			EnsureBinaryDataCompatibility("4.3.16 build dev", restoreVersion)
+		})
+		It("warns if the backup database minor version differs from the restore minor version", func() {
+			EnsureBinaryDataCompatibility("5.1.0 build dev", restoreVersion)
+			Expect(string(logfile.Contents())).To(ContainSubstring("This backup contains binary format data from GPDB version 5.1.0 build dev, which is being restored to 5.0.0 build dev."))
+		})
+		It("does not warn if the backup database version is the same as the restore version", func() {
+			EnsureBinaryDataCompatibility("5.0.0 build dev", restoreVersion)
+			Expect(string(logfile.Contents())).ToNot(ContainSubstring("binary format data"))
+		})
+	})
 
 	Describe("Email-related functions", func() {
 		reportFileContents := []byte(`Greenplum Database Backup Report
@@ -615,4 +997,122 @@ Content-Disposition: inline
 			})
 		})
 	})
+	Describe("GetWarnings", func() {
+		var counter *LogCounter
+		BeforeEach(func() {
+			ClearWarnings()
+			operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+				return NewBuffer(), nil
+			}
+			counter = EnableSummaryOnlyLogging("gpbackup")
+		})
+		AfterEach(func() {
+			ClearWarnings()
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		It("returns warnings in the order they were logged, with their codes", func() {
+			Warn(WARN_OBJECT_DROPPED, "public.foo", "View '%s' not backed up", "public.foo")
+			gplog.Warn("An uncategorized warning")
+			Warn(WARN_NO_TABLE_LOCKS, "", "Tables will not be locked")
+			Expect(GetWarnings(counter)).To(Equal([]Warning{
+				{Code: "W005", Name: "OBJECT_DROPPED_DURING_BACKUP", Object: "public.foo", Message: "View 'public.foo' not backed up"},
+				{Code: "W000", Name: "UNCATEGORIZED", Message: "An uncategorized warning"},
+				{Code: "W009", Name: "NO_TABLE_LOCKS", Message: "Tables will not be locked"},
+			}))
+		})
+		It("matches repeated warnings to their codes one at a time", func() {
+			Warn(WARN_SCHEMA_ALREADY_EXISTS, "public", "Schema public already exists")
+			gplog.Warn("Schema public already exists")
+			warnings := GetWarnings(counter)
+			Expect(warnings).To(HaveLen(2))
+			Expect(warnings[0].Code).To(Equal("W016"))
+			Expect(warnings[1].Code).To(Equal("W000"))
+		})
+		It("returns only coded warnings when warnings are not counted", func() {
+			Warn(WARN_CONNECTION_LOST, "", "Connection 1 to the database was lost")
+			gplog.Warn("An uncategorized warning")
+			Expect(GetWarnings(nil)).To(Equal([]Warning{{Code: "W012", Name: "CONNECTION_LOST", Message: "Connection 1 to the database was lost"}}))
+		})
+	})
+	Describe("Summary", func() {
+		summary := Summary{
+			Timestamp: "20170101010101",
+			Database:  "testdb",
+			Type:      "full",
+			Tables:    42,
+			Bytes:     1024,
+			Duration:  "0:01:02",
+			Warnings:  1,
+			Status:    history.BackupStatusSucceed,
+		}
+		It("prints a single line when there are no errors", func() {
+			Expect(summary.String()).To(Equal("timestamp=20170101010101 database=testdb type=full tables=42 bytes=1024 duration=0:01:02 warnings=1 errors=0 status=Success"))
+		})
+		It("prints a dash for an unknown size", func() {
+			unknownSize := summary
+			unknownSize.Bytes = -1
+			Expect(unknownSize.String()).To(ContainSubstring(" bytes=- "))
+		})
+		It("lists the first errors on separate lines", func() {
+			failed := summary
+			failed.Status = history.BackupStatusFailed
+			failed.Errors = []string{"error 1", "error 2", "error 3", "error 4", "error 5", "error 6", "error 7"}
+			Expect(failed.String()).To(Equal(`timestamp=20170101010101 database=testdb type=full tables=42 bytes=1024 duration=0:01:02 warnings=1 errors=7 status=Failure
+  error: error 1
+  error: error 2
+  error: error 3
+  error: error 4
+  error: error 5
+  ... and 2 more error(s)`))
+		})
+	})
+	Describe("EnableSummaryOnlyLogging", func() {
+		var logFileContents *Buffer
+		BeforeEach(func() {
+			logFileContents = NewBuffer()
+			operating.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+				return logFileContents, nil
+			}
+		})
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+			gplog.SetErrorCode(0)
+		})
+		It("writes only to the log file and counts warnings and errors", func() {
+			counter := EnableSummaryOnlyLogging("gpbackup")
+			gplog.Info("an info message")
+			gplog.Warn("a warning message")
+			gplog.Error("an error message\nwith a second line")
+			Expect(stdout.Contents()).To(BeEmpty())
+			Expect(logFileContents).To(Say("an info message"))
+			Expect(logFileContents).To(Say("a warning message"))
+			Expect(logFileContents).To(Say("an error message"))
+			Expect(counter.Warnings).To(Equal(1))
+			Expect(counter.Errors).To(Equal([]string{"an error message"}))
+		})
+	})
+	Describe("GetBackupDirectorySize", func() {
+		var testExecutor *testhelper.TestExecutor
+		var testCluster *cluster.Cluster
+		var testFPInfo filepath.FilePathInfo
+		BeforeEach(func() {
+			testCluster = testutils.SetDefaultSegmentConfiguration()
+			testFPInfo = filepath.NewFilePathInfo(testCluster, "", "20170101010101", "gpseg")
+			testExecutor = &testhelper.TestExecutor{}
+			testCluster.Executor = testExecutor
+		})
+		It("adds up the size of the backup directories on all segments", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				Commands: []cluster.ShellCommand{{Stdout: "100\n"}, {Stdout: "2000\n"}, {Stdout: ""}},
+			}
+			Expect(GetBackupDirectorySize(testCluster, testFPInfo)).To(Equal(int64(2100)))
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring("du -sb gpseg-1/backups/20170101/20170101010101"))
+		})
+		It("returns -1 if the size cannot be determined", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				NumErrors: 1,
+			}
+			Expect(GetBackupDirectorySize(testCluster, testFPInfo)).To(Equal(int64(-1)))
+		})
+	})
 })
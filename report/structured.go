@@ -0,0 +1,457 @@
+package report
+
+/*
+ * This file contains the structured form of the backup and restore reports,
+ * which is written as JSON alongside the text report.  The text report is
+ * rendered from the same structure so that the two never disagree.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+const (
+	REPORT_TYPE_BACKUP  = "backup"
+	REPORT_TYPE_RESTORE = "restore"
+)
+
+type StructuredReport struct {
+	Type                 string           `json:"type"`
+	Parameters           ReportParameters `json:"parameters"`
+	Timings              ReportTimings    `json:"timings"`
+	Status               string           `json:"status"`
+	NonFatalErrors       bool             `json:"nonFatalErrors,omitempty"`
+	Error                string           `json:"error,omitempty"`
+	ErrorClass           utils.ErrorClass `json:"errorClass,omitempty"`
+	ExitCode             int              `json:"exitCode"`
+	ObjectCounts         map[string]int   `json:"objectCounts,omitempty"`
+	Tables               []TableStats     `json:"tables"`
+	MaxConcurrentPerHost int              `json:"maxConcurrentPerHost,omitempty"`
+	HostConcurrency      map[string]int   `json:"hostConcurrency,omitempty"`
+	Backup               *BackupDetails   `json:"backup,omitempty"`
+	Restore              *RestoreDetails  `json:"restore,omitempty"`
+	Warnings             []Warning        `json:"warnings"`
+	Errors               []string         `json:"errors"`
+}
+
+/*
+ * Options are the backup parameters, such as compression and filtering, in
+ * the order they are printed.  RestorePoint is only set for a restore of an
+ * incremental chain as of an earlier backup.
+ */
+type ReportParameters struct {
+	Timestamp            string         `json:"timestamp"`
+	RestorePoint         string         `json:"restorePoint,omitempty"`
+	DatabaseVersion      string         `json:"databaseVersion"`
+	Version              string         `json:"version"`
+	DatabaseName         string         `json:"databaseName"`
+	CommandLine          string         `json:"commandLine"`
+	LogFile              string         `json:"logFile"`
+	Options              []ReportOption `json:"options,omitempty"`
+	IncrementalBackupSet []string       `json:"incrementalBackupSet,omitempty"`
+}
+
+type ReportOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type ReportTimings struct {
+	Start           time.Time          `json:"start"`
+	End             time.Time          `json:"end"`
+	DurationSeconds float64            `json:"durationSeconds"`
+	PhaseSeconds    map[string]float64 `json:"phaseSeconds,omitempty"`
+}
+
+/*
+ * Bytes and Seconds are only set for tables whose data was restored: the
+ * bytes read from the table's data files on all segments, and the time taken
+ * to load them.
+ */
+type TableStats struct {
+	Table   string  `json:"table"`
+	Rows    int64   `json:"rows"`
+	Bytes   int64   `json:"bytes,omitempty"`
+	Seconds float64 `json:"seconds,omitempty"`
+}
+
+type BackupDetails struct {
+	RetryCount               int            `json:"retryCount"`
+	DatabaseSize             string         `json:"databaseSize,omitempty"`
+	MixedOwnershipPartitions []string       `json:"mixedOwnershipPartitions,omitempty"`
+	OrphanedPartitions       []string       `json:"orphanedPartitions,omitempty"`
+	TablesWithOids           []string       `json:"tablesWithOids,omitempty"`
+	InaccessibleTables       []string       `json:"inaccessibleTables,omitempty"`
+	ManualAttentionTables    []string       `json:"manualAttentionTables,omitempty"`
+	PartialDataTables        []string       `json:"partialDataTables,omitempty"`
+	InvalidObjects           []string       `json:"invalidObjects,omitempty"`
+	SkippedDataTables        map[string]int `json:"skippedDataTablesByReason,omitempty"`
+}
+
+type RestoreDetails struct {
+	ReconnectEvents    map[int]int         `json:"reconnectEvents,omitempty"`
+	RetriedStatements  int                 `json:"retriedStatements"`
+	IndexRebuilds      []IndexRebuild      `json:"indexRebuilds,omitempty"`
+	EncodingConversion *EncodingConversion `json:"encodingConversion,omitempty"`
+	OidsRemovedTables  []string            `json:"oidsRemovedTables,omitempty"`
+	TableLoadRates     *TableLoadRates     `json:"tableLoadRates,omitempty"`
+	SlowestConstraints []ConstraintTiming  `json:"slowestConstraints,omitempty"`
+}
+
+// Percentiles of the rates at which individual tables were loaded
+type TableLoadRates struct {
+	RowsPerSecondP50 float64 `json:"rowsPerSecondP50"`
+	RowsPerSecondP95 float64 `json:"rowsPerSecondP95"`
+	MBPerSecondP50   float64 `json:"mbPerSecondP50"`
+	MBPerSecondP95   float64 `json:"mbPerSecondP95"`
+}
+
+/*
+ * Returns the percentiles of the load rates of the tables that were timed,
+ * or nil if none were.
+ */
+func NewTableLoadRates(tables []TableStats) *TableLoadRates {
+	rowRates := make([]float64, 0, len(tables))
+	byteRates := make([]float64, 0, len(tables))
+	for _, table := range tables {
+		if table.Seconds <= 0 {
+			continue
+		}
+		rowRates = append(rowRates, float64(table.Rows)/table.Seconds)
+		byteRates = append(byteRates, float64(table.Bytes)/(1<<20)/table.Seconds)
+	}
+	if len(rowRates) == 0 {
+		return nil
+	}
+	sort.Float64s(rowRates)
+	sort.Float64s(byteRates)
+	return &TableLoadRates{
+		RowsPerSecondP50: percentile(rowRates, 50),
+		RowsPerSecondP95: percentile(rowRates, 95),
+		MBPerSecondP50:   percentile(byteRates, 50),
+		MBPerSecondP95:   percentile(byteRates, 95),
+	}
+}
+
+// Returns the n constraints that took longest to add, slowest first
+func NewSlowestConstraints(timings []ConstraintTiming, n int) []ConstraintTiming {
+	slowest := make([]ConstraintTiming, len(timings))
+	copy(slowest, timings)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		return slowest[i].Duration > slowest[j].Duration
+	})
+	if len(slowest) > n {
+		slowest = slowest[:n]
+	}
+	return slowest
+}
+
+// Returns the nearest-rank percentile of sorted values, rounded to two decimal places
+func percentile(sorted []float64, p int) float64 {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return math.Round(sorted[rank-1]*100) / 100
+}
+
+const (
+	ENCODING_CONVERSION_SERVER = "server"
+	ENCODING_CONVERSION_HELPER = "helper"
+)
+
+/*
+ * Table data is converted from the encoding it was backed up in either by the
+ * server, through client_encoding, or by gpbackup_helper before the server
+ * reads it.  FailedRows is only set for conversion by gpbackup_helper, which
+ * can skip rows or replace characters instead of failing.
+ */
+type EncodingConversion struct {
+	SourceEncoding string       `json:"sourceEncoding"`
+	TargetEncoding string       `json:"targetEncoding"`
+	Method         string       `json:"method"`
+	OnError        string       `json:"onError"`
+	FailedRows     []TableStats `json:"failedRows,omitempty"`
+}
+
+func (conversion *EncodingConversion) SkipsRows() bool {
+	return conversion != nil && conversion.Method == ENCODING_CONVERSION_HELPER && conversion.OnError == utils.CONVERSION_ERROR_SKIP
+}
+
+func newReportTimings(startTimestamp string, endTime time.Time, phaseSeconds map[string]float64) ReportTimings {
+	startTime, _ := time.ParseInLocation("20060102150405", startTimestamp, operating.System.Local)
+	return ReportTimings{
+		Start:           startTime,
+		End:             endTime,
+		DurationSeconds: math.Round(endTime.Sub(startTime).Seconds()*1000) / 1000,
+		PhaseSeconds:    phaseSeconds,
+	}
+}
+
+/*
+ * The backup parameters are kept as a string in the backup config, so we
+ * split them back into options.  The timestamps of an incremental backup set
+ * follow the "incremental backup set" line without a key.
+ */
+func parseBackupParams(paramsStr string) ([]ReportOption, []string) {
+	paramLines := make([]LineInfo, 0)
+	AppendBackupParams(&paramLines, paramsStr)
+	options := make([]ReportOption, 0)
+	backupSet := make([]string, 0)
+	for _, line := range paramLines {
+		if line.Key == "incremental backup set:" {
+			continue
+		}
+		if !strings.HasSuffix(line.Key, ":") {
+			backupSet = append(backupSet, line.Key)
+			continue
+		}
+		options = append(options, ReportOption{Name: strings.TrimSuffix(line.Key, ":"), Value: line.Value})
+	}
+	return options, backupSet
+}
+
+func (report *Report) NewStructuredReport(timestamp string, endtime time.Time, objectCounts map[string]int, errMsg string) *StructuredReport {
+	structured := &StructuredReport{
+		Type: REPORT_TYPE_BACKUP,
+		Parameters: ReportParameters{
+			Timestamp:       timestamp,
+			DatabaseVersion: report.DatabaseVersion,
+			Version:         report.BackupVersion,
+			DatabaseName:    report.DatabaseName,
+			CommandLine:     strings.Join(os.Args, " "),
+			LogFile:         gplog.GetLogFilePath(),
+		},
+		Status:               history.BackupStatusSucceed,
+		Error:                errMsg,
+		ObjectCounts:         objectCounts,
+		Tables:               report.Tables,
+		MaxConcurrentPerHost: report.MaxConcurrentPerHost,
+		HostConcurrency:      report.HostConcurrency,
+		Backup: &BackupDetails{
+			RetryCount:               report.RetryCount,
+			DatabaseSize:             report.DatabaseSize,
+			MixedOwnershipPartitions: report.MixedOwnershipPartitions,
+			OrphanedPartitions:       report.OrphanedPartitions,
+			TablesWithOids:           report.TablesWithOids,
+			InaccessibleTables:       report.InaccessibleTables,
+			ManualAttentionTables:    report.ManualAttentionTables,
+			PartialDataTables:        report.PartialDataTables,
+			InvalidObjects:           report.InvalidObjects,
+			SkippedDataTables:        report.SkippedDataTables,
+		},
+		Warnings: report.Warnings,
+		Errors:   report.Errors,
+	}
+	structured.Parameters.Options, structured.Parameters.IncrementalBackupSet = parseBackupParams(report.BackupParamsString)
+	var phaseSeconds map[string]float64
+	if report.Stats != nil {
+		phaseSeconds = report.Stats.PhaseDurations
+	}
+	structured.Timings = newReportTimings(timestamp, endtime, phaseSeconds)
+	if errMsg != "" {
+		structured.Status = history.BackupStatusFailed
+	}
+	structured.setErrorClass()
+	return structured
+}
+
+/*
+ * restorePoint is the backup of an incremental chain that was restored, which
+ * is only printed if it differs from backupTimestamp.
+ */
+func NewRestoreStructuredReport(backupTimestamp string, restorePoint string, startTimestamp string, connectionPool *dbconn.DBConn, restoreVersion string, errMsg string) *StructuredReport {
+	structured := &StructuredReport{
+		Type: REPORT_TYPE_RESTORE,
+		Parameters: ReportParameters{
+			Timestamp:       backupTimestamp,
+			DatabaseVersion: connectionPool.Version.VersionString,
+			Version:         restoreVersion,
+			DatabaseName:    connectionPool.DBName,
+			CommandLine:     strings.Join(os.Args, " "),
+			LogFile:         gplog.GetLogFilePath(),
+		},
+		Timings: newReportTimings(startTimestamp, operating.System.Now(), nil),
+		Status:  history.BackupStatusSucceed,
+		Restore: &RestoreDetails{},
+	}
+	if restorePoint != backupTimestamp {
+		structured.Parameters.RestorePoint = restorePoint
+	}
+	// gplog sets the error code to 1 for errors that did not stop the restore
+	if gplog.GetErrorCode() == 1 {
+		structured.NonFatalErrors = true
+	} else if errMsg != "" {
+		structured.Status = history.BackupStatusFailed
+		structured.Error = errMsg
+	}
+	structured.setErrorClass()
+	return structured
+}
+
+// The exit code is given in the report as well, as the report is written before the utility exits
+func (structured *StructuredReport) setErrorClass() {
+	structured.ErrorClass = utils.ErrorClassForOutcome(structured.Status == history.BackupStatusFailed, false)
+	structured.ExitCode = structured.ErrorClass.ExitCode()
+}
+
+// Lists are written as empty rather than null so that tooling need not check for both
+func (structured *StructuredReport) fillDefaults() {
+	if structured.Tables == nil {
+		structured.Tables = make([]TableStats, 0)
+	}
+	if structured.Warnings == nil {
+		structured.Warnings = make([]Warning, 0)
+	}
+	if structured.Errors == nil {
+		structured.Errors = make([]string, 0)
+		if structured.Error != "" {
+			structured.Errors = append(structured.Errors, structured.Error)
+		}
+	}
+}
+
+func (structured *StructuredReport) headerLines() []LineInfo {
+	params := structured.Parameters
+	lines := []LineInfo{{Key: "timestamp key:", Value: params.Timestamp}}
+	if params.RestorePoint != "" {
+		lines = append(lines, LineInfo{Key: "restored as of:", Value: params.RestorePoint})
+	}
+	lines = append(lines,
+		LineInfo{Key: "gpdb version:", Value: params.DatabaseVersion},
+		LineInfo{Key: fmt.Sprintf("gp%s version:", structured.Type), Value: fmt.Sprintf("%s\n", params.Version)},
+		LineInfo{Key: "database name:", Value: params.DatabaseName})
+	if structured.Type == REPORT_TYPE_RESTORE {
+		lines = append(lines, LineInfo{Key: "command line:", Value: fmt.Sprintf("%s\n", params.CommandLine)})
+	} else {
+		lines = append(lines, LineInfo{Key: "command line:", Value: params.CommandLine})
+	}
+	for _, option := range params.Options {
+		lines = append(lines, LineInfo{Key: option.Name + ":", Value: option.Value})
+	}
+	if len(params.IncrementalBackupSet) > 0 {
+		lines = append(lines, LineInfo{Key: "incremental backup set:"})
+		for _, timestamp := range params.IncrementalBackupSet {
+			lines = append(lines, LineInfo{Key: timestamp})
+		}
+	}
+	if structured.Type == REPORT_TYPE_BACKUP {
+		lines = append(lines, LineInfo{})
+	}
+
+	timings := structured.Timings
+	lines = append(lines,
+		LineInfo{Key: "start time:", Value: timings.Start.Format("Mon Jan 02 2006 15:04:05")},
+		LineInfo{Key: "end time:", Value: timings.End.Format("Mon Jan 02 2006 15:04:05")},
+		LineInfo{Key: "duration:", Value: reformatDuration(timings.End.Sub(timings.Start))},
+		LineInfo{})
+
+	statusKey := fmt.Sprintf("%s status:", structured.Type)
+	if structured.NonFatalErrors {
+		lines = append(lines, LineInfo{Key: statusKey, Value: fmt.Sprintf("Success but non-fatal errors occurred. See log file %s for details.", params.LogFile)})
+	} else {
+		lines = append(lines, LineInfo{Key: statusKey, Value: structured.Status})
+		if structured.Error != "" {
+			lines = append(lines, LineInfo{Key: fmt.Sprintf("%s error:", structured.Type), Value: structured.Error})
+		}
+	}
+	if structured.Backup != nil {
+		if structured.Backup.RetryCount > 0 {
+			lines = append(lines, LineInfo{Key: "retry attempts:", Value: strconv.Itoa(structured.Backup.RetryCount)})
+		}
+		if structured.Backup.DatabaseSize != "" {
+			lines = append(lines, LineInfo{}, LineInfo{Key: "database size:", Value: strings.ToUpper(structured.Backup.DatabaseSize)})
+		}
+	}
+	return lines
+}
+
+func (structured *StructuredReport) WriteText(reportFile io.WriteCloser) {
+	if structured.Type == REPORT_TYPE_BACKUP {
+		utils.MustPrintf(reportFile, "Greenplum Database Backup Report\n\n")
+	} else {
+		utils.MustPrintf(reportFile, "Greenplum Database Restore Report\n\n")
+	}
+	logOutputReport(reportFile, structured.headerLines())
+
+	if backup := structured.Backup; backup != nil {
+		PrintObjectCounts(reportFile, structured.ObjectCounts)
+		PrintList(reportFile, "partition tables with mixed ownership", backup.MixedOwnershipPartitions)
+		PrintList(reportFile, "orphaned child partitions", backup.OrphanedPartitions)
+		PrintList(reportFile, "tables with OIDS", backup.TablesWithOids)
+		PrintList(reportFile, "tables without SELECT privilege", backup.InaccessibleTables)
+		PrintList(reportFile, "tables requiring manual attention", backup.ManualAttentionTables)
+		PrintList(reportFile, "tables with partially backed up data", backup.PartialDataTables)
+		PrintList(reportFile, "invalid indexes and constraints", backup.InvalidObjects)
+		PrintSkippedDataTables(reportFile, backup.SkippedDataTables)
+	}
+	if restore := structured.Restore; restore != nil {
+		PrintReconnectEvents(reportFile, restore.ReconnectEvents)
+		if restore.RetriedStatements > 0 {
+			utils.MustPrintf(reportFile, "\nretried statements: %d\n", restore.RetriedStatements)
+		}
+		PrintIndexRebuilds(reportFile, restore.IndexRebuilds)
+		PrintEncodingConversion(reportFile, restore.EncodingConversion)
+		PrintList(reportFile, "tables restored without OIDS", restore.OidsRemovedTables)
+		PrintTableLoadRates(reportFile, restore.TableLoadRates)
+		PrintSlowestConstraints(reportFile, restore.SlowestConstraints)
+	}
+	PrintHostConcurrency(reportFile, structured.MaxConcurrentPerHost, structured.HostConcurrency)
+	PrintWarnings(reportFile, structured.Warnings)
+}
+
+func PrintWarnings(reportFile io.WriteCloser, warnings []Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+	warningStr := "\nwarnings:\n"
+	for _, warning := range warnings {
+		warningStr += fmt.Sprintf("%s %s: %s\n", warning.Code, warning.Name, warning.Message)
+	}
+	utils.MustPrintf(reportFile, warningStr)
+}
+
+/*
+ * Writes the text report to reportFilename and the JSON report to
+ * jsonFilename.  Both files are made read-only, as the text report always
+ * has been.
+ */
+func (structured *StructuredReport) WriteReportFiles(reportFilename string, jsonFilename string) {
+	structured.fillDefaults()
+	reportFile, err := iohelper.OpenFileForWriting(reportFilename)
+	if err != nil {
+		gplog.Error("Unable to open %s report file %s", structured.Type, reportFilename)
+		return
+	}
+	structured.WriteText(reportFile)
+	err = reportFile.Close()
+	gplog.FatalOnError(err)
+	_ = operating.System.Chmod(reportFilename, 0444)
+
+	contents, err := json.MarshalIndent(structured, "", "  ")
+	gplog.FatalOnError(err)
+	jsonFile, err := iohelper.OpenFileForWriting(jsonFilename)
+	if err != nil {
+		gplog.Error("Unable to open %s report file %s", structured.Type, jsonFilename)
+		return
+	}
+	utils.MustPrintf(jsonFile, "%s\n", contents)
+	err = jsonFile.Close()
+	gplog.FatalOnError(err)
+	_ = operating.System.Chmod(jsonFilename, 0444)
+}
@@ -0,0 +1,148 @@
+package report
+
+/*
+ * This file contains structs and functions related to the single-line
+ * summary printed with --summary-only.
+ */
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/filepath"
+)
+
+// The number of error messages listed below the summary line
+const MAX_SUMMARY_ERRORS = 5
+
+type Summary struct {
+	Timestamp string
+	Database  string
+	Type      string
+	Tables    int
+	Bytes     int64 // -1 if unknown, e.g. for restores and for backups written through a plugin
+	Duration  string
+	Warnings  int
+	Errors    []string
+	Status    string
+}
+
+func (summary Summary) String() string {
+	bytesStr := "-"
+	if summary.Bytes >= 0 {
+		bytesStr = strconv.FormatInt(summary.Bytes, 10)
+	}
+	line := fmt.Sprintf("timestamp=%s database=%s type=%s tables=%d bytes=%s duration=%s warnings=%d errors=%d status=%s",
+		summary.Timestamp, summary.Database, summary.Type, summary.Tables, bytesStr, summary.Duration,
+		summary.Warnings, len(summary.Errors), summary.Status)
+	for i, errMsg := range summary.Errors {
+		if i == MAX_SUMMARY_ERRORS {
+			line += fmt.Sprintf("\n  ... and %d more error(s)", len(summary.Errors)-MAX_SUMMARY_ERRORS)
+			break
+		}
+		line += fmt.Sprintf("\n  error: %s", errMsg)
+	}
+	return line
+}
+
+/*
+ * LogCounter sits between gplog and the log file, counting warnings and
+ * keeping error messages for the summary while passing everything through.
+ */
+type LogCounter struct {
+	writer          io.Writer
+	mutex           sync.Mutex
+	warningMessages []string
+	Warnings        int
+	Errors          []string
+}
+
+func (counter *LogCounter) Write(p []byte) (int, error) {
+	message := strings.TrimSpace(string(p))
+	counter.mutex.Lock()
+	if index := strings.Index(message, "-[WARNING]:-"); index != -1 {
+		counter.Warnings++
+		counter.warningMessages = append(counter.warningMessages, message[index+len("-[WARNING]:-"):])
+	} else if level, errMsg := parseErrorLevel(message); level != "" {
+		counter.Errors = append(counter.Errors, strings.SplitN(errMsg, "\n", 2)[0])
+	}
+	counter.mutex.Unlock()
+	return counter.writer.Write(p)
+}
+
+func (counter *LogCounter) GetWarningMessages() []string {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	return append([]string{}, counter.warningMessages...)
+}
+
+func parseErrorLevel(message string) (string, string) {
+	for _, level := range []string{"ERROR", "CRITICAL"} {
+		levelStr := fmt.Sprintf("-[%s]:-", level)
+		if index := strings.Index(message, levelStr); index != -1 {
+			return level, message[index+len(levelStr):]
+		}
+	}
+	return "", ""
+}
+
+/*
+ * Replaces the logger with one that writes only to the log file, so that the
+ * summary line is the only terminal output.
+ */
+func EnableSummaryOnlyLogging(program string) *LogCounter {
+	logFileName := gplog.GetLogFilePath()
+	logFile, err := operating.System.OpenFileWrite(logFileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	gplog.FatalOnError(err)
+	counter := &LogCounter{writer: logFile}
+	gplog.SetLogger(gplog.NewLogger(ioutil.Discard, ioutil.Discard, counter, logFileName, gplog.LOGERROR, program, gplog.GetLogFileVerbosity()))
+	return counter
+}
+
+/*
+ * Counts warnings and errors written to the log file without changing what is
+ * printed, so that they can be recorded in the backup history.
+ */
+func EnableLogCounting(program string) *LogCounter {
+	logFileName := gplog.GetLogFilePath()
+	logFile, err := operating.System.OpenFileWrite(logFileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	gplog.FatalOnError(err)
+	counter := &LogCounter{writer: logFile}
+	gplog.SetLogger(gplog.NewLogger(os.Stdout, os.Stderr, counter, logFileName, gplog.GetVerbosity(), program, gplog.GetLogFileVerbosity()))
+	return counter
+}
+
+/*
+ * Returns the total size of the backup directories on all hosts, or -1 if it
+ * cannot be determined.
+ */
+func GetBackupDirectorySize(c *cluster.Cluster, fpInfo filepath.FilePathInfo) int64 {
+	remoteOutput := c.GenerateAndExecuteCommand("Computing backup size", cluster.ON_SEGMENTS|cluster.INCLUDE_MASTER,
+		func(contentID int) string {
+			return fmt.Sprintf("du -sb %s 2>/dev/null | cut -f1", fpInfo.GetDirForContent(contentID))
+		})
+	if remoteOutput.NumErrors > 0 {
+		return -1
+	}
+	var total int64
+	for _, cmd := range remoteOutput.Commands {
+		output := strings.TrimSpace(cmd.Stdout)
+		if output == "" {
+			continue
+		}
+		size, err := strconv.ParseInt(output, 10, 64)
+		if err != nil {
+			return -1
+		}
+		total += size
+	}
+	return total
+}
@@ -0,0 +1,116 @@
+package report
+
+/*
+ * This file contains the codes given to warnings in the structured report, so
+ * that tooling can act on a category of warning without matching its message.
+ * A code is never renumbered or reused for another category.
+ */
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+)
+
+type WarningCode struct {
+	Code string
+	Name string
+}
+
+var (
+	WARN_UNCATEGORIZED            = WarningCode{Code: "W000", Name: "UNCATEGORIZED"}
+	WARN_INACCESSIBLE_TABLE       = WarningCode{Code: "W001", Name: "INACCESSIBLE_TABLE"}
+	WARN_PARTIAL_TABLE_DATA       = WarningCode{Code: "W002", Name: "PARTIAL_TABLE_DATA"}
+	WARN_MIXED_OWNERSHIP          = WarningCode{Code: "W003", Name: "MIXED_OWNERSHIP_PARTITION"}
+	WARN_DANGLING_FOREIGN_KEY     = WarningCode{Code: "W004", Name: "DANGLING_FOREIGN_KEY"}
+	WARN_OBJECT_DROPPED           = WarningCode{Code: "W005", Name: "OBJECT_DROPPED_DURING_BACKUP"}
+	WARN_STATEMENT_TOO_LARGE      = WarningCode{Code: "W006", Name: "STATEMENT_TOO_LARGE"}
+	WARN_CSV_DATA_FORMAT          = WarningCode{Code: "W007", Name: "CSV_DATA_FORMAT_FALLBACK"}
+	WARN_INEXACT_PARTITION_BOUND  = WarningCode{Code: "W008", Name: "INEXACT_PARTITION_BOUND"}
+	WARN_NO_TABLE_LOCKS           = WarningCode{Code: "W009", Name: "NO_TABLE_LOCKS"}
+	WARN_TABLE_LOCK_DEFERRED      = WarningCode{Code: "W010", Name: "TABLE_LOCK_DEFERRED"}
+	WARN_MISSING_FILTER_OBJECT    = WarningCode{Code: "W011", Name: "MISSING_FILTER_OBJECT"}
+	WARN_CONNECTION_LOST          = WarningCode{Code: "W012", Name: "CONNECTION_LOST"}
+	WARN_STATEMENT_RETRIED        = WarningCode{Code: "W013", Name: "STATEMENT_RETRIED"}
+	WARN_GRANTOR_FALLBACK         = WarningCode{Code: "W014", Name: "GRANTOR_FALLBACK"}
+	WARN_INDEX_NOT_DROPPED        = WarningCode{Code: "W015", Name: "INDEX_NOT_DROPPED"}
+	WARN_SCHEMA_ALREADY_EXISTS    = WarningCode{Code: "W016", Name: "SCHEMA_ALREADY_EXISTS"}
+	WARN_LEGACY_HASH_DISTRIBUTION = WarningCode{Code: "W017", Name: "LEGACY_HASH_DISTRIBUTION"}
+	WARN_ENCODING_CONVERSION      = WarningCode{Code: "W018", Name: "ENCODING_CONVERSION_FAILED"}
+	WARN_SYSTEM_RELATION_FILTER   = WarningCode{Code: "W019", Name: "SYSTEM_RELATION_FILTER"}
+	WARN_FOREIGN_KEY_CYCLE        = WarningCode{Code: "W020", Name: "FOREIGN_KEY_CYCLE"}
+	WARN_EMPTY_INCLUDE_QUERY      = WarningCode{Code: "W021", Name: "EMPTY_INCLUDE_QUERY"}
+	WARN_HEURISTIC_INCREMENTAL    = WarningCode{Code: "W022", Name: "HEURISTIC_INCREMENTAL_RESTORE"}
+	WARN_ORPHANED_PARTITION       = WarningCode{Code: "W023", Name: "ORPHANED_PARTITION"}
+	WARN_TABLE_WITH_OIDS          = WarningCode{Code: "W024", Name: "TABLE_WITH_OIDS"}
+	WARN_OIDS_REMOVED             = WarningCode{Code: "W025", Name: "OIDS_REMOVED"}
+	WARN_CORRUPT_PARTITION        = WarningCode{Code: "W026", Name: "CORRUPT_PARTITION_TABLE"}
+	WARN_SYSTEM_SCHEMA_INCLUDED   = WarningCode{Code: "W027", Name: "SYSTEM_SCHEMA_INCLUDED"}
+	WARN_EXCLUDED_DEPENDENT       = WarningCode{Code: "W028", Name: "EXCLUDED_SCHEMA_DEPENDENT"}
+	WARN_INVALID_INDEX            = WarningCode{Code: "W029", Name: "INVALID_INDEX"}
+	WARN_SESSION_GUC              = WarningCode{Code: "W030", Name: "SESSION_GUC_NOT_SET"}
+)
+
+/*
+ * Object is the object the warning is about, if there is a single one, so
+ * that warnings can be grouped by object as well as by category.
+ */
+type Warning struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+}
+
+var (
+	recordedWarnings []Warning
+	warningMutex     sync.Mutex
+)
+
+/*
+ * Logs a warning as gplog.Warn does and records it with its code for the
+ * structured report.
+ */
+func Warn(code WarningCode, object string, s string, v ...interface{}) {
+	message := fmt.Sprintf(s, v...)
+	warningMutex.Lock()
+	recordedWarnings = append(recordedWarnings, Warning{Code: code.Code, Name: code.Name, Object: object, Message: message})
+	warningMutex.Unlock()
+	gplog.Warn("%s", message)
+}
+
+func ClearWarnings() {
+	warningMutex.Lock()
+	recordedWarnings = nil
+	warningMutex.Unlock()
+}
+
+/*
+ * Returns every warning logged so far in the order it was logged.  Warnings
+ * logged directly through gplog are only seen by the LogCounter, and are
+ * given WARN_UNCATEGORIZED.
+ */
+func GetWarnings(counter *LogCounter) []Warning {
+	warningMutex.Lock()
+	defer warningMutex.Unlock()
+	warnings := make([]Warning, 0)
+	if counter == nil {
+		return append(warnings, recordedWarnings...)
+	}
+	pending := make(map[string][]Warning)
+	for _, warning := range recordedWarnings {
+		message := strings.TrimSpace(warning.Message)
+		pending[message] = append(pending[message], warning)
+	}
+	for _, message := range counter.GetWarningMessages() {
+		if matches := pending[message]; len(matches) > 0 {
+			warnings = append(warnings, matches[0])
+			pending[message] = matches[1:]
+			continue
+		}
+		warnings = append(warnings, Warning{Code: WARN_UNCATEGORIZED.Code, Name: WARN_UNCATEGORIZED.Name, Message: message})
+	}
+	return warnings
+}
@@ -0,0 +1,112 @@
+package restore
+
+/*
+ * This file contains functions for confirming restore operations that destroy
+ * data already in the restore database before they begin.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Describes what a destructive restore will do, for the confirmation prompt
+ * and the log.  Tables is the number of tables whose data will be restored.
+ */
+type DestructiveRestorePlan struct {
+	Database string
+	Cluster  string
+	Tables   int
+	Actions  []string
+}
+
+/*
+ * A database that already exists is never dropped, as --create-db fails
+ * validation for it, so only operations on existing tables are destructive.
+ */
+func GetDestructiveActions() []string {
+	actions := make([]string, 0)
+	if MustGetFlagBool(options.TRUNCATE_TABLE) {
+		actions = append(actions, fmt.Sprintf("truncate the existing data of each restored table (--%s)", options.TRUNCATE_TABLE))
+	}
+	if MustGetFlagBool(options.INCREMENTAL) {
+		actions = append(actions, fmt.Sprintf("truncate the existing data of each table changed since the previous backup (--%s)", options.INCREMENTAL))
+	}
+	if MustGetFlagBool(options.REBUILD_INDEXES) {
+		actions = append(actions, fmt.Sprintf("drop the indexes of each restored table and re-create them after loading its data (--%s)", options.REBUILD_INDEXES))
+	}
+	return actions
+}
+
+/*
+ * Destructive operations are refused with --no-destructive, and otherwise
+ * confirmed interactively unless --force is given or there is no terminal to
+ * ask on, so that scripted restores are not left waiting for an answer.
+ */
+func ConfirmDestructiveRestore(plan DestructiveRestorePlan, input io.Reader, output io.Writer, isTerminal bool) error {
+	if len(plan.Actions) == 0 {
+		return nil
+	}
+	for _, action := range plan.Actions {
+		gplog.Info("Restore to database %s on %s will %s", plan.Database, plan.Cluster, action)
+	}
+	if MustGetFlagBool(options.NO_DESTRUCTIVE) {
+		return errors.Errorf("Restore would %s, but --%s was given", strings.Join(plan.Actions, " and "), options.NO_DESTRUCTIVE)
+	}
+	if MustGetFlagBool(options.FORCE) {
+		gplog.Info("Proceeding without confirmation because --%s was given", options.FORCE)
+		return nil
+	}
+	if !isTerminal {
+		gplog.Info("Proceeding without confirmation because standard input is not a terminal")
+		return nil
+	}
+
+	prompt := fmt.Sprintf("\nThis restore is destructive.\n\n%-16s%s\n%-16s%s\n%-16s%d\n\nIt will:\n",
+		"database:", plan.Database, "cluster:", plan.Cluster, "tables:", plan.Tables)
+	for _, action := range plan.Actions {
+		prompt += fmt.Sprintf("  - %s\n", action)
+	}
+	prompt += "\nContinue? Type yes to proceed: "
+	_, _ = fmt.Fprint(output, prompt)
+	answer, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "Unable to read the answer to the confirmation prompt")
+	}
+	answer = strings.TrimSpace(answer)
+	gplog.Info("Confirmation prompt answered %q", answer)
+	if lower := strings.ToLower(answer); lower != "yes" && lower != "y" {
+		return errors.Errorf("Restore canceled at the confirmation prompt; use --%s to proceed without confirmation", options.FORCE)
+	}
+	gplog.Info("Proceeding with destructive restore as confirmed")
+	return nil
+}
+
+func stdinIsTerminal() bool {
+	stdin, ok := operating.System.Stdin.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func confirmDestructiveRestore(unquotedRestoreDatabase string) {
+	plan := DestructiveRestorePlan{
+		Database: unquotedRestoreDatabase,
+		Cluster:  fmt.Sprintf("%s:%d", connectionPool.Host, connectionPool.Port),
+		Tables:   len(GenerateRestoreRelationList(*opts)),
+		Actions:  GetDestructiveActions(),
+	}
+	err := ConfirmDestructiveRestore(plan, operating.System.Stdin, operating.System.Stdout, stdinIsTerminal())
+	gplog.FatalOnError(err)
+}
@@ -0,0 +1,84 @@
+package restore_test
+
+import (
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/restore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("restore/confirm tests", func() {
+	var plan restore.DestructiveRestorePlan
+	BeforeEach(func() {
+		plan = restore.DestructiveRestorePlan{
+			Database: "prod",
+			Cluster:  "cdw:5432",
+			Tables:   42,
+			Actions:  []string{"truncate the existing data of each restored table (--truncate-table)"},
+		}
+	})
+	Describe("GetDestructiveActions", func() {
+		It("returns no actions without destructive flags", func() {
+			Expect(restore.GetDestructiveActions()).To(BeEmpty())
+		})
+		It("returns an action for each destructive flag", func() {
+			_ = cmdFlags.Set(options.TRUNCATE_TABLE, "true")
+			_ = cmdFlags.Set(options.REBUILD_INDEXES, "true")
+			Expect(restore.GetDestructiveActions()).To(Equal([]string{
+				"truncate the existing data of each restored table (--truncate-table)",
+				"drop the indexes of each restored table and re-create them after loading its data (--rebuild-indexes)",
+			}))
+		})
+	})
+	Describe("ConfirmDestructiveRestore", func() {
+		It("does not prompt for a restore without destructive actions", func() {
+			plan.Actions = nil
+			err := restore.ConfirmDestructiveRestore(plan, strings.NewReader(""), buffer, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+		It("prompts on a terminal and proceeds when the user answers yes", func() {
+			err := restore.ConfirmDestructiveRestore(plan, strings.NewReader("yes\n"), buffer, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer).To(Say(`database:       prod
+cluster:        cdw:5432
+tables:         42
+
+It will:
+  - truncate the existing data of each restored table \(--truncate-table\)
+
+Continue\? Type yes to proceed: `))
+			Expect(logfile).To(Say(`Confirmation prompt answered "yes"`))
+			Expect(logfile).To(Say("Proceeding with destructive restore as confirmed"))
+		})
+		It("returns an error when the user does not answer yes", func() {
+			err := restore.ConfirmDestructiveRestore(plan, strings.NewReader("no\n"), buffer, true)
+			Expect(err).To(MatchError("Restore canceled at the confirmation prompt; use --force to proceed without confirmation"))
+			Expect(logfile).To(Say(`Confirmation prompt answered "no"`))
+		})
+		It("does not prompt with --force", func() {
+			_ = cmdFlags.Set(options.FORCE, "true")
+			err := restore.ConfirmDestructiveRestore(plan, strings.NewReader(""), buffer, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.Contents()).To(BeEmpty())
+			Expect(logfile).To(Say("Proceeding without confirmation because --force was given"))
+		})
+		It("does not prompt without a terminal", func() {
+			err := restore.ConfirmDestructiveRestore(plan, strings.NewReader(""), buffer, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buffer.Contents()).To(BeEmpty())
+			Expect(logfile).To(Say("Proceeding without confirmation because standard input is not a terminal"))
+		})
+		It("returns an error with --no-destructive, even with --force", func() {
+			_ = cmdFlags.Set(options.NO_DESTRUCTIVE, "true")
+			_ = cmdFlags.Set(options.FORCE, "true")
+			err := restore.ConfirmDestructiveRestore(plan, strings.NewReader("yes\n"), buffer, true)
+			Expect(err).To(MatchError("Restore would truncate the existing data of each restored table (--truncate-table), but --no-destructive was given"))
+			Expect(buffer.Contents()).To(BeEmpty())
+		})
+	})
+})
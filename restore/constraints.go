@@ -0,0 +1,192 @@
+package restore
+
+/*
+ * This file contains functions for adding the constraints in the post-data of
+ * a backup, which on a database with many foreign keys can take longer than
+ * the rest of the post-data together.
+ */
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+const SLOWEST_CONSTRAINTS = 10
+
+// Captures the table a FOREIGN KEY constraint references
+var foreignKeyRegex = regexp.MustCompile(` ADD CONSTRAINT (?:"(?:[^"]|"")*"|[^\s"]+) FOREIGN KEY \(.*?\) REFERENCES ((?:(?:"(?:[^"]|"")*"|[^\s"(.]+)\.)?(?:"(?:[^"]|"")*"|[^\s"(.]+))\(`)
+
+func SplitConstraintStatements(statements []toc.StatementWithType) ([]toc.StatementWithType, []toc.StatementWithType) {
+	constraints := make([]toc.StatementWithType, 0)
+	others := make([]toc.StatementWithType, 0)
+	for _, statement := range statements {
+		if statement.ObjectType == "CONSTRAINT" {
+			constraints = append(constraints, statement)
+		} else {
+			others = append(others, statement)
+		}
+	}
+	return constraints, others
+}
+
+/*
+ * gpbackup prints FOREIGN KEY constraints after all other constraints, so
+ * every statement from the first FOREIGN KEY on, including the comments on
+ * those constraints, is added once the keys they may reference exist.
+ */
+func SplitForeignKeyStatements(statements []toc.StatementWithType) ([]toc.StatementWithType, []toc.StatementWithType) {
+	for i, statement := range statements {
+		if foreignKeyRegex.MatchString(statement.Statement) {
+			return statements[:i], statements[i:]
+		}
+	}
+	return statements, []toc.StatementWithType{}
+}
+
+/*
+ * Groups constraint statements that must be run one at a time, keeping the
+ * order of the statements within each group.  The statements on the same
+ * table share a group, as do the foreign keys of tables referencing the same
+ * table or one another, since adding a foreign key locks the referenced
+ * table against the same lock taken on another connection.
+ */
+func GroupConstraintStatements(statements []toc.StatementWithType) [][]toc.StatementWithType {
+	parent := make(map[string]string)
+	var find func(table string) string
+	find = func(table string) string {
+		if parent[table] == "" || parent[table] == table {
+			parent[table] = table
+			return table
+		}
+		root := find(parent[table])
+		parent[table] = root
+		return root
+	}
+	for _, statement := range statements {
+		if matches := foreignKeyRegex.FindStringSubmatch(statement.Statement); matches != nil {
+			parent[find(matches[1])] = find(statement.ReferenceObject)
+		}
+	}
+
+	groups := make([][]toc.StatementWithType, 0)
+	groupIndex := make(map[string]int)
+	for _, statement := range statements {
+		root := find(statement.ReferenceObject)
+		i, ok := groupIndex[root]
+		if !ok {
+			i = len(groups)
+			groupIndex[root] = i
+			groups = append(groups, make([]toc.StatementWithType, 0))
+		}
+		groups[i] = append(groups[i], statement)
+	}
+	return groups
+}
+
+type constraintPhase struct {
+	total       int
+	started     int32
+	threshold   time.Duration
+	progressBar utils.ProgressBar
+	fatalErr    error
+	numErrors   int32
+}
+
+func (phase *constraintPhase) addConstraints(statements []toc.StatementWithType, whichConn int) {
+	for _, statement := range statements {
+		if wasTerminated || phase.fatalErr != nil {
+			return
+		}
+		task := make(chan toc.StatementWithType, 1)
+		task <- statement
+		close(task)
+		if !strings.Contains(statement.Statement, " ADD CONSTRAINT ") {
+			executeStatementsForConn(task, &phase.fatalErr, &phase.numErrors, phase.progressBar, whichConn, true)
+			continue
+		}
+
+		num := atomic.AddInt32(&phase.started, 1)
+		gplog.Verbose("Adding constraint %s on %s (%d of %d)", statement.Name, statement.ReferenceObject, num, phase.total)
+		var timer *time.Timer
+		if phase.threshold > 0 {
+			timer = time.AfterFunc(phase.threshold, func() {
+				gplog.Info("Still adding constraint %s on %s (%d of %d) after %s", statement.Name, statement.ReferenceObject, num, phase.total, phase.threshold)
+			})
+		}
+		start := time.Now()
+		executeStatementsForConn(task, &phase.fatalErr, &phase.numErrors, phase.progressBar, whichConn, true)
+		duration := time.Since(start)
+		if timer != nil {
+			timer.Stop()
+		}
+		if duration >= phase.threshold {
+			gplog.Info("Constraint %s on %s took %s", statement.Name, statement.ReferenceObject, duration.Round(time.Millisecond))
+		}
+		mutex.Lock()
+		constraintTimings = append(constraintTimings, report.ConstraintTiming{Constraint: statement.Name, Table: statement.ReferenceObject, Duration: duration})
+		mutex.Unlock()
+	}
+}
+
+func (phase *constraintPhase) addConstraintGroupsInParallel(groups [][]toc.StatementWithType) {
+	tasks := make(chan []toc.StatementWithType, len(groups))
+	for _, group := range groups {
+		tasks <- group
+	}
+	close(tasks)
+	var workerPool sync.WaitGroup
+	for i := 0; i < connectionPool.NumConns; i++ {
+		workerPool.Add(1)
+		go func(connNum int) {
+			defer workerPool.Done()
+			connNum = connectionPool.ValidateConnNum(connNum)
+			for group := range tasks {
+				phase.addConstraints(group, connNum)
+			}
+		}(i)
+	}
+	workerPool.Wait()
+}
+
+/*
+ * With more than one connection, the constraints other than foreign keys are
+ * added in parallel, followed by the foreign keys, unless --serial-constraints
+ * is set, in which case constraints are added one at a time on a single
+ * connection.  Each constraint taking at least --slow-constraint-seconds to
+ * add is logged with its duration.
+ */
+func AddConstraints(statements []toc.StatementWithType) {
+	if len(statements) == 0 {
+		return
+	}
+	numConstraints := 0
+	for _, statement := range statements {
+		if strings.Contains(statement.Statement, " ADD CONSTRAINT ") {
+			numConstraints++
+		}
+	}
+	phase := &constraintPhase{
+		total:       numConstraints,
+		threshold:   time.Duration(MustGetFlagInt(options.SLOW_CONSTRAINT_SECS)) * time.Second,
+		progressBar: utils.NewProgressBar(len(statements), "Constraints added: ", utils.PB_VERBOSE),
+	}
+	phase.progressBar.Start()
+	if connectionPool.NumConns > 1 && !MustGetFlagBool(options.SERIAL_CONSTRAINTS) {
+		others, foreignKeys := SplitForeignKeyStatements(statements)
+		phase.addConstraintGroupsInParallel(GroupConstraintStatements(others))
+		phase.addConstraintGroupsInParallel(GroupConstraintStatements(foreignKeys))
+	} else {
+		phase.addConstraints(statements, connectionPool.ValidateConnNum())
+	}
+	phase.progressBar.Finish()
+	reportStatementErrors(phase.fatalErr, phase.numErrors)
+}
@@ -0,0 +1,122 @@
+package restore_test
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/constraints tests", func() {
+	fooPkey := toc.StatementWithType{ObjectType: "CONSTRAINT", Schema: "public", Name: "foo_pkey", ReferenceObject: "public.foo",
+		Statement: "\n\nALTER TABLE ONLY public.foo ADD CONSTRAINT foo_pkey PRIMARY KEY (i);\n"}
+	barPkey := toc.StatementWithType{ObjectType: "CONSTRAINT", Schema: "public", Name: "bar_pkey", ReferenceObject: "public.bar",
+		Statement: "\n\nALTER TABLE ONLY public.bar ADD CONSTRAINT bar_pkey PRIMARY KEY (i);\n"}
+	bazCheck := toc.StatementWithType{ObjectType: "CONSTRAINT", Schema: "public", Name: "baz_check", ReferenceObject: "public.baz",
+		Statement: "\n\nALTER TABLE public.baz ADD CONSTRAINT baz_check CHECK ((i > 0));\n"}
+	barFk := toc.StatementWithType{ObjectType: "CONSTRAINT", Schema: "public", Name: "bar_fk", ReferenceObject: "public.bar",
+		Statement: "\n\nALTER TABLE ONLY public.bar ADD CONSTRAINT bar_fk FOREIGN KEY (j) REFERENCES public.foo(i);\n"}
+	barFkComment := toc.StatementWithType{ObjectType: "CONSTRAINT", Schema: "public", Name: "bar_fk", ReferenceObject: "public.bar",
+		Statement: "\n\nCOMMENT ON CONSTRAINT bar_fk ON public.bar IS 'references foo';\n"}
+	bazFk := toc.StatementWithType{ObjectType: "CONSTRAINT", Schema: "public", Name: "baz_fk", ReferenceObject: "public.baz",
+		Statement: "\n\nALTER TABLE ONLY public.baz ADD CONSTRAINT baz_fk FOREIGN KEY (j) REFERENCES public.\"Other Table\"(i);\n"}
+	index := toc.StatementWithType{ObjectType: "INDEX", Schema: "public", Name: "foo_idx", ReferenceObject: "public.foo",
+		Statement: "\n\nCREATE INDEX foo_idx ON public.foo USING btree (j);\n"}
+
+	Describe("SplitConstraintStatements", func() {
+		It("separates constraints from other post-data statements", func() {
+			constraints, others := restore.SplitConstraintStatements([]toc.StatementWithType{fooPkey, index, barFk})
+			Expect(constraints).To(Equal([]toc.StatementWithType{fooPkey, barFk}))
+			Expect(others).To(Equal([]toc.StatementWithType{index}))
+		})
+	})
+	Describe("SplitForeignKeyStatements", func() {
+		It("splits the statements at the first foreign key", func() {
+			others, foreignKeys := restore.SplitForeignKeyStatements([]toc.StatementWithType{fooPkey, barPkey, barFk, barFkComment, bazFk})
+			Expect(others).To(Equal([]toc.StatementWithType{fooPkey, barPkey}))
+			Expect(foreignKeys).To(Equal([]toc.StatementWithType{barFk, barFkComment, bazFk}))
+
+			others, foreignKeys = restore.SplitForeignKeyStatements([]toc.StatementWithType{bazCheck, bazFk})
+			Expect(others).To(Equal([]toc.StatementWithType{bazCheck}))
+			Expect(foreignKeys).To(Equal([]toc.StatementWithType{bazFk}))
+		})
+		It("returns no foreign keys when there are none", func() {
+			others, foreignKeys := restore.SplitForeignKeyStatements([]toc.StatementWithType{fooPkey, bazCheck})
+			Expect(others).To(Equal([]toc.StatementWithType{fooPkey, bazCheck}))
+			Expect(foreignKeys).To(BeEmpty())
+		})
+	})
+	Describe("GroupConstraintStatements", func() {
+		It("groups the statements on each table in order", func() {
+			groups := restore.GroupConstraintStatements([]toc.StatementWithType{fooPkey, barPkey, barFkComment, bazCheck})
+			Expect(groups).To(Equal([][]toc.StatementWithType{{fooPkey}, {barPkey, barFkComment}, {bazCheck}}))
+		})
+		It("groups foreign keys with the tables they reference", func() {
+			fooFk := toc.StatementWithType{ObjectType: "CONSTRAINT", Schema: "public", Name: "foo_fk", ReferenceObject: "public.foo",
+				Statement: "\n\nALTER TABLE ONLY public.foo ADD CONSTRAINT foo_fk FOREIGN KEY (j) REFERENCES public.bar(i);\n"}
+			groups := restore.GroupConstraintStatements([]toc.StatementWithType{barFk, barFkComment, bazFk, fooFk, bazCheck})
+			Expect(groups).To(Equal([][]toc.StatementWithType{{barFk, barFkComment, fooFk}, {bazFk, bazCheck}}))
+		})
+	})
+	Describe("AddConstraints", func() {
+		BeforeEach(func() {
+			restore.SetConstraintTimings(nil)
+		})
+		It("adds constraints in order and logs those slower than the threshold", func() {
+			_ = cmdFlags.Set(options.SLOW_CONSTRAINT_SECS, "0")
+			mock.ExpectExec("ALTER TABLE ONLY public.foo ADD CONSTRAINT foo_pkey").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("ALTER TABLE ONLY public.bar ADD CONSTRAINT bar_fk").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("COMMENT ON CONSTRAINT bar_fk").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.AddConstraints([]toc.StatementWithType{fooPkey, barFk, barFkComment})
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(string(logfile.Contents())).To(ContainSubstring("Constraint foo_pkey on public.foo took"))
+			Expect(string(logfile.Contents())).To(ContainSubstring("Constraint bar_fk on public.bar took"))
+			timings := restore.GetConstraintTimings()
+			Expect(timings).To(HaveLen(2))
+			Expect(timings[0].Constraint).To(Equal("foo_pkey"))
+			Expect(timings[1].Table).To(Equal("public.bar"))
+		})
+		It("does not log constraints faster than the threshold", func() {
+			mock.ExpectExec("ALTER TABLE ONLY public.foo ADD CONSTRAINT foo_pkey").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.AddConstraints([]toc.StatementWithType{fooPkey})
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(string(logfile.Contents())).ToNot(ContainSubstring("Constraint foo_pkey on public.foo took"))
+			Expect(restore.GetConstraintTimings()).To(HaveLen(1))
+		})
+		Context("with more than one connection", func() {
+			BeforeEach(func() {
+				connectionPool, mock = testhelper.CreateAndConnectMockDB(2)
+				restore.SetConnection(connectionPool)
+			})
+			It("adds the constraints of different tables in parallel by default", func() {
+				mock.MatchExpectationsInOrder(false)
+				mock.ExpectExec("ALTER TABLE ONLY public.foo ADD CONSTRAINT foo_pkey").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("ALTER TABLE public.baz ADD CONSTRAINT baz_check").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("ALTER TABLE ONLY public.bar ADD CONSTRAINT bar_fk").WillReturnResult(sqlmock.NewResult(0, 0))
+
+				restore.AddConstraints([]toc.StatementWithType{fooPkey, bazCheck, barFk})
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+				Expect(restore.GetConstraintTimings()).To(HaveLen(3))
+			})
+			It("adds constraints one at a time in order with --serial-constraints", func() {
+				_ = cmdFlags.Set(options.SERIAL_CONSTRAINTS, "true")
+				mock.ExpectExec("ALTER TABLE ONLY public.foo ADD CONSTRAINT foo_pkey").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("ALTER TABLE public.baz ADD CONSTRAINT baz_check").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec("ALTER TABLE ONLY public.bar ADD CONSTRAINT bar_fk").WillReturnResult(sqlmock.NewResult(0, 0))
+
+				restore.AddConstraints([]toc.StatementWithType{fooPkey, bazCheck, barFk})
+
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			})
+		})
+	})
+})
@@ -6,13 +6,18 @@ package restore
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/jackc/pgconn"
@@ -24,22 +29,40 @@ var (
 	tableDelim = ","
 )
 
-func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttributes string, destinationToRead string, singleDataFile bool, whichConn int) (int64, error) {
+/*
+ * Data backed up with a plugin is never staged on the segments: the COPY
+ * program reads each file from the plugin's restore_data output, and with a
+ * single data file the helper reads the plugin's output into its pipes.
+ * Whichever it reads from, the COPY program counts the bytes it reads for
+ * the load rates logged once the data is restored.
+ */
+func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableOid uint32, tableAttributes string, destinationToRead string, singleDataFile bool, dataFormat string, whichConn int) (int64, error) {
 	whichConn = connectionPool.ValidateConnNum(whichConn)
 	copyCommand := ""
-	readFromDestinationCommand := "cat"
+	countDir := globalFPInfo.GetLoadByteCountDir()
+	readCommand := fmt.Sprintf("mkdir -p %s && %s < %s", countDir, utils.ByteCountCommand(countDir, tableOid), destinationToRead)
 	customPipeThroughCommand := utils.GetPipeThroughProgram().InputCommand
 
 	if singleDataFile {
 		//helper.go handles compression, so we don't want to set it here
 		customPipeThroughCommand = "cat -"
 	} else if MustGetFlagString(options.PLUGIN_CONFIG) != "" {
-		readFromDestinationCommand = fmt.Sprintf("%s restore_data %s", pluginConfig.ExecutablePath, pluginConfig.ConfigPath)
+		readCommand = fmt.Sprintf("mkdir -p %s && %s restore_data %s %s | %s", countDir, pluginConfig.ForData().ExecutablePath,
+			pluginConfig.ForData().ConfigPath, destinationToRead, utils.ByteCountCommand(countDir, tableOid))
 	}
 
-	copyCommand = fmt.Sprintf("PROGRAM '%s %s | %s'", readFromDestinationCommand, destinationToRead, customPipeThroughCommand)
+	hostSlotCommand := utils.HostSlotCommandPrefix(globalFPInfo.GetHostSlotDir(), MustGetFlagInt(options.MAX_PER_HOST))
+	if encodingConversion != nil && encodingConversion.Method == report.ENCODING_CONVERSION_HELPER {
+		customPipeThroughCommand += " | " + utils.TranscodeCommand(globalFPInfo.GetConversionCountDir(), tableOid,
+			encodingConversion.SourceEncoding, encodingConversion.TargetEncoding, encodingConversion.OnError)
+	}
+	copyCommand = fmt.Sprintf("PROGRAM '%s%s | %s'", hostSlotCommand, readCommand, customPipeThroughCommand)
 
-	query := fmt.Sprintf("COPY %s%s FROM %s WITH CSV DELIMITER '%s' ON SEGMENT;", tableName, tableAttributes, copyCommand, tableDelim)
+	formatClause := fmt.Sprintf("CSV DELIMITER '%s'", tableDelim)
+	if dataFormat == "binary" {
+		formatClause = "BINARY"
+	}
+	query := fmt.Sprintf("COPY %s%s FROM %s WITH %s ON SEGMENT;", tableName, tableAttributes, copyCommand, formatClause)
 	gplog.Verbose(query)
 	result, err := connectionPool.Exec(query, whichConn)
 	if err != nil {
@@ -56,23 +79,46 @@ func CopyTableIn(connectionPool *dbconn.DBConn, tableName string, tableAttribute
 	return numRows, err
 }
 
-func restoreSingleTableData(fpInfo *filepath.FilePathInfo, entry toc.MasterDataEntry, tableName string, whichConn int) error {
+func restoreSingleTableData(fpInfo *filepath.FilePathInfo, entry toc.MasterDataEntry, tableName string, whichConn int) (int64, error) {
 	destinationToRead := ""
 	if backupConfig.SingleDataFile {
 		destinationToRead = fmt.Sprintf("%s_%d", fpInfo.GetSegmentPipePathForCopyCommand(), entry.Oid)
+	} else if len(entry.ContentHashes) > 0 {
+		// Each segment looks up the name of its own data file in its reference file
+		destinationToRead = fmt.Sprintf(`%s/$(cut -d" " -f2 %s)`, fpInfo.GetContentAddressedDirForCopyCommand(),
+			fpInfo.GetTableContentRefFilePathForCopyCommand(entry.Oid))
 	} else {
 		destinationToRead = fpInfo.GetTableBackupFilePathForCopyCommand(entry.Oid, utils.GetPipeThroughProgram().Extension, backupConfig.SingleDataFile)
 	}
-	numRowsRestored, err := CopyTableIn(connectionPool, tableName, entry.AttributeString, destinationToRead, backupConfig.SingleDataFile, whichConn)
+	numRowsRestored, err := CopyTableIn(connectionPool, tableName, entry.Oid, entry.AttributeString, destinationToRead, backupConfig.SingleDataFile, entry.Format, whichConn)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	numRowsBackedUp := entry.RowsCopied
+	if encodingConversion.SkipsRows() && numRowsRestored < numRowsBackedUp {
+		// Rows that failed conversion are reported once all data is restored
+		return numRowsRestored, nil
+	}
 	err = CheckRowsRestored(numRowsRestored, numRowsBackedUp, tableName)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	return numRowsRestored, nil
+}
+
+/*
+ * The bytes read for each table are counted on the segments, so they are
+ * only gathered, and the load rates they give logged, once all table data
+ * has been restored.  restoredTableOids holds the oid of each table in
+ * restoredTables.
+ */
+func recordTableLoadBytes(bytesByOid map[uint32]int64) {
+	for i, oid := range restoredTableOids {
+		stats := &restoredTables[i]
+		stats.Bytes = bytesByOid[oid]
+		gplog.Info("Read %d bytes of data for table %s in %.2f seconds (%.2f MB/sec)", stats.Bytes, stats.Table, stats.Seconds,
+			float64(stats.Bytes)/(1<<20)/math.Max(stats.Seconds, 0.001))
 	}
-	return nil
 }
 
 func CheckRowsRestored(rowsRestored int64, rowsBackedUp int64, tableName string) error {
@@ -83,17 +129,67 @@ func CheckRowsRestored(rowsRestored int64, rowsBackedUp int64, tableName string)
 	return nil
 }
 
+/*
+ * A failed COPY can only be retried when the table may safely be truncated
+ * and the data can be read again.  Single data file restores stream through
+ * pipes on the segments that cannot be rewound.
+ *
+ * Truncating is safe when the user asked for it, or when this restore created
+ * the table.  Without --on-error-continue, DoSetup has already verified that no
+ * restored table existed beforehand; with it, a table whose metadata failed to
+ * restore may be a pre-existing table holding user data.
+ */
+func canRetryTableData(tableName string) bool {
+	if backupConfig.SingleDataFile {
+		return false
+	}
+	if MustGetFlagBool(options.INCREMENTAL) || MustGetFlagBool(options.TRUNCATE_TABLE) {
+		return true
+	}
+	if backupConfig.DataOnly || MustGetFlagBool(options.DATA_ONLY) {
+		return false
+	}
+	if MustGetFlagBool(options.ON_ERROR_CONTINUE) && !MustGetFlagBool(options.CREATE_DB) {
+		_, hadMetadataError := errorTablesMetadata[tableName]
+		return !hadMetadataError
+	}
+	return true
+}
+
+/*
+ * Columns excluded with --exclude-column-data were backed up as NULL or a
+ * constant, which is all that restoring them can bring back.
+ */
+func warnColumnSubstitutions(dataEntries []toc.MasterDataEntry) {
+	for _, entry := range dataEntries {
+		if len(entry.ColumnSubstitutions) == 0 {
+			continue
+		}
+		columns := make([]string, 0, len(entry.ColumnSubstitutions))
+		for column := range entry.ColumnSubstitutions {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		report.Warn(report.WARN_PARTIAL_TABLE_DATA, utils.MakeFQN(entry.Schema, entry.Name), "Table %s was partially backed up; restoring columns %s without their original data",
+			utils.MakeFQN(entry.Schema, entry.Name), strings.Join(columns, ", "))
+	}
+}
+
 func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.MasterDataEntry,
-	gucStatements []toc.StatementWithType, dataProgressBar utils.ProgressBar) {
+	gucStatements []toc.StatementWithType, dataProgressBar utils.ProgressBar, references map[string][]string) {
 	totalTables := len(dataEntries)
 	if totalTables == 0 {
 		gplog.Verbose("No data to restore for timestamp = %s", fpInfo.Timestamp)
 		return
 	}
+	schedule := ScheduleDataEntries(dataEntries, references, opts.RedirectSchema)
+	printDataSchedule(schedule)
+	// gpbackup_helper restores the tables of a single data file in the order they are listed
+	dataEntries = schedule.Entries()
 
 	if backupConfig.SingleDataFile {
 		gplog.Verbose("Initializing pipes and gpbackup_helper on segments for single data file restore")
-		utils.VerifyHelperVersionOnSegments(version, globalCluster)
+		utils.VerifyHelperVersionOnSegments(version, MustGetFlagBool(options.ALLOW_HELPER_SKEW), globalCluster)
 		filteredOids := make([]string, totalTables)
 		for i, entry := range dataEntries {
 			filteredOids[i] = fmt.Sprintf("%d", entry.Oid)
@@ -108,80 +204,126 @@ func restoreDataFromTimestamp(fpInfo filepath.FilePathInfo, dataEntries []toc.Ma
 		if len(opts.IncludedRelations) > 0 || len(opts.ExcludedRelations) > 0 || len(opts.IncludedSchemas) > 0 || len(opts.ExcludedSchemas) > 0 {
 			isFilter = true
 		}
-		utils.StartGpbackupHelpers(globalCluster, fpInfo, "--restore-agent", MustGetFlagString(options.PLUGIN_CONFIG), "", MustGetFlagBool(options.ON_ERROR_CONTINUE), isFilter, &wasTerminated)
+		pluginConfigPath := ""
+		if pluginConfig != nil {
+			pluginConfigPath = pluginConfig.ForData().ConfigPath
+		}
+		utils.StartGpbackupHelpers(globalCluster, fpInfo, "--restore-agent", pluginConfigPath, "", MustGetFlagBool(options.ON_ERROR_CONTINUE), isFilter,
+			version, MustGetFlagBool(options.ALLOW_HELPER_SKEW), &wasTerminated)
 	}
+	warnColumnSubstitutions(dataEntries)
 	/*
 	 * We break when an interrupt is received and rely on
 	 * TerminateHangingCopySessions to kill any COPY
 	 * statements in progress if they don't finish on their own.
 	 */
 	var tableNum int64 = 0
-	tasks := make(chan toc.MasterDataEntry, totalTables)
-	var workerPool sync.WaitGroup
 	var numErrors int32
 	var mutex = &sync.Mutex{}
 
 	for i := 0; i < connectionPool.NumConns; i++ {
-		workerPool.Add(1)
-		go func(whichConn int) {
-			defer workerPool.Done()
-
-			setGUCsForConnection(gucStatements, whichConn)
-			for entry := range tasks {
-				if wasTerminated {
-					dataProgressBar.(*pb.ProgressBar).NotPrint = true
-					return
-				}
-				tableName := utils.MakeFQN(entry.Schema, entry.Name)
-				if opts.RedirectSchema != "" {
-					tableName = utils.MakeFQN(opts.RedirectSchema, entry.Name)
-				}
-				// Truncate table before restore, if needed
-				var err error
-				if MustGetFlagBool(options.INCREMENTAL) || MustGetFlagBool(options.TRUNCATE_TABLE) {
-					err = TruncateTable(tableName, whichConn)
-				}
-				if err == nil {
-					err = restoreSingleTableData(&fpInfo, entry, tableName, whichConn)
+		setGUCsForConnection(gucStatements, i)
+		setDataEncodingForConnection(i)
+	}
+	restoreBatch := func(batch []toc.MasterDataEntry, replicaRole bool) {
+		tasks := make(chan toc.MasterDataEntry, len(batch))
+		var workerPool sync.WaitGroup
+		for i := 0; i < connectionPool.NumConns; i++ {
+			workerPool.Add(1)
+			go func(whichConn int) {
+				defer workerPool.Done()
+				for entry := range tasks {
+					if wasTerminated {
+						dataProgressBar.(*pb.ProgressBar).NotPrint = true
+						return
+					}
+					tableName := restoredTableName(entry, opts.RedirectSchema)
+					// Truncate table before restore, if needed
+					var err error
+					if MustGetFlagBool(options.INCREMENTAL) || MustGetFlagBool(options.TRUNCATE_TABLE) {
+						err = TruncateTable(tableName, whichConn)
+					}
+					var numRows int64
+					loadStart := time.Now()
+					if err == nil {
+						numRows, err = restoreSingleTableData(&fpInfo, entry, tableName, whichConn)
+						if IsConnectionLost(err) && canRetryTableData(tableName) && ReconnectConnection(whichConn, err) {
+							setDataEncodingForConnection(whichConn)
+							if replicaRole {
+								connectionPool.MustExec("SET session_replication_role = replica", whichConn)
+							}
+							// Truncate first so that rows from an interrupted COPY are not loaded twice
+							err = TruncateTable(tableName, whichConn)
+							if err == nil {
+								numRows, err = restoreSingleTableData(&fpInfo, entry, tableName, whichConn)
+							}
+						}
 
-					atomic.AddInt64(&tableNum, 1)
-					if gplog.GetVerbosity() > gplog.LOGINFO {
-						// No progress bar at this log level, so we note table count here
-						gplog.Verbose("Restored data to table %s from file (table %d of %d)", tableName, tableNum, totalTables)
-					} else {
-						gplog.Verbose("Restored data to table %s from file", tableName)
+						atomic.AddInt64(&tableNum, 1)
+						if gplog.GetVerbosity() > gplog.LOGINFO {
+							// No progress bar at this log level, so we note table count here
+							gplog.Verbose("Restored data to table %s from file (table %d of %d)", tableName, tableNum, totalTables)
+						} else {
+							gplog.Verbose("Restored data to table %s from file", tableName)
+						}
 					}
-				}
 
-				if err != nil {
-					gplog.Error(err.Error())
-					atomic.AddInt32(&numErrors, 1)
-					if !MustGetFlagBool(options.ON_ERROR_CONTINUE) {
-						dataProgressBar.(*pb.ProgressBar).NotPrint = true
-						return
+					if err != nil {
+						gplog.Error(err.Error())
+						atomic.AddInt32(&numErrors, 1)
+						if !MustGetFlagBool(options.ON_ERROR_CONTINUE) {
+							dataProgressBar.(*pb.ProgressBar).NotPrint = true
+							return
+						}
+						mutex.Lock()
+						errorTablesData[tableName] = Empty{}
+						mutex.Unlock()
+					} else {
+						loadSeconds := time.Since(loadStart).Seconds()
+						gplog.Info("Restored %d rows to table %s in %.2f seconds (%.0f rows/sec)", numRows, tableName, loadSeconds,
+							float64(numRows)/math.Max(loadSeconds, 0.001))
+						mutex.Lock()
+						restoredTables = append(restoredTables, report.TableStats{Table: tableName, Rows: entry.RowsCopied, Seconds: loadSeconds})
+						restoredTableOids = append(restoredTableOids, entry.Oid)
+						mutex.Unlock()
 					}
-					mutex.Lock()
-					errorTablesData[tableName] = Empty{}
-					mutex.Unlock()
-				}
 
-				if backupConfig.SingleDataFile {
-					agentErr := utils.CheckAgentErrorsOnSegments(globalCluster, globalFPInfo)
-					if agentErr != nil {
-						gplog.Error(agentErr.Error())
-						return
+					if backupConfig.SingleDataFile {
+						agentErr := utils.CheckAgentErrorsOnSegments(globalCluster, globalFPInfo)
+						if agentErr != nil {
+							gplog.Error(agentErr.Error())
+							return
+						}
 					}
-				}
 
-				dataProgressBar.Increment()
-			}
-		}(i)
+					dataProgressBar.Increment()
+				}
+			}(i)
+		}
+		for _, entry := range batch {
+			tasks <- entry
+		}
+		close(tasks)
+		workerPool.Wait()
 	}
-	for _, entry := range dataEntries {
-		tasks <- entry
+	for i, batch := range schedule.Batches {
+		isCyclicBatch := schedule.Cyclic && i == len(schedule.Batches)-1
+		if isCyclicBatch {
+			warnForeignKeyCycle(batch)
+		}
+		replicaRole := isCyclicBatch && MustGetFlagBool(options.FK_CYCLE_REPLICA)
+		if replicaRole {
+			setReplicationRole("replica")
+		}
+		restoreBatch(batch, replicaRole)
+		if replicaRole {
+			setReplicationRole("DEFAULT")
+		}
+		// Later batches reference the tables of earlier ones, so they are not loaded after a failure
+		if wasTerminated || (numErrors > 0 && !MustGetFlagBool(options.ON_ERROR_CONTINUE)) {
+			break
+		}
 	}
-	close(tasks)
-	workerPool.Wait()
 
 	if numErrors > 0 {
 		fmt.Println("")
@@ -4,8 +4,9 @@ import (
 	"regexp"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/restore"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/jackc/pgconn"
@@ -18,31 +19,40 @@ var _ = Describe("restore/data tests", func() {
 	Describe("CopyTableIn", func() {
 		BeforeEach(func() {
 			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "cat", OutputCommand: "cat -", InputCommand: "cat -", Extension: ""})
-			backup.SetPluginConfig(nil)
+			restore.SetPluginConfig(nil)
 			_ = cmdFlags.Set(options.PLUGIN_CONFIG, "")
+			restore.SetFPInfo(filepath.FilePathInfo{Timestamp: "20170101010101", PID: 1234})
 		})
 		It("will restore a table from its own file with compression", func() {
 			utils.SetPipeThroughProgram(utils.PipeThroughProgram{Name: "gzip", OutputCommand: "gzip -c -1", InputCommand: "gzip -d -c", Extension: ".gz"})
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "csv", 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
 		It("will restore a table from its own file without compression", func() {
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "csv", 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will restore a table from its own file in binary format", func() {
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH BINARY ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "binary", 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
 		It("will restore a table from a single data file", func() {
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, true, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, true, "csv", 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -51,11 +61,11 @@ var _ = Describe("restore/data tests", func() {
 			_ = cmdFlags.Set(options.PLUGIN_CONFIG, "/tmp/plugin_config")
 			pluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/fake-plugin.sh", ConfigPath: "/tmp/plugin_config"}
 			restore.SetPluginConfig(&pluginConfig)
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM '/tmp/fake-plugin.sh restore_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && /tmp/fake-plugin.sh restore_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz | dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "csv", 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
@@ -63,16 +73,49 @@ var _ = Describe("restore/data tests", func() {
 			_ = cmdFlags.Set(options.PLUGIN_CONFIG, "/tmp/plugin_config")
 			pluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/fake-plugin.sh", ConfigPath: "/tmp/plugin_config"}
 			restore.SetPluginConfig(&pluginConfig)
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM '/tmp/fake-plugin.sh restore_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && /tmp/fake-plugin.sh restore_data /tmp/plugin_config <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz | dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "csv", 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will restore a table from its own file using the data plugin of a plugin", func() {
+			_ = cmdFlags.Set(options.PLUGIN_CONFIG, "/tmp/plugin_config")
+			dataPluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/fake-data-plugin.sh", ConfigPath: "/tmp/plugin_config_data"}
+			pluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/fake-plugin.sh", ConfigPath: "/tmp/plugin_config", DataPlugin: &dataPluginConfig}
+			restore.SetPluginConfig(&pluginConfig)
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && /tmp/fake-data-plugin.sh restore_data /tmp/plugin_config_data <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz | dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_pipe_3456.gz"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "csv", 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will restore a table through gpbackup_helper when it converts the encoding", func() {
+			restore.SetEncodingConversion(&report.EncodingConversion{SourceEncoding: "LATIN1", TargetEncoding: "UTF8", Method: report.ENCODING_CONVERSION_HELPER, OnError: "skip"})
+			defer restore.SetEncodingConversion(nil)
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat - | $GPHOME/bin/gpbackup_helper --transcode-agent --content <SEGID> --from-encoding LATIN1 --to-encoding UTF8 --on-conversion-error skip --count-file /tmp/gpbackup_20170101010101_conversion_1234/<SEGID>_16384' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "csv", 0)
+
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+		It("will restore a table without gpbackup_helper when the server converts the encoding", func() {
+			restore.SetEncodingConversion(&report.EncodingConversion{SourceEncoding: "LATIN1", TargetEncoding: "UTF8", Method: report.ENCODING_CONVERSION_SERVER, OnError: "fail"})
+			defer restore.SetEncodingConversion(nil)
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "csv", 0)
 
 			Expect(err).ShouldNot(HaveOccurred())
 		})
 		It("will output expected error string from COPY ON SEGMENT failure", func() {
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'cat <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'mkdir -p /tmp/gpbackup_20170101010101_load_bytes_1234 && dd bs=1048576 2>/tmp/gpbackup_20170101010101_load_bytes_1234/<SEGID>_16384 < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456 | cat -' WITH CSV DELIMITER ',' ON SEGMENT;")
 			pgErr := &pgconn.PgError{
 				Severity: "ERROR",
 				Code:     "22P04",
@@ -81,7 +124,7 @@ var _ = Describe("restore/data tests", func() {
 			}
 			mock.ExpectExec(execStr).WillReturnError(pgErr)
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
-			_, err := restore.CopyTableIn(connectionPool, "public.foo", "(i,j)", filename, false, 0)
+			_, err := restore.CopyTableIn(connectionPool, "public.foo", 16384, "(i,j)", filename, false, "csv", 0)
 
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("Error loading data into table public.foo: " +
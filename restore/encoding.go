@@ -0,0 +1,127 @@
+package restore
+
+/*
+ * This file contains functions for converting table data from the encoding it
+ * was backed up in to the encoding of the restore database.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+func GetServerEncoding(connectionPool *dbconn.DBConn) string {
+	return dbconn.MustSelectString(connectionPool, "SELECT current_setting('server_encoding') AS string")
+}
+
+/*
+ * The server accepts any data as SQL_ASCII and reads SQL_ASCII data without
+ * converting it, and otherwise converts only where a default conversion exists.
+ */
+func ServerCanConvertEncoding(connectionPool *dbconn.DBConn, sourceEncoding string, targetEncoding string) bool {
+	if sourceEncoding == "SQL_ASCII" || targetEncoding == "SQL_ASCII" {
+		return true
+	}
+	query := fmt.Sprintf(`
+SELECT CASE
+	WHEN EXISTS (SELECT 1 FROM pg_conversion
+		WHERE condefault
+		AND conforencoding = pg_char_to_encoding('%s')
+		AND contoencoding = pg_char_to_encoding('%s')) THEN 'true'
+	ELSE 'false'
+END AS string;`, utils.EscapeSingleQuotes(sourceEncoding), utils.EscapeSingleQuotes(targetEncoding))
+	canConvert, err := strconv.ParseBool(dbconn.MustSelectString(connectionPool, query))
+	gplog.FatalOnError(err)
+	return canConvert
+}
+
+/*
+ * Returns nil if the table data is already in the encoding of the restore
+ * database, or was backed up before the encoding was recorded.  The server
+ * converts the data where it can, unless rows that fail conversion are to be
+ * skipped or repaired, which only gpbackup_helper can do.
+ */
+func PlanEncodingConversion(connectionPool *dbconn.DBConn, sourceEncoding string, onError string, hasBinaryData bool) *report.EncodingConversion {
+	if sourceEncoding == "" {
+		return nil
+	}
+	targetEncoding := GetServerEncoding(connectionPool)
+	if sourceEncoding == targetEncoding {
+		return nil
+	}
+	conversion := &report.EncodingConversion{
+		SourceEncoding: sourceEncoding,
+		TargetEncoding: targetEncoding,
+		Method:         report.ENCODING_CONVERSION_SERVER,
+		OnError:        onError,
+	}
+	if onError == utils.CONVERSION_ERROR_FAIL && ServerCanConvertEncoding(connectionPool, sourceEncoding, targetEncoding) {
+		gplog.Info("Table data will be converted from %s to %s by the server", sourceEncoding, targetEncoding)
+		return conversion
+	}
+	if !utils.IsTranscodableEncoding(sourceEncoding) || !utils.IsTranscodableEncoding(targetEncoding) {
+		gplog.Fatal(errors.Errorf("Cannot convert table data from %s to %s", sourceEncoding, targetEncoding), "")
+	}
+	if hasBinaryData {
+		gplog.Fatal(errors.Errorf("Cannot convert table data from %s to %s for a backup taken in binary format", sourceEncoding, targetEncoding), "")
+	}
+	conversion.Method = report.ENCODING_CONVERSION_HELPER
+	gplog.Info("Table data will be converted from %s to %s by gpbackup_helper (on error: %s)", sourceEncoding, targetEncoding, onError)
+	return conversion
+}
+
+/*
+ * The server reads data in the session's client_encoding, so it is set to the
+ * encoding of the data as COPY will receive it.
+ */
+func setDataEncodingForConnection(whichConn int) {
+	if encodingConversion == nil {
+		return
+	}
+	dataEncoding := encodingConversion.SourceEncoding
+	if encodingConversion.Method == report.ENCODING_CONVERSION_HELPER {
+		dataEncoding = encodingConversion.TargetEncoding
+	}
+	connectionPool.MustExec(fmt.Sprintf("SET client_encoding = '%s'", dataEncoding), whichConn)
+}
+
+func recordConversionErrors(filteredDataEntries map[string][]toc.MasterDataEntry) {
+	failedRowsByOid := utils.CollectConversionErrorCounts(globalCluster, globalFPInfo)
+	if len(failedRowsByOid) == 0 {
+		return
+	}
+	failedRows := make([]report.TableStats, 0, len(failedRowsByOid))
+	for _, entries := range filteredDataEntries {
+		for _, entry := range entries {
+			count, ok := failedRowsByOid[entry.Oid]
+			if !ok {
+				continue
+			}
+			tableName := utils.MakeFQN(entry.Schema, entry.Name)
+			if opts.RedirectSchema != "" {
+				tableName = utils.MakeFQN(opts.RedirectSchema, entry.Name)
+			}
+			failedRows = append(failedRows, report.TableStats{Table: tableName, Rows: count})
+		}
+	}
+	sort.Slice(failedRows, func(i, j int) bool {
+		return failedRows[i].Table < failedRows[j].Table
+	})
+	action := "skipped"
+	if encodingConversion.OnError == utils.CONVERSION_ERROR_REPLACE {
+		action = "restored with characters replaced"
+	}
+	for _, table := range failedRows {
+		report.Warn(report.WARN_ENCODING_CONVERSION, table.Table, "%d rows of table %s could not be converted from %s to %s and were %s",
+			table.Rows, table.Table, encodingConversion.SourceEncoding, encodingConversion.TargetEncoding, action)
+	}
+	encodingConversion.FailedRows = failedRows
+}
@@ -0,0 +1,58 @@
+package restore_test
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/restore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/encoding tests", func() {
+	Describe("PlanEncodingConversion", func() {
+		expectServerEncoding := func(encoding string) {
+			mock.ExpectQuery("SELECT current_setting").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow(encoding))
+		}
+		expectServerCanConvert := func(canConvert string) {
+			mock.ExpectQuery("SELECT CASE").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow(canConvert))
+		}
+		It("does not convert a backup that did not record its encoding", func() {
+			Expect(restore.PlanEncodingConversion(connectionPool, "", "fail", false)).To(BeNil())
+		})
+		It("does not convert data already in the database encoding", func() {
+			expectServerEncoding("UTF8")
+			Expect(restore.PlanEncodingConversion(connectionPool, "UTF8", "fail", false)).To(BeNil())
+		})
+		It("has the server convert data it can convert", func() {
+			expectServerEncoding("UTF8")
+			expectServerCanConvert("true")
+			conversion := restore.PlanEncodingConversion(connectionPool, "LATIN1", "fail", false)
+			Expect(conversion).To(Equal(&report.EncodingConversion{SourceEncoding: "LATIN1", TargetEncoding: "UTF8", Method: report.ENCODING_CONVERSION_SERVER, OnError: "fail"}))
+		})
+		It("has gpbackup_helper convert data the server cannot convert", func() {
+			expectServerEncoding("LATIN1")
+			expectServerCanConvert("false")
+			conversion := restore.PlanEncodingConversion(connectionPool, "KOI8R", "fail", false)
+			Expect(conversion.Method).To(Equal(report.ENCODING_CONVERSION_HELPER))
+		})
+		It("has gpbackup_helper convert data when rows that fail conversion are skipped", func() {
+			expectServerEncoding("LATIN1")
+			conversion := restore.PlanEncodingConversion(connectionPool, "UTF8", "skip", false)
+			Expect(conversion.Method).To(Equal(report.ENCODING_CONVERSION_HELPER))
+			Expect(conversion.OnError).To(Equal("skip"))
+		})
+		It("panics if neither the server nor gpbackup_helper can convert the data", func() {
+			expectServerEncoding("UTF8")
+			expectServerCanConvert("false")
+			defer testhelper.ShouldPanicWithMessage("Cannot convert table data from MULE_INTERNAL to UTF8")
+			restore.PlanEncodingConversion(connectionPool, "MULE_INTERNAL", "fail", false)
+		})
+		It("panics if gpbackup_helper would have to convert binary data", func() {
+			expectServerEncoding("LATIN1")
+			defer testhelper.ShouldPanicWithMessage("Cannot convert table data from UTF8 to LATIN1 for a backup taken in binary format")
+			restore.PlanEncodingConversion(connectionPool, "UTF8", "replace", true)
+		})
+	})
+})
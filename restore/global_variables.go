@@ -8,6 +8,7 @@ import (
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
 	"github.com/spf13/pflag"
@@ -30,9 +31,15 @@ type Empty struct{}
 var (
 	backupConfig        *history.BackupConfig
 	connectionPool      *dbconn.DBConn
+	constraintTimings   []report.ConstraintTiming
+	droppedIndexes      []RebuildIndex
+	encodingConversion  *report.EncodingConversion
 	globalCluster       *cluster.Cluster
 	globalFPInfo        filepath.FilePathInfo
 	globalTOC           *toc.TOC
+	hostConcurrency     map[string]int
+	indexRebuilds       []report.IndexRebuild
+	oidsRemovedTables   []string
 	pluginConfig        *utils.PluginConfig
 	restoreStartTime    string
 	version             string
@@ -40,6 +47,17 @@ var (
 	errorTablesMetadata map[string]Empty
 	errorTablesData     map[string]Empty
 	opts                *options.Options
+	setupQuery          string
+	sessionGUCs         []toc.StatementWithType
+	reconnectCounts     map[int]int
+	roleMap             map[string]string
+	tablespaceMap       map[string]string
+	retriedStatements   int32
+	tablesRestored      int
+	restoredTables      []report.TableStats
+	restoredTableOids   []uint32
+	logCounter          *report.LogCounter
+	runStats            *history.RunStats
 	/*
 	 * Used for synchronizing DoCleanup.  In DoInit() we increment the group
 	 * and then wait for at least one DoCleanup to finish, either in DoTeardown
@@ -52,6 +70,7 @@ func init() {
 	// Initialize global variables
 	errorTablesMetadata = make(map[string]Empty)
 	errorTablesData = make(map[string]Empty)
+	reconnectCounts = make(map[int]int)
 }
 
 /*
@@ -92,6 +111,30 @@ func SetTOC(toc *toc.TOC) {
 	globalTOC = toc
 }
 
+func SetSetupQuery(query string) {
+	setupQuery = query
+}
+
+func SetRoleMap(newRoleMap map[string]string) {
+	roleMap = newRoleMap
+}
+
+func SetReconnectCounts(counts map[int]int) {
+	reconnectCounts = counts
+}
+
+func SetConstraintTimings(timings []report.ConstraintTiming) {
+	constraintTimings = timings
+}
+
+func GetConstraintTimings() []report.ConstraintTiming {
+	return constraintTimings
+}
+
+func SetEncodingConversion(conversion *report.EncodingConversion) {
+	encodingConversion = conversion
+}
+
 // Util functions to enable ease of access to global flag values
 
 func MustGetFlagString(flagName string) string {
@@ -0,0 +1,242 @@
+package restore
+
+/*
+ * This file contains functions for dropping the indexes on restored tables
+ * before a data-only restore loads them with --rebuild-indexes, and
+ * re-creating them once the data is loaded.
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+ * An index backing a constraint is dropped and re-created through its
+ * constraint, so ConstraintName and ConstraintDef are set only for those.
+ */
+type RebuildIndex struct {
+	Schema         string
+	Table          string
+	Name           string
+	Definition     string
+	Tablespace     string
+	ConstraintName string
+	ConstraintDef  string
+}
+
+func (index RebuildIndex) FQN() string {
+	return utils.MakeFQN(index.Schema, index.Name)
+}
+
+func (index RebuildIndex) TableFQN() string {
+	return utils.MakeFQN(index.Schema, index.Table)
+}
+
+func (index RebuildIndex) DropStatement() string {
+	if index.ConstraintName != "" {
+		return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s;", index.TableFQN(), index.ConstraintName)
+	}
+	return fmt.Sprintf("DROP INDEX %s;", index.FQN())
+}
+
+func (index RebuildIndex) CreateStatement() string {
+	if index.ConstraintName != "" {
+		tablespaceClause := ""
+		if index.Tablespace != "" {
+			tablespaceClause = fmt.Sprintf(" USING INDEX TABLESPACE %s", index.Tablespace)
+		}
+		return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s%s;", index.TableFQN(), index.ConstraintName, index.ConstraintDef, tablespaceClause)
+	}
+	if index.Tablespace != "" {
+		return fmt.Sprintf("%s;\nALTER INDEX %s SET TABLESPACE %s;", index.Definition, index.FQN(), index.Tablespace)
+	}
+	return fmt.Sprintf("%s;", index.Definition)
+}
+
+/*
+ * The journal is named only by the backup timestamp so that a later restore
+ * of the same backup can find it if gprestore exits before the indexes it
+ * dropped are re-created.
+ */
+type IndexJournal struct {
+	Database string
+	Indexes  []RebuildIndex
+}
+
+func GetIndexesForRebuild(connectionPool *dbconn.DBConn, tableFQNs []string, includeConstraintIndexes bool) []RebuildIndex {
+	results := make([]RebuildIndex, 0)
+	if len(tableFQNs) == 0 {
+		return results
+	}
+	constraintFilter := ""
+	if !includeConstraintIndexes {
+		constraintFilter = "\n\t\tAND con.oid IS NULL"
+	}
+	query := fmt.Sprintf(`
+	SELECT quote_ident(n.nspname) AS schema,
+		quote_ident(t.relname) AS table,
+		quote_ident(ic.relname) AS name,
+		pg_get_indexdef(i.indexrelid) AS definition,
+		coalesce(quote_ident(ts.spcname), '') AS tablespace,
+		coalesce(quote_ident(con.conname), '') AS constraintname,
+		coalesce(pg_get_constraintdef(con.oid), '') AS constraintdef
+	FROM pg_index i
+		JOIN pg_class ic ON ic.oid = i.indexrelid
+		JOIN pg_class t ON t.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		LEFT JOIN pg_tablespace ts ON ts.oid = ic.reltablespace
+		LEFT JOIN pg_depend d ON d.objid = i.indexrelid
+			AND d.classid = 'pg_class'::regclass
+			AND d.refclassid = 'pg_constraint'::regclass
+			AND d.deptype = 'i'
+		LEFT JOIN pg_constraint con ON con.oid = d.refobjid
+	WHERE quote_ident(n.nspname) || '.' || quote_ident(t.relname) IN (%s)%s
+	ORDER BY n.nspname, t.relname, ic.relname`, utils.SliceToQuotedString(tableFQNs), constraintFilter)
+
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	return results
+}
+
+func getRestoredTableFQNs(filteredDataEntries map[string][]toc.MasterDataEntry) []string {
+	tableSet := make(map[string]Empty)
+	tableFQNs := make([]string, 0)
+	for _, entries := range filteredDataEntries {
+		for _, entry := range entries {
+			tableName := utils.MakeFQN(entry.Schema, entry.Name)
+			if opts.RedirectSchema != "" {
+				tableName = utils.MakeFQN(opts.RedirectSchema, entry.Name)
+			}
+			if _, ok := tableSet[tableName]; !ok {
+				tableSet[tableName] = Empty{}
+				tableFQNs = append(tableFQNs, tableName)
+			}
+		}
+	}
+	return tableFQNs
+}
+
+func writeIndexJournal(indexes []RebuildIndex) {
+	journal := IndexJournal{Database: connectionPool.DBName, Indexes: indexes}
+	contents, err := yaml.Marshal(journal)
+	gplog.FatalOnError(err)
+	err = utils.WriteToFileAndMakeReadOnly(globalFPInfo.GetIndexJournalFilePath(), contents)
+	gplog.FatalOnError(err)
+}
+
+/*
+ * Every index is recorded in the journal before any of them is dropped.  An
+ * index that cannot be dropped, for example a constraint index referenced by
+ * a foreign key, is left in place for the data load.
+ */
+func DropIndexesForRebuild(tableFQNs []string) {
+	indexes := GetIndexesForRebuild(connectionPool, tableFQNs, MustGetFlagBool(options.CONSTRAINT_INDEXES))
+	if len(indexes) == 0 {
+		return
+	}
+	writeIndexJournal(indexes)
+	for _, index := range indexes {
+		start := time.Now()
+		_, err := connectionPool.Exec(index.DropStatement())
+		if err != nil {
+			report.Warn(report.WARN_INDEX_NOT_DROPPED, index.FQN(), "Could not drop index %s on table %s; it will be kept during the data load: %v", index.FQN(), index.TableFQN(), err)
+			continue
+		}
+		droppedIndexes = append(droppedIndexes, index)
+		indexRebuilds = append(indexRebuilds, report.IndexRebuild{Index: index.FQN(), Table: index.TableFQN(), DropDuration: time.Since(start)})
+	}
+	gplog.Info("Dropped %d index(es) to re-create after the data load", len(droppedIndexes))
+}
+
+func RecreateIndexes() {
+	gplog.Info("Re-creating %d index(es) dropped for the data load", len(droppedIndexes))
+	tasks := make(chan int, len(droppedIndexes))
+	for i := range droppedIndexes {
+		tasks <- i
+	}
+	close(tasks)
+
+	numErrors := 0
+	var workerPool sync.WaitGroup
+	for i := 0; i < connectionPool.NumConns; i++ {
+		workerPool.Add(1)
+		go func(whichConn int) {
+			defer workerPool.Done()
+			for i := range tasks {
+				start := time.Now()
+				_, err := connectionPool.Exec(droppedIndexes[i].CreateStatement(), whichConn)
+				mutex.Lock()
+				indexRebuilds[i].CreateDuration = time.Since(start)
+				if err != nil {
+					gplog.Error("Could not re-create index %s on table %s: %v", droppedIndexes[i].FQN(), droppedIndexes[i].TableFQN(), err)
+					indexRebuilds[i].Error = err.Error()
+					numErrors++
+				} else {
+					indexRebuilds[i].Recreated = true
+				}
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	workerPool.Wait()
+	droppedIndexes = nil
+
+	if numErrors > 0 {
+		gplog.Error("Could not re-create %d index(es). Their definitions are saved in %s.", numErrors, globalFPInfo.GetIndexJournalFilePath())
+		return
+	}
+	_ = os.Remove(globalFPInfo.GetIndexJournalFilePath())
+	gplog.Info("Index re-creation complete")
+}
+
+func isDuplicateObjectError(err error) bool {
+	pgErr, ok := errors.Cause(err).(*pgconn.PgError)
+	return ok && (pgErr.Code == "42P07" || pgErr.Code == "42710")
+}
+
+/*
+ * Re-creates the indexes recorded by an earlier --rebuild-indexes restore of
+ * this backup that exited before re-creating them.  Indexes that were never
+ * dropped or were already re-created are skipped.
+ */
+func RecoverIndexesFromJournal() {
+	journalFilename := globalFPInfo.GetIndexJournalFilePath()
+	if !iohelper.FileExistsAndIsReadable(journalFilename) {
+		return
+	}
+	contents, err := ioutil.ReadFile(journalFilename)
+	gplog.FatalOnError(err)
+	journal := IndexJournal{}
+	err = yaml.Unmarshal(contents, &journal)
+	gplog.FatalOnError(err)
+	if journal.Database != connectionPool.DBName {
+		gplog.Fatal(errors.Errorf("Index journal %s from an earlier restore of this backup is for database %s. Re-create its indexes in that database and remove the journal before using --%s.",
+			journalFilename, journal.Database, options.REBUILD_INDEXES), "")
+	}
+
+	gplog.Warn("Re-creating indexes missing after an earlier restore of this backup, from index journal %s", journalFilename)
+	for _, index := range journal.Indexes {
+		_, err = connectionPool.Exec(index.CreateStatement())
+		if err != nil && !isDuplicateObjectError(err) {
+			gplog.Fatal(errors.Errorf("Could not re-create index %s on table %s from index journal %s: %v",
+				index.FQN(), index.TableFQN(), journalFilename, err), "")
+		}
+	}
+	err = os.Remove(journalFilename)
+	gplog.FatalOnError(err)
+}
@@ -0,0 +1,48 @@
+package restore_test
+
+import (
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gpbackup/restore"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/indexes tests", func() {
+	Describe("RebuildIndex", func() {
+		index := restore.RebuildIndex{Schema: "public", Table: "foo", Name: "foo_idx", Definition: "CREATE INDEX foo_idx ON public.foo USING btree (i)"}
+		constraintIndex := restore.RebuildIndex{Schema: "public", Table: "foo", Name: "foo_pkey", Definition: "CREATE UNIQUE INDEX foo_pkey ON public.foo USING btree (i)",
+			ConstraintName: "foo_pkey", ConstraintDef: "PRIMARY KEY (i)"}
+		It("drops and re-creates an index", func() {
+			Expect(index.DropStatement()).To(Equal("DROP INDEX public.foo_idx;"))
+			Expect(index.CreateStatement()).To(Equal("CREATE INDEX foo_idx ON public.foo USING btree (i);"))
+		})
+		It("re-creates an index in its tablespace", func() {
+			index.Tablespace = "test_tablespace"
+			Expect(index.CreateStatement()).To(Equal("CREATE INDEX foo_idx ON public.foo USING btree (i);\nALTER INDEX public.foo_idx SET TABLESPACE test_tablespace;"))
+		})
+		It("drops and re-creates the index of a constraint through the constraint", func() {
+			Expect(constraintIndex.DropStatement()).To(Equal("ALTER TABLE public.foo DROP CONSTRAINT foo_pkey;"))
+			Expect(constraintIndex.CreateStatement()).To(Equal("ALTER TABLE public.foo ADD CONSTRAINT foo_pkey PRIMARY KEY (i);"))
+			constraintIndex.Tablespace = "test_tablespace"
+			Expect(constraintIndex.CreateStatement()).To(Equal("ALTER TABLE public.foo ADD CONSTRAINT foo_pkey PRIMARY KEY (i) USING INDEX TABLESPACE test_tablespace;"))
+		})
+	})
+	Describe("GetIndexesForRebuild", func() {
+		header := []string{"schema", "table", "name", "definition", "tablespace", "constraintname", "constraintdef"}
+		It("excludes indexes backing constraints by default", func() {
+			rows := sqlmock.NewRows(header).AddRow("public", "foo", "foo_idx", "CREATE INDEX foo_idx ON public.foo USING btree (i)", "", "", "")
+			mock.ExpectQuery(regexp.QuoteMeta(`IN ('public.foo','public.bar')
+		AND con.oid IS NULL`)).WillReturnRows(rows)
+			indexes := restore.GetIndexesForRebuild(connectionPool, []string{"public.foo", "public.bar"}, false)
+			Expect(indexes).To(Equal([]restore.RebuildIndex{{Schema: "public", Table: "foo", Name: "foo_idx", Definition: "CREATE INDEX foo_idx ON public.foo USING btree (i)"}}))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not query the database when there are no tables", func() {
+			Expect(restore.GetIndexesForRebuild(connectionPool, []string{}, true)).To(BeEmpty())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+	})
+})
@@ -6,26 +6,113 @@ package restore
 
 import (
 	"fmt"
+	"math/rand"
+	"regexp"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
 )
 
 var (
 	mutex = &sync.Mutex{}
+
+	// Matches a GRANT written with --preserve-grantor, capturing the grantor and the plain GRANT
+	grantorRegex = regexp.MustCompile(`SET (?:SESSION AUTHORIZATION|ROLE) (.+?); (GRANT .*?) RESET (?:SESSION AUTHORIZATION|ROLE);`)
+
+	missingRoleRegex = regexp.MustCompile(`^role "(.*)" does not exist$`)
+
+	// Matches statements that leave the database in the same state whether they are run once or twice
+	idempotentStatementRegex = regexp.MustCompile(`^\s*(?:GRANT|REVOKE|COMMENT ON|SECURITY LABEL|CREATE OR REPLACE|ALTER [^;]* OWNER TO|SET (?:SESSION AUTHORIZATION|ROLE) [^;]+; (?:GRANT|REVOKE)) `)
 )
 
+/*
+ * Deadlocks and serialization failures, most often between a GRANT and an
+ * ALTER ... OWNER on the same object running on different connections, succeed
+ * if the statement is simply run again.
+ */
+func isRetryableStatementError(err error) bool {
+	pgErr, ok := errors.Cause(err).(*pgconn.PgError)
+	return ok && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+func retryStatement(statement toc.StatementWithType, err error, whichConn int) error {
+	maxRetries := MustGetFlagInt(options.MAX_STATEMENT_RETRIES)
+	for attempt := 1; attempt <= maxRetries && isRetryableStatementError(err); attempt++ {
+		if attempt == 1 {
+			atomic.AddInt32(&retriedStatements, 1)
+		}
+		report.Warn(report.WARN_STATEMENT_RETRIED, utils.MakeFQN(statement.Schema, statement.Name), "Statement for %s %s.%s failed (%s); retrying (attempt %d of %d)", statement.ObjectType, statement.Schema, statement.Name, err.Error(), attempt, maxRetries)
+		// Jitter keeps the conflicting connections from retrying in lockstep
+		time.Sleep(time.Duration(attempt*100+rand.Intn(100)) * time.Millisecond)
+		_, err = connectionPool.Exec(statement.Statement, whichConn)
+	}
+	return err
+}
+
+/*
+ * A grant backed up with --preserve-grantor fails as a whole if its grantor
+ * does not exist in the restore database, in which case it is retried as a
+ * plain GRANT run by the restoring user.  A missing grantee fails with the
+ * same error code, so the missing role must be one of the grantors.
+ */
+func isMissingGrantorError(statement string, err error) bool {
+	pgErr, ok := errors.Cause(err).(*pgconn.PgError)
+	if !ok || pgErr.Code != "42704" {
+		return false
+	}
+	matches := missingRoleRegex.FindStringSubmatch(pgErr.Message)
+	if matches == nil {
+		return false
+	}
+	for _, grant := range grantorRegex.FindAllStringSubmatch(statement, -1) {
+		if utils.UnquoteIdent(grant[1]) == matches[1] {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * A statement whose connection is lost may or may not have been committed, so
+ * after reconnecting only a statement that can safely be run a second time is
+ * retried.  Any other statement, such as a CREATE that may already have
+ * succeeded, is reported as failed instead.
+ */
+func retryAfterReconnect(statement toc.StatementWithType, err error, whichConn int) error {
+	if !idempotentStatementRegex.MatchString(statement.Statement) {
+		return errors.Wrapf(err, "Connection lost while restoring %s %s, which is not safe to run again", statement.ObjectType, utils.MakeFQN(statement.Schema, statement.Name))
+	}
+	_, err = connectionPool.Exec(statement.Statement, whichConn)
+	return err
+}
+
 func executeStatementsForConn(statements chan toc.StatementWithType, fatalErr *error, numErrors *int32, progressBar utils.ProgressBar, whichConn int, executeInParallel bool) {
 	for statement := range statements {
 		if wasTerminated || *fatalErr != nil {
 			return
 		}
+		statement.Statement = toc.RemapRolesInStatement(statement.Statement, roleMap)
 		_, err := connectionPool.Exec(statement.Statement, whichConn)
+		if IsConnectionLost(err) && ReconnectConnection(whichConn, err) {
+			err = retryAfterReconnect(statement, err, whichConn)
+		}
+		if isRetryableStatementError(err) {
+			err = retryStatement(statement, err, whichConn)
+		}
+		if isMissingGrantorError(statement.Statement, err) {
+			report.Warn(report.WARN_GRANTOR_FALLBACK, statement.Name, "Could not restore privileges on %s as their original grantor: %s. Granting them as the current user instead.", statement.Name, err.Error())
+			_, err = connectionPool.Exec(grantorRegex.ReplaceAllString(statement.Statement, "$2"), whichConn)
+		}
 		if err != nil {
 			gplog.Verbose("Error encountered when executing statement: %s Error was: %s", strings.TrimSpace(statement.Statement), err.Error())
 			if MustGetFlagBool(options.ON_ERROR_CONTINUE) {
@@ -51,15 +138,67 @@ func executeStatementsForConn(statements chan toc.StatementWithType, fatalErr *e
  * to N statements in parallel.
  */
 func ExecuteStatements(statements []toc.StatementWithType, progressBar utils.ProgressBar, executeInParallel bool, whichConn ...int) {
-	var workerPool sync.WaitGroup
-	var fatalErr error
-	var numErrors int32
 	tasks := make(chan toc.StatementWithType, len(statements))
 	for _, statement := range statements {
 		tasks <- statement
 	}
 	close(tasks)
 
+	numErrors, fatalErr := executeStatementsFromChannel(tasks, progressBar, executeInParallel, whichConn...)
+	reportStatementErrors(fatalErr, numErrors)
+}
+
+/*
+ * Unlike ExecuteStatements, this reads each statement from the metadata file
+ * only as a worker becomes free to run it, so a section with hundreds of
+ * thousands of statements is never held in memory all at once.  If it is not
+ * nil, editStatement is applied to each statement as it is read, and an error
+ * it returns stops the restore as a read error does.
+ */
+func ExecuteStatementsFromFile(entries []toc.MetadataEntry, filename string, editStatement func(*toc.StatementWithType) error, progressBar utils.ProgressBar, executeInParallel bool, whichConn ...int) {
+	metadataFile := iohelper.MustOpenFileForReading(filename)
+	defer metadataFile.Close()
+
+	tasks := make(chan toc.StatementWithType, connectionPool.NumConns)
+	stopReading := make(chan struct{})
+	readerDone := make(chan struct{})
+	var readErr error
+	go func() {
+		defer close(readerDone)
+		defer close(tasks)
+		for _, entry := range entries {
+			statement, err := toc.ReadStatement(metadataFile, entry)
+			if err != nil {
+				readErr = err
+				return
+			}
+			if editStatement != nil {
+				if err := editStatement(&statement); err != nil {
+					readErr = err
+					return
+				}
+			}
+			select {
+			case tasks <- statement:
+			case <-stopReading:
+				return
+			}
+		}
+	}()
+
+	numErrors, fatalErr := executeStatementsFromChannel(tasks, progressBar, executeInParallel, whichConn...)
+	close(stopReading)
+	<-readerDone
+	if fatalErr == nil {
+		fatalErr = readErr
+	}
+	reportStatementErrors(fatalErr, numErrors)
+}
+
+func executeStatementsFromChannel(tasks chan toc.StatementWithType, progressBar utils.ProgressBar, executeInParallel bool, whichConn ...int) (int32, error) {
+	var workerPool sync.WaitGroup
+	var fatalErr error
+	var numErrors int32
 	if !executeInParallel {
 		connNum := connectionPool.ValidateConnNum(whichConn...)
 		executeStatementsForConn(tasks, &fatalErr, &numErrors, progressBar, connNum, executeInParallel)
@@ -74,6 +213,10 @@ func ExecuteStatements(statements []toc.StatementWithType, progressBar utils.Pro
 		}
 		workerPool.Wait()
 	}
+	return numErrors, fatalErr
+}
+
+func reportStatementErrors(fatalErr error, numErrors int32) {
 	if fatalErr != nil {
 		fmt.Println("")
 		gplog.Fatal(fatalErr, "")
@@ -100,19 +243,26 @@ func ExecuteStatementsAndCreateProgressBar(statements []toc.StatementWithType, o
  *   restores them in parallel (which has no possibility of deadlock) and
  *   then the second restores all other postdata objects in parallel. After
  *   each table has at least one index, there is no more risk of deadlock.
+ *
+ *   Clustering a table or setting its replica identity requires the index
+ *   it uses to exist, so the CLUSTER and REPLICA IDENTITY statements are
+ *   restored in a third batch.
  */
-func BatchPostdataStatements(statements []toc.StatementWithType) ([]toc.StatementWithType, []toc.StatementWithType) {
+func BatchPostdataStatements(statements []toc.StatementWithType) ([]toc.StatementWithType, []toc.StatementWithType, []toc.StatementWithType) {
 	indexMap := make(map[string]bool)
 	firstBatch := make([]toc.StatementWithType, 0)
 	secondBatch := make([]toc.StatementWithType, 0)
+	clusterBatch := make([]toc.StatementWithType, 0)
 	for _, statement := range statements {
 		_, tableIndexPresent := indexMap[statement.ReferenceObject]
 		if statement.ObjectType == "INDEX" && !tableIndexPresent {
 			indexMap[statement.ReferenceObject] = true
 			firstBatch = append(firstBatch, statement)
+		} else if statement.ObjectType == "CLUSTER" || statement.ObjectType == "REPLICA IDENTITY" {
+			clusterBatch = append(clusterBatch, statement)
 		} else {
 			secondBatch = append(secondBatch, statement)
 		}
 	}
-	return firstBatch, secondBatch
+	return firstBatch, secondBatch, clusterBatch
 }
@@ -1,9 +1,22 @@
 package restore_test
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/restore"
 	"github.com/greenplum-db/gpbackup/toc"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -16,22 +29,189 @@ var _ = Describe("restore/parallel tests", func() {
 		trigger := toc.StatementWithType{ObjectType: "TRIGGER", ReferenceObject: "public.table3", Statement: `CREATE INDEX testindex ON public.testtable USING btree(i);`}
 		It("places all indexes in first batch when all are on different tables", func() {
 			statements := []toc.StatementWithType{index1, index2, index3}
-			firstBatch, secondBatch := restore.BatchPostdataStatements(statements)
+			firstBatch, secondBatch, _ := restore.BatchPostdataStatements(statements)
 			Expect(firstBatch).To(Equal([]toc.StatementWithType{index1, index2, index3}))
 			Expect(secondBatch).To(Equal([]toc.StatementWithType{}))
 		})
 		It("places first index for a table in first batch, and other indexes for that table in second", func() {
 			statements := []toc.StatementWithType{index1, index2, index2, index2, index3, index3}
-			firstBatch, secondBatch := restore.BatchPostdataStatements(statements)
+			firstBatch, secondBatch, _ := restore.BatchPostdataStatements(statements)
 			Expect(firstBatch).To(Equal([]toc.StatementWithType{index1, index2, index3}))
 			Expect(secondBatch).To(Equal([]toc.StatementWithType{index2, index2, index3}))
 		})
 		It("places non-index objects in second batch", func() {
 			statements := []toc.StatementWithType{index1, index1, trigger}
-			firstBatch, secondBatch := restore.BatchPostdataStatements(statements)
+			firstBatch, secondBatch, _ := restore.BatchPostdataStatements(statements)
+			Expect(firstBatch).To(Equal([]toc.StatementWithType{index1}))
+			Expect(secondBatch).To(Equal([]toc.StatementWithType{index1, trigger}))
+		})
+		It("places clustering in a third batch after all indexes", func() {
+			cluster := toc.StatementWithType{ObjectType: "CLUSTER", ReferenceObject: "public.table1", Statement: `ALTER TABLE public.table1 CLUSTER ON testindex;`}
+			statements := []toc.StatementWithType{index1, cluster, index1, trigger}
+			firstBatch, secondBatch, clusterBatch := restore.BatchPostdataStatements(statements)
 			Expect(firstBatch).To(Equal([]toc.StatementWithType{index1}))
 			Expect(secondBatch).To(Equal([]toc.StatementWithType{index1, trigger}))
+			Expect(clusterBatch).To(Equal([]toc.StatementWithType{cluster}))
+		})
+		It("places replica identities in the third batch after all indexes", func() {
+			replicaIdentity := toc.StatementWithType{ObjectType: "REPLICA IDENTITY", ReferenceObject: "public.table1", Statement: `ALTER TABLE public.table1 REPLICA IDENTITY USING INDEX testindex;`}
+			statements := []toc.StatementWithType{index1, replicaIdentity, trigger}
+			firstBatch, secondBatch, clusterBatch := restore.BatchPostdataStatements(statements)
+			Expect(firstBatch).To(Equal([]toc.StatementWithType{index1}))
+			Expect(secondBatch).To(Equal([]toc.StatementWithType{trigger}))
+			Expect(clusterBatch).To(Equal([]toc.StatementWithType{replicaIdentity}))
+		})
+
+	})
+	Describe("ExecuteStatements", func() {
+		BeforeEach(func() {
+			restore.SetSetupQuery("SET application_name TO 'gprestore';")
+			restore.SetReconnectCounts(make(map[int]int))
+		})
+		It("reconnects and retries a statement that is safe to run again when the connection is lost", func() {
+			statements := []toc.StatementWithType{{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "\n\nGRANT SELECT ON TABLE public.foo TO testrole;"}}
+			mock.ExpectExec("GRANT SELECT ON TABLE public.foo").WillReturnError(&pgconn.PgError{Code: "08006", Message: "connection failure"})
+			mock.ExpectExec("SET application_name TO 'gprestore';").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("GRANT SELECT ON TABLE public.foo").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.ExecuteStatements(statements, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("reconnects but reports a statement that is not safe to run again when the connection is lost", func() {
+			_ = cmdFlags.Set(options.ON_ERROR_CONTINUE, "true")
+			statements := []toc.StatementWithType{
+				{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "CREATE TABLE public.foo(i int);"},
+				{Schema: "public", Name: "bar", ObjectType: "TABLE", Statement: "CREATE TABLE public.bar(i int);"},
+			}
+			mock.ExpectExec("CREATE TABLE public.foo").WillReturnError(&pgconn.PgError{Code: "08006", Message: "connection failure"})
+			mock.ExpectExec("SET application_name TO 'gprestore';").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("CREATE TABLE public.bar").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.ExecuteStatements(statements, utils.NewProgressBar(2, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(string(logfile.Contents())).To(ContainSubstring("Connection lost while restoring TABLE public.foo, which is not safe to run again"))
+		})
+		It("retries a statement that fails with a deadlock", func() {
+			statements := []toc.StatementWithType{{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "ALTER TABLE public.foo OWNER TO testrole;"}}
+			mock.ExpectExec("ALTER TABLE public.foo").WillReturnError(&pgconn.PgError{Code: "40P01", Message: "deadlock detected"})
+			mock.ExpectExec("ALTER TABLE public.foo").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.ExecuteStatements(statements, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(string(logfile.Contents())).To(ContainSubstring("Statement for TABLE public.foo failed"))
+			Expect(string(logfile.Contents())).To(ContainSubstring("retrying (attempt 1 of 3)"))
+		})
+		It("does not retry a deadlocked statement when --max-statement-retries is 0", func() {
+			_ = cmdFlags.Set(options.MAX_STATEMENT_RETRIES, "0")
+			_ = cmdFlags.Set(options.ON_ERROR_CONTINUE, "true")
+			statements := []toc.StatementWithType{{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "ALTER TABLE public.foo OWNER TO testrole;"}}
+			mock.ExpectExec("ALTER TABLE public.foo").WillReturnError(&pgconn.PgError{Code: "40001", Message: "could not serialize access"})
+
+			restore.ExecuteStatements(statements, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(string(logfile.Contents())).ToNot(ContainSubstring("retrying"))
+		})
+		It("falls back to a plain GRANT when the original grantor does not exist", func() {
+			statements := []toc.StatementWithType{{Schema: "public", Name: "foo", Statement: "SET SESSION AUTHORIZATION grantorrole; GRANT SELECT ON TABLE public.foo TO testrole; RESET SESSION AUTHORIZATION;"}}
+			mock.ExpectExec("SET SESSION AUTHORIZATION grantorrole").WillReturnError(&pgconn.PgError{Code: "42704", Message: `role "grantorrole" does not exist`})
+			mock.ExpectExec(`^GRANT SELECT ON TABLE public.foo TO testrole;$`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.ExecuteStatements(statements, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(string(logfile.Contents())).To(ContainSubstring("Could not restore privileges on foo as their original grantor"))
+		})
+		It("falls back to a plain GRANT when a quoted grantor does not exist", func() {
+			statements := []toc.StatementWithType{{Schema: "public", Name: "foo", Statement: `SET SESSION AUTHORIZATION "Grantor Role"; GRANT SELECT ON TABLE public.foo TO testrole; RESET SESSION AUTHORIZATION;`}}
+			mock.ExpectExec("SET SESSION AUTHORIZATION").WillReturnError(&pgconn.PgError{Code: "42704", Message: `role "Grantor Role" does not exist`})
+			mock.ExpectExec(`^GRANT SELECT ON TABLE public.foo TO testrole;$`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.ExecuteStatements(statements, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("falls back to a plain GRANT written with SET ROLE by an older gpbackup", func() {
+			statements := []toc.StatementWithType{{Schema: "public", Name: "foo", Statement: "SET ROLE grantorrole; GRANT SELECT ON TABLE public.foo TO testrole; RESET ROLE;"}}
+			mock.ExpectExec("SET ROLE grantorrole").WillReturnError(&pgconn.PgError{Code: "42704", Message: `role "grantorrole" does not exist`})
+			mock.ExpectExec(`^GRANT SELECT ON TABLE public.foo TO testrole;$`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.ExecuteStatements(statements, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
 		})
+		It("does not fall back to a plain GRANT when the grantee does not exist", func() {
+			_ = cmdFlags.Set(options.ON_ERROR_CONTINUE, "true")
+			statements := []toc.StatementWithType{{Schema: "public", Name: "foo", Statement: "SET SESSION AUTHORIZATION grantorrole; GRANT SELECT ON TABLE public.foo TO testrole; RESET SESSION AUTHORIZATION;"}}
+			mock.ExpectExec("SET SESSION AUTHORIZATION grantorrole").WillReturnError(&pgconn.PgError{Code: "42704", Message: `role "testrole" does not exist`})
+
+			restore.ExecuteStatements(statements, utils.NewProgressBar(1, "", utils.PB_NONE), false)
 
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(string(logfile.Contents())).ToNot(ContainSubstring("original grantor"))
+		})
+	})
+	Describe("ExecuteStatementsFromFile", func() {
+		var metadataFilename string
+		BeforeEach(func() {
+			tempDir, err := ioutil.TempDir("", "restore_metadata")
+			Expect(err).ToNot(HaveOccurred())
+			metadataFilename = filepath.Join(tempDir, "metadata.sql")
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(filepath.Dir(metadataFilename))
+		})
+		It("executes many thousands of statements read one at a time from the metadata file", func() {
+			numStatements := 5000
+			var contents strings.Builder
+			entries := make([]toc.MetadataEntry, numStatements)
+			for i := 0; i < numStatements; i++ {
+				start := uint64(contents.Len())
+				contents.WriteString(fmt.Sprintf("\n\nGRANT ALL ON SCHEMA schema1 TO role%d;\n", i))
+				entries[i] = toc.MetadataEntry{Schema: "", Name: "schema1", ObjectType: "SCHEMA", StartByte: start, EndByte: uint64(contents.Len())}
+				mock.ExpectExec(fmt.Sprintf("GRANT ALL ON SCHEMA schema1 TO role%d;", i)).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			Expect(ioutil.WriteFile(metadataFilename, []byte(contents.String()), 0644)).To(Succeed())
+
+			restore.ExecuteStatementsFromFile(entries, metadataFilename, nil, utils.NewProgressBar(numStatements, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("applies the edit function to each statement before executing it", func() {
+			statement := "\n\nCREATE TABLE public.foo(i int);\n"
+			Expect(ioutil.WriteFile(metadataFilename, []byte(statement), 0644)).To(Succeed())
+			entries := []toc.MetadataEntry{{Schema: "public", Name: "foo", ObjectType: "TABLE", StartByte: 0, EndByte: uint64(len(statement))}}
+			mock.ExpectExec("CREATE TABLE other.foo").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.ExecuteStatementsFromFile(entries, metadataFilename, func(statement *toc.StatementWithType) error {
+				statement.Statement = strings.Replace(statement.Statement, "public.", "other.", 1)
+				return nil
+			}, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("panics with the error returned by the edit function without executing the statement", func() {
+			statement := "\n\nCREATE TABLE public.foo(i int);\n"
+			Expect(ioutil.WriteFile(metadataFilename, []byte(statement), 0644)).To(Succeed())
+			entries := []toc.MetadataEntry{{Schema: "public", Name: "foo", ObjectType: "TABLE", StartByte: 0, EndByte: uint64(len(statement))}}
+			defer func() {
+				Expect(mock.ExpectationsWereMet()).To(Succeed())
+			}()
+			defer testhelper.ShouldPanicWithMessage("Could not edit public.foo")
+
+			restore.ExecuteStatementsFromFile(entries, metadataFilename, func(statement *toc.StatementWithType) error {
+				return errors.New("Could not edit public.foo")
+			}, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+		})
+		It("panics with the object name and type if a statement exceeds the maximum statement size", func() {
+			Expect(ioutil.WriteFile(metadataFilename, []byte(""), 0644)).To(Succeed())
+			entries := []toc.MetadataEntry{{Schema: "public", Name: "foo", ObjectType: "TABLE", StartByte: 0, EndByte: toc.MAX_STATEMENT_SIZE + 1}}
+			defer testhelper.ShouldPanicWithMessage(fmt.Sprintf("Statement for TABLE public.foo is %d bytes, which exceeds the maximum statement size of %d bytes", toc.MAX_STATEMENT_SIZE+1, toc.MAX_STATEMENT_SIZE))
+
+			restore.ExecuteStatementsFromFile(entries, metadataFilename, nil, utils.NewProgressBar(1, "", utils.PB_NONE), false)
+		})
 	})
 })
@@ -0,0 +1,217 @@
+package restore
+
+/*
+ * This file contains functions for describing the table data a restore would
+ * load, without loading any of it.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	DATA_PLAN_TEXT = "text"
+	DATA_PLAN_JSON = "json"
+)
+
+/*
+ * Bytes is -1 when the size of the file was not checked, as for files stored
+ * with a plugin.
+ */
+type DataPlanFile struct {
+	ContentID int    `json:"content"`
+	Host      string `json:"host"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	Missing   bool   `json:"missing,omitempty"`
+}
+
+type DataPlanTable struct {
+	Table     string         `json:"table"`
+	Target    string         `json:"target"`
+	Timestamp string         `json:"timestamp"`
+	Rows      int64          `json:"rows"`
+	Files     []DataPlanFile `json:"files"`
+}
+
+/*
+ * With a single data file per segment, every table is read from the same file
+ * on each segment, and the size given for it is that of the whole file.
+ */
+type DataPlan struct {
+	Timestamp      string          `json:"timestamp"`
+	Database       string          `json:"database"`
+	SingleDataFile bool            `json:"singleDataFile"`
+	FilesChecked   bool            `json:"filesChecked"`
+	Tables         []DataPlanTable `json:"tables"`
+	NumFiles       int             `json:"numFiles"`
+	MissingFiles   int             `json:"missingFiles"`
+}
+
+/*
+ * Lists the size of every file in the backup directory of each segment, and
+ * in the shared directory of content-addressed data files if the backup has
+ * any.  A directory that does not exist lists no files, so that the files in
+ * it are reported missing rather than failing the listing.
+ */
+func listBackupFilesOnSegments(fpInfo filepath.FilePathInfo, contentAddressed bool) map[int]backupFileList {
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Listing backup files", cluster.ON_SEGMENTS, func(contentID int) string {
+		dirs := fpInfo.GetDirForContent(contentID)
+		if contentAddressed {
+			dirs += " " + fpInfo.GetContentAddressedDir(contentID)
+		}
+		return fmt.Sprintf(`find %s -type f -printf 'file %%s %%p\n' 2>/dev/null; true`, dirs)
+	})
+	globalCluster.CheckClusterError(remoteOutput, "Could not list backup files", func(contentID int) string {
+		return "Could not list backup files"
+	})
+	files := make(map[int]backupFileList)
+	for _, command := range remoteOutput.Commands {
+		files[command.Content] = parseBackupFileVerificationOutput(command.Stdout)
+	}
+	return files
+}
+
+func dataPlanFilePath(fpInfo filepath.FilePathInfo, entry toc.MasterDataEntry, contentID int) string {
+	if backupConfig.SingleDataFile {
+		return fpInfo.GetTableBackupFilePath(contentID, 0, utils.GetPipeThroughProgram().Extension, true)
+	} else if len(entry.ContentHashes) > 0 {
+		return path.Join(fpInfo.GetContentAddressedDir(contentID), entry.ContentHashes[contentID])
+	}
+	return fpInfo.GetTableBackupFilePath(contentID, entry.Oid, utils.GetPipeThroughProgram().Extension, false)
+}
+
+/*
+ * Describes the data file each segment would read for each table in
+ * entriesByTimestamp, in order of timestamp.  If checkFiles is set, the
+ * backup files on the segments are listed to find the size of each file and
+ * whether it is missing.
+ */
+func NewDataPlan(entriesByTimestamp map[string][]toc.MasterDataEntry, redirectSchema string, checkFiles bool) DataPlan {
+	plan := DataPlan{SingleDataFile: backupConfig.SingleDataFile, FilesChecked: checkFiles, Tables: make([]DataPlanTable, 0)}
+	timestamps := make([]string, 0, len(entriesByTimestamp))
+	for timestamp := range entriesByTimestamp {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Strings(timestamps)
+	contentIDs := make([]int, 0)
+	for _, contentID := range globalCluster.ContentIDs {
+		if contentID >= 0 {
+			contentIDs = append(contentIDs, contentID)
+		}
+	}
+	sort.Ints(contentIDs)
+
+	for _, timestamp := range timestamps {
+		entries := entriesByTimestamp[timestamp]
+		if len(entries) == 0 {
+			continue
+		}
+		fpInfo := GetBackupFPInfoForTimestamp(timestamp)
+		var files map[int]backupFileList
+		if checkFiles {
+			contentAddressed := false
+			for _, entry := range entries {
+				contentAddressed = contentAddressed || len(entry.ContentHashes) > 0
+			}
+			files = listBackupFilesOnSegments(fpInfo, contentAddressed)
+		}
+		for _, entry := range entries {
+			table := DataPlanTable{
+				Table:     utils.MakeFQN(entry.Schema, entry.Name),
+				Target:    restoredTableName(entry, redirectSchema),
+				Timestamp: timestamp,
+				Rows:      entry.RowsCopied,
+				Files:     make([]DataPlanFile, 0, len(contentIDs)),
+			}
+			for _, contentID := range contentIDs {
+				file := DataPlanFile{
+					ContentID: contentID,
+					Host:      globalCluster.GetHostForContent(contentID),
+					Path:      dataPlanFilePath(fpInfo, entry, contentID),
+					Bytes:     -1,
+				}
+				if checkFiles {
+					if listed, ok := files[contentID][file.Path]; ok {
+						file.Bytes = listed.Size
+					} else {
+						file.Missing = true
+					}
+				}
+				if file.Missing {
+					plan.MissingFiles++
+				}
+				plan.NumFiles++
+				table.Files = append(table.Files, file)
+			}
+			plan.Tables = append(plan.Tables, table)
+		}
+	}
+	return plan
+}
+
+func PrintDataPlan(plan DataPlan, format string, output io.Writer) error {
+	if format == DATA_PLAN_JSON {
+		contents, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(output, "%s\n", contents)
+		return err
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("Data plan for restoring backup %s to database %s\n", plan.Timestamp, plan.Database))
+	if plan.SingleDataFile {
+		text.WriteString("Every table is read from the single data file of each segment; sizes are those of the whole file\n")
+	}
+	if !plan.FilesChecked {
+		text.WriteString("Data files are stored with a plugin, so their sizes and presence are not checked\n")
+	}
+	for _, table := range plan.Tables {
+		text.WriteString(fmt.Sprintf("\n%s -> %s: %d rows from backup %s\n", table.Table, table.Target, table.Rows, table.Timestamp))
+		for _, file := range table.Files {
+			status := fmt.Sprintf("%d bytes", file.Bytes)
+			if file.Missing {
+				status = "MISSING"
+			} else if file.Bytes < 0 {
+				status = "not checked"
+			}
+			text.WriteString(fmt.Sprintf("\tcontent %d on %s: %s (%s)\n", file.ContentID, file.Host, file.Path, status))
+		}
+	}
+	text.WriteString(fmt.Sprintf("\n%d table(s), %d data file(s), %d missing\n", len(plan.Tables), plan.NumFiles, plan.MissingFiles))
+	_, err := io.WriteString(output, text.String())
+	return err
+}
+
+/*
+ * Prints the data plan for the tables the restore would load, and fails if
+ * any of their data files is missing, so that it is found before the load
+ * rather than partway through it.
+ */
+func printDataPlan(restoreDatabase string) {
+	plan := NewDataPlan(getDataEntriesToRestore(), opts.RedirectSchema, MustGetFlagString(options.PLUGIN_CONFIG) == "")
+	plan.Timestamp = globalFPInfo.Timestamp
+	plan.Database = restoreDatabase
+	err := PrintDataPlan(plan, MustGetFlagString(options.DATA_PLAN), operating.System.Stdout)
+	gplog.FatalOnError(err)
+	if plan.MissingFiles > 0 {
+		gplog.Fatal(errors.Errorf("%d of the %d data file(s) in the data plan are missing", plan.MissingFiles, plan.NumFiles), "")
+	}
+	gplog.Info("Data plan complete; no data was restored")
+}
@@ -0,0 +1,113 @@
+package restore_test
+
+import (
+	"encoding/json"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("restore/plan tests", func() {
+	var testExecutor *testhelper.TestExecutor
+	var pipeThroughProgram utils.PipeThroughProgram
+	entries := map[string][]toc.MasterDataEntry{
+		"20170101010101": {
+			{Schema: "public", Name: "foo", Oid: 1234, RowsCopied: 10},
+			{Schema: "public", Name: "bar", Oid: 1235, RowsCopied: 20},
+		},
+	}
+	BeforeEach(func() {
+		testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{
+			Commands: []cluster.ShellCommand{
+				{Content: 0, Stdout: "file 100 /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234.gz\n" +
+					"file 200 /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1235.gz\n"},
+				{Content: 1, Stdout: "file 150 /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_1234.gz\n"},
+			},
+		}}
+		testCluster := cluster.NewCluster([]cluster.SegConfig{
+			{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"},
+			{ContentID: 0, Hostname: "localhost", DataDir: "/data/gpseg0"},
+			{ContentID: 1, Hostname: "remotehost1", DataDir: "/data/gpseg1"},
+		})
+		testCluster.Executor = testExecutor
+		restore.SetCluster(testCluster)
+		restore.SetBackupConfig(&history.BackupConfig{Compressed: true})
+		pipeThroughProgram = utils.GetPipeThroughProgram()
+		utils.InitializePipeThroughParameters(true, 1)
+	})
+	AfterEach(func() {
+		utils.SetPipeThroughProgram(pipeThroughProgram)
+	})
+	Describe("NewDataPlan", func() {
+		It("lists the data file of each table on each segment with its size", func() {
+			plan := restore.NewDataPlan(entries, "", true)
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring(`find /data/gpseg0/backups/20170101/20170101010101 -type f -printf 'file %s %p\n' 2>/dev/null; true`))
+			Expect(plan.Tables).To(HaveLen(2))
+			Expect(plan.Tables[0].Table).To(Equal("public.foo"))
+			Expect(plan.Tables[0].Target).To(Equal("public.foo"))
+			Expect(plan.Tables[0].Rows).To(Equal(int64(10)))
+			Expect(plan.Tables[0].Files).To(Equal([]restore.DataPlanFile{
+				{ContentID: 0, Host: "localhost", Path: "/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234.gz", Bytes: 100},
+				{ContentID: 1, Host: "remotehost1", Path: "/data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_1234.gz", Bytes: 150},
+			}))
+			Expect(plan.NumFiles).To(Equal(4))
+		})
+		It("flags missing data files", func() {
+			plan := restore.NewDataPlan(entries, "", true)
+			Expect(plan.Tables[1].Files[1].Missing).To(BeTrue())
+			Expect(plan.MissingFiles).To(Equal(1))
+		})
+		It("gives the table each table is restored to with --redirect-schema", func() {
+			plan := restore.NewDataPlan(entries, "other", true)
+			Expect(plan.Tables[0].Target).To(Equal("other.foo"))
+		})
+		It("lists the shared directory of content-addressed data files", func() {
+			contentAddressed := map[string][]toc.MasterDataEntry{
+				"20170101010101": {{Schema: "public", Name: "foo", Oid: 1234, ContentHashes: map[int]string{0: "abc.gz", 1: "def.gz"}}},
+			}
+			plan := restore.NewDataPlan(contentAddressed, "", true)
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring("find /data/gpseg0/backups/20170101/20170101010101 /data/gpseg0/backups/content -type f"))
+			Expect(plan.Tables[0].Files[1].Path).To(Equal("/data/gpseg1/backups/content/def.gz"))
+		})
+		It("does not check files stored with a plugin", func() {
+			plan := restore.NewDataPlan(entries, "", false)
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+			Expect(plan.Tables[1].Files[1].Bytes).To(Equal(int64(-1)))
+			Expect(plan.MissingFiles).To(Equal(0))
+		})
+	})
+	Describe("PrintDataPlan", func() {
+		var plan restore.DataPlan
+		BeforeEach(func() {
+			plan = restore.NewDataPlan(entries, "", true)
+			plan.Timestamp = "20170101010101"
+			plan.Database = "testdb"
+		})
+		It("prints the plan as text", func() {
+			Expect(restore.PrintDataPlan(plan, restore.DATA_PLAN_TEXT, buffer)).To(Succeed())
+			Expect(buffer).To(Say(`Data plan for restoring backup 20170101010101 to database testdb
+
+public.foo -> public.foo: 10 rows from backup 20170101010101
+	content 0 on localhost: /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234.gz \(100 bytes\)
+	content 1 on remotehost1: /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_1234.gz \(150 bytes\)`))
+			Expect(buffer).To(Say(`	content 1 on remotehost1: /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_1235.gz \(MISSING\)
+
+2 table\(s\), 4 data file\(s\), 1 missing`))
+		})
+		It("prints the plan as json", func() {
+			Expect(restore.PrintDataPlan(plan, restore.DATA_PLAN_JSON, buffer)).To(Succeed())
+			var printed restore.DataPlan
+			Expect(json.Unmarshal(buffer.Contents(), &printed)).To(Succeed())
+			Expect(printed).To(Equal(plan))
+		})
+	})
+})
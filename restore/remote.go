@@ -29,17 +29,38 @@ func VerifyBackupDirectoriesExistOnAllHosts() {
 	}
 }
 
+/*
+ * By default this only counts the files in each segment backup directory.
+ * With --verify-backup-files or --verify-checksums the same find command also
+ * lists the size of each file and, for the latter, an md5sum of each file, so
+ * that the richer checks do not need another pass over the segments.  The
+ * backup does not record checksums to compare against, so they are logged for
+ * comparison with a later verification, and a file that cannot be read fails
+ * the check.
+ */
 func VerifyBackupFileCountOnSegments(fileCount int) {
+	verifyChecksums := MustGetFlagBool(options.VERIFY_CHECKSUMS)
+	verifyFiles := verifyChecksums || MustGetFlagBool(options.VERIFY_BACKUP_FILES)
 	remoteOutput := globalCluster.GenerateAndExecuteCommand("Verifying backup file count", cluster.ON_SEGMENTS, func(contentID int) string {
-		return fmt.Sprintf("find %s -type f | wc -l", globalFPInfo.GetDirForContent(contentID))
+		return backupFileVerificationCommand(globalFPInfo.GetDirForContent(contentID), verifyFiles, verifyChecksums)
 	})
 	globalCluster.CheckClusterError(remoteOutput, "Could not verify backup file count", func(contentID int) string {
 		return "Could not verify backup file count"
 	})
 
 	numIncorrect := 0
+	numInvalid := 0
 	for contentID, cmd := range remoteOutput.Commands {
-		numFound, _ := strconv.Atoi(strings.TrimSpace(cmd.Stdout))
+		numFound := 0
+		if verifyFiles {
+			files := parseBackupFileVerificationOutput(cmd.Stdout)
+			numFound = len(files)
+			if !files.verify(contentID, verifyChecksums) {
+				numInvalid++
+			}
+		} else {
+			numFound, _ = strconv.Atoi(strings.TrimSpace(cmd.Stdout))
+		}
 		if numFound != fileCount {
 			gplog.Verbose("Expected to find %d file(s) on segment %d on host %s, but found %d instead.", fileCount, contentID, globalCluster.GetHostForContent(contentID), numFound)
 			numIncorrect++
@@ -48,6 +69,100 @@ func VerifyBackupFileCountOnSegments(fileCount int) {
 	if numIncorrect > 0 {
 		cluster.LogFatalClusterError("Found incorrect number of backup files", cluster.ON_SEGMENTS, numIncorrect)
 	}
+	if numInvalid > 0 {
+		cluster.LogFatalClusterError("Found invalid backup files", cluster.ON_SEGMENTS, numInvalid)
+	}
+}
+
+/*
+ * Checks every location on every host, including the master host, since each
+ * segment creates its tablespace directory under the same location.
+ */
+func VerifyTablespaceLocationsExistOnAllHosts(locations []string) {
+	if len(locations) == 0 {
+		return
+	}
+	checks := make([]string, 0, len(locations))
+	for _, location := range locations {
+		checks = append(checks, fmt.Sprintf("test -d '%s'", location))
+	}
+	remoteOutput := globalCluster.GenerateAndExecuteCommand("Verifying tablespace locations exist", cluster.ON_HOSTS|cluster.INCLUDE_MASTER, func(contentID int) string {
+		return strings.Join(checks, " && ")
+	})
+	globalCluster.CheckClusterError(remoteOutput, "Tablespace locations missing or inaccessible", func(contentID int) string {
+		return fmt.Sprintf("One or more tablespace locations missing or inaccessible on host %s", globalCluster.GetHostForContent(contentID))
+	})
+}
+
+func backupFileVerificationCommand(backupDir string, verifyFiles bool, verifyChecksums bool) string {
+	if !verifyFiles {
+		return fmt.Sprintf("find %s -type f | wc -l", backupDir)
+	}
+	if verifyChecksums {
+		// md5sum's output is not prefixed, so it can be told apart from the size lines
+		return fmt.Sprintf(`find %s -type f -printf 'file %%s %%p\n' -exec md5sum {} +`, backupDir)
+	}
+	return fmt.Sprintf(`find %s -type f -printf 'file %%s %%p\n'`, backupDir)
+}
+
+type backupFileInfo struct {
+	Size     int64
+	Checksum string
+}
+
+// Maps the path of each backup file on a segment to its size and md5sum
+type backupFileList map[string]*backupFileInfo
+
+/*
+ * find prints the size of each file before passing it to md5sum, so a
+ * checksum line is only recorded for a file already listed.
+ */
+func parseBackupFileVerificationOutput(output string) backupFileList {
+	files := make(backupFileList)
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "file ") {
+			fields := strings.SplitN(line, " ", 3)
+			if len(fields) < 3 {
+				continue
+			}
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			files[fields[2]] = &backupFileInfo{Size: size}
+		} else if fields := strings.SplitN(line, "  ", 2); len(fields) == 2 {
+			if file, ok := files[fields[1]]; ok {
+				file.Checksum = fields[0]
+			}
+		}
+	}
+	return files
+}
+
+/*
+ * Uncompressed data files for empty tables are legitimately empty, so files
+ * are only required to be non-empty for compressed backups.
+ */
+func (files backupFileList) verify(contentID int, verifyChecksums bool) bool {
+	valid := true
+	var totalBytes int64
+	for path, file := range files {
+		totalBytes += file.Size
+		if file.Size == 0 && backupConfig.Compressed {
+			gplog.Verbose("Backup file %s on segment %d is empty.", path, contentID)
+			valid = false
+		}
+		if verifyChecksums {
+			if file.Checksum == "" {
+				gplog.Verbose("Could not compute a checksum for backup file %s on segment %d.", path, contentID)
+				valid = false
+			} else {
+				gplog.Verbose("Backup file %s on segment %d has md5sum %s.", path, contentID, file.Checksum)
+			}
+		}
+	}
+	gplog.Verbose("Found %d backup file(s) totaling %d bytes on segment %d.", len(files), totalBytes, contentID)
+	return valid
 }
 
 func VerifyMetadataFilePaths(withStats bool) {
@@ -7,6 +7,8 @@ import (
 	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
 	"github.com/greenplum-db/gpbackup/restore"
 
 	. "github.com/onsi/ginkgo"
@@ -77,5 +79,112 @@ var _ = Describe("restore/remote tests", func() {
 			defer testhelper.ShouldPanicWithMessage("Could not verify backup file count on 1 segment")
 			restore.VerifyBackupFileCountOnSegments(2)
 		})
+		It("only counts backup files by default", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				Commands: []cluster.ShellCommand{
+					cluster.ShellCommand{Stdout: "2"},
+					cluster.ShellCommand{Stdout: "2"},
+				},
+			}
+			restore.SetCluster(testCluster)
+			restore.VerifyBackupFileCountOnSegments(2)
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring("find /data/gpseg0/backups/20170101/20170101010101 -type f | wc -l"))
+		})
+		Context("with --verify-backup-files", func() {
+			BeforeEach(func() {
+				_ = cmdFlags.Set(options.VERIFY_BACKUP_FILES, "true")
+				restore.SetBackupConfig(&history.BackupConfig{Compressed: true})
+				restore.SetCluster(testCluster)
+			})
+			It("lists the size of each file", func() {
+				testExecutor.ClusterOutput = &cluster.RemoteOutput{
+					Commands: []cluster.ShellCommand{
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg0/a.gz\nfile 30 /data/gpseg0/b.gz\n"},
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg1/a.gz\nfile 30 /data/gpseg1/b.gz\n"},
+					},
+				}
+				restore.VerifyBackupFileCountOnSegments(2)
+				Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring(`find /data/gpseg0/backups/20170101/20170101010101 -type f -printf 'file %s %p\n'`))
+				Expect(string(logfile.Contents())).To(ContainSubstring("Found 2 backup file(s) totaling 50 bytes on segment 0."))
+			})
+			It("panics if a compressed backup file is empty", func() {
+				testExecutor.ClusterOutput = &cluster.RemoteOutput{
+					Commands: []cluster.ShellCommand{
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg0/a.gz\nfile 0 /data/gpseg0/b.gz\n"},
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg1/a.gz\nfile 30 /data/gpseg1/b.gz\n"},
+					},
+				}
+				defer testhelper.ShouldPanicWithMessage("Found invalid backup files on 1 segment")
+				restore.VerifyBackupFileCountOnSegments(2)
+			})
+			It("allows empty files in an uncompressed backup", func() {
+				restore.SetBackupConfig(&history.BackupConfig{Compressed: false})
+				testExecutor.ClusterOutput = &cluster.RemoteOutput{
+					Commands: []cluster.ShellCommand{
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg0/a\nfile 0 /data/gpseg0/b\n"},
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg1/a\nfile 30 /data/gpseg1/b\n"},
+					},
+				}
+				restore.VerifyBackupFileCountOnSegments(2)
+			})
+		})
+		Context("with --verify-checksums", func() {
+			BeforeEach(func() {
+				_ = cmdFlags.Set(options.VERIFY_CHECKSUMS, "true")
+				restore.SetBackupConfig(&history.BackupConfig{Compressed: true})
+				restore.SetCluster(testCluster)
+			})
+			It("checksums each file in the same command and logs its checksum", func() {
+				testExecutor.ClusterOutput = &cluster.RemoteOutput{
+					Commands: []cluster.ShellCommand{
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg0/a.gz\nfile 30 /data/gpseg0/b.gz\n0123456789abcdef0123456789abcdef  /data/gpseg0/a.gz\nfedcba9876543210fedcba9876543210  /data/gpseg0/b.gz\n"},
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg1/a.gz\n0123456789abcdef0123456789abcdef  /data/gpseg1/a.gz\nfile 30 /data/gpseg1/b.gz\nfedcba9876543210fedcba9876543210  /data/gpseg1/b.gz\n"},
+					},
+				}
+				restore.VerifyBackupFileCountOnSegments(2)
+				Expect(testExecutor.NumExecutions).To(Equal(1))
+				Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring(`find /data/gpseg0/backups/20170101/20170101010101 -type f -printf 'file %s %p\n' -exec md5sum {} +`))
+				Expect(string(logfile.Contents())).To(ContainSubstring("Backup file /data/gpseg1/b.gz on segment 1 has md5sum fedcba9876543210fedcba9876543210."))
+				Expect(string(logfile.Contents())).To(ContainSubstring("Found 2 backup file(s) totaling 50 bytes on segment 0."))
+			})
+			It("panics if a file could not be checksummed", func() {
+				testExecutor.ClusterOutput = &cluster.RemoteOutput{
+					Commands: []cluster.ShellCommand{
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg0/a.gz\nfile 30 /data/gpseg0/b.gz\n0123456789abcdef0123456789abcdef  /data/gpseg0/a.gz\n"},
+						cluster.ShellCommand{Stdout: "file 20 /data/gpseg1/a.gz\n0123456789abcdef0123456789abcdef  /data/gpseg1/a.gz\nfile 30 /data/gpseg1/b.gz\nfedcba9876543210fedcba9876543210  /data/gpseg1/b.gz\n"},
+					},
+				}
+				defer func() {
+					Expect(string(logfile.Contents())).To(ContainSubstring("Could not compute a checksum for backup file /data/gpseg0/b.gz on segment 0."))
+				}()
+				defer testhelper.ShouldPanicWithMessage("Found invalid backup files on 1 segment")
+				restore.VerifyBackupFileCountOnSegments(2)
+			})
+		})
+	})
+	Describe("VerifyTablespaceLocationsExistOnAllHosts", func() {
+		It("checks every location on every host", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{}
+			restore.SetCluster(testCluster)
+			restore.VerifyTablespaceLocationsExistOnAllHosts([]string{"/new/fast", "/new/slow"})
+			Expect(testExecutor.ClusterCommands[0]).To(HaveLen(3))
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring("test -d '/new/fast' && test -d '/new/slow'"))
+		})
+		It("panics if a location is missing on a host", func() {
+			testExecutor.ClusterOutput = &cluster.RemoteOutput{
+				NumErrors: 1,
+				FailedCommands: []*cluster.ShellCommand{
+					&cluster.ShellCommand{Content: 1},
+				},
+			}
+			restore.SetCluster(testCluster)
+			defer testhelper.ShouldPanicWithMessage("Tablespace locations missing or inaccessible on 1 segment")
+			restore.VerifyTablespaceLocationsExistOnAllHosts([]string{"/new/fast"})
+		})
+		It("does nothing if there are no locations", func() {
+			restore.SetCluster(testCluster)
+			restore.VerifyTablespaceLocationsExistOnAllHosts([]string{})
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+		})
 	})
 })
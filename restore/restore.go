@@ -8,9 +8,12 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gpbackup/filepath"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
@@ -42,16 +45,50 @@ func DoValidation(cmd *cobra.Command) {
 	gplog.FatalOnError(err)
 	err = utils.ValidateFullPath(MustGetFlagString(options.PLUGIN_CONFIG))
 	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.DEFAULTS_REWRITE_FILE))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.STORAGE_OVERRIDE_FILE))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.TABLESPACE_MAP_FILE))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.PRE_RESTORE_SCRIPT))
+	gplog.FatalOnError(err)
+	err = utils.ValidateFullPath(MustGetFlagString(options.POST_RESTORE_SCRIPT))
+	gplog.FatalOnError(err)
+	err = utils.ValidateKeepaliveSettings(MustGetFlagInt(options.KEEPALIVES_IDLE),
+		MustGetFlagInt(options.KEEPALIVES_INTERVAL), MustGetFlagInt(options.KEEPALIVES_COUNT))
+	gplog.FatalOnError(err)
+	if MustGetFlagInt(options.MAX_PER_HOST) < 0 {
+		gplog.Fatal(errors.Errorf("--%s must not be negative", options.MAX_PER_HOST), "")
+	}
+	if mode := MustGetFlagString(options.STORAGE_OVERRIDE_MODE); mode != STORAGE_OVERRIDE_MERGE && mode != STORAGE_OVERRIDE_REPLACE {
+		gplog.Fatal(errors.Errorf("Invalid --%s value %s.  Valid values are merge and replace.", options.STORAGE_OVERRIDE_MODE, mode), "")
+	}
+	_, err = ParseStorageOptions(MustGetFlagString(options.STORAGE_OVERRIDE))
+	gplog.FatalOnError(err)
+	if format := MustGetFlagString(options.DATA_PLAN); format != "" && format != DATA_PLAN_TEXT && format != DATA_PLAN_JSON {
+		gplog.Fatal(errors.Errorf("Invalid --%s value %s.  Valid values are text and json.", options.DATA_PLAN, format), "")
+	}
+	switch MustGetFlagString(options.ON_CONVERSION_ERROR) {
+	case utils.CONVERSION_ERROR_FAIL, utils.CONVERSION_ERROR_SKIP, utils.CONVERSION_ERROR_REPLACE:
+	default:
+		gplog.Fatal(errors.Errorf("Invalid --%s value %s.  Valid values are fail, skip, and replace.", options.ON_CONVERSION_ERROR, MustGetFlagString(options.ON_CONVERSION_ERROR)), "")
+	}
 	if !filepath.IsValidTimestamp(MustGetFlagString(options.TIMESTAMP)) {
 		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", MustGetFlagString(options.TIMESTAMP)), "")
 	}
+	if restoreTo := MustGetFlagString(options.RESTORE_TO_TIMESTAMP); restoreTo != "" && !filepath.IsValidTimestamp(restoreTo) {
+		gplog.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", restoreTo), "")
+	}
 }
 
 // This function handles setup that must be done after parsing flags.
 func DoSetup() {
 	SetLoggerVerbosity()
+	runStats = history.NewRunStats()
 	gplog.Verbose("Restore Command: %s", os.Args)
 
+	utils.SetErrorPhase(utils.ERROR_CLASS_CONNECTION)
 	utils.CheckGpexpandRunning(utils.RestorePreventedByGpexpandMessage)
 	restoreStartTime = history.CurrentTimestamp()
 	backupTimestamp := MustGetFlagString(options.TIMESTAMP)
@@ -59,13 +96,19 @@ func DoSetup() {
 
 	CreateConnectionPool("postgres")
 
+	utils.SetErrorPhase(utils.ERROR_CLASS_USAGE)
 	var err error
 	opts, err = options.NewOptions(cmdFlags)
 	gplog.FatalOnError(err)
+	removeSystemRelationsFromFilters()
+	roleMap = ParseRoleMap(MustGetFlagStringArray(options.ROLE_MAP))
+	tablespaceMap = ParseTablespaceLocationMap(MustGetFlagStringArray(options.TABLESPACE_MAP), MustGetFlagString(options.TABLESPACE_MAP_FILE))
 
 	err = opts.QuoteIncludeRelations(connectionPool)
 	gplog.FatalOnError(err)
+	opts.QuoteRedirectSchema()
 
+	utils.SetErrorPhase(utils.ERROR_CLASS_CONNECTION)
 	segConfig := cluster.MustGetSegmentConfiguration(connectionPool)
 	globalCluster = cluster.NewCluster(segConfig)
 	segPrefix := filepath.ParseSegPrefix(MustGetFlagString(options.BACKUP_DIR), backupTimestamp)
@@ -77,11 +120,15 @@ func DoSetup() {
 	} else {
 		InitializeBackupConfig()
 	}
+	if restoreTo := MustGetFlagString(options.RESTORE_TO_TIMESTAMP); restoreTo != "" && restoreTo != backupTimestamp {
+		SetRestorePoint(restoreTo)
+	}
 
 	gplog.Info("gpbackup version = %s", backupConfig.BackupVersion)
 	gplog.Info("gprestore version = %s", GetVersion())
 	gplog.Info("Greenplum Database Version = %s", connectionPool.Version.VersionString)
 
+	utils.SetErrorPhase(utils.ERROR_CLASS_USAGE)
 	BackupConfigurationValidation()
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	if !backupConfig.DataOnly {
@@ -91,7 +138,24 @@ func DoSetup() {
 	if MustGetFlagString(options.REDIRECT_DB) != "" {
 		unquotedRestoreDatabase = MustGetFlagString(options.REDIRECT_DB)
 	}
+	if MustGetFlagString(options.DATA_PLAN) != "" {
+		printDataPlan(unquotedRestoreDatabase)
+		return
+	}
 	ValidateDatabaseExistence(unquotedRestoreDatabase, MustGetFlagBool(options.CREATE_DB), backupConfig.IncludeTableFiltered || backupConfig.DataOnly)
+	if MustGetFlagBool(options.WITH_GLOBALS) && len(tablespaceMap) > 0 {
+		ValidateTablespaceLocations(GetRestoreMetadataStatements("global", metadataFilename, []string{"TABLESPACE"}, []string{}), tablespaceMap)
+	}
+	if len(roleMap) > 0 && !backupConfig.DataOnly && !MustGetFlagBool(options.DATA_ONLY) {
+		sections := []string{"predata", "postdata"}
+		if MustGetFlagBool(options.WITH_GLOBALS) {
+			sections = append(sections, "global")
+		}
+		filters := NewFilters(opts.IncludedSchemas, opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations)
+		ValidateRoleMapSources(roleMap, GetRoleUsesInMetadata(metadataFilename, sections, filters, nil))
+	}
+	confirmDestructiveRestore(unquotedRestoreDatabase)
+	utils.SetErrorPhase(utils.ERROR_CLASS_METADATA)
 	if MustGetFlagBool(options.WITH_GLOBALS) {
 		restoreGlobal(metadataFilename)
 	} else if MustGetFlagBool(options.CREATE_DB) {
@@ -100,7 +164,13 @@ func DoSetup() {
 	if connectionPool != nil {
 		connectionPool.Close()
 	}
+	utils.SetErrorPhase(utils.ERROR_CLASS_CONNECTION)
 	InitializeConnectionPool(backupTimestamp, restoreStartTime, unquotedRestoreDatabase)
+	if !backupConfig.MetadataOnly && !MustGetFlagBool(options.METADATA_ONLY) {
+		encodingConversion = PlanEncodingConversion(connectionPool, backupConfig.ClientEncoding,
+			MustGetFlagString(options.ON_CONVERSION_ERROR), backupConfig.DataFormat == "binary")
+	}
+	utils.SetErrorPhase(utils.ERROR_CLASS_USAGE)
 
 	/*
 	 * We don't need to validate anything if we're creating the database; we
@@ -125,9 +195,22 @@ func DoSetup() {
 	if opts.RedirectSchema != "" {
 		ValidateRedirectSchema(connectionPool, opts.RedirectSchema)
 	}
+	if MustGetFlagBool(options.STRICT_ROLES) && !backupConfig.DataOnly && !MustGetFlagBool(options.DATA_ONLY) {
+		filters := NewFilters(opts.IncludedSchemas, opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations)
+		ValidateRolesExist(connectionPool, GetRoleUsesInMetadata(metadataFilename, []string{"predata", "postdata"}, filters, roleMap))
+	}
+	if MustGetFlagBool(options.FK_CYCLE_REPLICA) {
+		ValidateReplicaRoleAllowed(connectionPool)
+	}
+	if MustGetFlagBool(options.REBUILD_INDEXES) {
+		RecoverIndexesFromJournal()
+	}
 }
 
 func DoRestore() {
+	if MustGetFlagString(options.DATA_PLAN) != "" {
+		return
+	}
 	var filteredDataEntries map[string][]toc.MasterDataEntry
 	metadataFilename := globalFPInfo.GetMetadataFilePath()
 	isDataOnly := backupConfig.DataOnly || MustGetFlagBool(options.DATA_ONLY)
@@ -137,6 +220,9 @@ func DoRestore() {
 	if isIncremental {
 		verifyIncrementalState()
 	}
+	utils.SetErrorPhase(utils.ERROR_CLASS_METADATA)
+
+	RunPreRestoreScript(MustGetFlagString(options.PRE_RESTORE_SCRIPT))
 
 	if !isDataOnly && !isIncremental {
 		restorePredata(metadataFilename)
@@ -157,6 +243,7 @@ func DoRestore() {
 			VerifyBackupFileCountOnSegments(backupFileCount)
 		}
 		totalTablesRestored, filteredDataEntries = restoreData()
+		tablesRestored = totalTablesRestored
 	}
 
 	if !isDataOnly && !isIncremental {
@@ -168,6 +255,8 @@ func DoRestore() {
 	} else if MustGetFlagBool(options.RUN_ANALYZE) && totalTablesRestored > 0 {
 		runAnalyze(filteredDataEntries)
 	}
+
+	RunPostRestoreScript(MustGetFlagString(options.POST_RESTORE_SCRIPT))
 }
 
 func createDatabase(metadataFilename string) {
@@ -176,7 +265,7 @@ func createDatabase(metadataFilename string) {
 	gplog.Info("Creating database")
 	statements := GetRestoreMetadataStatements("global", metadataFilename, objectTypes, []string{})
 	if MustGetFlagString(options.REDIRECT_DB) != "" {
-		quotedDBName := utils.QuoteIdent(connectionPool, MustGetFlagString(options.REDIRECT_DB))
+		quotedDBName := utils.QuoteIdentifier(MustGetFlagString(options.REDIRECT_DB))
 		dbName = quotedDBName
 		statements = toc.SubstituteRedirectDatabaseInStatements(statements, backupConfig.DatabaseName, quotedDBName)
 	}
@@ -189,12 +278,16 @@ func restoreGlobal(metadataFilename string) {
 	if MustGetFlagBool(options.CREATE_DB) {
 		objectTypes = append(objectTypes, "DATABASE")
 	}
+	defer runStats.RecordPhase("globals", time.Now())
 	gplog.Info("Restoring global metadata")
 	statements := GetRestoreMetadataStatements("global", metadataFilename, objectTypes, []string{})
 	if MustGetFlagString(options.REDIRECT_DB) != "" {
-		quotedDBName := utils.QuoteIdent(connectionPool, MustGetFlagString(options.REDIRECT_DB))
+		quotedDBName := utils.QuoteIdentifier(MustGetFlagString(options.REDIRECT_DB))
 		statements = toc.SubstituteRedirectDatabaseInStatements(statements, backupConfig.DatabaseName, quotedDBName)
 	}
+	if len(tablespaceMap) > 0 {
+		statements = SubstituteTablespaceLocations(statements, tablespaceMap)
+	}
 	statements = toc.RemoveActiveRole(connectionPool.User, statements)
 	ExecuteRestoreMetadataStatements(statements, "Global objects", nil, utils.PB_VERBOSE, false)
 	gplog.Info("Global database metadata restore complete")
@@ -263,6 +356,7 @@ func restorePredata(metadataFilename string) {
 	if wasTerminated {
 		return
 	}
+	defer runStats.RecordPhase("predata", time.Now())
 	gplog.Info("Restoring pre-data metadata")
 	// if not incremental restore - assume database is empty and just filter based on user input
 	filters := NewFilters(opts.IncludedSchemas, opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations)
@@ -270,14 +364,25 @@ func restorePredata(metadataFilename string) {
 	if opts.RedirectSchema == "" {
 		schemaStatements = GetRestoreMetadataStatementsFiltered("predata", metadataFilename, []string{"SCHEMA"}, []string{}, filters)
 	}
-	statements := GetRestoreMetadataStatementsFiltered("predata", metadataFilename, []string{}, []string{"SCHEMA"}, filters)
-
-	editStatementsRedirectSchema(statements, opts.RedirectSchema)
-	progressBar := utils.NewProgressBar(len(schemaStatements)+len(statements), "Pre-data objects restored: ", utils.PB_VERBOSE)
+	entries := GetRestoreMetadataEntriesFiltered("predata", []string{}, []string{"SCHEMA"}, filters)
+	progressBar := utils.NewProgressBar(len(schemaStatements)+len(entries), "Pre-data objects restored: ", utils.PB_VERBOSE)
 	progressBar.Start()
 
+	// Session GUCs such as default_table_access_method affect how tables are created
+	setGUCsForConnection(nil, 0)
 	RestoreSchemas(schemaStatements, progressBar)
-	ExecuteRestoreMetadataStatements(statements, "Pre-data objects", progressBar, utils.PB_VERBOSE, false)
+	removeOids := usesOidsRemoval()
+	rewriteStatement := preparePredataRewrites(entries, metadataFilename)
+	ExecuteStatementsFromFile(entries, metadataFilename, func(statement *toc.StatementWithType) error {
+		if removeOids {
+			removeTableOids(statement)
+		}
+		if err := rewriteStatement(statement); err != nil {
+			return err
+		}
+		editStatementRedirectSchema(statement, opts.RedirectSchema)
+		return nil
+	}, progressBar, false)
 
 	progressBar.Finish()
 	if wasTerminated {
@@ -287,6 +392,81 @@ func restorePredata(metadataFilename string) {
 	}
 }
 
+func usesColumnDefaults() bool {
+	return MustGetFlagString(options.DEFAULTS_REWRITE_FILE) != "" || MustGetFlagBool(options.VALIDATE_DEFAULTS)
+}
+
+/*
+ * Column defaults and storage options are validated for every table being
+ * restored before any of them is created.  The defaults are taken from the
+ * TOC, while the storage options require reading each CREATE TABLE statement,
+ * one at a time.  The returned function applies the same rewrites to each
+ * statement as it is read for restore.
+ */
+func preparePredataRewrites(entries []toc.MetadataEntry, metadataFilename string) func(*toc.StatementWithType) error {
+	rewriteDefaults, rewriteStorage := usesColumnDefaults(), usesStorageOverrides()
+	var defaultRewriter *ColumnDefaultRewriter
+	if rewriteDefaults {
+		var rules []DefaultRewriteRule
+		if rewriteFile := MustGetFlagString(options.DEFAULTS_REWRITE_FILE); rewriteFile != "" {
+			var err error
+			rules, err = ReadDefaultRewriteFile(rewriteFile)
+			gplog.FatalOnError(err)
+		}
+		defaultRewriter = NewColumnDefaultRewriter(globalTOC.ColumnDefaults, rules)
+	}
+	var storageOverrides StorageOverrides
+	if rewriteStorage {
+		storageOverrides = getStorageOverrides()
+	}
+	rewriteStatement := func(statement *toc.StatementWithType) ([]string, error) {
+		if rewriteDefaults {
+			defaultRewriter.RewriteStatement(statement)
+		}
+		if rewriteStorage {
+			return RewriteTableStorageOptions(statement, storageOverrides)
+		}
+		return nil, nil
+	}
+
+	if rewriteDefaults && MustGetFlagBool(options.VALIDATE_DEFAULTS) {
+		columnDefaults := make([]toc.ColumnDefaultEntry, 0)
+		for _, entry := range entries {
+			if entry.ObjectType == "TABLE" {
+				columnDefaults = append(columnDefaults, defaultRewriter.TableDefaults(entry.Schema, entry.Name)...)
+			}
+		}
+		ValidateColumnDefaults(connectionPool, columnDefaults)
+	}
+	if rewriteStorage {
+		removeOids := usesOidsRemoval()
+		metadataFile := iohelper.MustOpenFileForReading(metadataFilename)
+		defer metadataFile.Close()
+		clauses := make(map[string]bool)
+		for _, entry := range entries {
+			if entry.ObjectType != "TABLE" {
+				continue
+			}
+			statement, err := toc.ReadStatement(metadataFile, entry)
+			gplog.FatalOnError(err)
+			if removeOids {
+				statement.Statement = RemoveOidsOption(statement.Statement)
+			}
+			tableClauses, err := rewriteStatement(&statement)
+			gplog.FatalOnError(err)
+			for _, clause := range tableClauses {
+				clauses[clause] = true
+			}
+		}
+		ValidateStorageOptions(connectionPool, sortedClauses(clauses))
+	}
+
+	return func(statement *toc.StatementWithType) error {
+		_, err := rewriteStatement(statement)
+		return err
+	}
+}
+
 func restoreSequenceValues(metadataFilename string) {
 	if wasTerminated {
 		return
@@ -329,15 +509,23 @@ func editStatementsRedirectSchema(statements []toc.StatementWithType, redirectSc
 		return
 	}
 
-	for i, statement := range statements {
-		oldSchema := fmt.Sprintf("%s.", statement.Schema)
-		newSchema := fmt.Sprintf("%s.", redirectSchema)
-		statements[i].Schema = redirectSchema
-		statements[i].Statement = strings.Replace(statement.Statement, oldSchema, newSchema, 1)
-		// only postdata will have a reference object
-		if statement.ReferenceObject != "" {
-			statements[i].ReferenceObject = strings.Replace(statement.ReferenceObject, oldSchema, newSchema, 1)
-		}
+	for i := range statements {
+		editStatementRedirectSchema(&statements[i], redirectSchema)
+	}
+}
+
+func editStatementRedirectSchema(statement *toc.StatementWithType, redirectSchema string) {
+	if redirectSchema == "" {
+		return
+	}
+
+	oldSchema := fmt.Sprintf("%s.", statement.Schema)
+	newSchema := fmt.Sprintf("%s.", redirectSchema)
+	statement.Schema = redirectSchema
+	statement.Statement = strings.Replace(statement.Statement, oldSchema, newSchema, 1)
+	// only postdata will have a reference object
+	if statement.ReferenceObject != "" {
+		statement.ReferenceObject = strings.Replace(statement.ReferenceObject, oldSchema, newSchema, 1)
 	}
 }
 
@@ -345,20 +533,14 @@ func restoreData() (int, map[string][]toc.MasterDataEntry) {
 	if wasTerminated {
 		return -1, nil
 	}
-	restorePlan := backupConfig.RestorePlan
-	restorePlanEntries := make([]history.RestorePlanEntry, 0)
-	if MustGetFlagBool(options.INCREMENTAL) {
-		restorePlanEntries = append(restorePlanEntries,
-			restorePlan[len(backupConfig.RestorePlan)-1])
-	} else {
-		for _, restorePlanEntry := range restorePlan {
-			restorePlanEntries = append(restorePlanEntries, restorePlanEntry)
-		}
-	}
+	defer runStats.RecordPhase("data", time.Now())
+	utils.SetErrorPhase(utils.ERROR_CLASS_DATA)
+	defer utils.SetErrorPhase(utils.ERROR_CLASS_METADATA)
 
 	totalTables := 0
+	numSkippedTables := 0
 	filteredDataEntries := make(map[string][]toc.MasterDataEntry)
-	for _, entry := range restorePlanEntries {
+	for _, entry := range getRestorePlanEntries() {
 		fpInfo := GetBackupFPInfoForTimestamp(entry.Timestamp)
 		tocfile := toc.NewTOC(fpInfo.GetTOCFilePath())
 		restorePlanTableFQNs := entry.TableFQNs
@@ -366,42 +548,100 @@ func restoreData() (int, map[string][]toc.MasterDataEntry) {
 			opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations, restorePlanTableFQNs)
 		filteredDataEntries[entry.Timestamp] = filteredDataEntriesForTimestamp
 		totalTables += len(filteredDataEntriesForTimestamp)
+		skippedEntries := tocfile.GetSkippedDataEntriesMatching(opts.IncludedSchemas,
+			opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations)
+		for _, skipped := range skippedEntries {
+			gplog.Verbose("Table %s was backed up without data (%s); no data will be restored for it", utils.MakeFQN(skipped.Schema, skipped.Name), skipped.Reason)
+		}
+		numSkippedTables += len(skippedEntries)
+	}
+	if numSkippedTables > 0 {
+		gplog.Info("%d table(s) were intentionally backed up without data and will be restored without data; use --verbose to list them", numSkippedTables)
+	}
+	if MustGetFlagBool(options.REBUILD_INDEXES) {
+		DropIndexesForRebuild(getRestoredTableFQNs(filteredDataEntries))
 	}
 	dataProgressBar := utils.NewProgressBar(totalTables, "Tables restored: ", utils.PB_INFO)
 	dataProgressBar.Start()
 
 	gucStatements := setGUCsForConnection(nil, 0)
+	references := GetForeignKeyReferences(connectionPool)
 	for timestamp, entries := range filteredDataEntries {
 		gplog.Verbose("Restoring data for %d tables from backup with timestamp: %s", len(entries), timestamp)
-		restoreDataFromTimestamp(GetBackupFPInfoForTimestamp(timestamp), entries, gucStatements, dataProgressBar)
+		restoreDataFromTimestamp(GetBackupFPInfoForTimestamp(timestamp), entries, gucStatements, dataProgressBar, references)
+	}
+	if MustGetFlagInt(options.MAX_PER_HOST) > 0 {
+		hostConcurrency = utils.CollectHostSlotUsage(globalCluster, globalFPInfo)
+	}
+	if encodingConversion != nil && encodingConversion.Method == report.ENCODING_CONVERSION_HELPER {
+		recordConversionErrors(filteredDataEntries)
 	}
 
 	dataProgressBar.Finish()
+	recordTableLoadBytes(utils.CollectLoadByteCounts(globalCluster, globalFPInfo))
 	if wasTerminated {
 		gplog.Info("Data restore incomplete")
 	} else {
 		gplog.Info("Data restore complete")
 	}
+	if len(droppedIndexes) > 0 && !wasTerminated {
+		RecreateIndexes()
+	}
 
 	return totalTables, filteredDataEntries
 }
 
+/*
+ * An incremental restore only restores the data of the last backup in the
+ * restore plan; otherwise the data of each table is restored from whichever
+ * backup in the plan last backed it up.
+ */
+func getRestorePlanEntries() []history.RestorePlanEntry {
+	restorePlan := backupConfig.RestorePlan
+	restorePlanEntries := make([]history.RestorePlanEntry, 0)
+	if MustGetFlagBool(options.INCREMENTAL) {
+		restorePlanEntries = append(restorePlanEntries,
+			restorePlan[len(backupConfig.RestorePlan)-1])
+	} else {
+		for _, restorePlanEntry := range restorePlan {
+			restorePlanEntries = append(restorePlanEntries, restorePlanEntry)
+		}
+	}
+	return restorePlanEntries
+}
+
+func getDataEntriesToRestore() map[string][]toc.MasterDataEntry {
+	dataEntries := make(map[string][]toc.MasterDataEntry)
+	for _, entry := range getRestorePlanEntries() {
+		fpInfo := GetBackupFPInfoForTimestamp(entry.Timestamp)
+		tocfile := toc.NewTOC(fpInfo.GetTOCFilePath())
+		dataEntries[entry.Timestamp] = tocfile.GetDataEntriesMatching(opts.IncludedSchemas,
+			opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations, entry.TableFQNs)
+	}
+	return dataEntries
+}
+
 func restorePostdata(metadataFilename string) {
 	if wasTerminated {
 		return
 	}
+	defer runStats.RecordPhase("postdata", time.Now())
 	gplog.Info("Restoring post-data metadata")
 
 	filters := NewFilters(opts.IncludedSchemas, opts.ExcludedSchemas, opts.IncludedRelations, opts.ExcludedRelations)
 
 	statements := GetRestoreMetadataStatementsFiltered("postdata", metadataFilename, []string{}, []string{}, filters)
 	editStatementsRedirectSchema(statements, opts.RedirectSchema)
-	firstBatch, secondBatch := BatchPostdataStatements(statements)
-	progressBar := utils.NewProgressBar(len(statements), "Post-data objects restored: ", utils.PB_VERBOSE)
+	constraints, statements := SplitConstraintStatements(statements)
+	firstBatch, secondBatch, clusterBatch := BatchPostdataStatements(statements)
+	progressBar := utils.NewProgressBar(len(firstBatch)+len(secondBatch), "Post-data objects restored: ", utils.PB_VERBOSE)
 	progressBar.Start()
 	ExecuteRestoreMetadataStatements(firstBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
 	ExecuteRestoreMetadataStatements(secondBatch, "", progressBar, utils.PB_VERBOSE, connectionPool.NumConns > 1)
 	progressBar.Finish()
+	// Constraints may use the indexes restored above, and may back the indexes tables are clustered on
+	AddConstraints(constraints)
+	ExecuteRestoreMetadataStatements(clusterBatch, "Clustered tables and replica identities", nil, utils.PB_VERBOSE, connectionPool.NumConns > 1)
 	if wasTerminated {
 		gplog.Info("Post-data metadata restore incomplete")
 	} else {
@@ -413,6 +653,7 @@ func restoreStatistics() {
 	if wasTerminated {
 		return
 	}
+	defer runStats.RecordPhase("statistics", time.Now())
 	statisticsFilename := globalFPInfo.GetStatisticsFilePath()
 	gplog.Info("Restoring query planner statistics from %s", statisticsFilename)
 
@@ -503,10 +744,13 @@ func DoTeardown() {
 		DoCleanup(restoreFailed)
 
 		errorCode := gplog.GetErrorCode()
-		if errorCode == 0 {
+		if errorCode == 0 && MustGetFlagString(options.DATA_PLAN) == "" {
 			gplog.Info("Restore completed successfully")
 		}
-		os.Exit(errorCode)
+		if logCounter != nil && MustGetFlagBool(options.SUMMARY_ONLY) {
+			printRestoreSummary(restoreFailed)
+		}
+		os.Exit(utils.ErrorClassForOutcome(restoreFailed, wasTerminated).ExitCode())
 
 	}()
 
@@ -532,22 +776,25 @@ func DoTeardown() {
 		restoreFailed = true
 		return
 	}
-	if errStr != "" {
+	if errStr != "" && (logCounter == nil || !MustGetFlagBool(options.SUMMARY_ONLY)) {
 		fmt.Println(errStr)
 	}
 	errMsg := report.ParseErrorMessage(errStr)
 
-	if globalFPInfo.Timestamp != "" {
+	if globalFPInfo.Timestamp != "" && MustGetFlagString(options.DATA_PLAN) == "" {
 		_, statErr := os.Stat(globalFPInfo.GetDirForContent(-1))
 		if statErr != nil { // Even if this isn't os.IsNotExist, don't try to write a report file in case of further errors
 			return
 		}
 		reportFilename := globalFPInfo.GetRestoreReportFilePath(restoreStartTime)
-		report.WriteRestoreReportFile(reportFilename, globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, errMsg)
+		writeRestoreReport(reportFilename, errMsg)
 		report.EmailReport(globalCluster, globalFPInfo.Timestamp, reportFilename, "gprestore", !restoreFailed)
+		recordRestoreStats(restoreFailed)
 		if pluginConfig != nil {
-			pluginConfig.CleanupPluginForRestore(globalCluster, globalFPInfo)
-			pluginConfig.DeletePluginConfigWhenEncrypting(globalCluster)
+			for _, plugin := range pluginConfig.AllPlugins() {
+				plugin.CleanupPluginForRestore(globalCluster, globalFPInfo)
+				plugin.DeletePluginConfigWhenEncrypting(globalCluster)
+			}
 		}
 		if len(errorTablesMetadata) > 0 {
 			// tables with metadata errors
@@ -560,6 +807,125 @@ func DoTeardown() {
 	}
 }
 
+func writeRestoreReport(reportFilename string, errMsg string) {
+	restoreReport := report.NewRestoreStructuredReport(MustGetFlagString(options.TIMESTAMP), globalFPInfo.Timestamp, restoreStartTime, connectionPool, version, errMsg)
+	restoreReport.Restore.ReconnectEvents = reconnectCounts
+	restoreReport.Restore.RetriedStatements = int(retriedStatements)
+	restoreReport.Restore.IndexRebuilds = indexRebuilds
+	restoreReport.Restore.EncodingConversion = encodingConversion
+	restoreReport.Restore.OidsRemovedTables = oidsRemovedTables
+	restoreReport.MaxConcurrentPerHost = MustGetFlagInt(options.MAX_PER_HOST)
+	restoreReport.HostConcurrency = hostConcurrency
+	restoreReport.Tables = restoredTables
+	restoreReport.Restore.TableLoadRates = report.NewTableLoadRates(restoredTables)
+	restoreReport.Restore.SlowestConstraints = report.NewSlowestConstraints(constraintTimings, SLOWEST_CONSTRAINTS)
+	restoreReport.Warnings = report.GetWarnings(logCounter)
+	if logCounter != nil {
+		restoreReport.Errors = logCounter.Errors
+	}
+	if runStats != nil {
+		restoreReport.Timings.PhaseSeconds = runStats.PhaseDurations
+	}
+	restoreReport.WriteReportFiles(reportFilename, globalFPInfo.GetRestoreReportJSONFilePath(restoreStartTime))
+}
+
+func printRestoreSummary(restoreFailed bool) {
+	summary := report.Summary{
+		Timestamp: MustGetFlagString(options.TIMESTAMP),
+		Database:  getRestoreDatabaseName(),
+		Type:      getRestoreType(),
+		Tables:    tablesRestored,
+		Bytes:     -1,
+		Duration:  "-",
+		Warnings:  logCounter.Warnings,
+		Errors:    logCounter.Errors,
+		Status:    history.BackupStatusSucceed,
+	}
+	if restoreFailed {
+		summary.Status = history.BackupStatusFailed
+	}
+	if restoreStartTime != "" {
+		_, _, summary.Duration = report.GetDurationInfo(restoreStartTime, operating.System.Now())
+	}
+	fmt.Println(summary.String())
+}
+
+func getRestoreDatabaseName() string {
+	if MustGetFlagString(options.REDIRECT_DB) != "" {
+		return MustGetFlagString(options.REDIRECT_DB)
+	} else if backupConfig != nil {
+		return utils.UnquoteIdent(backupConfig.DatabaseName)
+	}
+	return "-"
+}
+
+/*
+ * Restores are recorded under their backup's entry in the history file, so
+ * nothing is recorded there when restoring on a cluster without that file.
+ */
+func recordRestoreStats(restoreFailed bool) {
+	historyFilename := globalFPInfo.GetBackupHistoryFilePath()
+	hasHistoryFile := iohelper.FileExistsAndIsReadable(historyFilename)
+	historyDB := MustGetFlagString(options.HISTORY_DB)
+	if runStats == nil || (!hasHistoryFile && historyDB == "") {
+		return
+	}
+	runStats.RestoreTimestamp = restoreStartTime
+	runStats.Database = getRestoreDatabaseName()
+	if backupConfig != nil {
+		runStats.SourceDatabase = utils.UnquoteIdent(backupConfig.DatabaseName)
+	}
+	runStats.Status = history.BackupStatusSucceed
+	if restoreFailed {
+		runStats.Status = history.BackupStatusFailed
+	}
+	runStats.Tables = tablesRestored
+	if logCounter != nil {
+		runStats.Errors = len(logCounter.Errors)
+		runStats.Warnings = logCounter.Warnings
+	}
+	if pluginConfig == nil {
+		runStats.Bytes = report.GetBackupDirectorySize(globalCluster, globalFPInfo)
+	}
+	start, _ := time.ParseInLocation("20060102150405", restoreStartTime, operating.System.Local)
+	runStats.Complete(operating.System.Now().Sub(start))
+	if hasHistoryFile {
+		err := history.AddRestoreStats(historyFilename, globalFPInfo.Timestamp, *runStats)
+		if err != nil {
+			gplog.Warn("Unable to record restore statistics in history file %s: %v", historyFilename, err)
+		}
+	}
+	if historyDB != "" {
+		record := history.OperationRecord{
+			Operation:        "restore",
+			BackupTimestamp:  globalFPInfo.Timestamp,
+			RestoreTimestamp: restoreStartTime,
+			Database:         runStats.Database,
+			SourceDatabase:   runStats.SourceDatabase,
+			Status:           runStats.Status,
+			StartTime:        restoreStartTime,
+			EndTime:          history.CurrentTimestamp(),
+			Options:          options.ChangedFlagValues(cmdFlags),
+			Stats:            runStats,
+		}
+		err := history.RecordOperationInDatabase(historyDB, MustGetFlagString(options.HISTORY_SCHEMA), record)
+		if err != nil {
+			gplog.Warn("Unable to record restore in database %s: %v", historyDB, err)
+		}
+	}
+}
+
+func getRestoreType() string {
+	if MustGetFlagBool(options.INCREMENTAL) {
+		return "incremental"
+	} else if MustGetFlagBool(options.DATA_ONLY) || (backupConfig != nil && backupConfig.DataOnly) {
+		return "data-only"
+	} else if MustGetFlagBool(options.METADATA_ONLY) || (backupConfig != nil && backupConfig.MetadataOnly) {
+		return "metadata-only"
+	}
+	return "full"
+}
+
 func writeErrorTables(isMetadata bool) {
 	var errorTables *map[string]Empty
 	var errorFilename string
@@ -616,6 +982,27 @@ func DoCleanup(restoreFailed bool) {
 		}
 	}
 
+	if restoreFailed && MustGetFlagInt(options.MAX_PER_HOST) > 0 && globalCluster != nil {
+		// Removes the slot directories left by an interrupted data load
+		utils.CollectHostSlotUsage(globalCluster, globalFPInfo)
+	}
+	if restoreFailed && encodingConversion != nil && encodingConversion.Method == report.ENCODING_CONVERSION_HELPER && globalCluster != nil {
+		// Removes the count directories left by an interrupted data load
+		utils.CollectConversionErrorCounts(globalCluster, globalFPInfo)
+	}
+	if restoreFailed && backupConfig != nil && !backupConfig.MetadataOnly && globalCluster != nil {
+		utils.CollectLoadByteCounts(globalCluster, globalFPInfo)
+	}
+
+	if len(droppedIndexes) > 0 {
+		if wasTerminated {
+			gplog.Warn("Indexes dropped for the data load were not re-created. Their definitions are saved in %s, and the next restore of this backup with --%s will re-create them.",
+				globalFPInfo.GetIndexJournalFilePath(), options.REBUILD_INDEXES)
+		} else {
+			RecreateIndexes()
+		}
+	}
+
 	if connectionPool != nil {
 		connectionPool.Close()
 	}
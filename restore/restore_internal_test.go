@@ -1,7 +1,20 @@
 package restore
 
 import (
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/filepath"
+	"github.com/greenplum-db/gpbackup/history"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -54,5 +67,158 @@ var _ = Describe("restore internal tests", func() {
 			}
 			Expect(statements).To(Equal(expectedStatements))
 		})
+		It("changes a quoted schema to a quoted redirect schema", func() {
+			for _, redirectSchema := range []string{"select", "Order", `My "Schema"`, "foo.bar", "foo'bar"} {
+				statements := []toc.StatementWithType{
+					{
+						Schema: `"Select"`, Name: `"Order"`, ObjectType: "TABLE",
+						Statement: "\n\nCREATE TABLE \"Select\".\"Order\" (\n\ti integer\n) DISTRIBUTED BY (i);\n",
+					},
+				}
+
+				quotedSchema := utils.QuoteIdentifier(redirectSchema)
+				editStatementsRedirectSchema(statements, quotedSchema)
+
+				Expect(statements[0].Schema).To(Equal(quotedSchema))
+				Expect(statements[0].Statement).To(Equal("\n\nCREATE TABLE " + quotedSchema + ".\"Order\" (\n\ti integer\n) DISTRIBUTED BY (i);\n"))
+			}
+		})
+	})
+	Describe("canRetryTableData", func() {
+		BeforeEach(func() {
+			backupConfig = &history.BackupConfig{}
+			errorTablesMetadata = make(map[string]Empty)
+		})
+		It("retries a table created by a full restore", func() {
+			Expect(canRetryTableData("public.foo")).To(BeTrue())
+		})
+		It("does not retry a single data file restore", func() {
+			backupConfig.SingleDataFile = true
+			Expect(canRetryTableData("public.foo")).To(BeFalse())
+		})
+		It("does not retry a data-only restore into existing tables", func() {
+			_ = cmdFlags.Set(options.DATA_ONLY, "true")
+			Expect(canRetryTableData("public.foo")).To(BeFalse())
+		})
+		It("retries a data-only restore when --truncate-table is set", func() {
+			_ = cmdFlags.Set(options.DATA_ONLY, "true")
+			_ = cmdFlags.Set(options.TRUNCATE_TABLE, "true")
+			Expect(canRetryTableData("public.foo")).To(BeTrue())
+		})
+		It("does not retry a table whose metadata failed to restore with --on-error-continue", func() {
+			_ = cmdFlags.Set(options.ON_ERROR_CONTINUE, "true")
+			errorTablesMetadata["public.foo"] = Empty{}
+			Expect(canRetryTableData("public.foo")).To(BeFalse())
+			Expect(canRetryTableData("public.bar")).To(BeTrue())
+		})
+	})
+	Describe("preparePredataRewrites", func() {
+		var (
+			mock             sqlmock.Sqlmock
+			tempDir          string
+			metadataFilename string
+			entries          []toc.MetadataEntry
+		)
+		fooTable := "\n\nCREATE TABLE public.foo (\n\tj jsonb DEFAULT '{}'::json\n) DISTRIBUTED RANDOMLY;\n"
+		fooView := "\n\nCREATE VIEW public.fooview AS SELECT 1;\n"
+		BeforeEach(func() {
+			connectionPool, mock = testhelper.CreateAndConnectMockDB(1)
+			tempDir, _ = ioutil.TempDir("", "gprestore_predata_test")
+			metadataFilename = tempDir + "/metadata.sql"
+			Expect(ioutil.WriteFile(metadataFilename, []byte(fooTable+fooView), 0644)).To(Succeed())
+			entries = []toc.MetadataEntry{
+				{Schema: "public", Name: "foo", ObjectType: "TABLE", StartByte: 0, EndByte: uint64(len(fooTable))},
+				{Schema: "public", Name: "fooview", ObjectType: "VIEW", StartByte: uint64(len(fooTable)), EndByte: uint64(len(fooTable + fooView))},
+			}
+			globalTOC = &toc.TOC{ColumnDefaults: []toc.ColumnDefaultEntry{{Schema: "public", Table: "foo", Column: "j", Type: "jsonb", Default: "'{}'::json"}}}
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		It("leaves statements unchanged when no rewrites are requested", func() {
+			statement := toc.StatementWithType{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: fooTable}
+			Expect(preparePredataRewrites(entries, metadataFilename)(&statement)).To(Succeed())
+			Expect(statement.Statement).To(Equal(fooTable))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("validates every table before returning and then rewrites each statement on its own", func() {
+			_ = cmdFlags.Set(options.VALIDATE_DEFAULTS, "true")
+			_ = cmdFlags.Set(options.STORAGE_OVERRIDE, "appendonly=true")
+			mock.ExpectExec(regexp.QuoteMeta("PREPARE gprestore_validate_default AS SELECT ('{}'::json)::jsonb")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("DEALLOCATE gprestore_validate_default").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("CREATE TEMPORARY TABLE gprestore_validate_storage (i integer) WITH (appendonly=true)")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("DROP TABLE gprestore_validate_storage").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			rewriteStatement := preparePredataRewrites(entries, metadataFilename)
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+
+			table := toc.StatementWithType{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: fooTable}
+			view := toc.StatementWithType{Schema: "public", Name: "fooview", ObjectType: "VIEW", Statement: fooView}
+			Expect(rewriteStatement(&table)).To(Succeed())
+			Expect(rewriteStatement(&view)).To(Succeed())
+			Expect(table.Statement).To(Equal("\n\nCREATE TABLE public.foo (\n\tj jsonb DEFAULT '{}'::json\n) WITH (appendonly=true) DISTRIBUTED RANDOMLY;\n"))
+			Expect(view.Statement).To(Equal(fooView))
+		})
+	})
+	Describe("index rebuilds", func() {
+		var (
+			mock     sqlmock.Sqlmock
+			tempDir  string
+			fooIndex = RebuildIndex{Schema: "public", Table: "foo", Name: "foo_idx", Definition: "CREATE INDEX foo_idx ON public.foo USING btree (i)"}
+			barIndex = RebuildIndex{Schema: "public", Table: "bar", Name: "bar_pkey", Definition: "CREATE UNIQUE INDEX bar_pkey ON public.bar USING btree (i)",
+				ConstraintName: "bar_pkey", ConstraintDef: "PRIMARY KEY (i)"}
+			header = []string{"schema", "table", "name", "definition", "tablespace", "constraintname", "constraintdef"}
+		)
+		BeforeEach(func() {
+			connectionPool, mock = testhelper.CreateAndConnectMockDB(1)
+			tempDir, _ = ioutil.TempDir("", "gprestore_index_test")
+			globalFPInfo = filepath.FilePathInfo{Timestamp: "20170101010101", SegDirMap: map[int]string{-1: tempDir}}
+			_ = os.MkdirAll(globalFPInfo.GetDirForContent(-1), 0755)
+			droppedIndexes = nil
+			indexRebuilds = nil
+			_ = cmdFlags.Set(options.CONSTRAINT_INDEXES, "true")
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(tempDir)
+		})
+		It("journals every index, drops them, and re-creates the dropped ones", func() {
+			rows := sqlmock.NewRows(header).
+				AddRow(fooIndex.Schema, fooIndex.Table, fooIndex.Name, fooIndex.Definition, "", "", "").
+				AddRow(barIndex.Schema, barIndex.Table, barIndex.Name, barIndex.Definition, "", barIndex.ConstraintName, barIndex.ConstraintDef)
+			mock.ExpectQuery("SELECT (.*)").WillReturnRows(rows)
+			mock.ExpectExec(regexp.QuoteMeta("DROP INDEX public.foo_idx;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE public.bar DROP CONSTRAINT bar_pkey;")).WillReturnError(&pgconn.PgError{Code: "2BP01", Message: "cannot drop constraint bar_pkey"})
+
+			DropIndexesForRebuild([]string{"public.foo", "public.bar"})
+			Expect(droppedIndexes).To(Equal([]RebuildIndex{fooIndex}))
+			Expect(indexRebuilds).To(HaveLen(1))
+			Expect(globalFPInfo.GetIndexJournalFilePath()).To(BeAnExistingFile())
+
+			mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX foo_idx ON public.foo USING btree (i);")).WillReturnResult(sqlmock.NewResult(0, 0))
+			RecreateIndexes()
+			Expect(droppedIndexes).To(BeEmpty())
+			Expect(indexRebuilds[0].Recreated).To(BeTrue())
+			Expect(globalFPInfo.GetIndexJournalFilePath()).ToNot(BeAnExistingFile())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("keeps the journal when an index cannot be re-created", func() {
+			droppedIndexes = []RebuildIndex{fooIndex}
+			indexRebuilds = make([]report.IndexRebuild, 1)
+			writeIndexJournal(droppedIndexes)
+			mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX foo_idx")).WillReturnError(errors.New("out of memory"))
+
+			RecreateIndexes()
+			Expect(indexRebuilds[0].Error).To(Equal("out of memory"))
+			Expect(globalFPInfo.GetIndexJournalFilePath()).To(BeAnExistingFile())
+		})
+		It("re-creates missing indexes from the journal of an earlier restore", func() {
+			writeIndexJournal([]RebuildIndex{fooIndex, barIndex})
+			mock.ExpectExec(regexp.QuoteMeta("CREATE INDEX foo_idx")).WillReturnError(&pgconn.PgError{Code: "42P07", Message: `relation "foo_idx" already exists`})
+			mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE public.bar ADD CONSTRAINT bar_pkey PRIMARY KEY (i);")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			RecoverIndexesFromJournal()
+			Expect(globalFPInfo.GetIndexJournalFilePath()).ToNot(BeAnExistingFile())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
 	})
 })
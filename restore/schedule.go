@@ -0,0 +1,171 @@
+package restore
+
+/*
+ * This file contains functions for ordering table data loads so that tables
+ * referenced by foreign keys in the restore database are loaded before the
+ * tables that reference them.
+ */
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * The tables in each batch are loaded in parallel, and a batch is only
+ * started once every table in the batch before it has been loaded.  If the
+ * foreign keys form a cycle, the tables in or depending on the cycle are
+ * loaded in a final batch and Cyclic is set.
+ */
+type DataSchedule struct {
+	Batches [][]toc.MasterDataEntry
+	Cyclic  bool
+}
+
+func (schedule DataSchedule) Entries() []toc.MasterDataEntry {
+	entries := make([]toc.MasterDataEntry, 0)
+	for _, batch := range schedule.Batches {
+		entries = append(entries, batch...)
+	}
+	return entries
+}
+
+func restoredTableName(entry toc.MasterDataEntry, redirectSchema string) string {
+	if redirectSchema != "" {
+		return utils.MakeFQN(redirectSchema, entry.Name)
+	}
+	return utils.MakeFQN(entry.Schema, entry.Name)
+}
+
+/*
+ * Returns the tables referenced by the foreign keys of each table in the
+ * restore database, keyed by the referencing table.  A table referencing
+ * itself does not constrain the load order and is left out.
+ */
+func GetForeignKeyReferences(connectionPool *dbconn.DBConn) map[string][]string {
+	query := `
+SELECT quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS referencing,
+	quote_ident(rn.nspname) || '.' || quote_ident(r.relname) AS referenced
+FROM pg_constraint con
+	JOIN pg_class c ON con.conrelid = c.oid
+	JOIN pg_namespace n ON c.relnamespace = n.oid
+	JOIN pg_class r ON con.confrelid = r.oid
+	JOIN pg_namespace rn ON r.relnamespace = rn.oid
+WHERE con.contype = 'f'
+	AND con.conrelid <> con.confrelid
+ORDER BY referencing, referenced`
+	results := make([]struct {
+		Referencing string
+		Referenced  string
+	}, 0)
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	references := make(map[string][]string)
+	for _, result := range results {
+		references[result.Referencing] = append(references[result.Referencing], result.Referenced)
+	}
+	return references
+}
+
+/*
+ * Orders the data entries into batches by their foreign keys.  Only
+ * references between tables being restored are considered, and the tables
+ * in each batch keep the order they had in the TOC.
+ */
+func ScheduleDataEntries(dataEntries []toc.MasterDataEntry, references map[string][]string, redirectSchema string) DataSchedule {
+	if len(references) == 0 {
+		return DataSchedule{Batches: [][]toc.MasterDataEntry{dataEntries}}
+	}
+	restoring := make(map[string]bool, len(dataEntries))
+	for _, entry := range dataEntries {
+		restoring[restoredTableName(entry, redirectSchema)] = true
+	}
+	loaded := make(map[string]bool, len(dataEntries))
+	remaining := dataEntries
+	schedule := DataSchedule{Batches: make([][]toc.MasterDataEntry, 0)}
+	for len(remaining) > 0 {
+		batch := make([]toc.MasterDataEntry, 0)
+		deferred := make([]toc.MasterDataEntry, 0)
+		for _, entry := range remaining {
+			ready := true
+			for _, referenced := range references[restoredTableName(entry, redirectSchema)] {
+				if restoring[referenced] && !loaded[referenced] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				batch = append(batch, entry)
+			} else {
+				deferred = append(deferred, entry)
+			}
+		}
+		if len(batch) == 0 {
+			schedule.Batches = append(schedule.Batches, deferred)
+			schedule.Cyclic = true
+			break
+		}
+		for _, entry := range batch {
+			loaded[restoredTableName(entry, redirectSchema)] = true
+		}
+		schedule.Batches = append(schedule.Batches, batch)
+		remaining = deferred
+	}
+	return schedule
+}
+
+func printDataSchedule(schedule DataSchedule) {
+	if len(schedule.Batches) < 2 {
+		return
+	}
+	gplog.Verbose("Table data will be restored in %d batches ordered by foreign key", len(schedule.Batches))
+	for i, batch := range schedule.Batches {
+		tableNames := make([]string, len(batch))
+		for j, entry := range batch {
+			tableNames[j] = restoredTableName(entry, opts.RedirectSchema)
+		}
+		label := fmt.Sprintf("Batch %d", i+1)
+		if schedule.Cyclic && i == len(schedule.Batches)-1 {
+			label += " (foreign key cycle)"
+		}
+		gplog.Verbose("%s: %s", label, strings.Join(tableNames, ", "))
+	}
+}
+
+func warnForeignKeyCycle(cyclicBatch []toc.MasterDataEntry) {
+	tableNames := make([]string, len(cyclicBatch))
+	for i, entry := range cyclicBatch {
+		tableNames[i] = restoredTableName(entry, opts.RedirectSchema)
+	}
+	if MustGetFlagBool(options.FK_CYCLE_REPLICA) {
+		gplog.Info("Restoring data for %d table(s) in or depending on a foreign key cycle with session_replication_role set to replica", len(tableNames))
+		return
+	}
+	report.Warn(report.WARN_FOREIGN_KEY_CYCLE, "", "The foreign keys of the following tables form a cycle, so their data may fail to load; use --%s to load them without checking foreign keys: %s",
+		options.FK_CYCLE_REPLICA, strings.Join(tableNames, ", "))
+}
+
+/*
+ * Foreign keys are checked by triggers, which setting session_replication_role
+ * to replica disables; only a superuser may set it.
+ */
+func ValidateReplicaRoleAllowed(connectionPool *dbconn.DBConn) {
+	isSuperuser := dbconn.MustSelectString(connectionPool, "SELECT CASE WHEN rolsuper THEN 'true' ELSE 'false' END AS string FROM pg_roles WHERE rolname = current_user")
+	if isSuperuser != "true" {
+		gplog.Fatal(errors.Errorf("The --%s flag requires superuser privileges.", options.FK_CYCLE_REPLICA), "")
+	}
+}
+
+func setReplicationRole(role string) {
+	for i := 0; i < connectionPool.NumConns; i++ {
+		connectionPool.MustExec(fmt.Sprintf("SET session_replication_role = %s", role), i)
+	}
+}
@@ -0,0 +1,90 @@
+package restore_test
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/schedule tests", func() {
+	orders := toc.MasterDataEntry{Schema: "public", Name: "orders", Oid: 1}
+	customers := toc.MasterDataEntry{Schema: "public", Name: "customers", Oid: 2}
+	items := toc.MasterDataEntry{Schema: "public", Name: "items", Oid: 3}
+	products := toc.MasterDataEntry{Schema: "public", Name: "products", Oid: 4}
+	audit := toc.MasterDataEntry{Schema: "public", Name: "audit", Oid: 5}
+	dataEntries := []toc.MasterDataEntry{orders, customers, items, products, audit}
+
+	Describe("GetForeignKeyReferences", func() {
+		It("groups the referenced tables by referencing table", func() {
+			rows := sqlmock.NewRows([]string{"referencing", "referenced"}).
+				AddRow("public.items", "public.orders").
+				AddRow("public.items", "public.products").
+				AddRow("public.orders", "public.customers")
+			mock.ExpectQuery("SELECT (.*) FROM pg_constraint").WillReturnRows(rows)
+			references := restore.GetForeignKeyReferences(connectionPool)
+			Expect(references).To(Equal(map[string][]string{
+				"public.items":  {"public.orders", "public.products"},
+				"public.orders": {"public.customers"},
+			}))
+		})
+	})
+	Describe("ScheduleDataEntries", func() {
+		It("restores all tables in one batch when there are no foreign keys", func() {
+			schedule := restore.ScheduleDataEntries(dataEntries, map[string][]string{}, "")
+			Expect(schedule.Batches).To(Equal([][]toc.MasterDataEntry{dataEntries}))
+			Expect(schedule.Cyclic).To(BeFalse())
+		})
+		It("restores referenced tables in batches before the tables referencing them", func() {
+			references := map[string][]string{
+				"public.items":  {"public.orders", "public.products"},
+				"public.orders": {"public.customers"},
+			}
+			schedule := restore.ScheduleDataEntries(dataEntries, references, "")
+			Expect(schedule.Batches).To(Equal([][]toc.MasterDataEntry{
+				{customers, products, audit},
+				{orders},
+				{items},
+			}))
+			Expect(schedule.Cyclic).To(BeFalse())
+			Expect(schedule.Entries()).To(Equal([]toc.MasterDataEntry{customers, products, audit, orders, items}))
+		})
+		It("ignores references to tables that are not being restored", func() {
+			references := map[string][]string{"public.orders": {"public.customers"}}
+			schedule := restore.ScheduleDataEntries([]toc.MasterDataEntry{orders, items}, references, "")
+			Expect(schedule.Batches).To(Equal([][]toc.MasterDataEntry{{orders, items}}))
+		})
+		It("restores the tables in or depending on a cycle in a final batch", func() {
+			references := map[string][]string{
+				"public.orders":    {"public.customers"},
+				"public.customers": {"public.orders"},
+				"public.items":     {"public.orders"},
+			}
+			schedule := restore.ScheduleDataEntries(dataEntries, references, "")
+			Expect(schedule.Batches).To(Equal([][]toc.MasterDataEntry{
+				{products, audit},
+				{orders, customers, items},
+			}))
+			Expect(schedule.Cyclic).To(BeTrue())
+		})
+		It("matches references against the tables in the redirect schema", func() {
+			references := map[string][]string{"other.orders": {"other.customers"}}
+			schedule := restore.ScheduleDataEntries([]toc.MasterDataEntry{orders, customers}, references, "other")
+			Expect(schedule.Batches).To(Equal([][]toc.MasterDataEntry{{customers}, {orders}}))
+		})
+	})
+	Describe("ValidateReplicaRoleAllowed", func() {
+		It("does not panic for a superuser", func() {
+			mock.ExpectQuery("SELECT (.*) FROM pg_roles").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("true"))
+			restore.ValidateReplicaRoleAllowed(connectionPool)
+		})
+		It("panics for a user who is not a superuser", func() {
+			mock.ExpectQuery("SELECT (.*) FROM pg_roles").WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("false"))
+			defer testhelper.ShouldPanicWithMessage("The --replica-role-on-fk-cycle flag requires superuser privileges.")
+			restore.ValidateReplicaRoleAllowed(connectionPool)
+		})
+	})
+})
@@ -0,0 +1,380 @@
+package restore
+
+/*
+ * This file contains functions for overriding the storage options given in
+ * the WITH clause of the tables created during restore.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/dbconn"
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	STORAGE_OVERRIDE_MERGE   = "merge"
+	STORAGE_OVERRIDE_REPLACE = "replace"
+)
+
+var (
+	// External and foreign tables are created with other statements and are never matched
+	createTableRegex = regexp.MustCompile(`^\s*CREATE (?:UNLOGGED )?TABLE `)
+	withClauseRegex  = regexp.MustCompile(`WITH \(((?:[^()']|'(?:[^']|'')*')*)\)`)
+	// The clauses that come between the column list and the WITH clause of a table
+	preWithClauseRegex = regexp.MustCompile(`^ (?:INHERITS \((?:[^()"]|"(?:[^"]|"")*")*\) )?(?:USING \S+ )?`)
+)
+
+/*
+ * Overrides applies to every table created, except for those given their own
+ * options in ByTable, keyed by the table's name as it was backed up.
+ */
+type StorageOverrides struct {
+	Mode    string
+	Options []string
+	ByTable map[string][]string
+}
+
+func (overrides StorageOverrides) ForTable(fqn string) []string {
+	if tableOptions, ok := overrides.ByTable[fqn]; ok {
+		return tableOptions
+	}
+	return overrides.Options
+}
+
+/*
+ * Splits a comma-separated list of storage options, such as
+ * "appendonly=true, compresstype=zstd", into its options.
+ */
+func ParseStorageOptions(optionStr string) ([]string, error) {
+	storageOptions := make([]string, 0)
+	if strings.TrimSpace(optionStr) == "" {
+		return storageOptions, nil
+	}
+	inQuotes := false
+	start := 0
+	for i := 0; i <= len(optionStr); i++ {
+		if i < len(optionStr) {
+			if optionStr[i] == '\'' {
+				inQuotes = !inQuotes
+			}
+			if optionStr[i] != ',' || inQuotes {
+				continue
+			}
+		}
+		option := strings.TrimSpace(optionStr[start:i])
+		equals := strings.Index(option, "=")
+		if equals < 1 || strings.TrimSpace(option[equals+1:]) == "" {
+			return nil, errors.Errorf("Invalid storage option %s.  Storage options must be given as name=value.", option)
+		}
+		storageOptions = append(storageOptions, option)
+		start = i + 1
+	}
+	return storageOptions, nil
+}
+
+func storageOptionName(option string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(option, "=", 2)[0]))
+}
+
+/*
+ * Options in the override take the place of source options of the same name.
+ * In replace mode, all other source options are dropped, except tablename,
+ * which names a partition of a table created with its partitions.
+ */
+func applyStorageOverrides(source []string, overrides []string, mode string) []string {
+	overridden := make(map[string]bool, len(overrides))
+	for _, option := range overrides {
+		overridden[storageOptionName(option)] = true
+	}
+	result := make([]string, 0, len(source)+len(overrides))
+	for _, option := range source {
+		name := storageOptionName(option)
+		if overridden[name] || (mode == STORAGE_OVERRIDE_REPLACE && name != "tablename") {
+			continue
+		}
+		result = append(result, option)
+	}
+	return append(result, overrides...)
+}
+
+// Returns the index of the parenthesis closing the column list of a CREATE TABLE statement
+func findColumnListEnd(statement string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(statement); i++ {
+		char := statement[i]
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+/*
+ * Rewrites the WITH clause of a CREATE TABLE statement, adding one if the
+ * table has none, along with the WITH clauses of any partitions defined in the
+ * statement.  Other statements are returned unchanged.
+ */
+func OverrideStorageOptions(statement string, overrides []string, mode string) (string, error) {
+	if len(overrides) == 0 || !createTableRegex.MatchString(statement) {
+		return statement, nil
+	}
+	columnListEnd := findColumnListEnd(statement)
+	if columnListEnd == -1 {
+		return statement, nil
+	}
+	tail := statement[columnListEnd+1:]
+	var err error
+	rewriteClause := func(clause string) string {
+		source, parseErr := ParseStorageOptions(withClauseRegex.FindStringSubmatch(clause)[1])
+		if parseErr != nil {
+			err = parseErr
+			return clause
+		}
+		return fmt.Sprintf("WITH (%s)", strings.Join(applyStorageOverrides(source, overrides, mode), ", "))
+	}
+
+	withStart := len(preWithClauseRegex.FindString(tail))
+	tableClause := withClauseRegex.FindStringIndex(tail[withStart:])
+	var rewrittenTail string
+	if tableClause != nil && tableClause[0] == 0 {
+		withEnd := withStart + tableClause[1]
+		rewrittenTail = tail[:withStart] + rewriteClause(tail[withStart:withEnd]) +
+			withClauseRegex.ReplaceAllStringFunc(tail[withEnd:], rewriteClause)
+	} else {
+		rewrittenTail = tail[:withStart] + fmt.Sprintf("WITH (%s) ", strings.Join(overrides, ", ")) +
+			withClauseRegex.ReplaceAllStringFunc(tail[withStart:], rewriteClause)
+	}
+	if err != nil {
+		return "", err
+	}
+	return statement[:columnListEnd+1] + rewrittenTail, nil
+}
+
+/*
+ * Removes the oids option from the WITH clauses of a CREATE TABLE statement,
+ * dropping any clause left empty, as GPDB 7 no longer accepts it.  Other
+ * statements are returned unchanged.
+ */
+func RemoveOidsOption(statement string) string {
+	if !createTableRegex.MatchString(statement) {
+		return statement
+	}
+	columnListEnd := findColumnListEnd(statement)
+	if columnListEnd == -1 {
+		return statement
+	}
+	tail := statement[columnListEnd+1:]
+	var rewrittenTail strings.Builder
+	last := 0
+	for _, match := range withClauseRegex.FindAllStringSubmatchIndex(tail, -1) {
+		source, err := ParseStorageOptions(tail[match[2]:match[3]])
+		if err != nil {
+			continue
+		}
+		kept := make([]string, 0, len(source))
+		for _, option := range source {
+			if storageOptionName(option) != "oids" {
+				kept = append(kept, option)
+			}
+		}
+		if len(kept) == len(source) {
+			continue
+		}
+		rewrittenTail.WriteString(tail[last:match[0]])
+		last = match[1]
+		if len(kept) > 0 {
+			rewrittenTail.WriteString(fmt.Sprintf("WITH (%s)", strings.Join(kept, ", ")))
+		} else if last < len(tail) && tail[last] == ' ' {
+			last++
+		}
+	}
+	if last == 0 {
+		return statement
+	}
+	rewrittenTail.WriteString(tail[last:])
+	return statement[:columnListEnd+1] + rewrittenTail.String()
+}
+
+/*
+ * Tables backed up WITH OIDS are restored without them to GPDB 7, and each
+ * one is reported, though the values of the oid column are lost either way.
+ */
+func usesOidsRemoval() bool {
+	return connectionPool.Version.AtLeast("7") && len(globalTOC.TablesWithOids) > 0
+}
+
+func removeTableOids(statement *toc.StatementWithType) {
+	if statement.ObjectType != "TABLE" {
+		return
+	}
+	rewritten := RemoveOidsOption(statement.Statement)
+	if rewritten == statement.Statement {
+		return
+	}
+	statement.Statement = rewritten
+	fqn := utils.MakeFQN(statement.Schema, statement.Name)
+	report.Warn(report.WARN_OIDS_REMOVED, fqn, "Table %s was backed up WITH OIDS, which GPDB %s does not support; it will be restored without OIDS, and the values of its oid column are not preserved",
+		fqn, connectionPool.Version.VersionString)
+	oidsRemovedTables = append(oidsRemovedTables, fqn)
+}
+
+/*
+ * The override file is a YAML map of tables, named as they were backed up,
+ * to the storage options to give them in place of --storage-option-override,
+ * e.g.
+ *
+ * tables:
+ *   public.sales: "appendonly=true, orientation=column, compresstype=zstd"
+ */
+func ReadStorageOverrideFile(filename string) (map[string][]string, error) {
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Tables map[string]string `yaml:"tables"`
+	}{}
+	err = yaml.UnmarshalStrict(contents, &config)
+	if err != nil {
+		return nil, errors.Errorf("Storage option override file %s is formatted incorrectly: %v", filename, err)
+	}
+	byTable := make(map[string][]string, len(config.Tables))
+	for table, optionStr := range config.Tables {
+		tableOptions, err := ParseStorageOptions(optionStr)
+		if err != nil {
+			return nil, errors.Errorf("Storage option override for table %s is invalid: %v", table, err)
+		}
+		byTable[table] = tableOptions
+	}
+	return byTable, nil
+}
+
+func usesStorageOverrides() bool {
+	return MustGetFlagString(options.STORAGE_OVERRIDE) != "" || MustGetFlagString(options.STORAGE_OVERRIDE_FILE) != ""
+}
+
+func getStorageOverrides() StorageOverrides {
+	overrides := StorageOverrides{Mode: MustGetFlagString(options.STORAGE_OVERRIDE_MODE)}
+	var err error
+	overrides.Options, err = ParseStorageOptions(MustGetFlagString(options.STORAGE_OVERRIDE))
+	gplog.FatalOnError(err)
+	if overrideFile := MustGetFlagString(options.STORAGE_OVERRIDE_FILE); overrideFile != "" {
+		overrides.ByTable, err = ReadStorageOverrideFile(overrideFile)
+		gplog.FatalOnError(err)
+	}
+	return overrides
+}
+
+/*
+ * Applies the storage option overrides to a CREATE TABLE statement, returning
+ * the storage option lists of the rewritten statement.
+ */
+func RewriteTableStorageOptions(statement *toc.StatementWithType, overrides StorageOverrides) ([]string, error) {
+	if statement.ObjectType != "TABLE" {
+		return nil, nil
+	}
+	fqn := utils.MakeFQN(statement.Schema, statement.Name)
+	rewritten, err := OverrideStorageOptions(statement.Statement, overrides.ForTable(fqn), overrides.Mode)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not override storage options of table %s", fqn)
+	}
+	if rewritten == statement.Statement {
+		return nil, nil
+	}
+	statement.Statement = rewritten
+	clauses := make([]string, 0)
+	columnListEnd := findColumnListEnd(rewritten)
+	for _, match := range withClauseRegex.FindAllStringSubmatch(rewritten[columnListEnd+1:], -1) {
+		clauses = append(clauses, match[1])
+	}
+	return clauses, nil
+}
+
+/*
+ * Returns the distinct storage option lists of the rewritten statements, so
+ * that each can be validated once.
+ */
+func RewriteStorageOptions(statements []toc.StatementWithType, overrides StorageOverrides) ([]string, error) {
+	clauses := make(map[string]bool)
+	for i := range statements {
+		tableClauses, err := RewriteTableStorageOptions(&statements[i], overrides)
+		if err != nil {
+			return nil, err
+		}
+		for _, clause := range tableClauses {
+			clauses[clause] = true
+		}
+	}
+	return sortedClauses(clauses), nil
+}
+
+func sortedClauses(clauses map[string]bool) []string {
+	distinctClauses := make([]string, 0, len(clauses))
+	for clause := range clauses {
+		distinctClauses = append(distinctClauses, clause)
+	}
+	sort.Strings(distinctClauses)
+	return distinctClauses
+}
+
+/*
+ * Each storage option list is checked by creating and dropping a temporary
+ * table with it, so that an invalid combination of options is reported
+ * before any tables are created.  Options naming a partition cannot be given
+ * to a table on its own and are left out.
+ */
+func ValidateStorageOptions(connectionPool *dbconn.DBConn, clauses []string) {
+	if len(clauses) == 0 {
+		return
+	}
+	gplog.Info("Validating storage option overrides")
+	invalidClauses := make([]string, 0)
+	for _, clause := range clauses {
+		storageOptions, _ := ParseStorageOptions(clause)
+		tableOptions := make([]string, 0, len(storageOptions))
+		for _, option := range storageOptions {
+			if storageOptionName(option) != "tablename" {
+				tableOptions = append(tableOptions, option)
+			}
+		}
+		query := fmt.Sprintf("CREATE TEMPORARY TABLE gprestore_validate_storage (i integer) WITH (%s) DISTRIBUTED RANDOMLY", strings.Join(tableOptions, ", "))
+		_, err := connectionPool.Exec(query, 0)
+		if err == nil {
+			_, err = connectionPool.Exec("DROP TABLE gprestore_validate_storage", 0)
+			gplog.FatalOnError(err)
+			continue
+		}
+		invalidClauses = append(invalidClauses, fmt.Sprintf("WITH (%s): %v", clause, err))
+	}
+	if len(invalidClauses) > 0 {
+		for _, invalidClause := range invalidClauses {
+			gplog.Error("%s", invalidClause)
+		}
+		gplog.Fatal(errors.Errorf("Found %d invalid storage option combination(s) after applying --%s and --%s.",
+			len(invalidClauses), options.STORAGE_OVERRIDE, options.STORAGE_OVERRIDE_FILE), "")
+	}
+}
@@ -0,0 +1,133 @@
+package restore_test
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/storage tests", func() {
+	zstdOptions := []string{"appendonly=true", "compresstype=zstd", "compresslevel=3"}
+	Describe("ParseStorageOptions", func() {
+		It("splits options on commas outside of quotes", func() {
+			storageOptions, err := restore.ParseStorageOptions("appendonly=true, tablename='a,b'")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(storageOptions).To(Equal([]string{"appendonly=true", "tablename='a,b'"}))
+		})
+		It("returns an error for an option without a value", func() {
+			_, err := restore.ParseStorageOptions("appendonly=true,compresstype")
+			Expect(err).To(MatchError("Invalid storage option compresstype.  Storage options must be given as name=value."))
+		})
+	})
+	Describe("OverrideStorageOptions", func() {
+		It("merges overrides with the options of the table", func() {
+			statement := "\n\nCREATE TABLE public.foo (\n\ti integer\n) WITH (appendonly=true, orientation=column, compresstype=zlib) DISTRIBUTED BY (i);"
+			result, err := restore.OverrideStorageOptions(statement, zstdOptions, restore.STORAGE_OVERRIDE_MERGE)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("\n\nCREATE TABLE public.foo (\n\ti integer\n) WITH (orientation=column, appendonly=true, compresstype=zstd, compresslevel=3) DISTRIBUTED BY (i);"))
+		})
+		It("replaces the options of the table", func() {
+			statement := "\n\nCREATE TABLE public.foo (\n\ti integer\n) WITH (appendonly=true, orientation=column, compresstype=zlib) DISTRIBUTED BY (i);"
+			result, err := restore.OverrideStorageOptions(statement, zstdOptions, restore.STORAGE_OVERRIDE_REPLACE)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("\n\nCREATE TABLE public.foo (\n\ti integer\n) WITH (appendonly=true, compresstype=zstd, compresslevel=3) DISTRIBUTED BY (i);"))
+		})
+		It("adds a WITH clause after INHERITS to a table without one", func() {
+			statement := "\n\nCREATE TABLE public.foo (\n\ti integer DEFAULT length('a)')\n) INHERITS (public.bar) TABLESPACE test_tablespace DISTRIBUTED RANDOMLY;"
+			result, err := restore.OverrideStorageOptions(statement, zstdOptions, restore.STORAGE_OVERRIDE_MERGE)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("\n\nCREATE TABLE public.foo (\n\ti integer DEFAULT length('a)')\n) INHERITS (public.bar) WITH (appendonly=true, compresstype=zstd, compresslevel=3) TABLESPACE test_tablespace DISTRIBUTED RANDOMLY;"))
+		})
+		It("overrides the options of partitions defined with the table, keeping their names", func() {
+			statement := "\n\nCREATE TABLE public.part (\n\ti integer\n) DISTRIBUTED BY (i) PARTITION BY RANGE(i) (START (1) END (5) WITH (tablename='part_1_prt_1', appendonly=false));"
+			result, err := restore.OverrideStorageOptions(statement, zstdOptions, restore.STORAGE_OVERRIDE_REPLACE)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("\n\nCREATE TABLE public.part (\n\ti integer\n) WITH (appendonly=true, compresstype=zstd, compresslevel=3) DISTRIBUTED BY (i) PARTITION BY RANGE(i) (START (1) END (5) WITH (tablename='part_1_prt_1', appendonly=true, compresstype=zstd, compresslevel=3));"))
+		})
+		It("does not change external or foreign tables", func() {
+			for _, statement := range []string{
+				"\n\nCREATE READABLE EXTERNAL TABLE public.ext (\n\ti integer\n) LOCATION (\n\t'file://host/tmp/ext'\n) FORMAT 'text';",
+				"\n\nCREATE FOREIGN TABLE public.ft (\n\ti integer\n) SERVER fs;",
+			} {
+				result, err := restore.OverrideStorageOptions(statement, zstdOptions, restore.STORAGE_OVERRIDE_MERGE)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(result).To(Equal(statement))
+			}
+		})
+	})
+	Describe("RemoveOidsOption", func() {
+		It("removes the oids option, keeping the other options of the table", func() {
+			statement := "\n\nCREATE TABLE public.foo (\n\ti integer\n) WITH (fillfactor=50, oids=true) DISTRIBUTED BY (i);"
+			Expect(restore.RemoveOidsOption(statement)).To(Equal("\n\nCREATE TABLE public.foo (\n\ti integer\n) WITH (fillfactor=50) DISTRIBUTED BY (i);"))
+		})
+		It("removes a WITH clause left empty", func() {
+			statement := "\n\nCREATE TABLE public.foo (\n\ti integer DEFAULT length('WITH (oids=true)')\n) INHERITS (public.bar) WITH (oids=true) TABLESPACE test_tablespace DISTRIBUTED RANDOMLY;"
+			Expect(restore.RemoveOidsOption(statement)).To(Equal("\n\nCREATE TABLE public.foo (\n\ti integer DEFAULT length('WITH (oids=true)')\n) INHERITS (public.bar) TABLESPACE test_tablespace DISTRIBUTED RANDOMLY;"))
+		})
+		It("does not change a table without OIDS", func() {
+			statement := "\n\nCREATE TABLE public.foo (\n\ti integer\n) WITH (appendonly=true) DISTRIBUTED BY (i);"
+			Expect(restore.RemoveOidsOption(statement)).To(Equal(statement))
+		})
+	})
+	Describe("ReadStorageOverrideFile", func() {
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		It("reads the options for each table", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte("tables:\n  public.foo: \"appendonly=true, orientation=column\"\n"), nil
+			}
+			byTable, err := restore.ReadStorageOverrideFile("/tmp/storage.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(byTable).To(Equal(map[string][]string{"public.foo": {"appendonly=true", "orientation=column"}}))
+		})
+		It("returns an error for invalid options", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte("tables:\n  public.foo: \"appendonly\"\n"), nil
+			}
+			_, err := restore.ReadStorageOverrideFile("/tmp/storage.yaml")
+			Expect(err).To(MatchError(ContainSubstring("Storage option override for table public.foo is invalid")))
+		})
+	})
+	Describe("RewriteStorageOptions", func() {
+		It("applies per-table overrides in place of the global override and returns the distinct option lists", func() {
+			statements := []toc.StatementWithType{
+				{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "CREATE TABLE public.foo (\n\ti integer\n) DISTRIBUTED RANDOMLY;"},
+				{Schema: "public", Name: "bar", ObjectType: "TABLE", Statement: "CREATE TABLE public.bar (\n\ti integer\n) DISTRIBUTED RANDOMLY;"},
+				{Schema: "public", Name: "baz", ObjectType: "VIEW", Statement: "CREATE VIEW public.baz AS SELECT 1;"},
+			}
+			overrides := restore.StorageOverrides{
+				Mode:    restore.STORAGE_OVERRIDE_MERGE,
+				Options: zstdOptions,
+				ByTable: map[string][]string{"public.bar": {"appendonly=false"}},
+			}
+			clauses, err := restore.RewriteStorageOptions(statements, overrides)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clauses).To(Equal([]string{"appendonly=false", "appendonly=true, compresstype=zstd, compresslevel=3"}))
+			Expect(statements[0].Statement).To(Equal("CREATE TABLE public.foo (\n\ti integer\n) WITH (appendonly=true, compresstype=zstd, compresslevel=3) DISTRIBUTED RANDOMLY;"))
+			Expect(statements[1].Statement).To(Equal("CREATE TABLE public.bar (\n\ti integer\n) WITH (appendonly=false) DISTRIBUTED RANDOMLY;"))
+			Expect(statements[2].Statement).To(Equal("CREATE VIEW public.baz AS SELECT 1;"))
+		})
+	})
+	Describe("ValidateStorageOptions", func() {
+		It("creates and drops a temporary table for each option list", func() {
+			mock.ExpectExec(regexp.QuoteMeta("CREATE TEMPORARY TABLE gprestore_validate_storage (i integer) WITH (appendonly=true) DISTRIBUTED RANDOMLY")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("DROP TABLE gprestore_validate_storage").WillReturnResult(sqlmock.NewResult(0, 0))
+			restore.ValidateStorageOptions(connectionPool, []string{"tablename='foo_1_prt_1', appendonly=true"})
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("panics if an option list is invalid", func() {
+			mock.ExpectExec("CREATE TEMPORARY TABLE").WillReturnError(errors.New(`invalid value for option "compresstype"`))
+			defer testhelper.ShouldPanicWithMessage("Found 1 invalid storage option combination(s)")
+			restore.ValidateStorageOptions(connectionPool, []string{"appendonly=true, compresstype=bogus"})
+		})
+	})
+})
@@ -0,0 +1,110 @@
+package restore
+
+/*
+ * This file contains functions for restoring tablespaces to a different
+ * location than the one they were backed up from.
+ */
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/iohelper"
+	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * --tablespace-location-map entries, and the lines of
+ * --tablespace-location-map-file, are given as name:/new/path with tablespace
+ * names matched exactly as given, or in double quotes as in SQL, which are
+ * quoted the way gpbackup quoted them in the metadata file.
+ */
+func ParseTablespaceLocationMap(entries []string, filename string) map[string]string {
+	if filename != "" {
+		lines, err := iohelper.ReadLinesFromFile(filename)
+		gplog.FatalOnError(err)
+		for _, line := range lines {
+			if strings.TrimSpace(line) != "" {
+				entries = append(entries, line)
+			}
+		}
+	}
+	locationMap := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		name, location, ok := utils.SplitMapEntry(entry)
+		if !ok || name == "" || !path.IsAbs(location) || strings.Contains(location, "'") {
+			gplog.Fatal(errors.Errorf("Invalid --%s entry %s.  Entries must be of the form name:/new/path, with an absolute path that does not contain a single quote.",
+				options.TABLESPACE_MAP, entry), "")
+		}
+		locationMap[utils.QuoteIdentifier(name)] = path.Clean(location)
+	}
+	return locationMap
+}
+
+/*
+ * Tablespaces backed up from GPDB 6 and later are created with a LOCATION
+ * clause.  Those backed up from earlier versions are created in a filespace,
+ * which has no location to remap.
+ */
+func isCreateTablespaceWithLocation(statement toc.StatementWithType) bool {
+	return statement.ObjectType == "TABLESPACE" &&
+		strings.HasPrefix(strings.TrimSpace(statement.Statement), fmt.Sprintf("CREATE TABLESPACE %s LOCATION ", statement.Name))
+}
+
+/*
+ * Every tablespace in the backup must be mapped, so that none is created at
+ * its original location alongside the remapped ones, and every mapped
+ * location must exist on all hosts before any tablespace is created.
+ */
+func ValidateTablespaceLocations(statements []toc.StatementWithType, locationMap map[string]string) {
+	unmapped := make([]string, 0)
+	inBackup := make(map[string]bool)
+	for _, statement := range statements {
+		if !isCreateTablespaceWithLocation(statement) {
+			continue
+		}
+		inBackup[statement.Name] = true
+		if _, ok := locationMap[statement.Name]; !ok {
+			unmapped = append(unmapped, statement.Name)
+		}
+	}
+	if len(unmapped) > 0 {
+		gplog.Fatal(errors.Errorf("The following tablespace(s) have no --%s entry: %s",
+			options.TABLESPACE_MAP, strings.Join(unmapped, ", ")), "")
+	}
+
+	locations := make([]string, 0, len(locationMap))
+	for name, location := range locationMap {
+		if !inBackup[name] {
+			report.Warn(report.WARN_MISSING_FILTER_OBJECT, name, "Tablespace %s given in --%s is not in the backup", name, options.TABLESPACE_MAP)
+			continue
+		}
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	VerifyTablespaceLocationsExistOnAllHosts(locations)
+}
+
+/*
+ * A remapped tablespace is created at the same location on every segment, so
+ * any per-segment locations given in the WITH clause of the backed up
+ * statement are dropped along with the original location.
+ */
+func SubstituteTablespaceLocations(statements []toc.StatementWithType, locationMap map[string]string) []toc.StatementWithType {
+	for i, statement := range statements {
+		location, ok := locationMap[statement.Name]
+		if !ok || !isCreateTablespaceWithLocation(statement) {
+			continue
+		}
+		leading := statement.Statement[:len(statement.Statement)-len(strings.TrimLeft(statement.Statement, " \t\n"))]
+		statements[i].Statement = fmt.Sprintf("%sCREATE TABLESPACE %s LOCATION '%s';", leading, statement.Name, location)
+	}
+	return statements
+}
@@ -0,0 +1,98 @@
+package restore_test
+
+import (
+	"os"
+
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/toc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/tablespace tests", func() {
+	segmentTablespace := toc.StatementWithType{ObjectType: "TABLESPACE", Name: "fast_disk",
+		Statement: "\n\nCREATE TABLESPACE fast_disk LOCATION '/data/fast'\n\tWITH (content0='/data/fast0', content1='/data/fast1');"}
+	quotedTablespace := toc.StatementWithType{ObjectType: "TABLESPACE", Name: `"Slow Disk"`,
+		Statement: "\n\nCREATE TABLESPACE \"Slow Disk\" LOCATION '/data/slow';"}
+	tablespaceOptions := toc.StatementWithType{ObjectType: "TABLESPACE", Name: "fast_disk",
+		Statement: "\n\nALTER TABLESPACE fast_disk SET (seq_page_cost=1);\n"}
+	filespaceTablespace := toc.StatementWithType{ObjectType: "TABLESPACE", Name: "old_disk",
+		Statement: "\n\nCREATE TABLESPACE old_disk FILESPACE old_filespace;"}
+
+	Describe("ParseTablespaceLocationMap", func() {
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		It("quotes tablespace names and cleans locations", func() {
+			locationMap := restore.ParseTablespaceLocationMap([]string{"fast_disk:/new/fast/", "Slow Disk:/new/slow"}, "")
+			Expect(locationMap).To(Equal(map[string]string{"fast_disk": "/new/fast", `"Slow Disk"`: "/new/slow"}))
+		})
+		It("matches mixed-case and quoted tablespace names exactly", func() {
+			locationMap := restore.ParseTablespaceLocationMap([]string{"Fast_Disk:/new/fast", `"slow_disk":/new/slow`, `"Archive:2019":/new/archive`}, "")
+			Expect(locationMap).To(Equal(map[string]string{`"Fast_Disk"`: "/new/fast", "slow_disk": "/new/slow", `"Archive:2019"`: "/new/archive"}))
+		})
+		It("reads entries from a file in addition to the flag", func() {
+			r, w, _ := os.Pipe()
+			_, _ = w.WriteString("fast_disk:/new/fast\n\nSlow Disk:/new/slow\n")
+			_ = w.Close()
+			operating.System.OpenFileRead = func(name string, flag int, perm os.FileMode) (operating.ReadCloserAt, error) { return r, nil }
+			locationMap := restore.ParseTablespaceLocationMap([]string{"old_disk:/new/old"}, "/tmp/tablespaces")
+			Expect(locationMap).To(Equal(map[string]string{"fast_disk": "/new/fast", `"Slow Disk"`: "/new/slow", "old_disk": "/new/old"}))
+		})
+		It("panics on a relative location", func() {
+			defer testhelper.ShouldPanicWithMessage("Invalid --tablespace-location-map entry fast_disk:new/fast.  Entries must be of the form name:/new/path, with an absolute path that does not contain a single quote.")
+			restore.ParseTablespaceLocationMap([]string{"fast_disk:new/fast"}, "")
+		})
+		It("panics on a location containing a single quote", func() {
+			defer testhelper.ShouldPanicWithMessage("Invalid --tablespace-location-map entry fast_disk:/new/fast'disk.")
+			restore.ParseTablespaceLocationMap([]string{"fast_disk:/new/fast'disk"}, "")
+		})
+	})
+	Describe("SubstituteTablespaceLocations", func() {
+		It("replaces the location and drops per-segment locations", func() {
+			statements := []toc.StatementWithType{segmentTablespace, tablespaceOptions, quotedTablespace}
+			statements = restore.SubstituteTablespaceLocations(statements, map[string]string{"fast_disk": "/new/fast", `"Slow Disk"`: "/new/slow"})
+			Expect(statements[0].Statement).To(Equal("\n\nCREATE TABLESPACE fast_disk LOCATION '/new/fast';"))
+			Expect(statements[1].Statement).To(Equal(tablespaceOptions.Statement))
+			Expect(statements[2].Statement).To(Equal("\n\nCREATE TABLESPACE \"Slow Disk\" LOCATION '/new/slow';"))
+		})
+		It("does not change tablespaces created in a filespace", func() {
+			statements := restore.SubstituteTablespaceLocations([]toc.StatementWithType{filespaceTablespace}, map[string]string{"old_disk": "/new/old"})
+			Expect(statements[0].Statement).To(Equal(filespaceTablespace.Statement))
+		})
+	})
+	Describe("ValidateTablespaceLocations", func() {
+		var testExecutor *testhelper.TestExecutor
+		BeforeEach(func() {
+			testExecutor = &testhelper.TestExecutor{ClusterOutput: &cluster.RemoteOutput{}}
+			testCluster := cluster.NewCluster([]cluster.SegConfig{
+				{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"},
+				{ContentID: 0, Hostname: "remotehost1", DataDir: "/data/gpseg0"},
+			})
+			testCluster.Executor = testExecutor
+			restore.SetCluster(testCluster)
+		})
+		It("checks that every mapped location exists", func() {
+			statements := []toc.StatementWithType{segmentTablespace, tablespaceOptions, quotedTablespace, filespaceTablespace}
+			restore.ValidateTablespaceLocations(statements, map[string]string{"fast_disk": "/new/fast", `"Slow Disk"`: "/new/slow"})
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).To(ContainSubstring("test -d '/new/fast' && test -d '/new/slow'"))
+		})
+		It("panics listing every unmapped tablespace before checking locations", func() {
+			defer func() {
+				Expect(testExecutor.NumExecutions).To(Equal(0))
+			}()
+			defer testhelper.ShouldPanicWithMessage(`The following tablespace(s) have no --tablespace-location-map entry: fast_disk, "Slow Disk"`)
+			restore.ValidateTablespaceLocations([]toc.StatementWithType{segmentTablespace, quotedTablespace}, map[string]string{})
+		})
+		It("warns about mapped tablespaces that are not in the backup", func() {
+			restore.ValidateTablespaceLocations([]toc.StatementWithType{segmentTablespace}, map[string]string{"fast_disk": "/new/fast", "other_disk": "/new/other"})
+			Expect(string(logfile.Contents())).To(ContainSubstring("Tablespace other_disk given in --tablespace-location-map is not in the backup"))
+			Expect(testExecutor.ClusterCommands[0][0].CommandString).ToNot(ContainSubstring("/new/other"))
+		})
+	})
+})
@@ -2,24 +2,47 @@ package restore
 
 import (
 	"fmt"
+	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
+	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
+	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
 )
 
 /*
  * This file contains functions related to validating user input.
  */
 
+/*
+ * Toast tables, append-optimized auxiliary tables, and catalog tables are
+ * never backed up on their own, so they are ignored in the filter lists with
+ * a single warning rather than reported as missing from the backup set.
+ */
+func removeSystemRelationsFromFilters() {
+	removed, err := opts.RemoveSystemRelations(cmdFlags)
+	gplog.FatalOnError(err)
+	if len(removed) > 0 {
+		report.Warn(report.WARN_SYSTEM_RELATION_FILTER, "", "Ignoring the following system or auxiliary relation(s) in the filter lists: %s", strings.Join(removed, ", "))
+	}
+}
+
 func validateFilterListsInBackupSet() {
 	ValidateIncludeSchemasInBackupSet(opts.IncludedSchemas)
 	ValidateExcludeSchemasInBackupSet(opts.ExcludedSchemas)
+	ValidateExcludedSchemaDependents(opts.ExcludedSchemas)
 	ValidateIncludeRelationsInBackupSet(opts.IncludedRelations)
 	ValidateExcludeRelationsInBackupSet(opts.ExcludedRelations)
 }
@@ -32,7 +55,33 @@ func ValidateIncludeSchemasInBackupSet(schemaList []string) {
 
 func ValidateExcludeSchemasInBackupSet(schemaList []string) {
 	if keys := getFilterSchemasInBackupSet(schemaList); len(keys) != 0 {
-		gplog.Warn("Could not find the following excluded schema(s) in the backup set: %s", strings.Join(keys, ", "))
+		report.Warn(report.WARN_MISSING_FILTER_OBJECT, "", "Could not find the following excluded schema(s) in the backup set: %s", strings.Join(keys, ", "))
+	}
+}
+
+/*
+ * Objects such as indexes, triggers, and sequence owners are restored with
+ * the schema they are in rather than the schema of the relation they belong
+ * to, so an object outside the excluded schemas that belongs to a relation
+ * inside one would fail to restore.  Schemas are excluded by the names they
+ * have in the backup, before any --redirect-schema is applied.
+ */
+func ValidateExcludedSchemaDependents(schemaList []string) {
+	if len(schemaList) == 0 || backupConfig.DataOnly {
+		return
+	}
+	excludedSchemaSet := utils.NewIncludeSet(schemaList)
+	for _, entries := range [][]toc.MetadataEntry{globalTOC.PredataEntries, globalTOC.PostdataEntries} {
+		for _, entry := range entries {
+			if entry.ReferenceObject == "" || excludedSchemaSet.MatchesFilter(entry.Schema) {
+				continue
+			}
+			referenceSchema := strings.SplitN(entry.ReferenceObject, ".", 2)[0]
+			if excludedSchemaSet.MatchesFilter(referenceSchema) {
+				report.Warn(report.WARN_EXCLUDED_DEPENDENT, entry.FQN(), "%s %s depends on %s in excluded schema %s and will fail to restore",
+					entry.ObjectType, entry.FQN(), entry.ReferenceObject, referenceSchema)
+			}
+		}
 	}
 }
 
@@ -142,7 +191,7 @@ WHERE quote_ident(n.nspname) || '.' || quote_ident(c.relname) IN (%s)`, quotedTa
 }
 
 func ValidateRedirectSchema(connectionPool *dbconn.DBConn, redirectSchema string) {
-	query := fmt.Sprintf(`SELECT quote_ident(nspname) AS name FROM pg_namespace n WHERE n.nspname = '%s'`, redirectSchema)
+	query := fmt.Sprintf(`SELECT quote_ident(nspname) AS name FROM pg_namespace n WHERE quote_ident(n.nspname) = '%s'`, utils.EscapeSingleQuotes(redirectSchema))
 	schemaInDB := dbconn.MustSelectStringSlice(connectionPool, query)
 
 	if len(schemaInDB) == 0 {
@@ -150,6 +199,244 @@ func ValidateRedirectSchema(connectionPool *dbconn.DBConn, redirectSchema string
 	}
 }
 
+/*
+ * --role-map entries are given as old:new with role names matched exactly as
+ * given, or in double quotes as in SQL, which are quoted the way gpbackup
+ * quoted role names in the metadata file.
+ */
+func ParseRoleMap(entries []string) map[string]string {
+	roleMap := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		oldRole, newRole, ok := utils.SplitMapEntry(entry)
+		newRole = utils.UnquoteIdent(newRole)
+		if !ok || oldRole == "" || newRole == "" {
+			gplog.Fatal(errors.Errorf("Invalid --%s entry %s.  Entries must be of the form old:new.", options.ROLE_MAP, entry), "")
+		}
+		roleMap[utils.QuoteIdentifier(oldRole)] = utils.QuoteIdentifier(newRole)
+	}
+	return roleMap
+}
+
+/*
+ * A role map entry for a role the restored metadata does not use has no
+ * effect, which is usually a mistake in the role name, such as its case.
+ */
+func ValidateRoleMapSources(roleMap map[string]string, roleUses map[string]string) {
+	oldRoles := make([]string, 0, len(roleMap))
+	for oldRole := range roleMap {
+		oldRoles = append(oldRoles, oldRole)
+	}
+	sort.Strings(oldRoles)
+	for _, oldRole := range oldRoles {
+		if _, ok := roleUses[oldRole]; !ok {
+			report.Warn(report.WARN_MISSING_FILTER_OBJECT, oldRole, "Role %s given in --%s is not used by the restored metadata", oldRole, options.ROLE_MAP)
+		}
+	}
+}
+
+/*
+ * roleUses maps each quoted role name the restore will use, after --role-map
+ * is applied, to an object that uses it.  A missing role would otherwise stop
+ * the restore partway through its metadata.
+ */
+func ValidateRolesExist(connectionPool *dbconn.DBConn, roleUses map[string]string) {
+	existingRoles := dbconn.MustSelectStringSlice(connectionPool, "SELECT quote_ident(rolname) AS name FROM pg_roles")
+	roleSet := make(map[string]bool, len(existingRoles))
+	for _, role := range existingRoles {
+		roleSet[role] = true
+	}
+	missingRoles := make([]string, 0)
+	for role := range roleUses {
+		if !roleSet[role] {
+			missingRoles = append(missingRoles, role)
+		}
+	}
+	if len(missingRoles) == 0 {
+		return
+	}
+	sort.Strings(missingRoles)
+	for _, role := range missingRoles {
+		gplog.Error("Role %s, used by %s, does not exist in the restore database", role, roleUses[role])
+	}
+	gplog.Fatal(errors.Errorf("%d role(s) used by the restored metadata do not exist in the restore database. Create them or map them to existing roles with --%s.",
+		len(missingRoles), options.ROLE_MAP), "")
+}
+
+type DefaultRewriteRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+	regex       *regexp.Regexp
+}
+
+func NewDefaultRewriteRule(pattern string, replacement string) (DefaultRewriteRule, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return DefaultRewriteRule{}, errors.Errorf("Invalid default rewrite pattern %s: %v", pattern, err)
+	}
+	return DefaultRewriteRule{Pattern: pattern, Replacement: replacement, regex: regex}, nil
+}
+
+/*
+ * The rewrite file is a YAML list of regular expressions and replacements,
+ * applied in order to every column default, e.g.
+ *
+ * rewrites:
+ *   - pattern: "'\\{\\}'::json\\b"
+ *     replacement: "'{}'::jsonb"
+ */
+func ReadDefaultRewriteFile(filename string) ([]DefaultRewriteRule, error) {
+	contents, err := operating.System.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Rewrites []DefaultRewriteRule `yaml:"rewrites"`
+	}{}
+	err = yaml.UnmarshalStrict(contents, &config)
+	if err != nil {
+		return nil, errors.Errorf("Default rewrite file %s is formatted incorrectly: %v", filename, err)
+	}
+	rules := make([]DefaultRewriteRule, 0, len(config.Rewrites))
+	for _, rewrite := range config.Rewrites {
+		rule, err := NewDefaultRewriteRule(rewrite.Pattern, rewrite.Replacement)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+/*
+ * Holds the column defaults recorded in the TOC by table, so that the
+ * defaults of each table can be rewritten as its CREATE TABLE statement is
+ * restored without holding the other statements in memory.
+ */
+type ColumnDefaultRewriter struct {
+	rules           []DefaultRewriteRule
+	defaultsByTable map[string][]toc.ColumnDefaultEntry
+}
+
+func NewColumnDefaultRewriter(columnDefaults []toc.ColumnDefaultEntry, rules []DefaultRewriteRule) *ColumnDefaultRewriter {
+	defaultsByTable := make(map[string][]toc.ColumnDefaultEntry)
+	for _, columnDefault := range columnDefaults {
+		fqn := utils.MakeFQN(columnDefault.Schema, columnDefault.Table)
+		defaultsByTable[fqn] = append(defaultsByTable[fqn], columnDefault)
+	}
+	return &ColumnDefaultRewriter{rules: rules, defaultsByTable: defaultsByTable}
+}
+
+func (rewriter *ColumnDefaultRewriter) rewrite(columnDefault string) string {
+	for _, rule := range rewriter.rules {
+		columnDefault = rule.regex.ReplaceAllString(columnDefault, rule.Replacement)
+	}
+	return columnDefault
+}
+
+/*
+ * Returns the defaults of the given table with any rewrite rules applied,
+ * logging each default that a rule changes.
+ */
+func (rewriter *ColumnDefaultRewriter) TableDefaults(schema string, table string) []toc.ColumnDefaultEntry {
+	fqn := utils.MakeFQN(schema, table)
+	tableDefaults := make([]toc.ColumnDefaultEntry, 0, len(rewriter.defaultsByTable[fqn]))
+	for _, columnDefault := range rewriter.defaultsByTable[fqn] {
+		rewritten := rewriter.rewrite(columnDefault.Default)
+		if rewritten != columnDefault.Default {
+			gplog.Verbose("Rewriting default for column %s of table %s from %s to %s", columnDefault.Column, fqn, columnDefault.Default, rewritten)
+			columnDefault.Default = rewritten
+		}
+		tableDefaults = append(tableDefaults, columnDefault)
+	}
+	return tableDefaults
+}
+
+// Updates a CREATE TABLE statement to match the rewritten defaults of its table
+func (rewriter *ColumnDefaultRewriter) RewriteStatement(statement *toc.StatementWithType) {
+	if statement.ObjectType != "TABLE" {
+		return
+	}
+	for _, columnDefault := range rewriter.defaultsByTable[utils.MakeFQN(statement.Schema, statement.Name)] {
+		rewritten := rewriter.rewrite(columnDefault.Default)
+		if rewritten != columnDefault.Default {
+			statement.Statement = strings.Replace(statement.Statement,
+				" DEFAULT "+columnDefault.Default, " DEFAULT "+rewritten, -1)
+		}
+	}
+}
+
+/*
+ * Returns the column defaults recorded in the TOC for the tables being
+ * created by the given statements, with any rewrite rules applied.  When a
+ * rule changes a default, the table's CREATE TABLE statement is updated to
+ * match.
+ */
+func RewriteColumnDefaults(statements []toc.StatementWithType, columnDefaults []toc.ColumnDefaultEntry, rules []DefaultRewriteRule) []toc.ColumnDefaultEntry {
+	rewriter := NewColumnDefaultRewriter(columnDefaults, rules)
+	tableDefaults := make([]toc.ColumnDefaultEntry, 0)
+	for i := range statements {
+		if statements[i].ObjectType != "TABLE" {
+			continue
+		}
+		tableDefaults = append(tableDefaults, rewriter.TableDefaults(statements[i].Schema, statements[i].Name)...)
+		rewriter.RewriteStatement(&statements[i])
+	}
+	return tableDefaults
+}
+
+/*
+ * Defaults that reference objects created later in the restore, such as a
+ * sequence or a user-defined function or type, cannot be checked before
+ * predata is restored and are skipped rather than reported as invalid.
+ */
+func isUnresolvedObjectError(err error) bool {
+	pgErr, ok := errors.Cause(err).(*pgconn.PgError)
+	if !ok {
+		return false
+	}
+	switch pgErr.Code {
+	case "42P01", "42704", "42883", "3F000":
+		return true
+	}
+	return false
+}
+
+/*
+ * Each default is checked by preparing, but never executing, a statement
+ * that casts it to the column's type, so functions such as nextval are not
+ * called.  All invalid defaults are reported before the restore exits.
+ */
+func ValidateColumnDefaults(connectionPool *dbconn.DBConn, columnDefaults []toc.ColumnDefaultEntry) {
+	if len(columnDefaults) == 0 {
+		return
+	}
+	gplog.Info("Validating column defaults")
+	invalidDefaults := make([]string, 0)
+	for _, columnDefault := range columnDefaults {
+		query := fmt.Sprintf("PREPARE gprestore_validate_default AS SELECT (%s)::%s", columnDefault.Default, columnDefault.Type)
+		_, err := connectionPool.Exec(query, 0)
+		if err == nil {
+			_, err = connectionPool.Exec("DEALLOCATE gprestore_validate_default", 0)
+			gplog.FatalOnError(err)
+			continue
+		}
+		fqn := utils.MakeFQN(columnDefault.Schema, columnDefault.Table)
+		if isUnresolvedObjectError(err) {
+			gplog.Verbose("Skipping validation of default for column %s of table %s: %v", columnDefault.Column, fqn, err)
+			continue
+		}
+		invalidDefaults = append(invalidDefaults, fmt.Sprintf("%s.%s (%s): DEFAULT %s: %v",
+			fqn, columnDefault.Column, columnDefault.Type, columnDefault.Default, err))
+	}
+	if len(invalidDefaults) > 0 {
+		for _, invalidDefault := range invalidDefaults {
+			gplog.Error("%s", invalidDefault)
+		}
+		gplog.Fatal(errors.Errorf("Found %d invalid column default(s) in the backup set. Use --%s to rewrite them.",
+			len(invalidDefaults), options.DEFAULTS_REWRITE_FILE), "")
+	}
+}
+
 func ValidateIncludeRelationsInBackupSet(schemaList []string) {
 	if keys := getFilterRelationsInBackupSet(schemaList); len(keys) != 0 {
 		gplog.Fatal(errors.Errorf("Could not find the following relation(s) in the backup set: %s", strings.Join(keys, ", ")), "")
@@ -158,7 +445,7 @@ func ValidateIncludeRelationsInBackupSet(schemaList []string) {
 
 func ValidateExcludeRelationsInBackupSet(schemaList []string) {
 	if keys := getFilterRelationsInBackupSet(schemaList); len(keys) != 0 {
-		gplog.Warn("Could not find the following excluded relation(s) in the backup set: %s", strings.Join(keys, ", "))
+		report.Warn(report.WARN_MISSING_FILTER_OBJECT, "", "Could not find the following excluded relation(s) in the backup set: %s", strings.Join(keys, ", "))
 	}
 }
 
@@ -224,6 +511,25 @@ END AS string;`, utils.EscapeSingleQuotes(unquotedDBName))
 	}
 }
 
+/*
+ * A restore point must be one of the backups in the restore plan of the
+ * --timestamp backup.  A metadata-only backup is never part of an incremental
+ * chain, so it gets its own error rather than the generic one.
+ */
+func ValidateRestorePoint(restorePlan []history.RestorePlanEntry, chainTimestamp string, restoreToTimestamp string, restorePointConfig *history.BackupConfig) error {
+	if restorePointConfig != nil && restorePointConfig.MetadataOnly {
+		return errors.Errorf("Backup %s is a metadata-only backup and has no data to restore; it cannot be used with --%s", restoreToTimestamp, options.RESTORE_TO_TIMESTAMP)
+	}
+	chain := make([]string, len(restorePlan))
+	for i, entry := range restorePlan {
+		if entry.Timestamp == restoreToTimestamp {
+			return nil
+		}
+		chain[i] = entry.Timestamp
+	}
+	return errors.Errorf("Timestamp %s is not part of the backup chain of %s, which consists of the following backups: %s", restoreToTimestamp, chainTimestamp, strings.Join(chain, ", "))
+}
+
 func ValidateBackupFlagCombinations() {
 	if backupConfig.SingleDataFile && MustGetFlagInt(options.JOBS) != 1 {
 		gplog.Fatal(errors.Errorf("Cannot use jobs flag when restoring backups with a single data file per segment."), "")
@@ -237,28 +543,71 @@ func ValidateBackupFlagCombinations() {
 	if backupConfig.DataOnly && MustGetFlagBool(options.METADATA_ONLY) {
 		gplog.Fatal(errors.Errorf("Cannot use metadata-only flag when restoring data-only backup"), "")
 	}
+	if backupConfig.MetadataOnly && MustGetFlagString(options.DATA_PLAN) != "" {
+		gplog.Fatal(errors.Errorf("Cannot use --data-plan when restoring metadata-only backup"), "")
+	}
+	if MustGetFlagBool(options.REBUILD_INDEXES) && !backupConfig.DataOnly && !MustGetFlagBool(options.DATA_ONLY) {
+		gplog.Fatal(errors.Errorf("Cannot use --rebuild-indexes unless only data is restored"), "")
+	}
+	validateHeuristicIncrementalRestore()
 	validateBackupFlagPluginCombinations()
 }
 
+/*
+ * A backup taken with --changed-since has all metadata, but only the data of
+ * the tables that probably changed, so restoring its data is only complete
+ * when it is restored into tables that already hold the rest.
+ */
+func validateHeuristicIncrementalRestore() {
+	if !backupConfig.HeuristicIncremental || MustGetFlagBool(options.METADATA_ONLY) || MustGetFlagBool(options.INCREMENTAL) {
+		return
+	}
+	if !MustGetFlagBool(options.FORCE) {
+		gplog.Fatal(errors.Errorf("Backup %s is a heuristic incremental backup containing only the data of tables that probably changed since backup %s, "+
+			"so it cannot be restored as a complete database. Use --%s to restore its data into existing tables, or --%s to restore it anyway.",
+			backupConfig.Timestamp, backupConfig.ChangedSince, options.INCREMENTAL, options.FORCE), "")
+	}
+	report.Warn(report.WARN_HEURISTIC_INCREMENTAL, "", "Restoring heuristic incremental backup %s as a complete backup; tables whose data did not change since backup %s will be empty",
+		backupConfig.Timestamp, backupConfig.ChangedSince)
+}
+
 func validateBackupFlagPluginCombinations() {
 	if backupConfig.Plugin != "" && MustGetFlagString(options.PLUGIN_CONFIG) == "" {
 		gplog.Fatal(errors.Errorf("Backup was taken with plugin %s. The --plugin-config flag must be used to restore.", backupConfig.Plugin), "")
 	} else if backupConfig.Plugin == "" && MustGetFlagString(options.PLUGIN_CONFIG) != "" {
 		gplog.Fatal(errors.Errorf("The --plugin-config flag cannot be used to restore a backup taken without a plugin."), "")
 	}
+	if pluginConfig == nil {
+		return
+	}
+	if backupConfig.DataPlugin != "" && pluginConfig.DataPlugin == nil {
+		gplog.Fatal(errors.Errorf("Backup sent its data files to plugin %s. The plugin config must have a data section to restore.", backupConfig.DataPlugin), "")
+	} else if backupConfig.DataPlugin == "" && pluginConfig.DataPlugin != nil {
+		gplog.Fatal(errors.Errorf("The plugin config cannot have a data section to restore a backup that sent its data files to the same destination as its metadata files."), "")
+	} else if pluginConfig.DataPlugin != nil {
+		if _, dataPlugin := path.Split(pluginConfig.DataPlugin.ExecutablePath); dataPlugin != backupConfig.DataPlugin {
+			gplog.Fatal(errors.Errorf("Backup sent its data files to plugin %s, but the data section of the plugin config uses plugin %s.", backupConfig.DataPlugin, dataPlugin), "")
+		}
+	}
 }
 
 func ValidateFlagCombinations(flags *pflag.FlagSet) {
 	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.WITH_GLOBALS)
 	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.CREATE_DB)
-	options.CheckExclusiveFlags(flags, options.DEBUG, options.QUIET, options.VERBOSE)
+	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.VALIDATE_DEFAULTS)
+	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.DEFAULTS_REWRITE_FILE)
+	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.STORAGE_OVERRIDE)
+	options.CheckExclusiveFlags(flags, options.DATA_ONLY, options.STORAGE_OVERRIDE_FILE)
+	options.CheckExclusiveFlags(flags, options.DEBUG, options.QUIET, options.VERBOSE, options.SUMMARY_ONLY)
 
-	options.CheckExclusiveFlags(flags, options.INCLUDE_SCHEMA, options.INCLUDE_RELATION, options.INCLUDE_RELATION_FILE)
-	options.CheckExclusiveFlags(flags, options.EXCLUDE_SCHEMA, options.INCLUDE_SCHEMA)
-	options.CheckExclusiveFlags(flags, options.EXCLUDE_SCHEMA, options.EXCLUDE_RELATION, options.INCLUDE_RELATION, options.EXCLUDE_RELATION_FILE, options.INCLUDE_RELATION_FILE)
+	options.CheckExclusiveFlags(flags, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE, options.INCLUDE_RELATION, options.INCLUDE_RELATION_FILE)
+	options.CheckExclusiveFlags(flags, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.INCLUDE_SCHEMA, options.INCLUDE_SCHEMA_FILE)
+	options.CheckExclusiveFlags(flags, options.EXCLUDE_SCHEMA, options.EXCLUDE_SCHEMA_FILE, options.EXCLUDE_RELATION, options.INCLUDE_RELATION, options.EXCLUDE_RELATION_FILE, options.INCLUDE_RELATION_FILE)
 
 	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.DATA_ONLY)
 	options.CheckExclusiveFlags(flags, options.PLUGIN_CONFIG, options.BACKUP_DIR)
+	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.PLUGIN_CONFIG, options.VERIFY_BACKUP_FILES)
+	options.CheckExclusiveFlags(flags, options.METADATA_ONLY, options.PLUGIN_CONFIG, options.VERIFY_CHECKSUMS)
 
 	if flags.Changed(options.REDIRECT_SCHEMA) {
 		// Redirect schema not compatible with any exclude flags and include schema flags
@@ -283,4 +632,13 @@ func ValidateFlagCombinations(flags *pflag.FlagSet) {
 		gplog.Fatal(errors.Errorf("Cannot use --incremental without --data-only"), "")
 	}
 	options.CheckExclusiveFlags(flags, options.RUN_ANALYZE, options.WITH_STATS)
+	if flags.Changed(options.CONSTRAINT_INDEXES) && !flags.Changed(options.REBUILD_INDEXES) {
+		gplog.Fatal(errors.Errorf("Cannot use --include-constraint-indexes without --rebuild-indexes"), "")
+	}
+	options.CheckExclusiveFlags(flags, options.RESTORE_TO_TIMESTAMP, options.METADATA_ONLY, options.INCREMENTAL)
+	options.CheckExclusiveFlags(flags, options.FK_CYCLE_REPLICA, options.METADATA_ONLY)
+	options.CheckExclusiveFlags(flags, options.DATA_PLAN, options.METADATA_ONLY)
+	if (flags.Changed(options.TABLESPACE_MAP) || flags.Changed(options.TABLESPACE_MAP_FILE)) && !flags.Changed(options.WITH_GLOBALS) {
+		gplog.Fatal(errors.Errorf("Cannot use --tablespace-location-map or --tablespace-location-map-file without --with-globals"), "")
+	}
 }
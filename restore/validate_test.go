@@ -2,16 +2,21 @@ package restore_test
 
 import (
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/greenplum-db/gp-common-go-libs/operating"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/history"
 	"github.com/greenplum-db/gpbackup/options"
+	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/restore"
 	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
+	"github.com/spf13/pflag"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
 )
 
 var _ = Describe("restore/validate tests", func() {
@@ -32,10 +37,10 @@ var _ = Describe("restore/validate tests", func() {
 			tocfile, backupfile = testutils.InitializeTestTOC(buffer, "predata")
 			backupfile.ByteCount = table1Len
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			tocfile.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
+			tocfile.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", "")
 			backupfile.ByteCount += table2Len
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema2", Name: "table2", ObjectType: "TABLE"}, table1Len, backupfile.ByteCount)
-			tocfile.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "")
+			tocfile.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "", "")
 			backupfile.ByteCount += sequenceLen
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema", Name: "somesequence", ObjectType: "SEQUENCE"}, table1Len+table2Len, backupfile.ByteCount)
 			restore.SetTOC(tocfile)
@@ -70,14 +75,45 @@ var _ = Describe("restore/validate tests", func() {
 			testhelper.ExpectRegexp(logfile, "[WARNING]:-Could not find the following excluded schema(s) in the backup set: schema3")
 		})
 	})
+	Describe("ValidateExcludedSchemaDependents", func() {
+		BeforeEach(func() {
+			_, _, logfile = testhelper.SetupTestLogger()
+			tocfile, _ = testutils.InitializeTestTOC(buffer, "predata")
+			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, 0)
+			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema2", Name: "seq1", ObjectType: "SEQUENCE OWNER", ReferenceObject: "schema1.table1"}, 0, 0)
+			tocfile.AddMetadataEntry("postdata", toc.MetadataEntry{Schema: "schema2", Name: "index1", ObjectType: "INDEX", ReferenceObject: "schema1.table1"}, 0, 0)
+			tocfile.AddMetadataEntry("postdata", toc.MetadataEntry{Schema: "schema1", Name: "index2", ObjectType: "INDEX", ReferenceObject: "schema1.table1"}, 0, 0)
+			tocfile.AddMetadataEntry("postdata", toc.MetadataEntry{Schema: "schema2", Name: "index3", ObjectType: "INDEX", ReferenceObject: "schema2.table2"}, 0, 0)
+			restore.SetTOC(tocfile)
+			restore.SetBackupConfig(&history.BackupConfig{})
+			report.ClearWarnings()
+		})
+		It("flags objects outside the excluded schemas that depend on relations inside them", func() {
+			restore.ValidateExcludedSchemaDependents([]string{"schema1"})
+			warnings := report.GetWarnings(nil)
+			Expect(warnings).To(HaveLen(2))
+			Expect(warnings[0].Object).To(Equal("schema2.seq1"))
+			Expect(warnings[1].Object).To(Equal("schema2.index1"))
+			testhelper.ExpectRegexp(logfile, "[WARNING]:-INDEX schema2.index1 depends on schema1.table1 in excluded schema schema1 and will fail to restore")
+		})
+		It("does not flag anything if no object depends on an excluded schema", func() {
+			restore.ValidateExcludedSchemaDependents([]string{"schema3"})
+			Expect(report.GetWarnings(nil)).To(BeEmpty())
+		})
+		It("does not flag anything in a data-only backup", func() {
+			restore.SetBackupConfig(&history.BackupConfig{DataOnly: true})
+			restore.ValidateExcludedSchemaDependents([]string{"schema1"})
+			Expect(report.GetWarnings(nil)).To(BeEmpty())
+		})
+	})
 	Describe("GenerateRestoreRelationList", func() {
 		var opts *options.Options
 		BeforeEach(func() {
 			tocfile, _ = testutils.InitializeTestTOC(buffer, "metadata")
-			tocfile.AddMasterDataEntry("s1", "table1", 1, "(j)", 0, "")
-			tocfile.AddMasterDataEntry("s1", "table2", 2, "(j)", 0, "")
-			tocfile.AddMasterDataEntry("s2", "table1", 3, "(j)", 0, "")
-			tocfile.AddMasterDataEntry("s2", "table2", 4, "(j)", 0, "")
+			tocfile.AddMasterDataEntry("s1", "table1", 1, "(j)", 0, "", "")
+			tocfile.AddMasterDataEntry("s1", "table2", 2, "(j)", 0, "", "")
+			tocfile.AddMasterDataEntry("s2", "table1", 3, "(j)", 0, "", "")
+			tocfile.AddMasterDataEntry("s2", "table2", 4, "(j)", 0, "", "")
 			restore.SetTOC(tocfile)
 
 			opts = &options.Options{}
@@ -131,6 +167,20 @@ var _ = Describe("restore/validate tests", func() {
 			expectedRelations := []string{"s1.table2"}
 			Expect(resultRelations).To(ConsistOf(expectedRelations))
 		})
+		It("does not include system or auxiliary relations given as include relations", func() {
+			_ = cmdFlags.Set(options.INCLUDE_RELATION, "s1.table1")
+			_ = cmdFlags.Set(options.INCLUDE_RELATION, "pg_toast.pg_toast_16384")
+			_ = cmdFlags.Set(options.INCLUDE_RELATION, "pg_aoseg.pg_aovisimap_16390")
+			opts, err := options.NewOptions(cmdFlags)
+			Expect(err).ToNot(HaveOccurred())
+			removed, err := opts.RemoveSystemRelations(cmdFlags)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(ConsistOf("pg_toast.pg_toast_16384", "pg_aoseg.pg_aovisimap_16390"))
+
+			resultRelations := restore.GenerateRestoreRelationList(*opts)
+
+			Expect(resultRelations).To(ConsistOf("s1.table1"))
+		})
 	})
 	Describe("ValidateRelationsInRestoreDatabase", func() {
 		BeforeEach(func() {
@@ -195,10 +245,10 @@ var _ = Describe("restore/validate tests", func() {
 		BeforeEach(func() {
 			tocfile, backupfile = testutils.InitializeTestTOC(buffer, "predata")
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "table1", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			tocfile.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
+			tocfile.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", "")
 
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema2", Name: "table2", ObjectType: "TABLE"}, 0, backupfile.ByteCount)
-			tocfile.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "")
+			tocfile.AddMasterDataEntry("schema2", "table2", 2, "(j)", 0, "", "")
 
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "somesequence", ObjectType: "SEQUENCE"}, 0, backupfile.ByteCount)
 			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema1", Name: "someview", ObjectType: "VIEW"}, 0, backupfile.ByteCount)
@@ -262,6 +312,52 @@ var _ = Describe("restore/validate tests", func() {
 			restore.ValidateIncludeRelationsInBackupSet(filterList)
 		})
 	})
+	Describe("ValidateFlagCombinations", func() {
+		var flags *pflag.FlagSet
+		BeforeEach(func() {
+			flags = pflag.NewFlagSet("gprestore", pflag.ContinueOnError)
+			options.SetRestoreFlagDefaults(flags)
+			_ = flags.Set(options.TIMESTAMP, "20170101010101")
+		})
+		It("panics when --exclude-schema is used with --include-schema", func() {
+			_ = flags.Set(options.EXCLUDE_SCHEMA, "schema1")
+			_ = flags.Set(options.INCLUDE_SCHEMA, "schema2")
+			defer testhelper.ShouldPanicWithMessage("The following flags may not be specified together: exclude-schema, exclude-schema-file, include-schema, include-schema-file")
+			restore.ValidateFlagCombinations(flags)
+		})
+		It("panics when --exclude-schema-file is used with --include-schema", func() {
+			_ = flags.Set(options.EXCLUDE_SCHEMA_FILE, "/tmp/schemas")
+			_ = flags.Set(options.INCLUDE_SCHEMA, "schema2")
+			defer testhelper.ShouldPanicWithMessage("The following flags may not be specified together: exclude-schema, exclude-schema-file, include-schema, include-schema-file")
+			restore.ValidateFlagCombinations(flags)
+		})
+		It("panics when --data-plan is used with --metadata-only", func() {
+			_ = flags.Set(options.DATA_PLAN, "json")
+			_ = flags.Set(options.METADATA_ONLY, "true")
+			defer testhelper.ShouldPanicWithMessage("The following flags may not be specified together: data-plan, metadata-only")
+			restore.ValidateFlagCombinations(flags)
+		})
+		It("panics when --exclude-schema is used with --redirect-schema", func() {
+			_ = flags.Set(options.EXCLUDE_SCHEMA, "schema1")
+			_ = flags.Set(options.REDIRECT_SCHEMA, "schema2")
+			defer testhelper.ShouldPanicWithMessage("Cannot use --redirect-schema with exclude flags or include schema flags")
+			restore.ValidateFlagCombinations(flags)
+		})
+		It("passes when --exclude-schema is used on its own", func() {
+			_ = flags.Set(options.EXCLUDE_SCHEMA, "schema1")
+			restore.ValidateFlagCombinations(flags)
+		})
+		It("panics when --tablespace-location-map is used without --with-globals", func() {
+			_ = flags.Set(options.TABLESPACE_MAP, "fast_disk:/new/fast")
+			defer testhelper.ShouldPanicWithMessage("Cannot use --tablespace-location-map or --tablespace-location-map-file without --with-globals")
+			restore.ValidateFlagCombinations(flags)
+		})
+		It("passes when --tablespace-location-map-file is used with --with-globals", func() {
+			_ = flags.Set(options.TABLESPACE_MAP_FILE, "/tmp/tablespaces")
+			_ = flags.Set(options.WITH_GLOBALS, "true")
+			restore.ValidateFlagCombinations(flags)
+		})
+	})
 	Describe("ValidateDatabaseExistence", func() {
 		It("panics if createdb passed when db exists", func() {
 			dbExists := sqlmock.NewRows([]string{"string"}).
@@ -291,4 +387,229 @@ var _ = Describe("restore/validate tests", func() {
 			restore.ValidateDatabaseExistence("testdb", false, false)
 		})
 	})
+	Describe("ReadDefaultRewriteFile", func() {
+		AfterEach(func() {
+			operating.System = operating.InitializeSystemFunctions()
+		})
+		It("reads rewrite rules in order", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte(`rewrites:
+  - pattern: "::json$"
+    replacement: "::jsonb"
+  - pattern: "ARRAY\\[\\]"
+    replacement: "'{}'"
+`), nil
+			}
+			rules, err := restore.ReadDefaultRewriteFile("/tmp/rewrites.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rules).To(HaveLen(2))
+			Expect(rules[0].Pattern).To(Equal("::json$"))
+			Expect(rules[1].Replacement).To(Equal("'{}'"))
+		})
+		It("returns an error for an invalid pattern", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte("rewrites:\n  - pattern: \"(\"\n    replacement: \"\"\n"), nil
+			}
+			_, err := restore.ReadDefaultRewriteFile("/tmp/rewrites.yaml")
+			Expect(err).To(MatchError(ContainSubstring("Invalid default rewrite pattern (")))
+		})
+		It("returns an error for an unknown key", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte("rewrite:\n  - pattern: a\n"), nil
+			}
+			_, err := restore.ReadDefaultRewriteFile("/tmp/rewrites.yaml")
+			Expect(err).To(MatchError(ContainSubstring("is formatted incorrectly")))
+		})
+	})
+	Describe("RewriteColumnDefaults", func() {
+		columnDefaults := []toc.ColumnDefaultEntry{
+			{Schema: "public", Table: "foo", Column: "j", Type: "jsonb", Default: "'{}'::json"},
+			{Schema: "public", Table: "foo", Column: "a", Type: "integer[]", Default: "ARRAY[1, 2]"},
+			{Schema: "public", Table: "bar", Column: "j", Type: "jsonb", Default: "'[]'::json"},
+		}
+		It("returns only defaults for tables being restored", func() {
+			statements := []toc.StatementWithType{
+				{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "CREATE TABLE public.foo (\n\tj jsonb DEFAULT '{}'::json,\n\ta integer[] DEFAULT ARRAY[1, 2]\n);"},
+				{Schema: "public", Name: "bar", ObjectType: "SEQUENCE", Statement: "CREATE SEQUENCE public.bar;"},
+			}
+			result := restore.RewriteColumnDefaults(statements, columnDefaults, nil)
+			Expect(result).To(Equal(columnDefaults[:2]))
+		})
+		It("applies rewrite rules to defaults and CREATE TABLE statements", func() {
+			statements := []toc.StatementWithType{
+				{Schema: "public", Name: "foo", ObjectType: "TABLE", Statement: "CREATE TABLE public.foo (\n\tj jsonb DEFAULT '{}'::json,\n\ta integer[] DEFAULT ARRAY[1, 2]\n);"},
+			}
+			rule, err := restore.NewDefaultRewriteRule("::json$", "::jsonb")
+			Expect(err).ToNot(HaveOccurred())
+			result := restore.RewriteColumnDefaults(statements, columnDefaults, []restore.DefaultRewriteRule{rule})
+			Expect(result[0].Default).To(Equal("'{}'::jsonb"))
+			Expect(result[1].Default).To(Equal("ARRAY[1, 2]"))
+			Expect(statements[0].Statement).To(Equal("CREATE TABLE public.foo (\n\tj jsonb DEFAULT '{}'::jsonb,\n\ta integer[] DEFAULT ARRAY[1, 2]\n);"))
+		})
+	})
+	Describe("ValidateColumnDefaults", func() {
+		columnDefaults := []toc.ColumnDefaultEntry{
+			{Schema: "public", Table: "foo", Column: "j", Type: "jsonb", Default: "'{}'::jsonb"},
+			{Schema: "public", Table: "foo", Column: "i", Type: "integer", Default: "nextval('public.foo_i_seq'::regclass)"},
+			{Schema: "public", Table: "foo", Column: "a", Type: "integer[]", Default: "'{a}'::integer[]"},
+		}
+		It("passes when all defaults are valid or reference objects not yet restored", func() {
+			mock.ExpectExec(`PREPARE gprestore_validate_default AS SELECT \('\{\}'::jsonb\)::jsonb`).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("DEALLOCATE gprestore_validate_default").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("PREPARE gprestore_validate_default").WillReturnError(&pgconn.PgError{Code: "42P01", Message: `relation "public.foo_i_seq" does not exist`})
+			restore.ValidateColumnDefaults(connectionPool, columnDefaults[:2])
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("reports every invalid default before exiting", func() {
+			mock.ExpectExec("PREPARE gprestore_validate_default").WillReturnError(&pgconn.PgError{Code: "22P02", Message: `invalid input syntax for type json`})
+			mock.ExpectExec("PREPARE gprestore_validate_default").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("DEALLOCATE gprestore_validate_default").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("PREPARE gprestore_validate_default").WillReturnError(&pgconn.PgError{Code: "22P02", Message: `invalid input syntax for type integer: "a"`})
+			defer func() {
+				Expect(logfile).To(Say(`public.foo.j \(jsonb\): DEFAULT '\{\}'::jsonb: .*invalid input syntax for type json`))
+				Expect(logfile).To(Say(`public.foo.a \(integer\[\]\): DEFAULT '\{a\}'::integer\[\]: .*invalid input syntax for type integer`))
+			}()
+			defer testhelper.ShouldPanicWithMessage("Found 2 invalid column default(s) in the backup set. Use --defaults-rewrite-file to rewrite them.")
+			restore.ValidateColumnDefaults(connectionPool, columnDefaults)
+		})
+	})
+	Describe("ValidateRestorePoint", func() {
+		restorePlan := []history.RestorePlanEntry{
+			{Timestamp: "20170101010101", TableFQNs: []string{"public.foo"}},
+			{Timestamp: "20170102010101", TableFQNs: []string{"public.foo"}},
+			{Timestamp: "20170103010101", TableFQNs: []string{"public.foo"}},
+		}
+		It("accepts a timestamp in the backup chain", func() {
+			err := restore.ValidateRestorePoint(restorePlan, "20170103010101", "20170102010101", &history.BackupConfig{Timestamp: "20170102010101"})
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("accepts a timestamp in the backup chain that has no history entry", func() {
+			err := restore.ValidateRestorePoint(restorePlan, "20170103010101", "20170101010101", nil)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		It("rejects a timestamp that is not in the backup chain", func() {
+			err := restore.ValidateRestorePoint(restorePlan, "20170103010101", "20170104010101", nil)
+			Expect(err).To(MatchError("Timestamp 20170104010101 is not part of the backup chain of 20170103010101, which consists of the following backups: 20170101010101, 20170102010101, 20170103010101"))
+		})
+		It("rejects a metadata-only backup", func() {
+			err := restore.ValidateRestorePoint(restorePlan, "20170103010101", "20170102020202", &history.BackupConfig{Timestamp: "20170102020202", MetadataOnly: true})
+			Expect(err).To(MatchError("Backup 20170102020202 is a metadata-only backup and has no data to restore; it cannot be used with --restore-to-timestamp"))
+		})
+	})
+	Describe("ValidateBackupFlagCombinations", func() {
+		heuristicConfig := history.BackupConfig{Timestamp: "20170102010101", HeuristicIncremental: true, ChangedSince: "20170101010101"}
+		BeforeEach(func() {
+			restore.SetPluginConfig(nil)
+		})
+		It("panics when restoring a heuristic incremental backup as a complete backup", func() {
+			restore.SetBackupConfig(&heuristicConfig)
+			defer testhelper.ShouldPanicWithMessage("Backup 20170102010101 is a heuristic incremental backup containing only the data of tables that probably changed since backup 20170101010101")
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("warns when restoring a heuristic incremental backup with --force", func() {
+			_, _, logfile = testhelper.SetupTestLogger()
+			restore.SetBackupConfig(&heuristicConfig)
+			_ = cmdFlags.Set(options.FORCE, "true")
+			restore.ValidateBackupFlagCombinations()
+			testhelper.ExpectRegexp(logfile, "[WARNING]:-Restoring heuristic incremental backup 20170102010101 as a complete backup")
+		})
+		It("passes when restoring the data of a heuristic incremental backup with --incremental", func() {
+			restore.SetBackupConfig(&heuristicConfig)
+			_ = cmdFlags.Set(options.INCREMENTAL, "true")
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("panics when --data-plan is used to restore a metadata-only backup", func() {
+			restore.SetBackupConfig(&history.BackupConfig{MetadataOnly: true})
+			_ = cmdFlags.Set(options.DATA_PLAN, "text")
+			defer testhelper.ShouldPanicWithMessage("Cannot use --data-plan when restoring metadata-only backup")
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("passes when restoring only the metadata of a heuristic incremental backup", func() {
+			restore.SetBackupConfig(&heuristicConfig)
+			_ = cmdFlags.Set(options.METADATA_ONLY, "true")
+			restore.ValidateBackupFlagCombinations()
+		})
+		Context("data plugins", func() {
+			dataPluginConfig := utils.PluginConfig{ExecutablePath: "/tmp/data_plugin.sh", ConfigPath: "/tmp/plugin_config_data"}
+			BeforeEach(func() {
+				_ = cmdFlags.Set(options.PLUGIN_CONFIG, "/tmp/plugin_config")
+			})
+			AfterEach(func() {
+				_ = cmdFlags.Set(options.PLUGIN_CONFIG, "")
+				restore.SetPluginConfig(nil)
+			})
+			It("passes when the data section of the plugin config uses the plugin the backup sent its data files to", func() {
+				restore.SetBackupConfig(&history.BackupConfig{Plugin: "plugin.sh", DataPlugin: "data_plugin.sh"})
+				restore.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", DataPlugin: &dataPluginConfig})
+				restore.ValidateBackupFlagCombinations()
+			})
+			It("panics when the backup sent its data files to a data plugin and the plugin config has no data section", func() {
+				restore.SetBackupConfig(&history.BackupConfig{Plugin: "plugin.sh", DataPlugin: "data_plugin.sh"})
+				restore.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh"})
+				defer testhelper.ShouldPanicWithMessage("Backup sent its data files to plugin data_plugin.sh. The plugin config must have a data section to restore.")
+				restore.ValidateBackupFlagCombinations()
+			})
+			It("panics when the plugin config has a data section and the backup did not use a data plugin", func() {
+				restore.SetBackupConfig(&history.BackupConfig{Plugin: "plugin.sh"})
+				restore.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", DataPlugin: &dataPluginConfig})
+				defer testhelper.ShouldPanicWithMessage("The plugin config cannot have a data section to restore a backup that sent its data files to the same destination as its metadata files.")
+				restore.ValidateBackupFlagCombinations()
+			})
+			It("panics when the data section of the plugin config uses a different plugin from the backup", func() {
+				restore.SetBackupConfig(&history.BackupConfig{Plugin: "plugin.sh", DataPlugin: "other_plugin.sh"})
+				restore.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", DataPlugin: &dataPluginConfig})
+				defer testhelper.ShouldPanicWithMessage("Backup sent its data files to plugin other_plugin.sh, but the data section of the plugin config uses plugin data_plugin.sh.")
+				restore.ValidateBackupFlagCombinations()
+			})
+		})
+	})
+	Describe("ParseRoleMap", func() {
+		It("quotes the old and new role names", func() {
+			roleMap := restore.ParseRoleMap([]string{"prod_app:Dev App"})
+			Expect(roleMap).To(Equal(map[string]string{"prod_app": `"Dev App"`}))
+		})
+		It("quotes keywords and role names with quotes", func() {
+			roleMap := restore.ParseRoleMap([]string{`user:dev "app"`})
+			Expect(roleMap).To(Equal(map[string]string{`"user"`: `"dev ""app"""`}))
+		})
+		It("matches mixed-case role names exactly on both sides", func() {
+			roleMap := restore.ParseRoleMap([]string{"Analyst:analyst", "reader:Reader"})
+			Expect(roleMap).To(Equal(map[string]string{`"Analyst"`: "analyst", "reader": `"Reader"`}))
+		})
+		It("accepts role names in double quotes on both sides", func() {
+			roleMap := restore.ParseRoleMap([]string{`"Sales:West":"West Sales"`, `"analyst":"Analyst"`})
+			Expect(roleMap).To(Equal(map[string]string{`"Sales:West"`: `"West Sales"`, "analyst": `"Analyst"`}))
+		})
+		It("panics on an unterminated quoted role name", func() {
+			defer testhelper.ShouldPanicWithMessage(`Invalid --role-map entry "Analyst:analyst.  Entries must be of the form old:new.`)
+			restore.ParseRoleMap([]string{`"Analyst:analyst`})
+		})
+		It("panics on an entry without a new role name", func() {
+			defer testhelper.ShouldPanicWithMessage("Invalid --role-map entry prod_app:.  Entries must be of the form old:new.")
+			restore.ParseRoleMap([]string{"prod_app:"})
+		})
+	})
+	Describe("ValidateRoleMapSources", func() {
+		It("warns about each mapped role the restored metadata does not use", func() {
+			roleMap := restore.ParseRoleMap([]string{"Analyst:analyst", "reader:Reader"})
+			restore.ValidateRoleMapSources(roleMap, map[string]string{"analyst": "TABLE public.foo", "reader": "VIEW public.bar"})
+			Expect(string(logfile.Contents())).To(ContainSubstring(`Role "Analyst" given in --role-map is not used by the restored metadata`))
+			Expect(string(logfile.Contents())).ToNot(ContainSubstring("Role reader given in --role-map"))
+		})
+	})
+	Describe("ValidateRolesExist", func() {
+		It("passes if every role exists", func() {
+			mock.ExpectQuery("SELECT quote_ident").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("dev_app").AddRow("gpadmin"))
+			restore.ValidateRolesExist(connectionPool, map[string]string{"dev_app": "TABLE public.foo"})
+		})
+		It("panics listing every missing role", func() {
+			mock.ExpectQuery("SELECT quote_ident").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("gpadmin"))
+			defer func() {
+				Expect(string(logfile.Contents())).To(ContainSubstring("Role dev_app, used by TABLE public.foo, does not exist in the restore database"))
+				Expect(string(logfile.Contents())).To(ContainSubstring(`Role "Dev Reader", used by VIEW public.bar, does not exist in the restore database`))
+			}()
+			defer testhelper.ShouldPanicWithMessage("2 role(s) used by the restored metadata do not exist in the restore database. Create them or map them to existing roles with --role-map.")
+			restore.ValidateRolesExist(connectionPool, map[string]string{"dev_app": "TABLE public.foo", `"Dev Reader"`: "VIEW public.bar", "gpadmin": "TABLE public.foo"})
+		})
+	})
 })
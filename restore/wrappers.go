@@ -1,11 +1,15 @@
 package restore
 
 import (
+	"database/sql/driver"
 	"fmt"
+	"io/ioutil"
+	"net"
 	path "path/filepath"
 	"strconv"
 	"strings"
 
+	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/dbconn"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
@@ -15,6 +19,8 @@ import (
 	"github.com/greenplum-db/gpbackup/report"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -54,11 +60,17 @@ func filtersEmpty(filters Filters) bool {
 func SetLoggerVerbosity() {
 	if MustGetFlagBool(options.QUIET) {
 		gplog.SetVerbosity(gplog.LOGERROR)
+	} else if MustGetFlagBool(options.SUMMARY_ONLY) {
+		logCounter = report.EnableSummaryOnlyLogging("gprestore")
 	} else if MustGetFlagBool(options.DEBUG) {
 		gplog.SetVerbosity(gplog.LOGDEBUG)
 	} else if MustGetFlagBool(options.VERBOSE) {
 		gplog.SetVerbosity(gplog.LOGVERBOSE)
 	}
+	if logCounter == nil {
+		// Warnings and errors are counted for the backup history
+		logCounter = report.EnableLogCounting("gprestore")
+	}
 }
 
 func CreateConnectionPool(unquotedDBName string) {
@@ -69,7 +81,7 @@ func CreateConnectionPool(unquotedDBName string) {
 
 func InitializeConnectionPool(backupTimestamp string, restoreTimestamp string, unquotedDBName string) {
 	CreateConnectionPool(unquotedDBName)
-	setupQuery := fmt.Sprintf("SET application_name TO 'gprestore_%s_%s';", backupTimestamp, restoreTimestamp)
+	setupQuery = fmt.Sprintf("SET application_name TO 'gprestore_%s_%s';", backupTimestamp, restoreTimestamp)
 	setupQuery += `
 SET search_path TO pg_catalog;
 SET gp_default_storage_options='';
@@ -99,22 +111,116 @@ SET default_with_oids = off;
 		backupConfigMajorVer, _ := strconv.Atoi(strings.Split(backupConfig.DatabaseVersion, ".")[0])
 		if backupConfigMajorVer < 6 {
 			setupQuery += "SET gp_use_legacy_hashops = on;\n"
-			gplog.Warn("This backup set was taken on a version of Greenplum prior to 6.x. This restore will use the legacy hash operators when loading data.")
+			report.Warn(report.WARN_LEGACY_HASH_DISTRIBUTION, "", "This backup set was taken on a version of Greenplum prior to 6.x. This restore will use the legacy hash operators when loading data.")
 			gplog.Warn("To use the new Greenplum 6.x default hash operators, these tables will need to be redistributed.")
 			gplog.Warn("For more information, refer to the migration guide located as https://docs.greenplum.org/latest/install_guide/migrate.html.")
 		}
 	}
 	setupQuery += SetMaxCsvLineLengthQuery(connectionPool)
+	setupQuery += utils.GetKeepaliveQuery(connectionPool, MustGetFlagInt(options.KEEPALIVES_IDLE),
+		MustGetFlagInt(options.KEEPALIVES_INTERVAL), MustGetFlagInt(options.KEEPALIVES_COUNT))
 
 	// Always disable gp_autostats_mode to prevent automatic ANALYZE
 	// during COPY FROM SEGMENT. ANALYZE should be run separately.
 	setupQuery += "SET gp_autostats_mode = 'none';\n"
+	setupQuery += PlanSessionGUCs(connectionPool, backupConfig.SessionGUCs)
 
 	for i := 0; i < connectionPool.NumConns; i++ {
 		connectionPool.MustExec(setupQuery, i)
 	}
 }
 
+/*
+ * Returns SET statements giving the restore sessions the settings recorded
+ * from the backup sessions, and reports how they differ from the defaults of
+ * the restore database.  A setting the restore database does not have cannot
+ * be set, so it is left out with a warning.  Backups taken before the
+ * settings were recorded have none, and leave the sessions as they are.
+ */
+func PlanSessionGUCs(connectionPool *dbconn.DBConn, recorded map[string]string) string {
+	if len(recorded) == 0 {
+		return ""
+	}
+	defaults := utils.GetRestoreSessionGUCs(connectionPool)
+	toSet := make(map[string]string, len(recorded))
+	for _, name := range utils.RestoreSessionGUCs {
+		setting, ok := recorded[name]
+		if !ok {
+			continue
+		}
+		defaultSetting, ok := defaults[name]
+		if !ok {
+			report.Warn(report.WARN_SESSION_GUC, name, "Setting %s was %s in the backup sessions but does not exist in the restore database, so it will not be set", name, setting)
+			continue
+		}
+		if defaultSetting != setting {
+			gplog.Info("Setting %s was %s in the backup sessions and defaults to %s in the restore database; restore sessions will use %s", name, setting, defaultSetting, setting)
+		}
+		toSet[name] = setting
+	}
+	return utils.GetRestoreSessionGUCsQuery(toSet)
+}
+
+/*
+ * A worker connection can be lost partway through a restore, for instance when
+ * the server enforces idle_in_transaction_session_timeout or the network drops.
+ */
+func IsConnectionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errors.Cause(err)
+	if cause == driver.ErrBadConn {
+		return true
+	}
+	if pgErr, ok := cause.(*pgconn.PgError); ok {
+		// Class 08 is connection_exception; 57P01 is admin_shutdown and
+		// 25P03 is idle_in_transaction_session_timeout.
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "25P03"
+	}
+	if _, ok := cause.(net.Error); ok {
+		return true
+	}
+	errStr := cause.Error()
+	for _, lostStr := range []string{"conn closed", "connection reset", "broken pipe", "unexpected EOF"} {
+		if strings.Contains(errStr, lostStr) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * database/sql discards a broken session and dials a new one on the next
+ * statement, so reconnecting only requires re-establishing the session state
+ * that InitializeConnectionPool and setGUCsForConnection set up.  Returns false
+ * once the connection has used up its --max-reconnects attempts.
+ */
+func ReconnectConnection(whichConn int, cause error) bool {
+	mutex.Lock()
+	if reconnectCounts[whichConn] >= MustGetFlagInt(options.MAX_RECONNECTS) {
+		mutex.Unlock()
+		return false
+	}
+	reconnectCounts[whichConn]++
+	attempt := reconnectCounts[whichConn]
+	mutex.Unlock()
+
+	report.Warn(report.WARN_CONNECTION_LOST, "", "Connection %d to the database was lost (%s); reconnecting (attempt %d of %d)", whichConn, cause.Error(), attempt, MustGetFlagInt(options.MAX_RECONNECTS))
+	if connectionPool.Tx != nil {
+		connectionPool.Tx[whichConn] = nil
+	}
+	_, err := connectionPool.Exec(setupQuery, whichConn)
+	for i := 0; err == nil && i < len(sessionGUCs); i++ {
+		_, err = connectionPool.Exec(sessionGUCs[i].Statement, whichConn)
+	}
+	if err != nil {
+		gplog.Warn("Unable to reconnect connection %d: %s", whichConn, err.Error())
+		return false
+	}
+	return true
+}
+
 func SetMaxCsvLineLengthQuery(connectionPool *dbconn.DBConn) string {
 	if connectionPool.Version.AtLeast("6") {
 		return ""
@@ -135,8 +241,15 @@ func SetMaxCsvLineLengthQuery(connectionPool *dbconn.DBConn) string {
 func InitializeBackupConfig() {
 	backupConfig = history.ReadConfigFile(globalFPInfo.GetConfigFilePath())
 	utils.InitializePipeThroughParameters(backupConfig.Compressed, 0)
-	report.EnsureBackupVersionCompatibility(backupConfig.BackupVersion, version)
+	if backupConfig.FormatVersion == 0 {
+		report.EnsureBackupVersionCompatibility(backupConfig.BackupVersion, version)
+	} else {
+		report.EnsureFormatCompatibility(backupConfig.BackupVersion, "config", backupConfig.FormatVersion, history.CONFIG_FORMAT_VERSION, backupConfig.UnknownRequiredSections())
+	}
 	report.EnsureDatabaseVersionCompatibility(backupConfig.DatabaseVersion, connectionPool.Version)
+	if backupConfig.DataFormat == "binary" && !MustGetFlagBool(options.METADATA_ONLY) {
+		report.EnsureBinaryDataCompatibility(backupConfig.DatabaseVersion, connectionPool.Version)
+	}
 }
 
 func BackupConfigurationValidation() {
@@ -149,6 +262,9 @@ func BackupConfigurationValidation() {
 
 	tocFilename := globalFPInfo.GetTOCFilePath()
 	globalTOC = toc.NewTOC(tocFilename)
+	if globalTOC.FormatVersion != 0 {
+		report.EnsureFormatCompatibility(backupConfig.BackupVersion, "TOC", globalTOC.FormatVersion, toc.FORMAT_VERSION, globalTOC.UnknownRequiredSections())
+	}
 	globalTOC.InitializeMetadataEntryMap()
 
 	// Legacy backups prior to the incremental feature would have no restoreplan yaml element
@@ -175,21 +291,25 @@ func SetRestorePlanForLegacyBackup(toc *toc.TOC, backupTimestamp string, backupC
 func RecoverMetadataFilesUsingPlugin() {
 	var err error
 	pluginConfig, err = utils.ReadPluginConfig(MustGetFlagString(options.PLUGIN_CONFIG))
-	gplog.FatalOnError(err)
+	utils.FatalWithClass(utils.ERROR_CLASS_USAGE, err)
 	configFilename := path.Base(pluginConfig.ConfigPath)
 	configDirname := path.Dir(pluginConfig.ConfigPath)
-	pluginConfig.ConfigPath = path.Join(configDirname, history.CurrentTimestamp()+"_"+configFilename)
+	pluginConfig.SetConfigPath(path.Join(configDirname, history.CurrentTimestamp()+"_"+configFilename))
 	_ = cmdFlags.Set(options.PLUGIN_CONFIG, pluginConfig.ConfigPath)
 	gplog.Info("plugin config path: %s", pluginConfig.ConfigPath)
 
-	pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
-
 	timestamp := MustGetFlagString(options.TIMESTAMP)
-	historicalPluginVersion := FindHistoricalPluginVersion(timestamp)
-	pluginConfig.SetBackupPluginVersion(timestamp, historicalPluginVersion)
+	pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
+	pluginConfig.SetBackupPluginVersion(timestamp, FindHistoricalPluginVersion(timestamp))
+	if pluginConfig.DataPlugin != nil {
+		pluginConfig.DataPlugin.CheckPluginExistsOnAllHosts(globalCluster)
+		pluginConfig.DataPlugin.SetBackupPluginVersion(timestamp, FindHistoricalDataPluginVersion(timestamp))
+	}
 
-	pluginConfig.CopyPluginConfigToAllHosts(globalCluster)
-	pluginConfig.SetupPluginForRestore(globalCluster, globalFPInfo)
+	for _, plugin := range pluginConfig.AllPlugins() {
+		plugin.CopyPluginConfigToAllHosts(globalCluster)
+		plugin.SetupPluginForRestore(globalCluster, globalFPInfo)
+	}
 
 	metadataFiles := []string{globalFPInfo.GetConfigFilePath(), globalFPInfo.GetMetadataFilePath(),
 		globalFPInfo.GetBackupReportFilePath()}
@@ -212,9 +332,43 @@ func RecoverMetadataFilesUsingPlugin() {
 	for _, fpInfo := range fpInfoList {
 		pluginConfig.MustRestoreFile(fpInfo.GetTOCFilePath())
 		if backupConfig.SingleDataFile {
-			pluginConfig.RestoreSegmentTOCs(globalCluster, fpInfo)
+			utils.PrepareDirectoriesOnAllHosts(globalCluster, fpInfo, cluster.ON_SEGMENTS, "")
+			pluginConfig.ForData().RestoreSegmentTOCs(globalCluster, fpInfo)
+		}
+	}
+}
+
+/*
+ * The restore plan of each backup in an incremental chain covers the backups
+ * before it, so restoring the chain as of an earlier backup means restoring
+ * that backup's own metadata and restore plan and ignoring later increments.
+ * The TOC files of that restore plan were already retrieved from the plugin
+ * along with those of the full chain.
+ */
+func SetRestorePoint(restoreToTimestamp string) {
+	chainTimestamp := globalFPInfo.Timestamp
+	var restorePointConfig *history.BackupConfig
+	if iohelper.FileExistsAndIsReadable(globalFPInfo.GetBackupHistoryFilePath()) {
+		hist, err := history.NewHistory(globalFPInfo.GetBackupHistoryFilePath())
+		gplog.FatalOnError(err)
+		restorePointConfig = hist.FindBackupConfig(restoreToTimestamp)
+	}
+	err := ValidateRestorePoint(backupConfig.RestorePlan, chainTimestamp, restoreToTimestamp, restorePointConfig)
+	gplog.FatalOnError(err)
+
+	gplog.Info("Restoring backup chain of %s as of backup %s", chainTimestamp, restoreToTimestamp)
+	globalFPInfo = GetBackupFPInfoForTimestamp(restoreToTimestamp)
+	if pluginConfig != nil {
+		metadataFiles := []string{globalFPInfo.GetConfigFilePath(), globalFPInfo.GetMetadataFilePath(),
+			globalFPInfo.GetBackupReportFilePath()}
+		if MustGetFlagBool(options.WITH_STATS) {
+			metadataFiles = append(metadataFiles, globalFPInfo.GetStatisticsFilePath())
+		}
+		for _, filename := range metadataFiles {
+			pluginConfig.MustRestoreFile(filename)
 		}
 	}
+	InitializeBackupConfig()
 }
 
 func FindHistoricalPluginVersion(timestamp string) string {
@@ -222,19 +376,31 @@ func FindHistoricalPluginVersion(timestamp string) string {
 	// first, read history from master and provide the historical version
 	// of the plugin that was used to create the original backup
 
-	// adapted from incremental GetLatestMatchingBackupTimestamp
 	var historicalPluginVersion string
-	if iohelper.FileExistsAndIsReadable(globalFPInfo.GetBackupHistoryFilePath()) {
-		hist, err := history.NewHistory(globalFPInfo.GetBackupHistoryFilePath())
-		gplog.FatalOnError(err)
-		foundBackupConfig := hist.FindBackupConfig(timestamp)
-		if foundBackupConfig != nil {
-			historicalPluginVersion = foundBackupConfig.PluginVersion
-		}
+	if foundBackupConfig := findHistoricalBackupConfig(timestamp); foundBackupConfig != nil {
+		historicalPluginVersion = foundBackupConfig.PluginVersion
 	}
 	return historicalPluginVersion
 }
 
+func FindHistoricalDataPluginVersion(timestamp string) string {
+	var historicalPluginVersion string
+	if foundBackupConfig := findHistoricalBackupConfig(timestamp); foundBackupConfig != nil {
+		historicalPluginVersion = foundBackupConfig.DataPluginVersion
+	}
+	return historicalPluginVersion
+}
+
+// adapted from incremental GetLatestMatchingBackupTimestamp
+func findHistoricalBackupConfig(timestamp string) *history.BackupConfig {
+	if !iohelper.FileExistsAndIsReadable(globalFPInfo.GetBackupHistoryFilePath()) {
+		return nil
+	}
+	hist, err := history.NewHistory(globalFPInfo.GetBackupHistoryFilePath())
+	gplog.FatalOnError(err)
+	return hist.FindBackupConfig(timestamp)
+}
+
 /*
  * Metadata and/or data restore wrapper functions
  */
@@ -247,7 +413,18 @@ func GetRestoreMetadataStatements(section string, filename string, includeObject
 
 func GetRestoreMetadataStatementsFiltered(section string, filename string, includeObjectTypes []string, excludeObjectTypes []string, filters Filters) []toc.StatementWithType {
 	metadataFile := iohelper.MustOpenFileForReading(filename)
-	var statements []toc.StatementWithType
+	defer metadataFile.Close()
+	entries := GetRestoreMetadataEntriesFiltered(section, includeObjectTypes, excludeObjectTypes, filters)
+	statements := make([]toc.StatementWithType, 0, len(entries))
+	for _, entry := range entries {
+		statement, err := toc.ReadStatement(metadataFile, entry)
+		gplog.FatalOnError(err)
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+func GetRestoreMetadataEntriesFiltered(section string, includeObjectTypes []string, excludeObjectTypes []string, filters Filters) []toc.MetadataEntry {
 	var inSchemas, exSchemas, inRelations, exRelations []string
 	if !filtersEmpty(filters) {
 		inSchemas = filters.includeSchemas
@@ -273,8 +450,30 @@ func GetRestoreMetadataStatementsFiltered(section string, filename string, inclu
 			exRelations = nil
 		}
 	}
-	statements = globalTOC.GetSQLStatementForObjectTypes(section, metadataFile, includeObjectTypes, excludeObjectTypes, inSchemas, exSchemas, inRelations, exRelations)
-	return statements
+	return globalTOC.GetMetadataEntriesForObjectTypes(section, includeObjectTypes, excludeObjectTypes, inSchemas, exSchemas, inRelations, exRelations)
+}
+
+/*
+ * Reads the statements of the given sections one at a time, as a section may
+ * be too large to hold in memory, and maps each role they use, after roleMap
+ * is applied, to the first object that uses it.
+ */
+func GetRoleUsesInMetadata(filename string, sections []string, filters Filters, roleMap map[string]string) map[string]string {
+	metadataFile := iohelper.MustOpenFileForReading(filename)
+	defer metadataFile.Close()
+	roleUses := make(map[string]string)
+	for _, section := range sections {
+		for _, entry := range GetRestoreMetadataEntriesFiltered(section, []string{}, []string{}, filters) {
+			statement, err := toc.ReadStatement(metadataFile, entry)
+			gplog.FatalOnError(err)
+			for _, role := range toc.GetRolesInStatement(toc.RemapRolesInStatement(statement.Statement, roleMap)) {
+				if _, ok := roleUses[role]; !ok {
+					roleUses[role] = fmt.Sprintf("%s %s", entry.ObjectType, entry.FQN())
+				}
+			}
+		}
+	}
+	return roleUses
 }
 
 func ExecuteRestoreMetadataStatements(statements []toc.StatementWithType, objectsTitle string, progressBar utils.ProgressBar, showProgressBar int, executeInParallel bool) {
@@ -307,11 +506,20 @@ func GetBackupFPInfoForTimestamp(timestamp string) filepath.FilePathInfo {
  * The first time this function is called, it retrieves the session GUCs from the
  * predata file and processes them appropriately, then it returns them so they
  * can be used in later calls without the file access and processing overhead.
+ *
+ * Only the main goroutine may pass nil, before any workers start, as that is
+ * when the GUCs are stored for ReconnectConnection to replay.
  */
 func setGUCsForConnection(gucStatements []toc.StatementWithType, whichConn int) []toc.StatementWithType {
 	if gucStatements == nil {
-		objectTypes := []string{"SESSION GUCS"}
-		gucStatements = GetRestoreMetadataStatements("global", globalFPInfo.GetMetadataFilePath(), objectTypes, []string{})
+		if sessionGUCs == nil {
+			objectTypes := []string{"SESSION GUCS"}
+			sessionGUCs = GetRestoreMetadataStatements("global", globalFPInfo.GetMetadataFilePath(), objectTypes, []string{})
+			if sessionGUCs == nil {
+				sessionGUCs = []toc.StatementWithType{}
+			}
+		}
+		gucStatements = sessionGUCs
 	}
 	ExecuteStatementsAndCreateProgressBar(gucStatements, "", utils.PB_NONE, false, whichConn)
 	return gucStatements
@@ -320,10 +528,10 @@ func setGUCsForConnection(gucStatements []toc.StatementWithType, whichConn int)
 func RestoreSchemas(schemaStatements []toc.StatementWithType, progressBar utils.ProgressBar) {
 	numErrors := 0
 	for _, schema := range schemaStatements {
-		_, err := connectionPool.Exec(schema.Statement, 0)
+		_, err := connectionPool.Exec(toc.RemapRolesInStatement(schema.Statement, roleMap), 0)
 		if err != nil {
 			if strings.Contains(err.Error(), "already exists") {
-				gplog.Warn("Schema %s already exists", schema.Name)
+				report.Warn(report.WARN_SCHEMA_ALREADY_EXISTS, schema.Name, "Schema %s already exists", schema.Name)
 			} else {
 				errMsg := fmt.Sprintf("Error encountered while creating schema %s", schema.Name)
 				if MustGetFlagBool(options.ON_ERROR_CONTINUE) {
@@ -372,8 +580,42 @@ func GetExistingSchemas() ([]string, error) {
 	return existingSchemas, err
 }
 
+/*
+ * Restore scripts run on connection 0 after the session setup, so they see the
+ * same search_path and GUCs as the restore statements.  The whole file is sent
+ * as one simple-protocol query, so it may contain multiple statements.
+ */
+func RunRestoreScript(filename string) error {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	_, err = connectionPool.Exec(string(contents), 0)
+	return err
+}
+
+func RunPreRestoreScript(filename string) {
+	if filename == "" || wasTerminated {
+		return
+	}
+	gplog.Info("Running pre-restore script %s", filename)
+	err := RunRestoreScript(filename)
+	gplog.FatalOnError(err, fmt.Sprintf("Pre-restore script %s failed", filename))
+}
+
+func RunPostRestoreScript(filename string) {
+	if filename == "" || wasTerminated {
+		return
+	}
+	gplog.Info("Running post-restore script %s", filename)
+	err := RunRestoreScript(filename)
+	if err != nil {
+		gplog.Warn("Post-restore script %s failed: %s", filename, err.Error())
+	}
+}
+
 func TruncateTable(tableFQN string, whichConn int) error {
 	gplog.Verbose("Truncating table %s prior to restoring data", tableFQN)
-	_, err := connectionPool.Exec(`TRUNCATE ` + tableFQN, whichConn)
+	_, err := connectionPool.Exec(`TRUNCATE `+tableFQN, whichConn)
 	return err
 }
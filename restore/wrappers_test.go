@@ -1,6 +1,7 @@
 package restore_test
 
 import (
+	"database/sql/driver"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -14,6 +15,7 @@ import (
 	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/toc"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/jackc/pgconn"
 	"github.com/pkg/errors"
 
 	. "github.com/onsi/ginkgo"
@@ -21,6 +23,52 @@ import (
 )
 
 var _ = Describe("wrapper tests", func() {
+	Describe("IsConnectionLost", func() {
+		It("returns false for a nil error", func() {
+			Expect(restore.IsConnectionLost(nil)).To(BeFalse())
+		})
+		It("returns true for a connection exception", func() {
+			Expect(restore.IsConnectionLost(&pgconn.PgError{Code: "08006"})).To(BeTrue())
+		})
+		It("returns true for an idle-in-transaction timeout", func() {
+			Expect(restore.IsConnectionLost(&pgconn.PgError{Code: "25P03"})).To(BeTrue())
+		})
+		It("returns true for a wrapped bad connection error", func() {
+			Expect(restore.IsConnectionLost(errors.Wrap(driver.ErrBadConn, "COPY failed"))).To(BeTrue())
+		})
+		It("returns true for a connection reset", func() {
+			Expect(restore.IsConnectionLost(errors.New("read tcp 10.0.0.1:5432: read: connection reset by peer"))).To(BeTrue())
+		})
+		It("returns false for an ordinary statement error", func() {
+			Expect(restore.IsConnectionLost(&pgconn.PgError{Code: "42P07"})).To(BeFalse())
+		})
+	})
+	Describe("ReconnectConnection", func() {
+		BeforeEach(func() {
+			restore.SetSetupQuery("SET application_name TO 'gprestore';")
+			restore.SetReconnectCounts(make(map[int]int))
+		})
+		It("replays the session setup and logs a warning", func() {
+			mock.ExpectExec("SET application_name TO 'gprestore';").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			Expect(restore.ReconnectConnection(0, errors.New("unexpected EOF"))).To(BeTrue())
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			testhelper.ExpectRegexp(logfile, "[WARNING]:-Connection 0 to the database was lost (unexpected EOF); reconnecting (attempt 1 of 3)")
+		})
+		It("stops reconnecting once --max-reconnects is reached", func() {
+			_ = cmdFlags.Set(options.MAX_RECONNECTS, "1")
+			mock.ExpectExec("SET application_name TO 'gprestore';").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			Expect(restore.ReconnectConnection(0, errors.New("unexpected EOF"))).To(BeTrue())
+			Expect(restore.ReconnectConnection(0, errors.New("unexpected EOF"))).To(BeFalse())
+		})
+		It("does not reconnect when --max-reconnects is 0", func() {
+			_ = cmdFlags.Set(options.MAX_RECONNECTS, "0")
+
+			Expect(restore.ReconnectConnection(0, errors.New("unexpected EOF"))).To(BeFalse())
+		})
+	})
 	Describe("SetMaxCsvLineLengthQuery", func() {
 		It("returns nothing with a connection version of at least 6.0.0", func() {
 			testhelper.SetDBVersion(connectionPool, "6.0.0")
@@ -48,6 +96,28 @@ var _ = Describe("wrapper tests", func() {
 			Expect(result).To(Equal("SET gp_max_csv_line_length = 4194304;\n"))
 		})
 	})
+	Describe("PlanSessionGUCs", func() {
+		header := []string{"name", "setting"}
+		It("sets nothing for a backup that recorded no settings", func() {
+			Expect(restore.PlanSessionGUCs(connectionPool, nil)).To(Equal(""))
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("sets the recorded settings and reports those that differ from the restore database defaults", func() {
+			mock.ExpectQuery("SELECT name, setting FROM pg_settings").WillReturnRows(sqlmock.NewRows(header).
+				AddRow("DateStyle", "ISO, DMY").AddRow("IntervalStyle", "postgres").AddRow("standard_conforming_strings", "on"))
+			recorded := map[string]string{"DateStyle": "ISO, MDY", "IntervalStyle": "postgres", "standard_conforming_strings": "on"}
+			query := restore.PlanSessionGUCs(connectionPool, recorded)
+			Expect(query).To(Equal("SET DateStyle TO 'ISO, MDY';\nSET IntervalStyle TO 'postgres';\nSET standard_conforming_strings TO 'on';\n"))
+			testhelper.ExpectRegexp(logfile, "Setting DateStyle was ISO, MDY in the backup sessions and defaults to ISO, DMY in the restore database; restore sessions will use ISO, MDY")
+			testhelper.NotExpectRegexp(logfile, "Setting IntervalStyle was")
+		})
+		It("warns about a recorded setting that the restore database does not have", func() {
+			mock.ExpectQuery("SELECT name, setting FROM pg_settings").WillReturnRows(sqlmock.NewRows(header).AddRow("DateStyle", "ISO, MDY"))
+			query := restore.PlanSessionGUCs(connectionPool, map[string]string{"DateStyle": "ISO, MDY", "bytea_output": "hex"})
+			Expect(query).To(Equal("SET DateStyle TO 'ISO, MDY';\n"))
+			testhelper.ExpectRegexp(logfile, "Setting bytea_output was hex in the backup sessions but does not exist in the restore database, so it will not be set")
+		})
+	})
 	Describe("RestoreSchemas", func() {
 		var (
 			ignoredProgressBar utils.ProgressBar
@@ -99,6 +169,45 @@ var _ = Describe("wrapper tests", func() {
 			restore.RestoreSchemas(schemaArray, ignoredProgressBar)
 		})
 	})
+	Describe("restore scripts", func() {
+		var scriptFile string
+		BeforeEach(func() {
+			tempDir, err := ioutil.TempDir("", "restore_script")
+			Expect(err).ToNot(HaveOccurred())
+			scriptFile = filepath.Join(tempDir, "script.sql")
+			Expect(ioutil.WriteFile(scriptFile, []byte("ALTER TABLE foo DISABLE TRIGGER ALL;"), 0644)).To(Succeed())
+		})
+		AfterEach(func() {
+			_ = os.RemoveAll(filepath.Dir(scriptFile))
+		})
+		It("runs the pre-restore script", func() {
+			mock.ExpectExec("ALTER TABLE foo DISABLE TRIGGER ALL;").WillReturnResult(sqlmock.NewResult(0, 0))
+
+			restore.RunPreRestoreScript(scriptFile)
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			testhelper.ExpectRegexp(logfile, "[INFO]:-Running pre-restore script "+scriptFile)
+		})
+		It("does nothing when no script is given", func() {
+			restore.RunPreRestoreScript("")
+			restore.RunPostRestoreScript("")
+
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("aborts the restore if the pre-restore script fails", func() {
+			mock.ExpectExec("ALTER TABLE foo DISABLE TRIGGER ALL;").WillReturnError(errors.New(`relation "foo" does not exist`))
+			defer testhelper.ShouldPanicWithMessage(`[CRITICAL]:-relation "foo" does not exist: Pre-restore script ` + scriptFile + " failed")
+
+			restore.RunPreRestoreScript(scriptFile)
+		})
+		It("only warns if the post-restore script fails", func() {
+			mock.ExpectExec("ALTER TABLE foo DISABLE TRIGGER ALL;").WillReturnError(errors.New(`relation "foo" does not exist`))
+
+			restore.RunPostRestoreScript(scriptFile)
+
+			testhelper.ExpectRegexp(logfile, `[WARNING]:-Post-restore script `+scriptFile+` failed: relation "foo" does not exist`)
+		})
+	})
 	Describe("SetRestorePlanForLegacyBackup", func() {
 		legacyBackupConfig := history.BackupConfig{}
 		legacyBackupConfig.RestorePlan = nil
@@ -235,7 +235,7 @@ func DefaultACLForTypeWithGrant(grantee string, objType string) backup.ACL {
 		ReferencesWithGrant: objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW",
 		TriggerWithGrant:    objType == "TABLE" || objType == "VIEW" || objType == "MATERIALIZED VIEW",
 		UsageWithGrant:      objType == "LANGUAGE" || objType == "SCHEMA" || objType == "SEQUENCE" || objType == "FOREIGN DATA WRAPPER" || objType == "FOREIGN SERVER",
-		ExecuteWithGrant:    objType == "FUNCTION",
+		ExecuteWithGrant:    objType == "FUNCTION" || objType == "AGGREGATE",
 		CreateWithGrant:     objType == "DATABASE" || objType == "SCHEMA" || objType == "TABLESPACE",
 		TemporaryWithGrant:  objType == "DATABASE",
 		ConnectWithGrant:    objType == "DATABASE",
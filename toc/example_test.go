@@ -0,0 +1,34 @@
+package toc_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/greenplum-db/gpbackup/toc"
+)
+
+func ExampleLoadTOC() {
+	dir, _ := ioutil.TempDir("", "gpbackup_example")
+	defer os.RemoveAll(dir)
+	tocFilename := filepath.Join(dir, "gpbackup_20170101010101_toc.yaml")
+	_ = ioutil.WriteFile(tocFilename, []byte(`formatversion: 1
+dataentries:
+- schema: public
+  name: foo
+  oid: 16384
+  attributestring: (i)
+  rowscopied: 3
+`), 0644)
+
+	tocfile, err := toc.LoadTOC(tocFilename)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, entry := range tocfile.DataEntriesForTable("public", "foo") {
+		fmt.Printf("%s.%s: %d rows\n", entry.Schema, entry.Name, entry.RowsCopied)
+	}
+	// Output: public.foo: 3 rows
+}
@@ -5,19 +5,44 @@ import (
 	"io"
 	"io/ioutil"
 	"regexp"
+	"strings"
 
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 )
 
+/*
+ * PostgreSQL cannot allocate a query string of 1GB or more, so no statement in
+ * the metadata file may be larger than this.
+ */
+const MAX_STATEMENT_SIZE = 1024*1024*1024 - 1
+
+/*
+ * Bump FORMAT_VERSION whenever the TOC changes in a way that an older
+ * gprestore cannot safely ignore.  TOC files written before versioning have a
+ * FormatVersion of 0.
+ *
+ * The exported TOC types are also read by external Go programs through
+ * LoadTOC, so within a format version fields may be added but are never
+ * renamed, removed, or given a different type.
+ */
+const FORMAT_VERSION = 1
+
 type TOC struct {
 	metadataEntryMap    map[string]*[]MetadataEntry
+	FormatVersion       int
+	RequiredSections    []string `yaml:",omitempty"`
+	ContentAddressed    bool     `yaml:",omitempty"`
 	GlobalEntries       []MetadataEntry
 	PredataEntries      []MetadataEntry
 	PostdataEntries     []MetadataEntry
 	StatisticsEntries   []MetadataEntry
 	DataEntries         []MasterDataEntry
+	SkippedDataEntries  []SkippedDataEntry
+	ColumnDefaults      []ColumnDefaultEntry
+	TablesWithOids      []string `yaml:",omitempty"`
 	IncrementalMetadata IncrementalEntries
 }
 
@@ -34,6 +59,13 @@ type MetadataEntry struct {
 	EndByte         uint64
 }
 
+func (entry MetadataEntry) FQN() string {
+	if entry.Schema == "" {
+		return entry.Name
+	}
+	return utils.MakeFQN(entry.Schema, entry.Name)
+}
+
 type MasterDataEntry struct {
 	Schema          string
 	Name            string
@@ -41,6 +73,43 @@ type MasterDataEntry struct {
 	AttributeString string
 	RowsCopied      int64
 	PartitionRoot   string
+	Format          string         // csv or binary; empty for backups taken before --data-format existed
+	ContentHashes   map[int]string `yaml:",omitempty"` // data file name per content with --content-addressed-data
+	// expression backed up in place of each column's data with --exclude-column-data
+	ColumnSubstitutions map[string]string `yaml:",omitempty"`
+}
+
+/*
+ * Tables whose data was deliberately left out of the backup, such as
+ * replicated tables with --no-data-for-replicated-tables, so that gprestore
+ * can tell them apart from tables that simply had no data entry.
+ */
+type SkippedDataEntry struct {
+	Schema string
+	Name   string
+	Reason string
+}
+
+// The reasons recorded for tables whose data was left out of the backup
+const (
+	SKIP_REASON_EXTERNAL     = "EXTERNAL TABLE"
+	SKIP_REASON_FOREIGN      = "FOREIGN TABLE"
+	SKIP_REASON_REPLICATED   = "DISTRIBUTED REPLICATED"
+	SKIP_REASON_INACCESSIBLE = "INSUFFICIENT PRIVILEGES"
+	SKIP_REASON_EXCLUDED     = "EXCLUDED LEAF PARTITION"
+)
+
+/*
+ * Column defaults are recorded with the column's type so that gprestore can
+ * check that each default expression is still accepted by the target cluster
+ * before any tables are created.
+ */
+type ColumnDefaultEntry struct {
+	Schema  string
+	Table   string
+	Column  string
+	Type    string
+	Default string
 }
 
 type SegmentDataEntry struct {
@@ -49,7 +118,8 @@ type SegmentDataEntry struct {
 }
 
 type IncrementalEntries struct {
-	AO map[string]AOEntry
+	AO   map[string]AOEntry
+	Heap map[string]HeapEntry
 }
 
 type AOEntry struct {
@@ -57,15 +127,65 @@ type AOEntry struct {
 	LastDDLTimestamp string
 }
 
-func NewTOC(filename string) *TOC {
+/*
+ * The table statistics counters and relfilenode of a table, from which a
+ * later backup can tell whether the table has probably changed.
+ */
+type HeapEntry struct {
+	TuplesInserted int64
+	TuplesUpdated  int64
+	TuplesDeleted  int64
+	RelFileNode    uint32
+}
+
+/*
+ * LoadTOC reads the TOC file written by gpbackup, returning an error instead
+ * of exiting so that it can be used by programs other than gprestore.
+ */
+func LoadTOC(filename string) (*TOC, error) {
 	toc := &TOC{}
 	contents, err := ioutil.ReadFile(filename)
-	gplog.FatalOnError(err)
+	if err != nil {
+		return nil, err
+	}
 	err = yaml.Unmarshal(contents, toc)
+	if err != nil {
+		// A newer format may have changed the type of a field we know about
+		versionInfo := struct{ FormatVersion int }{}
+		if yaml.Unmarshal(contents, &versionInfo) == nil && versionInfo.FormatVersion > FORMAT_VERSION {
+			err = errors.Errorf("TOC file %s was written using TOC format %d; this gprestore supports up to format %d",
+				filename, versionInfo.FormatVersion, FORMAT_VERSION)
+		}
+		return nil, err
+	}
+	return toc, nil
+}
+
+func NewTOC(filename string) *TOC {
+	toc, err := LoadTOC(filename)
 	gplog.FatalOnError(err)
 	return toc
 }
 
+/*
+ * DataEntriesForTable returns the data entries for the given table.  The
+ * schema and name are matched as they appear in the TOC, i.e. quoted where
+ * quote_ident would quote them.
+ */
+func (toc *TOC) DataEntriesForTable(schema string, name string) []MasterDataEntry {
+	entries := make([]MasterDataEntry, 0)
+	for _, entry := range toc.DataEntries {
+		if entry.Schema == schema && entry.Name == name {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (toc *TOC) UnknownRequiredSections() []string {
+	return utils.UnknownYAMLKeys(toc, toc.RequiredSections)
+}
+
 func NewSegmentTOC(filename string) *SegmentTOC {
 	toc := &SegmentTOC{}
 	contents, err := ioutil.ReadFile(filename)
@@ -121,19 +241,49 @@ func GetIncludedPartitionRoots(tocDataEntries []MasterDataEntry, includeRelation
 }
 
 func (toc *TOC) GetSQLStatementForObjectTypes(section string, metadataFile io.ReaderAt, includeObjectTypes []string, excludeObjectTypes []string, includeSchemas []string, excludeSchemas []string, includeRelations []string, excludeRelations []string) []StatementWithType {
+	entries := toc.GetMetadataEntriesForObjectTypes(section, includeObjectTypes, excludeObjectTypes, includeSchemas, excludeSchemas, includeRelations, excludeRelations)
+	statements := make([]StatementWithType, 0, len(entries))
+	for _, entry := range entries {
+		statement, err := ReadStatement(metadataFile, entry)
+		gplog.FatalOnError(err)
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+/*
+ * Returns the entries whose statements GetSQLStatementForObjectTypes would
+ * return, without reading the statements themselves, so that a caller can read
+ * and execute them one at a time instead of holding a whole section in memory.
+ */
+func (toc *TOC) GetMetadataEntriesForObjectTypes(section string, includeObjectTypes []string, excludeObjectTypes []string, includeSchemas []string, excludeSchemas []string, includeRelations []string, excludeRelations []string) []MetadataEntry {
 	entries := *toc.metadataEntryMap[section]
+	if len(excludeRelations) > 0 {
+		// Objects such as triggers and indexes on leaf partitions reference the leaf, not the excluded root
+		excludeRelations = append(excludeRelations, getLeafPartitions(excludeRelations, toc.DataEntries)...)
+	}
 
 	objectSet, schemaSet, relationSet := constructFilterSets(includeObjectTypes, excludeObjectTypes, includeSchemas, excludeSchemas, includeRelations, excludeRelations)
-	statements := make([]StatementWithType, 0)
+	filteredEntries := make([]MetadataEntry, 0)
 	for _, entry := range entries {
 		if shouldIncludeStatement(entry, objectSet, schemaSet, relationSet) {
-			contents := make([]byte, entry.EndByte-entry.StartByte)
-			_, err := metadataFile.ReadAt(contents, int64(entry.StartByte))
-			gplog.FatalOnError(err)
-			statements = append(statements, StatementWithType{Schema: entry.Schema, Name: entry.Name, ObjectType: entry.ObjectType, ReferenceObject: entry.ReferenceObject, Statement: string(contents)})
+			filteredEntries = append(filteredEntries, entry)
 		}
 	}
-	return statements
+	return filteredEntries
+}
+
+func ReadStatement(metadataFile io.ReaderAt, entry MetadataEntry) (StatementWithType, error) {
+	if entry.EndByte-entry.StartByte > MAX_STATEMENT_SIZE {
+		return StatementWithType{}, errors.Errorf("Statement for %s %s is %d bytes, which exceeds the maximum statement size of %d bytes",
+			entry.ObjectType, entry.FQN(), entry.EndByte-entry.StartByte, MAX_STATEMENT_SIZE)
+	}
+	contents := make([]byte, entry.EndByte-entry.StartByte)
+	_, err := metadataFile.ReadAt(contents, int64(entry.StartByte))
+	if err != nil {
+		return StatementWithType{}, errors.Wrapf(err, "Could not read statement for %s %s", entry.ObjectType, entry.FQN())
+	}
+	return StatementWithType{Schema: entry.Schema, Name: entry.Name, ObjectType: entry.ObjectType, ReferenceObject: entry.ReferenceObject, Statement: string(contents)}, nil
 }
 
 func constructFilterSets(includeObjectTypes []string, excludeObjectTypes []string, includeSchemas []string, excludeSchemas []string, includeRelations []string, excludeRelations []string) (*utils.FilterSet, *utils.FilterSet, *utils.FilterSet) {
@@ -220,8 +370,41 @@ func (toc *TOC) GetDataEntriesMatching(includeSchemas []string, excludeSchemas [
 	return matchingEntries
 }
 
+func (toc *TOC) GetSkippedDataEntriesMatching(includeSchemas []string, excludeSchemas []string,
+	includeTableFQNs []string, excludeTableFQNs []string) []SkippedDataEntry {
+
+	schemaSet := utils.NewIncludeSet([]string{})
+	if len(includeSchemas) > 0 {
+		schemaSet = utils.NewIncludeSet(includeSchemas)
+	} else if len(excludeSchemas) > 0 {
+		schemaSet = utils.NewExcludeSet(excludeSchemas)
+	}
+
+	tableSet := utils.NewIncludeSet([]string{})
+	if len(includeTableFQNs) > 0 {
+		tableSet = utils.NewIncludeSet(includeTableFQNs)
+	} else if len(excludeTableFQNs) > 0 {
+		tableSet = utils.NewExcludeSet(excludeTableFQNs)
+	}
+
+	matchingEntries := make([]SkippedDataEntry, 0)
+	for _, entry := range toc.SkippedDataEntries {
+		if schemaSet.MatchesFilter(entry.Schema) && tableSet.MatchesFilter(utils.MakeFQN(entry.Schema, entry.Name)) {
+			matchingEntries = append(matchingEntries, entry)
+		}
+	}
+	return matchingEntries
+}
+
+/*
+ * The source database name appears in the CREATE DATABASE statement, in the
+ * ALTER DATABASE ... SET, OWNER TO, GRANT, REVOKE, COMMENT, and SECURITY LABEL
+ * statements printed for it, and in ALTER ROLE ... IN DATABASE ... SET
+ * statements for role settings specific to it.  Role settings for other
+ * databases are left alone.
+ */
 func SubstituteRedirectDatabaseInStatements(statements []StatementWithType, oldQuotedName string, newQuotedName string) []StatementWithType {
-	shouldReplace := map[string]bool{"DATABASE GUC": true, "DATABASE": true, "DATABASE METADATA": true}
+	shouldReplace := map[string]bool{"DATABASE GUC": true, "DATABASE": true, "DATABASE METADATA": true, "ROLE GUCS": true}
 	pattern := regexp.MustCompile(fmt.Sprintf("DATABASE %s(;| OWNER| SET| TO| FROM| IS| TEMPLATE)", regexp.QuoteMeta(oldQuotedName)))
 	for i := range statements {
 		if shouldReplace[statements[i].ObjectType] {
@@ -231,6 +414,64 @@ func SubstituteRedirectDatabaseInStatements(statements []StatementWithType, oldQ
 	return statements
 }
 
+/*
+ * Role names appear in the statements gpbackup prints for ownership, grants
+ * and revokes, quoted as by quote_ident.  Each pattern captures one role name.
+ */
+const roleIdentPattern = `("(?:[^"]|"")+"|[^\s;,"]+)`
+
+var roleReferenceRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`OWNER TO ` + roleIdentPattern + `;`),
+	regexp.MustCompile(`CREATE SCHEMA [^;]* AUTHORIZATION ` + roleIdentPattern + `;`),
+	regexp.MustCompile(`SET (?:SESSION AUTHORIZATION|ROLE) ` + roleIdentPattern + `;`),
+	regexp.MustCompile(`DEFAULT PRIVILEGES FOR ROLE ` + roleIdentPattern + ` `),
+	regexp.MustCompile(`GRANT [^;]* TO ` + roleIdentPattern + `(?: WITH GRANT OPTION| WITH ADMIN OPTION)?(?: GRANTED BY [^;]+)?;`),
+	regexp.MustCompile(`GRANTED BY ` + roleIdentPattern + `;`),
+	regexp.MustCompile(`REVOKE [^;]* FROM ` + roleIdentPattern + `;`),
+}
+
+func replaceRoleReferences(statement string, replace func(role string) string) string {
+	for _, pattern := range roleReferenceRegexes {
+		var result strings.Builder
+		last := 0
+		for _, match := range pattern.FindAllStringSubmatchIndex(statement, -1) {
+			result.WriteString(statement[last:match[2]])
+			result.WriteString(replace(statement[match[2]:match[3]]))
+			last = match[3]
+		}
+		result.WriteString(statement[last:])
+		statement = result.String()
+	}
+	return statement
+}
+
+/*
+ * Rewrites the owners, grantees and grantors in a statement using a map of
+ * quoted role names.  Roles not in the map, and PUBLIC, are left unchanged.
+ */
+func RemapRolesInStatement(statement string, roleMap map[string]string) string {
+	if len(roleMap) == 0 {
+		return statement
+	}
+	return replaceRoleReferences(statement, func(role string) string {
+		if newRole, ok := roleMap[role]; ok {
+			return newRole
+		}
+		return role
+	})
+}
+
+func GetRolesInStatement(statement string) []string {
+	roles := make([]string, 0)
+	replaceRoleReferences(statement, func(role string) string {
+		if role != "PUBLIC" {
+			roles = append(roles, role)
+		}
+		return role
+	})
+	return roles
+}
+
 func RemoveActiveRole(activeUser string, statements []StatementWithType) []StatementWithType {
 	newStatements := make([]StatementWithType, 0)
 	for _, statement := range statements {
@@ -265,8 +506,32 @@ func (toc *TOC) AddMetadataEntry(section string, entry MetadataEntry, start, end
 	*toc.metadataEntryMap[section] = append(*toc.metadataEntryMap[section], entry)
 }
 
-func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string) {
-	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{schema, name, oid, attributeString, rowsCopied, PartitionRoot})
+func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string, rowsCopied int64, PartitionRoot string, format string) {
+	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{schema, name, oid, attributeString, rowsCopied, PartitionRoot, format, nil, nil})
+}
+
+func (toc *TOC) AddSkippedDataEntry(schema string, name string, reason string) {
+	toc.SkippedDataEntries = append(toc.SkippedDataEntries, SkippedDataEntry{schema, name, reason})
+}
+
+func (toc *TOC) CountSkippedDataEntries() map[string]int {
+	counts := make(map[string]int)
+	for _, entry := range toc.SkippedDataEntries {
+		counts[entry.Reason]++
+	}
+	return counts
+}
+
+func (toc *TOC) AddColumnDefaultEntry(schema string, table string, column string, columnType string, defaultVal string) {
+	toc.ColumnDefaults = append(toc.ColumnDefaults, ColumnDefaultEntry{schema, table, column, columnType, defaultVal})
+}
+
+/*
+ * Tables created WITH OIDS are recorded by name so that gprestore can report
+ * each one whose OIDS it removes when restoring to GPDB 7, which has none.
+ */
+func (toc *TOC) AddTableWithOids(schema string, table string) {
+	toc.TablesWithOids = append(toc.TablesWithOids, utils.MakeFQN(schema, table))
 }
 
 func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64) {
@@ -2,8 +2,12 @@ package toc_test
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/toc"
 
@@ -76,6 +80,32 @@ var _ = Describe("utils/toc tests", func() {
 
 			metadataFile = bytes.NewReader([]byte(table1.Statement + capsTable.Statement + table2.Statement + view.Statement + matView.Statement + sequence.Statement + index.Statement))
 		})
+		It("panics with the object name if a statement exceeds the maximum statement size", func() {
+			testhelper.SetupTestLogger()
+			tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "schema", Name: "bigschema", ObjectType: "SCHEMA"}, 0, toc.MAX_STATEMENT_SIZE+1)
+
+			defer testhelper.ShouldPanicWithMessage(fmt.Sprintf("Statement for SCHEMA schema.bigschema is %d bytes, which exceeds the maximum statement size of %d bytes", toc.MAX_STATEMENT_SIZE+1, toc.MAX_STATEMENT_SIZE))
+			tocfile.GetSQLStatementForObjectTypes("predata", metadataFile, []string{"SCHEMA"}, noExObj, noInSchema, noExSchema, noInRelation, noExRelation)
+		})
+		It("reads each of many thousands of statements in a section by its own byte range", func() {
+			numStatements := 20000
+			var contents bytes.Buffer
+			for i := 0; i < numStatements; i++ {
+				start := uint64(contents.Len())
+				contents.WriteString(fmt.Sprintf("\n\nGRANT ALL ON SCHEMA schema%d TO role%d;\n", i%10, i))
+				tocfile.AddMetadataEntry("predata", toc.MetadataEntry{Schema: "", Name: fmt.Sprintf("schema%d", i%10), ObjectType: "SCHEMA"}, start, uint64(contents.Len()))
+			}
+			largeMetadataFile := bytes.NewReader(contents.Bytes())
+
+			entries := tocfile.GetMetadataEntriesForObjectTypes("predata", []string{"SCHEMA"}, noExObj, noInSchema, noExSchema, noInRelation, noExRelation)
+
+			Expect(entries).To(HaveLen(numStatements))
+			for i, entry := range entries {
+				statement, err := toc.ReadStatement(largeMetadataFile, entry)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(statement.Statement).To(Equal(fmt.Sprintf("\n\nGRANT ALL ON SCHEMA schema%d TO role%d;\n", i%10, i)))
+			}
+		})
 		It("returns statement for a single object type", func() {
 			statements := tocfile.GetSQLStatementForObjectTypes("predata", metadataFile, []string{"VIEW"}, noExObj, noInSchema, noExSchema, noInRelation, noExRelation)
 
@@ -192,14 +222,42 @@ var _ = Describe("utils/toc tests", func() {
 				Expect(statements).To(Equal([]toc.StatementWithType{table1, capsTable, view, matView, sequence, sequenceTable, sequenceOwner}))
 			})
 		})
+		Context("Postdata objects on excluded tables", func() {
+			rule := toc.StatementWithType{Schema: "schema", Name: "rule1", ObjectType: "RULE", ReferenceObject: "schema.table1", Statement: "CREATE RULE rule1 AS ON UPDATE TO schema.table1 DO INSTEAD NOTHING;"}
+			trigger := toc.StatementWithType{Schema: "schema", Name: "trigger1", ObjectType: "TRIGGER", ReferenceObject: "schema.table1", Statement: "CREATE TRIGGER trigger1 BEFORE INSERT ON schema.table1 FOR EACH ROW EXECUTE PROCEDURE public.trigger_func();"}
+			partIndex := toc.StatementWithType{Schema: "schema", Name: "part_idx", ObjectType: "INDEX", ReferenceObject: "schema.part_1_prt_1", Statement: "CREATE INDEX part_idx ON schema.part_1_prt_1 USING btree (i);"}
+			otherIndex := toc.StatementWithType{Schema: "schema2", Name: "other_idx", ObjectType: "INDEX", ReferenceObject: "schema2.table2", Statement: "CREATE INDEX other_idx ON schema2.table2 USING btree (i);"}
+
+			BeforeEach(func() {
+				tocfile.AddMasterDataEntry("schema", "part", 1, "(i)", 0, "", "")
+				tocfile.AddMasterDataEntry("schema", "part_1_prt_1", 2, "(i)", 0, "part", "")
+				var contents string
+				for _, statement := range []toc.StatementWithType{rule, trigger, partIndex, otherIndex} {
+					start := uint64(len(contents))
+					contents += statement.Statement
+					tocfile.AddMetadataEntry("postdata", toc.MetadataEntry{Schema: statement.Schema, Name: statement.Name, ObjectType: statement.ObjectType, ReferenceObject: statement.ReferenceObject}, start, uint64(len(contents)))
+				}
+				metadataFile = bytes.NewReader([]byte(contents))
+			})
+			It("returns no rules or triggers referencing an excluded table", func() {
+				statements := tocfile.GetSQLStatementForObjectTypes("postdata", metadataFile, noInObj, noExObj, noInSchema, noExSchema, noInRelation, []string{"schema.table1"})
+
+				Expect(statements).To(Equal([]toc.StatementWithType{partIndex, otherIndex}))
+			})
+			It("returns no statements referencing a leaf partition of an excluded table", func() {
+				statements := tocfile.GetSQLStatementForObjectTypes("postdata", metadataFile, noInObj, noExObj, noInSchema, noExSchema, noInRelation, []string{"schema.part"})
+
+				Expect(statements).To(Equal([]toc.StatementWithType{rule, trigger, otherIndex}))
+			})
+		})
 	})
 	Describe("GetDataEntriesMatching", func() {
 		BeforeEach(func() {
-			tocfile.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "")
-			tocfile.AddMasterDataEntry("schema2", "table2", 1, "(i)", 0, "")
-			tocfile.AddMasterDataEntry("schema3", "table3", 1, "(i)", 0, "")
-			tocfile.AddMasterDataEntry("schema3", "table3_partition1", 1, "(i)", 0, "table3")
-			tocfile.AddMasterDataEntry("schema3", "table3_partition2", 1, "(i)", 0, "table3")
+			tocfile.AddMasterDataEntry("schema1", "table1", 1, "(i)", 0, "", "")
+			tocfile.AddMasterDataEntry("schema2", "table2", 1, "(i)", 0, "", "")
+			tocfile.AddMasterDataEntry("schema3", "table3", 1, "(i)", 0, "", "")
+			tocfile.AddMasterDataEntry("schema3", "table3_partition1", 1, "(i)", 0, "table3", "")
+			tocfile.AddMasterDataEntry("schema3", "table3_partition2", 1, "(i)", 0, "table3", "")
 		})
 		Context("Non-empty restore plan", func() {
 			restorePlanTableFQNs := []string{"schema1.table1", "schema2.table2", "schema3.table3", "schema3.table3_partition1", "schema3.table3_partition2"}
@@ -341,6 +399,24 @@ var _ = Describe("utils/toc tests", func() {
 			})
 		})
 	})
+	Describe("SkippedDataEntries", func() {
+		BeforeEach(func() {
+			tocfile.AddSkippedDataEntry("schema1", "ext1", toc.SKIP_REASON_EXTERNAL)
+			tocfile.AddSkippedDataEntry("schema1", "ext2", toc.SKIP_REASON_EXTERNAL)
+			tocfile.AddSkippedDataEntry("schema2", "foreign1", toc.SKIP_REASON_FOREIGN)
+		})
+		It("counts skipped data entries by reason", func() {
+			Expect(tocfile.CountSkippedDataEntries()).To(Equal(map[string]int{toc.SKIP_REASON_EXTERNAL: 2, toc.SKIP_REASON_FOREIGN: 1}))
+		})
+		It("returns skipped data entries matching an include schema", func() {
+			matchingEntries := tocfile.GetSkippedDataEntriesMatching([]string{"schema2"}, []string{}, []string{}, []string{})
+			Expect(matchingEntries).To(Equal([]toc.SkippedDataEntry{{Schema: "schema2", Name: "foreign1", Reason: toc.SKIP_REASON_FOREIGN}}))
+		})
+		It("returns skipped data entries not matching an exclude table", func() {
+			matchingEntries := tocfile.GetSkippedDataEntriesMatching([]string{}, []string{}, []string{}, []string{"schema1.ext1", "schema2.foreign1"})
+			Expect(matchingEntries).To(Equal([]toc.SkippedDataEntry{{Schema: "schema1", Name: "ext2", Reason: toc.SKIP_REASON_EXTERNAL}}))
+		})
+	})
 	Describe("SubstituteRedirectDatabaseInStatements", func() {
 		create := toc.StatementWithType{Schema: "", Name: "somedatabase", ObjectType: "DATABASE", Statement: "CREATE DATABASE somedatabase TEMPLATE template0;\n"}
 		wrongCreate := toc.StatementWithType{ObjectType: "TABLE", Statement: "CREATE DATABASE somedatabase;\n"}
@@ -383,6 +459,45 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(statements[0].Statement).To(Equal(`CREATE DATABASE "db-special-chär$" TEMPLATE template0;
 `))
 		})
+		It("substitutes the database name in role settings for that database only", func() {
+			roleGUCs := []toc.StatementWithType{
+				{ObjectType: "ROLE GUCS", Statement: "\n\nALTER ROLE testrole IN DATABASE somedatabase SET work_mem TO '64MB';"},
+				{ObjectType: "ROLE GUCS", Statement: "\n\nALTER ROLE testrole IN DATABASE otherdatabase SET work_mem TO '64MB';"},
+				{ObjectType: "ROLE GUCS", Statement: "\n\nALTER ROLE testrole SET work_mem TO '64MB';"},
+			}
+			statements := toc.SubstituteRedirectDatabaseInStatements(roleGUCs, "somedatabase", "newdatabase")
+			Expect(statements[0].Statement).To(Equal("\n\nALTER ROLE testrole IN DATABASE newdatabase SET work_mem TO '64MB';"))
+			Expect(statements[1].Statement).To(Equal("\n\nALTER ROLE testrole IN DATABASE otherdatabase SET work_mem TO '64MB';"))
+			Expect(statements[2].Statement).To(Equal("\n\nALTER ROLE testrole SET work_mem TO '64MB';"))
+		})
+		It("leaves no mention of the source database when restoring a backup of prod into prod_copy", func() {
+			statements := []toc.StatementWithType{
+				{ObjectType: "DATABASE", Statement: "\n\nCREATE DATABASE prod TEMPLATE template0 TABLESPACE prod_space ENCODING 'UTF8';"},
+				{ObjectType: "DATABASE GUC", Statement: "\nALTER DATABASE prod SET search_path TO public, pg_catalog;"},
+				{ObjectType: "DATABASE GUC", Statement: "\nALTER DATABASE prod SET statement_timeout TO '5min';"},
+				{ObjectType: "DATABASE METADATA", Statement: `
+
+COMMENT ON DATABASE prod IS 'production database';
+
+
+ALTER DATABASE prod OWNER TO dbowner;
+
+
+REVOKE ALL ON DATABASE prod FROM PUBLIC;
+REVOKE ALL ON DATABASE prod FROM dbowner;
+GRANT ALL ON DATABASE prod TO dbowner;
+GRANT CONNECT,TEMPORARY ON DATABASE prod TO reporting WITH GRANT OPTION;
+
+
+SECURITY LABEL FOR dummy ON DATABASE prod IS 'unclassified';`},
+				{ObjectType: "ROLE GUCS", Statement: "\n\nALTER ROLE reporting IN DATABASE prod SET work_mem TO '64MB';"},
+			}
+			statements = toc.SubstituteRedirectDatabaseInStatements(statements, "prod", "prod_copy")
+			for _, statement := range statements {
+				Expect(statement.Statement).To(ContainSubstring("DATABASE prod_copy"))
+				Expect(statement.Statement).ToNot(MatchRegexp(`DATABASE prod\b`), statement.Statement)
+			}
+		})
 	})
 	Describe("RemoveActiveRoles", func() {
 		user1 := toc.StatementWithType{Name: "user1", ObjectType: "ROLE", Statement: "CREATE ROLE user1 SUPERUSER;\n"}
@@ -398,24 +513,63 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(resultStatements).To(Equal([]toc.StatementWithType{user1, user2}))
 		})
 	})
+	Describe("RemapRolesInStatement", func() {
+		roleMap := map[string]string{"prod_app": "dev_app", `"Prod Reader"`: `"Dev Reader"`}
+		It("remaps owners, grantees and grantors", func() {
+			statement := `
+
+ALTER TABLE public.foo OWNER TO prod_app;
+
+
+REVOKE ALL ON TABLE public.foo FROM PUBLIC;
+REVOKE ALL ON TABLE public.foo FROM prod_app;
+GRANT ALL ON TABLE public.foo TO prod_app;
+SET SESSION AUTHORIZATION prod_app; GRANT SELECT ON TABLE public.foo TO "Prod Reader" WITH GRANT OPTION; RESET SESSION AUTHORIZATION;
+GRANT SELECT ON TABLE public.foo TO other_role;`
+			Expect(toc.RemapRolesInStatement(statement, roleMap)).To(Equal(`
+
+ALTER TABLE public.foo OWNER TO dev_app;
+
+
+REVOKE ALL ON TABLE public.foo FROM PUBLIC;
+REVOKE ALL ON TABLE public.foo FROM dev_app;
+GRANT ALL ON TABLE public.foo TO dev_app;
+SET SESSION AUTHORIZATION dev_app; GRANT SELECT ON TABLE public.foo TO "Dev Reader" WITH GRANT OPTION; RESET SESSION AUTHORIZATION;
+GRANT SELECT ON TABLE public.foo TO other_role;`))
+		})
+		It("remaps schema authorization and default privileges", func() {
+			statement := "CREATE SCHEMA sales AUTHORIZATION prod_app;\nALTER DEFAULT PRIVILEGES FOR ROLE prod_app GRANT SELECT ON TABLES TO \"Prod Reader\";"
+			Expect(toc.RemapRolesInStatement(statement, roleMap)).To(Equal("CREATE SCHEMA sales AUTHORIZATION dev_app;\nALTER DEFAULT PRIVILEGES FOR ROLE dev_app GRANT SELECT ON TABLES TO \"Dev Reader\";"))
+		})
+		It("does not change object names that match a role name", func() {
+			statement := "CREATE TABLE public.prod_app (i int);\nALTER TABLE public.prod_app OWNER TO prod_app;"
+			Expect(toc.RemapRolesInStatement(statement, roleMap)).To(Equal("CREATE TABLE public.prod_app (i int);\nALTER TABLE public.prod_app OWNER TO dev_app;"))
+		})
+	})
+	Describe("GetRolesInStatement", func() {
+		It("returns every role used by the statement except PUBLIC", func() {
+			statement := "ALTER VIEW public.v OWNER TO owner_role;\nREVOKE ALL ON TABLE public.v FROM PUBLIC;\nGRANT member_role TO \"New Member\" WITH ADMIN OPTION GRANTED BY grantor_role;"
+			Expect(toc.GetRolesInStatement(statement)).To(Equal([]string{"owner_role", `"New Member"`, "grantor_role"}))
+		})
+	})
 	Describe("GetIncludedPartitionRoots", func() {
 		It("does not return anything if relations are not leaf partitions", func() {
-			tocfile.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			tocfile.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
+			tocfile.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", "")
+			tocfile.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{"schema0.name0", "schema1.name1"})
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns root parition of leaf partitions", func() {
-			tocfile.AddMasterDataEntry("schema0", "name0", 2, "attribute0", 1, "root0")
-			tocfile.AddMasterDataEntry("schema1", "name1", 3, "attribute0", 1, "root1")
+			tocfile.AddMasterDataEntry("schema0", "name0", 2, "attribute0", 1, "root0", "")
+			tocfile.AddMasterDataEntry("schema1", "name1", 3, "attribute0", 1, "root1", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{"schema0.name0", "schema1.name1"})
 			Expect(roots).To(ConsistOf("schema0.root0", "schema1.root1"))
 		})
 		It("only returns root partitions of leaf partitions", func() {
-			tocfile.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			tocfile.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			tocfile.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			tocfile.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			tocfile.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", "")
+			tocfile.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", "")
+			tocfile.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", "")
+			tocfile.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{"schema2.name2", "schema3.name3"})
 			Expect(roots).To(ConsistOf("schema2.root2", "schema3.root3"))
 		})
@@ -424,20 +578,67 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns nothing if relation is not part of TOC data entries", func() {
-			tocfile.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			tocfile.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			tocfile.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			tocfile.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			tocfile.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", "")
+			tocfile.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", "")
+			tocfile.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", "")
+			tocfile.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{"schema4.name4", "schema5.name5"})
 			Expect(roots).To(BeEmpty())
 		})
 		It("returns empty if no relations are passed in", func() {
-			tocfile.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "")
-			tocfile.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "")
-			tocfile.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2")
-			tocfile.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3")
+			tocfile.AddMasterDataEntry("schema0", "name0", 0, "attribute0", 1, "", "")
+			tocfile.AddMasterDataEntry("schema1", "name1", 1, "attribute0", 1, "", "")
+			tocfile.AddMasterDataEntry("schema2", "name2", 2, "attribute0", 1, "root2", "")
+			tocfile.AddMasterDataEntry("schema3", "name3", 3, "attribute0", 1, "root3", "")
 			roots := toc.GetIncludedPartitionRoots(tocfile.DataEntries, []string{})
 			Expect(roots).To(BeEmpty())
 		})
 	})
+	Describe("NewTOC", func() {
+		tocFilePath := "/tmp/gpbackup_toc.yaml"
+		AfterEach(func() {
+			_ = os.Remove(tocFilePath)
+		})
+		It("ignores fields it does not know about and reports unknown required sections", func() {
+			err := ioutil.WriteFile(tocFilePath, []byte("formatversion: 1\nrequiredsections: [dataentries, checksumentries]\nnewoptionalfield: true\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			tocfile := toc.NewTOC(tocFilePath)
+			Expect(tocfile.FormatVersion).To(Equal(1))
+			Expect(tocfile.UnknownRequiredSections()).To(Equal([]string{"checksumentries"}))
+		})
+		It("panics with the format version when a newer format cannot be parsed", func() {
+			err := ioutil.WriteFile(tocFilePath, []byte("formatversion: 2\ndataentries: {}\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			defer testhelper.ShouldPanicWithMessage("TOC file /tmp/gpbackup_toc.yaml was written using TOC format 2; this gprestore supports up to format 1")
+			toc.NewTOC(tocFilePath)
+		})
+	})
+	Describe("LoadTOC", func() {
+		tocFilePath := "/tmp/gpbackup_toc.yaml"
+		AfterEach(func() {
+			_ = os.Remove(tocFilePath)
+		})
+		It("returns an error when the file does not exist", func() {
+			_, err := toc.LoadTOC("/tmp/gpbackup_missing_toc.yaml")
+			Expect(err).To(HaveOccurred())
+		})
+		It("returns an error with the format version when a newer format cannot be parsed", func() {
+			err := ioutil.WriteFile(tocFilePath, []byte("formatversion: 2\ndataentries: {}\n"), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = toc.LoadTOC(tocFilePath)
+			Expect(err).To(MatchError("TOC file /tmp/gpbackup_toc.yaml was written using TOC format 2; this gprestore supports up to format 1"))
+		})
+	})
+	Describe("DataEntriesForTable", func() {
+		It("returns only the data entries for the given table", func() {
+			tocfile := &toc.TOC{}
+			tocfile.AddMasterDataEntry("schema1", "table1", 1, "(i)", 10, "", "")
+			tocfile.AddMasterDataEntry("schema1", "table2", 2, "(i)", 20, "", "")
+			tocfile.AddMasterDataEntry("schema2", "table1", 3, "(i)", 30, "", "")
+			entries := tocfile.DataEntriesForTable("schema1", "table1")
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Oid).To(Equal(uint32(1)))
+			Expect(tocfile.DataEntriesForTable("schema3", "table1")).To(BeEmpty())
+		})
+	})
 })
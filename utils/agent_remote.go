@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"io"
 	path "path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/blang/semver"
 	"github.com/greenplum-db/gp-common-go-libs/cluster"
 	"github.com/greenplum-db/gp-common-go-libs/gplog"
 	"github.com/greenplum-db/gp-common-go-libs/iohelper"
@@ -21,6 +23,35 @@ var helperMutex sync.Mutex
  * Functions to run commands on entire cluster during both backup and restore
  */
 
+/*
+ * Backup directories are created, given the requested mode, and checked for
+ * writability by creating and removing a probe file up front, so that a
+ * mis-owned or read-only directory on any host is reported before any
+ * metadata work starts rather than when the first file is written.
+ */
+func PrepareDirectoryCommand(directory string, mode string) string {
+	probeFile := path.Join(directory, ".gpbackup_write_probe")
+	command := fmt.Sprintf("mkdir -p %s", directory)
+	if mode != "" {
+		command += fmt.Sprintf(" && chmod %s %s", mode, directory)
+	}
+	return command + fmt.Sprintf(" && touch %s && rm %s", probeFile, probeFile)
+}
+
+func PrepareDirectoriesOnAllHosts(c *cluster.Cluster, fpInfo filepath.FilePathInfo, scope cluster.Scope, mode string) {
+	remoteOutput := c.GenerateAndExecuteCommand("Preparing backup directories", scope, func(contentID int) string {
+		return PrepareDirectoryCommand(fpInfo.GetDirForContent(contentID), mode)
+	})
+	if remoteOutput.NumErrors == 0 {
+		return
+	}
+	for _, failedCommand := range remoteOutput.FailedCommands {
+		gplog.Error("Unable to prepare backup directory %s on host %s: %s", fpInfo.GetDirForContent(failedCommand.Content),
+			c.GetHostForContent(failedCommand.Content), strings.TrimSpace(failedCommand.Stderr))
+	}
+	cluster.LogFatalClusterError("Unable to prepare backup directories", remoteOutput.Scope, remoteOutput.NumErrors)
+}
+
 /*
  * The reason that gprestore is in charge of creating the first pipe to ensure
  * that the first pipe is created before the first COPY FROM is issued.  If
@@ -91,7 +122,74 @@ func WriteOids(writer io.Writer, oidList []string) error {
 	return nil
 }
 
-func VerifyHelperVersionOnSegments(version string, c *cluster.Cluster) {
+/*
+ * Bump HELPER_DATA_FORMAT_VERSION whenever gpbackup_helper changes how it
+ * frames table data in a single data file, so that data is never written or
+ * read by a helper that frames it differently than gpbackup/gprestore expect.
+ * Helpers written before the handshake existed report no data format version,
+ * and are rejected because they do not accept the handshake flags.
+ */
+const HELPER_DATA_FORMAT_VERSION = 1
+
+/*
+ * gpbackup_helper must match the version of gpbackup/gprestore exactly unless
+ * --allow-helper-version-skew is passed, in which case the following
+ * differences are allowed:
+ *
+ *   no data format version          never
+ *   data format version differs     never
+ *   major version differs           never
+ *   minor or patch version differs  allowed
+ *
+ * Versions that cannot be parsed must always match exactly.  A helperFormat
+ * of 0 means the helper reported no data format version.
+ */
+func CheckHelperVersionCompatibility(coordinatorVersion string, helperVersion string, coordinatorFormat int, helperFormat int, allowSkew bool) error {
+	if coordinatorVersion != helperVersion && !allowSkew {
+		return errors.Errorf("gpbackup_helper version %s does not match gpbackup/gprestore version %s",
+			helperVersion, coordinatorVersion)
+	}
+	if helperFormat == 0 {
+		return errors.Errorf("gpbackup_helper version %s predates the data format version handshake and cannot be used with gpbackup/gprestore version %s",
+			helperVersion, coordinatorVersion)
+	}
+	if coordinatorFormat != helperFormat {
+		return errors.Errorf("gpbackup_helper version %s uses data format version %d, but gpbackup/gprestore version %s uses data format version %d",
+			helperVersion, helperFormat, coordinatorVersion, coordinatorFormat)
+	}
+	if coordinatorVersion == helperVersion {
+		return nil
+	}
+	coordinatorSemver, coordinatorErr := semver.ParseTolerant(coordinatorVersion)
+	helperSemver, helperErr := semver.ParseTolerant(helperVersion)
+	if coordinatorErr != nil || helperErr != nil || coordinatorSemver.Major != helperSemver.Major {
+		return errors.Errorf("gpbackup_helper version %s is not compatible with gpbackup/gprestore version %s",
+			helperVersion, coordinatorVersion)
+	}
+	return nil
+}
+
+/*
+ * gpbackup_helper --version prints "gpbackup_helper version [version string]",
+ * followed by "data format version [number]" for helpers that support the
+ * version handshake.  The data format version is 0 for helpers that do not.
+ */
+func parseHelperVersionOutput(output string) (string, int) {
+	fields := strings.Fields(output)
+	helperVersion := ""
+	if len(fields) > 2 {
+		helperVersion = fields[2]
+	}
+	helperFormat := 0
+	if len(fields) > 6 {
+		if format, err := strconv.Atoi(fields[6]); err == nil {
+			helperFormat = format
+		}
+	}
+	return helperVersion, helperFormat
+}
+
+func VerifyHelperVersionOnSegments(version string, allowSkew bool, c *cluster.Cluster) {
 	remoteOutput := c.GenerateAndExecuteCommand("Verifying gpbackup_helper version", cluster.ON_HOSTS, func(contentID int) string {
 		gphome := operating.System.Getenv("GPHOME")
 		return fmt.Sprintf("%s/bin/gpbackup_helper --version", gphome)
@@ -101,20 +199,23 @@ func VerifyHelperVersionOnSegments(version string, c *cluster.Cluster) {
 	})
 
 	numIncorrect := 0
-	for contentID, cmd := range remoteOutput.Commands {
-		segVersion := strings.TrimSpace(cmd.Stdout)
-		segVersion = strings.Split(segVersion, " ")[2] // Format is "gpbackup_helper version [version string]"
-		if segVersion != version {
-			gplog.Verbose("Version mismatch for gpbackup_helper on segment %d on host %s: Expected version %s, found version %s.", contentID, c.GetHostForContent(contentID), version, segVersion)
+	for _, cmd := range remoteOutput.Commands {
+		helperVersion, helperFormat := parseHelperVersionOutput(cmd.Stdout)
+		err := CheckHelperVersionCompatibility(version, helperVersion, HELPER_DATA_FORMAT_VERSION, helperFormat, allowSkew)
+		if err != nil {
+			gplog.Error("Incompatible gpbackup_helper on host %s: %v", cmd.Host, err)
 			numIncorrect++
+		} else if helperVersion != version {
+			gplog.Warn("Using gpbackup_helper version %s on host %s with gpbackup/gprestore version %s", helperVersion, cmd.Host, version)
 		}
 	}
 	if numIncorrect > 0 {
 		cluster.LogFatalClusterError("The version of gpbackup_helper must match the version of gpbackup/gprestore, but found gpbackup_helper binaries with invalid version", cluster.ON_HOSTS, numIncorrect)
 	}
+	gplog.Verbose("Verified gpbackup_helper version and data format version %d on all hosts", HELPER_DATA_FORMAT_VERSION)
 }
 
-func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, onErrorContinue bool, isFilter bool, wasTerminated *bool) {
+func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, operation string, pluginConfigFile string, compressStr string, onErrorContinue bool, isFilter bool, version string, allowVersionSkew bool, wasTerminated *bool) {
 	// A mutex lock for cleaning up and starting gpbackup helpers prevents a
 	// race condition that causes gpbackup_helpers to be orphaned if
 	// gpbackup_helper cleanup happens before they are started.
@@ -139,13 +240,17 @@ func StartGpbackupHelpers(c *cluster.Cluster, fpInfo filepath.FilePathInfo, oper
 	if isFilter {
 		filterStr = " --with-filters"
 	}
+	versionStr := fmt.Sprintf(" --coordinator-version %s --data-format-version %d", version, HELPER_DATA_FORMAT_VERSION)
+	if allowVersionSkew {
+		versionStr += " --allow-version-skew"
+	}
 	remoteOutput := c.GenerateAndExecuteCommand("Starting gpbackup_helper agent", cluster.ON_SEGMENTS, func(contentID int) string {
 		tocFile := fpInfo.GetSegmentTOCFilePath(contentID)
 		oidFile := fpInfo.GetSegmentHelperFilePath(contentID, "oid")
 		scriptFile := fpInfo.GetSegmentHelperFilePath(contentID, "script")
 		pipeFile := fpInfo.GetSegmentPipeFilePath(contentID)
 		backupFile := fpInfo.GetTableBackupFilePath(contentID, 0, GetPipeThroughProgram().Extension, true)
-		helperCmdStr := fmt.Sprintf("gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file %s --content %d%s%s%s%s", operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr, onErrorContinueStr, filterStr)
+		helperCmdStr := fmt.Sprintf("gpbackup_helper %s --toc-file %s --oid-file %s --pipe-file %s --data-file %s --content %d%s%s%s%s%s", operation, tocFile, oidFile, pipeFile, backupFile, contentID, pluginStr, compressStr, onErrorContinueStr, filterStr, versionStr)
 		// we run these commands in sequence to ensure that any failure is critical; the last command ensures the agent process was successfully started
 		return fmt.Sprintf(`cat << HEREDOC > %[1]s && chmod +x %[1]s && ( nohup %[1]s &> /dev/null &)
 #!/bin/bash
@@ -219,3 +324,125 @@ func CheckAgentErrorsOnSegments(c *cluster.Cluster, fpInfo filepath.FilePathInfo
 	}
 	return nil
 }
+
+/*
+ * Returns a prefix for a COPY PROGRAM command that makes the segment wait for
+ * one of maxPerHost slots on its host before running the command.  Each slot
+ * is a lock file in slotDir, held with flock until the command exits.  Slots
+ * are tried lowest first, so a segment only holds slot N when slots 0 to N-1
+ * are held as well, and the number of slots ever used on a host is its peak
+ * concurrency.  The prefix contains no single quotes, as it is used inside
+ * the quoted PROGRAM string.
+ */
+func HostSlotCommandPrefix(slotDir string, maxPerHost int) string {
+	if maxPerHost <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("mkdir -p %[1]s && while true; do for i in $(seq 0 %[2]d); do exec 9>%[1]s/slot_$i; if flock -n 9; then touch %[1]s/slot_$i.used; break 2; fi; done; sleep 0.2; done; ",
+		slotDir, maxPerHost-1)
+}
+
+/*
+ * Returns the peak number of segments that held a slot at the same time on
+ * each host, and removes the slot directories.
+ */
+func CollectHostSlotUsage(c *cluster.Cluster, fpInfo filepath.FilePathInfo) map[string]int {
+	slotDir := fpInfo.GetHostSlotDir()
+	remoteOutput := c.GenerateAndExecuteCommand("Collecting per-host data concurrency", cluster.ON_HOSTS, func(contentID int) string {
+		return fmt.Sprintf("ls %s 2>/dev/null | grep -c '\\.used$'; rm -rf %s", slotDir, slotDir)
+	})
+	hostConcurrency := make(map[string]int, len(remoteOutput.Commands))
+	for _, cmd := range remoteOutput.Commands {
+		peak, err := strconv.Atoi(strings.TrimSpace(cmd.Stdout))
+		if err != nil {
+			gplog.Verbose("Could not read data concurrency on host %s: %s", cmd.Host, strings.TrimSpace(cmd.Stderr))
+			continue
+		}
+		hostConcurrency[cmd.Host] = peak
+	}
+	return hostConcurrency
+}
+
+/*
+ * Returns a command for a COPY PROGRAM pipeline that converts CSV table data
+ * from one database encoding to another with gpbackup_helper.  The number of
+ * rows that failed conversion on each segment is written to a file in
+ * countDir, named for the segment and table, for CollectConversionErrorCounts
+ * to read.  As with HostSlotCommandPrefix, the command contains no single
+ * quotes.
+ */
+func TranscodeCommand(countDir string, tableOid uint32, sourceEncoding string, targetEncoding string, onError string) string {
+	return fmt.Sprintf("$GPHOME/bin/gpbackup_helper --transcode-agent --content <SEGID> --from-encoding %s --to-encoding %s --on-conversion-error %s --count-file %s/<SEGID>_%d",
+		sourceEncoding, targetEncoding, onError, countDir, tableOid)
+}
+
+/*
+ * Returns the number of rows of each table, by oid, that failed conversion
+ * on any segment, and removes the count directories.
+ */
+func CollectConversionErrorCounts(c *cluster.Cluster, fpInfo filepath.FilePathInfo) map[uint32]int64 {
+	return collectSegmentCounts(c, "Collecting encoding conversion error counts", fpInfo.GetConversionCountDir(), ".")
+}
+
+/*
+ * Returns a command that copies its input to its output in place of cat,
+ * with no more overhead than cat, and writes the number of bytes copied on
+ * each segment to a file in countDir, named for the segment and table, for
+ * CollectLoadByteCounts to read.  dd reports the count on stderr as
+ * "<bytes> bytes ...", with GNU and BSD wording differing after that.
+ */
+func ByteCountCommand(countDir string, tableOid uint32) string {
+	return fmt.Sprintf("dd bs=1048576 2>%s/<SEGID>_%d", countDir, tableOid)
+}
+
+/*
+ * Returns the number of bytes read for each table, by oid, across all
+ * segments, and removes the count directories.
+ */
+func CollectLoadByteCounts(c *cluster.Cluster, fpInfo filepath.FilePathInfo) map[uint32]int64 {
+	return collectSegmentCounts(c, "Collecting table data load sizes", fpInfo.GetLoadByteCountDir(), "' bytes '")
+}
+
+/*
+ * Adds up the counts in the files named "<content>_<oid>" in countDir on
+ * each host, reading the lines of each file that match pattern, and removes
+ * the directories.
+ */
+func collectSegmentCounts(c *cluster.Cluster, verboseMsg string, countDir string, pattern string) map[uint32]int64 {
+	remoteOutput := c.GenerateAndExecuteCommand(verboseMsg, cluster.ON_HOSTS, func(contentID int) string {
+		return fmt.Sprintf("grep -H %[2]s %[1]s/* 2>/dev/null; rm -rf %[1]s", countDir, pattern)
+	})
+	counts := make(map[uint32]int64)
+	for _, cmd := range remoteOutput.Commands {
+		for _, line := range strings.Split(strings.TrimSpace(cmd.Stdout), "\n") {
+			oid, count, ok := parseSegmentCount(line)
+			if !ok {
+				continue
+			}
+			counts[oid] += count
+		}
+	}
+	return counts
+}
+
+// Each line is "<countDir>/<content>_<oid>:<count>[ ...]", as printed by grep -H
+func parseSegmentCount(line string) (uint32, int64, bool) {
+	colon := strings.Index(line, ":")
+	if colon == -1 {
+		return 0, 0, false
+	}
+	nameParts := strings.Split(path.Base(line[:colon]), "_")
+	oid, err := strconv.ParseUint(nameParts[len(nameParts)-1], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	fields := strings.Fields(line[colon+1:])
+	if len(fields) == 0 {
+		return 0, 0, false
+	}
+	count, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return uint32(oid), count, true
+}
@@ -14,6 +14,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
 )
 
 var _ = Describe("agent remote", func() {
@@ -77,6 +78,34 @@ var _ = Describe("agent remote", func() {
 			Expect(string(logfile.Contents())).To(ContainSubstring(`[CRITICAL]:-Failed to scp oid file on 1 segment. See gbytes.Buffer for a complete list of errors.`))
 		})
 	})
+	Describe("PrepareDirectoriesOnAllHosts()", func() {
+		It("creates each directory with the given mode and checks that it is writable", func() {
+			utils.PrepareDirectoriesOnAllHosts(testCluster, fpInfo, cluster.ON_SEGMENTS|cluster.INCLUDE_MASTER, "0700")
+
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc).To(HaveLen(3))
+			Expect(cc[0].CommandString).To(ContainSubstring("mkdir -p /data/gpseg-1/backups/11112233/11112233445566 && chmod 0700 /data/gpseg-1/backups/11112233/11112233445566 && touch /data/gpseg-1/backups/11112233/11112233445566/.gpbackup_write_probe && rm /data/gpseg-1/backups/11112233/11112233445566/.gpbackup_write_probe"))
+			Expect(cc[2].CommandString).To(ContainSubstring("mkdir -p /data/gpseg1/backups/11112233/11112233445566 && chmod 0700"))
+		})
+		It("does not change the mode if none is given", func() {
+			Expect(utils.PrepareDirectoryCommand("/backups/dir", "")).To(Equal("mkdir -p /backups/dir && touch /backups/dir/.gpbackup_write_probe && rm /backups/dir/.gpbackup_write_probe"))
+		})
+		It("reports each failing host before exiting", func() {
+			remoteOutput.NumErrors = 1
+			remoteOutput.Scope = cluster.ON_SEGMENTS
+			remoteOutput.FailedCommands = []*cluster.ShellCommand{
+				{Content: 1, Stderr: "touch: cannot touch 'probe': Permission denied\n", Error: errors.New("exit status 1")},
+			}
+
+			Expect(func() {
+				utils.PrepareDirectoriesOnAllHosts(testCluster, fpInfo, cluster.ON_SEGMENTS, "")
+			}).To(Panic())
+
+			Expect(string(logfile.Contents())).To(ContainSubstring("[ERROR]:-Unable to prepare backup directory /data/gpseg1/backups/11112233/11112233445566 on host remotehost1: touch: cannot touch 'probe': Permission denied"))
+			Expect(string(logfile.Contents())).To(ContainSubstring("[CRITICAL]:-Unable to prepare backup directories on 1 segment."))
+		})
+	})
 	Describe("WriteOidsToFile()", func() {
 		It("writes oid list, delimited by newline characters", func() {
 			utils.WriteOidsToFile("myFilename", oidList)
@@ -123,14 +152,136 @@ var _ = Describe("agent remote", func() {
 			Expect(err).To(Equal(tw.WriteErr))
 		})
 	})
+	Describe("CheckHelperVersionCompatibility", func() {
+		It("accepts identical versions", func() {
+			Expect(utils.CheckHelperVersionCompatibility("1.20.0", "1.20.0", 1, 1, false)).To(Succeed())
+		})
+		It("rejects different versions without --allow-helper-version-skew", func() {
+			err := utils.CheckHelperVersionCompatibility("1.20.0", "1.20.1", 1, 1, false)
+			Expect(err).To(MatchError("gpbackup_helper version 1.20.1 does not match gpbackup/gprestore version 1.20.0"))
+		})
+		It("accepts minor and patch differences with --allow-helper-version-skew", func() {
+			Expect(utils.CheckHelperVersionCompatibility("1.20.0", "1.21.3", 1, 1, true)).To(Succeed())
+		})
+		It("rejects major differences with --allow-helper-version-skew", func() {
+			err := utils.CheckHelperVersionCompatibility("1.20.0", "2.0.0", 1, 1, true)
+			Expect(err).To(MatchError("gpbackup_helper version 2.0.0 is not compatible with gpbackup/gprestore version 1.20.0"))
+		})
+		It("rejects a helper without a data format version with --allow-helper-version-skew", func() {
+			err := utils.CheckHelperVersionCompatibility("1.20.0", "1.19.0", 1, 0, true)
+			Expect(err).To(MatchError("gpbackup_helper version 1.19.0 predates the data format version handshake and cannot be used with gpbackup/gprestore version 1.20.0"))
+		})
+		It("rejects a different data format version even when the versions match", func() {
+			err := utils.CheckHelperVersionCompatibility("1.20.0", "1.20.0", 1, 2, true)
+			Expect(err).To(MatchError("gpbackup_helper version 1.20.0 uses data format version 2, but gpbackup/gprestore version 1.20.0 uses data format version 1"))
+		})
+	})
+	Describe("VerifyHelperVersionOnSegments()", func() {
+		BeforeEach(func() {
+			remoteOutput.Commands = []cluster.ShellCommand{
+				{Host: "localhost", Stdout: "gpbackup_helper version 1.20.0 data format version 1\n"},
+				{Host: "remotehost1", Stdout: "gpbackup_helper version 1.21.0 data format version 1\n"},
+			}
+		})
+		It("panics and names each host with an incompatible helper", func() {
+			defer func() {
+				Expect(logfile).To(Say("Incompatible gpbackup_helper on host remotehost1: gpbackup_helper version 1.21.0 does not match gpbackup/gprestore version 1.20.0"))
+			}()
+			defer testhelper.ShouldPanicWithMessage("found gpbackup_helper binaries with invalid version on 1 host")
+			utils.VerifyHelperVersionOnSegments("1.20.0", false, testCluster)
+		})
+		It("warns about a compatible helper with --allow-helper-version-skew", func() {
+			utils.VerifyHelperVersionOnSegments("1.20.0", true, testCluster)
+			Expect(logfile).To(Say("Using gpbackup_helper version 1.21.0 on host remotehost1 with gpbackup/gprestore version 1.20.0"))
+		})
+		It("panics on a helper that predates the version handshake with --allow-helper-version-skew", func() {
+			remoteOutput.Commands[1].Stdout = "gpbackup_helper version 1.19.0\n"
+			defer func() {
+				Expect(logfile).To(Say("Incompatible gpbackup_helper on host remotehost1: gpbackup_helper version 1.19.0 predates the data format version handshake"))
+			}()
+			defer testhelper.ShouldPanicWithMessage("found gpbackup_helper binaries with invalid version on 1 host")
+			utils.VerifyHelperVersionOnSegments("1.20.0", true, testCluster)
+		})
+	})
 	Describe("StartGpbackupHelpers()", func() {
 		It("Correctly propagates --on-error-continue flag to gpbackup_helper", func() {
 			wasTerminated := false
-			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", true, false, &wasTerminated)
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "/tmp/pluginConfigFile.yml", " compressStr", true, false, "1.2.3", false, &wasTerminated)
 
 			cc := testExecutor.ClusterCommands[0]
 			Expect(cc[1].CommandString).To(ContainSubstring(" --on-error-continue"))
 		})
+		It("passes the coordinator version and data format version to gpbackup_helper", func() {
+			wasTerminated := false
+			utils.StartGpbackupHelpers(testCluster, fpInfo, "operation", "", "", false, false, "1.2.3", true, &wasTerminated)
+
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[1].CommandString).To(ContainSubstring(fmt.Sprintf(" --coordinator-version 1.2.3 --data-format-version %d --allow-version-skew", utils.HELPER_DATA_FORMAT_VERSION)))
+		})
+	})
+	Describe("HostSlotCommandPrefix", func() {
+		It("returns an empty prefix when there is no limit", func() {
+			Expect(utils.HostSlotCommandPrefix("/tmp/slots", 0)).To(Equal(""))
+		})
+		It("waits for one of the given number of slots", func() {
+			Expect(utils.HostSlotCommandPrefix("/tmp/slots", 3)).To(Equal("mkdir -p /tmp/slots && while true; do for i in $(seq 0 2); do exec 9>/tmp/slots/slot_$i; if flock -n 9; then touch /tmp/slots/slot_$i.used; break 2; fi; done; sleep 0.2; done; "))
+		})
+	})
+	Describe("CollectHostSlotUsage", func() {
+		It("returns the number of slots used on each host and removes the slot directories", func() {
+			remoteOutput.Commands = []cluster.ShellCommand{
+				{Host: "localhost", Stdout: "2\n"},
+				{Host: "remotehost1", Stdout: "1\n"},
+			}
+			hostConcurrency := utils.CollectHostSlotUsage(testCluster, fpInfo)
+
+			Expect(hostConcurrency).To(Equal(map[string]int{"localhost": 2, "remotehost1": 1}))
+			slotDir := fmt.Sprintf("/tmp/gpbackup_11112233445566_host_slots_%d", fpInfo.PID)
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0].CommandString).To(ContainSubstring(fmt.Sprintf("rm -rf %s", slotDir)))
+		})
+	})
+	Describe("TranscodeCommand", func() {
+		It("pipes data through the helper and counts failed rows per segment and table", func() {
+			Expect(utils.TranscodeCommand("/tmp/counts", 12345, "LATIN1", "UTF8", "skip")).To(Equal("$GPHOME/bin/gpbackup_helper --transcode-agent --content <SEGID> --from-encoding LATIN1 --to-encoding UTF8 --on-conversion-error skip --count-file /tmp/counts/<SEGID>_12345"))
+		})
+	})
+	Describe("CollectConversionErrorCounts", func() {
+		It("adds up the counts for each table across segments and removes the count directories", func() {
+			countDir := fmt.Sprintf("/tmp/gpbackup_11112233445566_conversion_%d", fpInfo.PID)
+			remoteOutput.Commands = []cluster.ShellCommand{
+				{Host: "localhost", Stdout: fmt.Sprintf("%[1]s/0_100:2\n%[1]s/0_200:1\n", countDir)},
+				{Host: "remotehost1", Stdout: fmt.Sprintf("%s/1_100:3\n", countDir)},
+			}
+			counts := utils.CollectConversionErrorCounts(testCluster, fpInfo)
+
+			Expect(counts).To(Equal(map[uint32]int64{100: 5, 200: 1}))
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0].CommandString).To(ContainSubstring(fmt.Sprintf("rm -rf %s", countDir)))
+		})
+		It("returns no counts when no rows failed conversion", func() {
+			remoteOutput.Commands = []cluster.ShellCommand{{Host: "localhost", Stdout: ""}}
+			Expect(utils.CollectConversionErrorCounts(testCluster, fpInfo)).To(BeEmpty())
+		})
+	})
+	Describe("ByteCountCommand", func() {
+		It("copies data with dd and counts the bytes per segment and table", func() {
+			Expect(utils.ByteCountCommand("/tmp/counts", 12345)).To(Equal("dd bs=1048576 2>/tmp/counts/<SEGID>_12345"))
+		})
+	})
+	Describe("CollectLoadByteCounts", func() {
+		It("adds up the bytes read for each table across segments and removes the count directories", func() {
+			countDir := fmt.Sprintf("/tmp/gpbackup_11112233445566_load_bytes_%d", fpInfo.PID)
+			remoteOutput.Commands = []cluster.ShellCommand{
+				{Host: "localhost", Stdout: fmt.Sprintf("%[1]s/0_100:2048 bytes (2.0 kB, 2.0 KiB) copied, 0.01 s, 205 kB/s\n%[1]s/0_200:512 bytes copied, 0.01 s, 51 kB/s\n", countDir)},
+				{Host: "remotehost1", Stdout: fmt.Sprintf("%s/1_100:1024 bytes transferred in 0.010 secs (102400 bytes/sec)\n", countDir)},
+			}
+			counts := utils.CollectLoadByteCounts(testCluster, fpInfo)
+
+			Expect(counts).To(Equal(map[uint32]int64{100: 3072, 200: 512}))
+			cc := testExecutor.ClusterCommands[0]
+			Expect(cc[0].CommandString).To(ContainSubstring(fmt.Sprintf("grep -H ' bytes ' %[1]s/* 2>/dev/null; rm -rf %[1]s", countDir)))
+		})
 	})
 	Describe("CheckAgentErrorsOnSegments", func() {
 		It("constructs the correct ssh call to check for the existance of an error file on each segment", func() {
@@ -0,0 +1,203 @@
+package utils
+
+/*
+ * This file contains functions for converting table data between database
+ * encodings, for restoring a backup into a database whose encoding the server
+ * cannot convert the backed up data to.
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	CONVERSION_ERROR_FAIL    = "fail"
+	CONVERSION_ERROR_SKIP    = "skip"
+	CONVERSION_ERROR_REPLACE = "replace"
+)
+
+// Keyed by the name the database gives each encoding
+var databaseEncodings = map[string]encoding.Encoding{
+	"UTF8":       unicode.UTF8,
+	"LATIN1":     charmap.ISO8859_1,
+	"LATIN2":     charmap.ISO8859_2,
+	"LATIN3":     charmap.ISO8859_3,
+	"LATIN4":     charmap.ISO8859_4,
+	"LATIN5":     charmap.ISO8859_9,
+	"LATIN6":     charmap.ISO8859_10,
+	"LATIN7":     charmap.ISO8859_13,
+	"LATIN8":     charmap.ISO8859_14,
+	"LATIN9":     charmap.ISO8859_15,
+	"LATIN10":    charmap.ISO8859_16,
+	"ISO_8859_5": charmap.ISO8859_5,
+	"ISO_8859_6": charmap.ISO8859_6,
+	"ISO_8859_7": charmap.ISO8859_7,
+	"ISO_8859_8": charmap.ISO8859_8,
+	"KOI8R":      charmap.KOI8R,
+	"KOI8U":      charmap.KOI8U,
+	"WIN866":     charmap.CodePage866,
+	"WIN874":     charmap.Windows874,
+	"WIN1250":    charmap.Windows1250,
+	"WIN1251":    charmap.Windows1251,
+	"WIN1252":    charmap.Windows1252,
+	"WIN1253":    charmap.Windows1253,
+	"WIN1254":    charmap.Windows1254,
+	"WIN1255":    charmap.Windows1255,
+	"WIN1256":    charmap.Windows1256,
+	"WIN1257":    charmap.Windows1257,
+	"WIN1258":    charmap.Windows1258,
+	"EUC_JP":     japanese.EUCJP,
+	"SJIS":       japanese.ShiftJIS,
+	"EUC_KR":     korean.EUCKR,
+	"UHC":        korean.EUCKR,
+	"GBK":        simplifiedchinese.GBK,
+	"GB18030":    simplifiedchinese.GB18030,
+	"BIG5":       traditionalchinese.Big5,
+}
+
+func IsTranscodableEncoding(name string) bool {
+	_, ok := databaseEncodings[name]
+	return ok
+}
+
+type Transcoder struct {
+	sourceEncoding string
+	targetEncoding string
+	onError        string
+	decoder        *encoding.Decoder
+	encoder        *encoding.Encoder
+	replacer       *encoding.Encoder
+}
+
+func NewTranscoder(sourceEncoding string, targetEncoding string, onError string) (*Transcoder, error) {
+	source, ok := databaseEncodings[sourceEncoding]
+	if !ok {
+		return nil, errors.Errorf("Cannot convert data from unsupported encoding %s", sourceEncoding)
+	}
+	target, ok := databaseEncodings[targetEncoding]
+	if !ok {
+		return nil, errors.Errorf("Cannot convert data to unsupported encoding %s", targetEncoding)
+	}
+	transcoder := &Transcoder{
+		sourceEncoding: sourceEncoding,
+		targetEncoding: targetEncoding,
+		onError:        onError,
+		decoder:        source.NewDecoder(),
+		encoder:        target.NewEncoder(),
+	}
+	switch onError {
+	case CONVERSION_ERROR_REPLACE:
+		transcoder.replacer = encoding.ReplaceUnsupported(target.NewEncoder())
+	case CONVERSION_ERROR_FAIL, CONVERSION_ERROR_SKIP:
+	default:
+		return nil, errors.Errorf("Invalid conversion error behavior %s", onError)
+	}
+	return transcoder, nil
+}
+
+/*
+ * Decoders substitute utf8.RuneError for bytes that are invalid in the source
+ * encoding instead of returning an error, so we check for it ourselves.
+ */
+func (transcoder *Transcoder) decodeRow(row []byte) ([]byte, bool) {
+	decoded, err := transcoder.decoder.Bytes(row)
+	if err != nil {
+		return nil, false
+	}
+	if transcoder.sourceEncoding == "UTF8" {
+		return decoded, utf8.Valid(row)
+	}
+	return decoded, !bytes.ContainsRune(decoded, utf8.RuneError)
+}
+
+/*
+ * Returns the row in the target encoding and whether it converted cleanly.
+ * With CONVERSION_ERROR_REPLACE, a row that does not convert cleanly is
+ * still returned, with each character that could not be converted replaced.
+ */
+func (transcoder *Transcoder) TranscodeRow(row []byte) ([]byte, bool) {
+	decoded, valid := transcoder.decodeRow(row)
+	if decoded == nil {
+		return nil, false
+	}
+	encoded, err := transcoder.encoder.Bytes(decoded)
+	if err == nil && valid {
+		return encoded, true
+	}
+	if transcoder.replacer == nil {
+		return nil, false
+	}
+	encoded, err = transcoder.replacer.Bytes(decoded)
+	if err != nil {
+		return nil, false
+	}
+	return encoded, false
+}
+
+/*
+ * Rows are CSV records, which span several lines when a quoted field contains
+ * a newline.  Every encoding a database can use leaves ASCII bytes as they
+ * are, so quotes and newlines can be found before the row is converted.
+ */
+func readCSVRow(reader *bufio.Reader) ([]byte, error) {
+	var row []byte
+	inQuotes := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		row = append(row, line...)
+		if bytes.Count(line, []byte{'"'})%2 == 1 {
+			inQuotes = !inQuotes
+		}
+		if err != nil || !inQuotes {
+			return row, err
+		}
+	}
+}
+
+/*
+ * Converts CSV data from reader to writer and returns the number of rows that
+ * could not be converted, which were skipped or had characters replaced.
+ * With CONVERSION_ERROR_FAIL, the first such row is returned as an error.
+ */
+func (transcoder *Transcoder) TranscodeCSV(reader io.Reader, writer io.Writer) (int64, error) {
+	bufReader := bufio.NewReader(reader)
+	var numRows int64
+	var failedRows int64
+	for {
+		row, readErr := readCSVRow(bufReader)
+		if readErr != nil && readErr != io.EOF {
+			return failedRows, readErr
+		}
+		if len(row) > 0 {
+			numRows++
+			converted, ok := transcoder.TranscodeRow(row)
+			if !ok {
+				failedRows++
+				if transcoder.onError == CONVERSION_ERROR_FAIL {
+					return failedRows, errors.Errorf("Row %d could not be converted from %s to %s", numRows, transcoder.sourceEncoding, transcoder.targetEncoding)
+				}
+			}
+			if converted != nil {
+				_, err := writer.Write(converted)
+				if err != nil {
+					return failedRows, err
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return failedRows, nil
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package utils_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/encoding tests", func() {
+	Describe("IsTranscodableEncoding", func() {
+		It("recognizes database encoding names", func() {
+			Expect(utils.IsTranscodableEncoding("LATIN1")).To(BeTrue())
+			Expect(utils.IsTranscodableEncoding("SQL_ASCII")).To(BeFalse())
+		})
+	})
+	Describe("NewTranscoder", func() {
+		It("returns an error for an unsupported encoding", func() {
+			_, err := utils.NewTranscoder("SQL_ASCII", "UTF8", utils.CONVERSION_ERROR_FAIL)
+			Expect(err).To(MatchError("Cannot convert data from unsupported encoding SQL_ASCII"))
+		})
+		It("returns an error for an invalid conversion error behavior", func() {
+			_, err := utils.NewTranscoder("LATIN1", "UTF8", "ignore")
+			Expect(err).To(MatchError("Invalid conversion error behavior ignore"))
+		})
+	})
+	Describe("TranscodeCSV", func() {
+		transcode := func(source string, target string, onError string, input string) (string, int64, error) {
+			transcoder, err := utils.NewTranscoder(source, target, onError)
+			Expect(err).ToNot(HaveOccurred())
+			output := &bytes.Buffer{}
+			failedRows, err := transcoder.TranscodeCSV(strings.NewReader(input), output)
+			return output.String(), failedRows, err
+		}
+		It("converts high-bit characters from LATIN1 to UTF8", func() {
+			output, failedRows, err := transcode("LATIN1", "UTF8", utils.CONVERSION_ERROR_FAIL, "1,caf\xe9\n2,na\xefve\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(failedRows).To(Equal(int64(0)))
+			Expect(output).To(Equal("1,café\n2,naïve\n"))
+		})
+		It("converts a last row without a trailing newline", func() {
+			output, _, err := transcode("LATIN1", "UTF8", utils.CONVERSION_ERROR_FAIL, "1,caf\xe9")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(Equal("1,café"))
+		})
+		It("fails on the first row that cannot be converted", func() {
+			_, failedRows, err := transcode("UTF8", "LATIN1", utils.CONVERSION_ERROR_FAIL, "1,café\n2,日本\n")
+			Expect(err).To(MatchError("Row 2 could not be converted from UTF8 to LATIN1"))
+			Expect(failedRows).To(Equal(int64(1)))
+		})
+		It("skips rows that cannot be converted", func() {
+			output, failedRows, err := transcode("UTF8", "LATIN1", utils.CONVERSION_ERROR_SKIP, "1,café\n2,日本\n3,abc\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(failedRows).To(Equal(int64(1)))
+			Expect(output).To(Equal("1,caf\xe9\n3,abc\n"))
+		})
+		It("replaces characters that cannot be converted", func() {
+			output, failedRows, err := transcode("UTF8", "LATIN1", utils.CONVERSION_ERROR_REPLACE, "1,a日b\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(failedRows).To(Equal(int64(1)))
+			Expect(output).To(Equal("1,a\x1ab\n"))
+		})
+		It("counts bytes that are invalid in the source encoding as failures", func() {
+			output, failedRows, err := transcode("UTF8", "LATIN1", utils.CONVERSION_ERROR_SKIP, "1,\xff\n2,ok\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(failedRows).To(Equal(int64(1)))
+			Expect(output).To(Equal("2,ok\n"))
+		})
+		It("treats a quoted field containing a newline as part of one row", func() {
+			output, failedRows, err := transcode("UTF8", "LATIN1", utils.CONVERSION_ERROR_SKIP, "1,\"line one\nline 日\"\n2,\"x\"\"y\"\n")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(failedRows).To(Equal(int64(1)))
+			Expect(output).To(Equal("2,\"x\"\"y\"\n"))
+		})
+	})
+})
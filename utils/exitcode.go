@@ -0,0 +1,144 @@
+package utils
+
+/*
+ * This file contains the exit codes of gpbackup and gprestore, and the
+ * classes of error they are derived from, so that automation can tell why
+ * an operation failed without reading its log.
+ *
+ *   0  success
+ *   1  usage: invalid flags or flag values, or a backup that cannot be used
+ *   2  connection: the database or a segment host could not be reached
+ *   3  metadata: a catalog query or metadata statement failed
+ *   4  data: table data could not be backed up or restored
+ *   5  partial success: the operation finished, but logged errors
+ *   6  terminated: the operation was canceled by a signal
+ */
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/pkg/errors"
+)
+
+type ErrorClass string
+
+const (
+	ERROR_CLASS_NONE       ErrorClass = ""
+	ERROR_CLASS_USAGE      ErrorClass = "usage"
+	ERROR_CLASS_CONNECTION ErrorClass = "connection"
+	ERROR_CLASS_METADATA   ErrorClass = "metadata"
+	ERROR_CLASS_DATA       ErrorClass = "data"
+	ERROR_CLASS_PARTIAL    ErrorClass = "partial"
+	ERROR_CLASS_TERMINATED ErrorClass = "terminated"
+)
+
+const (
+	EXIT_SUCCESS    = 0
+	EXIT_USAGE      = 1
+	EXIT_CONNECTION = 2
+	EXIT_METADATA   = 3
+	EXIT_DATA       = 4
+	EXIT_PARTIAL    = 5
+	EXIT_TERMINATED = 6
+)
+
+func (class ErrorClass) ExitCode() int {
+	switch class {
+	case ERROR_CLASS_NONE:
+		return EXIT_SUCCESS
+	case ERROR_CLASS_USAGE:
+		return EXIT_USAGE
+	case ERROR_CLASS_CONNECTION:
+		return EXIT_CONNECTION
+	case ERROR_CLASS_METADATA:
+		return EXIT_METADATA
+	case ERROR_CLASS_DATA:
+		return EXIT_DATA
+	case ERROR_CLASS_PARTIAL:
+		return EXIT_PARTIAL
+	case ERROR_CLASS_TERMINATED:
+		return EXIT_TERMINATED
+	}
+	return EXIT_METADATA
+}
+
+/*
+ * A ClassifiedError gives an error a class of its own, which is kept however
+ * the error is wrapped afterward.
+ */
+type ClassifiedError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func NewClassifiedError(class ErrorClass, err error) error {
+	return ClassifiedError{Class: class, Err: err}
+}
+
+func (err ClassifiedError) Error() string {
+	return err.Err.Error()
+}
+
+func (err ClassifiedError) Cause() error {
+	return err.Err
+}
+
+func (err ClassifiedError) Unwrap() error {
+	return err.Err
+}
+
+func ErrorClassOf(err error, defaultClass ErrorClass) ErrorClass {
+	var classified ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+	return defaultClass
+}
+
+var (
+	// The class given to fatal errors that do not have one of their own
+	phaseErrorClass = ERROR_CLASS_USAGE
+	fatalErrorClass = ERROR_CLASS_NONE
+)
+
+/*
+ * Each utility sets the error phase as it moves between validating its flags,
+ * connecting to the cluster, and backing up or restoring metadata and data.
+ */
+func SetErrorPhase(class ErrorClass) {
+	phaseErrorClass = class
+}
+
+func ResetErrorClass() {
+	phaseErrorClass = ERROR_CLASS_USAGE
+	fatalErrorClass = ERROR_CLASS_NONE
+}
+
+/*
+ * gplog.Fatal panics with the message of its error rather than the error
+ * itself, so the class of a fatal error is recorded before it is logged.
+ */
+func FatalWithClass(class ErrorClass, err error, output ...string) {
+	if err == nil {
+		return
+	}
+	fatalErrorClass = class
+	gplog.FatalOnError(NewClassifiedError(class, err), output...)
+}
+
+/*
+ * An operation that did not fail has the partial class if it logged any
+ * errors, as gplog sets its error code to 1 when it logs one.
+ */
+func ErrorClassForOutcome(failed bool, terminated bool) ErrorClass {
+	if terminated {
+		return ERROR_CLASS_TERMINATED
+	} else if failed {
+		if fatalErrorClass != ERROR_CLASS_NONE {
+			return fatalErrorClass
+		}
+		return phaseErrorClass
+	} else if gplog.GetErrorCode() != 0 {
+		return ERROR_CLASS_PARTIAL
+	}
+	return ERROR_CLASS_NONE
+}
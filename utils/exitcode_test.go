@@ -0,0 +1,66 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+	"github.com/greenplum-db/gp-common-go-libs/testhelper"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/exitcode tests", func() {
+	AfterEach(func() {
+		utils.ResetErrorClass()
+		gplog.SetErrorCode(0)
+	})
+	Describe("ErrorClassOf", func() {
+		It("returns the class of a classified error however it is wrapped", func() {
+			err := utils.NewClassifiedError(utils.ERROR_CLASS_CONNECTION, errors.New("could not connect to server"))
+			wrapped := errors.Wrap(errors.Wrap(err, "Unable to create backup directories"), "Setup failed")
+			Expect(utils.ErrorClassOf(wrapped, utils.ERROR_CLASS_USAGE)).To(Equal(utils.ERROR_CLASS_CONNECTION))
+			Expect(errors.Cause(wrapped).Error()).To(Equal("could not connect to server"))
+		})
+		It("returns the default class for an error without one", func() {
+			Expect(utils.ErrorClassOf(errors.New("relation does not exist"), utils.ERROR_CLASS_METADATA)).To(Equal(utils.ERROR_CLASS_METADATA))
+		})
+	})
+	Describe("ErrorClassForOutcome", func() {
+		It("returns the class of a fatal error given one", func() {
+			utils.SetErrorPhase(utils.ERROR_CLASS_DATA)
+			func() {
+				defer testhelper.ShouldPanicWithMessage("Unable to read plugin config")
+				utils.FatalWithClass(utils.ERROR_CLASS_USAGE, errors.New("Unable to read plugin config"))
+			}()
+			Expect(utils.ErrorClassForOutcome(true, false)).To(Equal(utils.ERROR_CLASS_USAGE))
+			Expect(utils.ErrorClassForOutcome(true, false).ExitCode()).To(Equal(utils.EXIT_USAGE))
+		})
+		It("returns the class of the phase for a fatal error without one", func() {
+			utils.SetErrorPhase(utils.ERROR_CLASS_METADATA)
+			func() {
+				defer testhelper.ShouldPanicWithMessage("relation does not exist")
+				gplog.Fatal(errors.New("relation does not exist"), "")
+			}()
+			Expect(utils.ErrorClassForOutcome(true, false).ExitCode()).To(Equal(utils.EXIT_METADATA))
+		})
+		It("returns the usage class for a failure before any phase is set", func() {
+			Expect(utils.ErrorClassForOutcome(true, false).ExitCode()).To(Equal(utils.EXIT_USAGE))
+		})
+		It("does not classify an error with a nil error", func() {
+			utils.FatalWithClass(utils.ERROR_CLASS_CONNECTION, nil)
+			Expect(utils.ErrorClassForOutcome(false, false)).To(Equal(utils.ERROR_CLASS_NONE))
+		})
+		It("returns the partial class if errors were logged", func() {
+			gplog.Error("Error encountered when executing statement")
+			Expect(utils.ErrorClassForOutcome(false, false).ExitCode()).To(Equal(utils.EXIT_PARTIAL))
+		})
+		It("returns the terminated class if the operation was terminated", func() {
+			utils.SetErrorPhase(utils.ERROR_CLASS_DATA)
+			Expect(utils.ErrorClassForOutcome(true, true).ExitCode()).To(Equal(utils.EXIT_TERMINATED))
+		})
+		It("returns the success exit code if nothing failed", func() {
+			Expect(utils.ErrorClassForOutcome(false, false).ExitCode()).To(Equal(utils.EXIT_SUCCESS))
+		})
+	})
+})
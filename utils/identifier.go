@@ -0,0 +1,107 @@
+package utils
+
+/*
+ * This file contains functions for quoting identifiers given by the user,
+ * such as --redirect-schema, before they are used in generated statements.
+ * Identifiers read from the catalog are quoted by quote_ident in the query
+ * that reads them, and identifiers quoted here are quoted the same way, so
+ * that the two can be compared.
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ * The keywords that quote_ident quotes, which are every keyword other than
+ * the unreserved ones, across the Postgres versions that GPDB is based on
+ * and the keywords GPDB adds.  A keyword that one version does not reserve
+ * is still quoted, which is harmless.
+ */
+var quotedKeywords = map[string]bool{
+	// Reserved keywords
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true, "array": true, "as": true, "asc": true,
+	"asymmetric": true, "both": true, "case": true, "cast": true, "check": true, "collate": true, "column": true,
+	"constraint": true, "create": true, "current_catalog": true, "current_date": true, "current_role": true,
+	"current_time": true, "current_timestamp": true, "current_user": true, "default": true, "deferrable": true,
+	"desc": true, "distinct": true, "do": true, "else": true, "end": true, "except": true, "false": true,
+	"fetch": true, "for": true, "foreign": true, "from": true, "grant": true, "group": true, "having": true,
+	"in": true, "initially": true, "intersect": true, "into": true, "lateral": true, "leading": true,
+	"limit": true, "localtime": true, "localtimestamp": true, "not": true, "null": true, "offset": true,
+	"on": true, "only": true, "or": true, "order": true, "placing": true, "primary": true, "references": true,
+	"returning": true, "select": true, "session_user": true, "some": true, "symmetric": true, "table": true,
+	"then": true, "to": true, "trailing": true, "true": true, "union": true, "unique": true, "user": true,
+	"using": true, "variadic": true, "when": true, "where": true, "window": true, "with": true,
+	// Keywords that may be function or type names
+	"authorization": true, "binary": true, "collation": true, "concurrently": true, "cross": true,
+	"current_schema": true, "freeze": true, "full": true, "ilike": true, "inner": true, "is": true,
+	"isnull": true, "join": true, "left": true, "like": true, "natural": true, "notnull": true, "outer": true,
+	"over": true, "overlaps": true, "right": true, "similar": true, "tablesample": true, "verbose": true,
+	// Keywords that may be column names
+	"between": true, "bigint": true, "bit": true, "boolean": true, "char": true, "character": true,
+	"coalesce": true, "dec": true, "decimal": true, "exists": true, "extract": true, "float": true,
+	"greatest": true, "grouping": true, "inout": true, "int": true, "integer": true, "interval": true,
+	"least": true, "national": true, "nchar": true, "none": true, "nullif": true, "numeric": true, "out": true,
+	"overlay": true, "position": true, "precision": true, "real": true, "row": true, "setof": true,
+	"smallint": true, "substring": true, "time": true, "timestamp": true, "treat": true, "trim": true,
+	"values": true, "varchar": true, "xmlattributes": true, "xmlconcat": true, "xmlelement": true,
+	"xmlexists": true, "xmlforest": true, "xmlnamespaces": true, "xmlparse": true, "xmlpi": true,
+	"xmlroot": true, "xmlserialize": true, "xmltable": true,
+	// Keywords added by GPDB
+	"decode": true, "distributed": true, "exclude": true, "following": true, "log": true, "median": true,
+	"partition": true, "percentile_cont": true, "percentile_disc": true, "preceding": true, "scatter": true,
+	"unbounded": true,
+}
+
+/*
+ * Quotes an identifier the way quote_ident does: an identifier is left as it
+ * is only if it is lowercase, starts with a letter or underscore, contains
+ * only letters, digits, and underscores, and is not a keyword.
+ */
+func QuoteIdentifier(ident string) string {
+	safe := len(ident) > 0 && ((ident[0] >= 'a' && ident[0] <= 'z') || ident[0] == '_')
+	for i := 0; safe && i < len(ident); i++ {
+		char := ident[i]
+		safe = (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '_'
+	}
+	if safe && !quotedKeywords[ident] {
+		return ident
+	}
+	return fmt.Sprintf(`"%s"`, strings.Replace(ident, `"`, `""`, -1))
+}
+
+/*
+ * Splits an entry of a mapping flag, such as --role-map, given as name:value,
+ * into the unquoted name and the value.  The name is matched exactly as it is
+ * given, including its case.  It may also be given in double quotes, as in
+ * SQL, with any double quote inside it doubled, so that it may contain a
+ * colon.
+ */
+func SplitMapEntry(entry string) (string, string, bool) {
+	if !strings.HasPrefix(entry, `"`) {
+		fields := strings.SplitN(entry, ":", 2)
+		if len(fields) != 2 {
+			return "", "", false
+		}
+		return fields[0], fields[1], true
+	}
+	for i := 1; i < len(entry); i++ {
+		if entry[i] != '"' {
+			continue
+		}
+		if i+1 < len(entry) && entry[i+1] == '"' {
+			i++
+			continue
+		}
+		if i+1 < len(entry) && entry[i+1] == ':' {
+			return UnquoteIdent(entry[:i+1]), entry[i+2:], true
+		}
+		return "", "", false
+	}
+	return "", "", false
+}
+
+func MakeQuotedFQN(schema string, object string) string {
+	return MakeFQN(QuoteIdentifier(schema), QuoteIdentifier(object))
+}
@@ -0,0 +1,85 @@
+package utils_test
+
+import (
+	"math/rand"
+	"regexp"
+
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/identifier tests", func() {
+	Describe("QuoteIdentifier", func() {
+		DescribeTable("quotes identifiers the way quote_ident does",
+			func(ident string, expected string) {
+				Expect(utils.QuoteIdentifier(ident)).To(Equal(expected))
+			},
+			Entry("a lowercase identifier", "foo_bar1", "foo_bar1"),
+			Entry("an identifier starting with an underscore", "_foo", "_foo"),
+			Entry("an unreserved keyword", "action", "action"),
+			Entry("a reserved keyword", "select", `"select"`),
+			Entry("a keyword that may be a column name", "integer", `"integer"`),
+			Entry("a keyword that may be a function name", "left", `"left"`),
+			Entry("a keyword added by GPDB", "distributed", `"distributed"`),
+			Entry("a mixed-case identifier", "Order", `"Order"`),
+			Entry("a mixed-case keyword", "Select", `"Select"`),
+			Entry("an identifier starting with a digit", "1foo", `"1foo"`),
+			Entry("an identifier with a dollar sign", "foo$", `"foo$"`),
+			Entry("an identifier with a space", "foo bar", `"foo bar"`),
+			Entry("an identifier with a dot", "foo.bar", `"foo.bar"`),
+			Entry("an identifier with double quotes", `foo"bar"`, `"foo""bar"""`),
+			Entry("an identifier with a single quote", "foo'bar", `"foo'bar"`),
+			Entry("an identifier with a semicolon", "foo; DROP TABLE bar", `"foo; DROP TABLE bar"`),
+			Entry("a non-ASCII identifier", "tablé", `"tablé"`),
+			Entry("an empty identifier", "", `""`),
+		)
+		It("quotes hostile identifiers so that they can be unquoted", func() {
+			alphabet := []rune(`aZ_0 ."';$\é-`)
+			unquoted := regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+			quoted := regexp.MustCompile(`^"([^"]|"")*"$`)
+			random := rand.New(rand.NewSource(0))
+			for i := 0; i < 1000; i++ {
+				ident := make([]rune, random.Intn(8))
+				for j := range ident {
+					ident[j] = alphabet[random.Intn(len(alphabet))]
+				}
+				result := utils.QuoteIdentifier(string(ident))
+				Expect(unquoted.MatchString(result) || quoted.MatchString(result)).To(BeTrue(), "identifier %s quoted as %s", string(ident), result)
+				Expect(utils.UnquoteIdent(result)).To(Equal(string(ident)))
+			}
+		})
+	})
+	Describe("SplitMapEntry", func() {
+		DescribeTable("splits a name:value entry into the unquoted name and the value",
+			func(entry string, name string, value string) {
+				splitName, splitValue, ok := utils.SplitMapEntry(entry)
+				Expect(ok).To(BeTrue())
+				Expect(splitName).To(Equal(name))
+				Expect(splitValue).To(Equal(value))
+			},
+			Entry("an unquoted name", "analyst:reader", "analyst", "reader"),
+			Entry("a mixed-case name", "Analyst:analyst", "Analyst", "analyst"),
+			Entry("a quoted mixed-case name", `"Analyst":analyst`, "Analyst", "analyst"),
+			Entry("a quoted name containing a colon", `"Sales:West":/data/west`, "Sales:West", "/data/west"),
+			Entry("a quoted name containing a double quote", `"the ""best"" role":reader`, `the "best" role`, "reader"),
+			Entry("a value containing a colon", "fast_disk:/data/a:b", "fast_disk", "/data/a:b"),
+		)
+		DescribeTable("rejects an entry without a name and a value",
+			func(entry string) {
+				_, _, ok := utils.SplitMapEntry(entry)
+				Expect(ok).To(BeFalse())
+			},
+			Entry("an entry without a colon", "analyst"),
+			Entry("an unterminated quoted name", `"Analyst:analyst`),
+			Entry("a quoted name not followed by a colon", `"Analyst"x:analyst`),
+		)
+	})
+	Describe("MakeQuotedFQN", func() {
+		It("quotes the schema and the object", func() {
+			Expect(utils.MakeQuotedFQN("Select", "order")).To(Equal(`"Select"."order"`))
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package utils
+
+/*
+ * This file contains functions for reporting and limiting the memory used by
+ * gpbackup, which on catalogs with millions of objects is dominated by the
+ * metadata held between querying the catalog and writing the metadata file.
+ */
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/greenplum-db/gp-common-go-libs/gplog"
+)
+
+// The garbage collection target used in low-memory mode, in place of Go's default of 100
+const LowMemoryGCPercent = 25
+
+var lowMemoryMode = false
+
+/*
+ * In low-memory mode the heap is collected once it grows by a quarter rather
+ * than once it doubles, and memory is returned to the operating system at the
+ * end of each stage, so that a stage does not inherit the peak of the last.
+ */
+func SetLowMemoryMode(enabled bool) {
+	lowMemoryMode = enabled
+	if enabled {
+		debug.SetGCPercent(LowMemoryGCPercent)
+	}
+}
+
+func IsLowMemoryMode() bool {
+	return lowMemoryMode
+}
+
+/*
+ * Marks the end of a stage that gathered and printed metadata, logging the
+ * memory in use at that point.
+ */
+func EndMemoryStage(stage string) {
+	if lowMemoryMode {
+		debug.FreeOSMemory()
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	gplog.Verbose("Memory after %s: %d MB heap in use, %d MB held from the system",
+		stage, stats.HeapInuse>>20, (stats.Sys-stats.HeapReleased)>>20)
+}
@@ -22,10 +22,18 @@ import (
 const RequiredPluginVersion = "0.3.0"
 const SecretKeyFile = ".encrypt"
 
+/*
+ * A plugin config may have a data section, itself a plugin config, giving a
+ * separate destination or a separate plugin for the segment data files and
+ * segment TOC files.  The metadata files go to the destination given by the
+ * rest of the config.  The data section is written to its own config file on
+ * every host, so that each plugin sees only its own options.
+ */
 type PluginConfig struct {
 	ExecutablePath      string            `yaml:"executablepath"`
 	ConfigPath          string            `yaml:"-"`
 	Options             map[string]string `yaml:"options"`
+	DataPlugin          *PluginConfig     `yaml:"data,omitempty"`
 	backupPluginVersion string            `yaml:"-"`
 }
 
@@ -59,11 +67,54 @@ func ReadPluginConfig(configFile string) (*PluginConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	if data := config.DataPlugin; data != nil {
+		if data.DataPlugin != nil {
+			return nil, errors.New("data section of config file cannot have a data section of its own")
+		}
+		if data.ExecutablePath == "" {
+			data.ExecutablePath = config.ExecutablePath
+		}
+		if data.Options == nil {
+			data.Options = make(map[string]string)
+		}
+		data.ExecutablePath = os.ExpandEnv(data.ExecutablePath)
+		err = ValidateFullPath(data.ExecutablePath)
+		if err != nil {
+			return nil, err
+		}
+	}
 	configFilename := path.Base(configFile)
-	config.ConfigPath = path.Join("/tmp", configFilename)
+	config.SetConfigPath(path.Join("/tmp", configFilename))
 	return config, nil
 }
 
+/*
+ * Sets the path of the config file on each host, and that of the config file
+ * for the data section beside it.
+ */
+func (plugin *PluginConfig) SetConfigPath(configPath string) {
+	plugin.ConfigPath = configPath
+	if plugin.DataPlugin != nil {
+		plugin.DataPlugin.ConfigPath = configPath + "_data"
+	}
+}
+
+// Returns the plugin to which segment data files and segment TOC files are sent
+func (plugin *PluginConfig) ForData() *PluginConfig {
+	if plugin.DataPlugin != nil {
+		return plugin.DataPlugin
+	}
+	return plugin
+}
+
+// Returns the plugin for metadata files followed by a separate plugin for data files, if any
+func (plugin *PluginConfig) AllPlugins() []*PluginConfig {
+	if plugin.DataPlugin != nil {
+		return []*PluginConfig{plugin, plugin.DataPlugin}
+	}
+	return []*PluginConfig{plugin}
+}
+
 func (plugin *PluginConfig) BackupFile(filenamePath string) error {
 	command := fmt.Sprintf("%s backup_file %s %s", plugin.ExecutablePath, plugin.ConfigPath, filenamePath)
 	gplog.Debug("%s", command)
@@ -75,6 +126,16 @@ func (plugin *PluginConfig) BackupFile(filenamePath string) error {
 	return err
 }
 
+func (plugin *PluginConfig) DeleteBackup(timestamp string) error {
+	command := fmt.Sprintf("%s delete_backup %s %s", plugin.ExecutablePath, plugin.ConfigPath, timestamp)
+	gplog.Debug("%s", command)
+	output, err := exec.Command("bash", "-c", command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ERROR: Plugin failed to delete backup %s. %s", timestamp, string(output))
+	}
+	return nil
+}
+
 func (plugin *PluginConfig) MustBackupFile(filenamePath string) {
 	err := plugin.BackupFile(filenamePath)
 	gplog.FatalOnError(err)
@@ -369,7 +430,9 @@ func (plugin *PluginConfig) createHostPluginConfig(contentIDForSegmentOnHost int
 		}
 		plugin.Options[pluginName] = secret
 	}
-	out, err := yaml.Marshal(plugin)
+	hostConfig := *plugin
+	hostConfig.DataPlugin = nil
+	out, err := yaml.Marshal(&hostConfig)
 	gplog.FatalOnError(err)
 	bytes, err := file.Write(out)
 	gplog.FatalOnError(err)
@@ -146,6 +146,26 @@ options:
 			Expect(contents).To(ContainSubstring("\n  pgport: \"102\""))
 			Expect(contents).To(ContainSubstring("\n  backup_plugin_version: my.test.version"))
 		})
+		It("does not write the data section into the config of the plugin for metadata", func() {
+			subject.DataPlugin = &utils.PluginConfig{ExecutablePath: "/a/b/myDataPlugin", Options: map[string]string{"folder": "data"}}
+			subject.SetConfigPath("/tmp/my_plugin_config.yaml")
+			subject.CopyPluginConfigToAllHosts(testCluster)
+
+			cc := executor.ClusterCommands[0]
+			rgx := regexp.MustCompile(`scp (.*-1) master:\/tmp\/my_plugin_config\.yaml; rm .*-1`)
+			masterConfigPath := rgx.FindStringSubmatch(cc[0].CommandString)[1]
+			contents := strings.Join(iohelper.MustReadLinesFromFile(masterConfigPath), "\n")
+			Expect(contents).ToNot(ContainSubstring("data"))
+
+			subject.DataPlugin.CopyPluginConfigToAllHosts(testCluster)
+
+			cc = executor.ClusterCommands[1]
+			rgx = regexp.MustCompile(`scp (.*-1) master:\/tmp\/my_plugin_config\.yaml_data; rm .*-1`)
+			masterConfigPath = rgx.FindStringSubmatch(cc[0].CommandString)[1]
+			contents = strings.Join(iohelper.MustReadLinesFromFile(masterConfigPath), "\n")
+			Expect(contents).To(ContainSubstring("executablepath: /a/b/myDataPlugin"))
+			Expect(contents).To(ContainSubstring("\n  folder: data"))
+		})
 		When("copying for a plugin with encryption", func() {
 			It("copies the encryption key", func() {
 				executor.LocalOutput = "gpbackup_fake_plugin version 1.0.1+dev.28.g00c877e"
@@ -404,5 +424,65 @@ hostname: "myhostname"`), nil
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("plugin config file is formatted incorrectly"))
 		})
+		It("reads a data section with a plugin of its own", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte(`executablepath: "/usr/local/gpdb/bin/gpbackup_s3_plugin"
+options:
+  folder: "metadata"
+data:
+  executablepath: "/usr/local/gpdb/bin/gpbackup_ddboost_plugin"
+  options:
+    hostname: "myhostname"`), nil
+			}
+
+			config, err := utils.ReadPluginConfig("/home/gpadmin/myconfigpath")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.ConfigPath).To(Equal("/tmp/myconfigpath"))
+			Expect(config.Options).To(Equal(map[string]string{"folder": "metadata"}))
+			Expect(config.ForData().ExecutablePath).To(Equal("/usr/local/gpdb/bin/gpbackup_ddboost_plugin"))
+			Expect(config.ForData().ConfigPath).To(Equal("/tmp/myconfigpath_data"))
+			Expect(config.ForData().Options).To(Equal(map[string]string{"hostname": "myhostname"}))
+			Expect(config.AllPlugins()).To(Equal([]*utils.PluginConfig{config, config.DataPlugin}))
+
+			config.SetConfigPath("/tmp/20170101010101_myconfigpath")
+			Expect(config.ForData().ConfigPath).To(Equal("/tmp/20170101010101_myconfigpath_data"))
+		})
+		It("uses the plugin of the config for a data section without one", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte(`executablepath: "/usr/local/gpdb/bin/gpbackup_s3_plugin"
+options:
+  folder: "metadata"
+data:
+  options:
+    folder: "data"`), nil
+			}
+
+			config, err := utils.ReadPluginConfig("myconfigpath")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.ForData().ExecutablePath).To(Equal("/usr/local/gpdb/bin/gpbackup_s3_plugin"))
+			Expect(config.ForData().Options).To(Equal(map[string]string{"folder": "data"}))
+		})
+		It("sends data files to the plugin of the config without a data section", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte(`executablepath: "/usr/local/gpdb/bin/gpbackup_s3_plugin"`), nil
+			}
+
+			config, err := utils.ReadPluginConfig("myconfigpath")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.ForData()).To(BeIdenticalTo(config))
+			Expect(config.AllPlugins()).To(Equal([]*utils.PluginConfig{config}))
+		})
+		It("returns an error if the data section has a data section", func() {
+			operating.System.ReadFile = func(string) ([]byte, error) {
+				return []byte(`executablepath: "/usr/local/gpdb/bin/gpbackup_s3_plugin"
+data:
+  data:
+    executablepath: "/usr/local/gpdb/bin/gpbackup_s3_plugin"`), nil
+			}
+
+			_, err := utils.ReadPluginConfig("myconfigpath")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("data section of config file cannot have a data section of its own"))
+		})
 	})
 })
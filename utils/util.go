@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -109,6 +111,16 @@ func ValidateFullPath(path string) error {
 	return nil
 }
 
+func ValidateDirectoryMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	if value, err := strconv.ParseUint(mode, 8, 32); err != nil || value > 0777 {
+		return errors.Errorf("%s is not a valid directory mode.  The mode must be an octal number between 0000 and 0777.", mode)
+	}
+	return nil
+}
+
 func ValidateCompressionLevel(compressionLevel int) error {
 	if compressionLevel < 1 || compressionLevel > 9 {
 		return errors.Errorf("Compression level must be between 1 and 9")
@@ -116,6 +128,71 @@ func ValidateCompressionLevel(compressionLevel int) error {
 	return nil
 }
 
+func ValidateKeepaliveSettings(idle int, interval int, count int) error {
+	if idle < 0 || interval < 0 || count < 0 {
+		return errors.Errorf("Keepalive settings must be greater than or equal to 0")
+	}
+	return nil
+}
+
+/*
+ * The defaults of 300 seconds idle, 30 seconds between probes, and 3 probes
+ * are well under the idle timeout of most firewalls, so connections that sit
+ * quietly during long metadata or data phases are not dropped.  A value of 0
+ * leaves the corresponding setting at the system default.
+ *
+ * Backup connections hold a transaction open for the whole run, so on GPDB 7+
+ * we also disable idle_in_transaction_session_timeout for the session.
+ */
+func GetKeepaliveQuery(connectionPool *dbconn.DBConn, idle int, interval int, count int) string {
+	query := fmt.Sprintf(`SET tcp_keepalives_idle = %d;
+SET tcp_keepalives_interval = %d;
+SET tcp_keepalives_count = %d;
+`, idle, interval, count)
+	if connectionPool.Version.AtLeast("7") {
+		query += "SET idle_in_transaction_session_timeout = 0;\n"
+	}
+	return query
+}
+
+/*
+ * The settings that change how values are written out and read back in, which
+ * are recorded from the backup sessions so that gprestore can give its own
+ * sessions the same ones.  A setting is only added here once it is known to
+ * be safe to SET on every connection of a restore.
+ */
+var RestoreSessionGUCs = []string{"DateStyle", "IntervalStyle", "bytea_output", "standard_conforming_strings"}
+
+/*
+ * Returns the session value of each of RestoreSessionGUCs, leaving out those
+ * that the server version does not have.
+ */
+func GetRestoreSessionGUCs(connectionPool *dbconn.DBConn) map[string]string {
+	results := make([]struct {
+		Name    string
+		Setting string
+	}, 0)
+	query := fmt.Sprintf("SELECT name, setting FROM pg_settings WHERE name IN (%s)", SliceToQuotedString(RestoreSessionGUCs))
+	err := connectionPool.Select(&results, query)
+	gplog.FatalOnError(err)
+	gucs := make(map[string]string, len(results))
+	for _, result := range results {
+		gucs[result.Name] = result.Setting
+	}
+	return gucs
+}
+
+// Returns SET statements for the given settings, in the order of RestoreSessionGUCs
+func GetRestoreSessionGUCsQuery(gucs map[string]string) string {
+	query := ""
+	for _, name := range RestoreSessionGUCs {
+		if setting, ok := gucs[name]; ok {
+			query += fmt.Sprintf("SET %s TO '%s';\n", name, EscapeSingleQuotes(setting))
+		}
+	}
+	return query
+}
+
 func InitializeSignalHandler(cleanupFunc func(bool), procDesc string, termFlag *bool) {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
@@ -125,7 +202,7 @@ func InitializeSignalHandler(cleanupFunc func(bool), procDesc string, termFlag *
 			gplog.Warn("Received a termination signal, aborting %s", procDesc)
 			*termFlag = true
 			cleanupFunc(true)
-			os.Exit(2)
+			os.Exit(EXIT_TERMINATED)
 		}
 	}()
 }
@@ -191,10 +268,6 @@ func UnquoteIdent(ident string) string {
 	return ident
 }
 
-func QuoteIdent(connectionPool *dbconn.DBConn, ident string) string {
-	return dbconn.MustSelectString(connectionPool, fmt.Sprintf(`SELECT quote_ident('%s')`, EscapeSingleQuotes(ident)))
-}
-
 func SliceToQuotedString(slice []string) string {
 	quotedStrings := make([]string, len(slice))
 	for i, str := range slice {
@@ -206,3 +279,38 @@ func SliceToQuotedString(slice []string) string {
 func EscapeSingleQuotes(str string) string {
 	return strings.Replace(str, "'", "''", -1)
 }
+
+/*
+ * A TOC or config file written by a newer gpbackup may contain fields that an
+ * older gprestore does not know about.  Those are ignored when the file is
+ * unmarshaled, so a writer that adds a section readers cannot safely ignore
+ * lists it as required, and readers use this to find the required sections
+ * that structPtr has no field for.
+ */
+func UnknownYAMLKeys(structPtr interface{}, keys []string) []string {
+	structType := reflect.TypeOf(structPtr).Elem()
+	knownKeys := make(map[string]bool, structType.NumField())
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		knownKeys[key] = true
+	}
+	unknownKeys := make([]string, 0)
+	for _, key := range keys {
+		if !knownKeys[key] {
+			unknownKeys = append(unknownKeys, key)
+		}
+	}
+	return unknownKeys
+}
+
+func NewFormatVersionError(backupVersion string, fileType string, formatVersion int, supportedFormatVersion int) error {
+	return errors.Errorf("backup was created by gpbackup %s using %s format %d; this gprestore supports up to format %d",
+		backupVersion, fileType, formatVersion, supportedFormatVersion)
+}
@@ -1,6 +1,9 @@
 package utils_test
 
 import (
+	"regexp"
+
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/greenplum-db/gp-common-go-libs/testhelper"
 	"github.com/greenplum-db/gpbackup/utils"
 
@@ -120,6 +123,73 @@ var _ = Describe("utils/util tests", func() {
 			Expect(err).To(MatchError("Compression level must be between 1 and 9"))
 		})
 	})
+	Describe("ValidateDirectoryMode", func() {
+		It("accepts an empty mode or an octal mode", func() {
+			Expect(utils.ValidateDirectoryMode("")).To(Succeed())
+			Expect(utils.ValidateDirectoryMode("0750")).To(Succeed())
+			Expect(utils.ValidateDirectoryMode("700")).To(Succeed())
+		})
+		It("returns an error for a mode that is not octal or is out of range", func() {
+			Expect(utils.ValidateDirectoryMode("0790")).To(MatchError("0790 is not a valid directory mode.  The mode must be an octal number between 0000 and 0777."))
+			Expect(utils.ValidateDirectoryMode("1777")).To(MatchError("1777 is not a valid directory mode.  The mode must be an octal number between 0000 and 0777."))
+		})
+	})
+	Describe("ValidateKeepaliveSettings", func() {
+		It("validates non-negative keepalive settings", func() {
+			err := utils.ValidateKeepaliveSettings(300, 30, 0)
+			Expect(err).To(Not(HaveOccurred()))
+		})
+		It("returns an error if any keepalive setting is negative", func() {
+			err := utils.ValidateKeepaliveSettings(300, -1, 3)
+			Expect(err).To(MatchError("Keepalive settings must be greater than or equal to 0"))
+		})
+	})
+	Describe("GetKeepaliveQuery", func() {
+		It("sets the tcp keepalive GUCs", func() {
+			testhelper.SetDBVersion(connectionPool, "6.0.0")
+			result := utils.GetKeepaliveQuery(connectionPool, 300, 30, 3)
+			Expect(result).To(Equal(`SET tcp_keepalives_idle = 300;
+SET tcp_keepalives_interval = 30;
+SET tcp_keepalives_count = 3;
+`))
+		})
+		It("also disables idle_in_transaction_session_timeout when connection version is at least 7", func() {
+			testhelper.SetDBVersion(connectionPool, "7.0.0")
+			result := utils.GetKeepaliveQuery(connectionPool, 300, 30, 3)
+			Expect(result).To(Equal(`SET tcp_keepalives_idle = 300;
+SET tcp_keepalives_interval = 30;
+SET tcp_keepalives_count = 3;
+SET idle_in_transaction_session_timeout = 0;
+`))
+		})
+	})
+	Describe("GetRestoreSessionGUCs", func() {
+		It("returns the setting of each GUC the server has", func() {
+			rows := sqlmock.NewRows([]string{"name", "setting"}).AddRow("DateStyle", "ISO, MDY").AddRow("standard_conforming_strings", "on")
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT name, setting FROM pg_settings WHERE name IN ('DateStyle','IntervalStyle','bytea_output','standard_conforming_strings')")).WillReturnRows(rows)
+			gucs := utils.GetRestoreSessionGUCs(connectionPool)
+			Expect(gucs).To(Equal(map[string]string{"DateStyle": "ISO, MDY", "standard_conforming_strings": "on"}))
+		})
+	})
+	Describe("GetRestoreSessionGUCsQuery", func() {
+		It("sets each GUC in a fixed order", func() {
+			query := utils.GetRestoreSessionGUCsQuery(map[string]string{"standard_conforming_strings": "on", "bytea_output": "hex", "DateStyle": "ISO, MDY", "IntervalStyle": "postgres"})
+			Expect(query).To(Equal(`SET DateStyle TO 'ISO, MDY';
+SET IntervalStyle TO 'postgres';
+SET bytea_output TO 'hex';
+SET standard_conforming_strings TO 'on';
+`))
+		})
+		It("sets only the GUCs that were captured", func() {
+			rows := sqlmock.NewRows([]string{"name", "setting"}).AddRow("DateStyle", "SQL, DMY").AddRow("bytea_output", "escape")
+			mock.ExpectQuery("SELECT name, setting FROM pg_settings").WillReturnRows(rows)
+			query := utils.GetRestoreSessionGUCsQuery(utils.GetRestoreSessionGUCs(connectionPool))
+			Expect(query).To(Equal("SET DateStyle TO 'SQL, DMY';\nSET bytea_output TO 'escape';\n"))
+		})
+		It("does not set a GUC that is not a restore session GUC", func() {
+			Expect(utils.GetRestoreSessionGUCsQuery(map[string]string{"search_path": "public"})).To(Equal(""))
+		})
+	})
 	Describe("UnquoteIdent", func() {
 		It("returns unchanged ident when passed a single char", func() {
 			dbname := `a`